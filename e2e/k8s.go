@@ -54,8 +54,13 @@ func getScaledObject(
 		"",
 		"",
 		"",
+		"",
+		"",
 		1,
 		2,
+		0,
+		"",
+		"",
 	)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+type accessLogEntryKey struct{}
+
+// accessLogEntry is a single line in the access log. Handlers further
+// down the chain fill in the durations they measure, using
+// accessLogEntryFromContext
+type accessLogEntry struct {
+	Time              time.Time `json:"time"`
+	RequestID         string    `json:"requestID,omitempty"`
+	Method            string    `json:"method"`
+	Host              string    `json:"host"`
+	Path              string    `json:"path"`
+	Status            int       `json:"status"`
+	ClientIP          string    `json:"clientIP"`
+	DurationMS        float64   `json:"durationMS"`
+	ColdStartWaitMS   float64   `json:"coldStartWaitMS"`
+	UpstreamLatencyMS float64   `json:"upstreamLatencyMS"`
+	Canary            bool      `json:"canary,omitempty"`
+	Mirrored          bool      `json:"mirrored,omitempty"`
+}
+
+// accessLogEntryFromContext returns the access log entry for the request
+// that ctx belongs to, or nil if the request isn't being logged
+func accessLogEntryFromContext(ctx context.Context) *accessLogEntry {
+	entry, _ := ctx.Value(accessLogEntryKey{}).(*accessLogEntry)
+	return entry
+}
+
+// remoteIP returns the IP address of the client that sent r, as
+// clientIPMiddleware found it, or r's peer's if it didn't see r
+func remoteIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return peerIP(r)
+}
+
+// peerIP returns the IP address of the connection that r came in on
+func peerIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+func durationMS(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// accessLogMiddleware writes an accessLogEntry as a line of JSON to out
+// for a sampleRate fraction of the requests that pass through it
+func accessLogMiddleware(
+	lggr logr.Logger,
+	out io.Writer,
+	sampleRate float64,
+	next http.Handler,
+) http.Handler {
+	lggr = lggr.WithName("accessLogMiddleware")
+	// entries for concurrent requests must not interleave
+	mut := new(sync.Mutex)
+	enc := json.NewEncoder(out)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if sampleRate < 1 && rand.Float64() >= sampleRate {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		host, _ := getHost(r)
+		entry := &accessLogEntry{
+			Time:      start,
+			RequestID: requestIDFromContext(r.Context()),
+			Method:    r.Method,
+			Host:      host,
+			Path:      r.URL.Path,
+			ClientIP:  remoteIP(r),
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(
+			rec,
+			r.WithContext(context.WithValue(r.Context(), accessLogEntryKey{}, entry)),
+		)
+		entry.Status = rec.status
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		entry.DurationMS = durationMS(time.Since(start))
+
+		mut.Lock()
+		defer mut.Unlock()
+		if err := enc.Encode(entry); err != nil {
+			lggr.Error(err, "writing access log entry")
+		}
+	})
+}
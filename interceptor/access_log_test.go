@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessLogMiddleware(t *testing.T) {
+	const host = "TestAccessLogMiddleware.testing"
+	r := require.New(t)
+	out := new(bytes.Buffer)
+	hdl := accessLogMiddleware(
+		logr.Discard(),
+		out,
+		1,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			entry := accessLogEntryFromContext(r.Context())
+			entry.ColdStartWaitMS = durationMS(2 * time.Millisecond)
+			w.WriteHeader(418)
+		}),
+	)
+	req := httptest.NewRequest("PUT", "/testlog", nil)
+	req.Host = host
+	req.RemoteAddr = "10.0.0.1:1234"
+	hdl.ServeHTTP(httptest.NewRecorder(), req)
+
+	entry := accessLogEntry{}
+	r.NoError(json.Unmarshal(out.Bytes(), &entry))
+	r.Equal("PUT", entry.Method)
+	r.Equal(host, entry.Host)
+	r.Equal("/testlog", entry.Path)
+	r.Equal(418, entry.Status)
+	r.Equal("10.0.0.1", entry.ClientIP)
+	r.Equal(float64(2), entry.ColdStartWaitMS)
+}
+
+func TestAccessLogMiddlewareSampling(t *testing.T) {
+	r := require.New(t)
+	out := new(bytes.Buffer)
+	hdl := accessLogMiddleware(
+		logr.Discard(),
+		out,
+		0,
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			// requests that aren't sampled have no entry
+			r.Nil(accessLogEntryFromContext(req.Context()))
+		}),
+	)
+	for i := 0; i < 10; i++ {
+		hdl.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/testlog", nil))
+	}
+	r.Equal(0, out.Len())
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+	kedahttp "github.com/kedacore/http-add-on/pkg/http"
+	"github.com/kedacore/http-add-on/pkg/k8s"
+	"github.com/kedacore/http-add-on/pkg/queue"
+	"github.com/kedacore/http-add-on/pkg/routing"
+)
+
+const adminDebugPathPrefix = "/admin/"
+
+// routeResult is the result of a dry-run of routing a request
+type routeResult struct {
+	Host          string          `json:"host"`
+	Found         bool            `json:"found"`
+	Target        *routing.Target `json:"target,omitempty"`
+	ServiceURL    string          `json:"serviceURL,omitempty"`
+	ReadyReplicas *int32          `json:"readyReplicas,omitempty"`
+	Error         string          `json:"error,omitempty"`
+}
+
+// routingTableVersion is the version of the routing table
+type routingTableVersion struct {
+	Version string    `json:"version"`
+	Updated time.Time `json:"updated"`
+}
+
+// addDebugRoutes adds routes to mux, all requiring token as a bearer
+// token, that help operators debug how the interceptor routes requests:
+//
+//   - /admin/routing_table returns the current routing table, with its
+//     version in the routing.VersionHeader header
+//   - /admin/routing_table_version returns the version of the current
+//     routing table and when it last changed
+//   - /admin/queue returns the current pending request counts per host
+//   - /admin/deployments returns the state of the deployment cache
+//   - /admin/route?host=<host> does a dry-run of routing a request to
+//     host and returns where it would go, or why it would fail
+//
+// If profiling is true, the profiling routes from addProfilingRoutes
+// are added too, behind the same token
+func addDebugRoutes(
+	lggr logr.Logger,
+	mux *http.ServeMux,
+	token string,
+	profiling bool,
+	routingTable *routing.Table,
+	q queue.CountReader,
+	deployCache k8s.DeploymentCache,
+	replicas workloadReplicasFunc,
+) {
+	lggr = lggr.WithName("addDebugRoutes")
+	debugMux := http.NewServeMux()
+	encode := func(w http.ResponseWriter, v interface{}, what string) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(v); err != nil {
+			lggr.Error(err, "encoding response", "what", what)
+		}
+	}
+	debugMux.HandleFunc(
+		adminDebugPathPrefix+"routing_table",
+		func(w http.ResponseWriter, r *http.Request) {
+			routing.SetVersionHeader(w, routingTable)
+			encode(w, routingTable, "routing table")
+		},
+	)
+	debugMux.HandleFunc(
+		adminDebugPathPrefix+"routing_table_version",
+		func(w http.ResponseWriter, r *http.Request) {
+			var ret routingTableVersion
+			ret.Version, ret.Updated = routingTable.Version()
+			encode(w, ret, "routing table version")
+		},
+	)
+	debugMux.HandleFunc(
+		adminDebugPathPrefix+"queue",
+		func(w http.ResponseWriter, r *http.Request) {
+			counts, err := q.Current()
+			if err != nil {
+				lggr.Error(err, "getting queue counts")
+				w.WriteHeader(500)
+				w.Write([]byte("error getting queue counts"))
+				return
+			}
+			encode(w, counts, "queue counts")
+		},
+	)
+	debugMux.HandleFunc(
+		adminDebugPathPrefix+"deployments",
+		func(w http.ResponseWriter, r *http.Request) {
+			encode(w, deployCache, "deployment cache")
+		},
+	)
+	debugMux.HandleFunc(
+		adminDebugPathPrefix+"route",
+		func(w http.ResponseWriter, r *http.Request) {
+			host := r.URL.Query().Get("host")
+			if host == "" {
+				w.WriteHeader(400)
+				w.Write([]byte("missing 'host' query parameter"))
+				return
+			}
+			encode(w, dryRunRoute(r.Context(), host, routingTable, replicas), "route result")
+		},
+	)
+	if profiling {
+		addProfilingRoutes(lggr, debugMux)
+	}
+	lggr.Info("adding admin debug routes", "prefix", adminDebugPathPrefix)
+	mux.Handle(adminDebugPathPrefix, kedahttp.BearerAuth(token, debugMux))
+}
+
+// dryRunRoute figures out how the proxy would route a
+// request to host, without sending anything
+func dryRunRoute(
+	ctx context.Context,
+	host string,
+	routingTable *routing.Table,
+	replicas workloadReplicasFunc,
+) routeResult {
+	ret := routeResult{Host: host}
+	target, err := routingTable.Lookup(host)
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+	ret.Found = true
+	ret.Target = &target
+	svcURL, err := target.ServiceURL()
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+	ret.ServiceURL = svcURL.String()
+	if !target.HasWorkload() {
+		return ret
+	}
+	readyReplicas, err := replicas(ctx, target)
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+	ret.ReadyReplicas = &readyReplicas
+	return ret
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/k8s"
+	"github.com/kedacore/http-add-on/pkg/queue"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+func TestDebugRoutes(t *testing.T) {
+	const (
+		token    = "testtoken"
+		host     = "TestDebugRoutes.testing"
+		deplName = "testdepl"
+	)
+	r := require.New(t)
+	routingTable := routing.NewTable()
+	routingTable.AddTarget(host, routing.NewTarget("testsvc", 8080, deplName, 100))
+	q := queue.NewMemory()
+	r.NoError(q.Resize(host, 2))
+	deployCache := k8s.NewFakeDeploymentCache()
+	deployCache.Set(deplName, appsv1.Deployment{
+		Status: appsv1.DeploymentStatus{ReadyReplicas: 3},
+	})
+	mux := http.NewServeMux()
+	addDebugRoutes(
+		logr.Discard(),
+		mux,
+		token,
+		false,
+		routingTable,
+		q,
+		deployCache,
+		newWorkloadReplicasFunc(deployCache, nil, ""),
+	)
+
+	get := func(path, authz string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		if authz != "" {
+			req.Header.Set("Authorization", authz)
+		}
+		mux.ServeHTTP(res, req)
+		return res
+	}
+
+	// requests without the right token are rejected
+	r.Equal(401, get("/admin/queue", "").Code)
+	r.Equal(401, get("/admin/queue", "Bearer wrongtoken").Code)
+
+	res := get("/admin/queue", "Bearer "+token)
+	r.Equal(200, res.Code)
+	counts := queue.NewCounts()
+	r.NoError(json.NewDecoder(res.Body).Decode(counts))
+	r.Equal(map[string]int{host: 2}, counts.Counts)
+
+	res = get("/admin/route?host="+host, "Bearer "+token)
+	r.Equal(200, res.Code)
+	var result routeResult
+	r.NoError(json.NewDecoder(res.Body).Decode(&result))
+	r.True(result.Found)
+	r.Equal("http://testsvc:8080", result.ServiceURL)
+	r.Equal(int32(3), *result.ReadyReplicas)
+	r.Empty(result.Error)
+
+	res = get("/admin/route?host=unknown.testing", "Bearer "+token)
+	r.Equal(200, res.Code)
+	result = routeResult{}
+	r.NoError(json.NewDecoder(res.Body).Decode(&result))
+	r.False(result.Found)
+	r.NotEmpty(result.Error)
+
+	r.Equal(400, get("/admin/route", "Bearer "+token).Code)
+
+	version, _ := routingTable.Version()
+	res = get("/admin/routing_table", "Bearer "+token)
+	r.Equal(200, res.Code)
+	r.Equal(version, res.Header().Get(routing.VersionHeader))
+
+	res = get("/admin/routing_table_version", "Bearer "+token)
+	r.Equal(200, res.Code)
+	var tableVersion routingTableVersion
+	r.NoError(json.NewDecoder(res.Body).Decode(&tableVersion))
+	r.Equal(version, tableVersion.Version)
+	r.False(tableVersion.Updated.IsZero())
+
+	// profiling is off
+	r.Equal(404, get("/admin/runtime", "Bearer "+token).Code)
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/interceptor/config"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"github.com/pkg/errors"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listerv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// bearerTokenSecretKey is the key of the token in a bearer
+	// token Secret
+	bearerTokenSecretKey = "token"
+	// forwardAuthMaxBodyBytes is the maximum size of the body of a
+	// forward auth service's response that's passed on to the client
+	forwardAuthMaxBodyBytes = 64 << 10
+)
+
+// forwardAuthSkipHeaders are the headers that aren't copied between a
+// request and its forward auth request, or between a forward auth
+// service's response and the response to the client
+var forwardAuthSkipHeaders = map[string]struct{}{
+	"Connection":          {},
+	"Content-Length":      {},
+	"Keep-Alive":          {},
+	"Proxy-Authenticate":  {},
+	"Proxy-Authorization": {},
+	"Te":                  {},
+	"Trailer":             {},
+	"Transfer-Encoding":   {},
+	"Upgrade":             {},
+}
+
+// authDenial is the response to a request that wasn't authenticated
+type authDenial struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// write writes d to w
+func (d *authDenial) write(w http.ResponseWriter) {
+	for name, vals := range d.header {
+		w.Header()[name] = vals
+	}
+	w.WriteHeader(d.status)
+	w.Write(d.body)
+}
+
+// unauthorized returns the denial for a request without valid
+// credentials for host
+func unauthorized(host string) *authDenial {
+	return &authDenial{
+		status: http.StatusUnauthorized,
+		header: http.Header{
+			"Www-Authenticate": {fmt.Sprintf("Bearer realm=%q", host)},
+		},
+		body: []byte("unauthorized"),
+	}
+}
+
+// authUnavailable returns the denial for a request that couldn't be
+// authenticated because of an error on the interceptor's side, like a
+// missing Secret or an unreachable JWKS
+func authUnavailable() *authDenial {
+	return &authDenial{
+		status: http.StatusServiceUnavailable,
+		body:   []byte("authentication is unavailable"),
+	}
+}
+
+// authenticator authenticates requests with the AuthPolicy of the
+// routing table target that they go to. It caches the bearer token
+// Secrets in a namespace with a shared informer. Call start to run the
+// informer.
+//
+// A nil *authenticator is valid, and rejects every request to a target
+// that has an AuthPolicy, so that requests aren't let through by an
+// interceptor that doesn't have auth enabled
+type authenticator struct {
+	lggr     logr.Logger
+	factory  informers.SharedInformerFactory
+	informer cache.SharedIndexInformer
+	secrets  listerv1.SecretNamespaceLister
+	client   *http.Client
+	jwks     *jwksCache
+	now      func() time.Time
+}
+
+// newAuthenticator creates a new authenticator for the bearer token
+// Secrets in namespace ns
+func newAuthenticator(
+	lggr logr.Logger,
+	cl kubernetes.Interface,
+	ns string,
+	cfg config.Auth,
+) *authenticator {
+	factory := informers.NewSharedInformerFactoryWithOptions(
+		cl,
+		time.Duration(cfg.SecretsResyncDurationMS)*time.Millisecond,
+		informers.WithNamespace(ns),
+	)
+	secretInformer := factory.Core().V1().Secrets()
+	client := &http.Client{
+		Timeout: cfg.Timeout,
+		// a forward auth service's redirects, like to a login
+		// page, go back to the client
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return &authenticator{
+		lggr:     lggr.WithName("authenticator"),
+		factory:  factory,
+		informer: secretInformer.Informer(),
+		secrets:  secretInformer.Lister().Secrets(ns),
+		client:   client,
+		jwks:     newJWKSCache(client, cfg.JWKSRefreshInterval),
+		now:      time.Now,
+	}
+}
+
+// start runs the informer, waits for its cache to sync, then
+// blocks until ctx is done
+func (a *authenticator) start(ctx context.Context) error {
+	a.factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), a.informer.HasSynced) {
+		return errors.New("bearer token Secrets cache never synced")
+	}
+	<-ctx.Done()
+	return errors.Wrap(ctx.Err(), "context is done")
+}
+
+// hasSynced returns true once the informer's cache has synced
+func (a *authenticator) hasSynced() bool {
+	return a.informer.HasSynced()
+}
+
+// authenticate checks r, a request to host, against policy. It returns
+// nil if r may go through, and the response to send otherwise.
+// Requests that a forward auth service accepts get the headers that
+// policy asks for from the service's response
+func (a *authenticator) authenticate(
+	r *http.Request,
+	host string,
+	policy *routing.AuthPolicy,
+) *authDenial {
+	if a == nil {
+		return authUnavailable()
+	}
+	lggr := a.lggr.WithValues(
+		"host",
+		host,
+		"type",
+		policy.Type,
+		"requestID",
+		requestIDFromContext(r.Context()),
+	)
+	switch policy.Type {
+	case routing.AuthTypeBearerToken:
+		token, ok := bearerToken(r)
+		if !ok {
+			return unauthorized(host)
+		}
+		secret, err := a.secrets.Get(policy.SecretName)
+		if err != nil {
+			lggr.Error(err, "getting bearer token Secret", "secret", policy.SecretName)
+			return authUnavailable()
+		}
+		want := strings.TrimSpace(string(secret.Data[bearerTokenSecretKey]))
+		if want == "" {
+			lggr.Error(
+				errors.New("bearer token Secret has no token"),
+				"invalid bearer token Secret",
+				"secret",
+				policy.SecretName,
+			)
+			return authUnavailable()
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(want)) != 1 {
+			return unauthorized(host)
+		}
+		return nil
+	case routing.AuthTypeJWT:
+		token, ok := bearerToken(r)
+		if !ok {
+			return unauthorized(host)
+		}
+		err := verifyJWT(
+			a.jwks,
+			token,
+			policy.JWKSURL,
+			policy.Issuer,
+			policy.Audience,
+			a.now(),
+		)
+		if err != nil {
+			lggr.V(1).Info("rejecting token", "error", err.Error())
+			return unauthorized(host)
+		}
+		return nil
+	case routing.AuthTypeForwardAuth:
+		return a.forwardAuth(lggr, r, host, policy)
+	}
+	lggr.Error(errors.New("unknown auth type"), "rejecting request")
+	return authUnavailable()
+}
+
+// forwardAuth asks the forward auth service at policy's URL whether to
+// accept r. The service gets r's headers, and its method, host and URI
+// in X-Forwarded-* headers
+func (a *authenticator) forwardAuth(
+	lggr logr.Logger,
+	r *http.Request,
+	host string,
+	policy *routing.AuthPolicy,
+) *authDenial {
+	authReq, err := http.NewRequestWithContext(
+		r.Context(),
+		http.MethodGet,
+		policy.URL,
+		nil,
+	)
+	if err != nil {
+		lggr.Error(err, "creating forward auth request")
+		return authUnavailable()
+	}
+	copyAuthHeaders(authReq.Header, r.Header)
+	authReq.Header.Set("X-Forwarded-Method", r.Method)
+	authReq.Header.Set("X-Forwarded-Host", host)
+	authReq.Header.Set("X-Forwarded-Uri", r.URL.RequestURI())
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	authReq.Header.Set("X-Forwarded-Proto", proto)
+	res, err := a.client.Do(authReq)
+	if err != nil {
+		lggr.Error(err, "calling forward auth service", "url", policy.URL)
+		return authUnavailable()
+	}
+	defer res.Body.Close()
+
+	// the headers that the service passes to the backend can't come
+	// from the client, or it could claim to be any user
+	for _, name := range policy.ResponseHeaders {
+		r.Header.Del(name)
+	}
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		for _, name := range policy.ResponseHeaders {
+			for _, val := range res.Header.Values(name) {
+				r.Header.Add(name, val)
+			}
+		}
+		return nil
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, forwardAuthMaxBodyBytes))
+	if err != nil {
+		lggr.Error(err, "reading forward auth response", "url", policy.URL)
+		return authUnavailable()
+	}
+	ret := &authDenial{
+		status: res.StatusCode,
+		header: http.Header{},
+		body:   body,
+	}
+	copyAuthHeaders(ret.header, res.Header)
+	return ret
+}
+
+// copyAuthHeaders copies the headers in src to dst, except for the
+// ones in forwardAuthSkipHeaders
+func copyAuthHeaders(dst, src http.Header) {
+	for name, vals := range src {
+		if _, skip := forwardAuthSkipHeaders[http.CanonicalHeaderKey(name)]; skip {
+			continue
+		}
+		dst[name] = append([]string(nil), vals...)
+	}
+}
+
+// bearerToken returns the bearer token in r's Authorization header,
+// and true, if it has one
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "bearer "
+	hdr := r.Header.Get("Authorization")
+	if len(hdr) <= len(prefix) || !strings.EqualFold(hdr[:len(prefix)], prefix) {
+		return "", false
+	}
+	token := strings.TrimSpace(hdr[len(prefix):])
+	return token, token != ""
+}
+
+// authMiddleware authenticates the requests to every host whose
+// routing table target has an AuthPolicy, and only passes the ones
+// that are authenticated to next. The others get a 401, or whatever a
+// forward auth service responded with. Requests to other hosts go
+// straight to next
+func authMiddleware(
+	lggr logr.Logger,
+	auth *authenticator,
+	routingTable routing.TableReader,
+	next http.Handler,
+) http.Handler {
+	lggr = lggr.WithName("authMiddleware")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, err := getHost(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		target, err := lookupTarget(r.Context(), routingTable, host)
+		if err != nil || target.Auth == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if auth == nil {
+			lggr.Error(
+				errors.New("auth isn't enabled in the interceptor"),
+				"rejecting request to a host that requires auth",
+				"host",
+				host,
+			)
+		}
+		if denial := auth.authenticate(r, host, target.Auth); denial != nil {
+			lggr.V(1).Info(
+				"request not authenticated",
+				"host",
+				host,
+				"status",
+				denial.status,
+				"requestID",
+				requestIDFromContext(r.Context()),
+			)
+			denial.write(w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
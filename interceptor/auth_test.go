@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/interceptor/config"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestAuthenticator(t *testing.T, secrets ...*corev1.Secret) *authenticator {
+	t.Helper()
+	ctx, done := context.WithCancel(context.Background())
+	t.Cleanup(done)
+	cl := k8sfake.NewSimpleClientset()
+	for _, secret := range secrets {
+		_, err := cl.CoreV1().Secrets(secret.Namespace).Create(ctx, secret, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+	auth := newAuthenticator(logr.Discard(), cl, "testns", config.Auth{
+		SecretsResyncDurationMS: 60000,
+		JWKSRefreshInterval:     time.Minute,
+		Timeout:                 time.Second,
+	})
+	go auth.start(ctx)
+	require.Eventually(t, auth.hasSynced, time.Second, 10*time.Millisecond)
+	return auth
+}
+
+// authTestHandler runs authMiddleware in front of a handler that
+// records the requests it gets
+func authTestHandler(
+	t *testing.T,
+	auth *authenticator,
+	host string,
+	policy *routing.AuthPolicy,
+) (http.Handler, *[]*http.Request) {
+	t.Helper()
+	routingTable := routing.NewTable()
+	require.NoError(t, routingTable.AddTarget(host, routing.Target{
+		Service:    "testsvc",
+		Port:       8080,
+		Deployment: "testdepl",
+		Auth:       policy,
+	}))
+	reqs := &[]*http.Request{}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*reqs = append(*reqs, r)
+		w.WriteHeader(200)
+	})
+	return authMiddleware(logr.Discard(), auth, routingTable, next), reqs
+}
+
+func TestAuthMiddlewareBearerToken(t *testing.T) {
+	const host = "TestAuthMiddlewareBearerToken.testing"
+	r := require.New(t)
+	auth := newTestAuthenticator(t, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "testns", Name: "testtoken"},
+		Data:       map[string][]byte{bearerTokenSecretKey: []byte("s3cret\n")},
+	})
+	hdl, reqs := authTestHandler(t, auth, host, &routing.AuthPolicy{
+		Type:       routing.AuthTypeBearerToken,
+		SecretName: "testtoken",
+	})
+
+	for _, authz := range []string{"", "Bearer wrong", "Basic s3cret", "Bearer s3cretx"} {
+		res, req, err := reqAndRes("/")
+		r.NoError(err)
+		req.Host = host
+		if authz != "" {
+			req.Header.Set("Authorization", authz)
+		}
+		hdl.ServeHTTP(res, req)
+		r.Equal(401, res.Code, "Authorization: %s", authz)
+		r.Contains(res.Header().Get("WWW-Authenticate"), "Bearer")
+	}
+	r.Empty(*reqs)
+
+	res, req, err := reqAndRes("/")
+	r.NoError(err)
+	req.Host = host
+	req.Header.Set("Authorization", "bearer s3cret")
+	hdl.ServeHTTP(res, req)
+	r.Equal(200, res.Code)
+	r.Len(*reqs, 1)
+
+	// a missing Secret is an error on the interceptor's side
+	hdl, reqs = authTestHandler(t, auth, host, &routing.AuthPolicy{
+		Type:       routing.AuthTypeBearerToken,
+		SecretName: "missing",
+	})
+	res, req, err = reqAndRes("/")
+	r.NoError(err)
+	req.Host = host
+	req.Header.Set("Authorization", "Bearer s3cret")
+	hdl.ServeHTTP(res, req)
+	r.Equal(503, res.Code)
+	r.Empty(*reqs)
+}
+
+func TestAuthMiddlewareJWT(t *testing.T) {
+	const host = "TestAuthMiddlewareJWT.testing"
+	r := require.New(t)
+	jwks := newTestJWKS(t)
+	hdl, reqs := authTestHandler(t, newTestAuthenticator(t), host, &routing.AuthPolicy{
+		Type:     routing.AuthTypeJWT,
+		JWKSURL:  jwks.srv.URL,
+		Audience: "testaud",
+	})
+
+	res, req, err := reqAndRes("/")
+	r.NoError(err)
+	req.Host = host
+	req.Header.Set("Authorization", "Bearer "+jwks.sign(t, "RS256", "rsakey", map[string]interface{}{
+		"aud": "otheraud",
+	}))
+	hdl.ServeHTTP(res, req)
+	r.Equal(401, res.Code)
+	r.Empty(*reqs)
+
+	res, req, err = reqAndRes("/")
+	r.NoError(err)
+	req.Host = host
+	req.Header.Set("Authorization", "Bearer "+jwks.sign(t, "ES256", "eckey", map[string]interface{}{
+		"aud": "testaud",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}))
+	hdl.ServeHTTP(res, req)
+	r.Equal(200, res.Code)
+	r.Len(*reqs, 1)
+}
+
+func TestAuthMiddlewareForwardAuth(t *testing.T) {
+	const host = "TestAuthMiddlewareForwardAuth.testing"
+	r := require.New(t)
+	authSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Cookie") != "session=valid" {
+			w.Header().Set("Location", "https://login.testing")
+			w.WriteHeader(302)
+			w.Write([]byte("log in first"))
+			return
+		}
+		w.Header().Set("X-Auth-User", "testuser")
+		w.Header().Set("X-Auth-Method", req.Header.Get("X-Forwarded-Method"))
+		w.Header().Set("X-Auth-Uri", req.Header.Get("X-Forwarded-Uri"))
+		w.WriteHeader(200)
+	}))
+	defer authSrv.Close()
+	hdl, reqs := authTestHandler(t, newTestAuthenticator(t), host, &routing.AuthPolicy{
+		Type:            routing.AuthTypeForwardAuth,
+		URL:             authSrv.URL,
+		ResponseHeaders: []string{"X-Auth-User", "X-Auth-Uri"},
+	})
+
+	// the service's response goes back to the client, and the
+	// redirect isn't followed
+	res, req, err := reqAndRes("/testpath?q=1")
+	r.NoError(err)
+	req.Host = host
+	hdl.ServeHTTP(res, req)
+	r.Equal(302, res.Code)
+	r.Equal("https://login.testing", res.Header().Get("Location"))
+	r.Equal("log in first", res.Body.String())
+	r.Empty(*reqs)
+
+	res, req, err = reqAndRes("/testpath?q=1")
+	r.NoError(err)
+	req.Host = host
+	req.Header.Set("Cookie", "session=valid")
+	// the client can't set the headers that the service sets
+	req.Header.Set("X-Auth-User", "admin")
+	hdl.ServeHTTP(res, req)
+	r.Equal(200, res.Code)
+	r.Len(*reqs, 1)
+	fwdReq := (*reqs)[0]
+	r.Equal([]string{"testuser"}, fwdReq.Header.Values("X-Auth-User"))
+	r.Equal("/testpath?q=1", fwdReq.Header.Get("X-Auth-Uri"))
+	// only the headers in the policy are copied
+	r.Empty(fwdReq.Header.Get("X-Auth-Method"))
+
+	// an unreachable service rejects every request
+	authSrv.Close()
+	res, req, err = reqAndRes("/")
+	r.NoError(err)
+	req.Host = host
+	req.Header.Set("Cookie", "session=valid")
+	hdl.ServeHTTP(res, req)
+	r.Equal(503, res.Code)
+	r.Len(*reqs, 1)
+}
+
+func TestAuthMiddlewareDisabled(t *testing.T) {
+	const host = "TestAuthMiddlewareDisabled.testing"
+	r := require.New(t)
+	hdl, reqs := authTestHandler(t, nil, host, &routing.AuthPolicy{
+		Type:       routing.AuthTypeBearerToken,
+		SecretName: "testtoken",
+	})
+
+	// without auth enabled, hosts that require it are unavailable,
+	// rather than open to everyone
+	res, req, err := reqAndRes("/")
+	r.NoError(err)
+	req.Host = host
+	req.Header.Set("Authorization", "Bearer s3cret")
+	hdl.ServeHTTP(res, req)
+	r.Equal(503, res.Code)
+	r.Empty(*reqs)
+
+	// hosts without an auth policy aren't affected
+	hdl, reqs = authTestHandler(t, nil, host, nil)
+	res, req, err = reqAndRes("/")
+	r.NoError(err)
+	req.Host = host
+	hdl.ServeHTTP(res, req)
+	r.Equal(200, res.Code)
+	r.Len(*reqs, 1)
+}
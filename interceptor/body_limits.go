@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+var errResponseBodyTooLarge = errors.New("response body too large")
+
+// bodyLimits are the maximum sizes of the request and response bodies
+// for a single proxied request. 0 means no limit
+type bodyLimits struct {
+	maxRequestBytes  int64
+	maxResponseBytes int64
+}
+
+// limitedReadCloser is an io.ReadCloser that streams at most limit bytes
+// from rc. If rc has more than that, Read returns err and the reader
+// remembers that the limit was exceeded
+type limitedReadCloser struct {
+	rc        io.ReadCloser
+	remaining int64
+	err       error
+	exceeded  bool
+}
+
+func newLimitedReadCloser(rc io.ReadCloser, limit int64, err error) *limitedReadCloser {
+	return &limitedReadCloser{rc: rc, remaining: limit, err: err}
+}
+
+func (l *limitedReadCloser) Read(p []byte) (int, error) {
+	if l.exceeded {
+		return 0, l.err
+	}
+	// read one byte more than what's remaining, so that we
+	// can tell whether the body goes past the limit
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.rc.Read(p)
+	if int64(n) <= l.remaining {
+		l.remaining -= int64(n)
+		return n, err
+	}
+	n = int(l.remaining)
+	l.remaining = 0
+	l.exceeded = true
+	return n, l.err
+}
+
+func (l *limitedReadCloser) Close() error {
+	return l.rc.Close()
+}
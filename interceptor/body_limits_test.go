@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitedReadCloser(t *testing.T) {
+	r := require.New(t)
+
+	// bodies at the limit are read in full
+	lrc := newLimitedReadCloser(ioutil.NopCloser(strings.NewReader("12345")), 5, errResponseBodyTooLarge)
+	b, err := ioutil.ReadAll(lrc)
+	r.NoError(err)
+	r.Equal("12345", string(b))
+	r.False(lrc.exceeded)
+
+	// bodies past the limit are cut off
+	lrc = newLimitedReadCloser(ioutil.NopCloser(strings.NewReader("123456")), 5, errResponseBodyTooLarge)
+	b, err = ioutil.ReadAll(lrc)
+	r.ErrorIs(err, errResponseBodyTooLarge)
+	r.Equal("12345", string(b))
+	r.True(lrc.exceeded)
+}
+
+func TestForwarderBodyLimits(t *testing.T) {
+	r := require.New(t)
+	const respBody = "a response body that is too long"
+	testServer := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ioutil.ReadAll(r.Body)
+			w.Write([]byte(respBody))
+		}),
+	)
+	defer testServer.Close()
+	forwardURL, err := url.Parse(testServer.URL)
+	r.NoError(err)
+
+	forward := func(body string, chunked bool, limits bodyLimits) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/testlimits", strings.NewReader(body))
+		r.NoError(err)
+		if chunked {
+			// hide the length so the body has to be streamed
+			req.ContentLength = -1
+		}
+		res := httptest.NewRecorder()
+		forwardRequest(res, req, http.DefaultTransport, forwardURL, limits, nil, nil, nil, nil, nil)
+		return res
+	}
+
+	// requests under the limit go through
+	res := forward("body", false, bodyLimits{maxRequestBytes: 10})
+	r.Equal(200, res.Code)
+	r.Equal(respBody, res.Body.String())
+
+	// requests with a known length over the limit are
+	// rejected without contacting the backend
+	res = forward("a body that is too long", false, bodyLimits{maxRequestBytes: 10})
+	r.Equal(413, res.Code)
+
+	// streamed requests are rejected when they go past the limit
+	res = forward("a body that is too long", true, bodyLimits{maxRequestBytes: 10})
+	r.Equal(413, res.Code)
+
+	// responses with a known length over the limit are rejected
+	res = forward("body", false, bodyLimits{maxResponseBytes: 10})
+	r.Equal(502, res.Code)
+	r.Contains(res.Body.String(), errResponseBodyTooLarge.Error())
+}
@@ -0,0 +1,48 @@
+package main
+
+import "sync"
+
+// proxyBufferSize is the size of the buffers that response bodies are
+// copied through. It's the size that httputil.ReverseProxy uses when
+// it has no BufferPool
+const proxyBufferSize = 32 * 1024
+
+// proxyBuffers are the buffers that all the requests that the
+// interceptor forwards share
+var proxyBuffers = newBufferPool(proxyBufferSize)
+
+// bufferPool is an httputil.BufferPool backed by a sync.Pool, so that
+// forwarding a response doesn't allocate a new buffer every time
+type bufferPool struct {
+	size int
+	pool *sync.Pool
+}
+
+func newBufferPool(size int) *bufferPool {
+	return &bufferPool{
+		size: size,
+		pool: &sync.Pool{
+			New: func() interface{} {
+				// a pointer, so that putting the buffer
+				// back doesn't allocate
+				buf := make([]byte, size)
+				return &buf
+			},
+		},
+	}
+}
+
+// Get implements httputil.BufferPool
+func (b *bufferPool) Get() []byte {
+	return *b.pool.Get().(*[]byte)
+}
+
+// Put implements httputil.BufferPool. Buffers that aren't the pool's
+// size aren't kept
+func (b *bufferPool) Put(buf []byte) {
+	if cap(buf) != b.size {
+		return
+	}
+	buf = buf[:b.size]
+	b.pool.Put(&buf)
+}
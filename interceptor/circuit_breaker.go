@@ -0,0 +1,224 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/interceptor/config"
+)
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// circuitBreaker tracks the error rate of requests to a single host.
+//
+// It starts closed, letting all requests through. When the error rate
+// within a window crosses a threshold, it opens and rejects all requests
+// until its open duration has passed. Then it becomes half-open and lets
+// a limited number of trial requests through. If they all succeed, it
+// closes again, otherwise it re-opens.
+type circuitBreaker struct {
+	mut              *sync.Mutex
+	cfg              config.CircuitBreaker
+	state            circuitState
+	windowStart      time.Time
+	requests         int
+	failures         int
+	openedAt         time.Time
+	halfOpenInFlight int
+	halfOpenSuccess  int
+}
+
+func newCircuitBreaker(cfg config.CircuitBreaker) *circuitBreaker {
+	return &circuitBreaker{
+		mut: new(sync.Mutex),
+		cfg: cfg,
+	}
+}
+
+// allow returns true if a request may be sent at time now. If it
+// returns true, the caller must call record with the request's outcome.
+// Otherwise it also returns how long the caller should wait before
+// trying again.
+func (c *circuitBreaker) allow(now time.Time) (bool, time.Duration) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if c.state == circuitOpen {
+		reopenAt := c.openedAt.Add(c.cfg.OpenDuration)
+		if now.Before(reopenAt) {
+			return false, reopenAt.Sub(now)
+		}
+		c.state = circuitHalfOpen
+		c.halfOpenInFlight = 0
+		c.halfOpenSuccess = 0
+	}
+	if c.state == circuitHalfOpen {
+		if c.halfOpenInFlight+c.halfOpenSuccess >= c.cfg.HalfOpenRequests {
+			// all the trial requests are in flight already, so
+			// tell the caller to come back soon
+			return false, time.Second
+		}
+		c.halfOpenInFlight++
+	}
+	return true, 0
+}
+
+// record records the outcome of a request that allow let through
+func (c *circuitBreaker) record(now time.Time, success bool) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	switch c.state {
+	case circuitClosed:
+		if now.Sub(c.windowStart) > c.cfg.Window {
+			c.windowStart = now
+			c.requests = 0
+			c.failures = 0
+		}
+		c.requests++
+		if !success {
+			c.failures++
+		}
+		if c.requests >= c.cfg.MinRequests &&
+			c.failures*100 >= c.cfg.ErrorThresholdPercent*c.requests {
+			c.open(now)
+		}
+	case circuitHalfOpen:
+		c.halfOpenInFlight--
+		if !success {
+			c.open(now)
+			return
+		}
+		c.halfOpenSuccess++
+		if c.halfOpenSuccess >= c.cfg.HalfOpenRequests {
+			c.state = circuitClosed
+			c.windowStart = now
+			c.requests = 0
+			c.failures = 0
+		}
+	case circuitOpen:
+		// the request was let through before the circuit
+		// breaker opened, so its outcome doesn't matter
+	}
+}
+
+func (c *circuitBreaker) open(now time.Time) {
+	c.state = circuitOpen
+	c.openedAt = now
+}
+
+// circuitBreakers holds a circuitBreaker for each host
+type circuitBreakers struct {
+	mut      *sync.Mutex
+	cfg      config.CircuitBreaker
+	breakers map[string]*circuitBreaker
+}
+
+func newCircuitBreakers(cfg config.CircuitBreaker) *circuitBreakers {
+	return &circuitBreakers{
+		mut:      new(sync.Mutex),
+		cfg:      cfg,
+		breakers: map[string]*circuitBreaker{},
+	}
+}
+
+// setConfig makes every circuit breaker in c, and the ones that c
+// creates from now on, use cfg. Each circuit breaker keeps its state
+func (c *circuitBreakers) setConfig(cfg config.CircuitBreaker) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.cfg = cfg
+	for _, breaker := range c.breakers {
+		breaker.mut.Lock()
+		breaker.cfg = cfg
+		breaker.mut.Unlock()
+	}
+}
+
+func (c *circuitBreakers) forHost(host string) *circuitBreaker {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	breaker, ok := c.breakers[host]
+	if !ok {
+		breaker = newCircuitBreaker(c.cfg)
+		c.breakers[host] = breaker
+	}
+	return breaker
+}
+
+// statusRecorder is an http.ResponseWriter that
+// remembers the status code that was written
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher, so that streamed responses like
+// server-sent events aren't held back by the recorder
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// circuitBreakerMiddleware fast-fails requests with a 503 and a
+// Retry-After header while the circuit breaker for the request's host
+// is open. Otherwise, it calls next and records every 5xx response as
+// a failure for that host
+func circuitBreakerMiddleware(
+	lggr logr.Logger,
+	breakers *circuitBreakers,
+	next http.Handler,
+) http.Handler {
+	lggr = lggr.WithName("circuitBreakerMiddleware")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, err := getHost(r)
+		if err != nil {
+			// let the next handler deal with requests
+			// that have no host
+			next.ServeHTTP(w, r)
+			return
+		}
+		breaker := breakers.forHost(host)
+		allowed, retryAfter := breaker.allow(time.Now())
+		if !allowed {
+			lggr.Info(
+				"circuit breaker open, rejecting request",
+				"host",
+				host,
+			)
+			w.Header().Set(
+				"Retry-After",
+				strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))),
+			)
+			w.WriteHeader(503)
+			w.Write([]byte("backend is unavailable, try again later"))
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		breaker.record(time.Now(), rec.status < 500)
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/interceptor/config"
+	"github.com/stretchr/testify/require"
+)
+
+func testCircuitBreakerConfig() config.CircuitBreaker {
+	return config.CircuitBreaker{
+		Enabled:               true,
+		ErrorThresholdPercent: 50,
+		MinRequests:           4,
+		Window:                time.Minute,
+		OpenDuration:          10 * time.Second,
+		HalfOpenRequests:      1,
+	}
+}
+
+func TestCircuitBreakerStates(t *testing.T) {
+	r := require.New(t)
+	breaker := newCircuitBreaker(testCircuitBreakerConfig())
+	now := time.Now()
+
+	// 2 failures out of 4 requests crosses the 50% threshold
+	for _, success := range []bool{true, false, true, false} {
+		allowed, _ := breaker.allow(now)
+		r.True(allowed)
+		breaker.record(now, success)
+	}
+	allowed, retryAfter := breaker.allow(now.Add(time.Second))
+	r.False(allowed)
+	r.Equal(9*time.Second, retryAfter)
+
+	// after the open duration, a single trial request is let through
+	now = now.Add(11 * time.Second)
+	allowed, _ = breaker.allow(now)
+	r.True(allowed)
+	allowed, _ = breaker.allow(now)
+	r.False(allowed)
+
+	// a failed trial request re-opens the breaker
+	breaker.record(now, false)
+	allowed, _ = breaker.allow(now)
+	r.False(allowed)
+
+	// a successful trial request closes it
+	now = now.Add(11 * time.Second)
+	allowed, _ = breaker.allow(now)
+	r.True(allowed)
+	breaker.record(now, true)
+	for i := 0; i < 3; i++ {
+		allowed, _ = breaker.allow(now)
+		r.True(allowed)
+	}
+}
+
+func TestCircuitBreakerMiddleware(t *testing.T) {
+	const host = "TestCircuitBreakerMiddleware.testing"
+	r := require.New(t)
+	cfg := testCircuitBreakerConfig()
+	numCalls := 0
+	hdl := circuitBreakerMiddleware(
+		logr.Discard(),
+		newCircuitBreakers(cfg),
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			numCalls++
+			w.WriteHeader(502)
+		}),
+	)
+
+	for i := 0; i < cfg.MinRequests; i++ {
+		res, req, err := reqAndRes("/testbreaker")
+		r.NoError(err)
+		req.Host = host
+		hdl.ServeHTTP(res, req)
+		r.Equal(502, res.Code)
+	}
+	r.Equal(cfg.MinRequests, numCalls)
+
+	// the breaker is now open, so requests should
+	// fail fast without reaching the backend
+	res, req, err := reqAndRes("/testbreaker")
+	r.NoError(err)
+	req.Host = host
+	hdl.ServeHTTP(res, req)
+	r.Equal(503, res.Code)
+	r.Equal("10", res.Header().Get("Retry-After"))
+	r.Equal(cfg.MinRequests, numCalls)
+
+	// other hosts have their own breaker
+	res = httptest.NewRecorder()
+	req.Host = "other.testing"
+	hdl.ServeHTTP(res, req)
+	r.Equal(502, res.Code)
+}
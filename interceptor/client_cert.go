@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	kedatls "github.com/kedacore/http-add-on/pkg/tls"
+)
+
+// clientCertMiddleware rejects the requests to every host whose
+// routing table target has a ClientCertificatePolicy, unless they came
+// over a TLS connection whose client presented a verified certificate
+// with one of the allowed SANs. Those get a 403. Requests to other
+// hosts go straight to next.
+//
+// The certificate was verified against the proxy server's client CA
+// bundle during the TLS handshake, so requests only have one if the
+// proxy server verifies client certificates
+func clientCertMiddleware(
+	lggr logr.Logger,
+	routingTable routing.TableReader,
+	next http.Handler,
+) http.Handler {
+	lggr = lggr.WithName("clientCertMiddleware")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, err := getHost(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		target, err := lookupTarget(r.Context(), routingTable, host)
+		if err != nil || target.ClientCertificate == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			lggr.V(1).Info(
+				"request without a client certificate",
+				"host",
+				host,
+				"requestID",
+				requestIDFromContext(r.Context()),
+			)
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("client certificate required"))
+			return
+		}
+		verify := kedatls.VerifyClientSANs(target.ClientCertificate.AllowedSANs)
+		if err := verify(*r.TLS); err != nil {
+			lggr.V(1).Info(
+				"client certificate not allowed",
+				"host",
+				host,
+				"error",
+				err.Error(),
+				"requestID",
+				requestIDFromContext(r.Context()),
+			)
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("client certificate not allowed"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
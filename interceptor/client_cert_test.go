@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientCertMiddleware(t *testing.T) {
+	const (
+		host      = "TestClientCertMiddleware.testing"
+		otherHost = "other.TestClientCertMiddleware.testing"
+	)
+	r := require.New(t)
+	routingTable := routing.NewTable()
+	r.NoError(routingTable.AddTarget(host, routing.Target{
+		Service:    "testsvc",
+		Port:       8080,
+		Deployment: "testdepl",
+		ClientCertificate: &routing.ClientCertificatePolicy{
+			AllowedSANs: []string{"spiffe://cluster.local/ns/testns/sa/frontend"},
+		},
+	}))
+	r.NoError(routingTable.AddTarget(otherHost, routing.Target{
+		Service:    "othersvc",
+		Port:       8080,
+		Deployment: "otherdepl",
+	}))
+	reqs := 0
+	hdl := clientCertMiddleware(
+		logr.Discard(),
+		routingTable,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reqs++
+			w.WriteHeader(200)
+		}),
+	)
+	// the TLS handshake already verified these certificates, so
+	// only their SANs matter
+	connState := func(uri string) *tls.ConnectionState {
+		parsed, err := url.Parse(uri)
+		r.NoError(err)
+		return &tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{
+				{URIs: []*url.URL{parsed}},
+			}},
+		}
+	}
+
+	for _, state := range []*tls.ConnectionState{
+		nil,
+		{},
+		connState("spiffe://cluster.local/ns/testns/sa/backend"),
+	} {
+		res, req, err := reqAndRes("/")
+		r.NoError(err)
+		req.Host = host
+		req.TLS = state
+		hdl.ServeHTTP(res, req)
+		r.Equal(http.StatusForbidden, res.Code)
+	}
+	r.Equal(0, reqs)
+
+	res, req, err := reqAndRes("/")
+	r.NoError(err)
+	req.Host = host
+	req.TLS = connState("spiffe://cluster.local/ns/testns/sa/frontend")
+	hdl.ServeHTTP(res, req)
+	r.Equal(200, res.Code)
+	r.Equal(1, reqs)
+
+	// hosts without a policy take any client
+	res, req, err = reqAndRes("/")
+	r.NoError(err)
+	req.Host = otherHost
+	hdl.ServeHTTP(res, req)
+	r.Equal(200, res.Code)
+	r.Equal(2, reqs)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"time"
+)
+
+const (
+	// cloudEventsSpecVersion is the version of the CloudEvents spec
+	// that the interceptor's events follow
+	cloudEventsSpecVersion = "1.0"
+	// cloudEventsContentType is the content type of CloudEvents in
+	// structured mode, encoded as JSON
+	cloudEventsContentType = "application/cloudevents+json"
+)
+
+// cloudEvent is a CloudEvent in structured mode, with its attributes
+// and its data in one JSON object
+type cloudEvent struct {
+	SpecVersion     string      `json:"specversion"`
+	ID              string      `json:"id"`
+	Source          string      `json:"source"`
+	Type            string      `json:"type"`
+	Subject         string      `json:"subject,omitempty"`
+	Time            time.Time   `json:"time"`
+	DataContentType string      `json:"datacontenttype"`
+	Data            interface{} `json:"data"`
+}
+
+// newCloudEvent creates a CloudEvent of the given type, about subject,
+// that carries data encoded as JSON
+func newCloudEvent(source, typ, subject string, data interface{}) cloudEvent {
+	return cloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		ID:              newRequestID(),
+		Source:          source,
+		Type:            typ,
+		Subject:         subject,
+		Time:            time.Now().UTC(),
+		DataContentType: "application/json",
+		Data:            data,
+	}
+}
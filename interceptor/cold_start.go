@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/interceptor/config"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// coldStartBucketsMS are the upper bounds, in milliseconds, of the
+// buckets of the cold start histograms
+var coldStartBucketsMS = []float64{
+	100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000,
+}
+
+// coldStartHistogram is the distribution of a host's cold start
+// durations. Buckets are cumulative, like Prometheus': each one counts
+// the cold starts that took at most LeMS milliseconds
+type coldStartHistogram struct {
+	Buckets []coldStartBucket `json:"buckets"`
+	Count   int               `json:"count"`
+	SumMS   float64           `json:"sumMS"`
+	// SLOBreaches is the number of cold starts
+	// that took longer than the SLO
+	SLOBreaches int `json:"sloBreaches"`
+}
+
+type coldStartBucket struct {
+	LeMS  float64 `json:"leMS"`
+	Count int     `json:"count"`
+}
+
+func newColdStartHistogram() *coldStartHistogram {
+	ret := &coldStartHistogram{
+		Buckets: make([]coldStartBucket, len(coldStartBucketsMS)),
+	}
+	for i, le := range coldStartBucketsMS {
+		ret.Buckets[i].LeMS = le
+	}
+	return ret
+}
+
+func (h *coldStartHistogram) observe(ms float64) {
+	h.Count++
+	h.SumMS += ms
+	for i := range h.Buckets {
+		if ms <= h.Buckets[i].LeMS {
+			h.Buckets[i].Count++
+		}
+	}
+}
+
+// coldStartEvents records that a cold start of host, which routes
+// to target, took d, which is longer than slo
+type coldStartEvents interface {
+	sloBreached(
+		ctx context.Context,
+		host string,
+		target routing.Target,
+		d,
+		slo time.Duration,
+	) error
+}
+
+// coldStartTracker keeps a histogram of each host's cold start
+// durations, and sends a coldStartEvents when one breaches the SLO
+type coldStartTracker struct {
+	lggr     logr.Logger
+	cfg      config.ColdStart
+	replicas workloadReplicasFunc
+	events   coldStartEvents
+	// scaling gets told when hosts start scaling from zero,
+	// and when they stop. nil means it isn't
+	scaling   *scalingEvents
+	mut       *sync.Mutex
+	hists     map[string]*coldStartHistogram
+	lastEvent map[string]time.Time
+}
+
+// newColdStartTracker creates a coldStartTracker that uses replicas
+// to tell whether a request is a cold start. events may be nil, in
+// which case SLO breaches are only counted
+func newColdStartTracker(
+	lggr logr.Logger,
+	cfg config.ColdStart,
+	replicas workloadReplicasFunc,
+	events coldStartEvents,
+) *coldStartTracker {
+	return &coldStartTracker{
+		lggr:      lggr.WithName("coldStartTracker"),
+		cfg:       cfg,
+		replicas:  replicas,
+		events:    events,
+		mut:       new(sync.Mutex),
+		hists:     map[string]*coldStartHistogram{},
+		lastEvent: map[string]time.Time{},
+	}
+}
+
+// track returns w, wrapped so that the time from arrival to the first
+// byte of the response is recorded as a cold start of host, if
+// target's workload has no replicas. It returns w as it is if the
+// request isn't a cold start, or if t is nil
+func (t *coldStartTracker) track(
+	ctx context.Context,
+	w http.ResponseWriter,
+	host string,
+	target routing.Target,
+	arrival time.Time,
+) http.ResponseWriter {
+	if t == nil {
+		return w
+	}
+	replicas, err := t.replicas(ctx, target)
+	if err != nil || replicas > 0 {
+		return w
+	}
+	t.scaling.started(host, target, arrival)
+	return &firstByteWriter{
+		ResponseWriter: w,
+		onFirstByte: func() {
+			t.observe(host, target, time.Since(arrival))
+		},
+	}
+}
+
+// waited records that a request to host, which routes to target, is
+// done waiting for target's workload to have replicas. err is what
+// the wait returned. It does nothing if t is nil
+func (t *coldStartTracker) waited(host string, target routing.Target, err error) {
+	switch {
+	case t == nil:
+	case err == nil:
+		t.scaling.finished(host, target, scalingEventCompleted)
+	case errors.Is(err, context.DeadlineExceeded):
+		t.scaling.finished(host, target, scalingEventTimedOut)
+	}
+}
+
+func (t *coldStartTracker) observe(host string, target routing.Target, d time.Duration) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	hist, ok := t.hists[host]
+	if !ok {
+		hist = newColdStartHistogram()
+		t.hists[host] = hist
+	}
+	hist.observe(durationMS(d))
+	if t.cfg.SLO <= 0 || d <= t.cfg.SLO {
+		return
+	}
+	hist.SLOBreaches++
+	now := time.Now()
+	if t.events == nil || now.Sub(t.lastEvent[host]) < t.cfg.EventInterval {
+		return
+	}
+	t.lastEvent[host] = now
+	// the request that breached the SLO mustn't
+	// wait on the API server
+	go func() {
+		ctx, done := context.WithTimeout(context.Background(), 10*time.Second)
+		defer done()
+		if err := t.events.sloBreached(ctx, host, target, d, t.cfg.SLO); err != nil {
+			t.lggr.Error(err, "recording cold start SLO breach", "host", host)
+		}
+	}()
+}
+
+// meanDuration returns how long host's cold starts took on average,
+// and false if it had none yet or t is nil
+func (t *coldStartTracker) meanDuration(host string) (time.Duration, bool) {
+	if t == nil {
+		return 0, false
+	}
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	hist, ok := t.hists[host]
+	if !ok || hist.Count == 0 {
+		return 0, false
+	}
+	return time.Duration(hist.SumMS / float64(hist.Count) * float64(time.Millisecond)), true
+}
+
+// MarshalJSON implements json.Marshaler. It returns each host's
+// histogram
+func (t *coldStartTracker) MarshalJSON() ([]byte, error) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	return json.Marshal(t.hists)
+}
+
+// firstByteWriter calls onFirstByte once, when the first byte of the
+// response, or its header, is written
+type firstByteWriter struct {
+	http.ResponseWriter
+	onFirstByte func()
+	once        sync.Once
+}
+
+func (f *firstByteWriter) WriteHeader(code int) {
+	f.once.Do(f.onFirstByte)
+	f.ResponseWriter.WriteHeader(code)
+}
+
+func (f *firstByteWriter) Write(b []byte) (int, error) {
+	f.once.Do(f.onFirstByte)
+	return f.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher, so that streamed
+// responses aren't held back by the wrapper
+func (f *firstByteWriter) Flush() {
+	f.once.Do(f.onFirstByte)
+	if flusher, ok := f.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// k8sColdStartEvents sends a Kubernetes Event on the HTTPScaledObject
+// of the host whose cold start breached the SLO
+type k8sColdStartEvents struct {
+	cl kubernetes.Interface
+	ns string
+}
+
+func (k *k8sColdStartEvents) sloBreached(
+	ctx context.Context,
+	host string,
+	target routing.Target,
+	d,
+	slo time.Duration,
+) error {
+	return recordHTTPScaledObjectEvent(
+		ctx,
+		k.cl,
+		k.ns,
+		host,
+		target.HTTPScaledObject,
+		corev1.EventTypeWarning,
+		"ColdStartSLOBreached",
+		fmt.Sprintf(
+			"cold start of host %s took %s, longer than the SLO of %s",
+			host,
+			d.Round(time.Millisecond),
+			slo,
+		),
+	)
+}
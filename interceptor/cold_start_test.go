@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/interceptor/config"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+type fakeColdStartEvents struct {
+	mut   sync.Mutex
+	hosts []string
+}
+
+func (f *fakeColdStartEvents) sloBreached(
+	_ context.Context,
+	host string,
+	_ routing.Target,
+	_,
+	_ time.Duration,
+) error {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	f.hosts = append(f.hosts, host)
+	return nil
+}
+
+func (f *fakeColdStartEvents) count() int {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	return len(f.hosts)
+}
+
+func TestColdStartTracker(t *testing.T) {
+	const host = "TestColdStartTracker.testing"
+	r := require.New(t)
+	ctx := context.Background()
+	replicas := int32(0)
+	events := &fakeColdStartEvents{}
+	tracker := newColdStartTracker(
+		logr.Discard(),
+		config.ColdStart{SLO: time.Second, EventInterval: time.Hour},
+		func(context.Context, routing.Target) (int32, error) {
+			return replicas, nil
+		},
+		events,
+	)
+	target := routing.Target{Service: "svc", Port: 8080, Deployment: "depl"}
+
+	// a cold start within the SLO is only counted
+	w := tracker.track(ctx, httptest.NewRecorder(), host, target, time.Now().Add(-300*time.Millisecond))
+	w.WriteHeader(200)
+	w.Write([]byte("hello"))
+	// two cold starts over the SLO send a single event
+	for i := 0; i < 2; i++ {
+		w = tracker.track(ctx, httptest.NewRecorder(), host, target, time.Now().Add(-3*time.Second))
+		w.Write([]byte("hello"))
+	}
+	r.Eventually(func() bool {
+		return events.count() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// requests to workloads with replicas aren't cold starts
+	replicas = 1
+	rec := httptest.NewRecorder()
+	r.Equal(rec, tracker.track(ctx, rec, host, target, time.Now().Add(-time.Minute)))
+
+	b, err := json.Marshal(tracker)
+	r.NoError(err)
+	hists := map[string]coldStartHistogram{}
+	r.NoError(json.Unmarshal(b, &hists))
+	hist := hists[host]
+	r.Equal(3, hist.Count)
+	r.Equal(2, hist.SLOBreaches)
+	for _, bucket := range hist.Buckets {
+		switch {
+		case bucket.LeMS < 300:
+			r.Equal(0, bucket.Count, bucket.LeMS)
+		case bucket.LeMS < 3000:
+			r.Equal(1, bucket.Count, bucket.LeMS)
+		default:
+			r.Equal(3, bucket.Count, bucket.LeMS)
+		}
+	}
+
+	// a nil tracker tracks nothing
+	var nilTracker *coldStartTracker
+	r.Equal(rec, nilTracker.track(ctx, rec, host, target, time.Now()))
+}
+
+func TestK8sColdStartEvents(t *testing.T) {
+	const ns = "testns"
+	r := require.New(t)
+	ctx := context.Background()
+	cl := fake.NewSimpleClientset()
+	events := &k8sColdStartEvents{cl: cl, ns: ns}
+
+	// the event needs the HTTPScaledObject's name
+	r.Error(events.sloBreached(ctx, "host", routing.Target{}, 2*time.Second, time.Second))
+
+	r.NoError(events.sloBreached(
+		ctx,
+		"host",
+		routing.Target{HTTPScaledObject: "myapp"},
+		2*time.Second,
+		time.Second,
+	))
+	list, err := cl.CoreV1().Events(ns).List(ctx, metav1.ListOptions{})
+	r.NoError(err)
+	r.Len(list.Items, 1)
+	evt := list.Items[0]
+	r.Equal("HTTPScaledObject", evt.InvolvedObject.Kind)
+	r.Equal("myapp", evt.InvolvedObject.Name)
+	r.Equal("ColdStartSLOBreached", evt.Reason)
+	r.Equal("Warning", evt.Type)
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kedacore/http-add-on/interceptor/config"
+	"github.com/kedacore/http-add-on/pkg/brotli"
+)
+
+// the content codings that the interceptor compresses responses with,
+// in order of preference when a client accepts more than one equally
+const (
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+	// br is last, since its encoder is built for speed, and
+	// compresses about as well as gzip's fastest level
+	encodingBrotli = "br"
+)
+
+var compressionEncodings = []string{encodingGzip, encodingDeflate, encodingBrotli}
+
+// compressor compresses responses as its config says, with pooled
+// encoders
+type compressor struct {
+	minSize   int
+	mimeTypes []string
+	pools     map[string]*sync.Pool
+}
+
+func newCompressor(cfg config.Compression) *compressor {
+	level := cfg.Level
+	mimeTypes := make([]string, 0, len(cfg.MIMETypes))
+	for _, t := range cfg.MIMETypes {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			mimeTypes = append(mimeTypes, t)
+		}
+	}
+	return &compressor{
+		minSize:   cfg.MinSizeBytes,
+		mimeTypes: mimeTypes,
+		pools: map[string]*sync.Pool{
+			encodingGzip: {New: func() interface{} {
+				// the level was validated with the config
+				w, _ := gzip.NewWriterLevel(io.Discard, level)
+				return w
+			}},
+			encodingDeflate: {New: func() interface{} {
+				w, _ := flate.NewWriter(io.Discard, level)
+				return w
+			}},
+			// brotli has a single level
+			encodingBrotli: {New: func() interface{} {
+				return brotli.NewWriter(io.Discard)
+			}},
+		},
+	}
+}
+
+// compressible returns true if responses with Content-Type
+// contentType are compressed
+func (c *compressor) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range c.mimeTypes {
+		if t == mediaType {
+			return true
+		}
+		if strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, t[:len(t)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// encoder is a compressing writer that can be flushed and reset to
+// write to another writer
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// getEncoder returns a pooled encoder for encoding that writes to w
+func (c *compressor) getEncoder(encoding string, w io.Writer) encoder {
+	enc := c.pools[encoding].Get().(encoder)
+	enc.Reset(w)
+	return enc
+}
+
+// putEncoder returns enc to the pool for encoding
+func (c *compressor) putEncoder(encoding string, enc encoder) {
+	c.pools[encoding].Put(enc)
+}
+
+// negotiateEncoding returns the content coding to compress a response
+// with, given the request's Accept-Encoding header, or "" if the
+// client doesn't accept any that the interceptor supports
+func negotiateEncoding(acceptEncoding string) string {
+	bestQ := 0.0
+	best := ""
+	wildcardQ := -1.0
+	qs := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+			parsed, err := strconv.ParseFloat(param[2:], 64)
+			if err != nil {
+				q = 0
+			} else {
+				q = parsed
+			}
+		}
+		if coding == "*" {
+			wildcardQ = q
+			continue
+		}
+		qs[coding] = q
+	}
+	for _, enc := range compressionEncodings {
+		q, ok := qs[enc]
+		if !ok {
+			if wildcardQ < 0 {
+				continue
+			}
+			q = wildcardQ
+		}
+		if q > bestQ {
+			bestQ = q
+			best = enc
+		}
+	}
+	return best
+}
+
+// compressWriter compresses the response that's written to it, if
+// its status, headers and size allow it. It holds the start of bodies
+// of unknown size back until it knows whether they reach the minimum
+// size. Call close once the handler returns
+type compressWriter struct {
+	http.ResponseWriter
+	c        *compressor
+	encoding string
+	// status is the status that the handler wrote, or 0
+	status int
+	// decided is true once the status and headers were written
+	// to ResponseWriter
+	decided bool
+	buf     []byte
+	enc     encoder
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.status != 0 || w.decided {
+		return
+	}
+	// informational responses go straight through,
+	// and the handler writes the real status later
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+	hdr := w.Header()
+	if !w.eligible() {
+		w.writeThrough()
+		return
+	}
+	if hdr.Get("Content-Type") != "" && !w.c.compressible(hdr.Get("Content-Type")) {
+		w.writeThrough()
+		return
+	}
+	if cl := hdr.Get("Content-Length"); cl != "" {
+		size, err := strconv.Atoi(cl)
+		if err == nil && size < w.c.minSize {
+			w.writeThrough()
+			return
+		}
+		if err == nil && hdr.Get("Content-Type") != "" {
+			w.startCompressing()
+			return
+		}
+	}
+	// the body's size or type aren't known yet,
+	// so the decision waits for the body
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.status == 0 && !w.decided {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.decided {
+		if w.enc != nil {
+			return w.enc.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= w.c.minSize {
+		if err := w.decide(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Flush implements http.Flusher, so that streamed responses aren't
+// held back by the wrapper. A response that's flushed before it
+// reaches the minimum size isn't compressed
+func (w *compressWriter) Flush() {
+	if w.status != 0 && !w.decided {
+		w.writeThrough()
+	}
+	if w.enc != nil {
+		w.enc.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// eligible returns true if the response's status and headers allow
+// it to be compressed at all
+func (w *compressWriter) eligible() bool {
+	hdr := w.Header()
+	switch {
+	case w.status < 200,
+		w.status == http.StatusNoContent,
+		w.status == http.StatusNotModified,
+		w.status == http.StatusPartialContent:
+		return false
+	case hdr.Get("Content-Encoding") != "":
+		return false
+	case strings.Contains(strings.ToLower(hdr.Get("Cache-Control")), "no-transform"):
+		return false
+	}
+	return true
+}
+
+// decide writes the status and headers, compressing the body if it's
+// big enough and of a compressible type, then writes what was held
+// back. Bodies without a Content-Type get the one that net/http would
+// have sniffed for them
+func (w *compressWriter) decide() error {
+	hdr := w.Header()
+	if hdr.Get("Content-Type") == "" && len(w.buf) > 0 {
+		hdr.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	if len(w.buf) < w.c.minSize || !w.c.compressible(hdr.Get("Content-Type")) {
+		return w.writeThrough()
+	}
+	return w.startCompressing()
+}
+
+// writeThrough writes the response as it is
+func (w *compressWriter) writeThrough() error {
+	w.decided = true
+	w.ResponseWriter.WriteHeader(w.status)
+	return w.writeBuffered(w.ResponseWriter)
+}
+
+// startCompressing writes the headers of the compressed response, and
+// compresses the body from here on
+func (w *compressWriter) startCompressing() error {
+	w.decided = true
+	hdr := w.Header()
+	// caches must not serve the compressed response
+	// to clients that don't accept it, and vice versa
+	hdr.Add("Vary", "Accept-Encoding")
+	if w.encoding == "" {
+		w.ResponseWriter.WriteHeader(w.status)
+		return w.writeBuffered(w.ResponseWriter)
+	}
+	hdr.Set("Content-Encoding", w.encoding)
+	hdr.Del("Content-Length")
+	hdr.Del("Accept-Ranges")
+	// the compressed body isn't byte for byte
+	// the same as the one the ETag is for
+	if etag := hdr.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		hdr.Set("ETag", "W/"+etag)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	w.enc = w.c.getEncoder(w.encoding, w.ResponseWriter)
+	return w.writeBuffered(w.enc)
+}
+
+// writeBuffered writes the part of the body that was held back to dst
+func (w *compressWriter) writeBuffered(dst io.Writer) error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := dst.Write(w.buf)
+	w.buf = nil
+	return err
+}
+
+// close writes whatever is still held back, and finishes the
+// compressed body
+func (w *compressWriter) close() error {
+	if !w.decided && w.status != 0 {
+		if err := w.decide(); err != nil {
+			return err
+		}
+	}
+	if w.enc == nil {
+		return nil
+	}
+	err := w.enc.Close()
+	w.c.putEncoder(w.encoding, w.enc)
+	w.enc = nil
+	return err
+}
+
+// compressionMiddleware compresses responses to clients that accept
+// gzip, deflate or br, if they're of a type that c compresses and at least
+// its minimum size. Upgrade, CONNECT and HEAD requests are passed
+// straight to next
+func compressionMiddleware(c *compressor, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead ||
+			r.Method == http.MethodConnect ||
+			r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// responses to clients that don't accept compression go
+		// through the writer too, so that they get the same Vary
+		// header as the ones that do
+		cw := &compressWriter{
+			ResponseWriter: w,
+			c:              c,
+			encoding:       negotiateEncoding(r.Header.Get("Accept-Encoding")),
+		}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
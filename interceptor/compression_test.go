@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kedacore/http-add-on/interceptor/config"
+	"github.com/kedacore/http-add-on/pkg/brotli"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	r := require.New(t)
+	for acceptEncoding, expected := range map[string]string{
+		"":                            "",
+		"identity":                    "",
+		"br":                          encodingBrotli,
+		"br, deflate":                 encodingDeflate,
+		"br;q=0.9, *;q=0.5":           encodingBrotli,
+		"gzip":                        encodingGzip,
+		"deflate, gzip":               encodingGzip,
+		"GZIP;q=0.5, deflate":         encodingDeflate,
+		"gzip;q=0, deflate;q=0":       "",
+		"*":                           encodingGzip,
+		"*;q=0.1, gzip;q=0":           encodingDeflate,
+		"br;q=1.0, gzip;q=0.8, *;q=0": encodingBrotli,
+		"br;q=0, gzip;q=0, *":         encodingDeflate,
+		"gzip;q=invalid":              "",
+	} {
+		r.Equal(expected, negotiateEncoding(acceptEncoding), "Accept-Encoding: %s", acceptEncoding)
+	}
+}
+
+func TestCompressible(t *testing.T) {
+	r := require.New(t)
+	c := newCompressor(config.Compression{
+		MIMETypes: []string{"application/json", " Text/* ", ""},
+	})
+	r.True(c.compressible("application/json"))
+	r.True(c.compressible("application/json; charset=utf-8"))
+	r.True(c.compressible("text/html"))
+	r.True(c.compressible("TEXT/CSS"))
+	r.False(c.compressible("image/png"))
+	r.False(c.compressible("application/jsonx"))
+	r.False(c.compressible(""))
+}
+
+// compressionTestResponse sends a request with acceptEncoding through
+// compressionMiddleware to hdl, and returns the response and its
+// decompressed body. br bodies are returned as they are
+func compressionTestResponse(
+	t *testing.T,
+	acceptEncoding string,
+	hdl http.HandlerFunc,
+) (*httptest.ResponseRecorder, string) {
+	t.Helper()
+	r := require.New(t)
+	c := newCompressor(config.Compression{
+		MinSizeBytes: 100,
+		MIMETypes:    []string{"text/plain", "text/html"},
+		Level:        -1,
+	})
+	res, req, err := reqAndRes("/")
+	r.NoError(err)
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	compressionMiddleware(c, hdl).ServeHTTP(res, req)
+
+	body := res.Body.Bytes()
+	switch res.Header().Get("Content-Encoding") {
+	case encodingGzip:
+		gz, err := gzip.NewReader(res.Body)
+		r.NoError(err)
+		body, err = ioutil.ReadAll(gz)
+		r.NoError(err)
+	case encodingDeflate:
+		body, err = ioutil.ReadAll(flate.NewReader(res.Body))
+		r.NoError(err)
+	}
+	return res, string(body)
+}
+
+func TestCompressionMiddleware(t *testing.T) {
+	r := require.New(t)
+	big := strings.Repeat("hello, world! ", 100)
+
+	// bodies of unknown size are compressed once they're big enough
+	res, body := compressionTestResponse(t, "gzip", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("ETag", `"v1"`)
+		for i := 0; i < 100; i++ {
+			w.Write([]byte("hello, world! "))
+		}
+	})
+	r.Equal(200, res.Code)
+	r.Equal(encodingGzip, res.Header().Get("Content-Encoding"))
+	r.Equal("Accept-Encoding", res.Header().Get("Vary"))
+	r.Equal(`W/"v1"`, res.Header().Get("ETag"))
+	r.Equal(big, body)
+	r.Less(res.Body.Len(), len(big))
+
+	// so are bodies of a known size, and without a Content-Type
+	res, body = compressionTestResponse(t, "deflate", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(big)))
+		w.WriteHeader(201)
+		w.Write([]byte(big))
+	})
+	r.Equal(201, res.Code)
+	r.Equal(encodingDeflate, res.Header().Get("Content-Encoding"))
+	r.Empty(res.Header().Get("Content-Length"))
+	r.Equal("text/plain; charset=utf-8", res.Header().Get("Content-Type"))
+	r.Equal(big, body)
+
+	// small bodies aren't worth compressing
+	res, body = compressionTestResponse(t, "gzip", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("tiny"))
+	})
+	r.Empty(res.Header().Get("Content-Encoding"))
+	r.Equal("tiny", body)
+
+	// neither are bodies of other types, or that are compressed already
+	for _, hdr := range []http.Header{
+		{"Content-Type": {"image/png"}},
+		{"Content-Type": {"text/plain"}, "Content-Encoding": {"br"}},
+		{"Content-Type": {"text/plain"}, "Cache-Control": {"no-transform"}},
+	} {
+		res, body = compressionTestResponse(t, "gzip", func(w http.ResponseWriter, _ *http.Request) {
+			for k, v := range hdr {
+				w.Header()[k] = v
+			}
+			w.Write([]byte(big))
+		})
+		r.Equal(hdr.Get("Content-Encoding"), res.Header().Get("Content-Encoding"))
+		r.Equal(big, body)
+	}
+
+	// clients that don't accept compression get the same Vary
+	res, body = compressionTestResponse(t, "identity", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(big))
+	})
+	r.Empty(res.Header().Get("Content-Encoding"))
+	r.Equal("Accept-Encoding", res.Header().Get("Vary"))
+	r.Equal(big, body)
+
+	// br bodies are what the brotli encoder makes of them
+	res, body = compressionTestResponse(t, "br", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(big))
+	})
+	r.Equal(encodingBrotli, res.Header().Get("Content-Encoding"))
+	var expected bytes.Buffer
+	br := brotli.NewWriter(&expected)
+	br.Write([]byte(big))
+	r.NoError(br.Close())
+	r.Equal(expected.String(), body)
+	r.Less(len(body), len(big))
+
+	// responses without a body go straight through
+	res, body = compressionTestResponse(t, "gzip", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(304)
+	})
+	r.Equal(304, res.Code)
+	r.Empty(res.Header().Get("Content-Encoding"))
+	r.Empty(body)
+}
+
+func TestCompressionMiddlewareFlush(t *testing.T) {
+	r := require.New(t)
+	big := strings.Repeat("data: hello\n\n", 100)
+
+	// a stream that's flushed before it's big enough isn't compressed
+	res, body := compressionTestResponse(t, "gzip", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("data: first\n\n"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte(big))
+	})
+	r.Empty(res.Header().Get("Content-Encoding"))
+	r.True(res.Flushed)
+	r.Equal("data: first\n\n"+big, body)
+
+	// one that's already compressed is flushed through the encoder
+	res, body = compressionTestResponse(t, "gzip", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(big))
+		w.(http.Flusher).Flush()
+		w.Write([]byte(big))
+	})
+	r.Equal(encodingGzip, res.Header().Get("Content-Encoding"))
+	r.True(res.Flushed)
+	r.Equal(big+big, body)
+}
+
+func TestCompressionConfigValidate(t *testing.T) {
+	r := require.New(t)
+	for level, valid := range map[int]bool{-2: false, -1: true, 0: false, 1: true, 9: true, 10: false} {
+		cfg := &config.Compression{Level: level}
+		if valid {
+			r.NoError(cfg.Validate(), "level %d", level)
+		} else {
+			r.Error(cfg.Validate(), "level %d", level)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/interceptor/config"
+	"github.com/kedacore/http-add-on/pkg/routing"
+)
+
+var (
+	errConcurrencyQueueFull    = errors.New("too many requests are waiting")
+	errConcurrencyQueueTimeout = errors.New("timed out waiting for a request in flight to finish")
+)
+
+// concurrencyStats are the per-host counters that a
+// concurrencyLimiter exposes
+type concurrencyStats struct {
+	InFlight  int   `json:"inFlight"`
+	Queued    int   `json:"queued"`
+	Forwarded int64 `json:"forwarded"`
+	Shed      int64 `json:"shed"`
+	TimedOut  int64 `json:"timedOut"`
+}
+
+// hostConcurrency is the state of the limit for a single host. Each
+// element of waiters is the channel of a request that waits for a
+// slot, in the order that they arrived
+type hostConcurrency struct {
+	maxInFlight int
+	inFlight    int
+	waiters     *list.List
+	stats       *concurrencyStats
+}
+
+// grantFirst hands a slot, that the caller already counted in
+// h.inFlight, to the request that's waited the longest. Callers must
+// hold the concurrencyLimiter's mut, and h must have waiters
+func (h *hostConcurrency) grantFirst() {
+	front := h.waiters.Front()
+	h.waiters.Remove(front)
+	h.stats.Forwarded++
+	close(front.Value.(chan struct{}))
+}
+
+// concurrencyLimiter limits the requests in flight to each host. When
+// a request finishes, its slot goes straight to the request that's
+// waited the longest, so that requests are forwarded in the order
+// they arrived
+type concurrencyLimiter struct {
+	mut   *sync.Mutex
+	cfg   config.Concurrency
+	hosts map[string]*hostConcurrency
+}
+
+func newConcurrencyLimiter(cfg config.Concurrency) *concurrencyLimiter {
+	return &concurrencyLimiter{
+		mut:   new(sync.Mutex),
+		cfg:   cfg,
+		hosts: map[string]*hostConcurrency{},
+	}
+}
+
+// acquire takes one of the slots for host that policy allows, waiting
+// in host's queue if they're all taken. While it waits, the request
+// that ctx belongs to is marked as pending. It returns the func that
+// gives the slot back, which must be called exactly once. It returns
+// an error, and no slot, if host's queue is full or the request
+// waited longer than its timeout, or if ctx is done
+func (l *concurrencyLimiter) acquire(
+	ctx context.Context,
+	host string,
+	policy routing.ConcurrencyPolicy,
+) (func(), error) {
+	maxQueued := l.cfg.MaxQueued
+	if policy.MaxQueued != nil {
+		maxQueued = *policy.MaxQueued
+	}
+	timeout := l.cfg.QueueTimeout
+	if policy.QueueTimeoutMS > 0 {
+		timeout = time.Duration(policy.QueueTimeoutMS) * time.Millisecond
+	}
+
+	l.mut.Lock()
+	h, ok := l.hosts[host]
+	if !ok {
+		h = &hostConcurrency{
+			waiters: list.New(),
+			stats:   &concurrencyStats{},
+		}
+		l.hosts[host] = h
+	}
+	h.maxInFlight = policy.MaxInFlight
+	// if the limit was raised, the requests that already wait get
+	// the new slots first
+	for h.inFlight < h.maxInFlight && h.waiters.Len() > 0 {
+		h.inFlight++
+		h.grantFirst()
+	}
+	if h.inFlight < h.maxInFlight {
+		h.inFlight++
+		h.stats.Forwarded++
+		l.mut.Unlock()
+		return l.releaseFunc(h), nil
+	}
+	if h.waiters.Len() >= maxQueued {
+		h.stats.Shed++
+		l.mut.Unlock()
+		return nil, errConcurrencyQueueFull
+	}
+	granted := make(chan struct{})
+	elt := h.waiters.PushBack(granted)
+	l.mut.Unlock()
+
+	donePending := startPending(ctx)
+	defer donePending()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-granted:
+		return l.releaseFunc(h), nil
+	case <-timer.C:
+		err = errConcurrencyQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.mut.Lock()
+	select {
+	case <-granted:
+		// the slot was handed over while the request gave up on it.
+		// if it timed out, it may as well use the slot. otherwise
+		// nobody is left to use it
+		l.mut.Unlock()
+		release := l.releaseFunc(h)
+		if ctx.Err() == nil {
+			return release, nil
+		}
+		release()
+		return nil, err
+	default:
+	}
+	h.waiters.Remove(elt)
+	if err == errConcurrencyQueueTimeout {
+		h.stats.TimedOut++
+	}
+	l.mut.Unlock()
+	return nil, err
+}
+
+// releaseFunc returns the func that gives back one of h's slots. The
+// slot goes to the first request in h's queue, if there's one and h's
+// limit hasn't been lowered below the requests in flight since
+func (l *concurrencyLimiter) releaseFunc(h *hostConcurrency) func() {
+	once := new(sync.Once)
+	return func() {
+		once.Do(func() {
+			l.mut.Lock()
+			defer l.mut.Unlock()
+			if h.waiters.Len() > 0 && h.inFlight <= h.maxInFlight {
+				h.grantFirst()
+				return
+			}
+			h.inFlight--
+		})
+	}
+}
+
+// MarshalJSON returns the requests in flight and waiting for each
+// host, and the number of requests that were forwarded, shed and
+// timed out
+func (l *concurrencyLimiter) MarshalJSON() ([]byte, error) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	ret := make(map[string]concurrencyStats, len(l.hosts))
+	for host, h := range l.hosts {
+		stats := *h.stats
+		stats.InFlight = h.inFlight
+		stats.Queued = h.waiters.Len()
+		ret[host] = stats
+	}
+	return json.Marshal(ret)
+}
+
+// concurrencyLimitMiddleware limits the requests in flight to the
+// hosts whose routing table targets ask for it. Requests past the
+// limit wait for a slot, and are marked as pending while they do, so
+// that the scaler sees them. Requests that can't get a slot are
+// rejected with a 503 and a Retry-After header. Requests to a host's
+// canary are limited apart from the requests to its main workload
+func concurrencyLimitMiddleware(
+	lggr logr.Logger,
+	limiter *concurrencyLimiter,
+	routingTable routing.TableReader,
+	next http.Handler,
+) http.Handler {
+	lggr = lggr.WithName("concurrencyLimitMiddleware")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, err := getHost(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		target, err := lookupTarget(r.Context(), routingTable, host)
+		if err != nil || target.Concurrency == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := queueKey(r.Context(), host)
+		release, err := limiter.acquire(r.Context(), key, *target.Concurrency)
+		if err != nil {
+			lggr.V(1).Info(
+				"rejecting request over the concurrency limit",
+				"host",
+				key,
+				"reason",
+				err.Error(),
+				"requestID",
+				requestIDFromContext(r.Context()),
+			)
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("too many requests in flight, try again later"))
+			return
+		}
+		defer release()
+		next.ServeHTTP(w, r)
+	})
+}
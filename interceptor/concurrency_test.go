@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/interceptor/config"
+	"github.com/kedacore/http-add-on/pkg/queue"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"github.com/stretchr/testify/require"
+)
+
+// concurrencyStatsFor returns l's stats for host
+func concurrencyStatsFor(l *concurrencyLimiter, host string) concurrencyStats {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	h, ok := l.hosts[host]
+	if !ok {
+		return concurrencyStats{}
+	}
+	ret := *h.stats
+	ret.InFlight = h.inFlight
+	ret.Queued = h.waiters.Len()
+	return ret
+}
+
+func TestConcurrencyLimiterFIFO(t *testing.T) {
+	const host = "TestConcurrencyLimiterFIFO.testing"
+	r := require.New(t)
+	ctx := context.Background()
+	limiter := newConcurrencyLimiter(config.Concurrency{
+		MaxQueued:    10,
+		QueueTimeout: time.Minute,
+	})
+	policy := routing.ConcurrencyPolicy{MaxInFlight: 1}
+
+	release, err := limiter.acquire(ctx, host, policy)
+	r.NoError(err)
+
+	// the waiters get the slot in the order that they arrived
+	order := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		i := i
+		go func() {
+			release, err := limiter.acquire(ctx, host, policy)
+			if err == nil {
+				order <- i
+				release()
+			}
+		}()
+		r.Eventually(func() bool {
+			return concurrencyStatsFor(limiter, host).Queued == i+1
+		}, time.Second, time.Millisecond)
+	}
+	release()
+	// releasing twice doesn't give back two slots
+	release()
+	r.Equal(0, <-order)
+	r.Equal(1, <-order)
+
+	r.Eventually(func() bool {
+		return concurrencyStatsFor(limiter, host).InFlight == 0
+	}, time.Second, time.Millisecond)
+	stats := concurrencyStatsFor(limiter, host)
+	r.Equal(0, stats.Queued)
+	r.Equal(int64(3), stats.Forwarded)
+}
+
+func TestConcurrencyLimiterShed(t *testing.T) {
+	const host = "TestConcurrencyLimiterShed.testing"
+	r := require.New(t)
+	ctx := context.Background()
+	limiter := newConcurrencyLimiter(config.Concurrency{
+		MaxQueued:    10,
+		QueueTimeout: time.Minute,
+	})
+	maxQueued := 0
+	policy := routing.ConcurrencyPolicy{MaxInFlight: 1, MaxQueued: &maxQueued}
+
+	release, err := limiter.acquire(ctx, host, policy)
+	r.NoError(err)
+	defer release()
+	// with no room in the queue, requests past the limit are
+	// rejected right away
+	_, err = limiter.acquire(ctx, host, policy)
+	r.ErrorIs(err, errConcurrencyQueueFull)
+	r.Equal(int64(1), concurrencyStatsFor(limiter, host).Shed)
+}
+
+func TestConcurrencyLimiterTimeout(t *testing.T) {
+	const host = "TestConcurrencyLimiterTimeout.testing"
+	r := require.New(t)
+	limiter := newConcurrencyLimiter(config.Concurrency{
+		MaxQueued:    10,
+		QueueTimeout: time.Minute,
+	})
+	policy := routing.ConcurrencyPolicy{MaxInFlight: 1, QueueTimeoutMS: 10}
+
+	release, err := limiter.acquire(context.Background(), host, policy)
+	r.NoError(err)
+	_, err = limiter.acquire(context.Background(), host, policy)
+	r.ErrorIs(err, errConcurrencyQueueTimeout)
+
+	ctx, done := context.WithCancel(context.Background())
+	done()
+	policy.QueueTimeoutMS = 0
+	_, err = limiter.acquire(ctx, host, policy)
+	r.ErrorIs(err, context.Canceled)
+
+	stats := concurrencyStatsFor(limiter, host)
+	r.Equal(int64(1), stats.TimedOut)
+	r.Equal(0, stats.Queued)
+
+	// the requests that gave up don't hold on to the slot
+	release()
+	r.Equal(0, concurrencyStatsFor(limiter, host).InFlight)
+}
+
+func TestConcurrencyLimiterRaisedLimit(t *testing.T) {
+	const host = "TestConcurrencyLimiterRaisedLimit.testing"
+	r := require.New(t)
+	ctx := context.Background()
+	limiter := newConcurrencyLimiter(config.Concurrency{
+		MaxQueued:    10,
+		QueueTimeout: time.Minute,
+	})
+	policy := routing.ConcurrencyPolicy{MaxInFlight: 1}
+	release, err := limiter.acquire(ctx, host, policy)
+	r.NoError(err)
+	defer release()
+
+	waited := make(chan func())
+	go func() {
+		release, err := limiter.acquire(ctx, host, policy)
+		if err == nil {
+			waited <- release
+		}
+	}()
+	r.Eventually(func() bool {
+		return concurrencyStatsFor(limiter, host).Queued == 1
+	}, time.Second, time.Millisecond)
+
+	// the request that waits gets the new slot before
+	// the one that arrives after the limit was raised
+	_, err = limiter.acquire(ctx, host, routing.ConcurrencyPolicy{
+		MaxInFlight: 2,
+		MaxQueued:   new(int),
+	})
+	r.ErrorIs(err, errConcurrencyQueueFull)
+	select {
+	case release := <-waited:
+		release()
+	case <-time.After(time.Second):
+		r.FailNow("the waiting request didn't get the new slot")
+	}
+}
+
+func TestConcurrencyLimitMiddleware(t *testing.T) {
+	const (
+		host          = "TestConcurrencyLimitMiddleware.testing"
+		unlimitedHost = "unlimited.testing"
+	)
+	r := require.New(t)
+	table := routing.NewTable()
+	target := routing.NewTarget("testsvc", 8080, "testdepl", 100)
+	r.NoError(table.AddTarget(unlimitedHost, target))
+	target.Concurrency = &routing.ConcurrencyPolicy{MaxInFlight: 1}
+	r.NoError(table.AddTarget(host, target))
+	limiter := newConcurrencyLimiter(config.Concurrency{
+		MaxQueued:    1,
+		QueueTimeout: time.Minute,
+	})
+	q := queue.NewMemory()
+
+	unblock := make(chan struct{})
+	hdl := countMiddleware(
+		logr.Discard(),
+		q,
+		table,
+		concurrencyLimitMiddleware(
+			logr.Discard(),
+			limiter,
+			table,
+			http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				<-unblock
+				w.WriteHeader(200)
+			}),
+		),
+	)
+	serve := func(host string) chan int {
+		codes := make(chan int, 1)
+		go func() {
+			res := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Host = host
+			hdl.ServeHTTP(res, req)
+			codes <- res.Code
+		}()
+		return codes
+	}
+
+	first := serve(host)
+	r.Eventually(func() bool {
+		return concurrencyStatsFor(limiter, host).InFlight == 1
+	}, time.Second, time.Millisecond)
+	second := serve(host)
+	r.Eventually(func() bool {
+		return concurrencyStatsFor(limiter, host).Queued == 1
+	}, time.Second, time.Millisecond)
+
+	// the request that waits counts toward scaling as pending
+	cts, err := q.Current()
+	r.NoError(err)
+	r.Equal(1, cts.Host(host).Active)
+	r.Equal(1, cts.Host(host).Pending)
+
+	// with the queue full, the next request is shed
+	r.Equal(http.StatusServiceUnavailable, <-serve(host))
+
+	// hosts without a limit aren't limited
+	unlimited := serve(unlimitedHost)
+	close(unblock)
+	r.Equal(200, <-first)
+	r.Equal(200, <-second)
+	r.Equal(200, <-unlimited)
+
+	res, err := json.Marshal(limiter)
+	r.NoError(err)
+	stats := map[string]concurrencyStats{}
+	r.NoError(json.Unmarshal(res, &stats))
+	r.Equal(concurrencyStats{Forwarded: 2, Shed: 1}, stats[host])
+	r.NotContains(stats, unlimitedHost)
+}
@@ -0,0 +1,24 @@
+package config
+
+import (
+	"github.com/kelseyhightower/envconfig"
+)
+
+// AccessLog is the configuration for the interceptor's access log
+type AccessLog struct {
+	// Enabled toggles whether the interceptor writes an access log entry,
+	// as a line of JSON on standard output, for proxied requests
+	Enabled bool `envconfig:"KEDA_HTTP_ACCESS_LOG_ENABLED" default:"false"`
+	// SampleRate is the fraction, between 0 and 1, of requests
+	// that get an access log entry
+	SampleRate float64 `envconfig:"KEDA_HTTP_ACCESS_LOG_SAMPLE_RATE" default:"1"`
+}
+
+// MustParseAccessLog parses access log configuration using envconfig
+// and returns a pointer to the newly created config. Panics if
+// parsing failed
+func MustParseAccessLog() *AccessLog {
+	ret := new(AccessLog)
+	envconfig.MustProcess("", ret)
+	return ret
+}
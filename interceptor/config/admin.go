@@ -0,0 +1,47 @@
+package config
+
+import (
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// Admin is the configuration for the debugging endpoints
+// on the interceptor's admin server
+type Admin struct {
+	// Token is the bearer token that clients must send to use the
+	// debugging endpoints. If it's empty, the debugging endpoints
+	// are not served at all
+	Token string `envconfig:"KEDA_HTTP_ADMIN_TOKEN" default:""`
+	// ProfilingEnabled toggles whether the pprof profiles, runtime
+	// stats and goroutine and heap dumps are served along with the
+	// debugging endpoints, behind the same token. They're never served
+	// without a token
+	ProfilingEnabled bool `envconfig:"KEDA_HTTP_ADMIN_PROFILING_ENABLED" default:"false"`
+	// TLSCertFile and TLSKeyFile are the paths to the certificate
+	// and key that the admin server presents, and TLSCAFile is the
+	// path to the CA bundle that client certificates are verified
+	// against. If they're all set, the admin server serves mutual
+	// TLS, and every route except the health checks requires a
+	// verified client certificate. Otherwise it serves plain HTTP
+	TLSCertFile string `envconfig:"KEDA_HTTP_ADMIN_TLS_CERT_FILE" default:""`
+	TLSKeyFile  string `envconfig:"KEDA_HTTP_ADMIN_TLS_KEY_FILE" default:""`
+	TLSCAFile   string `envconfig:"KEDA_HTTP_ADMIN_TLS_CA_FILE" default:""`
+	// TLSReloadInterval is how often the TLS files are checked for
+	// changes, so that rotated certificates are picked up
+	TLSReloadInterval time.Duration `envconfig:"KEDA_HTTP_ADMIN_TLS_RELOAD_INTERVAL" default:"1m"`
+}
+
+// TLSEnabled returns true if the admin server should serve mutual TLS
+func (a *Admin) TLSEnabled() bool {
+	return a.TLSCertFile != "" && a.TLSKeyFile != "" && a.TLSCAFile != ""
+}
+
+// MustParseAdmin parses admin server configuration using envconfig
+// and returns a pointer to the newly created config. Panics if
+// parsing failed
+func MustParseAdmin() *Admin {
+	ret := new(Admin)
+	envconfig.MustProcess("", ret)
+	return ret
+}
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// Auth is the configuration for authenticating the requests to the
+// hosts whose HTTPScaledObjects ask for it
+type Auth struct {
+	// Enabled toggles whether the interceptor authenticates requests.
+	// It needs permission to watch the Secrets in its namespace for
+	// bearer tokens. If it's false, requests to hosts that ask to be
+	// authenticated are rejected, rather than let through
+	Enabled bool `envconfig:"KEDA_HTTP_AUTH_ENABLED" default:"false"`
+	// SecretsResyncDurationMS is the interval (in milliseconds) at
+	// which the informer that caches the bearer token Secrets
+	// re-delivers every Secret it has, as a fallback in case it
+	// missed a change
+	SecretsResyncDurationMS int `envconfig:"KEDA_HTTP_AUTH_SECRETS_RESYNC_DURATION_MS" default:"60000"`
+	// JWKSRefreshInterval is how long the keys fetched from a JWKS URL
+	// are used before they're fetched again. Tokens signed by a key
+	// that isn't known yet trigger a fetch sooner
+	JWKSRefreshInterval time.Duration `envconfig:"KEDA_HTTP_AUTH_JWKS_REFRESH_INTERVAL" default:"5m"`
+	// Timeout is the maximum time that fetching a JWKS, or a request
+	// to a forward auth service, may take
+	Timeout time.Duration `envconfig:"KEDA_HTTP_AUTH_TIMEOUT" default:"5s"`
+}
+
+// MustParseAuth parses auth configuration using envconfig and returns
+// a pointer to the newly created config. Panics if parsing failed
+func MustParseAuth() *Auth {
+	ret := new(Auth)
+	envconfig.MustProcess("", ret)
+	return ret
+}
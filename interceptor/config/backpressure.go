@@ -0,0 +1,62 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// Backpressure is the configuration for turning requests away when
+// too many of them are waiting for their backends to scale up, so
+// that a burst of requests to cold hosts can't exhaust the
+// interceptor's memory
+type Backpressure struct {
+	// MaxPendingRequests is the maximum number of requests that can
+	// wait for their backends at once, across all hosts. Requests
+	// past it get a 503. 0 means there's no maximum
+	MaxPendingRequests int `envconfig:"KEDA_HTTP_MAX_PENDING_REQUESTS" default:"0"`
+	// SoftMaxPendingRequestsPerHost is the number of requests that can
+	// wait for each host's backend at once. Requests past it get a 503
+	// with a Retry-After of how much longer the backend usually takes
+	// to scale up. 0 means there's no maximum
+	SoftMaxPendingRequestsPerHost int `envconfig:"KEDA_HTTP_SOFT_MAX_PENDING_REQUESTS_PER_HOST" default:"0"`
+	// RetryAfter is the value of the Retry-After header of the
+	// responses to the requests that are turned away. It's rounded
+	// up to whole seconds. For requests past the per-host maximum,
+	// it's the shortest Retry-After
+	RetryAfter time.Duration `envconfig:"KEDA_HTTP_BACKPRESSURE_RETRY_AFTER" default:"1s"`
+}
+
+// Validate returns an error if b has a negative maximum, or a
+// Retry-After that isn't positive
+func (b *Backpressure) Validate() error {
+	if b.MaxPendingRequests < 0 {
+		return fmt.Errorf(
+			"KEDA_HTTP_MAX_PENDING_REQUESTS must be at least 0, but it's %d",
+			b.MaxPendingRequests,
+		)
+	}
+	if b.SoftMaxPendingRequestsPerHost < 0 {
+		return fmt.Errorf(
+			"KEDA_HTTP_SOFT_MAX_PENDING_REQUESTS_PER_HOST must be at least 0, but it's %d",
+			b.SoftMaxPendingRequestsPerHost,
+		)
+	}
+	if b.RetryAfter <= 0 {
+		return fmt.Errorf(
+			"KEDA_HTTP_BACKPRESSURE_RETRY_AFTER must be positive, but it's %s",
+			b.RetryAfter,
+		)
+	}
+	return nil
+}
+
+// MustParseBackpressure parses backpressure configuration using
+// envconfig and returns a pointer to the newly created config. Panics
+// if parsing failed
+func MustParseBackpressure() *Backpressure {
+	ret := new(Backpressure)
+	envconfig.MustProcess("", ret)
+	return ret
+}
@@ -0,0 +1,26 @@
+package config
+
+import (
+	"github.com/kelseyhightower/envconfig"
+)
+
+// BodyLimits is the default configuration for the maximum sizes of
+// request and response bodies that the interceptor proxies. Each
+// HTTPScaledObject can override them
+type BodyLimits struct {
+	// MaxRequestBytes is the maximum size of a request body. Requests
+	// with larger bodies get a 413 response. 0 means no limit
+	MaxRequestBytes int64 `envconfig:"KEDA_HTTP_MAX_REQUEST_BODY_BYTES" default:"0"`
+	// MaxResponseBytes is the maximum size of a response body. Larger
+	// responses are cut off. 0 means no limit
+	MaxResponseBytes int64 `envconfig:"KEDA_HTTP_MAX_RESPONSE_BODY_BYTES" default:"0"`
+}
+
+// MustParseBodyLimits parses body limit configuration using envconfig
+// and returns a pointer to the newly created config. Panics if
+// parsing failed
+func MustParseBodyLimits() *BodyLimits {
+	ret := new(BodyLimits)
+	envconfig.MustProcess("", ret)
+	return ret
+}
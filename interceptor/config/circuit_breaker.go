@@ -0,0 +1,38 @@
+package config
+
+import (
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// CircuitBreaker is the configuration for the per-host circuit
+// breakers in the interceptor
+type CircuitBreaker struct {
+	// Enabled toggles whether circuit breakers are used at all
+	Enabled bool `envconfig:"KEDA_HTTP_CIRCUIT_BREAKER_ENABLED" default:"false"`
+	// ErrorThresholdPercent is the percentage of failed requests to a
+	// host, within Window, at which the circuit breaker for that host
+	// opens
+	ErrorThresholdPercent int `envconfig:"KEDA_HTTP_CIRCUIT_BREAKER_ERROR_THRESHOLD_PERCENT" default:"50"`
+	// MinRequests is the minimum number of requests that need to be
+	// seen in a Window before the error rate is evaluated
+	MinRequests int `envconfig:"KEDA_HTTP_CIRCUIT_BREAKER_MIN_REQUESTS" default:"20"`
+	// Window is the duration over which requests and errors are counted
+	Window time.Duration `envconfig:"KEDA_HTTP_CIRCUIT_BREAKER_WINDOW" default:"10s"`
+	// OpenDuration is how long a circuit breaker stays open before
+	// letting trial requests through
+	OpenDuration time.Duration `envconfig:"KEDA_HTTP_CIRCUIT_BREAKER_OPEN_DURATION" default:"30s"`
+	// HalfOpenRequests is the number of trial requests that need to
+	// succeed, while half-open, before the circuit breaker closes again
+	HalfOpenRequests int `envconfig:"KEDA_HTTP_CIRCUIT_BREAKER_HALF_OPEN_REQUESTS" default:"1"`
+}
+
+// MustParseCircuitBreaker parses circuit breaker configuration using
+// envconfig and returns a pointer to the newly created config. Panics
+// if parsing failed
+func MustParseCircuitBreaker() *CircuitBreaker {
+	ret := new(CircuitBreaker)
+	envconfig.MustProcess("", ret)
+	return ret
+}
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// ColdStart is the configuration for how the interceptor tracks cold
+// starts, which are the requests that arrive while their workload is
+// scaled to zero
+type ColdStart struct {
+	// SLO is the longest that a cold start, from the request's arrival
+	// to the first byte of its response, should take. Cold starts that
+	// take longer get a Kubernetes Event on their HTTPScaledObject. 0
+	// means no Events are sent
+	SLO time.Duration `envconfig:"KEDA_HTTP_COLD_START_SLO" default:"0"`
+	// EventInterval is the shortest time between two Events for the
+	// same host, since every request that waits on the same cold start
+	// would otherwise get its own
+	EventInterval time.Duration `envconfig:"KEDA_HTTP_COLD_START_EVENT_INTERVAL" default:"1m"`
+}
+
+// MustParseColdStart parses cold start configuration using envconfig
+// and returns a pointer to the newly created config. Panics if parsing
+// failed
+func MustParseColdStart() *ColdStart {
+	ret := new(ColdStart)
+	envconfig.MustProcess("", ret)
+	return ret
+}
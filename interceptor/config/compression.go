@@ -0,0 +1,49 @@
+package config
+
+import (
+	"compress/flate"
+	"fmt"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// Compression is the configuration for compressing responses to
+// clients that accept it, so that backends don't need to
+type Compression struct {
+	// Enabled toggles whether the interceptor compresses responses
+	Enabled bool `envconfig:"KEDA_HTTP_COMPRESSION_ENABLED" default:"false"`
+	// MinSizeBytes is the minimum size of a response body that's
+	// compressed. Smaller ones aren't worth it
+	MinSizeBytes int `envconfig:"KEDA_HTTP_COMPRESSION_MIN_SIZE_BYTES" default:"1024"`
+	// MIMETypes are the media types of the responses that are
+	// compressed. A type can end in /* to match all of its subtypes
+	MIMETypes []string `envconfig:"KEDA_HTTP_COMPRESSION_MIME_TYPES" default:"text/html,text/css,text/plain,text/javascript,text/xml,application/javascript,application/json,application/xml,image/svg+xml"`
+	// Level is the gzip and deflate compression level, from 1 (fastest)
+	// to 9 (smallest). -1 is the default level of the compression
+	// libraries. br has a single level
+	Level int `envconfig:"KEDA_HTTP_COMPRESSION_LEVEL" default:"-1"`
+}
+
+// Validate returns an error if c's level isn't a valid compression
+// level
+func (c *Compression) Validate() error {
+	if c.Level != flate.DefaultCompression &&
+		(c.Level < flate.BestSpeed || c.Level > flate.BestCompression) {
+		return fmt.Errorf(
+			"KEDA_HTTP_COMPRESSION_LEVEL must be -1, or between %d and %d, but it's %d",
+			flate.BestSpeed,
+			flate.BestCompression,
+			c.Level,
+		)
+	}
+	return nil
+}
+
+// MustParseCompression parses response compression configuration
+// using envconfig and returns a pointer to the newly created config.
+// Panics if parsing failed
+func MustParseCompression() *Compression {
+	ret := new(Compression)
+	envconfig.MustProcess("", ret)
+	return ret
+}
@@ -0,0 +1,30 @@
+package config
+
+import (
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// Concurrency is the configuration for the hosts whose
+// HTTPScaledObjects limit the requests that the interceptor forwards
+// to them at once
+type Concurrency struct {
+	// MaxQueued is the maximum number of requests to a host that wait
+	// for a request in flight to finish, unless the host's
+	// HTTPScaledObject sets its own. Requests past it are rejected
+	MaxQueued int `envconfig:"KEDA_HTTP_CONCURRENCY_MAX_QUEUED" default:"100"`
+	// QueueTimeout is how long a request waits for a request in flight
+	// to finish before it's rejected, unless the host's
+	// HTTPScaledObject sets its own
+	QueueTimeout time.Duration `envconfig:"KEDA_HTTP_CONCURRENCY_QUEUE_TIMEOUT" default:"30s"`
+}
+
+// MustParseConcurrency parses concurrency configuration using
+// envconfig and returns a pointer to the newly created config. Panics
+// if parsing failed
+func MustParseConcurrency() *Concurrency {
+	ret := new(Concurrency)
+	envconfig.MustProcess("", ret)
+	return ret
+}
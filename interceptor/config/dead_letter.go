@@ -0,0 +1,85 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+const (
+	// DeadLetterFormatJSON makes the interceptor POST dead letters
+	// as plain JSON objects
+	DeadLetterFormatJSON = "json"
+	// DeadLetterFormatCloudEvents makes the interceptor POST dead
+	// letters as CloudEvents, in structured mode
+	DeadLetterFormatCloudEvents = "cloudevents"
+)
+
+// DeadLetter is the configuration for reporting the requests that
+// the interceptor counted toward scaling, but then failed to get a
+// response from the backend for, so that they can be replayed
+type DeadLetter struct {
+	// URL is the endpoint that dead letters are POSTed to. If it's
+	// empty, failed requests aren't reported
+	URL string `envconfig:"KEDA_HTTP_DEAD_LETTER_URL" default:""`
+	// Format is how dead letters are encoded. It's either
+	// DeadLetterFormatJSON or DeadLetterFormatCloudEvents
+	Format string `envconfig:"KEDA_HTTP_DEAD_LETTER_FORMAT" default:"json"`
+	// MaxBodyBytes is how much of the body of a failed request is
+	// included in its dead letter. Longer bodies are cut off, and 0
+	// leaves bodies out altogether
+	MaxBodyBytes int64 `envconfig:"KEDA_HTTP_DEAD_LETTER_MAX_BODY_BYTES" default:"0"`
+	// MaxConcurrent is the maximum number of dead letters that may be
+	// in flight at once. Failed requests that would go over it aren't
+	// reported
+	MaxConcurrent int `envconfig:"KEDA_HTTP_DEAD_LETTER_MAX_CONCURRENT" default:"10"`
+	// Timeout is the maximum time that sending a dead letter,
+	// including reading its response, may take
+	Timeout time.Duration `envconfig:"KEDA_HTTP_DEAD_LETTER_TIMEOUT" default:"10s"`
+}
+
+// Enabled returns true if failed requests should be reported
+func (d *DeadLetter) Enabled() bool {
+	return d.URL != ""
+}
+
+// Validate returns an error if d has an unknown format, or a URL that
+// isn't absolute
+func (d *DeadLetter) Validate() error {
+	switch d.Format {
+	case DeadLetterFormatJSON, DeadLetterFormatCloudEvents:
+	default:
+		return fmt.Errorf("unknown KEDA_HTTP_DEAD_LETTER_FORMAT %q", d.Format)
+	}
+	if d.URL == "" {
+		return nil
+	}
+	u, err := url.Parse(d.URL)
+	if err != nil || !u.IsAbs() {
+		return fmt.Errorf("KEDA_HTTP_DEAD_LETTER_URL %q isn't an absolute URL", d.URL)
+	}
+	if d.MaxBodyBytes < 0 {
+		return fmt.Errorf(
+			"KEDA_HTTP_DEAD_LETTER_MAX_BODY_BYTES must not be negative, but it's %d",
+			d.MaxBodyBytes,
+		)
+	}
+	if d.MaxConcurrent < 1 {
+		return fmt.Errorf(
+			"KEDA_HTTP_DEAD_LETTER_MAX_CONCURRENT must be at least 1, but it's %d",
+			d.MaxConcurrent,
+		)
+	}
+	return nil
+}
+
+// MustParseDeadLetter parses dead letter configuration using
+// envconfig and returns a pointer to the newly created config. Panics
+// if parsing failed
+func MustParseDeadLetter() *DeadLetter {
+	ret := new(DeadLetter)
+	envconfig.MustProcess("", ret)
+	return ret
+}
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"github.com/kelseyhightower/envconfig"
+)
+
+// ErrorPages is the configuration for the custom pages that the
+// interceptor responds with when it can't forward a request
+type ErrorPages struct {
+	// Enabled toggles whether custom error pages are used at all. If
+	// it's false, the interceptor always uses its built-in responses
+	Enabled bool `envconfig:"KEDA_HTTP_ERROR_PAGES_ENABLED" default:"false"`
+	// DefaultConfigMap is the name of a ConfigMap, in the interceptor's
+	// namespace, with the error pages for hosts that don't have their
+	// own, and for requests to unknown hosts. Empty means there are no
+	// default pages
+	DefaultConfigMap string `envconfig:"KEDA_HTTP_ERROR_PAGES_DEFAULT_CONFIG_MAP" default:""`
+	// ResyncDurationMS is the interval (in milliseconds) at which the
+	// informer that caches the error page ConfigMaps re-delivers every
+	// ConfigMap it has, as a fallback in case it missed a change
+	ResyncDurationMS int `envconfig:"KEDA_HTTP_ERROR_PAGES_RESYNC_DURATION_MS" default:"60000"`
+}
+
+// MustParseErrorPages parses error page configuration using envconfig
+// and returns a pointer to the newly created config. Panics if parsing
+// failed
+func MustParseErrorPages() *ErrorPages {
+	ret := new(ErrorPages)
+	envconfig.MustProcess("", ret)
+	return ret
+}
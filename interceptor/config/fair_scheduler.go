@@ -0,0 +1,63 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// FairScheduler is the configuration for scheduling the requests that
+// the interceptor forwards fairly across hosts, so that a burst of
+// requests to one host can't starve the others, like when many hosts
+// wake up from zero at once
+type FairScheduler struct {
+	// Enabled toggles whether requests are scheduled at all. The
+	// scheduler's workers are the interceptor's pool of forwarding
+	// workers, so it's on by default
+	Enabled bool `envconfig:"KEDA_HTTP_FAIR_SCHEDULER_ENABLED" default:"true"`
+	// Workers is the maximum number of requests that the interceptor
+	// forwards at once, across all hosts. Requests past it wait, and
+	// are let through one host at a time, round-robin
+	Workers int `envconfig:"KEDA_HTTP_FAIR_SCHEDULER_WORKERS" default:"1000"`
+	// MaxHostSharePercent is the maximum percentage of Workers that
+	// the requests to a single host can use. Requests to a host that
+	// uses its share wait, even if there are idle workers
+	MaxHostSharePercent int `envconfig:"KEDA_HTTP_FAIR_SCHEDULER_MAX_HOST_SHARE_PERCENT" default:"50"`
+}
+
+// Validate returns an error if f has no workers, or a share that
+// isn't a percentage
+func (f *FairScheduler) Validate() error {
+	if f.Workers < 1 {
+		return fmt.Errorf(
+			"KEDA_HTTP_FAIR_SCHEDULER_WORKERS must be at least 1, but it's %d",
+			f.Workers,
+		)
+	}
+	if f.MaxHostSharePercent < 1 || f.MaxHostSharePercent > 100 {
+		return fmt.Errorf(
+			"KEDA_HTTP_FAIR_SCHEDULER_MAX_HOST_SHARE_PERCENT must be between 1 and 100, but it's %d",
+			f.MaxHostSharePercent,
+		)
+	}
+	return nil
+}
+
+// MaxPerHost returns the number of workers that the requests to a
+// single host can use. It's at least 1
+func (f *FairScheduler) MaxPerHost() int {
+	ret := f.Workers * f.MaxHostSharePercent / 100
+	if ret < 1 {
+		return 1
+	}
+	return ret
+}
+
+// MustParseFairScheduler parses fair scheduler configuration using
+// envconfig and returns a pointer to the newly created config. Panics
+// if parsing failed
+func MustParseFairScheduler() *FairScheduler {
+	ret := new(FairScheduler)
+	envconfig.MustProcess("", ret)
+	return ret
+}
@@ -0,0 +1,27 @@
+package config
+
+import (
+	"github.com/kelseyhightower/envconfig"
+)
+
+// FaultInjection is the configuration for injecting latency and errors
+// into the requests to the hosts whose HTTPScaledObjects ask for it
+// with fault annotations
+type FaultInjection struct {
+	// Enabled toggles whether the interceptor honors fault annotations.
+	// It's off by default, so that anyone who can annotate an
+	// HTTPScaledObject can't disrupt a production interceptor
+	Enabled bool `envconfig:"KEDA_HTTP_FAULT_INJECTION_ENABLED" default:"false"`
+	// MaxDelayMS caps the delay, in milliseconds, that a fault
+	// annotation can inject into a request
+	MaxDelayMS int64 `envconfig:"KEDA_HTTP_FAULT_INJECTION_MAX_DELAY_MS" default:"60000"`
+}
+
+// MustParseFaultInjection parses fault injection configuration using
+// envconfig and returns a pointer to the newly created config. Panics
+// if parsing failed
+func MustParseFaultInjection() *FaultInjection {
+	ret := new(FaultInjection)
+	envconfig.MustProcess("", ret)
+	return ret
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// Forwarded is the configuration for how the interceptor fills in the
+// X-Forwarded-* and Forwarded headers on the requests it proxies
+type Forwarded struct {
+	// TrustedProxyCIDRs is the list of CIDRs, or single IPs, of the
+	// proxies in front of the interceptor, like load balancers. The
+	// X-Forwarded-* and Forwarded headers from clients in these ranges
+	// are kept and added to. The ones from all other clients are
+	// stripped, so that clients can't spoof them
+	TrustedProxyCIDRs []string `envconfig:"KEDA_HTTP_TRUSTED_PROXY_CIDRS" default:""`
+	// TrustedHops is the number of proxies in front of the interceptor
+	// whose X-Forwarded-For entries are trusted. The client IP that
+	// rate limiting, IP filters and the access log use is the one that
+	// many hops back from the interceptor in X-Forwarded-For. 0 means
+	// it's always the address of the connection. If TrustedProxyCIDRs
+	// is set, X-Forwarded-For is only read from the proxies in it
+	TrustedHops int `envconfig:"KEDA_HTTP_TRUSTED_HOPS" default:"0"`
+}
+
+// Validate returns an error if TrustedHops is negative
+func (f *Forwarded) Validate() error {
+	if f.TrustedHops < 0 {
+		return fmt.Errorf("KEDA_HTTP_TRUSTED_HOPS must not be negative, got %d", f.TrustedHops)
+	}
+	return nil
+}
+
+// MustParseForwarded parses forwarded header configuration using
+// envconfig and returns a pointer to the newly created config. Panics
+// if parsing failed
+func MustParseForwarded() *Forwarded {
+	ret := new(Forwarded)
+	envconfig.MustProcess("", ret)
+	return ret
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"github.com/kelseyhightower/envconfig"
+	"github.com/pkg/errors"
+	"go.uber.org/zap/zapcore"
+)
+
+// Logging is the configuration for the interceptor's logs
+type Logging struct {
+	// Level is the lowest level of the messages that are logged.
+	// It's one of debug, info, warn or error. debug includes
+	// the verbose messages that are left out by default
+	Level string `envconfig:"KEDA_HTTP_LOG_LEVEL" default:"info"`
+}
+
+// ZapLevel returns the parsed Level
+func (l *Logging) ZapLevel() (zapcore.Level, error) {
+	var ret zapcore.Level
+	if err := ret.UnmarshalText([]byte(l.Level)); err != nil {
+		return ret, errors.Wrap(err, "invalid KEDA_HTTP_LOG_LEVEL")
+	}
+	return ret, nil
+}
+
+// Validate returns an error if Level isn't a valid level
+func (l *Logging) Validate() error {
+	_, err := l.ZapLevel()
+	return err
+}
+
+// MustParseLogging parses logging configuration using envconfig and
+// returns a pointer to the newly created config. Panics if parsing
+// failed
+func MustParseLogging() *Logging {
+	ret := new(Logging)
+	envconfig.MustProcess("", ret)
+	return ret
+}
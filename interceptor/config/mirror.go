@@ -0,0 +1,32 @@
+package config
+
+import (
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// Mirror is the configuration for mirroring requests to the mirror
+// services of the HTTPScaledObjects that have one
+type Mirror struct {
+	// MaxConcurrent is the maximum number of mirrored requests that
+	// may be in flight at once. Requests that would go over it aren't
+	// mirrored
+	MaxConcurrent int `envconfig:"KEDA_HTTP_MIRROR_MAX_CONCURRENT" default:"100"`
+	// MaxBodyBytes is the maximum size of the body of a request that
+	// is mirrored. Requests with larger bodies aren't mirrored, since
+	// their bodies need to be held in memory to be sent twice
+	MaxBodyBytes int64 `envconfig:"KEDA_HTTP_MIRROR_MAX_BODY_BYTES" default:"1048576"`
+	// Timeout is the maximum time that a mirrored request, including
+	// reading its response, may take
+	Timeout time.Duration `envconfig:"KEDA_HTTP_MIRROR_TIMEOUT" default:"10s"`
+}
+
+// MustParseMirror parses request mirroring configuration using
+// envconfig and returns a pointer to the newly created config. Panics
+// if parsing failed
+func MustParseMirror() *Mirror {
+	ret := new(Mirror)
+	envconfig.MustProcess("", ret)
+	return ret
+}
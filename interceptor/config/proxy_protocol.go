@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	kedanet "github.com/kedacore/http-add-on/pkg/net"
+	"github.com/kelseyhightower/envconfig"
+)
+
+// ProxyProtocol is the configuration for the PROXY protocol, which L4
+// load balancers use to pass on the addresses of the clients whose
+// connections they forward
+type ProxyProtocol struct {
+	// Enabled makes the proxy server read a PROXY protocol v1 or v2
+	// header at the start of each connection, and use the client
+	// address in it for logging, rate limiting and IP filtering.
+	// Connections without a header are served as they are
+	Enabled bool `envconfig:"KEDA_HTTP_PROXY_PROTOCOL_ENABLED" default:"false"`
+	// TrustedCIDRs is the list of CIDRs, or single IPs, of the load
+	// balancers that may send PROXY protocol headers. If it's empty,
+	// every peer may, so only leave it empty if the proxy server
+	// can't be reached other than through the load balancers
+	TrustedCIDRs []string `envconfig:"KEDA_HTTP_PROXY_PROTOCOL_TRUSTED_CIDRS" default:""`
+	// HeaderTimeout is how long a trusted peer has to send its
+	// header after it connects, before its connection is closed
+	HeaderTimeout time.Duration `envconfig:"KEDA_HTTP_PROXY_PROTOCOL_HEADER_TIMEOUT" default:"5s"`
+	// Upstream is the version of the PROXY protocol header, either
+	// v1 or v2, that the interceptor sends to backends at the start
+	// of each connection, for backends that want the client address
+	// without parsing X-Forwarded-For. Connections to backends aren't
+	// reused when it's set, since a header describes one client.
+	// Empty means no header is sent
+	Upstream string `envconfig:"KEDA_HTTP_PROXY_PROTOCOL_UPSTREAM" default:""`
+}
+
+// Validate returns an error if Upstream is an unknown version
+func (p *ProxyProtocol) Validate() error {
+	switch p.Upstream {
+	case "", kedanet.ProxyProtocolV1, kedanet.ProxyProtocolV2:
+	default:
+		return fmt.Errorf("unknown KEDA_HTTP_PROXY_PROTOCOL_UPSTREAM %q", p.Upstream)
+	}
+	return nil
+}
+
+// MustParseProxyProtocol parses PROXY protocol configuration using
+// envconfig and returns a pointer to the newly created config. Panics
+// if parsing failed
+func MustParseProxyProtocol() *ProxyProtocol {
+	ret := new(ProxyProtocol)
+	envconfig.MustProcess("", ret)
+	return ret
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+const (
+	// ProxyClientAuthRequire makes the proxy server reject TLS
+	// connections from clients without a verified certificate
+	ProxyClientAuthRequire = "require"
+	// ProxyClientAuthOptional makes the proxy server verify client
+	// certificates if clients present them, and accept connections
+	// from clients without one. Hosts that allow only some
+	// certificates still reject requests without one
+	ProxyClientAuthOptional = "optional"
+)
+
+// ProxyTLS is the configuration for serving TLS, and verifying client
+// certificates, on the interceptor's proxy server
+type ProxyTLS struct {
+	// CertFile and KeyFile are the paths to the certificate and key
+	// that the proxy server presents. If they're both set, the proxy
+	// server serves TLS. Otherwise it serves plain HTTP
+	CertFile string `envconfig:"KEDA_HTTP_PROXY_TLS_CERT_FILE" default:""`
+	KeyFile  string `envconfig:"KEDA_HTTP_PROXY_TLS_KEY_FILE" default:""`
+	// ClientCAFile is the path to the CA bundle that client
+	// certificates are verified against. If it's empty, clients
+	// aren't asked for certificates, and hosts that only allow some
+	// certificates reject every request
+	ClientCAFile string `envconfig:"KEDA_HTTP_PROXY_TLS_CLIENT_CA_FILE" default:""`
+	// ClientAuth is whether clients must present a certificate. It's
+	// either ProxyClientAuthRequire or ProxyClientAuthOptional, and
+	// is ignored without ClientCAFile
+	ClientAuth string `envconfig:"KEDA_HTTP_PROXY_TLS_CLIENT_AUTH" default:"require"`
+	// ReloadInterval is how often the TLS files are checked for
+	// changes, so that rotated certificates are picked up
+	ReloadInterval time.Duration `envconfig:"KEDA_HTTP_PROXY_TLS_RELOAD_INTERVAL" default:"1m"`
+}
+
+// Enabled returns true if the proxy server should serve TLS
+func (p *ProxyTLS) Enabled() bool {
+	return p.CertFile != "" && p.KeyFile != ""
+}
+
+// VerifiesClients returns true if the proxy server should verify
+// client certificates
+func (p *ProxyTLS) VerifiesClients() bool {
+	return p.Enabled() && p.ClientCAFile != ""
+}
+
+// Validate returns an error if ClientAuth is unknown, or if p has a
+// client CA bundle but no certificate to serve TLS with
+func (p *ProxyTLS) Validate() error {
+	switch p.ClientAuth {
+	case ProxyClientAuthRequire, ProxyClientAuthOptional:
+	default:
+		return fmt.Errorf(
+			"unknown KEDA_HTTP_PROXY_TLS_CLIENT_AUTH %q",
+			p.ClientAuth,
+		)
+	}
+	if p.ClientCAFile != "" && !p.Enabled() {
+		return fmt.Errorf(
+			"KEDA_HTTP_PROXY_TLS_CLIENT_CA_FILE is set, but KEDA_HTTP_PROXY_TLS_CERT_FILE or KEDA_HTTP_PROXY_TLS_KEY_FILE isn't",
+		)
+	}
+	return nil
+}
+
+// MustParseProxyTLS parses proxy TLS configuration using envconfig
+// and returns a pointer to the newly created config. Panics if
+// parsing failed
+func MustParseProxyTLS() *ProxyTLS {
+	ret := new(ProxyTLS)
+	envconfig.MustProcess("", ret)
+	return ret
+}
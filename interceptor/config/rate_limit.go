@@ -0,0 +1,30 @@
+package config
+
+import (
+	"github.com/kelseyhightower/envconfig"
+)
+
+// RateLimit is the configuration for the per-host rate limiters in
+// the interceptor
+type RateLimit struct {
+	// Enabled toggles whether requests are rate limited at all
+	Enabled bool `envconfig:"KEDA_HTTP_RATE_LIMIT_ENABLED" default:"false"`
+	// RequestsPerSecond is the sustained rate of requests that each
+	// host, or each client of a host if PerClientIP is set, may send
+	RequestsPerSecond float64 `envconfig:"KEDA_HTTP_RATE_LIMIT_REQUESTS_PER_SECOND" default:"100"`
+	// Burst is the maximum number of requests that may be sent at
+	// once, above RequestsPerSecond
+	Burst int `envconfig:"KEDA_HTTP_RATE_LIMIT_BURST" default:"200"`
+	// PerClientIP makes each client IP have its own limit for
+	// each host, instead of all clients sharing the host's limit
+	PerClientIP bool `envconfig:"KEDA_HTTP_RATE_LIMIT_PER_CLIENT_IP" default:"false"`
+}
+
+// MustParseRateLimit parses rate limit configuration using envconfig
+// and returns a pointer to the newly created config. Panics if
+// parsing failed
+func MustParseRateLimit() *RateLimit {
+	ret := new(RateLimit)
+	envconfig.MustProcess("", ret)
+	return ret
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// Registration is the configuration for registering the interceptor
+// with the scaler, for interceptors that the scaler doesn't know
+// about otherwise, like the interceptors that a single namespace
+// runs for itself
+type Registration struct {
+	// ScalerURL is the URL of the scaler's health server, which
+	// serves the registration endpoint. If it's empty, the
+	// interceptor doesn't register itself
+	ScalerURL string `envconfig:"KEDA_HTTP_SCALER_REGISTRATION_URL" default:""`
+	// Token is the bearer token that the scaler requires to
+	// register interceptors
+	Token string `envconfig:"KEDA_HTTP_SCALER_REGISTRATION_TOKEN" default:""`
+	// PodName and PodIP identify this interceptor's pod to the
+	// scaler, which scrapes the admin server at PodIP. They're
+	// usually set with the downward API
+	PodName string `envconfig:"KEDA_HTTP_POD_NAME" default:""`
+	PodIP   string `envconfig:"KEDA_HTTP_POD_IP" default:""`
+	// Interval is how often the interceptor registers again. The
+	// scaler forgets interceptors that don't for three intervals
+	Interval time.Duration `envconfig:"KEDA_HTTP_SCALER_REGISTRATION_INTERVAL" default:"10s"`
+}
+
+// Enabled returns true if the interceptor should
+// register itself with the scaler
+func (r *Registration) Enabled() bool {
+	return r.ScalerURL != ""
+}
+
+// Validate returns an error if r is enabled, but the scaler's URL
+// is invalid, or the token, pod name, pod IP or interval is missing
+func (r *Registration) Validate() error {
+	if !r.Enabled() {
+		return nil
+	}
+	if _, err := url.Parse(r.ScalerURL); err != nil {
+		return fmt.Errorf("invalid KEDA_HTTP_SCALER_REGISTRATION_URL (%w)", err)
+	}
+	if r.Token == "" {
+		return fmt.Errorf("KEDA_HTTP_SCALER_REGISTRATION_TOKEN must be set to register with the scaler")
+	}
+	if r.PodName == "" || r.PodIP == "" {
+		return fmt.Errorf("KEDA_HTTP_POD_NAME and KEDA_HTTP_POD_IP must be set to register with the scaler")
+	}
+	if r.Interval <= 0 {
+		return fmt.Errorf(
+			"KEDA_HTTP_SCALER_REGISTRATION_INTERVAL must be positive, but it's %s",
+			r.Interval,
+		)
+	}
+	return nil
+}
+
+// MustParseRegistration parses registration configuration using
+// envconfig and returns a pointer to the newly created config. Panics
+// if parsing failed
+func MustParseRegistration() *Registration {
+	ret := new(Registration)
+	envconfig.MustProcess("", ret)
+	return ret
+}
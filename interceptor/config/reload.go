@@ -0,0 +1,26 @@
+package config
+
+import (
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// Reload is the configuration for how the interceptor reloads its
+// configuration while it's running
+type Reload struct {
+	// ConfigFilePollInterval is how often the interceptor checks
+	// whether its config file changed, which is how it sees updates
+	// to a mounted ConfigMap. 0 turns the checks off, so the
+	// configuration is only reloaded on SIGHUP
+	ConfigFilePollInterval time.Duration `envconfig:"KEDA_HTTP_CONFIG_FILE_POLL_INTERVAL" default:"10s"`
+}
+
+// MustParseReload parses reload configuration using envconfig and
+// returns a pointer to the newly created config. Panics if parsing
+// failed
+func MustParseReload() *Reload {
+	ret := new(Reload)
+	envconfig.MustProcess("", ret)
+	return ret
+}
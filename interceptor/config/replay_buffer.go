@@ -0,0 +1,34 @@
+package config
+
+import (
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// ReplayBuffer is the configuration for the buffer that holds requests
+// while their backend is scaling up from zero
+type ReplayBuffer struct {
+	// Enabled toggles whether requests to backends with no ready
+	// replicas are buffered. If false, they are held on open
+	// connections with no bound on how many there can be
+	Enabled bool `envconfig:"KEDA_HTTP_REPLAY_BUFFER_ENABLED" default:"false"`
+	// MaxRequests is the maximum number of requests that may be
+	// buffered for a single host
+	MaxRequests int `envconfig:"KEDA_HTTP_REPLAY_BUFFER_MAX_REQUESTS" default:"100"`
+	// MaxBodyBytes is the maximum total size of the request bodies
+	// that may be buffered for a single host
+	MaxBodyBytes int64 `envconfig:"KEDA_HTTP_REPLAY_BUFFER_MAX_BODY_BYTES" default:"10485760"`
+	// RetryAfter is the value of the Retry-After header sent with the
+	// 503 response when the buffer for a host is full
+	RetryAfter time.Duration `envconfig:"KEDA_HTTP_REPLAY_BUFFER_RETRY_AFTER" default:"5s"`
+}
+
+// MustParseReplayBuffer parses replay buffer configuration using
+// envconfig and returns a pointer to the newly created config. Panics
+// if parsing failed
+func MustParseReplayBuffer() *ReplayBuffer {
+	ret := new(ReplayBuffer)
+	envconfig.MustProcess("", ret)
+	return ret
+}
@@ -0,0 +1,26 @@
+package config
+
+import (
+	"github.com/kelseyhightower/envconfig"
+)
+
+// RequestID is the configuration for the IDs that the interceptor
+// attaches to the requests it proxies
+type RequestID struct {
+	// Header is the header that holds the request ID. It's set on the
+	// request to the backend and on the response to the client
+	Header string `envconfig:"KEDA_HTTP_REQUEST_ID_HEADER" default:"X-Request-ID"`
+	// TrustInbound toggles whether the interceptor keeps a request ID
+	// that the client sent, instead of generating a new one. IDs that
+	// are too long or have unprintable characters are always replaced
+	TrustInbound bool `envconfig:"KEDA_HTTP_REQUEST_ID_TRUST_INBOUND" default:"true"`
+}
+
+// MustParseRequestID parses request ID configuration using envconfig
+// and returns a pointer to the newly created config. Panics if parsing
+// failed
+func MustParseRequestID() *RequestID {
+	ret := new(RequestID)
+	envconfig.MustProcess("", ret)
+	return ret
+}
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// ResponseCache is the configuration for the interceptor's response
+// cache. Responses are only cached for hosts whose HTTPScaledObjects
+// opt in, and only within the bounds set here
+type ResponseCache struct {
+	// Enabled toggles whether responses are cached at all
+	Enabled bool `envconfig:"KEDA_HTTP_RESPONSE_CACHE_ENABLED" default:"false"`
+	// MaxBytes is the maximum total size of all cached responses.
+	// The least recently used responses are evicted to stay under it
+	MaxBytes int64 `envconfig:"KEDA_HTTP_RESPONSE_CACHE_MAX_BYTES" default:"67108864"`
+	// MaxEntryBytes is the maximum size of a single cached response.
+	// Larger responses are proxied but not cached
+	MaxEntryBytes int64 `envconfig:"KEDA_HTTP_RESPONSE_CACHE_MAX_ENTRY_BYTES" default:"1048576"`
+	// MaxTTL is the maximum time a response is cached for, no matter
+	// what its Cache-Control header says
+	MaxTTL time.Duration `envconfig:"KEDA_HTTP_RESPONSE_CACHE_MAX_TTL" default:"1h"`
+}
+
+// MustParseResponseCache parses response cache configuration using
+// envconfig and returns a pointer to the newly created config. Panics
+// if parsing failed
+func MustParseResponseCache() *ResponseCache {
+	ret := new(ResponseCache)
+	envconfig.MustProcess("", ret)
+	return ret
+}
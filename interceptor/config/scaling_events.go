@@ -0,0 +1,60 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+const (
+	// ScalingEventsSinkHTTP makes the interceptor POST scaling events
+	// as CloudEvents to ScalingEvents.URL
+	ScalingEventsSinkHTTP = "http"
+	// ScalingEventsSinkKubernetes makes the interceptor record scaling
+	// events as Kubernetes Events on the HTTPScaledObjects of hosts
+	ScalingEventsSinkKubernetes = "kubernetes"
+)
+
+// ScalingEvents is the configuration for the events that the
+// interceptor emits when hosts scale from zero
+type ScalingEvents struct {
+	// Sink is where the events go. It's either ScalingEventsSinkHTTP,
+	// ScalingEventsSinkKubernetes or empty, which means no events are
+	// emitted
+	Sink string `envconfig:"KEDA_HTTP_SCALING_EVENTS_SINK" default:""`
+	// URL is the endpoint that events are POSTed to, with
+	// ScalingEventsSinkHTTP
+	URL string `envconfig:"KEDA_HTTP_SCALING_EVENTS_URL" default:""`
+	// Timeout is the maximum time that emitting an event may take
+	Timeout time.Duration `envconfig:"KEDA_HTTP_SCALING_EVENTS_TIMEOUT" default:"10s"`
+}
+
+// Validate returns an error if s has an unknown sink, or the HTTP
+// sink without an absolute URL
+func (s *ScalingEvents) Validate() error {
+	switch s.Sink {
+	case "", ScalingEventsSinkKubernetes:
+	case ScalingEventsSinkHTTP:
+		u, err := url.Parse(s.URL)
+		if err != nil || !u.IsAbs() {
+			return fmt.Errorf(
+				"KEDA_HTTP_SCALING_EVENTS_URL %q isn't an absolute URL",
+				s.URL,
+			)
+		}
+	default:
+		return fmt.Errorf("unknown KEDA_HTTP_SCALING_EVENTS_SINK %q", s.Sink)
+	}
+	return nil
+}
+
+// MustParseScalingEvents parses scaling event configuration using
+// envconfig and returns a pointer to the newly created config. Panics
+// if parsing failed
+func MustParseScalingEvents() *ScalingEvents {
+	ret := new(ScalingEvents)
+	envconfig.MustProcess("", ret)
+	return ret
+}
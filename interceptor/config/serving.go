@@ -1,7 +1,34 @@
 package config
 
 import (
+	"fmt"
+	"time"
+
+	"github.com/kedacore/http-add-on/pkg/k8s"
 	"github.com/kelseyhightower/envconfig"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// RoutingTableSourceConfigMap makes the interceptor read its
+	// routing table from the routing table ConfigMap
+	RoutingTableSourceConfigMap = "configmap"
+	// RoutingTableSourceHTTPScaledObjects makes the interceptor build
+	// its routing table by watching HTTPScaledObjects directly
+	RoutingTableSourceHTTPScaledObjects = "httpscaledobjects"
+	// WaitForEndpoints makes the interceptor hold requests until the
+	// Endpoints for the target Service have a ready address
+	WaitForEndpoints = "endpoints"
+	// WaitForReplicas makes the interceptor hold requests until the
+	// target workload has a ready replica
+	WaitForReplicas = "replicas"
+	// UpstreamResolverDNS makes the interceptor dial backends by
+	// their Service's DNS name
+	UpstreamResolverDNS = "dns"
+	// UpstreamResolverEndpoints makes the interceptor dial a ready
+	// pod of the backend's Service directly, picked from the
+	// Service's Endpoints
+	UpstreamResolverEndpoints = "endpoints"
 )
 
 // Serving is configuration for how the interceptor serves the proxy
@@ -16,20 +43,142 @@ type Serving struct {
 	// This is the server that the external scaler will issue metrics
 	// requests to
 	AdminPort int `envconfig:"KEDA_HTTP_ADMIN_PORT" required:"true"`
-	// RoutingTableUpdateDurationMS is the interval (in milliseconds) representing how
-	// often to do a complete update of the routing table ConfigMap.
+	// RoutingTableSource is where the interceptor gets its routing table
+	// from. If it's RoutingTableSourceConfigMap, it reads the routing
+	// table ConfigMap that the operator maintains. If it's
+	// RoutingTableSourceHTTPScaledObjects, it builds the routing table
+	// itself by watching the HTTPScaledObjects in CurrentNamespace
+	RoutingTableSource string `envconfig:"KEDA_HTTP_ROUTING_TABLE_SOURCE" default:"configmap"`
+	// RoutingTableLabelSelector restricts the HTTPScaledObjects that
+	// the interceptor routes to the ones that match it, so that teams
+	// that share a namespace can each run their own interceptors.
+	// Only used with RoutingTableSourceHTTPScaledObjects. Empty means
+	// all HTTPScaledObjects in CurrentNamespace
+	RoutingTableLabelSelector string `envconfig:"KEDA_HTTP_ROUTING_TABLE_LABEL_SELECTOR" default:""`
+	// RoutingTableResyncDurationMS is the interval (in milliseconds) at
+	// which the routing table is rebuilt from RoutingTableSource, even if
+	// nothing changed.
 	//
-	// The interceptor will also open a watch stream to the routing table
-	// ConfigMap and attempt to update the routing table on every update.
+	// The interceptor watches RoutingTableSource and updates the routing
+	// table as soon as it changes, so this is only a fallback in case a
+	// change was missed
+	RoutingTableResyncDurationMS int `envconfig:"KEDA_HTTP_ROUTING_TABLE_RESYNC_DURATION_MS" default:"60000"`
+	// DeploymentCacheResyncDurationMS is the interval (in milliseconds)
+	// at which the informers of the deployment and endpoints caches
+	// re-deliver every object they have. The informers watch those
+	// objects, so this is only a fallback in case a change was missed.
+	// The endpoints cache ignores resyncs
+	DeploymentCacheResyncDurationMS int `envconfig:"KEDA_HTTP_DEPLOYMENT_CACHE_RESYNC_DURATION_MS" default:"60000"`
+	// DeploymentCacheLabelSelector restricts the Deployments that the
+	// deployment cache holds to the ones that match it, so that the
+	// interceptor doesn't cache every Deployment in a busy namespace.
+	// Empty means all Deployments in CurrentNamespace
+	DeploymentCacheLabelSelector string `envconfig:"KEDA_HTTP_DEPLOYMENT_CACHE_LABEL_SELECTOR" default:""`
+	// DeploymentCacheWatchBackoffInitialMS is how long (in
+	// milliseconds) the deployment cache waits to reconnect after its
+	// watch on the API server fails. It doubles with every failure
+	// in a row, up to DeploymentCacheWatchBackoffMaxMS. This is on
+	// top of client-go's own backoff. 0 adds no backoff
+	DeploymentCacheWatchBackoffInitialMS int `envconfig:"KEDA_HTTP_DEPLOYMENT_CACHE_WATCH_BACKOFF_INITIAL_MS" default:"1000"`
+	// DeploymentCacheWatchBackoffMaxMS caps (in milliseconds) how long
+	// the deployment cache waits to reconnect
+	DeploymentCacheWatchBackoffMaxMS int `envconfig:"KEDA_HTTP_DEPLOYMENT_CACHE_WATCH_BACKOFF_MAX_MS" default:"30000"`
+	// DeploymentCacheWatchBackoffJitter is the fraction, between 0 and
+	// 1, of each wait to reconnect that is randomized, so that
+	// interceptors don't all reconnect at the same time
+	DeploymentCacheWatchBackoffJitter float64 `envconfig:"KEDA_HTTP_DEPLOYMENT_CACHE_WATCH_BACKOFF_JITTER" default:"0.2"`
+	// DeploymentCacheWatchMaxRetries is the number of failed
+	// reconnects in a row after which the deployment cache's circuit
+	// opens, which is logged, until a list or watch succeeds. It keeps
+	// reconnecting. 0 means the circuit never opens
+	DeploymentCacheWatchMaxRetries int `envconfig:"KEDA_HTTP_DEPLOYMENT_CACHE_WATCH_MAX_RETRIES" default:"10"`
+	// DeploymentCacheWatchReadiness toggles whether the interceptor
+	// reports itself as not ready while the deployment cache's circuit
+	// is open. An API server outage opens the circuits of every
+	// interceptor at the same time, which would take the whole fleet
+	// out of its Service, so this is only for fleets that are spread
+	// over clusters or API servers
+	DeploymentCacheWatchReadiness bool `envconfig:"KEDA_HTTP_DEPLOYMENT_CACHE_WATCH_READINESS" default:"false"`
+	// WaitFor is what the interceptor waits for before it forwards a
+	// request to a backend that was scaled to zero. It's either
+	// WaitForEndpoints or WaitForReplicas.
 	//
-	// Since it does full updates alongside watch stream updates, it can
-	// only process one at a time. Therefore, this is a best effort timeout
-	RoutingTableUpdateDurationMS int `envconfig:"KEDA_HTTP_ROUTING_TABLE_UPDATE_DURATION_MS" default:"500"`
-	// The interceptor has an internal process that periodically fetches the state
-	// of deployment that is running the servers it forwards to.
+	// A ready replica isn't necessarily in the Service's Endpoints yet,
+	// so WaitForReplicas can forward requests a little too early
+	WaitFor string `envconfig:"KEDA_HTTP_WAIT_FOR" default:"endpoints"`
+	// ScalePollIntervalMS is the interval (in milliseconds) at which the
+	// interceptor reads the scale subresource of workloads that aren't
+	// Deployments, while it waits for them to scale up
+	ScalePollIntervalMS int `envconfig:"KEDA_HTTP_SCALE_POLLING_INTERVAL_MS" default:"250"`
+	// UpstreamResolver is how the interceptor finds the address to
+	// dial for a backend. It's either UpstreamResolverDNS or
+	// UpstreamResolverEndpoints.
 	//
-	// This is the interval (in milliseconds) representing how often to do a fetch
-	DeploymentCachePollIntervalMS int `envconfig:"KEDA_HTTP_DEPLOYMENT_CACHE_POLLING_INTERVAL_MS" default:"250"`
+	// Right after a scale from zero, the Service's DNS name and
+	// kube-proxy can take a moment to catch up with the new pods.
+	// UpstreamResolverEndpoints skips both, and spreads connections
+	// across the ready pods round-robin
+	UpstreamResolver string `envconfig:"KEDA_HTTP_UPSTREAM_RESOLVER" default:"dns"`
+	// RevisionPinning makes the interceptor honor the revisions that
+	// HTTPScaledObjects pin their hosts to, by only dialing the pods
+	// of the pinned revision. The interceptor caches the Pods in
+	// CurrentNamespace to read their labels. It requires
+	// UpstreamResolverEndpoints
+	RevisionPinning bool `envconfig:"KEDA_HTTP_REVISION_PINNING" default:"false"`
+}
+
+// Validate returns an error if any of the fields that only
+// take a few values has a different one
+func (s *Serving) Validate() error {
+	switch s.RoutingTableSource {
+	case RoutingTableSourceConfigMap, RoutingTableSourceHTTPScaledObjects:
+	default:
+		return fmt.Errorf(
+			"unknown KEDA_HTTP_ROUTING_TABLE_SOURCE %q",
+			s.RoutingTableSource,
+		)
+	}
+	if _, err := labels.Parse(s.RoutingTableLabelSelector); err != nil {
+		return fmt.Errorf(
+			"invalid KEDA_HTTP_ROUTING_TABLE_LABEL_SELECTOR %q (%w)",
+			s.RoutingTableLabelSelector,
+			err,
+		)
+	}
+	switch s.WaitFor {
+	case WaitForEndpoints, WaitForReplicas:
+	default:
+		return fmt.Errorf("unknown KEDA_HTTP_WAIT_FOR %q", s.WaitFor)
+	}
+	switch s.UpstreamResolver {
+	case UpstreamResolverDNS, UpstreamResolverEndpoints:
+	default:
+		return fmt.Errorf(
+			"unknown KEDA_HTTP_UPSTREAM_RESOLVER %q",
+			s.UpstreamResolver,
+		)
+	}
+	if s.RevisionPinning && s.UpstreamResolver != UpstreamResolverEndpoints {
+		return fmt.Errorf(
+			"KEDA_HTTP_REVISION_PINNING requires KEDA_HTTP_UPSTREAM_RESOLVER=%s",
+			UpstreamResolverEndpoints,
+		)
+	}
+	if err := s.DeploymentCacheWatchBackoff().Validate(); err != nil {
+		return fmt.Errorf("invalid KEDA_HTTP_DEPLOYMENT_CACHE_WATCH_* settings (%w)", err)
+	}
+	return nil
+}
+
+// DeploymentCacheWatchBackoff returns how the deployment cache backs
+// off when its watch on the API server fails
+func (s *Serving) DeploymentCacheWatchBackoff() k8s.WatchBackoff {
+	return k8s.WatchBackoff{
+		Initial:    time.Duration(s.DeploymentCacheWatchBackoffInitialMS) * time.Millisecond,
+		Max:        time.Duration(s.DeploymentCacheWatchBackoffMaxMS) * time.Millisecond,
+		Jitter:     s.DeploymentCacheWatchBackoffJitter,
+		MaxRetries: s.DeploymentCacheWatchMaxRetries,
+	}
 }
 
 // Parse parses standard configs using envconfig and returns a pointer to the
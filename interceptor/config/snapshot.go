@@ -0,0 +1,46 @@
+package config
+
+import (
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+const (
+	// SnapshotStoreNone disables queue count snapshots
+	SnapshotStoreNone = ""
+	// SnapshotStoreConfigMap saves queue count snapshots in a ConfigMap
+	SnapshotStoreConfigMap = "configmap"
+	// SnapshotStoreFile saves queue count snapshots in a local file
+	SnapshotStoreFile = "file"
+)
+
+// Snapshot is the configuration for periodically persisting the
+// interceptor's queue counts, so they can be restored after a restart
+type Snapshot struct {
+	// Store is where to save snapshots. It must be one of
+	// SnapshotStoreNone, SnapshotStoreConfigMap or SnapshotStoreFile
+	Store string `envconfig:"KEDA_HTTP_QUEUE_SNAPSHOT_STORE" default:""`
+	// FilePath is the file to save snapshots to when Store is
+	// SnapshotStoreFile. It should be on a volume that outlives the
+	// interceptor container
+	FilePath string `envconfig:"KEDA_HTTP_QUEUE_SNAPSHOT_FILE_PATH" default:"/var/run/keda-http/queue-snapshot.json"`
+	// ConfigMapName is the ConfigMap, in the interceptor's namespace,
+	// to save snapshots to when Store is SnapshotStoreConfigMap. Each
+	// interceptor replica saves its snapshot under its own key
+	ConfigMapName string `envconfig:"KEDA_HTTP_QUEUE_SNAPSHOT_CONFIG_MAP_NAME" default:"keda-http-queue-snapshots"`
+	// Interval is how often to save a snapshot
+	Interval time.Duration `envconfig:"KEDA_HTTP_QUEUE_SNAPSHOT_INTERVAL" default:"5s"`
+	// RestoreTTL is how long counts restored from a snapshot are
+	// kept after startup before they're removed again
+	RestoreTTL time.Duration `envconfig:"KEDA_HTTP_QUEUE_SNAPSHOT_RESTORE_TTL" default:"30s"`
+}
+
+// MustParseSnapshot parses snapshot configuration using envconfig and
+// returns a pointer to the newly created config. Panics if parsing
+// failed
+func MustParseSnapshot() *Snapshot {
+	ret := new(Snapshot)
+	envconfig.MustProcess("", ret)
+	return ret
+}
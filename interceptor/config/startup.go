@@ -0,0 +1,48 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// Startup is the configuration for how the interceptor waits for the
+// routing table and deployment cache to load when it starts
+type Startup struct {
+	// PreloadTimeout is how long the interceptor waits for the first
+	// routing table load and deployment cache sync before it gives up
+	// and exits, so that Kubernetes restarts it. /readyz fails until
+	// both are done. 0 means it waits forever
+	PreloadTimeout time.Duration `envconfig:"KEDA_HTTP_STARTUP_PRELOAD_TIMEOUT" default:"5m"`
+	// PreloadLogInterval is how often the interceptor logs what it's
+	// still waiting for while it waits
+	PreloadLogInterval time.Duration `envconfig:"KEDA_HTTP_STARTUP_PRELOAD_LOG_INTERVAL" default:"10s"`
+}
+
+// Validate returns an error if PreloadTimeout is negative or
+// PreloadLogInterval isn't positive
+func (s *Startup) Validate() error {
+	if s.PreloadTimeout < 0 {
+		return fmt.Errorf(
+			"KEDA_HTTP_STARTUP_PRELOAD_TIMEOUT must not be negative, got %s",
+			s.PreloadTimeout,
+		)
+	}
+	if s.PreloadLogInterval <= 0 {
+		return fmt.Errorf(
+			"KEDA_HTTP_STARTUP_PRELOAD_LOG_INTERVAL must be positive, got %s",
+			s.PreloadLogInterval,
+		)
+	}
+	return nil
+}
+
+// MustParseStartup parses startup configuration using envconfig and
+// returns a pointer to the newly created config. Panics if parsing
+// failed
+func MustParseStartup() *Startup {
+	ret := new(Startup)
+	envconfig.MustProcess("", ret)
+	return ret
+}
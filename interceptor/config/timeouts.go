@@ -24,6 +24,11 @@ type Timeouts struct {
 	// MaxIdleConns is the max number of connections that can be idle in the
 	// interceptor's internal connection pool
 	MaxIdleConns int `envconfig:"KEDA_HTTP_MAX_IDLE_CONNS" default:"100"`
+	// MaxIdleConnsPerHost is the max number of idle connections that
+	// the interceptor keeps open to each backend. Backends that get many
+	// concurrent requests need a high value here, or connections are
+	// closed and reopened under load
+	MaxIdleConnsPerHost int `envconfig:"KEDA_HTTP_MAX_IDLE_CONNS_PER_HOST" default:"100"`
 	// IdleConnTimeout is the timeout after which a connection in the interceptor's
 	// internal connection pool will be closed
 	IdleConnTimeout time.Duration `envconfig:"KEDA_HTTP_IDLE_CONN_TIMEOUT" default:"90s"`
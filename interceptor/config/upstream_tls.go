@@ -0,0 +1,29 @@
+package config
+
+import (
+	"github.com/kelseyhightower/envconfig"
+)
+
+// UpstreamTLS is the configuration for the CA bundles that the
+// certificates of HTTPS backends are verified against
+type UpstreamTLS struct {
+	// CASecretsEnabled toggles whether the interceptor reads the CA
+	// bundles that HTTPScaledObjects name. It needs permission to watch
+	// the Secrets in its namespace. If it's false, connections to
+	// backends that name a CA bundle fail verification, rather than
+	// trust the system roots
+	CASecretsEnabled bool `envconfig:"KEDA_HTTP_UPSTREAM_TLS_CA_SECRETS_ENABLED" default:"false"`
+	// ResyncDurationMS is the interval (in milliseconds) at which the
+	// informer that caches the CA bundle Secrets re-delivers every
+	// Secret it has, as a fallback in case it missed a change
+	ResyncDurationMS int `envconfig:"KEDA_HTTP_UPSTREAM_TLS_RESYNC_DURATION_MS" default:"60000"`
+}
+
+// MustParseUpstreamTLS parses upstream TLS configuration using
+// envconfig and returns a pointer to the newly created config. Panics
+// if parsing failed
+func MustParseUpstreamTLS() *UpstreamTLS {
+	ret := new(UpstreamTLS)
+	envconfig.MustProcess("", ret)
+	return ret
+}
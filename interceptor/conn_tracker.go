@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+
+	kedahttp "github.com/kedacore/http-add-on/pkg/http"
+	"github.com/kedacore/http-add-on/pkg/queue"
+)
+
+type connKey struct{}
+
+type eventStreamKey struct{}
+
+// trackedConn is a client connection that's counted under a queue key
+type trackedConn struct {
+	key      string
+	hijacked bool
+	// streaming is true while one of the connection's requests is a
+	// server-sent events stream that the connection's count covers
+	streaming bool
+}
+
+// connTracker counts the client connections that are open to each
+// host, from the first request on each connection until the connection
+// closes, so that long-lived connections like server-sent events and
+// long polls keep counting while they're open, even between requests.
+//
+// Connections that never send a request to a known host aren't counted,
+// since they can't be attributed to one
+type connTracker struct {
+	q     queue.ConnectionTracker
+	mut   *sync.Mutex
+	conns map[net.Conn]*trackedConn
+}
+
+func newConnTracker(q queue.ConnectionTracker) *connTracker {
+	return &connTracker{
+		q:     q,
+		mut:   new(sync.Mutex),
+		conns: map[net.Conn]*trackedConn{},
+	}
+}
+
+// serverOption returns the kedahttp.ServerOption that lets c see
+// the server's connections open and close
+func (c *connTracker) serverOption() kedahttp.ServerOption {
+	return func(srv *http.Server) {
+		srv.ConnContext = func(ctx context.Context, conn net.Conn) context.Context {
+			return context.WithValue(ctx, connKey{}, conn)
+		}
+		srv.ConnState = c.connState
+	}
+}
+
+func (c *connTracker) connState(conn net.Conn, state http.ConnState) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	tracked, ok := c.conns[conn]
+	if !ok {
+		return
+	}
+	switch state {
+	case http.StateHijacked:
+		// the server stops tracking hijacked connections, like
+		// websockets, so they're released when their request ends
+		tracked.hijacked = true
+	case http.StateClosed:
+		c.q.ResizeConnections(tracked.key, -1)
+		delete(c.conns, conn)
+	}
+}
+
+// attribute counts conn under key. If conn was counted under another
+// key, it's moved to key
+func (c *connTracker) attribute(conn net.Conn, key string) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	tracked, ok := c.conns[conn]
+	if ok && tracked.key == key {
+		return
+	}
+	if ok {
+		c.q.ResizeConnections(tracked.key, -1)
+		tracked.key = key
+	} else {
+		c.conns[conn] = &trackedConn{key: key}
+	}
+	c.q.ResizeConnections(key, +1)
+}
+
+// startEventStream counts a server-sent events stream for key on conn
+// until the returned func is called. The first stream on a connection
+// that's counted under key is covered by the connection's own count,
+// and any others, like more streams on the same HTTP/2 connection,
+// count as one connection each
+func (c *connTracker) startEventStream(conn net.Conn, key string) func() {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	tracked, ok := c.conns[conn]
+	if ok && tracked.key == key && !tracked.streaming {
+		tracked.streaming = true
+		return func() {
+			c.mut.Lock()
+			defer c.mut.Unlock()
+			tracked.streaming = false
+		}
+	}
+	c.q.ResizeConnections(key, +1)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.q.ResizeConnections(key, -1)
+		})
+	}
+}
+
+// trackEventStream counts the server-sent events stream of the request
+// with ctx as an open connection until the returned func is called.
+// It doesn't count anything if the request didn't go through
+// connTrackerMiddleware
+func trackEventStream(ctx context.Context) func() {
+	start, ok := ctx.Value(eventStreamKey{}).(func() func())
+	if !ok {
+		return func() {}
+	}
+	return start()
+}
+
+// releaseHijacked stops counting conn if it was hijacked
+func (c *connTracker) releaseHijacked(conn net.Conn) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	tracked, ok := c.conns[conn]
+	if !ok || !tracked.hijacked {
+		return
+	}
+	c.q.ResizeConnections(tracked.key, -1)
+	delete(c.conns, conn)
+}
+
+// connTrackerMiddleware attributes the connection of each request to
+// the request's queue key, using tracker. Requests that were routed to
+// a canary are counted under the host's canary queue key
+func connTrackerMiddleware(tracker *connTracker, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, ok := r.Context().Value(connKey{}).(net.Conn)
+		host, err := getHost(r)
+		if !ok || err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := queueKey(r.Context(), host)
+		tracker.attribute(conn, key)
+		defer tracker.releaseHijacked(conn)
+		r = r.WithContext(context.WithValue(
+			r.Context(),
+			eventStreamKey{},
+			func() func() {
+				return tracker.startEventStream(conn, key)
+			},
+		))
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kedacore/http-add-on/pkg/queue"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnTracker(t *testing.T) {
+	const host = "TestConnTracker.testing"
+	r := require.New(t)
+	q := queue.NewMemory()
+	q.Ensure(host)
+	tracker := newConnTracker(q)
+	srv := httptest.NewUnstartedServer(connTrackerMiddleware(
+		tracker,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(200)
+		}),
+	))
+	tracker.serverOption()(srv.Config)
+	srv.Start()
+	defer srv.Close()
+
+	connections := func() int {
+		cts, err := q.Current()
+		r.NoError(err)
+		return cts.Host(host).Connections
+	}
+
+	transport := &http.Transport{}
+	cl := &http.Client{Transport: transport}
+	get := func() {
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		r.NoError(err)
+		req.Host = host
+		res, err := cl.Do(req)
+		r.NoError(err)
+		ioutil.ReadAll(res.Body)
+		r.NoError(res.Body.Close())
+	}
+
+	// the connection still counts once its request is done,
+	// and reusing it for more requests doesn't count it again
+	get()
+	get()
+	r.Equal(1, connections())
+
+	// the connection stops counting once it's closed
+	transport.CloseIdleConnections()
+	r.Eventually(func() bool {
+		return connections() == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestConnTrackerEventStreams(t *testing.T) {
+	const host = "TestConnTrackerEventStreams.testing"
+	r := require.New(t)
+	q := queue.NewMemory()
+	q.Ensure(host)
+	tracker := newConnTracker(q)
+	connections := func() int {
+		cts, err := q.Current()
+		r.NoError(err)
+		return cts.Host(host).Connections
+	}
+	conn, other := net.Pipe()
+	defer conn.Close()
+	defer other.Close()
+	tracker.attribute(conn, host)
+	r.Equal(1, connections())
+
+	// the connection's own count covers its first stream
+	endFirst := tracker.startEventStream(conn, host)
+	r.Equal(1, connections())
+	// more streams on the same connection, like with HTTP/2,
+	// count one each
+	endSecond := tracker.startEventStream(conn, host)
+	endThird := tracker.startEventStream(conn, host)
+	r.Equal(3, connections())
+	endSecond()
+	endSecond()
+	r.Equal(2, connections())
+	endThird()
+	endFirst()
+	r.Equal(1, connections())
+
+	// the connection covers a stream again once its first one ended
+	endFirst = tracker.startEventStream(conn, host)
+	r.Equal(1, connections())
+	endFirst()
+
+	tracker.connState(conn, http.StateClosed)
+	r.Equal(0, connections())
+}
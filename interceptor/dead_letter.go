@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/interceptor/config"
+)
+
+// deadLetterEventType is the type of the CloudEvents that dead letters
+// are sent as, with DeadLetterFormatCloudEvents
+const deadLetterEventType = "sh.keda.http.request.failed"
+
+// deadLetterHeaderDenylist are the request headers that are left out
+// of dead letters, since they carry credentials
+var deadLetterHeaderDenylist = map[string]struct{}{
+	"Authorization":       {},
+	"Cookie":              {},
+	"Proxy-Authorization": {},
+}
+
+// deadLetter describes a request that the interceptor counted toward
+// scaling, but then failed to get a response from the backend for
+type deadLetter struct {
+	Host       string      `json:"host"`
+	Method     string      `json:"method"`
+	URI        string      `json:"uri"`
+	Header     http.Header `json:"header"`
+	Reason     dropReason  `json:"reason"`
+	RequestID  string      `json:"requestID,omitempty"`
+	ReceivedAt time.Time   `json:"receivedAt"`
+	// Body is encoded as base64 in JSON
+	Body          []byte `json:"body,omitempty"`
+	BodyTruncated bool   `json:"bodyTruncated,omitempty"`
+}
+
+// deadLetterer sends dead letters to the dead letter endpoint in the
+// background
+type deadLetterer struct {
+	lggr   logr.Logger
+	cfg    config.DeadLetter
+	source string
+	cl     *http.Client
+	// inFlight holds a token for every dead
+	// letter that's in flight
+	inFlight chan struct{}
+}
+
+// newDeadLetterer creates a deadLetterer that sends dead letters as cfg
+// says. CloudEvents are sent with source as their source
+func newDeadLetterer(
+	lggr logr.Logger,
+	cfg config.DeadLetter,
+	source string,
+	transport http.RoundTripper,
+) *deadLetterer {
+	return &deadLetterer{
+		lggr:   lggr.WithName("deadLetterer"),
+		cfg:    cfg,
+		source: source,
+		cl: &http.Client{
+			Transport: transport,
+			Timeout:   cfg.Timeout,
+		},
+		inFlight: make(chan struct{}, cfg.MaxConcurrent),
+	}
+}
+
+// send POSTs letter to the dead letter endpoint in the background.
+// Returns false if it wasn't sent because there are too many dead
+// letters in flight already
+func (d *deadLetterer) send(letter *deadLetter) bool {
+	select {
+	case d.inFlight <- struct{}{}:
+	default:
+		return false
+	}
+	var payload interface{} = letter
+	contentType := "application/json"
+	if d.cfg.Format == config.DeadLetterFormatCloudEvents {
+		payload = newCloudEvent(d.source, deadLetterEventType, letter.Host, letter)
+		contentType = cloudEventsContentType
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		<-d.inFlight
+		d.lggr.Error(err, "encoding dead letter", "host", letter.Host)
+		return true
+	}
+	req, err := http.NewRequestWithContext(
+		context.Background(),
+		"POST",
+		d.cfg.URL,
+		bytes.NewReader(body),
+	)
+	if err != nil {
+		<-d.inFlight
+		d.lggr.Error(err, "creating dead letter request", "url", d.cfg.URL)
+		return true
+	}
+	req.Header.Set("Content-Type", contentType)
+	go func() {
+		defer func() { <-d.inFlight }()
+		res, err := d.cl.Do(req)
+		if err != nil {
+			d.lggr.Error(err, "sending dead letter", "host", letter.Host)
+			return
+		}
+		defer res.Body.Close()
+		io.Copy(ioutil.Discard, res.Body)
+		if res.StatusCode >= 300 {
+			d.lggr.Info(
+				"dead letter endpoint rejected dead letter",
+				"host",
+				letter.Host,
+				"status",
+				res.StatusCode,
+			)
+		}
+	}()
+	return true
+}
+
+// bodyCapture keeps the first max bytes that are read from a request
+// body, so that they can be put in a dead letter after the body was
+// forwarded
+type bodyCapture struct {
+	io.ReadCloser
+	max       int64
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (b *bodyCapture) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if room := b.max - int64(b.buf.Len()); int64(n) > room {
+		b.buf.Write(p[:room])
+		b.truncated = true
+	} else {
+		b.buf.Write(p[:n])
+	}
+	return n, err
+}
+
+// deadLetterMiddleware sends a dead letter, with d, for every request
+// that the handlers further down the chain mark as dropped because
+// its backend didn't start in time or failed it. Those are the
+// requests that were counted toward scaling and then lost, as opposed
+// to the ones that were turned away before, which clients can simply
+// retry. Requests whose client went away aren't reported.
+//
+// It must go behind dropCounterMiddleware, which lets handlers mark
+// requests as dropped
+func deadLetterMiddleware(d *deadLetterer, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedAt := time.Now()
+		var body *bodyCapture
+		if d.cfg.MaxBodyBytes > 0 && r.Body != nil && r.Body != http.NoBody {
+			body = &bodyCapture{ReadCloser: r.Body, max: d.cfg.MaxBodyBytes}
+			r.Body = body
+		}
+		next.ServeHTTP(w, r)
+
+		reason := droppedReason(r.Context())
+		if r.Context().Err() != nil ||
+			(reason != dropReasonColdStartTimeout &&
+				reason != dropReasonUpstream5xx &&
+				reason != dropReasonUpstreamTimeout) {
+			return
+		}
+		host, _ := getHost(r)
+		letter := &deadLetter{
+			Host:       host,
+			Method:     r.Method,
+			URI:        r.URL.RequestURI(),
+			Header:     http.Header{},
+			Reason:     reason,
+			RequestID:  requestIDFromContext(r.Context()),
+			ReceivedAt: receivedAt.UTC(),
+		}
+		for name, vals := range r.Header {
+			if _, ok := deadLetterHeaderDenylist[name]; !ok {
+				letter.Header[name] = vals
+			}
+		}
+		if body != nil {
+			// requests that never got to the backend weren't read,
+			// so the rest of their body is read now, up to the limit.
+			// a body that was closed already just reads nothing more
+			if !body.truncated {
+				io.Copy(ioutil.Discard, io.LimitReader(body, d.cfg.MaxBodyBytes+1))
+			}
+			letter.Body = body.buf.Bytes()
+			letter.BodyTruncated = body.truncated
+		}
+		if !d.send(letter) {
+			d.lggr.Info(
+				"too many dead letters in flight, dropping one",
+				"host",
+				host,
+				"requestID",
+				letter.RequestID,
+			)
+		}
+	})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/interceptor/config"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestDeadLetterSink starts a server that sends every request body
+// it gets, along with its content type, to the returned channel
+func newTestDeadLetterSink(t *testing.T) (*httptest.Server, <-chan [2]string) {
+	t.Helper()
+	letters := make(chan [2]string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		letters <- [2]string{r.Header.Get("Content-Type"), string(body)}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, letters
+}
+
+func TestDeadLetterMiddleware(t *testing.T) {
+	const host = "TestDeadLetterMiddleware.testing"
+	r := require.New(t)
+	sink, letters := newTestDeadLetterSink(t)
+	d := newDeadLetterer(logr.Discard(), config.DeadLetter{
+		URL:           sink.URL,
+		Format:        config.DeadLetterFormatJSON,
+		MaxBodyBytes:  5,
+		MaxConcurrent: 10,
+		Timeout:       time.Second,
+	}, "/testing", http.DefaultTransport)
+	hdl := dropCounterMiddleware(
+		newDropCounter(),
+		deadLetterMiddleware(d, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/upstream":
+				// the backend read the whole body before it failed
+				ioutil.ReadAll(r.Body)
+				markDropped(r.Context(), dropReasonUpstream5xx)
+				w.WriteHeader(502)
+			case "/coldstart":
+				markDropped(r.Context(), dropReasonColdStartTimeout)
+				w.WriteHeader(502)
+			case "/noroute":
+				markDropped(r.Context(), dropReasonNoRoute)
+				w.WriteHeader(404)
+			}
+		})),
+	)
+
+	req := httptest.NewRequest("POST", "/upstream?a=b", strings.NewReader("hello world"))
+	req.Host = host
+	req.Header.Set("Authorization", "Bearer s3cret")
+	req.Header.Set("X-Job", "42")
+	hdl.ServeHTTP(httptest.NewRecorder(), req)
+	letter := <-letters
+	r.Equal("application/json", letter[0])
+	got := deadLetter{}
+	r.NoError(json.Unmarshal([]byte(letter[1]), &got))
+	r.Equal(host, got.Host)
+	r.Equal("POST", got.Method)
+	r.Equal("/upstream?a=b", got.URI)
+	r.Equal(dropReasonUpstream5xx, got.Reason)
+	r.Equal("42", got.Header.Get("X-Job"))
+	r.Empty(got.Header.Get("Authorization"))
+	r.Equal("hello", string(got.Body))
+	r.True(got.BodyTruncated)
+
+	// bodies that the backend never got are read for the letter
+	req = httptest.NewRequest("POST", "/coldstart", strings.NewReader("hi"))
+	req.Host = host
+	hdl.ServeHTTP(httptest.NewRecorder(), req)
+	letter = <-letters
+	got = deadLetter{}
+	r.NoError(json.Unmarshal([]byte(letter[1]), &got))
+	r.Equal(dropReasonColdStartTimeout, got.Reason)
+	r.Equal("hi", string(got.Body))
+	r.False(got.BodyTruncated)
+
+	// requests that were turned away aren't reported
+	req = httptest.NewRequest("GET", "/noroute", nil)
+	req.Host = host
+	hdl.ServeHTTP(httptest.NewRecorder(), req)
+	select {
+	case letter := <-letters:
+		r.Failf("unexpected dead letter", "%s", letter[1])
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestDeadLetterCloudEvents(t *testing.T) {
+	const host = "TestDeadLetterCloudEvents.testing"
+	r := require.New(t)
+	sink, letters := newTestDeadLetterSink(t)
+	d := newDeadLetterer(logr.Discard(), config.DeadLetter{
+		URL:           sink.URL,
+		Format:        config.DeadLetterFormatCloudEvents,
+		MaxConcurrent: 10,
+		Timeout:       time.Second,
+	}, "/testing", http.DefaultTransport)
+	hdl := dropCounterMiddleware(
+		newDropCounter(),
+		deadLetterMiddleware(d, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			markDropped(r.Context(), dropReasonColdStartTimeout)
+			w.WriteHeader(502)
+		})),
+	)
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader("hello"))
+	req.Host = host
+	hdl.ServeHTTP(httptest.NewRecorder(), req)
+	letter := <-letters
+	r.Equal(cloudEventsContentType, letter[0])
+	event := struct {
+		cloudEvent
+		Data deadLetter `json:"data"`
+	}{}
+	r.NoError(json.Unmarshal([]byte(letter[1]), &event))
+	r.Equal("1.0", event.SpecVersion)
+	r.NotEmpty(event.ID)
+	r.Equal("/testing", event.Source)
+	r.Equal(deadLetterEventType, event.Type)
+	r.Equal(host, event.Subject)
+	r.Equal(dropReasonColdStartTimeout, event.Data.Reason)
+	// bodies are left out by default
+	r.Empty(event.Data.Body)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// dropReason is why the interceptor didn't get a response from the
+// backend to the client
+type dropReason string
+
+const (
+	// dropReasonNoRoute is for requests to hosts that aren't in the
+	// routing table
+	dropReasonNoRoute dropReason = "no_route"
+	// dropReasonColdStartTimeout is for requests whose backend didn't
+	// become ready in time
+	dropReasonColdStartTimeout dropReason = "cold_start_timeout"
+	// dropReasonUpstream5xx is for requests that the backend failed,
+	// either with a 5xx response or before it sent one
+	dropReasonUpstream5xx dropReason = "upstream_5xx"
+	// dropReasonUpstreamTimeout is for requests that the backend
+	// didn't respond to in time
+	dropReasonUpstreamTimeout dropReason = "upstream_timeout"
+	// dropReasonClientCanceled is for requests whose client went
+	// away before they got a response
+	dropReasonClientCanceled dropReason = "client_canceled"
+	// dropReasonBodyTooLarge is for requests or responses whose body
+	// was over the limit
+	dropReasonBodyTooLarge dropReason = "body_too_large"
+	// dropReasonRateLimited is for requests that the rate limiter
+	// rejected
+	dropReasonRateLimited dropReason = "rate_limited"
+	// dropReasonOverloaded is for requests that were rejected because
+	// too many others were waiting for their backends
+	dropReasonOverloaded dropReason = "overloaded"
+)
+
+type dropReasonKey struct{}
+
+// markDropped records that the request that ctx belongs to was dropped
+// for reason, replacing any reason that was recorded before. It does
+// nothing if the request isn't being counted
+func markDropped(ctx context.Context, reason dropReason) {
+	if ret, ok := ctx.Value(dropReasonKey{}).(*dropReason); ok {
+		*ret = reason
+	}
+}
+
+// droppedReason returns the reason that the request that ctx belongs
+// to was dropped for, so far. It's empty if the request wasn't dropped,
+// or isn't being counted
+func droppedReason(ctx context.Context) dropReason {
+	if ret, ok := ctx.Value(dropReasonKey{}).(*dropReason); ok {
+		return *ret
+	}
+	return ""
+}
+
+// dropCounter counts the requests that the interceptor dropped, by host
+// and reason, so that scaling problems can be told apart from errors in
+// the applications
+type dropCounter struct {
+	mut    *sync.Mutex
+	counts map[string]map[dropReason]int64
+}
+
+func newDropCounter() *dropCounter {
+	return &dropCounter{
+		mut:    new(sync.Mutex),
+		counts: map[string]map[dropReason]int64{},
+	}
+}
+
+// record counts a request to host that was dropped for reason
+func (d *dropCounter) record(host string, reason dropReason) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	reasons, ok := d.counts[host]
+	if !ok {
+		reasons = map[dropReason]int64{}
+		d.counts[host] = reasons
+	}
+	reasons[reason]++
+}
+
+// MarshalJSON returns the number of dropped requests to each host, by
+// reason
+func (d *dropCounter) MarshalJSON() ([]byte, error) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	return json.Marshal(d.counts)
+}
+
+// dropCounterMiddleware counts the requests that the handlers further
+// down the chain mark as dropped with markDropped. Requests whose
+// client went away count as client_canceled, whatever happened to
+// them after that
+func dropCounterMiddleware(drops *dropCounter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reason := new(dropReason)
+		next.ServeHTTP(
+			w,
+			r.WithContext(context.WithValue(r.Context(), dropReasonKey{}, reason)),
+		)
+		if r.Context().Err() != nil {
+			*reason = dropReasonClientCanceled
+		}
+		if *reason == "" {
+			return
+		}
+		host, _ := getHost(r)
+		drops.record(host, *reason)
+	})
+}
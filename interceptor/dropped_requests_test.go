@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/interceptor/config"
+	kedanet "github.com/kedacore/http-add-on/pkg/net"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDropCounterMiddleware(t *testing.T) {
+	const (
+		host        = "TestDropCounterMiddleware.testing"
+		limitedHost = "limited.TestDropCounterMiddleware.testing"
+	)
+	r := require.New(t)
+
+	originHdl := kedanet.NewTestHTTPHandlerWrapper(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(500)
+			w.Write([]byte("oops"))
+		}),
+	)
+	srv, originURL, err := kedanet.StartTestServer(originHdl)
+	r.NoError(err)
+	defer srv.Close()
+	portInt, err := strconv.Atoi(originURL.Port())
+	r.NoError(err)
+	routingTable := routing.NewTable()
+	r.NoError(routingTable.AddTarget(host, routing.Target{
+		Service:    strings.Split(originURL.Host, ":")[0],
+		Port:       portInt,
+		Deployment: "testdepl",
+	}))
+
+	timeouts := defaultTimeouts()
+	waitFunc := func(context.Context, routing.Target) error {
+		return nil
+	}
+	fwdHdl := newForwardingHandler(
+		logr.Discard(),
+		routingTable,
+		retryDialContextFunc(timeouts, timeouts.DefaultBackoff()),
+		waitFunc,
+		forwardingConfig{
+			waitTimeout:       timeouts.DeploymentReplicas,
+			respHeaderTimeout: timeouts.ResponseHeader,
+		},
+	)
+	limiter := newRateLimiter(config.RateLimit{
+		RequestsPerSecond: 1,
+		Burst:             1,
+	})
+	drops := newDropCounter()
+	hdl := dropCounterMiddleware(
+		drops,
+		rateLimitMiddleware(logr.Discard(), limiter, fwdHdl),
+	)
+
+	// 5xx responses from the backend
+	res, req, err := reqAndRes("/testfwd")
+	r.NoError(err)
+	req.Host = host
+	hdl.ServeHTTP(res, req)
+	r.Equal(500, res.Code)
+
+	// hosts that aren't in the routing table, the first time, and
+	// the rate limiter the second time
+	for i := 0; i < 2; i++ {
+		res, req, err = reqAndRes("/testfwd")
+		r.NoError(err)
+		req.Host = limitedHost
+		hdl.ServeHTTP(res, req)
+	}
+	r.Equal(http.StatusTooManyRequests, res.Code)
+
+	// clients that go away before they get a response, whatever
+	// happens to their requests
+	ctx, done := context.WithCancel(context.Background())
+	done()
+	req = httptest.NewRequest("GET", "/testfwd", nil).WithContext(ctx)
+	req.Host = host
+	hdl.ServeHTTP(httptest.NewRecorder(), req)
+
+	resJSON, err := json.Marshal(drops)
+	r.NoError(err)
+	counts := map[string]map[dropReason]int64{}
+	r.NoError(json.Unmarshal(resJSON, &counts))
+	r.Equal(map[string]map[dropReason]int64{
+		host: {
+			dropReasonUpstream5xx:    1,
+			dropReasonClientCanceled: 1,
+		},
+		limitedHost: {
+			dropReasonNoRoute:     1,
+			dropReasonRateLimited: 1,
+		},
+	}, counts)
+
+	// requests that aren't counted can still be marked
+	markDropped(context.Background(), dropReasonNoRoute)
+}
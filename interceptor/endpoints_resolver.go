@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/k8s"
+	kedanet "github.com/kedacore/http-add-on/pkg/net"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	v1 "k8s.io/api/core/v1"
+)
+
+// endpointsResolver picks the address to dial for a backend Service
+// from the Service's Endpoints, instead of resolving the Service's DNS
+// name, so that requests can go to a pod as soon as it's ready, even
+// before DNS and kube-proxy know about it. Each Service's ready pods are
+// picked in turn.
+//
+// The interceptor only knows a backend's Service port, but Endpoints
+// hold the pods' ports, which can be different. If the Endpoints have a
+// single port, that's the one that's dialed. Otherwise, the port with
+// the same number as the Service port is. If there's no such port, or
+// no ready pods, the resolver falls back to the Service's DNS name.
+//
+// Targets that are pinned to a revision only get the ready pods of
+// that revision, going by the pods' labels in pods. They never fall
+// back to the DNS name, which can reach pods of any revision. A nil
+// pods ignores revisions.
+//
+// A nil *endpointsResolver always dials the DNS name
+type endpointsResolver struct {
+	lggr  logr.Logger
+	cache k8s.EndpointsCache
+	pods  k8s.PodCache
+	mut   *sync.Mutex
+	// next is the index of the ready address to pick
+	// next, for each Service and pinned revision
+	next map[string]int
+}
+
+func newEndpointsResolver(
+	lggr logr.Logger,
+	cache k8s.EndpointsCache,
+	pods k8s.PodCache,
+) *endpointsResolver {
+	return &endpointsResolver{
+		lggr:  lggr.WithName("endpointsResolver"),
+		cache: cache,
+		pods:  pods,
+		mut:   new(sync.Mutex),
+		next:  map[string]int{},
+	}
+}
+
+// resolve returns the pod address to dial instead of addr, which is a
+// Service's host:port, or addr itself if there isn't one
+func (e *endpointsResolver) resolve(addr string) string {
+	ret, _ := e.resolveRevision(addr, nil)
+	return ret
+}
+
+// resolveRevision is like resolve, but only picks the pods of the
+// revision that pin pins. If pin isn't nil, and there's no ready pod
+// of that revision, it returns an error instead of addr
+func (e *endpointsResolver) resolveRevision(
+	addr string,
+	pin *routing.RevisionPin,
+) (string, error) {
+	if e == nil || e.pods == nil {
+		pin = nil
+	}
+	fallback := func() (string, error) {
+		if pin != nil {
+			return "", fmt.Errorf(
+				"no ready pods of revision %s=%s for %s",
+				pin.Label,
+				pin.Value,
+				addr,
+			)
+		}
+		return addr, nil
+	}
+	if e == nil {
+		return addr, nil
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fallback()
+	}
+	// the cache only holds the Endpoints in the interceptor's
+	// namespace, so only plain Service names can be resolved
+	if strings.Contains(host, ".") || net.ParseIP(host) != nil {
+		return fallback()
+	}
+	svcPort, err := strconv.Atoi(portStr)
+	if err != nil {
+		return fallback()
+	}
+	endpts, err := e.cache.Get(host)
+	if err != nil {
+		return fallback()
+	}
+	key := host
+	var keep func(v1.EndpointAddress) bool
+	if pin != nil {
+		key = fmt.Sprintf("%s#%s=%s", host, pin.Label, pin.Value)
+		keep = func(addr v1.EndpointAddress) bool {
+			if addr.TargetRef == nil || addr.TargetRef.Kind != "Pod" {
+				return false
+			}
+			podLabels, ok := e.pods.Labels(addr.TargetRef.Name)
+			return ok && pin.Matches(podLabels)
+		}
+	}
+	addrs := readyPodAddrs(&endpts, int32(svcPort), keep)
+	if len(addrs) == 0 {
+		return fallback()
+	}
+
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	idx := e.next[key] % len(addrs)
+	e.next[key] = idx + 1
+	return addrs[idx], nil
+}
+
+// wrap returns a kedanet.DialContextFunc that dials the address that e
+// resolves each address to, using dialCtxFunc
+func (e *endpointsResolver) wrap(dialCtxFunc kedanet.DialContextFunc) kedanet.DialContextFunc {
+	if e == nil {
+		return dialCtxFunc
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		resolved, err := e.resolveRevision(addr, revisionFromContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		if resolved != addr {
+			e.lggr.V(1).Info(
+				"dialing pod from endpoints",
+				"address",
+				addr,
+				"podAddress",
+				resolved,
+			)
+		}
+		return dialCtxFunc(ctx, network, resolved)
+	}
+}
+
+// readyPodAddrs returns the host:port of each ready address in endpts
+// that keep returns true for, for the Service port svcPort. A nil keep
+// keeps every address. See endpointsResolver for how the pod port is
+// picked
+func readyPodAddrs(
+	endpts *v1.Endpoints,
+	svcPort int32,
+	keep func(v1.EndpointAddress) bool,
+) []string {
+	ret := []string{}
+	for _, subset := range endpts.Subsets {
+		port, ok := podPort(subset.Ports, svcPort)
+		if !ok {
+			continue
+		}
+		portStr := strconv.Itoa(int(port))
+		for _, addr := range subset.Addresses {
+			if keep != nil && !keep(addr) {
+				continue
+			}
+			ret = append(ret, net.JoinHostPort(addr.IP, portStr))
+		}
+	}
+	return ret
+}
+
+type revisionKey struct{}
+
+// withRevision returns a copy of ctx that holds pin, so that the
+// connections that are dialed with it only go to the pods of pin's
+// revision
+func withRevision(ctx context.Context, pin *routing.RevisionPin) context.Context {
+	return context.WithValue(ctx, revisionKey{}, pin)
+}
+
+// revisionFromContext returns the revision that ctx's connections are
+// pinned to, or nil if they aren't
+func revisionFromContext(ctx context.Context) *routing.RevisionPin {
+	pin, _ := ctx.Value(revisionKey{}).(*routing.RevisionPin)
+	return pin
+}
+
+func podPort(ports []v1.EndpointPort, svcPort int32) (int32, bool) {
+	if len(ports) == 1 {
+		return ports[0].Port, true
+	}
+	for _, port := range ports {
+		if port.Port == svcPort {
+			return port.Port, true
+		}
+	}
+	return 0, false
+}
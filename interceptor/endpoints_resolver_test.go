@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/k8s"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEndpointsResolver(t *testing.T) {
+	r := require.New(t)
+	endpointsCache := k8s.NewFakeEndpointsCache()
+	endpointsCache.Set("svc", corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc"},
+		Subsets: []corev1.EndpointSubset{
+			{
+				Addresses: []corev1.EndpointAddress{
+					{IP: "10.0.0.1"},
+					{IP: "10.0.0.2"},
+				},
+				NotReadyAddresses: []corev1.EndpointAddress{{IP: "10.0.0.3"}},
+				Ports:             []corev1.EndpointPort{{Port: 8080}},
+			},
+		},
+	})
+	endpointsCache.Set("multiport", corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "multiport"},
+		Subsets: []corev1.EndpointSubset{
+			{
+				Addresses: []corev1.EndpointAddress{{IP: "10.0.1.1"}},
+				Ports: []corev1.EndpointPort{
+					{Name: "http", Port: 80},
+					{Name: "metrics", Port: 9090},
+				},
+			},
+		},
+	})
+	endpointsCache.Set("empty", corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "empty"},
+	})
+	resolver := newEndpointsResolver(logr.Discard(), endpointsCache, nil)
+
+	// ready pods are picked in turn, on the pods' port
+	r.Equal("10.0.0.1:8080", resolver.resolve("svc:80"))
+	r.Equal("10.0.0.2:8080", resolver.resolve("svc:80"))
+	r.Equal("10.0.0.1:8080", resolver.resolve("svc:80"))
+
+	// with more than one port, the one that matches the Service port
+	// is used, and without a match the DNS name is
+	r.Equal("10.0.1.1:80", resolver.resolve("multiport:80"))
+	r.Equal("multiport:8080", resolver.resolve("multiport:8080"))
+
+	// Services without ready pods, unknown Services and names
+	// outside the interceptor's namespace use the DNS name
+	r.Equal("empty:80", resolver.resolve("empty:80"))
+	r.Equal("missing:80", resolver.resolve("missing:80"))
+	r.Equal("svc.other:80", resolver.resolve("svc.other:80"))
+
+	// a nil resolver always uses the DNS name
+	var nilResolver *endpointsResolver
+	r.Equal("svc:80", nilResolver.resolve("svc:80"))
+
+	// the wrapped dial function gets the resolved address
+	dialed := ""
+	dialCtxFunc := resolver.wrap(func(_ context.Context, _, addr string) (net.Conn, error) {
+		dialed = addr
+		return nil, nil
+	})
+	_, err := dialCtxFunc(context.Background(), "tcp", "svc:80")
+	r.NoError(err)
+	r.Equal("10.0.0.2:8080", dialed)
+}
+
+func TestEndpointsResolverRevision(t *testing.T) {
+	r := require.New(t)
+	podRef := func(name string) *corev1.ObjectReference {
+		return &corev1.ObjectReference{Kind: "Pod", Name: name}
+	}
+	endpointsCache := k8s.NewFakeEndpointsCache()
+	endpointsCache.Set("svc", corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc"},
+		Subsets: []corev1.EndpointSubset{
+			{
+				Addresses: []corev1.EndpointAddress{
+					{IP: "10.0.0.1", TargetRef: podRef("blue-1")},
+					{IP: "10.0.0.2", TargetRef: podRef("green-1")},
+					{IP: "10.0.0.3", TargetRef: podRef("blue-2")},
+					{IP: "10.0.0.4"},
+				},
+				Ports: []corev1.EndpointPort{{Port: 8080}},
+			},
+		},
+	})
+	pods := k8s.NewFakePodCache()
+	pods.Set("blue-1", map[string]string{"version": "blue"})
+	pods.Set("blue-2", map[string]string{"version": "blue"})
+	pods.Set("green-1", map[string]string{"version": "green"})
+	resolver := newEndpointsResolver(logr.Discard(), endpointsCache, pods)
+	blue := &routing.RevisionPin{Label: "version", Value: "blue"}
+	green := &routing.RevisionPin{Label: "version", Value: "green"}
+
+	// only the pinned revision's pods are picked, in turn
+	for _, want := range []string{"10.0.0.1:8080", "10.0.0.3:8080", "10.0.0.1:8080"} {
+		addr, err := resolver.resolveRevision("svc:80", blue)
+		r.NoError(err)
+		r.Equal(want, addr)
+	}
+	addr, err := resolver.resolveRevision("svc:80", green)
+	r.NoError(err)
+	r.Equal("10.0.0.2:8080", addr)
+
+	// without a ready pod of the revision, nothing is dialed,
+	// rather than a pod of another revision
+	_, err = resolver.resolveRevision("svc:80", &routing.RevisionPin{Label: "version", Value: "red"})
+	r.Error(err)
+	_, err = resolver.resolveRevision("missing:80", blue)
+	r.Error(err)
+
+	// the wrapped dial function reads the revision from the context
+	dialed := ""
+	dialCtxFunc := resolver.wrap(func(_ context.Context, _, addr string) (net.Conn, error) {
+		dialed = addr
+		return nil, nil
+	})
+	_, err = dialCtxFunc(withRevision(context.Background(), green), "tcp", "svc:80")
+	r.NoError(err)
+	r.Equal("10.0.0.2:8080", dialed)
+
+	// without a pod cache, revisions are ignored
+	resolver = newEndpointsResolver(logr.Discard(), endpointsCache, nil)
+	addr, err = resolver.resolveRevision("svc:80", &routing.RevisionPin{Label: "version", Value: "red"})
+	r.NoError(err)
+	r.Equal("10.0.0.1:8080", addr)
+}
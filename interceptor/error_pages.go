@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listerv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// the classes of errors that can have custom error pages. Each one is
+// also the key of the page's body in an error pages ConfigMap
+const (
+	// errorClassColdStartTimeout is for requests whose backend
+	// didn't become ready in time
+	errorClassColdStartTimeout = "coldStartTimeout"
+	// errorClassNoRoute is for requests to hosts that aren't in the
+	// routing table. Only the default error pages can have one
+	errorClassNoRoute = "noRoute"
+	// errorClassUpstream is for requests that the backend
+	// failed before sending a response
+	errorClassUpstream = "upstreamError"
+	// errorClassUpstreamTimeout is for requests that the backend
+	// didn't respond to in time
+	errorClassUpstreamTimeout = "upstreamTimeout"
+	// errorClassWaitingRoom is for requests from browsers that
+	// get a waiting page while their backend starts
+	errorClassWaitingRoom = "waitingRoom"
+)
+
+// the suffixes of the keys for a page's status code and content
+// type in an error pages ConfigMap
+const (
+	errorPageStatusSuffix      = ".status"
+	errorPageContentTypeSuffix = ".contentType"
+)
+
+// errorPage is a custom response for a class of errors
+type errorPage struct {
+	statusCode  int
+	contentType string
+	body        []byte
+}
+
+// write writes p to w
+func (p *errorPage) write(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", p.contentType)
+	w.WriteHeader(p.statusCode)
+	w.Write(p.body)
+}
+
+// errorPageFromConfigMap returns the page for class in cm, and true,
+// if cm has a body for class. If cm doesn't set the page's status
+// code, defaultCode is used. If it doesn't set the content type, it's
+// detected from the body
+func errorPageFromConfigMap(
+	cm *corev1.ConfigMap,
+	class string,
+	defaultCode int,
+) (*errorPage, bool, error) {
+	body, ok := cm.Data[class]
+	if !ok {
+		return nil, false, nil
+	}
+	ret := &errorPage{
+		statusCode:  defaultCode,
+		contentType: cm.Data[class+errorPageContentTypeSuffix],
+		body:        []byte(body),
+	}
+	if ret.contentType == "" {
+		ret.contentType = http.DetectContentType(ret.body)
+	}
+	if codeStr, ok := cm.Data[class+errorPageStatusSuffix]; ok {
+		code, err := strconv.Atoi(codeStr)
+		if err != nil || code < 100 || code > 599 {
+			return nil, false, fmt.Errorf(
+				"invalid status code %q for %s in ConfigMap %s",
+				codeStr,
+				class,
+				cm.Name,
+			)
+		}
+		ret.statusCode = code
+	}
+	return ret, true, nil
+}
+
+// errorPages holds the custom error pages from the ConfigMaps in a
+// namespace, kept up to date by a shared informer. Call start to run
+// the informer.
+//
+// A nil *errorPages is valid, and always writes the built-in responses
+type errorPages struct {
+	lggr             logr.Logger
+	factory          informers.SharedInformerFactory
+	informer         cache.SharedIndexInformer
+	lister           listerv1.ConfigMapNamespaceLister
+	defaultConfigMap string
+}
+
+// newErrorPages creates a new errorPages for the ConfigMaps in
+// namespace ns. defaultConfigMap is the name of the ConfigMap with the
+// pages for targets that don't have their own, and may be empty
+func newErrorPages(
+	lggr logr.Logger,
+	cl kubernetes.Interface,
+	ns,
+	defaultConfigMap string,
+	resyncEvery time.Duration,
+) *errorPages {
+	factory := informers.NewSharedInformerFactoryWithOptions(
+		cl,
+		resyncEvery,
+		informers.WithNamespace(ns),
+	)
+	cmInformer := factory.Core().V1().ConfigMaps()
+	return &errorPages{
+		lggr:             lggr.WithName("errorPages"),
+		factory:          factory,
+		informer:         cmInformer.Informer(),
+		lister:           cmInformer.Lister().ConfigMaps(ns),
+		defaultConfigMap: defaultConfigMap,
+	}
+}
+
+// start runs the informer, waits for its cache to sync, then
+// blocks until ctx is done
+func (e *errorPages) start(ctx context.Context) error {
+	e.factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), e.informer.HasSynced) {
+		return errors.New("error page ConfigMaps cache never synced")
+	}
+	<-ctx.Done()
+	return errors.Wrap(ctx.Err(), "context is done")
+}
+
+// hasSynced returns true once the informer's cache has synced
+func (e *errorPages) hasSynced() bool {
+	return e.informer.HasSynced()
+}
+
+// lookup returns the page for class, and true, if target's ConfigMap
+// or the default ConfigMap have one. target's ConfigMap takes
+// precedence. target may be nil for requests that have none
+func (e *errorPages) lookup(
+	class string,
+	target *routing.Target,
+	defaultCode int,
+) (*errorPage, bool) {
+	if e == nil {
+		return nil, false
+	}
+	names := []string{}
+	if target != nil && target.ErrorPagesConfigMap != "" {
+		names = append(names, target.ErrorPagesConfigMap)
+	}
+	if e.defaultConfigMap != "" {
+		names = append(names, e.defaultConfigMap)
+	}
+	for _, name := range names {
+		cm, err := e.lister.Get(name)
+		if err != nil {
+			e.lggr.V(1).Info("error pages ConfigMap not found", "name", name)
+			continue
+		}
+		page, ok, err := errorPageFromConfigMap(cm, class, defaultCode)
+		if err != nil {
+			e.lggr.Error(err, "invalid error page, ignoring it", "class", class)
+			continue
+		}
+		if ok {
+			return page, true
+		}
+	}
+	return nil, false
+}
+
+// write writes the page for class to w, if there is one. Otherwise, it
+// writes the built-in response, which is defaultCode with msg as its
+// body
+func (e *errorPages) write(
+	w http.ResponseWriter,
+	class string,
+	target *routing.Target,
+	defaultCode int,
+	msg string,
+) {
+	if page, ok := e.lookup(class, target, defaultCode); ok {
+		page.write(w)
+		return
+	}
+	w.WriteHeader(defaultCode)
+	w.Write([]byte(msg))
+}
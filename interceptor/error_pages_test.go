@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func newErrorPagesConfigMap(ns, name string, data map[string]string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name},
+		Data:       data,
+	}
+}
+
+func TestErrorPageFromConfigMap(t *testing.T) {
+	r := require.New(t)
+	cm := newErrorPagesConfigMap("testns", "pages", map[string]string{
+		errorClassColdStartTimeout:                              `{"error": "warming up"}`,
+		errorClassColdStartTimeout + errorPageStatusSuffix:      "503",
+		errorClassColdStartTimeout + errorPageContentTypeSuffix: "application/json",
+		errorClassUpstream:                                      "<html><body>oops</body></html>",
+		errorClassNoRoute:                                       "not here",
+		errorClassNoRoute + errorPageStatusSuffix:               "999",
+	})
+
+	page, ok, err := errorPageFromConfigMap(cm, errorClassColdStartTimeout, 502)
+	r.NoError(err)
+	r.True(ok)
+	r.Equal(503, page.statusCode)
+	r.Equal("application/json", page.contentType)
+	r.Equal(`{"error": "warming up"}`, string(page.body))
+
+	// the status code and content type have defaults
+	page, ok, err = errorPageFromConfigMap(cm, errorClassUpstream, 502)
+	r.NoError(err)
+	r.True(ok)
+	r.Equal(502, page.statusCode)
+	r.Equal("text/html; charset=utf-8", page.contentType)
+
+	_, _, err = errorPageFromConfigMap(cm, errorClassNoRoute, 404)
+	r.Error(err)
+
+	_, ok, err = errorPageFromConfigMap(
+		newErrorPagesConfigMap("testns", "empty", nil),
+		errorClassUpstream,
+		502,
+	)
+	r.NoError(err)
+	r.False(ok)
+}
+
+func TestErrorPagesCustomResponses(t *testing.T) {
+	const (
+		ns   = "testns"
+		host = "TestErrorPagesCustomResponses.testing"
+	)
+	r := require.New(t)
+	ctx, done := context.WithCancel(context.Background())
+	defer done()
+	cl := k8sfake.NewSimpleClientset(
+		newErrorPagesConfigMap(ns, "defaultpages", map[string]string{
+			errorClassNoRoute:          "no such app",
+			errorClassColdStartTimeout: "default timeout page",
+		}),
+		newErrorPagesConfigMap(ns, "apppages", map[string]string{
+			errorClassColdStartTimeout:                         "app timeout page",
+			errorClassColdStartTimeout + errorPageStatusSuffix: "504",
+		}),
+	)
+	pages := newErrorPages(logr.Discard(), cl, ns, "defaultpages", time.Minute)
+	go pages.start(ctx)
+	r.Eventually(pages.hasSynced, time.Second, 10*time.Millisecond)
+
+	routingTable := routing.NewTable()
+	// hosts that aren't in a loaded table get a 404
+	routingTable.Replace(routing.NewTable())
+	r.NoError(routingTable.AddTarget(host, routing.Target{
+		Service:             "testsvc",
+		Port:                8080,
+		Deployment:          "testdepl",
+		ErrorPagesConfigMap: "apppages",
+	}))
+	waitFunc := func(context.Context, routing.Target) error {
+		return errors.New("backend never became ready")
+	}
+	timeouts := defaultTimeouts()
+	hdl := newForwardingHandler(
+		logr.Discard(),
+		routingTable,
+		retryDialContextFunc(timeouts, timeouts.DefaultBackoff()),
+		waitFunc,
+		forwardingConfig{
+			waitTimeout:       timeouts.DeploymentReplicas,
+			respHeaderTimeout: timeouts.ResponseHeader,
+			errorPages:        pages,
+		},
+	)
+
+	// the host's own page takes precedence over the default one
+	res, req, err := reqAndRes("/testfwd")
+	r.NoError(err)
+	req.Host = host
+	hdl.ServeHTTP(res, req)
+	r.Equal(504, res.Code)
+	r.Equal("app timeout page", res.Body.String())
+
+	// unknown hosts get the default page
+	res, req, err = reqAndRes("/testfwd")
+	r.NoError(err)
+	req.Host = "unknown.testing"
+	hdl.ServeHTTP(res, req)
+	r.Equal(404, res.Code)
+	r.Equal("no such app", res.Body.String())
+
+	// without error pages, the built-in responses are used
+	var noPages *errorPages
+	_, ok := noPages.lookup(errorClassNoRoute, nil, 404)
+	r.False(ok)
+}
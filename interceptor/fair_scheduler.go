@@ -0,0 +1,179 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/kedacore/http-add-on/interceptor/config"
+)
+
+// fairSchedulerStats are the per-host counters that a fairScheduler
+// exposes
+type fairSchedulerStats struct {
+	Running   int   `json:"running"`
+	Queued    int   `json:"queued"`
+	Scheduled int64 `json:"scheduled"`
+}
+
+// schedulerHost is the state of a single host in a fairScheduler.
+// Each element of waiters is the channel of a request that waits for
+// a worker, in the order that they arrived
+type schedulerHost struct {
+	running   int
+	waiters   *list.List
+	scheduled int64
+	// ringElt is the host's element in the fairScheduler's ring,
+	// or nil if it has no waiters
+	ringElt *list.Element
+}
+
+// fairScheduler limits the requests that the interceptor forwards at
+// once, across all hosts. When a worker frees up, it goes to the host
+// after the last one that got a worker, among the hosts with requests
+// waiting, so that hosts take turns. Within a host, requests get
+// workers in the order they arrived. No host gets more than its share
+// of the workers, so a host with lots of requests can't starve the
+// others
+type fairScheduler struct {
+	mut        *sync.Mutex
+	workers    int
+	maxPerHost int
+	inUse      int
+	hosts      map[string]*schedulerHost
+	// ring holds the name of each host with waiters, in the order
+	// that they take turns
+	ring *list.List
+}
+
+func newFairScheduler(cfg config.FairScheduler) *fairScheduler {
+	return &fairScheduler{
+		mut:        new(sync.Mutex),
+		workers:    cfg.Workers,
+		maxPerHost: cfg.MaxPerHost(),
+		hosts:      map[string]*schedulerHost{},
+		ring:       list.New(),
+	}
+}
+
+// acquire gets a worker for a request to host, waiting for one if
+// they're all busy, host is using its share, or other requests to
+// host are waiting already. While it waits, the request that ctx
+// belongs to is marked as pending. It returns the func that gives the
+// worker back, which must be called exactly once, or ctx's error if
+// ctx is done before the request gets a worker. A nil fairScheduler
+// schedules nothing and returns right away
+func (s *fairScheduler) acquire(ctx context.Context, host string) (func(), error) {
+	if s == nil {
+		return func() {}, nil
+	}
+	s.mut.Lock()
+	h, ok := s.hosts[host]
+	if !ok {
+		h = &schedulerHost{waiters: list.New()}
+		s.hosts[host] = h
+	}
+	if s.inUse < s.workers && h.running < s.maxPerHost && h.waiters.Len() == 0 {
+		s.inUse++
+		h.running++
+		h.scheduled++
+		s.mut.Unlock()
+		return s.releaseFunc(h), nil
+	}
+	granted := make(chan struct{})
+	elt := h.waiters.PushBack(granted)
+	if h.ringElt == nil {
+		h.ringElt = s.ring.PushBack(host)
+	}
+	s.mut.Unlock()
+
+	donePending := startPending(ctx)
+	defer donePending()
+	select {
+	case <-granted:
+		return s.releaseFunc(h), nil
+	case <-ctx.Done():
+	}
+
+	s.mut.Lock()
+	select {
+	case <-granted:
+		// the worker was handed over while the request gave up on
+		// it, so it goes to the next request instead
+		s.mut.Unlock()
+		s.releaseFunc(h)()
+		return nil, ctx.Err()
+	default:
+	}
+	h.waiters.Remove(elt)
+	if h.waiters.Len() == 0 {
+		s.ring.Remove(h.ringElt)
+		h.ringElt = nil
+	}
+	s.mut.Unlock()
+	return nil, ctx.Err()
+}
+
+// releaseFunc returns the func that gives back one of the workers
+// that the host whose state is h is using
+func (s *fairScheduler) releaseFunc(h *schedulerHost) func() {
+	once := new(sync.Once)
+	return func() {
+		once.Do(func() {
+			s.mut.Lock()
+			defer s.mut.Unlock()
+			s.inUse--
+			h.running--
+			s.dispatch()
+		})
+	}
+}
+
+// dispatch hands out the idle workers to the waiting requests, one
+// host at a time, starting with the host at the front of the ring.
+// A host that gets a worker goes to the back of the ring, and hosts
+// that are using their share are skipped. Callers must hold s.mut
+func (s *fairScheduler) dispatch() {
+	for s.inUse < s.workers {
+		var next *list.Element
+		for elt := s.ring.Front(); elt != nil; elt = elt.Next() {
+			if s.hosts[elt.Value.(string)].running < s.maxPerHost {
+				next = elt
+				break
+			}
+		}
+		if next == nil {
+			return
+		}
+		h := s.hosts[next.Value.(string)]
+		front := h.waiters.Front()
+		h.waiters.Remove(front)
+		s.inUse++
+		h.running++
+		h.scheduled++
+		close(front.Value.(chan struct{}))
+		if h.waiters.Len() == 0 {
+			s.ring.Remove(next)
+			h.ringElt = nil
+		} else {
+			s.ring.MoveToBack(next)
+		}
+	}
+}
+
+// MarshalJSON returns the requests that are running and waiting for
+// each host, and the number of requests that got a worker
+func (s *fairScheduler) MarshalJSON() ([]byte, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	ret := make(map[string]fairSchedulerStats, len(s.hosts))
+	for host, h := range s.hosts {
+		ret[host] = fairSchedulerStats{
+			Running:   h.running,
+			Queued:    h.waiters.Len(),
+			Scheduled: h.scheduled,
+		}
+	}
+	return json.Marshal(ret)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/interceptor/config"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"github.com/stretchr/testify/require"
+)
+
+// fairSchedulerStatsFor returns s's stats for host
+func fairSchedulerStatsFor(s *fairScheduler, host string) fairSchedulerStats {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	h, ok := s.hosts[host]
+	if !ok {
+		return fairSchedulerStats{}
+	}
+	return fairSchedulerStats{
+		Running:   h.running,
+		Queued:    h.waiters.Len(),
+		Scheduled: h.scheduled,
+	}
+}
+
+func TestFairSchedulerRoundRobin(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	s := newFairScheduler(config.FairScheduler{
+		Workers:             1,
+		MaxHostSharePercent: 100,
+	})
+	release, err := s.acquire(ctx, "a")
+	r.NoError(err)
+
+	// host a's requests arrive before host b's, but the hosts
+	// still take turns
+	order := make(chan string, 4)
+	for _, host := range []string{"a", "a", "b", "b"} {
+		host := host
+		queued := fairSchedulerStatsFor(s, host).Queued
+		go func() {
+			release, err := s.acquire(ctx, host)
+			if err == nil {
+				order <- host
+				release()
+			}
+		}()
+		r.Eventually(func() bool {
+			return fairSchedulerStatsFor(s, host).Queued == queued+1
+		}, time.Second, time.Millisecond)
+	}
+	release()
+	got := []string{}
+	for i := 0; i < 4; i++ {
+		got = append(got, <-order)
+	}
+	r.Equal([]string{"a", "b", "a", "b"}, got)
+	r.Equal(fairSchedulerStats{Scheduled: 3}, fairSchedulerStatsFor(s, "a"))
+	r.Equal(fairSchedulerStats{Scheduled: 2}, fairSchedulerStatsFor(s, "b"))
+}
+
+func TestFairSchedulerMaxHostShare(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	s := newFairScheduler(config.FairScheduler{
+		Workers:             4,
+		MaxHostSharePercent: 50,
+	})
+	for i := 0; i < 2; i++ {
+		_, err := s.acquire(ctx, "a")
+		r.NoError(err)
+	}
+
+	// host a is using its share, so its next request waits even
+	// though there are idle workers, and host b still gets them
+	waited := make(chan func())
+	go func() {
+		release, err := s.acquire(ctx, "a")
+		if err == nil {
+			waited <- release
+		}
+	}()
+	r.Eventually(func() bool {
+		return fairSchedulerStatsFor(s, "a").Queued == 1
+	}, time.Second, time.Millisecond)
+	releaseB, err := s.acquire(ctx, "b")
+	r.NoError(err)
+	select {
+	case <-waited:
+		r.FailNow("host a got more than its share")
+	default:
+	}
+	releaseB()
+
+	res, err := json.Marshal(s)
+	r.NoError(err)
+	stats := map[string]fairSchedulerStats{}
+	r.NoError(json.Unmarshal(res, &stats))
+	r.Equal(fairSchedulerStats{Running: 2, Queued: 1, Scheduled: 2}, stats["a"])
+	r.Equal(fairSchedulerStats{Scheduled: 1}, stats["b"])
+}
+
+func TestFairSchedulerCanceled(t *testing.T) {
+	r := require.New(t)
+	s := newFairScheduler(config.FairScheduler{
+		Workers:             1,
+		MaxHostSharePercent: 100,
+	})
+	release, err := s.acquire(context.Background(), "a")
+	r.NoError(err)
+
+	ctx, done := context.WithCancel(context.Background())
+	done()
+	_, err = s.acquire(ctx, "b")
+	r.ErrorIs(err, context.Canceled)
+	r.Equal(0, fairSchedulerStatsFor(s, "b").Queued)
+	r.Equal(0, s.ring.Len())
+
+	// the request that gave up doesn't hold on to the worker
+	release()
+	release, err = s.acquire(context.Background(), "c")
+	r.NoError(err)
+	release()
+
+	// a nil scheduler lets everything through
+	var nilScheduler *fairScheduler
+	release, err = nilScheduler.acquire(context.Background(), "a")
+	r.NoError(err)
+	release()
+}
+
+// the proxy should count requests whose client went away while they
+// waited for a worker as dropped, like the ones it rejects for waiting
+// on their backends
+func TestProxySchedulerCanceled(t *testing.T) {
+	const host = "TestProxySchedulerCanceled.testing"
+	r := require.New(t)
+	routingTable := routing.NewTable()
+	routingTable.AddTarget(host, routing.Target{
+		Service: "nosuchsvc",
+		Port:    9091,
+	})
+	scheduler := newFairScheduler(config.FairScheduler{
+		Workers:             1,
+		MaxHostSharePercent: 100,
+	})
+	release, err := scheduler.acquire(context.Background(), host)
+	r.NoError(err)
+	defer release()
+	timeouts := defaultTimeouts()
+	hdl := newForwardingHandler(
+		logr.Discard(),
+		routingTable,
+		retryDialContextFunc(timeouts, timeouts.DefaultBackoff()),
+		func(context.Context, routing.Target) error { return nil },
+		forwardingConfig{
+			waitTimeout:       timeouts.DeploymentReplicas,
+			respHeaderTimeout: timeouts.ResponseHeader,
+			scheduler:         scheduler,
+		},
+	)
+
+	reason := new(dropReason)
+	ctx, done := context.WithCancel(
+		context.WithValue(context.Background(), dropReasonKey{}, reason),
+	)
+	done()
+	res, req, err := reqAndRes("/testfwd")
+	r.NoError(err)
+	req.Host = host
+	hdl.ServeHTTP(res, req.WithContext(ctx))
+	r.Equal(503, res.Code)
+	r.Equal(dropReasonClientCanceled, *reason)
+	r.Equal(0, fairSchedulerStatsFor(scheduler, host).Queued)
+}
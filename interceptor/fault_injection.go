@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/interceptor/config"
+	"github.com/kedacore/http-add-on/pkg/routing"
+)
+
+// faultHeader is set on the responses to requests that a fault was
+// injected into, so that clients can tell injected faults apart from
+// real ones
+const faultHeader = "X-Keda-Http-Fault"
+
+// faultInjectionMiddleware injects the latency and the errors that the
+// routing table asks for into a share of the requests to each host,
+// before passing them to next. Whether a request gets a fault is
+// decided with split.
+//
+// Delays are capped at cfg's maximum, and stop early if the client
+// goes away. Aborted requests get the fault's status and are never
+// forwarded, so they don't wake up the backend
+func faultInjectionMiddleware(
+	lggr logr.Logger,
+	cfg config.FaultInjection,
+	routingTable routing.TableReader,
+	split splitFunc,
+	next http.Handler,
+) http.Handler {
+	lggr = lggr.WithName("faultInjectionMiddleware")
+	maxDelay := time.Duration(cfg.MaxDelayMS) * time.Millisecond
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, err := getHost(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		target, err := lookupTarget(r.Context(), routingTable, host)
+		if err != nil || target.Fault == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		fault := target.Fault
+		if fault.DelayMS > 0 && split(fault.DelayPercent) {
+			delay := time.Duration(fault.DelayMS) * time.Millisecond
+			if delay > maxDelay {
+				delay = maxDelay
+			}
+			lggr.V(1).Info(
+				"injecting delay",
+				"host",
+				host,
+				"delay",
+				delay,
+				"requestID",
+				requestIDFromContext(r.Context()),
+			)
+			w.Header().Set(faultHeader, "delay")
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-r.Context().Done():
+				timer.Stop()
+				return
+			}
+		}
+		if fault.AbortStatus > 0 && split(fault.AbortPercent) {
+			lggr.V(1).Info(
+				"injecting error",
+				"host",
+				host,
+				"status",
+				fault.AbortStatus,
+				"requestID",
+				requestIDFromContext(r.Context()),
+			)
+			w.Header().Set(faultHeader, "abort")
+			w.WriteHeader(fault.AbortStatus)
+			w.Write([]byte("fault injected by the interceptor"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/interceptor/config"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaultInjectionMiddleware(t *testing.T) {
+	const (
+		host        = "TestFaultInjectionMiddleware.testing"
+		noFaultHost = "nofault.testing"
+	)
+	r := require.New(t)
+	table := routing.NewTable()
+	target := routing.NewTarget("testsvc", 8080, "testdepl", 100)
+	r.NoError(table.AddTarget(noFaultHost, target))
+	target.Fault = &routing.FaultPolicy{
+		DelayMS:      50,
+		DelayPercent: 100,
+		AbortStatus:  503,
+		AbortPercent: 20,
+	}
+	r.NoError(table.AddTarget(host, target))
+
+	inject := false
+	splitWeights := []int{}
+	split := func(weight int) bool {
+		splitWeights = append(splitWeights, weight)
+		return inject
+	}
+	forwarded := 0
+	hdl := faultInjectionMiddleware(
+		logr.Discard(),
+		config.FaultInjection{Enabled: true, MaxDelayMS: 10},
+		table,
+		split,
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			forwarded++
+			w.WriteHeader(200)
+		}),
+	)
+	do := func(ctx context.Context, host string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+		req.Host = host
+		res := httptest.NewRecorder()
+		hdl.ServeHTTP(res, req)
+		return res
+	}
+
+	// hosts without faults are never split
+	res := do(context.Background(), noFaultHost)
+	r.Equal(200, res.Code)
+	r.Empty(splitWeights)
+
+	// requests that don't get a fault are forwarded as they are
+	res = do(context.Background(), host)
+	r.Equal(200, res.Code)
+	r.Empty(res.Header().Get(faultHeader))
+	r.Equal([]int{100, 20}, splitWeights)
+	r.Equal(2, forwarded)
+
+	// the delay is capped, and aborted requests aren't forwarded
+	inject = true
+	start := time.Now()
+	res = do(context.Background(), host)
+	r.Less(time.Since(start), 50*time.Millisecond)
+	r.Equal(503, res.Code)
+	r.Equal("abort", res.Header().Get(faultHeader))
+	r.Equal(2, forwarded)
+
+	// delayed requests stop as soon as the client goes away
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	do(ctx, host)
+	r.Equal(2, forwarded)
+}
@@ -2,19 +2,47 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"time"
 
+	"github.com/go-logr/logr"
 	"github.com/kedacore/http-add-on/pkg/k8s"
+	"github.com/kedacore/http-add-on/pkg/routing"
 	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/watch"
 )
 
-type forwardWaitFunc func(context.Context, string) error
+// forwardWaitFunc waits until the workload that serves a
+// routing.Target has one or more replicas
+type forwardWaitFunc func(context.Context, routing.Target) error
 
+// workloadReplicasFunc returns the number of replicas of the workload
+// that serves a routing.Target
+type workloadReplicasFunc func(context.Context, routing.Target) (int32, error)
+
+// cacheRecheckInterval is how often the wait funcs that watch a cache
+// re-read their target from it. The caches' watch events are best
+// effort, and a waiter that falls behind misses them, so the event that
+// makes a target ready may never arrive
+const cacheRecheckInterval = 500 * time.Millisecond
+
+// errNoWorkload is returned by workloadReplicasFuncs for targets that
+// aren't served by a workload, like backends outside the cluster
+var errNoWorkload = errors.New("target has no workload")
+
+// newDeployReplicasForwardWaitFunc returns a forwardWaitFunc that
+// waits until the target's Deployment in deployCache has one or more
+// ready replicas. It logs watch events that aren't for a Deployment to
+// lggr
 func newDeployReplicasForwardWaitFunc(
+	lggr logr.Logger,
 	deployCache k8s.DeploymentCache,
 ) forwardWaitFunc {
-	return func(ctx context.Context, deployName string) error {
+	lggr = lggr.WithName("deployReplicasForwardWaitFunc")
+	return func(ctx context.Context, target routing.Target) error {
+		deployName := target.Deployment
 		deployment, err := deployCache.Get(deployName)
 		if err != nil {
 			// if we didn't get the initial deployment state, bail out
@@ -25,21 +53,33 @@ func newDeployReplicasForwardWaitFunc(
 			return nil
 		}
 		watcher := deployCache.Watch(deployName)
-		if err != nil {
-			return fmt.Errorf("error getting the stream of deployment changes")
-		}
 		defer watcher.Stop()
 		eventCh := watcher.ResultChan()
+		ticker := time.NewTicker(cacheRecheckInterval)
+		defer ticker.Stop()
 		for {
 			select {
-			case event := <-eventCh:
+			case event, ok := <-eventCh:
+				if !ok {
+					return fmt.Errorf("the watch on deployment %s was closed", deployName)
+				}
 				deployment, ok := event.Object.(*appsv1.Deployment)
 				if !ok {
-					log.Println("Didn't get a deployment back in event")
+					lggr.Info(
+						"didn't get a deployment back in event",
+						"deployment", deployName,
+						"type", event.Type,
+					)
+					continue
 				}
 				if deployment.Status.ReadyReplicas > 0 {
 					return nil
 				}
+			case <-ticker.C:
+				deployment, err := deployCache.Get(deployName)
+				if err == nil && deployment.Status.ReadyReplicas > 0 {
+					return nil
+				}
 			case <-ctx.Done():
 				// otherwise, if the context is marked done before
 				// we're done waiting, fail.
@@ -52,3 +92,151 @@ func newDeployReplicasForwardWaitFunc(
 		}
 	}
 }
+
+// newEndpointsForwardWaitFunc returns a forwardWaitFunc that waits
+// until the Endpoints for the target's Service have at least one ready
+// address.
+//
+// Waiting on the Endpoints instead of the workload's replicas avoids
+// forwarding requests before kube-proxy or the Service's DNS know about
+// the new pod. The Endpoints are also re-read from the cache every
+// cacheRecheckInterval, in case the event that made them ready was
+// dropped
+func newEndpointsForwardWaitFunc(
+	endpointsCache k8s.EndpointsCache,
+) forwardWaitFunc {
+	return func(ctx context.Context, target routing.Target) error {
+		svcName := target.Service
+		// start watching before the first Get, so that no
+		// change between the two is missed
+		watcher := endpointsCache.Watch(svcName)
+		defer watcher.Stop()
+		endpts, err := endpointsCache.Get(svcName)
+		if err == nil && k8s.ReadyAddresses(&endpts) > 0 {
+			return nil
+		}
+		// a missing Endpoints is fine, since it'll show up
+		// in the watch once the Service is created
+		eventCh := watcher.ResultChan()
+		ticker := time.NewTicker(cacheRecheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				endpts, err := endpointsCache.Get(svcName)
+				if err == nil && k8s.ReadyAddresses(&endpts) > 0 {
+					return nil
+				}
+			case event, ok := <-eventCh:
+				if !ok {
+					return fmt.Errorf("the watch on endpoints %s was closed", svcName)
+				}
+				endpts, ok := event.Object.(*corev1.Endpoints)
+				if !ok {
+					continue
+				}
+				if event.Type != watch.Deleted && k8s.ReadyAddresses(endpts) > 0 {
+					return nil
+				}
+			case <-ctx.Done():
+				return fmt.Errorf(
+					"context marked done while waiting for endpoints %s to have a ready address (%w)",
+					svcName,
+					ctx.Err(),
+				)
+			}
+		}
+	}
+}
+
+// newScaleReplicasForwardWaitFunc returns a forwardWaitFunc that reads
+// the scale subresource of the target's workload every pollInterval,
+// until it has one or more replicas
+func newScaleReplicasForwardWaitFunc(
+	scaleReader k8s.ScaleReader,
+	ns string,
+	pollInterval time.Duration,
+) forwardWaitFunc {
+	return func(ctx context.Context, target routing.Target) error {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			replicas, err := scaleReader.Replicas(
+				ctx,
+				ns,
+				target.APIVersion,
+				target.Kind,
+				target.Deployment,
+			)
+			if err != nil {
+				return fmt.Errorf(
+					"error getting state for %s %s (%w)",
+					target.Kind,
+					target.Deployment,
+					err,
+				)
+			}
+			if replicas > 0 {
+				return nil
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return fmt.Errorf(
+					"context marked done while waiting for %s %s to reach > 0 replicas (%w)",
+					target.Kind,
+					target.Deployment,
+					ctx.Err(),
+				)
+			}
+		}
+	}
+}
+
+// newWorkloadForwardWaitFunc returns a forwardWaitFunc that calls
+// deployWait for targets served by Deployments, and scaleWait for
+// targets served by any other kind of workload
+func newWorkloadForwardWaitFunc(
+	deployWait,
+	scaleWait forwardWaitFunc,
+) forwardWaitFunc {
+	return func(ctx context.Context, target routing.Target) error {
+		if target.IsDeployment() {
+			return deployWait(ctx, target)
+		}
+		return scaleWait(ctx, target)
+	}
+}
+
+// newWorkloadReplicasFunc returns a workloadReplicasFunc that returns
+// the ready replicas in deployCache for targets served by Deployments,
+// and the replicas in the scale subresource for all other targets.
+//
+// The scale subresource doesn't say how many replicas are ready, so
+// for non-Deployment workloads this counts replicas that are still
+// starting up
+func newWorkloadReplicasFunc(
+	deployCache k8s.DeploymentCache,
+	scaleReader k8s.ScaleReader,
+	ns string,
+) workloadReplicasFunc {
+	return func(ctx context.Context, target routing.Target) (int32, error) {
+		if !target.HasWorkload() {
+			return 0, errNoWorkload
+		}
+		if target.IsDeployment() {
+			deployment, err := deployCache.Get(target.Deployment)
+			if err != nil {
+				return 0, err
+			}
+			return deployment.Status.ReadyReplicas, nil
+		}
+		return scaleReader.Replicas(
+			ctx,
+			ns,
+			target.APIVersion,
+			target.Kind,
+			target.Deployment,
+		)
+	}
+}
@@ -5,11 +5,14 @@ import (
 	"testing"
 	"time"
 
+	"github.com/go-logr/logr"
 	"github.com/kedacore/http-add-on/pkg/k8s"
+	"github.com/kedacore/http-add-on/pkg/routing"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/sync/errgroup"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/watch"
 )
 
@@ -39,11 +42,12 @@ func TestForwardWaitFuncOneReplica(t *testing.T) {
 	group, ctx := errgroup.WithContext(ctx)
 
 	waitFunc := newDeployReplicasForwardWaitFunc(
+		logr.Discard(),
 		cache,
 	)
 
 	group.Go(func() error {
-		return waitFunc(ctx, deployName)
+		return waitFunc(ctx, routing.Target{Deployment: deployName})
 	})
 	r.NoError(group.Wait(), "wait function failed, but it shouldn't have")
 }
@@ -73,10 +77,11 @@ func TestForwardWaitFuncNoReplicas(t *testing.T) {
 	ctx, done := context.WithTimeout(ctx, waitFuncWait)
 	defer done()
 	waitFunc := newDeployReplicasForwardWaitFunc(
+		logr.Discard(),
 		cache,
 	)
 
-	err := waitFunc(ctx, deployName)
+	err := waitFunc(ctx, routing.Target{Deployment: deployName})
 	r.Error(err)
 }
 
@@ -103,6 +108,7 @@ func TestWaitFuncWaitsUntilReplicas(t *testing.T) {
 	ctx, done := context.WithTimeout(ctx, totalWaitDur)
 	defer done()
 	waitFunc := newDeployReplicasForwardWaitFunc(
+		logr.Discard(),
 		cache,
 	)
 	// this channel will be closed immediately after the replicas were increased
@@ -117,5 +123,161 @@ func TestWaitFuncWaitsUntilReplicas(t *testing.T) {
 		watcher.Action(watch.Modified, modifiedDeployment)
 		close(replicasIncreasedCh)
 	}()
-	r.NoError(waitFunc(ctx, deployName))
+	r.NoError(waitFunc(ctx, routing.Target{Deployment: deployName}))
+}
+
+// Test to make sure the deployment wait function fails, rather than
+// spinning on the closed channel, once its watch is closed
+func TestWaitFuncFailsOnClosedWatch(t *testing.T) {
+	r := require.New(t)
+	const deployName = "testdepl"
+	cache := k8s.NewFakeDeploymentCache()
+	cache.Set(deployName, appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: deployName},
+	})
+	watcher := cache.SetWatcher(deployName)
+	watcher.Stop()
+	waitFunc := newDeployReplicasForwardWaitFunc(logr.Discard(), cache)
+
+	ctx, done := context.WithTimeout(context.Background(), time.Second)
+	defer done()
+	err := waitFunc(ctx, routing.Target{Deployment: deployName})
+	r.Error(err)
+	r.NoError(ctx.Err(), "the wait func waited for the context")
+	r.Contains(err.Error(), "was closed")
+}
+
+// Test to make sure the scale wait function polls the
+// scale subresource until the workload has a replica
+func TestScaleWaitFuncWaitsUntilReplicas(t *testing.T) {
+	r := require.New(t)
+	const ns = "testNS"
+	target := routing.Target{
+		Deployment: "testrollout",
+		APIVersion: "argoproj.io/v1alpha1",
+		Kind:       "Rollout",
+	}
+	scaleReader := k8s.NewFakeScaleReader()
+	scaleReader.Set(ns, target.APIVersion, target.Kind, target.Deployment, 0)
+	waitFunc := newScaleReplicasForwardWaitFunc(
+		scaleReader,
+		ns,
+		10*time.Millisecond,
+	)
+
+	// with no replicas, the wait function should time out
+	ctx, done := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer done()
+	r.Error(waitFunc(ctx, target))
+
+	ctx, done = context.WithTimeout(context.Background(), time.Second)
+	defer done()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		scaleReader.Set(ns, target.APIVersion, target.Kind, target.Deployment, 1)
+	}()
+	r.NoError(waitFunc(ctx, target))
+
+	// workloads that don't exist should fail right away
+	target.Deployment = "nosuchrollout"
+	r.Error(waitFunc(ctx, target))
+}
+
+// Test to make sure the workload wait function only waits
+// on the deployment cache for Deployments
+func TestWorkloadWaitFuncDispatch(t *testing.T) {
+	r := require.New(t)
+	var calledDeploy, calledScale bool
+	waitFunc := newWorkloadForwardWaitFunc(
+		func(context.Context, routing.Target) error {
+			calledDeploy = true
+			return nil
+		},
+		func(context.Context, routing.Target) error {
+			calledScale = true
+			return nil
+		},
+	)
+	ctx := context.Background()
+	r.NoError(waitFunc(ctx, routing.Target{Deployment: "testdepl"}))
+	r.True(calledDeploy)
+	r.False(calledScale)
+
+	calledDeploy = false
+	r.NoError(waitFunc(ctx, routing.Target{
+		Deployment: "teststs",
+		APIVersion: "apps/v1",
+		Kind:       "StatefulSet",
+	}))
+	r.False(calledDeploy)
+	r.True(calledScale)
+}
+
+// Test to make sure the endpoints wait function returns right away if
+// the target's Service has a ready address, and waits until it has one
+// otherwise
+func TestEndpointsWaitFuncWaitsUntilReadyAddress(t *testing.T) {
+	r := require.New(t)
+	const svcName = "testsvc"
+	target := routing.Target{Service: svcName, Deployment: "testdepl"}
+	endpointsCache := k8s.NewFakeEndpointsCache()
+	notReady := corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: svcName},
+		Subsets: []corev1.EndpointSubset{
+			{NotReadyAddresses: []corev1.EndpointAddress{{IP: "1.2.3.4"}}},
+		},
+	}
+	endpointsCache.Set(svcName, notReady)
+	waitFunc := newEndpointsForwardWaitFunc(endpointsCache)
+
+	// a not-ready address isn't enough
+	ctx, done := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer done()
+	r.Error(waitFunc(ctx, target))
+
+	// the wait function stops its watcher when it returns,
+	// so each call needs a new one
+	watcher := endpointsCache.SetWatcher(svcName)
+	ctx, done = context.WithTimeout(context.Background(), time.Second)
+	defer done()
+	ready := notReady.DeepCopy()
+	ready.Subsets[0].Addresses = ready.Subsets[0].NotReadyAddresses
+	ready.Subsets[0].NotReadyAddresses = nil
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		watcher.Modify(ready)
+	}()
+	r.NoError(waitFunc(ctx, target))
+
+	// once the cache has the ready address, there's no waiting
+	endpointsCache.Set(svcName, *ready)
+	ctx, done = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer done()
+	r.NoError(waitFunc(ctx, target))
+}
+
+// Test to make sure the endpoints wait function notices a ready address
+// in the cache even if the watch event for it was dropped
+func TestEndpointsWaitFuncRechecksCache(t *testing.T) {
+	r := require.New(t)
+	const svcName = "testsvc"
+	target := routing.Target{Service: svcName, Deployment: "testdepl"}
+	endpointsCache := k8s.NewFakeEndpointsCache()
+	// the watcher never gets an event
+	endpointsCache.SetWatcher(svcName)
+	waitFunc := newEndpointsForwardWaitFunc(endpointsCache)
+
+	ready := corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: svcName},
+		Subsets: []corev1.EndpointSubset{
+			{Addresses: []corev1.EndpointAddress{{IP: "1.2.3.4"}}},
+		},
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		endpointsCache.Set(svcName, ready)
+	}()
+	ctx, done := context.WithTimeout(context.Background(), 4*cacheRecheckInterval)
+	defer done()
+	r.NoError(waitFunc(ctx, target))
 }
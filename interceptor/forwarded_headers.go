@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// forwardedHeaders fills in the X-Forwarded-For, X-Forwarded-Proto,
+// X-Forwarded-Host and RFC 7239 Forwarded headers on proxied requests.
+//
+// The headers that clients in trustedProxies sent are kept, and this
+// hop is added to them. The ones that any other client sent are
+// stripped, so that they can't be spoofed.
+//
+// It also finds the IP of the client behind the trustedHops proxies in
+// front of the interceptor, in X-Forwarded-For. If there are hops but
+// no trustedProxies, every client is taken to be the nearest of them.
+//
+// A nil *forwardedHeaders is valid, and trusts no clients
+type forwardedHeaders struct {
+	trustedProxies []*net.IPNet
+	trustedHops    int
+}
+
+// newForwardedHeaders creates a new forwardedHeaders that trusts the
+// clients in cidrs, and hops proxies in front of the interceptor. Each
+// element of cidrs is either a CIDR or a single IP address
+func newForwardedHeaders(cidrs []string, hops int) (*forwardedHeaders, error) {
+	trusted, err := parseTrustedProxies(cidrs)
+	if err != nil {
+		return nil, err
+	}
+	return &forwardedHeaders{trustedProxies: trusted, trustedHops: hops}, nil
+}
+
+// parseTrustedProxies parses cidrs, each of which is either a CIDR or
+// a single IP address of a trusted proxy. Empty elements are skipped
+func parseTrustedProxies(cidrs []string) ([]*net.IPNet, error) {
+	var ret []*net.IPNet
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy IP %q", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			ret = append(ret, &net.IPNet{
+				IP:   ip,
+				Mask: net.CIDRMask(bits, bits),
+			})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy CIDR %q (%w)", cidr, err)
+		}
+		ret = append(ret, ipNet)
+	}
+	return ret, nil
+}
+
+// trusts returns true if the client at ip is a trusted proxy
+func (f *forwardedHeaders) trusts(ip net.IP) bool {
+	if f == nil || ip == nil {
+		return false
+	}
+	if len(f.trustedProxies) == 0 {
+		return f.trustedHops > 0
+	}
+	for _, ipNet := range f.trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply sets the forwarding headers on out, which is the request that
+// is about to be proxied for in.
+//
+// httputil.ReverseProxy appends the client's IP to X-Forwarded-For
+// itself, so apply only strips that header when the client isn't
+// trusted
+func (f *forwardedHeaders) apply(out, in *http.Request) {
+	clientHost, _, err := net.SplitHostPort(in.RemoteAddr)
+	if err != nil {
+		clientHost = in.RemoteAddr
+	}
+	trusted := f.trusts(net.ParseIP(clientHost))
+	if !trusted {
+		out.Header.Del("X-Forwarded-For")
+		out.Header.Del("X-Forwarded-Proto")
+		out.Header.Del("X-Forwarded-Host")
+		out.Header.Del("Forwarded")
+	}
+
+	proto := "http"
+	if in.TLS != nil {
+		proto = "https"
+	}
+	if out.Header.Get("X-Forwarded-Proto") == "" {
+		out.Header.Set("X-Forwarded-Proto", proto)
+	}
+	if out.Header.Get("X-Forwarded-Host") == "" {
+		out.Header.Set("X-Forwarded-Host", in.Host)
+	}
+
+	elem := forwardedElement(clientHost, in.Host, proto)
+	if prior := out.Header.Values("Forwarded"); len(prior) > 0 {
+		elem = strings.Join(prior, ", ") + ", " + elem
+	}
+	out.Header.Set("Forwarded", elem)
+}
+
+// forwardedElement returns the RFC 7239 forwarded-element for a hop
+// from the client at clientHost, for host, over proto
+func forwardedElement(clientHost, host, proto string) string {
+	forNode := clientHost
+	if ip := net.ParseIP(clientHost); ip != nil && ip.To4() == nil {
+		// IPv6 addresses must be bracketed and quoted
+		forNode = fmt.Sprintf("\"[%s]\"", clientHost)
+	} else if ip == nil {
+		forNode = "unknown"
+	}
+	elems := []string{"for=" + forNode}
+	if host != "" {
+		elems = append(elems, fmt.Sprintf("host=%q", host))
+	}
+	elems = append(elems, "proto="+proto)
+	return strings.Join(elems, ";")
+}
+
+// clientIP returns the IP of the client that sent r. If r came from a
+// trusted proxy, that's the address f.trustedHops hops back from the
+// interceptor, counting r's peer as the first, in X-Forwarded-For.
+// Otherwise, and if that address isn't a valid IP, it's r's peer's
+func (f *forwardedHeaders) clientIP(r *http.Request) string {
+	peer := peerIP(r)
+	if f == nil || f.trustedHops <= 0 || !f.trusts(net.ParseIP(peer)) {
+		return peer
+	}
+	var hops []string
+	for _, val := range r.Header.Values("X-Forwarded-For") {
+		for _, addr := range strings.Split(val, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				hops = append(hops, addr)
+			}
+		}
+	}
+	hops = append(hops, peer)
+	idx := len(hops) - 1 - f.trustedHops
+	if idx < 0 {
+		// fewer proxies than expected, so the
+		// first one is as close as there is
+		idx = 0
+	}
+	if net.ParseIP(hops[idx]) == nil {
+		return peer
+	}
+	return hops[idx]
+}
+
+type clientIPKey struct{}
+
+// clientIPMiddleware finds the IP of the client behind each request
+// with fwdHeaders, once, so that everything that looks at it behind
+// the middleware, through remoteIP, sees the same one
+func clientIPMiddleware(fwdHeaders *forwardedHeaders, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), clientIPKey{}, fwdHeaders.clientIP(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewForwardedHeaders(t *testing.T) {
+	r := require.New(t)
+	fwd, err := newForwardedHeaders([]string{"10.0.0.0/8", " 192.168.1.1 ", "::1", ""}, 0)
+	r.NoError(err)
+	r.Len(fwd.trustedProxies, 3)
+
+	_, err = newForwardedHeaders([]string{"10.0.0.0/33"}, 0)
+	r.Error(err)
+	_, err = newForwardedHeaders([]string{"notanip"}, 0)
+	r.Error(err)
+}
+
+func TestForwardedHeadersApply(t *testing.T) {
+	r := require.New(t)
+	fwd, err := newForwardedHeaders([]string{"10.0.0.0/8"}, 0)
+	r.NoError(err)
+
+	newReqs := func(remoteAddr string) (*http.Request, *http.Request) {
+		in := httptest.NewRequest("GET", "http://myapp.com/path", nil)
+		in.RemoteAddr = remoteAddr
+		in.Header.Set("X-Forwarded-For", "1.2.3.4")
+		in.Header.Set("X-Forwarded-Proto", "https")
+		in.Header.Set("X-Forwarded-Host", "public.myapp.com")
+		in.Header.Set("Forwarded", `for=1.2.3.4;host="public.myapp.com";proto=https`)
+		return in.Clone(in.Context()), in
+	}
+
+	// a trusted proxy's headers are kept and added to
+	out, in := newReqs("10.1.2.3:5678")
+	fwd.apply(out, in)
+	r.Equal("1.2.3.4", out.Header.Get("X-Forwarded-For"))
+	r.Equal("https", out.Header.Get("X-Forwarded-Proto"))
+	r.Equal("public.myapp.com", out.Header.Get("X-Forwarded-Host"))
+	r.Equal(
+		`for=1.2.3.4;host="public.myapp.com";proto=https, for=10.1.2.3;host="myapp.com";proto=http`,
+		out.Header.Get("Forwarded"),
+	)
+
+	// an untrusted client's headers are replaced
+	out, in = newReqs("8.8.8.8:5678")
+	in.TLS = &tls.ConnectionState{}
+	fwd.apply(out, in)
+	r.Empty(out.Header.Values("X-Forwarded-For"))
+	r.Equal("https", out.Header.Get("X-Forwarded-Proto"))
+	r.Equal("myapp.com", out.Header.Get("X-Forwarded-Host"))
+	r.Equal(`for=8.8.8.8;host="myapp.com";proto=https`, out.Header.Get("Forwarded"))
+
+	// no clients are trusted without any trusted proxies, and
+	// IPv6 addresses are quoted in the Forwarded header
+	var noTrust *forwardedHeaders
+	out, in = newReqs("[2001:db8::1]:5678")
+	noTrust.apply(out, in)
+	r.Equal("http", out.Header.Get("X-Forwarded-Proto"))
+	r.Equal(`for="[2001:db8::1]";host="myapp.com";proto=http`, out.Header.Get("Forwarded"))
+}
+
+func TestForwardRequestSetsForwardedHeaders(t *testing.T) {
+	r := require.New(t)
+	gotHeaders := make(chan http.Header, 1)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		gotHeaders <- req.Header.Clone()
+		w.WriteHeader(200)
+	}))
+	defer origin.Close()
+	originURL, err := url.Parse(origin.URL)
+	r.NoError(err)
+
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "http://myapp.com/path", nil)
+	req.RemoteAddr = "8.8.8.8:1234"
+	req.Header.Set("X-Forwarded-For", "6.6.6.6")
+	forwardRequest(res, req, http.DefaultTransport, originURL, bodyLimits{}, nil, nil, nil, nil, nil)
+	r.Equal(200, res.Code)
+
+	// the spoofed address is gone, and the client's is in its place
+	headers := <-gotHeaders
+	r.Equal("8.8.8.8", headers.Get("X-Forwarded-For"))
+	r.Equal("myapp.com", headers.Get("X-Forwarded-Host"))
+}
+
+func TestForwardedHeadersClientIP(t *testing.T) {
+	r := require.New(t)
+	newReq := func(remoteAddr string, xff ...string) *http.Request {
+		req := httptest.NewRequest("GET", "http://myapp.com/path", nil)
+		req.RemoteAddr = remoteAddr
+		for _, val := range xff {
+			req.Header.Add("X-Forwarded-For", val)
+		}
+		return req
+	}
+
+	// without hops, the client is always the peer
+	fwd, err := newForwardedHeaders([]string{"10.0.0.0/8"}, 0)
+	r.NoError(err)
+	r.Equal("10.1.2.3", fwd.clientIP(newReq("10.1.2.3:5678", "1.2.3.4")))
+	var nilFwd *forwardedHeaders
+	r.Equal("10.1.2.3", nilFwd.clientIP(newReq("10.1.2.3:5678", "1.2.3.4")))
+
+	// one hop, like a load balancer, makes the client the last entry
+	fwd, err = newForwardedHeaders([]string{"10.0.0.0/8"}, 1)
+	r.NoError(err)
+	r.Equal("1.2.3.4", fwd.clientIP(newReq("10.1.2.3:5678", "6.6.6.6, 1.2.3.4")))
+	// entries across several headers count as one list
+	r.Equal("1.2.3.4", fwd.clientIP(newReq("10.1.2.3:5678", "6.6.6.6", "1.2.3.4")))
+	// untrusted peers can't spoof it
+	r.Equal("8.8.8.8", fwd.clientIP(newReq("8.8.8.8:5678", "1.2.3.4")))
+	// without X-Forwarded-For, the peer is all there is
+	r.Equal("10.1.2.3", fwd.clientIP(newReq("10.1.2.3:5678")))
+	// an entry that isn't an IP isn't used
+	r.Equal("10.1.2.3", fwd.clientIP(newReq("10.1.2.3:5678", "unknown")))
+
+	// two hops skip the proxy that the load balancer forwarded for,
+	// and fewer entries than hops give the first one
+	fwd, err = newForwardedHeaders(nil, 2)
+	r.NoError(err)
+	r.Equal("6.6.6.6", fwd.clientIP(newReq("8.8.8.8:5678", "1.1.1.1, 6.6.6.6, 10.9.9.9")))
+	r.Equal("10.9.9.9", fwd.clientIP(newReq("8.8.8.8:5678", "10.9.9.9")))
+	// hops without trusted proxies trust every peer, so
+	// their headers are kept too
+	out := newReq("8.8.8.8:5678", "1.2.3.4")
+	fwd.apply(out, newReq("8.8.8.8:5678", "1.2.3.4"))
+	r.Equal("1.2.3.4", out.Header.Get("X-Forwarded-For"))
+}
+
+// the rate limiter, the IP filters and the access log all see the
+// client IP that clientIPMiddleware found
+func TestClientIPMiddleware(t *testing.T) {
+	r := require.New(t)
+	fwd, err := newForwardedHeaders([]string{"10.0.0.0/8"}, 1)
+	r.NoError(err)
+	var seen string
+	hdl := clientIPMiddleware(fwd, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		seen = remoteIP(req)
+	}))
+	req := httptest.NewRequest("GET", "http://myapp.com/path", nil)
+	req.RemoteAddr = "10.1.2.3:5678"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4")
+	hdl.ServeHTTP(httptest.NewRecorder(), req)
+	r.Equal("1.2.3.4", seen)
+
+	// without the middleware, it's the peer
+	r.Equal("10.1.2.3", remoteIP(req))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/kedacore/http-add-on/pkg/routing"
+)
+
+// rewriteRequestHeaders applies rewrite to req, which is about to be
+// forwarded: it removes the headers in rewrite.Remove, then sets the
+// ones in rewrite.Set, and replaces req's Host if rewrite.Host is set.
+// The Host header can only be changed through rewrite.Host, since Go
+// sends req.Host rather than a Host entry in req.Header. Does nothing
+// if rewrite is nil
+func rewriteRequestHeaders(req *http.Request, rewrite *routing.HeaderRewrite) {
+	if rewrite == nil {
+		return
+	}
+	for _, name := range rewrite.Remove {
+		req.Header.Del(name)
+	}
+	for name, val := range rewrite.Set {
+		req.Header.Set(name, val)
+	}
+	if rewrite.Host != "" {
+		req.Host = rewrite.Host
+	}
+}
+
+// rewriteResponseHeaders applies rewrite to hdr, the headers of a
+// response that's about to be sent to the client: it removes the
+// headers in rewrite.Remove, then sets the ones in rewrite.Set, and
+// then the ones in rewrite.Defaults that hdr doesn't have. Does
+// nothing if rewrite is nil
+func rewriteResponseHeaders(hdr http.Header, rewrite *routing.ResponseHeaderRewrite) {
+	if rewrite == nil {
+		return
+	}
+	for _, name := range rewrite.Remove {
+		hdr.Del(name)
+	}
+	for name, val := range rewrite.Set {
+		hdr.Set(name, val)
+	}
+	for name, val := range rewrite.Defaults {
+		if hdr.Get(name) == "" {
+			hdr.Set(name, val)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForwardRequestRewritesHeaders(t *testing.T) {
+	r := require.New(t)
+	type received struct {
+		host   string
+		header http.Header
+	}
+	gotCh := make(chan received, 1)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		gotCh <- received{host: req.Host, header: req.Header.Clone()}
+		w.WriteHeader(200)
+	}))
+	defer origin.Close()
+	originURL, err := url.Parse(origin.URL)
+	r.NoError(err)
+
+	newReq := func() *http.Request {
+		req := httptest.NewRequest("GET", "http://myapp.com/path", nil)
+		req.Header.Set("Cookie", "session=abc")
+		req.Header.Set("X-Env", "dev")
+		req.Header.Set("X-Kept", "yes")
+		return req
+	}
+
+	// without a rewrite, requests go out with the Service's host
+	res := httptest.NewRecorder()
+	forwardRequest(res, newReq(), http.DefaultTransport, originURL, bodyLimits{}, nil, nil, nil, nil, nil)
+	r.Equal(200, res.Code)
+	got := <-gotCh
+	r.Equal(originURL.Host, got.host)
+	r.Equal("session=abc", got.header.Get("Cookie"))
+
+	res = httptest.NewRecorder()
+	forwardRequest(
+		res,
+		newReq(),
+		http.DefaultTransport,
+		originURL,
+		bodyLimits{},
+		nil,
+		nil,
+		nil,
+		&routing.HeaderRewrite{
+			Set:    map[string]string{"x-env": "prod", "X-New": "1"},
+			Remove: []string{"cookie", "X-Env"},
+			Host:   "myapp.default.svc.cluster.local",
+		},
+		nil,
+	)
+	r.Equal(200, res.Code)
+	got = <-gotCh
+	r.Equal("myapp.default.svc.cluster.local", got.host)
+	r.Empty(got.header.Values("Cookie"))
+	// headers in both are removed, then set
+	r.Equal([]string{"prod"}, got.header.Values("X-Env"))
+	r.Equal("1", got.header.Get("X-New"))
+	r.Equal("yes", got.header.Get("X-Kept"))
+	// the client's host is still passed on
+	r.Equal("myapp.com", got.header.Get("X-Forwarded-Host"))
+}
+
+func TestForwardRequestRewritesResponseHeaders(t *testing.T) {
+	r := require.New(t)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Server", "legacy/1.0")
+		w.Header().Set("X-Served-By", "backend")
+		w.Header().Set("Access-Control-Allow-Origin", "https://myapp.com")
+		w.WriteHeader(200)
+	}))
+	defer origin.Close()
+	originURL, err := url.Parse(origin.URL)
+	r.NoError(err)
+	rewrite := &routing.ResponseHeaderRewrite{
+		Set: map[string]string{"X-Served-By": "keda"},
+		Defaults: map[string]string{
+			"Access-Control-Allow-Origin":  "*",
+			"Access-Control-Allow-Methods": "GET, POST",
+		},
+		Remove: []string{"server"},
+	}
+	forward := func(u *url.URL) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		forwardRequest(
+			res,
+			httptest.NewRequest("GET", "http://myapp.com/path", nil),
+			http.DefaultTransport,
+			u,
+			bodyLimits{},
+			nil,
+			nil,
+			nil,
+			nil,
+			rewrite,
+		)
+		return res
+	}
+
+	res := forward(originURL)
+	r.Equal(200, res.Code)
+	r.Empty(res.Header().Values("Server"))
+	r.Equal([]string{"keda"}, res.Header().Values("X-Served-By"))
+	// defaults don't replace what the backend sent
+	r.Equal("https://myapp.com", res.Header().Get("Access-Control-Allow-Origin"))
+	r.Equal("GET, POST", res.Header().Get("Access-Control-Allow-Methods"))
+
+	// the interceptor's own responses for a backend that's
+	// down are rewritten too
+	origin.Close()
+	res = forward(originURL)
+	r.Equal(502, res.Code)
+	r.Equal("keda", res.Header().Get("X-Served-By"))
+	r.Equal("*", res.Header().Get("Access-Control-Allow-Origin"))
+}
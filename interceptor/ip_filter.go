@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/routing"
+)
+
+// ipFilterMiddleware rejects the requests to every host whose routing
+// table target has an IPFilterPolicy, unless the policy allows the
+// client's IP address. Those get a 403, before they're counted toward
+// scaling. Requests to other hosts go straight to next.
+//
+// A policy with an invalid CIDR rejects every request, so that a typo
+// doesn't let external clients into an internal-only app
+func ipFilterMiddleware(
+	lggr logr.Logger,
+	routingTable routing.TableReader,
+	next http.Handler,
+) http.Handler {
+	lggr = lggr.WithName("ipFilterMiddleware")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, err := getHost(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		target, err := lookupTarget(r.Context(), routingTable, host)
+		if err != nil || target.IPFilter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		clientIP := remoteIP(r)
+		allowed, err := target.IPFilter.Allows(net.ParseIP(clientIP))
+		if err != nil {
+			lggr.Error(
+				err,
+				"invalid IP filter, rejecting every request",
+				"host",
+				host,
+			)
+		}
+		if !allowed {
+			lggr.V(1).Info(
+				"client IP not allowed",
+				"host",
+				host,
+				"clientIP",
+				clientIP,
+				"requestID",
+				requestIDFromContext(r.Context()),
+			)
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("client IP not allowed"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPFilterMiddleware(t *testing.T) {
+	const (
+		host        = "TestIPFilterMiddleware.testing"
+		invalidHost = "invalid.TestIPFilterMiddleware.testing"
+		otherHost   = "other.TestIPFilterMiddleware.testing"
+	)
+	r := require.New(t)
+	routingTable := routing.NewTable()
+	r.NoError(routingTable.AddTarget(host, routing.Target{
+		Service:    "testsvc",
+		Port:       8080,
+		Deployment: "testdepl",
+		IPFilter: &routing.IPFilterPolicy{
+			Allow: []string{"10.0.0.0/8"},
+			Deny:  []string{"10.1.2.3"},
+		},
+	}))
+	r.NoError(routingTable.AddTarget(invalidHost, routing.Target{
+		Service:    "invalidsvc",
+		Port:       8080,
+		Deployment: "invaliddepl",
+		IPFilter: &routing.IPFilterPolicy{
+			Allow: []string{"10.0.0.0/33"},
+		},
+	}))
+	r.NoError(routingTable.AddTarget(otherHost, routing.Target{
+		Service:    "othersvc",
+		Port:       8080,
+		Deployment: "otherdepl",
+	}))
+	reqs := 0
+	hdl := ipFilterMiddleware(
+		logr.Discard(),
+		routingTable,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reqs++
+			w.WriteHeader(200)
+		}),
+	)
+	serve := func(host, remoteAddr string) int {
+		res, req, err := reqAndRes("/")
+		r.NoError(err)
+		req.Host = host
+		req.RemoteAddr = remoteAddr
+		hdl.ServeHTTP(res, req)
+		return res.Code
+	}
+
+	r.Equal(http.StatusForbidden, serve(host, "192.168.1.1:1234"))
+	r.Equal(http.StatusForbidden, serve(host, "10.1.2.3:1234"))
+	r.Equal(http.StatusForbidden, serve(invalidHost, "10.1.2.3:1234"))
+	r.Equal(0, reqs)
+
+	r.Equal(200, serve(host, "10.1.2.4:1234"))
+	r.Equal(1, reqs)
+
+	// hosts without a policy take any client
+	r.Equal(200, serve(otherHost, "192.168.1.1:1234"))
+	r.Equal(2, reqs)
+}
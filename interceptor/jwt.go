@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// jwtClockSkew is how far a token's exp and nbf claims may be off
+	// from the interceptor's clock
+	jwtClockSkew = time.Minute
+	// jwksMinRefetchInterval is the minimum time between two fetches
+	// of a JWKS that are caused by tokens with an unknown key ID, so
+	// that such tokens can't make the interceptor hammer the JWKS URL
+	jwksMinRefetchInterval = 10 * time.Second
+	// jwksMaxBytes is the maximum size of a JWKS
+	jwksMaxBytes = 1 << 20
+)
+
+// jwtAlgs are the signature algorithms that tokens may use, and the
+// hashes they use. Symmetric algorithms and "none" aren't supported,
+// since the keys come from a public JWKS
+var jwtAlgs = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+// jwtHeader is the part of a JWT's header that the interceptor uses
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// jwtAudience is a JWT's aud claim, which may be a string or an array
+// of strings
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = jwtAudience{single}
+		return nil
+	}
+	var multi []string
+	if err := json.Unmarshal(b, &multi); err != nil {
+		return err
+	}
+	*a = multi
+	return nil
+}
+
+// jwtClaims are the claims of a JWT that the interceptor checks
+type jwtClaims struct {
+	Issuer    string      `json:"iss"`
+	Audience  jwtAudience `json:"aud"`
+	ExpiresAt *float64    `json:"exp"`
+	NotBefore *float64    `json:"nbf"`
+}
+
+// jwksKeys are the public keys in a JWKS, by key ID
+type jwksKeys map[string]crypto.PublicKey
+
+// jwksEntry is a JWKS that a jwksCache fetched
+type jwksEntry struct {
+	keys      jwksKeys
+	fetchedAt time.Time
+}
+
+// jwksCache fetches the JWKS at each URL that tokens are verified
+// against, and keeps its keys for a while. Concurrent fetches of the
+// same URL are collapsed into one
+type jwksCache struct {
+	client          *http.Client
+	refreshInterval time.Duration
+	now             func() time.Time
+	mut             *sync.Mutex
+	entries         map[string]*jwksEntry
+	fetches         *singleflight.Group
+}
+
+func newJWKSCache(client *http.Client, refreshInterval time.Duration) *jwksCache {
+	return &jwksCache{
+		client:          client,
+		refreshInterval: refreshInterval,
+		now:             time.Now,
+		mut:             new(sync.Mutex),
+		entries:         map[string]*jwksEntry{},
+		fetches:         new(singleflight.Group),
+	}
+}
+
+// key returns the key with ID kid from the JWKS at url. The JWKS is
+// fetched if it's not cached, if it's older than the refresh interval,
+// or if it doesn't have kid and wasn't fetched too recently. The fetch
+// is shared by every request that waits on it, so it isn't canceled
+// with any one of them. c's client's timeout bounds it instead
+func (c *jwksCache) key(url, kid string) (crypto.PublicKey, error) {
+	c.mut.Lock()
+	entry, ok := c.entries[url]
+	c.mut.Unlock()
+	now := c.now()
+	if ok && now.Sub(entry.fetchedAt) < c.refreshInterval {
+		if key, found := entry.keys[kid]; found {
+			return key, nil
+		}
+		if now.Sub(entry.fetchedAt) < jwksMinRefetchInterval {
+			return nil, fmt.Errorf("no key with ID %q in JWKS %s", kid, url)
+		}
+	}
+	res, err, _ := c.fetches.Do(url, func() (interface{}, error) {
+		keys, err := c.fetch(context.Background(), url)
+		if err != nil {
+			return nil, err
+		}
+		c.mut.Lock()
+		defer c.mut.Unlock()
+		c.entries[url] = &jwksEntry{keys: keys, fetchedAt: c.now()}
+		return keys, nil
+	})
+	if err != nil {
+		// keep using the keys that were fetched before, so that a
+		// JWKS that's briefly unavailable doesn't reject every token
+		if ok {
+			if key, found := entry.keys[kid]; found {
+				return key, nil
+			}
+		}
+		return nil, err
+	}
+	key, found := res.(jwksKeys)[kid]
+	if !found {
+		return nil, fmt.Errorf("no key with ID %q in JWKS %s", kid, url)
+	}
+	return key, nil
+}
+
+// fetch gets and parses the JWKS at url. Keys that aren't for
+// signatures, or whose type isn't supported, are skipped
+func (c *jwksCache) fetch(ctx context.Context, url string) (jwksKeys, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fetching JWKS %s", url)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching JWKS %s: status %d", url, res.StatusCode)
+	}
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, jwksMaxBytes)).Decode(&jwks); err != nil {
+		return nil, errors.Wrapf(err, "decoding JWKS %s", url)
+	}
+	ret := jwksKeys{}
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err := decodeBigInt(k.N)
+			if err != nil {
+				continue
+			}
+			e, err := decodeBigInt(k.E)
+			if err != nil || !e.IsInt64() {
+				continue
+			}
+			ret[k.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				continue
+			}
+			x, err := decodeBigInt(k.X)
+			if err != nil {
+				continue
+			}
+			y, err := decodeBigInt(k.Y)
+			if err != nil {
+				continue
+			}
+			ret[k.Kid] = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		}
+	}
+	return ret, nil
+}
+
+// decodeBigInt decodes a base64url-encoded big-endian integer, as JWKs
+// hold them
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// verifyJWT verifies that token was signed by a key from the JWKS at
+// jwksURL, that it's valid at now, and that it has issuer and audience
+// if they're not empty
+func verifyJWT(
+	keys *jwksCache,
+	token,
+	jwksURL,
+	issuer,
+	audience string,
+	now time.Time,
+) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("malformed token")
+	}
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return errors.Wrap(err, "decoding token header")
+	}
+	hash, ok := jwtAlgs[header.Alg]
+	if !ok {
+		return fmt.Errorf("unsupported signing algorithm %q", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return errors.Wrap(err, "decoding token signature")
+	}
+	key, err := keys.key(jwksURL, header.Kid)
+	if err != nil {
+		return err
+	}
+	h := hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	digest := h.Sum(nil)
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(header.Alg, "RS") {
+			return fmt.Errorf("key %q can't verify %s signatures", header.Kid, header.Alg)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, hash, digest, sig); err != nil {
+			return errors.Wrap(err, "verifying token signature")
+		}
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(header.Alg, "ES") {
+			return fmt.Errorf("key %q can't verify %s signatures", header.Kid, header.Alg)
+		}
+		// ECDSA signatures are the fixed-size r and s, one after
+		// the other
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("invalid token signature size")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("verifying token signature: invalid signature")
+		}
+	}
+
+	var claims jwtClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return errors.Wrap(err, "decoding token claims")
+	}
+	if claims.ExpiresAt != nil && now.Add(-jwtClockSkew).After(unixTime(*claims.ExpiresAt)) {
+		return errors.New("token expired")
+	}
+	if claims.NotBefore != nil && now.Add(jwtClockSkew).Before(unixTime(*claims.NotBefore)) {
+		return errors.New("token not valid yet")
+	}
+	if issuer != "" && claims.Issuer != issuer {
+		return fmt.Errorf("unexpected token issuer %q", claims.Issuer)
+	}
+	if audience != "" {
+		found := false
+		for _, aud := range claims.Audience {
+			if aud == audience {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.New("token isn't for the expected audience")
+		}
+	}
+	return nil
+}
+
+// decodeJWTPart decodes a base64url-encoded JSON part of a JWT into v
+func decodeJWTPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// unixTime converts a JWT NumericDate, in seconds since the epoch, to
+// a time.Time
+func unixTime(secs float64) time.Time {
+	return time.Unix(int64(secs), 0)
+}
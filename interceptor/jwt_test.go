@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testJWKS serves a JWKS with one RSA and one EC key, and signs
+// tokens with them
+type testJWKS struct {
+	rsaKey  *rsa.PrivateKey
+	ecKey   *ecdsa.PrivateKey
+	fetches *int32
+	srv     *httptest.Server
+}
+
+func newTestJWKS(t *testing.T) *testJWKS {
+	t.Helper()
+	r := require.New(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	r.NoError(err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	r.NoError(err)
+	ret := &testJWKS{rsaKey: rsaKey, ecKey: ecKey, fetches: new(int32)}
+	enc := base64.RawURLEncoding.EncodeToString
+	jwks := map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA",
+				"kid": "rsakey",
+				"use": "sig",
+				"n":   enc(rsaKey.N.Bytes()),
+				"e":   enc(big.NewInt(int64(rsaKey.E)).Bytes()),
+			},
+			{
+				"kty": "EC",
+				"kid": "eckey",
+				"crv": "P-256",
+				"x":   enc(ecKey.X.FillBytes(make([]byte, 32))),
+				"y":   enc(ecKey.Y.FillBytes(make([]byte, 32))),
+			},
+			{
+				// keys for encryption are skipped
+				"kty": "RSA",
+				"kid": "enckey",
+				"use": "enc",
+				"n":   enc(rsaKey.N.Bytes()),
+				"e":   enc(big.NewInt(int64(rsaKey.E)).Bytes()),
+			},
+		},
+	}
+	ret.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(ret.fetches, 1)
+		json.NewEncoder(w).Encode(jwks)
+	}))
+	t.Cleanup(ret.srv.Close)
+	return ret
+}
+
+// sign returns a token with claims, signed with alg and the key kid
+func (j *testJWKS) sign(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	r := require.New(t)
+	enc := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		r.NoError(err)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := enc(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + enc(claims)
+	digest := crypto.SHA256.New()
+	digest.Write([]byte(signed))
+	var sig []byte
+	switch alg {
+	case "RS256":
+		var err error
+		sig, err = rsa.SignPKCS1v15(rand.Reader, j.rsaKey, crypto.SHA256, digest.Sum(nil))
+		r.NoError(err)
+	case "ES256":
+		rr, s, err := ecdsa.Sign(rand.Reader, j.ecKey, digest.Sum(nil))
+		r.NoError(err)
+		sig = append(rr.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestVerifyJWT(t *testing.T) {
+	r := require.New(t)
+	jwks := newTestJWKS(t)
+	keys := newJWKSCache(http.DefaultClient, time.Minute)
+	now := time.Now()
+	valid := map[string]interface{}{
+		"iss": "testissuer",
+		"aud": []string{"otheraud", "testaud"},
+		"exp": now.Add(time.Hour).Unix(),
+		"nbf": now.Add(-time.Hour).Unix(),
+	}
+	verify := func(token string) error {
+		return verifyJWT(keys, token, jwks.srv.URL, "testissuer", "testaud", now)
+	}
+
+	r.NoError(verify(jwks.sign(t, "RS256", "rsakey", valid)))
+	r.NoError(verify(jwks.sign(t, "ES256", "eckey", valid)))
+	// the JWKS was only fetched once
+	r.EqualValues(1, atomic.LoadInt32(jwks.fetches))
+
+	// a key can only verify signatures of its own type
+	r.Error(verify(jwks.sign(t, "ES256", "rsakey", valid)))
+	r.Error(verify(jwks.sign(t, "RS256", "enckey", valid)))
+
+	// tampering with the claims breaks the signature
+	token := jwks.sign(t, "RS256", "rsakey", valid)
+	other := jwks.sign(t, "RS256", "rsakey", map[string]interface{}{"iss": "testissuer"})
+	r.Error(verify(token[:len(token)-10] + other[len(other)-10:]))
+
+	// unsigned and symmetric tokens are never accepted
+	r.Error(verify(jwks.sign(t, "none", "rsakey", valid)))
+	r.Error(verify(jwks.sign(t, "HS256", "rsakey", valid)))
+	r.Error(verify("not.a.token.at.all"))
+
+	claims := func(key string, val interface{}) map[string]interface{} {
+		ret := map[string]interface{}{}
+		for k, v := range valid {
+			ret[k] = v
+		}
+		ret[key] = val
+		return ret
+	}
+	r.Error(verify(jwks.sign(t, "RS256", "rsakey", claims("exp", now.Add(-time.Hour).Unix()))))
+	r.Error(verify(jwks.sign(t, "RS256", "rsakey", claims("nbf", now.Add(time.Hour).Unix()))))
+	r.Error(verify(jwks.sign(t, "RS256", "rsakey", claims("iss", "otherissuer"))))
+	r.Error(verify(jwks.sign(t, "RS256", "rsakey", claims("aud", "otheraud"))))
+	// exp within the clock skew is fine, and aud may be a string
+	r.NoError(verify(jwks.sign(t, "RS256", "rsakey", claims("exp", now.Add(-jwtClockSkew/2).Unix()))))
+	r.NoError(verify(jwks.sign(t, "RS256", "rsakey", claims("aud", "testaud"))))
+}
+
+func TestJWKSCacheRefetch(t *testing.T) {
+	r := require.New(t)
+	jwks := newTestJWKS(t)
+	keys := newJWKSCache(http.DefaultClient, time.Hour)
+	now := time.Now()
+	keys.now = func() time.Time { return now }
+
+	_, err := keys.key(jwks.srv.URL, "rsakey")
+	r.NoError(err)
+	r.EqualValues(1, atomic.LoadInt32(jwks.fetches))
+
+	// unknown key IDs don't refetch the JWKS right away
+	_, err = keys.key(jwks.srv.URL, "unknown")
+	r.Error(err)
+	r.EqualValues(1, atomic.LoadInt32(jwks.fetches))
+
+	// but they do after a while, in case the keys were rotated
+	now = now.Add(jwksMinRefetchInterval)
+	_, err = keys.key(jwks.srv.URL, "unknown")
+	r.Error(err)
+	r.EqualValues(2, atomic.LoadInt32(jwks.fetches))
+
+	// once the JWKS is unreachable, the keys that were fetched
+	// before keep working
+	jwks.srv.Close()
+	now = now.Add(2 * time.Hour)
+	_, err = keys.key(jwks.srv.URL, "rsakey")
+	r.NoError(err)
+	_, err = keys.key(jwks.srv.URL, "unknown")
+	r.Error(err)
+}
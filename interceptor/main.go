@@ -2,24 +2,38 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"math/rand"
+	"net"
 	nethttp "net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/kedacore/http-add-on/interceptor/config"
+	pkgconfig "github.com/kedacore/http-add-on/pkg/config"
+	"github.com/kedacore/http-add-on/pkg/health"
 	kedahttp "github.com/kedacore/http-add-on/pkg/http"
 	"github.com/kedacore/http-add-on/pkg/k8s"
 	pkglog "github.com/kedacore/http-add-on/pkg/log"
 	kedanet "github.com/kedacore/http-add-on/pkg/net"
 	"github.com/kedacore/http-add-on/pkg/queue"
 	"github.com/kedacore/http-add-on/pkg/routing"
+	kedatls "github.com/kedacore/http-add-on/pkg/tls"
+	"go.uber.org/zap"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"golang.org/x/sync/errgroup"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	crcache "sigs.k8s.io/controller-runtime/pkg/cache"
 )
 
 func init() {
@@ -27,13 +41,78 @@ func init() {
 }
 
 func main() {
-	lggr, err := pkglog.NewZapr()
+	logLevel := zap.NewAtomicLevelAt(zap.InfoLevel)
+	lggr, err := pkglog.NewZaprWithLevel(logLevel)
 	if err != nil {
 		fmt.Println("Error building logger", err)
 		os.Exit(1)
 	}
-	timeoutCfg := config.MustParseTimeouts()
-	servingCfg := config.MustParseServing()
+	timeoutCfg := new(config.Timeouts)
+	servingCfg := new(config.Serving)
+	circuitBreakerCfg := new(config.CircuitBreaker)
+	snapshotCfg := new(config.Snapshot)
+	replayBufferCfg := new(config.ReplayBuffer)
+	adminCfg := new(config.Admin)
+	bodyLimitsCfg := new(config.BodyLimits)
+	accessLogCfg := new(config.AccessLog)
+	rateLimitCfg := new(config.RateLimit)
+	responseCacheCfg := new(config.ResponseCache)
+	errorPagesCfg := new(config.ErrorPages)
+	forwardedCfg := new(config.Forwarded)
+	mirrorCfg := new(config.Mirror)
+	loggingCfg := new(config.Logging)
+	reloadCfg := new(config.Reload)
+	requestIDCfg := new(config.RequestID)
+	coldStartCfg := new(config.ColdStart)
+	faultInjectionCfg := new(config.FaultInjection)
+	authCfg := new(config.Auth)
+	compressionCfg := new(config.Compression)
+	concurrencyCfg := new(config.Concurrency)
+	fairSchedulerCfg := new(config.FairScheduler)
+	proxyTLSCfg := new(config.ProxyTLS)
+	deadLetterCfg := new(config.DeadLetter)
+	scalingEventsCfg := new(config.ScalingEvents)
+	backpressureCfg := new(config.Backpressure)
+	registrationCfg := new(config.Registration)
+	proxyProtocolCfg := new(config.ProxyProtocol)
+	startupCfg := new(config.Startup)
+	upstreamTLSCfg := new(config.UpstreamTLS)
+	cfgLoader := pkgconfig.MustLoad(
+		"interceptor",
+		timeoutCfg,
+		servingCfg,
+		circuitBreakerCfg,
+		snapshotCfg,
+		replayBufferCfg,
+		adminCfg,
+		bodyLimitsCfg,
+		accessLogCfg,
+		rateLimitCfg,
+		responseCacheCfg,
+		errorPagesCfg,
+		forwardedCfg,
+		mirrorCfg,
+		loggingCfg,
+		reloadCfg,
+		requestIDCfg,
+		coldStartCfg,
+		faultInjectionCfg,
+		authCfg,
+		compressionCfg,
+		concurrencyCfg,
+		fairSchedulerCfg,
+		proxyTLSCfg,
+		deadLetterCfg,
+		scalingEventsCfg,
+		backpressureCfg,
+		registrationCfg,
+		proxyProtocolCfg,
+		startupCfg,
+		upstreamTLSCfg,
+	)
+	// the config loader validated the level
+	level, _ := loggingCfg.ZapLevel()
+	logLevel.SetLevel(level)
 	ctx, ctxDone := context.WithCancel(
 		context.Background(),
 	)
@@ -51,73 +130,450 @@ func main() {
 		lggr.Error(err, "creating new Kubernetes ClientSet")
 		os.Exit(1)
 	}
-	deployInterface := cl.AppsV1().Deployments(
+	dynamicCl, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		lggr.Error(err, "creating new Kubernetes dynamic client")
+		os.Exit(1)
+	}
+	deploySelector, err := labels.Parse(servingCfg.DeploymentCacheLabelSelector)
+	if err != nil {
+		lggr.Error(err, "invalid KEDA_HTTP_DEPLOYMENT_CACHE_LABEL_SELECTOR")
+		os.Exit(1)
+	}
+	// the deployment and endpoints caches share a cache, so
+	// each kind of object is only listed and watched once
+	k8sCache, err := k8s.NewCache(
+		cfg,
 		servingCfg.CurrentNamespace,
+		time.Duration(servingCfg.DeploymentCacheResyncDurationMS)*time.Millisecond,
+		k8s.WithSelectors(crcache.SelectorsByObject{
+			&appsv1.Deployment{}: {Label: deploySelector},
+		}),
 	)
-	deployCache, err := k8s.NewK8sDeploymentCache(
+	if err != nil {
+		lggr.Error(err, "creating the Kubernetes cache")
+		os.Exit(1)
+	}
+	deployCache, err := k8s.NewInformerDeploymentCache(
 		ctx,
 		lggr,
-		deployInterface,
+		k8sCache,
+		servingCfg.CurrentNamespace,
+		servingCfg.DeploymentCacheWatchBackoff(),
 	)
 	if err != nil {
-		lggr.Error(err, "creating new deployment cache")
+		lggr.Error(err, "creating the deployment cache")
 		os.Exit(1)
 	}
 
 	configMapsInterface := cl.CoreV1().ConfigMaps(servingCfg.CurrentNamespace)
 
-	waitFunc := newDeployReplicasForwardWaitFunc(deployCache)
+	scaleReader, err := k8s.NewScaleReaderForConfig(cfg)
+	if err != nil {
+		lggr.Error(err, "creating new scale reader")
+		os.Exit(1)
+	}
+	scalePollInterval := time.Duration(servingCfg.ScalePollIntervalMS) * time.Millisecond
+	// the replay buffer, the admin server and the waiters read
+	// replicas on every request or poll, so they share reads
+	// rather than hit the API server directly
+	cachedScaleReader := k8s.NewCachedScaleReader(scaleReader, scalePollInterval)
+	replicasFunc := newWorkloadReplicasFunc(
+		deployCache,
+		cachedScaleReader,
+		servingCfg.CurrentNamespace,
+	)
 
-	lggr.Info("Interceptor starting")
+	var endpointsCache *k8s.InformerEndpointsCache
+	if servingCfg.WaitFor == config.WaitForEndpoints ||
+		servingCfg.UpstreamResolver == config.UpstreamResolverEndpoints {
+		// waiters only care about changes, which the informer's
+		// watch delivers, and the resolver reads the latest state
+		// from the informer, so the cache ignores resyncs
+		endpointsCache, err = k8s.NewInformerEndpointsCache(
+			ctx,
+			k8sCache,
+			servingCfg.CurrentNamespace,
+		)
+		if err != nil {
+			lggr.Error(err, "creating the endpoints cache")
+			os.Exit(1)
+		}
+	}
+	var waitFunc forwardWaitFunc
+	switch servingCfg.WaitFor {
+	case config.WaitForEndpoints:
+		waitFunc = newEndpointsForwardWaitFunc(endpointsCache)
+	case config.WaitForReplicas:
+		waitFunc = newWorkloadForwardWaitFunc(
+			newDeployReplicasForwardWaitFunc(lggr, deployCache),
+			newScaleReplicasForwardWaitFunc(
+				cachedScaleReader,
+				servingCfg.CurrentNamespace,
+				scalePollInterval,
+			),
+		)
+	}
+	// requests that wait for the same backend share one wait, so
+	// that bursts of them don't cost a watch per request
+	waitFunc = newSharedForwardWaitFunc(waitFunc)
 
-	q := queue.NewMemory()
-	routingTable := routing.NewTable()
+	// the resolver reads the labels of the pods behind the
+	// Services, to only dial the pods of pinned revisions
+	var podCache *k8s.InformerPodCache
+	var pods k8s.PodCache
+	if servingCfg.RevisionPinning {
+		podCache, err = k8s.NewInformerPodCache(
+			ctx,
+			k8sCache,
+			servingCfg.CurrentNamespace,
+		)
+		if err != nil {
+			lggr.Error(err, "creating the pod cache")
+			os.Exit(1)
+		}
+		pods = podCache
+	}
+	var resolver *endpointsResolver
+	if servingCfg.UpstreamResolver == config.UpstreamResolverEndpoints {
+		resolver = newEndpointsResolver(lggr, endpointsCache, pods)
+	}
 
-	lggr.Info(
-		"Fetching initial routing table",
+	fwdHeaders, err := newForwardedHeaders(
+		forwardedCfg.TrustedProxyCIDRs,
+		forwardedCfg.TrustedHops,
 	)
-	if err := routing.GetTable(
-		ctx,
+	if err != nil {
+		lggr.Error(err, "invalid KEDA_HTTP_TRUSTED_PROXY_CIDRS")
+		os.Exit(1)
+	}
+
+	lggr.Info("Interceptor starting")
+
+	q := queue.NewMemory()
+	routingTable := routing.NewNamespacedTable(servingCfg.CurrentNamespace)
+	var buffer *replayBuffer
+	if replayBufferCfg.Enabled {
+		buffer = newReplayBuffer(*replayBufferCfg)
+	}
+	var limiter *rateLimiter
+	if rateLimitCfg.Enabled {
+		limiter = newRateLimiter(*rateLimitCfg)
+	}
+	reloads := newReloader(lggr, cfgLoader, logLevel, limiter)
+	concurrency := newConcurrencyLimiter(*concurrencyCfg)
+	var scheduler *fairScheduler
+	if fairSchedulerCfg.Enabled {
+		scheduler = newFairScheduler(*fairSchedulerCfg)
+	}
+	pendingLimit := newPendingLimiter(*backpressureCfg)
+	var respCache *responseCache
+	if responseCacheCfg.Enabled {
+		respCache = newResponseCache(*responseCacheCfg)
+	}
+	var errPages *errorPages
+	if errorPagesCfg.Enabled {
+		errPages = newErrorPages(
+			lggr,
+			cl,
+			servingCfg.CurrentNamespace,
+			errorPagesCfg.DefaultConfigMap,
+			time.Duration(errorPagesCfg.ResyncDurationMS)*time.Millisecond,
+		)
+	}
+	var auth *authenticator
+	if authCfg.Enabled {
+		auth = newAuthenticator(lggr, cl, servingCfg.CurrentNamespace, *authCfg)
+	}
+	var upstreamCAPools *upstreamCAs
+	if upstreamTLSCfg.CASecretsEnabled {
+		upstreamCAPools = newUpstreamCAs(
+			lggr,
+			cl,
+			servingCfg.CurrentNamespace,
+			time.Duration(upstreamTLSCfg.ResyncDurationMS)*time.Millisecond,
+		)
+	}
+	// the source of the CloudEvents that the interceptor sends
+	eventSource := "/keda-http-add-on/" + servingCfg.CurrentNamespace + "/interceptor"
+	coldStarts := newColdStartTracker(
 		lggr,
-		configMapsInterface,
-		routingTable,
-		q,
-	); err != nil {
-		lggr.Error(err, "fetching routing table")
+		*coldStartCfg,
+		replicasFunc,
+		&k8sColdStartEvents{cl: cl, ns: servingCfg.CurrentNamespace},
+	)
+	switch scalingEventsCfg.Sink {
+	case config.ScalingEventsSinkHTTP:
+		coldStarts.scaling = newScalingEvents(
+			lggr,
+			&httpScalingEvents{
+				cl:     &nethttp.Client{Timeout: scalingEventsCfg.Timeout},
+				url:    scalingEventsCfg.URL,
+				source: eventSource,
+			},
+			scalingEventsCfg.Timeout,
+		)
+	case config.ScalingEventsSinkKubernetes:
+		coldStarts.scaling = newScalingEvents(
+			lggr,
+			&k8sScalingEvents{cl: cl, ns: servingCfg.CurrentNamespace},
+			scalingEventsCfg.Timeout,
+		)
+	}
+	drops := newDropCounter()
+	var deadLetters *deadLetterer
+	if deadLetterCfg.Enabled() {
+		deadLetters = newDeadLetterer(
+			lggr,
+			*deadLetterCfg,
+			eventSource,
+			nethttp.DefaultTransport,
+		)
+	}
+
+	switch servingCfg.RoutingTableSource {
+	case config.RoutingTableSourceConfigMap:
+		lggr.Info(
+			"Fetching initial routing table",
+		)
+		if err := routing.GetTable(
+			ctx,
+			lggr,
+			configMapsInterface,
+			routingTable,
+			q,
+		); err != nil {
+			lggr.Error(err, "fetching routing table")
+			os.Exit(1)
+		}
+	case config.RoutingTableSourceHTTPScaledObjects:
+		// the informer fills in the routing table once it starts
+	}
+
+	snapshotStore, err := newSnapshotStore(snapshotCfg, servingCfg, configMapsInterface)
+	if err != nil {
+		lggr.Error(err, "creating queue snapshot store")
 		os.Exit(1)
 	}
 
+	// the pod only becomes ready once the caches and routing
+	// table that the proxy depends on have been loaded
+	readyChecks := map[string]health.Check{
+		"deploymentCache": health.SyncedCheck("the deployment cache", deployCache.HasSynced),
+		"routingTable":    health.SyncedCheck("the routing table", routingTable.HasSynced),
+	}
+	if servingCfg.DeploymentCacheWatchReadiness {
+		// the pod stops getting traffic while its deployment
+		// cache can't reach the API server
+		readyChecks["deploymentCacheWatch"] = deployCache.Healthy
+	}
+	if errPages != nil {
+		readyChecks["errorPages"] = health.SyncedCheck(
+			"the error pages cache",
+			errPages.hasSynced,
+		)
+	}
+	if auth != nil {
+		readyChecks["auth"] = health.SyncedCheck(
+			"the bearer token Secrets cache",
+			auth.hasSynced,
+		)
+	}
+	if upstreamCAPools != nil {
+		readyChecks["upstreamCAs"] = health.SyncedCheck(
+			"the upstream CA Secrets cache",
+			upstreamCAPools.hasSynced,
+		)
+	}
+	if endpointsCache != nil {
+		readyChecks["endpointsCache"] = health.SyncedCheck(
+			"the endpoints cache",
+			endpointsCache.HasSynced,
+		)
+	}
+	if podCache != nil {
+		readyChecks["podCache"] = health.SyncedCheck(
+			"the pod cache",
+			podCache.HasSynced,
+		)
+	}
+
 	errGrp, ctx := errgroup.WithContext(ctx)
 
-	// start the deployment cache updater
-	errGrp.Go(func() error {
-		defer ctxDone()
-		err := deployCache.StartWatcher(
+	if snapshotStore != nil {
+		// restore after the routing table was fetched, so that the
+		// restored hosts aren't pruned from the queue right away
+		if err := queue.RestoreSnapshot(
 			ctx,
 			lggr,
-			time.Duration(servingCfg.DeploymentCachePollIntervalMS)*time.Millisecond,
+			snapshotStore,
+			q,
+			snapshotCfg.RestoreTTL,
+		); err != nil {
+			// a missing snapshot shouldn't stop the interceptor
+			// from serving, so just log and move on
+			lggr.Error(err, "restoring queue counts snapshot")
+		}
+
+		// start saving snapshots of the queue counts
+		errGrp.Go(func() error {
+			defer ctxDone()
+			err := queue.StartSnapshotter(
+				ctx,
+				lggr,
+				snapshotStore,
+				q,
+				snapshotCfg.Interval,
+			)
+			lggr.Error(err, "queue snapshotter failed")
+			return err
+		})
+	}
+
+	// /readyz fails until the routing table and deployment cache have
+	// loaded, so this only logs how that's going, and exits if it
+	// takes too long for them to ever load
+	errGrp.Go(func() error {
+		err := health.WaitFor(
+			ctx,
+			lggr.WithName("startup"),
+			startupCfg.PreloadTimeout,
+			100*time.Millisecond,
+			startupCfg.PreloadLogInterval,
+			map[string]health.Check{
+				"deploymentCache": readyChecks["deploymentCache"],
+				"routingTable":    readyChecks["routingTable"],
+			},
 		)
-		lggr.Error(err, "deployment cache watcher failed")
+		if err != nil && ctx.Err() == nil {
+			defer ctxDone()
+			lggr.Error(err, "routing table and deployment cache didn't load in time")
+			return err
+		}
+		return nil
+	})
+
+	// start the informers of the deployment and endpoints caches
+	errGrp.Go(func() error {
+		defer ctxDone()
+		err := k8s.StartCache(ctx, lggr, k8sCache)
+		lggr.Error(err, "Kubernetes cache failed")
+		return err
+	})
+
+	if errPages != nil {
+		// start the error pages cache updater
+		errGrp.Go(func() error {
+			defer ctxDone()
+			err := errPages.start(ctx)
+			lggr.Error(err, "error pages cache informer failed")
+			return err
+		})
+	}
+
+	if auth != nil {
+		// start the bearer token Secrets cache updater
+		errGrp.Go(func() error {
+			defer ctxDone()
+			err := auth.start(ctx)
+			lggr.Error(err, "bearer token Secrets cache informer failed")
+			return err
+		})
+	}
+
+	if upstreamCAPools != nil {
+		// start the upstream CA Secrets cache updater
+		errGrp.Go(func() error {
+			defer ctxDone()
+			err := upstreamCAPools.start(ctx)
+			lggr.Error(err, "upstream CA Secrets cache informer failed")
+			return err
+		})
+	}
+
+	// reload the configuration on SIGHUP, and
+	// whenever the config file changes
+	reloadSigs := make(chan os.Signal, 1)
+	signal.Notify(reloadSigs, syscall.SIGHUP)
+	errGrp.Go(func() error {
+		defer ctxDone()
+		err := reloads.run(ctx, reloadSigs, reloadCfg.ConfigFilePollInterval)
+		lggr.Error(err, "config reloader failed")
 		return err
 	})
 
-	// start the update loop that updates the routing table from
+	// interceptors that the scaler doesn't scrape as one of its
+	// fleets, like the ones that a namespace runs for itself,
+	// tell it where they are
+	if registrationCfg.Enabled() {
+		errGrp.Go(func() error {
+			defer ctxDone()
+			err := runScalerRegistration(
+				ctx,
+				lggr,
+				nethttp.DefaultClient,
+				*registrationCfg,
+				newScalerRegistration(
+					*registrationCfg,
+					servingCfg.CurrentNamespace,
+					adminPort,
+				),
+			)
+			lggr.Error(err, "scaler registration stopped")
+			return err
+		})
+	}
+
+	// start the informer that updates the routing table, either from
 	// the ConfigMap that the operator updates as HTTPScaledObjects
-	// enter and exit the system
+	// enter and exit the system, or from the HTTPScaledObjects directly
 	errGrp.Go(func() error {
 		defer ctxDone()
-		err := routing.StartConfigMapRoutingTableUpdater(
+		resyncEvery := time.Duration(servingCfg.RoutingTableResyncDurationMS) * time.Millisecond
+		if servingCfg.RoutingTableSource == config.RoutingTableSourceHTTPScaledObjects {
+			err := routing.StartHTTPScaledObjectRoutingTableInformer(
+				ctx,
+				lggr,
+				dynamicCl,
+				servingCfg.CurrentNamespace,
+				servingCfg.RoutingTableLabelSelector,
+				resyncEvery,
+				// the interceptor doesn't use the target pending
+				// requests, so there's no need for a default
+				0,
+				routingTable,
+				q,
+			)
+			lggr.Error(err, "HTTPScaledObject routing table informer failed")
+			return err
+		}
+		err := routing.StartConfigMapRoutingTableInformer(
 			ctx,
 			lggr,
-			time.Duration(servingCfg.RoutingTableUpdateDurationMS)*time.Millisecond,
-			configMapsInterface,
+			cl,
+			servingCfg.CurrentNamespace,
+			resyncEvery,
 			routingTable,
 			q,
 		)
-		lggr.Error(err, "config map routing table updater failed")
+		lggr.Error(err, "config map routing table informer failed")
 		return err
 	})
 
+	state := serverState{
+		q:            q,
+		routingTable: routingTable,
+		replicas:     replicasFunc,
+		buffer:       buffer,
+		limiter:      limiter,
+		respCache:    respCache,
+		concurrency:  concurrency,
+		scheduler:    scheduler,
+		pendingLimit: pendingLimit,
+		coldStarts:   coldStarts,
+		drops:        drops,
+	}
+
 	// start the administrative server. this is the server
 	// that serves the queue size API
 	errGrp.Go(func() error {
@@ -127,15 +583,16 @@ func main() {
 			"port",
 			adminPort,
 		)
-		err := runAdminServer(
-			ctx,
-			lggr,
-			configMapsInterface,
-			q,
-			routingTable,
-			deployCache,
-			adminPort,
-		)
+		err := runAdminServer(ctx, lggr, adminServerConfig{
+			serverState:        state,
+			cmGetter:           configMapsInterface,
+			routingTableSource: servingCfg.RoutingTableSource,
+			ns:                 servingCfg.CurrentNamespace,
+			deployCache:        deployCache,
+			admin:              adminCfg,
+			readyChecks:        readyChecks,
+			port:               adminPort,
+		})
 		lggr.Error(err, "admin server failed")
 		return err
 	})
@@ -149,15 +606,28 @@ func main() {
 			"port",
 			proxyPort,
 		)
-		err := runProxyServer(
-			ctx,
-			lggr,
-			q,
-			waitFunc,
-			routingTable,
-			timeoutCfg,
-			proxyPort,
-		)
+		err := runProxyServer(ctx, lggr, proxyServerConfig{
+			serverState:     state,
+			waitFunc:        waitFunc,
+			errPages:        errPages,
+			auth:            auth,
+			upstreamCAPools: upstreamCAPools,
+			fwdHeaders:      fwdHeaders,
+			deadLetters:     deadLetters,
+			resolver:        resolver,
+			reloads:         reloads,
+			timeouts:        timeoutCfg,
+			bodyLimits:      bodyLimitsCfg,
+			circuitBreaker:  circuitBreakerCfg,
+			accessLog:       accessLogCfg,
+			mirror:          mirrorCfg,
+			requestID:       requestIDCfg,
+			faultInjection:  faultInjectionCfg,
+			compression:     compressionCfg,
+			proxyTLS:        proxyTLSCfg,
+			proxyProtocol:   proxyProtocolCfg,
+			port:            proxyPort,
+		})
 		lggr.Error(err, "proxy server failed")
 		return err
 	})
@@ -169,73 +639,474 @@ func main() {
 	os.Exit(1)
 }
 
+// newSnapshotStore returns the queue.SnapshotStore configured in
+// cfg, or nil if snapshots are disabled
+func newSnapshotStore(
+	cfg *config.Snapshot,
+	servingCfg *config.Serving,
+	cmClient k8s.ConfigMapGetterCreatorUpdater,
+) (queue.SnapshotStore, error) {
+	switch cfg.Store {
+	case config.SnapshotStoreNone:
+		return nil, nil
+	case config.SnapshotStoreFile:
+		return queue.NewFileSnapshotStore(cfg.FilePath), nil
+	case config.SnapshotStoreConfigMap:
+		// each replica saves its snapshot under its own pod name
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		return queue.NewConfigMapSnapshotStore(
+			cmClient,
+			servingCfg.CurrentNamespace,
+			cfg.ConfigMapName,
+			hostname,
+		), nil
+	default:
+		return nil, fmt.Errorf("unknown queue snapshot store %q", cfg.Store)
+	}
+}
+
+// serverState is what the proxy server and the admin server share.
+// The proxy server keeps it up to date as it handles requests, and
+// the admin server reports it
+type serverState struct {
+	q            queue.Counter
+	routingTable *routing.Table
+	replicas     workloadReplicasFunc
+	buffer       *replayBuffer
+	limiter      *rateLimiter
+	respCache    *responseCache
+	concurrency  *concurrencyLimiter
+	scheduler    *fairScheduler
+	pendingLimit *pendingLimiter
+	coldStarts   *coldStartTracker
+	drops        *dropCounter
+}
+
+// adminServerConfig is what runAdminServer serves, and how
+type adminServerConfig struct {
+	serverState
+	cmGetter           k8s.ConfigMapGetter
+	routingTableSource string
+	ns                 string
+	deployCache        k8s.DeploymentCache
+	admin              *config.Admin
+	readyChecks        map[string]health.Check
+	port               int
+}
+
 func runAdminServer(
 	ctx context.Context,
 	lggr logr.Logger,
-	cmGetter k8s.ConfigMapGetter,
-	q queue.Counter,
-	routingTable *routing.Table,
-	deployCache k8s.DeploymentCache,
-	port int,
+	cfg adminServerConfig,
 ) error {
 	lggr = lggr.WithName("runAdminServer")
 	adminServer := nethttp.NewServeMux()
+	health.AddRoutes(lggr, adminServer, cfg.readyChecks)
+	// the counts are served with namespaced keys, so that the
+	// scaler can tell this namespace's hosts from other namespaces'
 	queue.AddCountsRoute(
 		lggr,
 		adminServer,
-		q,
+		cfg.q,
+		cfg.ns,
 	)
 	routing.AddFetchRoute(
 		lggr,
 		adminServer,
-		routingTable,
-	)
-	routing.AddPingRoute(
-		lggr,
-		adminServer,
-		cmGetter,
-		routingTable,
-		q,
+		cfg.routingTable,
 	)
+	// the ping route refreshes the routing table from the ConfigMap,
+	// which would clobber a table built from HTTPScaledObjects
+	if cfg.routingTableSource == config.RoutingTableSourceConfigMap {
+		routing.AddPingRoute(
+			lggr,
+			adminServer,
+			cfg.cmGetter,
+			cfg.routingTable,
+			cfg.q,
+		)
+	}
 	adminServer.HandleFunc(
 		"/deployments",
 		func(w nethttp.ResponseWriter, r *nethttp.Request) {
-			if err := json.NewEncoder(w).Encode(deployCache); err != nil {
+			if err := json.NewEncoder(w).Encode(cfg.deployCache); err != nil {
 				lggr.Error(err, "encoding deployment cache")
 			}
 		},
 	)
+	if cfg.buffer != nil {
+		adminServer.HandleFunc(
+			"/replay-buffer",
+			func(w nethttp.ResponseWriter, r *nethttp.Request) {
+				if err := json.NewEncoder(w).Encode(cfg.buffer); err != nil {
+					lggr.Error(err, "encoding replay buffer occupancy")
+				}
+			},
+		)
+	}
+	if cfg.limiter != nil {
+		adminServer.HandleFunc(
+			"/rate-limits",
+			func(w nethttp.ResponseWriter, r *nethttp.Request) {
+				if err := json.NewEncoder(w).Encode(cfg.limiter); err != nil {
+					lggr.Error(err, "encoding rate limiter stats")
+				}
+			},
+		)
+	}
+	if cfg.respCache != nil {
+		adminServer.HandleFunc(
+			"/response-cache",
+			func(w nethttp.ResponseWriter, r *nethttp.Request) {
+				if err := json.NewEncoder(w).Encode(cfg.respCache); err != nil {
+					lggr.Error(err, "encoding response cache stats")
+				}
+			},
+		)
+	}
+	if cfg.scheduler != nil {
+		adminServer.HandleFunc(
+			"/fair-scheduler",
+			func(w nethttp.ResponseWriter, r *nethttp.Request) {
+				if err := json.NewEncoder(w).Encode(cfg.scheduler); err != nil {
+					lggr.Error(err, "encoding fair scheduler stats")
+				}
+			},
+		)
+	}
+	if cfg.pendingLimit != nil {
+		adminServer.HandleFunc(
+			"/backpressure",
+			func(w nethttp.ResponseWriter, r *nethttp.Request) {
+				if err := json.NewEncoder(w).Encode(cfg.pendingLimit); err != nil {
+					lggr.Error(err, "encoding backpressure stats")
+				}
+			},
+		)
+	}
+	adminServer.HandleFunc(
+		"/concurrency",
+		func(w nethttp.ResponseWriter, r *nethttp.Request) {
+			if err := json.NewEncoder(w).Encode(cfg.concurrency); err != nil {
+				lggr.Error(err, "encoding concurrency limit stats")
+			}
+		},
+	)
+	adminServer.HandleFunc(
+		"/cold-starts",
+		func(w nethttp.ResponseWriter, r *nethttp.Request) {
+			if err := json.NewEncoder(w).Encode(cfg.coldStarts); err != nil {
+				lggr.Error(err, "encoding cold start histograms")
+			}
+		},
+	)
+	adminServer.HandleFunc(
+		"/dropped-requests",
+		func(w nethttp.ResponseWriter, r *nethttp.Request) {
+			if err := json.NewEncoder(w).Encode(cfg.drops); err != nil {
+				lggr.Error(err, "encoding dropped request counts")
+			}
+		},
+	)
+	if cfg.admin.Token != "" {
+		addDebugRoutes(
+			lggr,
+			adminServer,
+			cfg.admin.Token,
+			cfg.admin.ProfilingEnabled,
+			cfg.routingTable,
+			cfg.q,
+			cfg.deployCache,
+			cfg.replicas,
+		)
+	}
 
-	addr := fmt.Sprintf("0.0.0.0:%d", port)
+	addr := fmt.Sprintf("0.0.0.0:%d", cfg.port)
+	if cfg.admin.TLSEnabled() {
+		certs, err := kedatls.NewCertReloader(
+			cfg.admin.TLSCertFile,
+			cfg.admin.TLSKeyFile,
+			cfg.admin.TLSCAFile,
+			cfg.admin.TLSReloadInterval,
+		)
+		if err != nil {
+			return err
+		}
+		lggr.Info("admin server starting with mutual TLS", "address", addr)
+		// the kubelet doesn't have a client certificate, so
+		// it can only reach the health checks
+		return kedahttp.ServeContextTLS(
+			ctx,
+			addr,
+			certs.ServerConfig(tls.VerifyClientCertIfGiven),
+			kedatls.RequireClientCert(health.Paths, adminServer),
+		)
+	}
 	lggr.Info("admin server starting", "address", addr)
-	return kedahttp.ServeContext(ctx, addr, adminServer)
+	// cleartext HTTP/2 lets the scaler scrape the counts over a
+	// single connection. HTTP/1.1 clients, like the kubelet, are
+	// served as before
+	return kedahttp.ServeContext(
+		ctx,
+		addr,
+		h2c.NewHandler(adminServer, &http2.Server{}),
+	)
+}
+
+// proxyServerConfig is what runProxyServer chains into the proxy
+// server's handler, and how it serves it
+type proxyServerConfig struct {
+	serverState
+	waitFunc        forwardWaitFunc
+	errPages        *errorPages
+	auth            *authenticator
+	upstreamCAPools *upstreamCAs
+	fwdHeaders      *forwardedHeaders
+	deadLetters     *deadLetterer
+	resolver        *endpointsResolver
+	reloads         *reloader
+	timeouts        *config.Timeouts
+	bodyLimits      *config.BodyLimits
+	circuitBreaker  *config.CircuitBreaker
+	accessLog       *config.AccessLog
+	mirror          *config.Mirror
+	requestID       *config.RequestID
+	faultInjection  *config.FaultInjection
+	compression     *config.Compression
+	proxyTLS        *config.ProxyTLS
+	proxyProtocol   *config.ProxyProtocol
+	port            int
 }
 
 func runProxyServer(
 	ctx context.Context,
 	lggr logr.Logger,
-	q queue.Counter,
-	waitFunc forwardWaitFunc,
-	routingTable *routing.Table,
-	timeouts *config.Timeouts,
-	port int,
+	cfg proxyServerConfig,
 ) error {
 	lggr = lggr.WithName("runProxyServer")
-	dialer := kedanet.NewNetDialer(timeouts.Connect, timeouts.KeepAlive)
-	dialContextFunc := kedanet.DialContextWithRetry(dialer, timeouts.DefaultBackoff())
-	proxyHdl := countMiddleware(
+	dialer := kedanet.NewNetDialer(cfg.timeouts.Connect, cfg.timeouts.KeepAlive)
+	dialContextFunc := cfg.resolver.wrap(
+		kedanet.DialContextWithRetry(dialer, cfg.timeouts.DefaultBackoff()),
+	)
+	fwdCfg := newForwardingConfigFromTimeouts(cfg.timeouts)
+	fwdCfg.defaultBodyLimits = bodyLimits{
+		maxRequestBytes:  cfg.bodyLimits.MaxRequestBytes,
+		maxResponseBytes: cfg.bodyLimits.MaxResponseBytes,
+	}
+	fwdCfg.errorPages = cfg.errPages
+	fwdCfg.forwardedHeaders = cfg.fwdHeaders
+	fwdCfg.coldStarts = cfg.coldStarts
+	fwdCfg.scheduler = cfg.scheduler
+	fwdCfg.pendingLimit = cfg.pendingLimit
+	fwdCfg.proxyProtocolUpstream = cfg.proxyProtocol.Upstream
+	fwdCfg.upstreamCAs = cfg.upstreamCAPools
+	fwdHdl := newForwardingHandler(
 		lggr,
-		q,
-		newForwardingHandler(
+		cfg.routingTable,
+		dialContextFunc,
+		cfg.waitFunc,
+		fwdCfg,
+	)
+	cfg.reloads.setForwarding(fwdHdl)
+	// the concurrency limit goes behind the count middleware, so
+	// that the requests that wait for a slot count toward scaling
+	var proxyHdl nethttp.Handler = countMiddleware(
+		lggr,
+		cfg.q,
+		cfg.routingTable,
+		concurrencyLimitMiddleware(lggr, cfg.concurrency, cfg.routingTable, fwdHdl),
+	)
+	var srvOpts []kedahttp.ServerOption
+	if connQ, ok := cfg.q.(queue.ConnectionTracker); ok {
+		// the connection tracker goes right in front of the count
+		// middleware, so that it counts the same requests
+		tracker := newConnTracker(connQ)
+		proxyHdl = connTrackerMiddleware(tracker, proxyHdl)
+		srvOpts = append(srvOpts, tracker.serverOption())
+	}
+	// like the circuit breaker, the replay buffer goes in front of
+	// the count middleware so that rejected requests aren't counted
+	if cfg.buffer != nil {
+		proxyHdl = replayBufferMiddleware(
 			lggr,
-			routingTable,
-			dialContextFunc,
-			waitFunc,
-			newForwardingConfigFromTimeouts(timeouts),
-		),
+			cfg.routingTable,
+			cfg.replicas,
+			cfg.buffer,
+			proxyHdl,
+		)
+	}
+	// the mirror goes behind the circuit breaker, the response cache
+	// and the rate limiter, so that the requests they turn away, or
+	// answer themselves, aren't mirrored
+	proxyHdl = mirrorMiddleware(
+		cfg.routingTable,
+		newMirrorer(lggr, *cfg.mirror, &nethttp.Transport{
+			DialContext:         dialer.DialContext,
+			MaxIdleConnsPerHost: cfg.mirror.MaxConcurrent,
+		}),
+		randomSplit,
+		proxyHdl,
 	)
+	// the traffic split goes in front of everything that needs
+	// to know whether a request goes to a canary
+	proxyHdl = trafficSplitMiddleware(cfg.routingTable, randomSplit, proxyHdl)
+	// the circuit breaker goes in front of the count middleware,
+	// so that rejected requests never count as pending
+	if cfg.circuitBreaker.Enabled {
+		breakers := newCircuitBreakers(*cfg.circuitBreaker)
+		cfg.reloads.setCircuitBreakers(breakers)
+		proxyHdl = circuitBreakerMiddleware(lggr, breakers, proxyHdl)
+	}
+	// the response cache goes in front of the circuit breaker and
+	// the count middleware, so that cached responses can be served
+	// while the backend is unavailable or scaled to zero, without
+	// waking it up
+	if cfg.respCache != nil {
+		proxyHdl = responseCacheMiddleware(
+			lggr,
+			cfg.routingTable,
+			cfg.respCache,
+			proxyHdl,
+		)
+	}
+	// the rate limiter goes in front of the circuit breaker, so
+	// that rejected requests don't count against the backend
+	if cfg.limiter != nil {
+		proxyHdl = rateLimitMiddleware(
+			lggr,
+			cfg.limiter,
+			proxyHdl,
+		)
+	}
+	// auth goes in front of the rate limiter and the response cache,
+	// so that unauthenticated requests never use up the rate limit,
+	// get cached responses or count toward scaling. It's skipped
+	// unless a host asks for it
+	proxyHdl = authMiddleware(lggr, cfg.auth, cfg.routingTable, proxyHdl)
+	// client certificates are checked in front of auth, so that
+	// clients that aren't allowed never reach a forward auth service
+	proxyHdl = clientCertMiddleware(lggr, cfg.routingTable, proxyHdl)
+	// client IPs are checked in front of everything that might
+	// count the request or call out to another service, so that
+	// internal-only apps can turn away external traffic cheaply
+	proxyHdl = ipFilterMiddleware(lggr, cfg.routingTable, proxyHdl)
+	// injected faults go behind the access log, so that it logs them,
+	// and in front of everything else, so that aborted requests never
+	// use up the rate limit or wake up the backend
+	if cfg.faultInjection.Enabled {
+		proxyHdl = faultInjectionMiddleware(
+			lggr,
+			*cfg.faultInjection,
+			cfg.routingTable,
+			randomSplit,
+			proxyHdl,
+		)
+	}
+	// compression goes in front of everything that writes a
+	// response, like the response cache and the error pages, so
+	// that all of their responses get compressed
+	if cfg.compression.Enabled {
+		proxyHdl = compressionMiddleware(newCompressor(*cfg.compression), proxyHdl)
+	}
+	// the access log goes in front of everything else,
+	// so that it sees requests that were rejected too
+	if cfg.accessLog.Enabled {
+		proxyHdl = accessLogMiddleware(
+			lggr,
+			os.Stdout,
+			cfg.accessLog.SampleRate,
+			proxyHdl,
+		)
+	}
+	// dropped requests are counted in front of everything that
+	// can drop them
+	// dead letters go right behind the drop counter, which lets
+	// them see why requests were dropped, and in front of everything
+	// that reads request bodies
+	if cfg.deadLetters != nil {
+		proxyHdl = deadLetterMiddleware(cfg.deadLetters, proxyHdl)
+	}
+	proxyHdl = dropCounterMiddleware(cfg.drops, proxyHdl)
+	// the request ID goes in front of the access log,
+	// so that every log line has the ID
+	proxyHdl = requestIDMiddleware(*cfg.requestID, proxyHdl)
+	// the route is pinned in front of everything else, so that
+	// the whole chain sees the target the request was accepted for
+	proxyHdl = pinRouteMiddleware(cfg.routingTable, proxyHdl)
+	// the client IP is found in front of everything, so that the
+	// rate limiter, the IP filters and the access log agree on it
+	proxyHdl = clientIPMiddleware(cfg.fwdHeaders, proxyHdl)
+	// the hijacker is kept in front of everything that wraps the
+	// ResponseWriter, so that tunnels can take over connections
+	proxyHdl = hijackerMiddleware(proxyHdl)
 
-	addr := fmt.Sprintf("0.0.0.0:%d", port)
+	addr := fmt.Sprintf("0.0.0.0:%d", cfg.port)
+	var ln net.Listener
+	if cfg.proxyProtocol.Enabled {
+		trusted, err := parseTrustedProxies(cfg.proxyProtocol.TrustedCIDRs)
+		if err != nil {
+			return err
+		}
+		if ln, err = net.Listen("tcp", addr); err != nil {
+			return err
+		}
+		// the header comes before the TLS handshake, so the
+		// listener reads it before the server sees the connection
+		ln = kedanet.NewProxyProtocolListener(
+			ln,
+			cfg.proxyProtocol.HeaderTimeout,
+			trusted,
+		)
+		lggr.Info("proxy server reading PROXY protocol headers")
+	}
+	if cfg.proxyTLS.Enabled() {
+		certs, err := kedatls.NewCertReloader(
+			cfg.proxyTLS.CertFile,
+			cfg.proxyTLS.KeyFile,
+			cfg.proxyTLS.ClientCAFile,
+			cfg.proxyTLS.ReloadInterval,
+		)
+		if err != nil {
+			return err
+		}
+		clientAuth := tls.NoClientCert
+		switch {
+		case !cfg.proxyTLS.VerifiesClients():
+		case cfg.proxyTLS.ClientAuth == config.ProxyClientAuthRequire:
+			clientAuth = tls.RequireAndVerifyClientCert
+		default:
+			clientAuth = tls.VerifyClientCertIfGiven
+		}
+		lggr.Info(
+			"proxy server starting with TLS",
+			"address",
+			addr,
+			"clientAuth",
+			clientAuth.String(),
+		)
+		if ln != nil {
+			return kedahttp.ServeListenerContextTLS(
+				ctx,
+				ln,
+				certs.ServerConfig(clientAuth),
+				proxyHdl,
+				srvOpts...,
+			)
+		}
+		return kedahttp.ServeContextTLS(
+			ctx,
+			addr,
+			certs.ServerConfig(clientAuth),
+			proxyHdl,
+			srvOpts...,
+		)
+	}
 	lggr.Info("proxy server starting", "address", addr)
-	return kedahttp.ServeContext(ctx, addr, proxyHdl)
+	if ln != nil {
+		return kedahttp.ServeListenerContext(ctx, ln, proxyHdl, srvOpts...)
+	}
+	return kedahttp.ServeContext(ctx, addr, proxyHdl, srvOpts...)
 }
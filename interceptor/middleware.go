@@ -1,15 +1,26 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"net"
 	nethttp "net/http"
 
 	"github.com/go-logr/logr"
 	"github.com/kedacore/http-add-on/pkg/queue"
+	"github.com/kedacore/http-add-on/pkg/routing"
 )
 
 func getHost(r *nethttp.Request) (string, error) {
+	// CONNECT requests name the port to tunnel to along
+	// with the host
+	if r.Method == nethttp.MethodConnect {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil || host == "" {
+			return "", fmt.Errorf("host not found")
+		}
+		return host, nil
+	}
 	// check the host header first, then the request host
 	// field (which may contain the actual URL if there is no
 	// host header)
@@ -22,11 +33,52 @@ func getHost(r *nethttp.Request) (string, error) {
 	return "", fmt.Errorf("host not found")
 }
 
+type pendingKey struct{}
+
+// startPending records that the request that ctx belongs to started
+// waiting for its backend to be ready, if the queue it's counted in
+// tracks pending requests. The returned func records that it stopped
+// waiting, and must be called exactly once
+func startPending(ctx context.Context) func() {
+	start, ok := ctx.Value(pendingKey{}).(func() func())
+	if !ok {
+		return func() {}
+	}
+	return start()
+}
+
+type streamingKey struct{}
+
+// startStreaming records that the request that ctx belongs to got its
+// response headers, and is streaming its response body, if the queue
+// it's counted in tracks streaming requests. The returned func records
+// that its response ended, and must be called exactly once
+func startStreaming(ctx context.Context) func() {
+	start, ok := ctx.Value(streamingKey{}).(func() func())
+	if !ok {
+		return func() {}
+	}
+	return start()
+}
+
 // countMiddleware adds 1 to the given queue counter, executes next
-// (by calling ServeHTTP on it), then decrements the queue counter
+// (by calling ServeHTTP on it), then decrements the queue counter.
+// The request's ID is logged when it enters and exits the queue, at
+// verbosity 1, so that a count that never goes back down can be traced
+// to the request that leaked it.
+// Requests that were routed to a canary are counted under the
+// host's canary queue key. If q is a queue.PendingTracker, handlers
+// further down the chain can use startPending to mark the time that
+// the request spends waiting for its backend. Likewise, if q is a
+// queue.StreamingTracker, they can use startStreaming to mark the
+// time that the request spends streaming its response.
+// Probe requests to hosts in routingTable, like health checks from
+// load balancers, and requests with methods that their hosts don't
+// count, are passed to next without being counted
 func countMiddleware(
 	lggr logr.Logger,
 	q queue.Counter,
+	routingTable routing.TableReader,
 	next nethttp.Handler,
 ) nethttp.Handler {
 	return nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
@@ -37,14 +89,46 @@ func countMiddleware(
 			w.Write([]byte("Host not found, not forwarding request"))
 			return
 		}
-		if err := q.Resize(host, +1); err != nil {
-			log.Printf("Error incrementing queue for %q (%s)", r.RequestURI, err)
+		if target, err := lookupTarget(r.Context(), routingTable, host); err == nil &&
+			(target.Probes.Matches(r.URL.Path, r.UserAgent()) || !target.CountsMethod(r.Method)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := queueKey(r.Context(), host)
+		reqLggr := lggr.WithValues(
+			"requestID",
+			requestIDFromContext(r.Context()),
+			"key",
+			key,
+		)
+		// only a request that was counted may be uncounted, or a
+		// failed increment would leak a -1 into the queue
+		if err := q.Resize(key, +1); err != nil {
+			reqLggr.Error(err, "incrementing queue", "uri", r.RequestURI)
+		} else {
+			reqLggr.V(1).Info("request entered queue")
+			defer func() {
+				if err := q.Resize(key, -1); err != nil {
+					reqLggr.Error(err, "decrementing queue", "uri", r.RequestURI)
+					return
+				}
+				reqLggr.V(1).Info("request exited queue")
+			}()
+		}
+		if tracker, ok := q.(queue.PendingTracker); ok {
+			r = r.WithContext(context.WithValue(
+				r.Context(),
+				pendingKey{},
+				func() func() { return tracker.StartPending(key) },
+			))
+		}
+		if tracker, ok := q.(queue.StreamingTracker); ok {
+			r = r.WithContext(context.WithValue(
+				r.Context(),
+				streamingKey{},
+				func() func() { return tracker.StartStreaming(key) },
+			))
 		}
-		defer func() {
-			if err := q.Resize(host, -1); err != nil {
-				log.Printf("Error decrementing queue for %q (%s)", r.RequestURI, err)
-			}
-		}()
 		next.ServeHTTP(w, r)
 	})
 }
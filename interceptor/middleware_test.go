@@ -11,6 +11,7 @@ import (
 
 	"github.com/go-logr/logr"
 	"github.com/kedacore/http-add-on/pkg/queue"
+	"github.com/kedacore/http-add-on/pkg/routing"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/sync/errgroup"
 )
@@ -23,6 +24,7 @@ func TestCountMiddleware(t *testing.T) {
 	middleware := countMiddleware(
 		logr.Discard(),
 		queueCounter,
+		routing.NewTable(),
 		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(200)
 			w.Write([]byte("OK"))
@@ -123,3 +125,132 @@ func expectResizes(
 
 	return agg, respRecorder
 }
+
+func TestCountMiddlewarePending(t *testing.T) {
+	const host = "testingkeda.com"
+	r := require.New(t)
+	q := queue.NewMemory()
+	middleware := countMiddleware(
+		logr.Discard(),
+		q,
+		routing.NewTable(),
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			donePending := startPending(req.Context())
+			cts, err := q.Current()
+			r.NoError(err)
+			r.Equal(1, cts.Host(host).Pending)
+			r.Equal(0, cts.Host(host).Active)
+
+			donePending()
+			cts, err = q.Current()
+			r.NoError(err)
+			r.Equal(0, cts.Host(host).Pending)
+			r.Equal(1, cts.Host(host).Active)
+			w.WriteHeader(200)
+		}),
+	)
+	req, err := http.NewRequest("GET", "/something", nil)
+	r.NoError(err)
+	req.Host = host
+	respRecorder := httptest.NewRecorder()
+	middleware.ServeHTTP(respRecorder, req)
+	r.Equal(200, respRecorder.Code)
+
+	cts, err := q.Current()
+	r.NoError(err)
+	hc := cts.Host(host)
+	r.Equal(0, hc.Active+hc.Pending)
+	// the request's finish is the host's last request
+	r.NotNil(hc.LastRequestAgeMS)
+
+	// requests outside of the count middleware have nothing to track
+	startPending(context.Background())()
+}
+
+func TestCountMiddlewareProbes(t *testing.T) {
+	const host = "TestCountMiddlewareProbes.testing"
+	r := require.New(t)
+	q := queue.NewMemory()
+	table := routing.NewTable()
+	r.NoError(table.AddTarget(host, routing.Target{
+		Service:    "testsvc",
+		Port:       8080,
+		Deployment: "testdepl",
+		Probes: &routing.ProbePolicy{
+			Paths:      []string{"/healthz"},
+			UserAgents: []string{"ELB-HealthChecker/"},
+		},
+	}))
+	counted := 0
+	middleware := countMiddleware(
+		logr.Discard(),
+		q,
+		table,
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			cts, err := q.Current()
+			r.NoError(err)
+			counted += cts.Host(host).Active
+			w.WriteHeader(200)
+		}),
+	)
+
+	// probes are forwarded, but not counted
+	for path, userAgent := range map[string]string{
+		"/healthz": "kube-probe/1.22",
+		"/":        "ELB-HealthChecker/2.0",
+	} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Host = host
+		req.Header.Set("User-Agent", userAgent)
+		respRecorder := httptest.NewRecorder()
+		middleware.ServeHTTP(respRecorder, req)
+		r.Equal(200, respRecorder.Code)
+	}
+	r.Equal(0, counted)
+	cts, err := q.Current()
+	r.NoError(err)
+	r.Nil(cts.Host(host).LastRequestAgeMS)
+
+	// everything else is
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Host = host
+	middleware.ServeHTTP(httptest.NewRecorder(), req)
+	r.Equal(1, counted)
+}
+
+func TestCountMiddlewareCountedMethods(t *testing.T) {
+	const host = "TestCountMiddlewareCountedMethods.testing"
+	r := require.New(t)
+	q := queue.NewMemory()
+	table := routing.NewTable()
+	r.NoError(table.AddTarget(host, routing.Target{
+		Service:        "testsvc",
+		Port:           8080,
+		Deployment:     "testdepl",
+		CountedMethods: []string{"POST", "PUT"},
+	}))
+	counted := 0
+	forwarded := 0
+	middleware := countMiddleware(
+		logr.Discard(),
+		q,
+		table,
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			cts, err := q.Current()
+			r.NoError(err)
+			counted += cts.Host(host).Active
+			forwarded++
+			w.WriteHeader(200)
+		}),
+	)
+
+	// every request is forwarded, but only the ones with counted
+	// methods are counted
+	for _, method := range []string{"GET", "HEAD", "POST", "PUT", "DELETE"} {
+		req := httptest.NewRequest(method, "/", nil)
+		req.Host = host
+		middleware.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	r.Equal(5, forwarded)
+	r.Equal(2, counted)
+}
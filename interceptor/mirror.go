@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/interceptor/config"
+	"github.com/kedacore/http-add-on/pkg/routing"
+)
+
+// mirroredHeader is set on the copies of requests that are sent to
+// mirror services, so that they can tell them apart from the originals
+const mirroredHeader = "X-Keda-Http-Mirrored"
+
+// readCloser reads from Reader and closes Closer, so that a request
+// body that was read ahead can be replaced, while still closing the
+// original body
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// mirrorer sends copies of requests to mirror services in the
+// background, and discards their responses
+type mirrorer struct {
+	lggr logr.Logger
+	cfg  config.Mirror
+	cl   *http.Client
+	// inFlight holds a token for every mirrored
+	// request that's in flight
+	inFlight chan struct{}
+}
+
+func newMirrorer(
+	lggr logr.Logger,
+	cfg config.Mirror,
+	transport http.RoundTripper,
+) *mirrorer {
+	return &mirrorer{
+		lggr: lggr.WithName("mirrorer"),
+		cfg:  cfg,
+		cl: &http.Client{
+			Transport: transport,
+			Timeout:   cfg.Timeout,
+			// the mirror's responses are discarded,
+			// so there's no use in following redirects
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		inFlight: make(chan struct{}, cfg.MaxConcurrent),
+	}
+}
+
+// mirror sends a copy of r, with body as its body, to svcURL in the
+// background. Returns false if the copy wasn't sent because there are
+// too many mirrored requests in flight already
+func (m *mirrorer) mirror(r *http.Request, body []byte, svcURL *url.URL) bool {
+	select {
+	case m.inFlight <- struct{}{}:
+	default:
+		return false
+	}
+	u := *svcURL
+	u.Path = r.URL.Path
+	u.RawPath = r.URL.RawPath
+	u.RawQuery = r.URL.RawQuery
+	// the copy isn't tied to r's context, so that it's not
+	// cancelled as soon as the original request is done
+	req, err := http.NewRequestWithContext(
+		context.Background(),
+		r.Method,
+		u.String(),
+		bytes.NewReader(body),
+	)
+	if err != nil {
+		<-m.inFlight
+		m.lggr.Error(err, "creating mirrored request", "url", u.String())
+		return true
+	}
+	req.Header = r.Header.Clone()
+	req.Header.Set(mirroredHeader, "true")
+	req.Host = r.Host
+	go func() {
+		defer func() { <-m.inFlight }()
+		res, err := m.cl.Do(req)
+		if err != nil {
+			m.lggr.V(1).Info("mirrored request failed", "url", u.String(), "error", err.Error())
+			return
+		}
+		defer res.Body.Close()
+		io.Copy(ioutil.Discard, res.Body)
+	}()
+	return true
+}
+
+// mirrorMiddleware sends copies of a share of the requests to each host
+// that has a mirror to the mirror, using m, as it forwards the original
+// requests to next. Whether a request is mirrored is decided with split.
+//
+// Requests with bodies larger than m's maximum aren't mirrored, and
+// neither are requests that were upgraded to another protocol, like
+// websockets, or tunnels, since they can't be replayed
+func mirrorMiddleware(
+	routingTable routing.TableReader,
+	m *mirrorer,
+	split splitFunc,
+	next http.Handler,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, err := getHost(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		target, err := lookupTarget(r.Context(), routingTable, host)
+		if err != nil ||
+			target.Mirror == nil ||
+			r.Header.Get("Upgrade") != "" ||
+			r.Method == http.MethodConnect ||
+			r.ContentLength > m.cfg.MaxBodyBytes ||
+			!split(target.Mirror.Percent) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		svcURL, err := target.Mirror.ServiceURL()
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// the body is read ahead so that it can be sent twice.
+		// if it turns out to be too large, the original request
+		// gets the part that was read, followed by the rest
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, m.cfg.MaxBodyBytes+1))
+		if err != nil || int64(len(body)) > m.cfg.MaxBodyBytes {
+			r.Body = readCloser{
+				Reader: io.MultiReader(bytes.NewReader(body), r.Body),
+				Closer: r.Body,
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		r.Body = readCloser{Reader: bytes.NewReader(body), Closer: r.Body}
+		if m.mirror(r, body, svcURL) {
+			if logEntry := accessLogEntryFromContext(r.Context()); logEntry != nil {
+				logEntry.Mirrored = true
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
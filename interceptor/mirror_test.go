@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/interceptor/config"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMirrorMiddleware(t *testing.T) {
+	const host = "TestMirrorMiddleware.testing"
+	r := require.New(t)
+
+	type mirrored struct {
+		method, uri, host, body, header string
+	}
+	mirroredCh := make(chan mirrored, 10)
+	mirrorSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		mirroredCh <- mirrored{
+			method: req.Method,
+			uri:    req.URL.RequestURI(),
+			host:   req.Host,
+			body:   string(body),
+			header: req.Header.Get(mirroredHeader),
+		}
+		w.WriteHeader(500)
+	}))
+	defer mirrorSrv.Close()
+	mirrorURL, err := url.Parse(mirrorSrv.URL)
+	r.NoError(err)
+	mirrorPort, err := strconv.Atoi(mirrorURL.Port())
+	r.NoError(err)
+
+	table := routing.NewTable()
+	target := routing.NewTarget("testsvc", 8080, "testdepl", 100)
+	target.Mirror = &routing.MirrorTarget{
+		Service: mirrorURL.Hostname(),
+		Port:    mirrorPort,
+		Percent: 50,
+	}
+	r.NoError(table.AddTarget(host, target))
+
+	toMirror := true
+	splitWeights := []int{}
+	split := func(weight int) bool {
+		splitWeights = append(splitWeights, weight)
+		return toMirror
+	}
+	m := newMirrorer(logr.Discard(), config.Mirror{
+		MaxConcurrent: 10,
+		MaxBodyBytes:  10,
+		Timeout:       time.Second,
+	}, http.DefaultTransport)
+	var gotBody string
+	hdl := mirrorMiddleware(table, m, split, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		r.NoError(err)
+		gotBody = string(body)
+		w.WriteHeader(200)
+	}))
+	do := func(body string) int {
+		req := httptest.NewRequest("POST", "/some/path?a=b", strings.NewReader(body))
+		req.Host = host
+		// the length of chunked bodies isn't known up front
+		req.ContentLength = -1
+		res := httptest.NewRecorder()
+		hdl.ServeHTTP(res, req)
+		return res.Code
+	}
+
+	// the original gets the backend's response, not the mirror's,
+	// and the mirror gets an exact copy
+	r.Equal(200, do("hello"))
+	r.Equal("hello", gotBody)
+	r.Equal([]int{50}, splitWeights)
+	select {
+	case got := <-mirroredCh:
+		r.Equal(mirrored{
+			method: "POST",
+			uri:    "/some/path?a=b",
+			host:   host,
+			body:   "hello",
+			header: "true",
+		}, got)
+	case <-time.After(time.Second):
+		r.Fail("the request wasn't mirrored")
+	}
+
+	// requests with bodies that are too large aren't mirrored,
+	// and the original still gets the whole body
+	r.Equal(200, do("hello, world"))
+	r.Equal("hello, world", gotBody)
+
+	// neither are requests that aren't split off
+	toMirror = false
+	r.Equal(200, do("hello"))
+	r.Equal("hello", gotBody)
+
+	select {
+	case got := <-mirroredCh:
+		r.Fail("unexpected mirrored request", "%v", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kedacore/http-add-on/interceptor/config"
+)
+
+// pendingLimiter caps the number of requests that wait for their
+// backends at once, across all hosts, and softly caps the number that
+// wait for each host's backend. Requests past either cap are rejected
+// right away, with a Retry-After, instead of holding memory and a
+// connection while they wait
+type pendingLimiter struct {
+	max        int64
+	retryAfter string
+	// minRetryAfter is the shortest Retry-After of the requests that
+	// are past the per-host cap
+	minRetryAfter time.Duration
+	pending       int64
+	rejected      int64
+	// hostMax is the per-host cap. 0 means there's none. mut
+	// guards hosts, the requests that are waiting for each host's
+	// backend, and hostRejected
+	hostMax      int
+	mut          *sync.Mutex
+	hosts        map[string]*hostPending
+	hostRejected int64
+}
+
+// hostPending is the requests that are waiting for a host's backend
+type hostPending struct {
+	count int
+	// since is when the oldest of them started waiting
+	since time.Time
+}
+
+// newPendingLimiter returns a pendingLimiter for cfg, or nil if cfg
+// has no maximum
+func newPendingLimiter(cfg config.Backpressure) *pendingLimiter {
+	if cfg.MaxPendingRequests <= 0 && cfg.SoftMaxPendingRequestsPerHost <= 0 {
+		return nil
+	}
+	return &pendingLimiter{
+		max:           int64(cfg.MaxPendingRequests),
+		retryAfter:    retryAfterSeconds(cfg.RetryAfter),
+		minRetryAfter: cfg.RetryAfter,
+		hostMax:       cfg.SoftMaxPendingRequestsPerHost,
+		mut:           new(sync.Mutex),
+		hosts:         map[string]*hostPending{},
+	}
+}
+
+// retryAfterSeconds returns d as the value of a Retry-After header,
+// rounded up to whole seconds, and at least 1
+func retryAfterSeconds(d time.Duration) string {
+	secs := int64(math.Ceil(d.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	return strconv.FormatInt(secs, 10)
+}
+
+// tryAcquire makes room for one more pending request to host, and
+// returns whether there was any. Each successful call must be followed
+// by exactly one call to release. A nil pendingLimiter always has room
+func (p *pendingLimiter) tryAcquire(host string) bool {
+	if p == nil {
+		return true
+	}
+	if p.hostMax > 0 {
+		p.mut.Lock()
+		defer p.mut.Unlock()
+		if hp := p.hosts[host]; hp != nil && hp.count >= p.hostMax {
+			p.hostRejected++
+			return false
+		}
+	}
+	if atomic.AddInt64(&p.pending, 1) > p.max && p.max > 0 {
+		atomic.AddInt64(&p.pending, -1)
+		atomic.AddInt64(&p.rejected, 1)
+		return false
+	}
+	if p.hostMax > 0 {
+		hp := p.hosts[host]
+		if hp == nil {
+			hp = &hostPending{since: time.Now()}
+			p.hosts[host] = hp
+		}
+		hp.count++
+	}
+	return true
+}
+
+// release gives back the room that a successful tryAcquire for host
+// made. It does nothing if p is nil
+func (p *pendingLimiter) release(host string) {
+	if p == nil {
+		return
+	}
+	atomic.AddInt64(&p.pending, -1)
+	if p.hostMax <= 0 {
+		return
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	if hp := p.hosts[host]; hp != nil {
+		hp.count--
+		if hp.count <= 0 {
+			delete(p.hosts, host)
+		}
+	}
+}
+
+// hostRetryAfter returns how long clients of host should wait before
+// they try again, and true, if host is past its soft cap. That's how
+// much longer host's backend usually takes to scale up from zero,
+// going by coldStarts, or p.minRetryAfter if it's about to be ready or
+// there's no telling
+func (p *pendingLimiter) hostRetryAfter(
+	host string,
+	coldStarts *coldStartTracker,
+	now time.Time,
+) (time.Duration, bool) {
+	if p.hostMax <= 0 {
+		return 0, false
+	}
+	p.mut.Lock()
+	hp := p.hosts[host]
+	if hp == nil || hp.count < p.hostMax {
+		p.mut.Unlock()
+		return 0, false
+	}
+	waited := now.Sub(hp.since)
+	p.mut.Unlock()
+	ret := p.minRetryAfter
+	if usual, ok := coldStarts.meanDuration(host); ok && usual-waited > ret {
+		ret = usual - waited
+	}
+	return ret, true
+}
+
+// reject writes the response to a request to host that there was no
+// room for. Requests that are past host's soft cap are told to retry
+// once host's backend is likely to be ready. See hostRetryAfter
+func (p *pendingLimiter) reject(
+	w http.ResponseWriter,
+	host string,
+	coldStarts *coldStartTracker,
+) {
+	if retryAfter, ok := p.hostRetryAfter(host, coldStarts, time.Now()); ok {
+		w.Header().Set("Retry-After", retryAfterSeconds(retryAfter))
+		w.WriteHeader(503)
+		w.Write([]byte("too many requests are waiting for this host's backend"))
+		return
+	}
+	w.Header().Set("Retry-After", p.retryAfter)
+	w.WriteHeader(503)
+	w.Write([]byte("too many requests are waiting for their backends"))
+}
+
+// MarshalJSON implements json.Marshaler. It returns the number of
+// requests that are pending, and the number that were rejected
+func (p *pendingLimiter) MarshalJSON() ([]byte, error) {
+	p.mut.Lock()
+	hostRejected := p.hostRejected
+	p.mut.Unlock()
+	return json.Marshal(struct {
+		Max             int64 `json:"max"`
+		Pending         int64 `json:"pending"`
+		Rejected        int64 `json:"rejected"`
+		SoftMaxPerHost  int   `json:"softMaxPerHost,omitempty"`
+		SoftMaxRejected int64 `json:"softMaxRejected,omitempty"`
+	}{
+		Max:             p.max,
+		Pending:         atomic.LoadInt64(&p.pending),
+		Rejected:        atomic.LoadInt64(&p.rejected),
+		SoftMaxPerHost:  p.hostMax,
+		SoftMaxRejected: hostRejected,
+	})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/interceptor/config"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPendingLimiter(t *testing.T) {
+	r := require.New(t)
+	r.Nil(newPendingLimiter(config.Backpressure{RetryAfter: time.Second}))
+	var nilLimiter *pendingLimiter
+	r.True(nilLimiter.tryAcquire("host1"))
+	nilLimiter.release("host1")
+
+	limiter := newPendingLimiter(config.Backpressure{
+		MaxPendingRequests: 2,
+		RetryAfter:         1500 * time.Millisecond,
+	})
+	r.True(limiter.tryAcquire("host1"))
+	r.True(limiter.tryAcquire("host1"))
+	r.False(limiter.tryAcquire("host1"))
+	limiter.release("host1")
+	r.True(limiter.tryAcquire("host1"))
+
+	b, err := json.Marshal(limiter)
+	r.NoError(err)
+	r.JSONEq(`{"max":2,"pending":2,"rejected":1}`, string(b))
+
+	w := httptest.NewRecorder()
+	limiter.reject(w, "host1", nil)
+	r.Equal(503, w.Code)
+	r.Equal("2", w.Header().Get("Retry-After"))
+}
+
+func TestPendingLimiterPerHost(t *testing.T) {
+	r := require.New(t)
+	limiter := newPendingLimiter(config.Backpressure{
+		SoftMaxPendingRequestsPerHost: 2,
+		RetryAfter:                    time.Second,
+	})
+	r.NotNil(limiter)
+	r.True(limiter.tryAcquire("host1"))
+	r.True(limiter.tryAcquire("host1"))
+	r.False(limiter.tryAcquire("host1"))
+	// other hosts have their own maximum
+	r.True(limiter.tryAcquire("host2"))
+
+	// without cold starts to go by, clients are
+	// told to retry after the shortest Retry-After
+	w := httptest.NewRecorder()
+	limiter.reject(w, "host1", nil)
+	r.Equal(503, w.Code)
+	r.Equal("1", w.Header().Get("Retry-After"))
+
+	// host1's backend usually takes 10 seconds to scale up, and its
+	// oldest request started waiting 3 seconds ago
+	coldStarts := newColdStartTracker(logr.Discard(), config.ColdStart{}, nil, nil)
+	coldStarts.observe("host1", routing.Target{}, 8*time.Second)
+	coldStarts.observe("host1", routing.Target{}, 12*time.Second)
+	now := limiter.hosts["host1"].since.Add(3 * time.Second)
+	retryAfter, ok := limiter.hostRetryAfter("host1", coldStarts, now)
+	r.True(ok)
+	r.Equal(7*time.Second, retryAfter)
+	// once it's past its usual cold start, the shortest one
+	retryAfter, ok = limiter.hostRetryAfter("host1", coldStarts, now.Add(time.Minute))
+	r.True(ok)
+	r.Equal(time.Second, retryAfter)
+	_, ok = limiter.hostRetryAfter("host2", coldStarts, now)
+	r.False(ok)
+
+	limiter.release("host1")
+	r.True(limiter.tryAcquire("host1"))
+	limiter.release("host1")
+	limiter.release("host1")
+	limiter.release("host2")
+	r.Empty(limiter.hosts)
+
+	b, err := json.Marshal(limiter)
+	r.NoError(err)
+	r.JSONEq(
+		`{"max":0,"pending":0,"rejected":0,"softMaxPerHost":2,"softMaxRejected":1}`,
+		string(b),
+	)
+}
+
+// the proxy should reject requests that would wait for their backend
+// while too many others are waiting already
+func TestProxyRejectsPastMaxPending(t *testing.T) {
+	const host = "TestProxyRejectsPastMaxPending.testing"
+	r := require.New(t)
+	routingTable := routing.NewTable()
+	routingTable.AddTarget(host, routing.Target{
+		Service:    "nosuchsvc",
+		Port:       9091,
+		Deployment: "nosuchdepl",
+	})
+	waitFunc, waitFuncCalledCh, finishWaitFunc := notifyingFunc()
+	defer finishWaitFunc()
+	timeouts := defaultTimeouts()
+	hdl := newForwardingHandler(
+		logr.Discard(),
+		routingTable,
+		retryDialContextFunc(timeouts, timeouts.DefaultBackoff()),
+		waitFunc,
+		forwardingConfig{
+			waitTimeout:       timeouts.DeploymentReplicas,
+			respHeaderTimeout: timeouts.ResponseHeader,
+			pendingLimit: newPendingLimiter(config.Backpressure{
+				MaxPendingRequests: 1,
+				RetryAfter:         time.Second,
+			}),
+		},
+	)
+
+	ctx, done := context.WithCancel(context.Background())
+	defer done()
+	res, req, err := reqAndRes("/testfwd")
+	r.NoError(err)
+	req.Host = host
+	go hdl.ServeHTTP(res, req.WithContext(ctx))
+	r.NoError(waitForSignal(waitFuncCalledCh, time.Second))
+
+	res, req, err = reqAndRes("/testfwd")
+	r.NoError(err)
+	req.Host = host
+	hdl.ServeHTTP(res, req)
+	r.Equal(503, res.Code)
+	r.Equal("1", res.Header().Get("Retry-After"))
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// runtimeStats is a summary of the interceptor's memory and goroutine
+// use, for spotting leaks without taking a full profile
+type runtimeStats struct {
+	Goroutines int `json:"goroutines"`
+	GOMAXPROCS int `json:"gomaxprocs"`
+	// HeapAllocBytes and HeapObjects are the live heap, and
+	// HeapSysBytes is the memory obtained for it from the OS
+	HeapAllocBytes uint64 `json:"heapAllocBytes"`
+	HeapObjects    uint64 `json:"heapObjects"`
+	HeapSysBytes   uint64 `json:"heapSysBytes"`
+	// SysBytes is all the memory obtained from the OS
+	SysBytes uint64 `json:"sysBytes"`
+	NumGC    uint32 `json:"numGC"`
+	// LastGC is when the last garbage collection finished. It's
+	// the zero time if there hasn't been one
+	LastGC time.Time `json:"lastGC"`
+	// PauseTotalMS is the total time, in milliseconds, that garbage
+	// collections stopped the world for
+	PauseTotalMS float64 `json:"pauseTotalMS"`
+}
+
+// currentRuntimeStats reads the runtimeStats of the process. It
+// stops the world briefly, like runtime.ReadMemStats does
+func currentRuntimeStats() runtimeStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	ret := runtimeStats{
+		Goroutines:     runtime.NumGoroutine(),
+		GOMAXPROCS:     runtime.GOMAXPROCS(0),
+		HeapAllocBytes: mem.HeapAlloc,
+		HeapObjects:    mem.HeapObjects,
+		HeapSysBytes:   mem.HeapSys,
+		SysBytes:       mem.Sys,
+		NumGC:          mem.NumGC,
+		PauseTotalMS:   float64(mem.PauseTotalNs) / float64(time.Millisecond),
+	}
+	if mem.LastGC > 0 {
+		ret.LastGC = time.Unix(0, int64(mem.LastGC)).UTC()
+	}
+	return ret
+}
+
+// dumpProfiles are the profiles that the dump route writes
+var dumpProfiles = map[string]struct{}{
+	"goroutine": {},
+	"heap":      {},
+}
+
+// addProfilingRoutes adds routes to debugMux, which must only serve
+// requests with the admin token, for diagnosing leaks in the proxy:
+//
+//   - /admin/debug/pprof/ serves the net/http/pprof profiles
+//   - /admin/runtime returns the current runtimeStats
+//   - POST /admin/dump?profile=<goroutine|heap> returns a readable
+//     dump of every goroutine's stack, or of the heap right after a
+//     garbage collection
+func addProfilingRoutes(lggr logr.Logger, debugMux *http.ServeMux) {
+	lggr = lggr.WithName("addProfilingRoutes")
+	// the pprof handlers find the profile to serve in the path
+	// after /debug/pprof/
+	pprofMux := http.NewServeMux()
+	pprofMux.HandleFunc("/debug/pprof/", pprof.Index)
+	pprofMux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	pprofMux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	pprofMux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	pprofMux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	debugMux.Handle(
+		adminDebugPathPrefix+"debug/pprof/",
+		http.StripPrefix("/admin", pprofMux),
+	)
+	debugMux.HandleFunc(
+		adminDebugPathPrefix+"runtime",
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(currentRuntimeStats()); err != nil {
+				lggr.Error(err, "encoding runtime stats")
+			}
+		},
+	)
+	debugMux.HandleFunc(
+		adminDebugPathPrefix+"dump",
+		func(w http.ResponseWriter, r *http.Request) {
+			// dumps are expensive, so they're never
+			// taken by a stray GET, like a prefetch
+			if r.Method != http.MethodPost {
+				w.Header().Set("Allow", http.MethodPost)
+				w.WriteHeader(405)
+				w.Write([]byte("dumps must be requested with POST"))
+				return
+			}
+			name := r.URL.Query().Get("profile")
+			if _, ok := dumpProfiles[name]; !ok {
+				w.WriteHeader(400)
+				w.Write([]byte("'profile' must be goroutine or heap"))
+				return
+			}
+			if name == "heap" {
+				runtime.GC()
+			}
+			lggr.Info("writing dump", "profile", name)
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Header().Set(
+				"Content-Disposition",
+				fmt.Sprintf(`attachment; filename="%s-%d.txt"`, name, time.Now().Unix()),
+			)
+			// debug level 2 writes goroutine stacks like an
+			// unrecovered panic would, and 1 writes the heap
+			// with symbolized stacks
+			debug := 1
+			if name == "goroutine" {
+				debug = 2
+			}
+			if err := runtimepprof.Lookup(name).WriteTo(w, debug); err != nil {
+				lggr.Error(err, "writing dump", "profile", name)
+			}
+		},
+	)
+	lggr.Info("adding admin profiling routes", "prefix", adminDebugPathPrefix)
+}
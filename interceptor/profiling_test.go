@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/k8s"
+	"github.com/kedacore/http-add-on/pkg/queue"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfilingRoutes(t *testing.T) {
+	const token = "testtoken"
+	r := require.New(t)
+	deployCache := k8s.NewFakeDeploymentCache()
+	mux := http.NewServeMux()
+	addDebugRoutes(
+		logr.Discard(),
+		mux,
+		token,
+		true,
+		routing.NewTable(),
+		queue.NewMemory(),
+		deployCache,
+		newWorkloadReplicasFunc(deployCache, nil, ""),
+	)
+
+	do := func(method, path, authz string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		if authz != "" {
+			req.Header.Set("Authorization", authz)
+		}
+		mux.ServeHTTP(res, req)
+		return res
+	}
+
+	// the profiling routes need the token like the others
+	r.Equal(401, do("GET", "/admin/debug/pprof/", "").Code)
+	r.Equal(401, do("GET", "/admin/runtime", "").Code)
+	r.Equal(401, do("POST", "/admin/dump?profile=goroutine", "").Code)
+
+	res := do("GET", "/admin/debug/pprof/", "Bearer "+token)
+	r.Equal(200, res.Code)
+	r.Contains(res.Body.String(), "goroutine")
+	res = do("GET", "/admin/debug/pprof/goroutine?debug=1", "Bearer "+token)
+	r.Equal(200, res.Code)
+	r.Contains(res.Body.String(), "goroutine profile")
+
+	res = do("GET", "/admin/runtime", "Bearer "+token)
+	r.Equal(200, res.Code)
+	var stats runtimeStats
+	r.NoError(json.NewDecoder(res.Body).Decode(&stats))
+	r.Greater(stats.Goroutines, 0)
+	r.Greater(stats.HeapAllocBytes, uint64(0))
+
+	r.Equal(405, do("GET", "/admin/dump?profile=goroutine", "Bearer "+token).Code)
+	r.Equal(400, do("POST", "/admin/dump?profile=cpu", "Bearer "+token).Code)
+	res = do("POST", "/admin/dump?profile=goroutine", "Bearer "+token)
+	r.Equal(200, res.Code)
+	r.True(strings.HasPrefix(res.Body.String(), "goroutine "))
+	res = do("POST", "/admin/dump?profile=heap", "Bearer "+token)
+	r.Equal(200, res.Code)
+	r.Contains(res.Body.String(), "heap profile")
+	r.Contains(res.Header().Get("Content-Disposition"), "heap-")
+}
@@ -2,8 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
@@ -17,9 +21,33 @@ type forwardingConfig struct {
 	respHeaderTimeout     time.Duration
 	forceAttemptHTTP2     bool
 	maxIdleConns          int
+	maxIdleConnsPerHost   int
 	idleConnTimeout       time.Duration
 	tlsHandshakeTimeout   time.Duration
 	expectContinueTimeout time.Duration
+	// the default body limits, for targets that don't set their own
+	defaultBodyLimits bodyLimits
+	// the custom pages for requests that can't be forwarded. nil
+	// means the built-in responses are always used
+	errorPages *errorPages
+	// the X-Forwarded-* and Forwarded headers policy. nil means
+	// no clients are trusted to set them
+	forwardedHeaders *forwardedHeaders
+	// the tracker of cold start durations. nil means
+	// they aren't tracked
+	coldStarts *coldStartTracker
+	// the scheduler that shares the forwarding workers fairly
+	// across hosts. nil means requests aren't scheduled
+	scheduler *fairScheduler
+	// the cap on the requests that wait for their backends at
+	// once. nil means there's no cap
+	pendingLimit *pendingLimiter
+	// the version of the PROXY protocol header that's sent to
+	// backends. Empty means none is
+	proxyProtocolUpstream string
+	// the CA bundles that the certificates of HTTPS backends are
+	// verified against. nil means there are none
+	upstreamCAs *upstreamCAs
 }
 
 func newForwardingConfigFromTimeouts(t *config.Timeouts) forwardingConfig {
@@ -28,6 +56,7 @@ func newForwardingConfigFromTimeouts(t *config.Timeouts) forwardingConfig {
 		respHeaderTimeout:     t.ResponseHeader,
 		forceAttemptHTTP2:     t.ForceHTTP2,
 		maxIdleConns:          t.MaxIdleConns,
+		maxIdleConnsPerHost:   t.MaxIdleConnsPerHost,
 		idleConnTimeout:       t.IdleConnTimeout,
 		tlsHandshakeTimeout:   t.TLSHandshakeTimeout,
 		expectContinueTimeout: t.ExpectContinueTimeout,
@@ -46,46 +75,256 @@ func newForwardingHandler(
 	dialCtxFunc kedanet.DialContextFunc,
 	waitFunc forwardWaitFunc,
 	fwdCfg forwardingConfig,
-) http.Handler {
-	roundTripper := &http.Transport{
-		Proxy:                 http.ProxyFromEnvironment,
-		DialContext:           dialCtxFunc,
-		ForceAttemptHTTP2:     fwdCfg.forceAttemptHTTP2,
-		MaxIdleConns:          fwdCfg.maxIdleConns,
-		IdleConnTimeout:       fwdCfg.idleConnTimeout,
-		TLSHandshakeTimeout:   fwdCfg.tlsHandshakeTimeout,
-		ExpectContinueTimeout: fwdCfg.expectContinueTimeout,
-		ResponseHeaderTimeout: fwdCfg.respHeaderTimeout,
-	}
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		host, err := getHost(r)
-		if err != nil {
-			w.WriteHeader(400)
-			w.Write([]byte("Host not found in request"))
+) *forwardingHandler {
+	return &forwardingHandler{
+		lggr:         lggr,
+		routingTable: routingTable,
+		waitFunc:     waitFunc,
+		dial:         dialCtxFunc,
+		transports:   newTransportPool(dialCtxFunc, fwdCfg),
+		budgets:      newRetryBudgets(),
+		mut:          new(sync.RWMutex),
+		fwdCfg:       fwdCfg,
+	}
+}
+
+// forwardingHandler is the http.Handler that newForwardingHandler
+// returns. Its forwardingConfig can be changed while it's serving
+// requests, with setConfig
+type forwardingHandler struct {
+	lggr         logr.Logger
+	routingTable *routing.Table
+	waitFunc     forwardWaitFunc
+	dial         kedanet.DialContextFunc
+	transports   *transportPool
+	budgets      *retryBudgets
+	mut          *sync.RWMutex
+	fwdCfg       forwardingConfig
+}
+
+// setConfig makes f use fwdCfg for the requests that it gets from
+// now on. Requests that are in flight finish with the old config
+func (f *forwardingHandler) setConfig(fwdCfg forwardingConfig) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	f.fwdCfg = fwdCfg
+	f.transports.setConfig(fwdCfg)
+}
+
+func (f *forwardingHandler) config() forwardingConfig {
+	f.mut.RLock()
+	defer f.mut.RUnlock()
+	return f.fwdCfg
+}
+
+func (f *forwardingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	arrival := time.Now()
+	fwdCfg := f.config()
+	host, err := getHost(r)
+	if err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte("Host not found in request"))
+		return
+	}
+	routingTarget, err := lookupTarget(r.Context(), f.routingTable, host)
+	if err != nil {
+		markDropped(r.Context(), dropReasonNoRoute)
+		if !f.routingTable.HasSynced() {
+			// the host may well be in the routing table once it's
+			// loaded, so the client should try again rather than
+			// take the 404 for granted
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(503)
+			w.Write([]byte("The routing table hasn't been loaded yet"))
 			return
 		}
-		routingTarget, err := routingTable.Lookup(host)
-		if err != nil {
-			w.WriteHeader(404)
-			w.Write([]byte(fmt.Sprintf("Host %s not found", r.Host)))
+		fwdCfg.errorPages.write(
+			w,
+			errorClassNoRoute,
+			nil,
+			404,
+			fmt.Sprintf("Host %s not found", r.Host),
+		)
+		return
+	}
+	routingTarget = routedTarget(r.Context(), routingTarget)
+	// CONNECT requests are turned away before they wake the
+	// backend up, unless the target allows the tunnel they ask for
+	tunnelPort := 0
+	if r.Method == http.MethodConnect {
+		if routingTarget.Tunnel == nil {
+			w.WriteHeader(405)
+			w.Write([]byte(fmt.Sprintf("Host %s doesn't allow tunnels", host)))
+			return
+		}
+		tunnelPort, err = connectPort(r)
+		if err != nil || !routingTarget.Tunnel.Allows(tunnelPort, routingTarget.Port) {
+			w.WriteHeader(403)
+			w.Write([]byte(fmt.Sprintf("Host %s doesn't allow tunnels to %s", host, r.Host)))
 			return
 		}
+	}
+	w = fwdCfg.coldStarts.track(r.Context(), w, host, routingTarget, arrival)
 
-		ctx, done := context.WithTimeout(r.Context(), fwdCfg.waitTimeout)
+	logEntry := accessLogEntryFromContext(r.Context())
+	// targets outside the cluster, or behind ExternalName services,
+	// have no workload to wait on, and warm ones never need to
+	if routingTarget.HasWorkload() && !routingTarget.Warm() {
+		waitTimeout := fwdCfg.waitTimeout
+		// browsers that would wait longer than the waiting room's
+		// threshold get its page instead
+		room := waitingRoomFor(r, routingTarget)
+		if room != nil && room.threshold < waitTimeout {
+			waitTimeout = room.threshold
+		} else {
+			room = nil
+		}
+		if !fwdCfg.pendingLimit.tryAcquire(host) {
+			markDropped(r.Context(), dropReasonOverloaded)
+			fwdCfg.pendingLimit.reject(w, host, fwdCfg.coldStarts)
+			return
+		}
+		ctx, done := context.WithTimeout(r.Context(), waitTimeout)
 		defer done()
-		if err := waitFunc(ctx, routingTarget.Deployment); err != nil {
-			lggr.Error(err, "wait function failed, not forwarding request")
-			w.WriteHeader(502)
-			w.Write([]byte(fmt.Sprintf("error on backend (%s)", err)))
+		waitStart := time.Now()
+		donePending := startPending(r.Context())
+		err = f.waitFunc(ctx, routingTarget)
+		donePending()
+		fwdCfg.pendingLimit.release(host)
+		if logEntry != nil {
+			logEntry.ColdStartWaitMS = durationMS(time.Since(waitStart))
+		}
+		// a browser that gets the waiting room's page gave up
+		// before the wait timeout, so the cold start goes on
+		if err == nil || room == nil {
+			fwdCfg.coldStarts.waited(host, routingTarget, err)
+		}
+		if err != nil {
+			markDropped(r.Context(), dropReasonColdStartTimeout)
+		}
+		if err != nil && room != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			room.write(w, fwdCfg.errorPages, &routingTarget)
 			return
 		}
-		targetSvcURL, err := routingTarget.ServiceURL()
 		if err != nil {
-			lggr.Error(err, "forwarding failed")
-			w.WriteHeader(500)
-			w.Write([]byte("error getting backend service URL"))
+			f.lggr.Error(
+				err,
+				"wait function failed, not forwarding request",
+				"requestID",
+				requestIDFromContext(r.Context()),
+			)
+			fwdCfg.errorPages.write(
+				w,
+				errorClassColdStartTimeout,
+				&routingTarget,
+				502,
+				fmt.Sprintf("error on backend (%s)", err),
+			)
 			return
 		}
-		forwardRequest(w, r, roundTripper, targetSvcURL)
-	})
+	}
+	// requests wait for a worker once their backend is ready, so
+	// that hosts that wake up at the same time take turns
+	release, err := fwdCfg.scheduler.acquire(r.Context(), queueKey(r.Context(), host))
+	if err != nil {
+		// the client is gone, so nobody sees the response
+		markDropped(r.Context(), dropReasonClientCanceled)
+		w.WriteHeader(503)
+		w.Write([]byte("request canceled while waiting to be forwarded"))
+		return
+	}
+	defer release()
+	// the worker is only held until the request is under way, which
+	// is once the tunnel is established or the response's header is
+	// written. Tunnels and streamed responses can stay open for hours,
+	// and would keep it from every other request until then
+	if r.Method == http.MethodConnect {
+		f.tunnel(w, r, routingTarget, tunnelPort, release)
+		return
+	}
+	if fwdCfg.scheduler != nil {
+		w = &firstByteWriter{ResponseWriter: w, onFirstByte: release}
+	}
+	targetSvcURL, err := routingTarget.ServiceURL()
+	if err != nil {
+		f.lggr.Error(
+			err,
+			"forwarding failed",
+			"requestID",
+			requestIDFromContext(r.Context()),
+		)
+		w.WriteHeader(500)
+		w.Write([]byte("error getting backend service URL"))
+		return
+	}
+	roundTripper := f.transports.forTarget(routingTarget)
+	var transport http.RoundTripper = roundTripper
+	if retryPolicy := routingTarget.RetryPolicy; retryPolicy != nil {
+		transport = newRetryRoundTripper(
+			roundTripper,
+			*retryPolicy,
+			f.budgets.forHost(host, retryPolicy.BudgetPercent),
+		)
+	}
+	limits := fwdCfg.defaultBodyLimits
+	if routingTarget.MaxRequestBodyBytes > 0 {
+		limits.maxRequestBytes = routingTarget.MaxRequestBodyBytes
+	}
+	if routingTarget.MaxResponseBodyBytes > 0 {
+		limits.maxResponseBytes = routingTarget.MaxResponseBodyBytes
+	}
+	upstreamErrPage, _ := fwdCfg.errorPages.lookup(
+		errorClassUpstream,
+		&routingTarget,
+		502,
+	)
+	timeoutPage, _ := fwdCfg.errorPages.lookup(
+		errorClassUpstreamTimeout,
+		&routingTarget,
+		504,
+	)
+	// the response deadline starts once the request is forwarded, so
+	// that waiting for a cold start doesn't use up the budget
+	if timeouts := routingTarget.Timeouts; timeouts != nil && timeouts.ResponseMS > 0 {
+		ctx, done := context.WithTimeout(
+			r.Context(),
+			time.Duration(timeouts.ResponseMS)*time.Millisecond,
+		)
+		defer done()
+		r = r.WithContext(ctx)
+	}
+	if fwdCfg.proxyProtocolUpstream != "" {
+		r = r.WithContext(withClientAddrs(r))
+	}
+	upstreamStart := time.Now()
+	forwardRequest(
+		w,
+		r,
+		transport,
+		targetSvcURL,
+		limits,
+		upstreamErrPage,
+		timeoutPage,
+		fwdCfg.forwardedHeaders,
+		routingTarget.RequestHeaders,
+		routingTarget.ResponseHeaders,
+	)
+	if logEntry != nil {
+		logEntry.UpstreamLatencyMS = durationMS(time.Since(upstreamStart))
+	}
+}
+
+// withClientAddrs returns a copy of r's context that holds r's client
+// and local addresses, for the PROXY protocol header that's sent to
+// the backend
+func withClientAddrs(r *http.Request) context.Context {
+	var src net.Addr
+	if host, port, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ip := net.ParseIP(host)
+		if p, err := strconv.Atoi(port); err == nil && ip != nil {
+			src = &net.TCPAddr{IP: ip, Port: p}
+		}
+	}
+	dst, _ := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return kedanet.WithProxyAddrs(r.Context(), src, dst)
 }
@@ -272,6 +272,8 @@ func newHarness(
 ) (*harness, error) {
 	lggr := logr.Discard()
 	routingTable := routing.NewTable()
+	// hosts that aren't in a loaded table get a 404
+	routingTable.Replace(routing.NewTable())
 	dialContextFunc := kedanet.DialContextWithRetry(
 		&net.Dialer{
 			Timeout: 2 * time.Second,
@@ -283,7 +285,7 @@ func newHarness(
 	)
 
 	deplCache := k8s.NewFakeDeploymentCache()
-	waitFunc := newDeployReplicasForwardWaitFunc(deplCache)
+	waitFunc := newDeployReplicasForwardWaitFunc(lggr, deplCache)
 
 	proxyHdl := newForwardingHandler(
 		lggr,
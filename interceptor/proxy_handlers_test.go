@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"strconv"
 	"strings"
 	"testing"
@@ -41,7 +43,7 @@ func TestImmediatelySuccessfulProxy(t *testing.T) {
 
 	timeouts := defaultTimeouts()
 	dialCtxFunc := retryDialContextFunc(timeouts, timeouts.DefaultBackoff())
-	waitFunc := func(context.Context, string) error {
+	waitFunc := func(context.Context, routing.Target) error {
 		return nil
 	}
 	hdl := newForwardingHandler(
@@ -65,6 +67,132 @@ func TestImmediatelySuccessfulProxy(t *testing.T) {
 	r.Equal("test response", res.Body.String())
 }
 
+// the proxy should forward requests to targets that never scale to
+// zero right away, without waiting on their workload
+func TestWarmTargetSkipsWait(t *testing.T) {
+	const host = "TestWarmTargetSkipsWait.testing"
+	r := require.New(t)
+
+	originHdl := kedanet.NewTestHTTPHandlerWrapper(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(200)
+			w.Write([]byte("test response"))
+		}),
+	)
+	srv, originURL, err := kedanet.StartTestServer(originHdl)
+	r.NoError(err)
+	defer srv.Close()
+	routingTable := routing.NewTable()
+	portInt, err := strconv.Atoi(originURL.Port())
+	r.NoError(err)
+	routingTable.AddTarget(host, routing.Target{
+		Service:     strings.Split(originURL.Host, ":")[0],
+		Port:        portInt,
+		Deployment:  "testdepl",
+		MinReplicas: 1,
+	})
+
+	timeouts := defaultTimeouts()
+	waitFunc := func(context.Context, routing.Target) error {
+		return fmt.Errorf("wait function was called")
+	}
+	hdl := newForwardingHandler(
+		logr.Discard(),
+		routingTable,
+		retryDialContextFunc(timeouts, timeouts.DefaultBackoff()),
+		waitFunc,
+		forwardingConfig{
+			waitTimeout:       timeouts.DeploymentReplicas,
+			respHeaderTimeout: timeouts.ResponseHeader,
+		},
+	)
+	res, req, err := reqAndRes("/testfwd")
+	r.NoError(err)
+	req.Host = host
+
+	hdl.ServeHTTP(res, req)
+
+	r.Equal(200, res.Code, "expected response code 200")
+	r.Equal("test response", res.Body.String())
+}
+
+// the proxy should forward requests for targets with a URL to that
+// URL, under its path, without waiting on a workload
+func TestProxyToExternalURL(t *testing.T) {
+	const host = "TestProxyToExternalURL.testing"
+	r := require.New(t)
+
+	originHdl := kedanet.NewTestHTTPHandlerWrapper(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(200)
+			w.Write([]byte(r.URL.Path))
+		}),
+	)
+	srv, originURL, err := kedanet.StartTestServer(originHdl)
+	r.NoError(err)
+	defer srv.Close()
+	routingTable := routing.NewTable()
+	routingTable.AddTarget(host, routing.Target{
+		URL: originURL.String() + "/legacy/",
+	})
+
+	timeouts := defaultTimeouts()
+	dialCtxFunc := retryDialContextFunc(timeouts, timeouts.DefaultBackoff())
+	waitFunc := func(context.Context, routing.Target) error {
+		return fmt.Errorf("there's no workload to wait on")
+	}
+	hdl := newForwardingHandler(
+		logr.Discard(),
+		routingTable,
+		dialCtxFunc,
+		waitFunc,
+		forwardingConfig{
+			waitTimeout:       timeouts.DeploymentReplicas,
+			respHeaderTimeout: timeouts.ResponseHeader,
+		},
+	)
+	res, req, err := reqAndRes("/testfwd")
+	req.Host = host
+	r.NoError(err)
+
+	hdl.ServeHTTP(res, req)
+
+	r.Equal(200, res.Code, "expected response code 200")
+	r.Equal("/legacy/testfwd", res.Body.String())
+}
+
+// the proxy should ask clients to retry requests to unknown hosts
+// until the routing table is loaded, and 404 them after that
+func TestUnknownHostBeforeRoutingTableLoad(t *testing.T) {
+	r := require.New(t)
+	routingTable := routing.NewTable()
+	timeouts := defaultTimeouts()
+	hdl := newForwardingHandler(
+		logr.Discard(),
+		routingTable,
+		retryDialContextFunc(timeouts, timeouts.DefaultBackoff()),
+		func(context.Context, routing.Target) error { return nil },
+		forwardingConfig{
+			waitTimeout:       timeouts.DeploymentReplicas,
+			respHeaderTimeout: timeouts.ResponseHeader,
+		},
+	)
+
+	res, req, err := reqAndRes("/testfwd")
+	r.NoError(err)
+	req.Host = "TestUnknownHostBeforeRoutingTableLoad.testing"
+	hdl.ServeHTTP(res, req)
+	r.Equal(503, res.Code)
+	r.Equal("1", res.Header().Get("Retry-After"))
+
+	routingTable.Replace(routing.NewTable())
+	res, req, err = reqAndRes("/testfwd")
+	r.NoError(err)
+	req.Host = "TestUnknownHostBeforeRoutingTableLoad.testing"
+	hdl.ServeHTTP(res, req)
+	r.Equal(404, res.Code)
+}
+
 // the proxy should wait for a timeout and fail if there is no
 // origin to which to connect
 func TestWaitFailedConnection(t *testing.T) {
@@ -78,7 +206,7 @@ func TestWaitFailedConnection(t *testing.T) {
 		timeouts,
 		backoff,
 	)
-	waitFunc := func(context.Context, string) error {
+	waitFunc := func(context.Context, routing.Target) error {
 		return nil
 	}
 	routingTable := routing.NewTable()
@@ -252,7 +380,7 @@ func TestWaitHeaderTimeout(t *testing.T) {
 
 	timeouts := defaultTimeouts()
 	dialCtxFunc := retryDialContextFunc(timeouts, timeouts.DefaultBackoff())
-	waitFunc := func(context.Context, string) error {
+	waitFunc := func(context.Context, routing.Target) error {
 		return nil
 	}
 	routingTable := routing.NewTable()
@@ -316,13 +444,13 @@ func waitForSignal(sig <-chan struct{}, waitDur time.Duration) error {
 // is called, or the context that is passed to it is done (e.g. cancelled, timed out,
 // etc...). in the former case, the returned func itself returns nil. in the latter,
 // it returns ctx.Err()
-func notifyingFunc() (func(context.Context, string) error, <-chan struct{}, func()) {
+func notifyingFunc() (func(context.Context, routing.Target) error, <-chan struct{}, func()) {
 	calledCh := make(chan struct{})
 	finishCh := make(chan struct{})
 	finishFunc := func() {
 		close(finishCh)
 	}
-	return func(ctx context.Context, _ string) error {
+	return func(ctx context.Context, _ routing.Target) error {
 		close(calledCh)
 		select {
 		case <-finishCh:
@@ -332,3 +460,77 @@ func notifyingFunc() (func(context.Context, string) error, <-chan struct{}, func
 		}
 	}, calledCh, finishFunc
 }
+
+// targets with timeouts get a 504 when their backend is too slow
+func TestTargetTimeouts(t *testing.T) {
+	const host = "TestTargetTimeouts.testing"
+	r := require.New(t)
+
+	originHdl := kedanet.NewTestHTTPHandlerWrapper(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-time.After(200 * time.Millisecond):
+			case <-r.Context().Done():
+				return
+			}
+			w.WriteHeader(200)
+			w.Write([]byte("test response"))
+		}),
+	)
+	srv, originURL, err := kedanet.StartTestServer(originHdl)
+	r.NoError(err)
+	defer srv.Close()
+	routingTable := routing.NewTable()
+	portInt, err := strconv.Atoi(originURL.Port())
+	r.NoError(err)
+	target := routing.Target{
+		Service:    strings.Split(originURL.Host, ":")[0],
+		Port:       portInt,
+		Deployment: "testdepl",
+	}
+
+	timeouts := defaultTimeouts()
+	dialCtxFunc := retryDialContextFunc(timeouts, timeouts.DefaultBackoff())
+	waitFunc := func(context.Context, routing.Target) error {
+		return nil
+	}
+	hdl := newForwardingHandler(
+		logr.Discard(),
+		routingTable,
+		dialCtxFunc,
+		waitFunc,
+		forwardingConfig{
+			waitTimeout:       timeouts.DeploymentReplicas,
+			respHeaderTimeout: timeouts.ResponseHeader,
+		},
+	)
+	serve := func() *httptest.ResponseRecorder {
+		res, req, err := reqAndRes("/testfwd")
+		r.NoError(err)
+		req.Host = host
+		hdl.ServeHTTP(res, req)
+		return res
+	}
+
+	// without timeouts, the request waits for the slow backend
+	r.NoError(routingTable.AddTarget(host, target))
+	res := serve()
+	r.Equal(200, res.Code)
+	r.Equal("test response", res.Body.String())
+
+	for _, policy := range []*routing.TimeoutPolicy{
+		{ResponseHeaderMS: 20},
+		{ResponseMS: 20},
+	} {
+		target.Timeouts = policy
+		r.NoError(routingTable.RemoveTarget(host))
+		r.NoError(routingTable.AddTarget(host, target))
+		start := time.Now()
+		res = serve()
+		r.Less(time.Since(start), 200*time.Millisecond)
+		r.Equal(504, res.Code, "response code for %+v", *policy)
+		body := upstreamTimeoutBody{}
+		r.NoError(json.Unmarshal(res.Body.Bytes(), &body))
+		r.Equal("upstream_timeout", body.Error)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/interceptor/config"
+)
+
+// rateLimitPruneInterval is how often idle token buckets are removed
+// from a rateLimiter, so that per-client buckets don't pile up
+const rateLimitPruneInterval = time.Minute
+
+// tokenBucket is a token bucket that holds up to burst tokens and
+// gains rate tokens every second. Each request takes one token
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimitStats are the per-host counters that a rateLimiter exposes
+type rateLimitStats struct {
+	Allowed  int64 `json:"allowed"`
+	Rejected int64 `json:"rejected"`
+}
+
+// rateLimiter holds a token bucket for each host, or for each host
+// and client IP pair if the config says so
+type rateLimiter struct {
+	mut       *sync.Mutex
+	cfg       config.RateLimit
+	buckets   map[string]*tokenBucket
+	stats     map[string]*rateLimitStats
+	lastPrune time.Time
+}
+
+func newRateLimiter(cfg config.RateLimit) *rateLimiter {
+	return &rateLimiter{
+		mut:     new(sync.Mutex),
+		cfg:     cfg,
+		buckets: map[string]*tokenBucket{},
+		stats:   map[string]*rateLimitStats{},
+	}
+}
+
+// setConfig makes l use cfg from now on. Buckets that hold more tokens
+// than cfg's burst lose the extra ones. If cfg changes whether buckets
+// are per client IP, all the buckets start over
+func (l *rateLimiter) setConfig(cfg config.RateLimit) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	if cfg.PerClientIP != l.cfg.PerClientIP {
+		l.buckets = map[string]*tokenBucket{}
+	}
+	burst := float64(cfg.Burst)
+	for _, bucket := range l.buckets {
+		bucket.tokens = math.Min(burst, bucket.tokens)
+	}
+	l.cfg = cfg
+}
+
+// limit returns the number of requests per second that l allows,
+// formatted for the X-RateLimit-Limit header
+func (l *rateLimiter) limit() string {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	return strconv.FormatFloat(l.cfg.RequestsPerSecond, 'f', -1, 64)
+}
+
+// allow takes a token for a request from clientIP to host at time
+// now. It returns whether the request may go through, and how many
+// tokens are left. If the request may not go through, it also returns
+// how long until a token is available
+func (l *rateLimiter) allow(
+	now time.Time,
+	host,
+	clientIP string,
+) (bool, int, time.Duration) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	if now.Sub(l.lastPrune) > rateLimitPruneInterval {
+		l.prune(now)
+	}
+
+	key := host
+	if l.cfg.PerClientIP {
+		key = fmt.Sprintf("%s/%s", host, clientIP)
+	}
+	burst := float64(l.cfg.Burst)
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: burst, last: now}
+		l.buckets[key] = bucket
+	}
+	elapsed := now.Sub(bucket.last).Seconds()
+	if elapsed > 0 {
+		bucket.tokens = math.Min(burst, bucket.tokens+elapsed*l.cfg.RequestsPerSecond)
+		bucket.last = now
+	}
+
+	stats, ok := l.stats[host]
+	if !ok {
+		stats = &rateLimitStats{}
+		l.stats[host] = stats
+	}
+	if bucket.tokens < 1 {
+		stats.Rejected++
+		wait := time.Duration(
+			(1 - bucket.tokens) / l.cfg.RequestsPerSecond * float64(time.Second),
+		)
+		return false, 0, wait
+	}
+	bucket.tokens--
+	stats.Allowed++
+	return true, int(bucket.tokens), 0
+}
+
+// prune removes the buckets that have been idle long enough to be
+// full again, since a new bucket would behave exactly the same.
+// Callers must hold l.mut
+func (l *rateLimiter) prune(now time.Time) {
+	fillDur := time.Duration(
+		float64(l.cfg.Burst) / l.cfg.RequestsPerSecond * float64(time.Second),
+	)
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.last) >= fillDur {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastPrune = now
+}
+
+// MarshalJSON returns the number of allowed and rejected requests
+// for each host
+func (l *rateLimiter) MarshalJSON() ([]byte, error) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	return json.Marshal(l.stats)
+}
+
+// rateLimitMiddleware rejects requests with a 429 when the rate
+// limiter for the request's host has no tokens left. All responses
+// get X-RateLimit-Limit and X-RateLimit-Remaining headers, and 429
+// responses also get a Retry-After header
+func rateLimitMiddleware(
+	lggr logr.Logger,
+	limiter *rateLimiter,
+	next http.Handler,
+) http.Handler {
+	lggr = lggr.WithName("rateLimitMiddleware")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, err := getHost(r)
+		if err != nil {
+			// let the next handler deal with requests
+			// that have no host
+			next.ServeHTTP(w, r)
+			return
+		}
+		allowed, remaining, retryAfter := limiter.allow(time.Now(), host, remoteIP(r))
+		w.Header().Set("X-RateLimit-Limit", limiter.limit())
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		if !allowed {
+			lggr.V(1).Info(
+				"rate limit exceeded, rejecting request",
+				"host",
+				host,
+			)
+			w.Header().Set(
+				"Retry-After",
+				strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))),
+			)
+			markDropped(r.Context(), dropReasonRateLimited)
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte("rate limit exceeded, try again later"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
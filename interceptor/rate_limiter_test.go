@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/interceptor/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	const host = "TestRateLimiterAllow.testing"
+	r := require.New(t)
+	limiter := newRateLimiter(config.RateLimit{
+		RequestsPerSecond: 2,
+		Burst:             2,
+	})
+	now := time.Now()
+
+	allowed, remaining, _ := limiter.allow(now, host, "1.2.3.4")
+	r.True(allowed)
+	r.Equal(1, remaining)
+	allowed, remaining, _ = limiter.allow(now, host, "1.2.3.4")
+	r.True(allowed)
+	r.Equal(0, remaining)
+	// the burst is used up, so the next token comes in 1/2 second
+	allowed, _, retryAfter := limiter.allow(now, host, "1.2.3.4")
+	r.False(allowed)
+	r.Equal(500*time.Millisecond, retryAfter)
+	// all clients share the host's limit
+	allowed, _, _ = limiter.allow(now, host, "2.3.4.5")
+	r.False(allowed)
+	// but other hosts have their own
+	allowed, _, _ = limiter.allow(now, "other.testing", "1.2.3.4")
+	r.True(allowed)
+
+	allowed, _, _ = limiter.allow(now.Add(500*time.Millisecond), host, "1.2.3.4")
+	r.True(allowed)
+
+	b, err := json.Marshal(limiter)
+	r.NoError(err)
+	r.JSONEq(
+		`{"TestRateLimiterAllow.testing":{"allowed":3,"rejected":2},"other.testing":{"allowed":1,"rejected":0}}`,
+		string(b),
+	)
+}
+
+func TestRateLimiterPerClientIP(t *testing.T) {
+	const host = "TestRateLimiterPerClientIP.testing"
+	r := require.New(t)
+	limiter := newRateLimiter(config.RateLimit{
+		RequestsPerSecond: 1,
+		Burst:             1,
+		PerClientIP:       true,
+	})
+	now := time.Now()
+	allowed, _, _ := limiter.allow(now, host, "1.2.3.4")
+	r.True(allowed)
+	allowed, _, _ = limiter.allow(now, host, "1.2.3.4")
+	r.False(allowed)
+	allowed, _, _ = limiter.allow(now, host, "2.3.4.5")
+	r.True(allowed)
+
+	// idle buckets are pruned once they'd be full again
+	r.Equal(2, len(limiter.buckets))
+	allowed, _, _ = limiter.allow(now.Add(2*rateLimitPruneInterval), host, "1.2.3.4")
+	r.True(allowed)
+	r.Equal(1, len(limiter.buckets))
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	const host = "TestRateLimitMiddleware.testing"
+	r := require.New(t)
+	limiter := newRateLimiter(config.RateLimit{
+		RequestsPerSecond: 0.5,
+		Burst:             1,
+	})
+	hdl := rateLimitMiddleware(
+		logr.Discard(),
+		limiter,
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(200)
+		}),
+	)
+	do := func() *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/", nil)
+		r.NoError(err)
+		req.Host = host
+		res := httptest.NewRecorder()
+		hdl.ServeHTTP(res, req)
+		return res
+	}
+
+	res := do()
+	r.Equal(200, res.Code)
+	r.Equal("0.5", res.Header().Get("X-RateLimit-Limit"))
+	r.Equal("0", res.Header().Get("X-RateLimit-Remaining"))
+
+	res = do()
+	r.Equal(http.StatusTooManyRequests, res.Code)
+	r.Equal("0", res.Header().Get("X-RateLimit-Remaining"))
+	r.Equal("2", res.Header().Get("Retry-After"))
+}
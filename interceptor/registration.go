@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/interceptor/config"
+	"github.com/kedacore/http-add-on/pkg/queue"
+)
+
+// registrationTTLIntervals is how many registration intervals the
+// scaler keeps a registration for, so that a registration or two that
+// fail don't make the scaler forget the interceptor
+const registrationTTLIntervals = 3
+
+// newScalerRegistration returns the registration that tells the scaler
+// to scrape the admin server on adminPort of the interceptor in ns that
+// cfg describes
+func newScalerRegistration(
+	cfg config.Registration,
+	ns string,
+	adminPort int,
+) queue.Registration {
+	return queue.Registration{
+		Namespace:  ns,
+		Pod:        cfg.PodName,
+		Address:    net.JoinHostPort(cfg.PodIP, strconv.Itoa(adminPort)),
+		TTLSeconds: int((registrationTTLIntervals * cfg.Interval).Seconds()),
+	}
+}
+
+// runScalerRegistration registers reg with the scaler that cfg points
+// to right away, then again every cfg.Interval, until ctx is done. It
+// deregisters reg before it returns, so that the scaler stops scraping
+// the interceptor as soon as it shuts down
+func runScalerRegistration(
+	ctx context.Context,
+	lggr logr.Logger,
+	cl *http.Client,
+	cfg config.Registration,
+	reg queue.Registration,
+) error {
+	lggr = lggr.WithName("runScalerRegistration")
+	scalerURL, err := url.Parse(cfg.ScalerURL)
+	if err != nil {
+		return err
+	}
+	register := func() {
+		regCtx, done := context.WithTimeout(ctx, cfg.Interval)
+		defer done()
+		if err := queue.Register(regCtx, cl, *scalerURL, cfg.Token, reg); err != nil {
+			lggr.Error(err, "registering with the scaler", "scalerURL", cfg.ScalerURL)
+		}
+	}
+	register()
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			register()
+		case <-ctx.Done():
+			// ctx is done, so the deregistration needs its own
+			deregCtx, done := context.WithTimeout(context.Background(), 5*time.Second)
+			defer done()
+			if err := queue.Deregister(deregCtx, cl, *scalerURL, cfg.Token, reg); err != nil {
+				lggr.Error(err, "deregistering from the scaler", "scalerURL", cfg.ScalerURL)
+			}
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/interceptor/config"
+	"github.com/kedacore/http-add-on/pkg/queue"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunScalerRegistration(t *testing.T) {
+	r := require.New(t)
+	type call struct {
+		method string
+		reg    queue.Registration
+	}
+	calls := make(chan call, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.Equal(queue.RegistrationPath, req.URL.Path)
+		r.Equal("Bearer secret", req.Header.Get("Authorization"))
+		reg := queue.Registration{}
+		r.NoError(json.NewDecoder(req.Body).Decode(&reg))
+		calls <- call{method: req.Method, reg: reg}
+		w.WriteHeader(204)
+	}))
+	defer srv.Close()
+	cfg := config.Registration{
+		ScalerURL: srv.URL,
+		Token:     "secret",
+		PodName:   "interceptor-1",
+		PodIP:     "10.0.0.1",
+		Interval:  time.Hour,
+	}
+	r.NoError(cfg.Validate())
+	reg := newScalerRegistration(cfg, "team-a", 9090)
+	r.Equal("10.0.0.1:9090", reg.Address)
+	r.Equal(3*60*60, reg.TTLSeconds)
+
+	ctx, done := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		errs <- runScalerRegistration(ctx, logr.Discard(), srv.Client(), cfg, reg)
+	}()
+	// the interceptor registers right away
+	registered := <-calls
+	r.Equal(http.MethodPost, registered.method)
+	r.Equal(reg, registered.reg)
+
+	// and deregisters when it shuts down
+	done()
+	r.ErrorIs(<-errs, context.Canceled)
+	deregistered := <-calls
+	r.Equal(http.MethodDelete, deregistered.method)
+	r.Equal("team-a/interceptor-1", deregistered.reg.Key())
+}
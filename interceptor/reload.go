@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/interceptor/config"
+	pkgconfig "github.com/kedacore/http-add-on/pkg/config"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// reloader applies the parts of the interceptor's configuration that
+// can change while it's running, every time the configuration is
+// reloaded. Those are the timeouts, the body limits, the rate limit
+// and circuit breaker settings, and the log level. The components that
+// use them are changed in place, so no connections are dropped.
+//
+// Everything else, including turning the rate limiter or the circuit
+// breaker on or off, needs a restart. TLS certificates don't need a
+// reload, since they're re-read whenever their files change
+type reloader struct {
+	lggr     logr.Logger
+	loader   *pkgconfig.Loader
+	logLevel zap.AtomicLevel
+	mut      *sync.Mutex
+	fwd      *forwardingHandler
+	limiter  *rateLimiter
+	breakers *circuitBreakers
+}
+
+func newReloader(
+	lggr logr.Logger,
+	loader *pkgconfig.Loader,
+	logLevel zap.AtomicLevel,
+	limiter *rateLimiter,
+) *reloader {
+	return &reloader{
+		lggr:     lggr.WithName("reloader"),
+		loader:   loader,
+		logLevel: logLevel,
+		mut:      new(sync.Mutex),
+		limiter:  limiter,
+	}
+}
+
+// setForwarding makes r reload fwd's timeouts and body limits. It
+// does nothing if r is nil
+func (r *reloader) setForwarding(fwd *forwardingHandler) {
+	if r == nil {
+		return
+	}
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.fwd = fwd
+}
+
+// setCircuitBreakers makes r reload the settings of breakers. It does
+// nothing if r is nil
+func (r *reloader) setCircuitBreakers(breakers *circuitBreakers) {
+	if r == nil {
+		return
+	}
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.breakers = breakers
+}
+
+// reload reloads the configuration and applies it. If the new
+// configuration is invalid, nothing changes
+func (r *reloader) reload() error {
+	timeoutCfg := new(config.Timeouts)
+	bodyLimitsCfg := new(config.BodyLimits)
+	rateLimitCfg := new(config.RateLimit)
+	circuitBreakerCfg := new(config.CircuitBreaker)
+	loggingCfg := new(config.Logging)
+	if err := r.loader.Reload(
+		timeoutCfg,
+		bodyLimitsCfg,
+		rateLimitCfg,
+		circuitBreakerCfg,
+		loggingCfg,
+	); err != nil {
+		return err
+	}
+	level, err := loggingCfg.ZapLevel()
+	if err != nil {
+		return err
+	}
+	r.logLevel.SetLevel(level)
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	if r.fwd != nil {
+		oldCfg := r.fwd.config()
+		fwdCfg := newForwardingConfigFromTimeouts(timeoutCfg)
+		fwdCfg.defaultBodyLimits = bodyLimits{
+			maxRequestBytes:  bodyLimitsCfg.MaxRequestBytes,
+			maxResponseBytes: bodyLimitsCfg.MaxResponseBytes,
+		}
+		fwdCfg.errorPages = oldCfg.errorPages
+		fwdCfg.forwardedHeaders = oldCfg.forwardedHeaders
+		fwdCfg.coldStarts = oldCfg.coldStarts
+		fwdCfg.scheduler = oldCfg.scheduler
+		fwdCfg.pendingLimit = oldCfg.pendingLimit
+		fwdCfg.upstreamCAs = oldCfg.upstreamCAs
+		r.fwd.setConfig(fwdCfg)
+	}
+	if (r.limiter != nil) != rateLimitCfg.Enabled {
+		r.lggr.Info("turning the rate limiter on or off needs a restart")
+	} else if r.limiter != nil {
+		r.limiter.setConfig(*rateLimitCfg)
+	}
+	if (r.breakers != nil) != circuitBreakerCfg.Enabled {
+		r.lggr.Info("turning the circuit breaker on or off needs a restart")
+	} else if r.breakers != nil {
+		r.breakers.setConfig(*circuitBreakerCfg)
+	}
+	return nil
+}
+
+// run reloads the configuration every time a signal arrives on sigs,
+// and every time the config file's contents change, which it checks
+// every pollInterval. It returns when ctx is done
+func (r *reloader) run(
+	ctx context.Context,
+	sigs <-chan os.Signal,
+	pollInterval time.Duration,
+) error {
+	file := r.loader.ConfigFile()
+	var pollCh <-chan time.Time
+	var lastContents []byte
+	if file != "" && pollInterval > 0 {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		pollCh = ticker.C
+		// the file was read when the interceptor started, so
+		// an error here will show up on the first poll
+		lastContents, _ = ioutil.ReadFile(file)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "context is done")
+		case sig := <-sigs:
+			r.reloadAndLog("signal", sig.String())
+		case <-pollCh:
+			contents, err := ioutil.ReadFile(file)
+			if err != nil {
+				r.lggr.Error(err, "reading config file", "file", file)
+				continue
+			}
+			if bytes.Equal(contents, lastContents) {
+				continue
+			}
+			lastContents = contents
+			r.reloadAndLog("config file changed", file)
+		}
+	}
+}
+
+func (r *reloader) reloadAndLog(reason, detail string) {
+	if err := r.reload(); err != nil {
+		r.lggr.Error(
+			err,
+			"reloading configuration, keeping the current one",
+			"reason",
+			reason,
+			"detail",
+			detail,
+		)
+		return
+	}
+	r.lggr.Info("reloaded configuration", "reason", reason, "detail", detail)
+}
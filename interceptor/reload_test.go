@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/interceptor/config"
+	pkgconfig "github.com/kedacore/http-add-on/pkg/config"
+	kedanet "github.com/kedacore/http-add-on/pkg/net"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// newTestReloader returns a reloader with a forwarding handler, a rate
+// limiter and circuit breakers, loaded from a config file with
+// contents. The config file's path is returned too
+func newTestReloader(t *testing.T, contents string) (*reloader, string) {
+	t.Helper()
+	r := require.New(t)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	r.NoError(ioutil.WriteFile(path, []byte(contents), 0600))
+
+	timeoutCfg := new(config.Timeouts)
+	bodyLimitsCfg := new(config.BodyLimits)
+	rateLimitCfg := new(config.RateLimit)
+	circuitBreakerCfg := new(config.CircuitBreaker)
+	loggingCfg := new(config.Logging)
+	specs := []interface{}{
+		timeoutCfg,
+		bodyLimitsCfg,
+		rateLimitCfg,
+		circuitBreakerCfg,
+		loggingCfg,
+	}
+	// the loader puts the file's values in the environment, so
+	// they mustn't leak into other tests
+	t.Cleanup(func() {
+		for _, spec := range specs {
+			for _, key := range specEnvKeys(spec) {
+				os.Unsetenv(key)
+			}
+		}
+	})
+	loader := pkgconfig.NewLoader("test", specs...)
+	r.NoError(loader.Load([]string{"--config-file", path}))
+
+	reloads := newReloader(
+		logr.Discard(),
+		loader,
+		zap.NewAtomicLevelAt(zap.InfoLevel),
+		newRateLimiter(*rateLimitCfg),
+	)
+	reloads.setForwarding(newForwardingHandler(
+		logr.Discard(),
+		routing.NewTable(),
+		kedanet.DialContextWithRetry(
+			kedanet.NewNetDialer(time.Second, time.Second),
+			timeoutCfg.DefaultBackoff(),
+		),
+		func(context.Context, routing.Target) error { return nil },
+		newForwardingConfigFromTimeouts(timeoutCfg),
+	))
+	reloads.setCircuitBreakers(newCircuitBreakers(*circuitBreakerCfg))
+	return reloads, path
+}
+
+// specEnvKeys returns the environment variables of
+// spec's fields, from their envconfig tags
+func specEnvKeys(spec interface{}) []string {
+	ret := []string{}
+	v := reflect.Indirect(reflect.ValueOf(spec))
+	for i := 0; i < v.NumField(); i++ {
+		if key := v.Type().Field(i).Tag.Get("envconfig"); key != "" {
+			ret = append(ret, key)
+		}
+	}
+	return ret
+}
+
+func TestReload(t *testing.T) {
+	r := require.New(t)
+	reloads, path := newTestReloader(t, `
+KEDA_CONDITION_WAIT_TIMEOUT: 1s
+KEDA_HTTP_RATE_LIMIT_ENABLED: true
+KEDA_HTTP_RATE_LIMIT_REQUESTS_PER_SECOND: 10
+KEDA_HTTP_CIRCUIT_BREAKER_ENABLED: true
+`)
+	r.Equal(time.Second, reloads.fwd.config().waitTimeout)
+	r.Equal("10", reloads.limiter.limit())
+
+	r.NoError(ioutil.WriteFile(path, []byte(`
+KEDA_CONDITION_WAIT_TIMEOUT: 3s
+KEDA_RESPONSE_HEADER_TIMEOUT: 2s
+KEDA_HTTP_MAX_REQUEST_BODY_BYTES: 1024
+KEDA_HTTP_RATE_LIMIT_ENABLED: true
+KEDA_HTTP_RATE_LIMIT_REQUESTS_PER_SECOND: 20
+KEDA_HTTP_CIRCUIT_BREAKER_ENABLED: true
+KEDA_HTTP_CIRCUIT_BREAKER_OPEN_DURATION: 5s
+KEDA_HTTP_LOG_LEVEL: debug
+`), 0600))
+	breaker := reloads.breakers.forHost("host")
+	r.NoError(reloads.reload())
+
+	fwdCfg := reloads.fwd.config()
+	r.Equal(3*time.Second, fwdCfg.waitTimeout)
+	r.Equal(2*time.Second, fwdCfg.respHeaderTimeout)
+	r.Equal(int64(1024), fwdCfg.defaultBodyLimits.maxRequestBytes)
+	r.Equal("20", reloads.limiter.limit())
+	// existing circuit breakers get the new settings too
+	r.Equal(5*time.Second, breaker.cfg.OpenDuration)
+	r.Equal(zap.DebugLevel, reloads.logLevel.Level())
+
+	// backends get a new transport with the new settings
+	transport := reloads.fwd.transports.forTarget(routing.Target{
+		Service: "svc",
+		Port:    8080,
+	})
+	r.Equal(2*time.Second, transport.ResponseHeaderTimeout)
+
+	// an invalid configuration changes nothing
+	r.NoError(ioutil.WriteFile(path, []byte("KEDA_HTTP_LOG_LEVEL: loud\n"), 0600))
+	r.Error(reloads.reload())
+	r.Equal(zap.DebugLevel, reloads.logLevel.Level())
+	r.Equal(3*time.Second, reloads.fwd.config().waitTimeout)
+}
+
+func TestReloaderRun(t *testing.T) {
+	r := require.New(t)
+	reloads, path := newTestReloader(t, "KEDA_HTTP_LOG_LEVEL: info\n")
+	ctx, done := context.WithCancel(context.Background())
+	defer done()
+	sigs := make(chan os.Signal, 1)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- reloads.run(ctx, sigs, 10*time.Millisecond)
+	}()
+
+	// a signal reloads the configuration, even
+	// though the config file didn't change
+	reloads.logLevel.SetLevel(zap.WarnLevel)
+	sigs <- syscall.SIGHUP
+	r.Eventually(func() bool {
+		return reloads.logLevel.Level() == zap.InfoLevel
+	}, time.Second, 10*time.Millisecond)
+
+	// a change to the config file is picked up on its own
+	r.NoError(ioutil.WriteFile(path, []byte("KEDA_HTTP_LOG_LEVEL: warn\n"), 0600))
+	r.Eventually(func() bool {
+		return reloads.logLevel.Level() == zap.WarnLevel
+	}, time.Second, 10*time.Millisecond)
+
+	done()
+	r.Error(<-errCh)
+}
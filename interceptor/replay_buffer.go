@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/interceptor/config"
+	"github.com/kedacore/http-add-on/pkg/routing"
+)
+
+// replayBufferOccupancy is how much of the replay buffer
+// a single host is using
+type replayBufferOccupancy struct {
+	Requests  int   `json:"requests"`
+	BodyBytes int64 `json:"bodyBytes"`
+}
+
+// replayBuffer keeps track of the requests that are held while their
+// backend scales up from zero, and limits how many of them there can
+// be, and how large their bodies can be, for each host
+type replayBuffer struct {
+	mut   *sync.Mutex
+	cfg   config.ReplayBuffer
+	hosts map[string]*replayBufferOccupancy
+}
+
+func newReplayBuffer(cfg config.ReplayBuffer) *replayBuffer {
+	return &replayBuffer{
+		mut:   new(sync.Mutex),
+		cfg:   cfg,
+		hosts: map[string]*replayBufferOccupancy{},
+	}
+}
+
+// tryReserve reserves room for a request with a body of bodyBytes
+// for host. Returns false if the buffer for host is full. If it
+// returns true, the caller must call release when the request is done
+func (b *replayBuffer) tryReserve(host string, bodyBytes int64) bool {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	occ, ok := b.hosts[host]
+	if !ok {
+		occ = &replayBufferOccupancy{}
+	}
+	if occ.Requests >= b.cfg.MaxRequests ||
+		occ.BodyBytes+bodyBytes > b.cfg.MaxBodyBytes {
+		return false
+	}
+	occ.Requests++
+	occ.BodyBytes += bodyBytes
+	b.hosts[host] = occ
+	return true
+}
+
+// tryAddBytes reserves room for bodyBytes more body bytes for a request
+// to host that was already reserved. Returns false if they don't fit
+func (b *replayBuffer) tryAddBytes(host string, bodyBytes int64) bool {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	occ, ok := b.hosts[host]
+	if !ok || occ.BodyBytes+bodyBytes > b.cfg.MaxBodyBytes {
+		return false
+	}
+	occ.BodyBytes += bodyBytes
+	return true
+}
+
+// remainingBytes returns how many body bytes are still free for host
+func (b *replayBuffer) remainingBytes(host string) int64 {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	occ, ok := b.hosts[host]
+	if !ok {
+		return b.cfg.MaxBodyBytes
+	}
+	return b.cfg.MaxBodyBytes - occ.BodyBytes
+}
+
+// release frees the room for a request to host, with a body of
+// bodyBytes, that was reserved with tryReserve
+func (b *replayBuffer) release(host string, bodyBytes int64) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	occ, ok := b.hosts[host]
+	if !ok {
+		return
+	}
+	occ.Requests--
+	occ.BodyBytes -= bodyBytes
+	if occ.Requests <= 0 {
+		delete(b.hosts, host)
+	}
+}
+
+// MarshalJSON encodes the current occupancy of each host
+// that has requests in the buffer
+func (b *replayBuffer) MarshalJSON() ([]byte, error) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	ret := make(map[string]replayBufferOccupancy, len(b.hosts))
+	for host, occ := range b.hosts {
+		ret[host] = *occ
+	}
+	return json.Marshal(ret)
+}
+
+// replayBufferMiddleware holds requests to backends that have no ready
+// replicas in buffer, reading their bodies into memory, before calling
+// next. When the buffer for the request's host is full, it responds
+// immediately with a 503 and a Retry-After header. Requests to backends
+// that already have ready replicas go straight to next.
+func replayBufferMiddleware(
+	lggr logr.Logger,
+	routingTable *routing.Table,
+	replicas workloadReplicasFunc,
+	buffer *replayBuffer,
+	next http.Handler,
+) http.Handler {
+	lggr = lggr.WithName("replayBufferMiddleware")
+	reject := func(w http.ResponseWriter, host string) {
+		lggr.Info(
+			"replay buffer full, rejecting request",
+			"host",
+			host,
+		)
+		w.Header().Set(
+			"Retry-After",
+			strconv.Itoa(int(math.Ceil(buffer.cfg.RetryAfter.Seconds()))),
+		)
+		w.WriteHeader(503)
+		w.Write([]byte("backend is starting up, try again later"))
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// let the next handler deal with requests that have
+		// no host or an unknown one
+		host, err := getHost(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		target, err := lookupTarget(r.Context(), routingTable, host)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		readyReplicas, err := replicas(r.Context(), routedTarget(r.Context(), target))
+		if err != nil || readyReplicas > 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// requests with chunked bodies don't have a known
+		// length, so their bytes are reserved after reading
+		reserved := r.ContentLength
+		if reserved < 0 {
+			reserved = 0
+		}
+		if !buffer.tryReserve(host, reserved) {
+			reject(w, host)
+			return
+		}
+		defer func() {
+			buffer.release(host, reserved)
+		}()
+
+		if r.Body != nil && r.Body != http.NoBody {
+			var body []byte
+			if r.ContentLength >= 0 {
+				body, err = ioutil.ReadAll(io.LimitReader(r.Body, r.ContentLength))
+			} else {
+				limit := buffer.remainingBytes(host)
+				body, err = ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+				if err == nil {
+					if !buffer.tryAddBytes(host, int64(len(body))) {
+						reject(w, host)
+						return
+					}
+					reserved = int64(len(body))
+				}
+			}
+			if err != nil {
+				lggr.Error(err, "reading request body into the replay buffer", "host", host)
+				w.WriteHeader(400)
+				w.Write([]byte("error reading request body"))
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
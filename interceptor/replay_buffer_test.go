@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/interceptor/config"
+	"github.com/kedacore/http-add-on/pkg/k8s"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+func TestReplayBufferLimits(t *testing.T) {
+	const host = "TestReplayBufferLimits.testing"
+	r := require.New(t)
+	buffer := newReplayBuffer(config.ReplayBuffer{
+		MaxRequests:  2,
+		MaxBodyBytes: 10,
+	})
+
+	r.True(buffer.tryReserve(host, 6))
+	// the body doesn't fit
+	r.False(buffer.tryReserve(host, 5))
+	r.True(buffer.tryReserve(host, 4))
+	// the buffer has no more room for requests
+	r.False(buffer.tryReserve(host, 0))
+	// other hosts have their own room
+	r.True(buffer.tryReserve("other.testing", 10))
+
+	b, err := json.Marshal(buffer)
+	r.NoError(err)
+	r.JSONEq(
+		`{"TestReplayBufferLimits.testing":{"requests":2,"bodyBytes":10},"other.testing":{"requests":1,"bodyBytes":10}}`,
+		string(b),
+	)
+
+	buffer.release(host, 6)
+	r.Equal(int64(6), buffer.remainingBytes(host))
+	r.True(buffer.tryReserve(host, 0))
+	r.True(buffer.tryAddBytes(host, 6))
+	r.False(buffer.tryAddBytes(host, 1))
+}
+
+func TestReplayBufferMiddleware(t *testing.T) {
+	const (
+		host     = "TestReplayBufferMiddleware.testing"
+		deplName = "testdepl"
+	)
+	r := require.New(t)
+	routingTable := routing.NewTable()
+	routingTable.AddTarget(host, routing.NewTarget("testsvc", 8080, deplName, 100))
+	deployCache := k8s.NewFakeDeploymentCache()
+	deployCache.Set(deplName, appsv1.Deployment{})
+	buffer := newReplayBuffer(config.ReplayBuffer{
+		MaxRequests:  1,
+		MaxBodyBytes: 10,
+		RetryAfter:   3 * time.Second,
+	})
+
+	var hdl http.Handler
+	var innerRes *httptest.ResponseRecorder
+	hdl = replayBufferMiddleware(
+		logr.Discard(),
+		routingTable,
+		newWorkloadReplicasFunc(deployCache, nil, ""),
+		buffer,
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Method == "GET" {
+				w.WriteHeader(200)
+				return
+			}
+			body, err := ioutil.ReadAll(req.Body)
+			r.NoError(err)
+			r.Equal("body", string(body))
+			// the buffer is full while this request is being
+			// held, so a second one should be rejected
+			innerRes = httptest.NewRecorder()
+			secondReq := httptest.NewRequest("GET", "/testbuffer", nil)
+			secondReq.Host = host
+			hdl.ServeHTTP(innerRes, secondReq)
+			w.WriteHeader(200)
+		}),
+	)
+
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/testbuffer", strings.NewReader("body"))
+	req.Host = host
+	hdl.ServeHTTP(res, req)
+	r.Equal(200, res.Code)
+	r.Equal(503, innerRes.Code)
+	r.Equal("3", innerRes.Header().Get("Retry-After"))
+	r.Equal(int64(10), buffer.remainingBytes(host))
+
+	// bodies that don't fit are rejected
+	res = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/testbuffer", strings.NewReader("a body that is too long"))
+	req.Host = host
+	hdl.ServeHTTP(res, req)
+	r.Equal(503, res.Code)
+
+	// once the deployment has ready replicas, requests
+	// aren't buffered anymore
+	deployCache.Set(deplName, appsv1.Deployment{
+		Status: appsv1.DeploymentStatus{ReadyReplicas: 1},
+	})
+	res = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/testbuffer", strings.NewReader("body"))
+	req.Host = host
+	hdl.ServeHTTP(res, req)
+	r.Equal(200, res.Code)
+	r.Equal(200, innerRes.Code)
+}
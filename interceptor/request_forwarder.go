@@ -1,30 +1,145 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"mime"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strings"
+
+	"github.com/kedacore/http-add-on/pkg/routing"
 )
 
+// forwardRequest proxies r to fwdSvcURL and writes the response to w.
+// Request and response bodies are streamed, not buffered, and are cut
+// off if they're larger than limits allow.
+//
+// If the backend fails before it sends a response, upstreamErrPage is
+// written to w, or a built-in 502 response if upstreamErrPage is nil.
+// If it failed because it timed out, like when it didn't send its
+// response headers in time or r's context hit its deadline,
+// timeoutPage is written instead, or a built-in 504 response with a
+// JSON body if timeoutPage is nil.
+// fwdHeaders sets the X-Forwarded-* and Forwarded headers on the
+// proxied request, and then rewrite rewrites its headers, if it's not
+// nil. resRewrite rewrites the headers of the response, whether it came
+// from the backend or is one of the above, if it's not nil.
+//
+// Server-sent events are flushed to the client as soon as they arrive,
+// and each stream counts as an open connection until it ends. Every
+// response from the backend counts as streaming from the time its
+// headers arrive until its body is done
 func forwardRequest(
 	w http.ResponseWriter,
 	r *http.Request,
 	roundTripper http.RoundTripper,
 	fwdSvcURL *url.URL,
+	limits bodyLimits,
+	upstreamErrPage *errorPage,
+	timeoutPage *errorPage,
+	fwdHeaders *forwardedHeaders,
+	rewrite *routing.HeaderRewrite,
+	resRewrite *routing.ResponseHeaderRewrite,
 ) {
+	var reqBody *limitedReadCloser
+	if limits.maxRequestBytes > 0 {
+		if r.ContentLength > limits.maxRequestBytes {
+			markDropped(r.Context(), dropReasonBodyTooLarge)
+			rewriteResponseHeaders(w.Header(), resRewrite)
+			w.WriteHeader(413)
+			w.Write([]byte("request body too large"))
+			return
+		}
+		if r.Body != nil && r.Body != http.NoBody {
+			reqBody = newLimitedReadCloser(
+				r.Body,
+				limits.maxRequestBytes,
+				errors.New("request body too large"),
+			)
+			r.Body = reqBody
+		}
+	}
+
 	proxy := httputil.NewSingleHostReverseProxy(fwdSvcURL)
 	proxy.Transport = roundTripper
+	proxy.BufferPool = proxyBuffers
+	var resBody *limitedReadCloser
+	endEventStream := func() {}
+	endStreaming := func() {}
+	defer func() {
+		endEventStream()
+		endStreaming()
+	}()
+	proxy.ModifyResponse = func(res *http.Response) error {
+		endStreaming = startStreaming(r.Context())
+		rewriteResponseHeaders(res.Header, resRewrite)
+		if res.StatusCode >= 500 {
+			markDropped(r.Context(), dropReasonUpstream5xx)
+		}
+		if isEventStream(res.Header) {
+			// proxy is only used for this response, so this
+			// doesn't affect any other
+			proxy.FlushInterval = -1
+			endEventStream = trackEventStream(r.Context())
+		}
+		if limits.maxResponseBytes <= 0 {
+			return nil
+		}
+		if res.ContentLength > limits.maxResponseBytes {
+			return errResponseBodyTooLarge
+		}
+		// if the response turns out to be too large after its headers
+		// were sent, the proxy aborts the connection to the client
+		resBody = newLimitedReadCloser(
+			res.Body,
+			limits.maxResponseBytes,
+			errResponseBodyTooLarge,
+		)
+		res.Body = resBody
+		return nil
+	}
+	basePath := fwdSvcURL.Path
 	proxy.Director = func(req *http.Request) {
 		req.URL = fwdSvcURL
 		req.Host = fwdSvcURL.Host
-		req.URL.Path = r.URL.Path
+		req.URL.Path = joinURLPath(basePath, r.URL.Path)
 		req.URL.RawQuery = r.URL.RawQuery
-		// delete the incoming X-Forwarded-For header so the proxy
-		// puts its own in. This is also important to prevent IP spoofing
-		req.Header.Del("X-Forwarded-For ")
+		fwdHeaders.apply(req, r)
+		rewriteRequestHeaders(req, rewrite)
 	}
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		rewriteResponseHeaders(w.Header(), resRewrite)
+		tooLarge := (reqBody != nil && reqBody.exceeded) || errors.Is(err, errResponseBodyTooLarge)
+		timedOut := !tooLarge && isTimeout(err)
+		switch {
+		case tooLarge:
+			markDropped(r.Context(), dropReasonBodyTooLarge)
+		case timedOut:
+			markDropped(r.Context(), dropReasonUpstreamTimeout)
+		default:
+			markDropped(r.Context(), dropReasonUpstream5xx)
+		}
+		if reqBody != nil && reqBody.exceeded {
+			w.WriteHeader(413)
+			w.Write([]byte("request body too large"))
+			return
+		}
+		if timedOut {
+			if timeoutPage != nil {
+				timeoutPage.write(w)
+				return
+			}
+			writeUpstreamTimeout(w, r, err)
+			return
+		}
+		if upstreamErrPage != nil {
+			upstreamErrPage.write(w)
+			return
+		}
 		w.WriteHeader(502)
 		// note: we can only use the '%w' directive inside of fmt.Errorf,
 		// not Sprintf or anything similar. this means we have to create the
@@ -34,4 +149,55 @@ func forwardRequest(
 	}
 
 	proxy.ServeHTTP(w, r)
+	if resBody != nil && resBody.exceeded {
+		markDropped(r.Context(), dropReasonBodyTooLarge)
+	}
+}
+
+// isEventStream returns true if hdr are the headers of a server-sent
+// events response
+func isEventStream(hdr http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(hdr.Get("Content-Type"))
+	return err == nil && mediaType == "text/event-stream"
+}
+
+// joinURLPath appends reqPath to basePath, the path of the URL that a
+// request is forwarded to, so that backends outside the cluster can be
+// served under a path prefix
+func joinURLPath(basePath, reqPath string) string {
+	if basePath == "" {
+		return reqPath
+	}
+	return strings.TrimSuffix(basePath, "/") + "/" + strings.TrimPrefix(reqPath, "/")
+}
+
+// isTimeout returns true if err is the backend, or the connection to
+// it, timing out, rather than failing some other way
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var timeoutErr interface{ Timeout() bool }
+	return errors.As(err, &timeoutErr) && timeoutErr.Timeout()
+}
+
+// upstreamTimeoutBody is the body of the built-in response to a
+// request whose backend timed out, for clients that tell timeouts
+// apart from other errors
+type upstreamTimeoutBody struct {
+	Error     string `json:"error"`
+	Message   string `json:"message"`
+	RequestID string `json:"requestID,omitempty"`
+}
+
+// writeUpstreamTimeout writes the built-in 504 response to r, whose
+// backend timed out with err
+func writeUpstreamTimeout(w http.ResponseWriter, r *http.Request, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(504)
+	json.NewEncoder(w).Encode(upstreamTimeoutBody{
+		Error:     string(dropReasonUpstreamTimeout),
+		Message:   fmt.Sprintf("the backend didn't respond in time (%s)", err),
+		RequestID: requestIDFromContext(r.Context()),
+	})
 }
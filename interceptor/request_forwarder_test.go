@@ -1,6 +1,9 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
 	"log"
 	"net/http"
 	"net/http/httptest"
@@ -86,6 +89,12 @@ func TestForwarderSuccess(t *testing.T) {
 		req,
 		newRoundTripper(dialCtxFunc, timeouts.ResponseHeader),
 		forwardURL,
+		bodyLimits{},
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 	)
 
 	r.True(
@@ -133,14 +142,24 @@ func TestForwarderHeaderTimeout(t *testing.T) {
 		req,
 		newRoundTripper(dialCtxFunc, timeouts.ResponseHeader),
 		originURL,
+		bodyLimits{},
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 	)
 
 	forwardedRequests := hdl.IncomingRequests()
-	r.Equal(0, len(forwardedRequests))
-	r.Equal(502, res.Code)
-	r.Contains(res.Body.String(), "error on backend")
 	// the proxy has bailed out, so tell the origin to stop
 	close(originWaitCh)
+	r.Equal(0, len(forwardedRequests))
+	// timeouts get a 504, with a body that says so
+	r.Equal(504, res.Code)
+	r.Equal("application/json", res.Header().Get("Content-Type"))
+	body := upstreamTimeoutBody{}
+	r.NoError(json.Unmarshal(res.Body.Bytes(), &body))
+	r.Equal("upstream_timeout", body.Error)
 }
 
 // Test to ensure that the request forwarder waits for an origin that is slow
@@ -184,6 +203,12 @@ func TestForwarderWaitsForSlowOrigin(t *testing.T) {
 		req,
 		newRoundTripper(dialCtxFunc, timeouts.ResponseHeader),
 		originURL,
+		bodyLimits{},
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 	)
 	// wait for the goroutine above to finish, with a little cusion
 	ensureSignalBeforeTimeout(originWaitCh, originDelay*2)
@@ -212,6 +237,12 @@ func TestForwarderConnectionRetryAndTimeout(t *testing.T) {
 		req,
 		newRoundTripper(dialCtxFunc, timeouts.ResponseHeader),
 		noSuchURL,
+		bodyLimits{},
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
 	)
 	elapsed := time.Since(start)
 	log.Printf("forwardRequest took %s", elapsed)
@@ -237,3 +268,131 @@ func TestForwarderConnectionRetryAndTimeout(t *testing.T) {
 	)
 	r.Contains(res.Body.String(), "error on backend")
 }
+
+// server-sent events should reach the client as soon as the backend
+// flushes them, even through recorders that wrap the ResponseWriter,
+// and count as an open connection while they stream
+func TestForwarderFlushesEventStream(t *testing.T) {
+	r := require.New(t)
+	unblock := make(chan struct{})
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+		w.WriteHeader(200)
+		w.Write([]byte("data: first\n\n"))
+		w.(http.Flusher).Flush()
+		<-unblock
+		w.Write([]byte("data: second\n\n"))
+	}))
+	defer origin.Close()
+	forwardURL, err := url.Parse(origin.URL)
+	r.NoError(err)
+
+	streams := make(chan string, 2)
+	timeouts := defaultTimeouts()
+	dialCtxFunc := retryDialContextFunc(timeouts, timeouts.DefaultBackoff())
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req = req.WithContext(context.WithValue(
+			req.Context(),
+			eventStreamKey{},
+			func() func() {
+				streams <- "start"
+				return func() { streams <- "end" }
+			},
+		))
+		forwardRequest(
+			&statusRecorder{ResponseWriter: w},
+			req,
+			newRoundTripper(dialCtxFunc, timeouts.ResponseHeader),
+			forwardURL,
+			bodyLimits{},
+			nil,
+			nil,
+			nil,
+			nil,
+			nil,
+		)
+	}))
+	defer proxy.Close()
+	// closing unblock first lets the servers close
+	defer close(unblock)
+
+	res, err := http.Get(proxy.URL)
+	r.NoError(err)
+	defer res.Body.Close()
+	line, err := bufio.NewReader(res.Body).ReadString('\n')
+	r.NoError(err)
+	r.Equal("data: first\n", line)
+	r.Equal("start", <-streams)
+	select {
+	case evt := <-streams:
+		r.FailNow("the stream ended early", evt)
+	default:
+	}
+}
+
+// every response counts as streaming from the time that its headers
+// arrive until its body is done
+func TestForwarderTracksStreaming(t *testing.T) {
+	r := require.New(t)
+	unblock := make(chan struct{})
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		w.Write([]byte("first chunk\n"))
+		w.(http.Flusher).Flush()
+		<-unblock
+		w.Write([]byte("second chunk\n"))
+	}))
+	defer origin.Close()
+	forwardURL, err := url.Parse(origin.URL)
+	r.NoError(err)
+
+	streams := make(chan string, 2)
+	timeouts := defaultTimeouts()
+	dialCtxFunc := retryDialContextFunc(timeouts, timeouts.DefaultBackoff())
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req = req.WithContext(context.WithValue(
+			req.Context(),
+			streamingKey{},
+			func() func() {
+				streams <- "start"
+				return func() { streams <- "end" }
+			},
+		))
+		forwardRequest(
+			w,
+			req,
+			newRoundTripper(dialCtxFunc, timeouts.ResponseHeader),
+			forwardURL,
+			bodyLimits{},
+			nil,
+			nil,
+			nil,
+			nil,
+			nil,
+		)
+	}))
+	defer proxy.Close()
+
+	res, err := http.Get(proxy.URL)
+	r.NoError(err)
+	defer res.Body.Close()
+	body := bufio.NewReader(res.Body)
+	line, err := body.ReadString('\n')
+	r.NoError(err)
+	r.Equal("first chunk\n", line)
+	r.Equal("start", <-streams)
+	select {
+	case evt := <-streams:
+		r.FailNow("the response ended early", evt)
+	default:
+	}
+
+	close(unblock)
+	line, err = body.ReadString('\n')
+	r.NoError(err)
+	r.Equal("second chunk\n", line)
+	r.Equal("end", <-streams)
+
+	// requests outside of the count middleware have nothing to track
+	startStreaming(context.Background())()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/kedacore/http-add-on/interceptor/config"
+)
+
+// maxRequestIDLen is the length of the longest inbound
+// request ID that the interceptor keeps
+const maxRequestIDLen = 200
+
+type requestIDKey struct{}
+
+// requestIDFromContext returns the ID of the request that ctx belongs
+// to, or the empty string if it has none
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// newRequestID returns a random 128 bit request ID, hex encoded
+func newRequestID() string {
+	b := make([]byte, 16)
+	// crypto/rand only fails if the OS has no randomness to
+	// give, and an all-zero ID still proxies the request
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID returns true if id is short enough, and only
+// has printable ASCII characters, to be passed on as it is
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDMiddleware gives every request an ID, in the header that cfg
+// names. It keeps the client's ID if cfg trusts it and it's valid, and
+// generates a new one otherwise. The ID is sent on to the backend,
+// returned to the client, and put in the request's context, so that
+// handlers further down the chain can log it
+func requestIDMiddleware(cfg config.RequestID, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(cfg.Header)
+		if !cfg.TrustInbound || !validRequestID(id) {
+			id = newRequestID()
+		}
+		r.Header.Set(cfg.Header, id)
+		w.Header().Set(cfg.Header, id)
+		next.ServeHTTP(
+			w,
+			r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)),
+		)
+	})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kedacore/http-add-on/interceptor/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	r := require.New(t)
+	cfg := config.RequestID{Header: "X-Request-ID", TrustInbound: true}
+	var upstreamID, ctxID string
+	hdl := requestIDMiddleware(cfg, http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			upstreamID = req.Header.Get(cfg.Header)
+			ctxID = requestIDFromContext(req.Context())
+		},
+	))
+	serve := func(inbound string) string {
+		req := httptest.NewRequest("GET", "/", nil)
+		if inbound != "" {
+			req.Header.Set(cfg.Header, inbound)
+		}
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		// the backend, the handlers and the client
+		// all see the same ID
+		r.Equal(upstreamID, ctxID)
+		r.Equal(upstreamID, rec.Header().Get(cfg.Header))
+		return upstreamID
+	}
+
+	// a new ID is generated for every request without one
+	id1 := serve("")
+	r.Len(id1, 32)
+	r.NotEqual(id1, serve(""))
+
+	// a valid inbound ID is kept
+	r.Equal("abc-123", serve("abc-123"))
+
+	// invalid inbound IDs are replaced
+	r.NotEqual("has space", serve("has space"))
+	long := strings.Repeat("a", maxRequestIDLen+1)
+	r.NotEqual(long, serve(long))
+
+	// inbound IDs are replaced if they aren't trusted
+	cfg.TrustInbound = false
+	hdl = requestIDMiddleware(cfg, http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			upstreamID = req.Header.Get(cfg.Header)
+			ctxID = requestIDFromContext(req.Context())
+		},
+	))
+	r.NotEqual("abc-123", serve("abc-123"))
+}
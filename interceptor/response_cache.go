@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/interceptor/config"
+	"github.com/kedacore/http-add-on/pkg/routing"
+)
+
+// cacheStatusHeader is the response header that says whether a
+// response came from the response cache
+const cacheStatusHeader = "X-Cache"
+
+// cachedResponse is a response that's held in a responseCache
+type cachedResponse struct {
+	key     string
+	status  int
+	header  http.Header
+	body    []byte
+	storeAt time.Time
+	expires time.Time
+	// vary holds the values, in the request that this response
+	// was for, of the request headers the response varies on
+	vary map[string]string
+}
+
+// size returns roughly how much memory c takes up
+func (c *cachedResponse) size() int64 {
+	size := int64(len(c.key) + len(c.body))
+	for name, vals := range c.header {
+		size += int64(len(name))
+		for _, val := range vals {
+			size += int64(len(val))
+		}
+	}
+	return size
+}
+
+// matches returns true if c can be served for r, given the
+// request headers that c varies on
+func (c *cachedResponse) matches(r *http.Request) bool {
+	for name, val := range c.vary {
+		if r.Header.Get(name) != val {
+			return false
+		}
+	}
+	return true
+}
+
+// responseCacheStats are the counters that a responseCache exposes
+type responseCacheStats struct {
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Stores    int64 `json:"stores"`
+	Evictions int64 `json:"evictions"`
+	Entries   int   `json:"entries"`
+	Bytes     int64 `json:"bytes"`
+}
+
+// responseCache is an in-memory LRU cache of responses, bounded by the
+// total size of the responses it holds
+type responseCache struct {
+	mut     *sync.Mutex
+	cfg     config.ResponseCache
+	lru     *list.List
+	entries map[string]*list.Element
+	stats   responseCacheStats
+}
+
+func newResponseCache(cfg config.ResponseCache) *responseCache {
+	return &responseCache{
+		mut:     new(sync.Mutex),
+		cfg:     cfg,
+		lru:     list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+// get returns the response cached under key at time now, if there is
+// one that hasn't expired and that can be served for r
+func (c *responseCache) get(
+	now time.Time,
+	key string,
+	r *http.Request,
+) (*cachedResponse, bool) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	elt, ok := c.entries[key]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	resp := elt.Value.(*cachedResponse)
+	if !now.Before(resp.expires) {
+		c.remove(elt)
+		c.stats.Misses++
+		return nil, false
+	}
+	if !resp.matches(r) {
+		c.stats.Misses++
+		return nil, false
+	}
+	c.lru.MoveToFront(elt)
+	c.stats.Hits++
+	return resp, true
+}
+
+// put caches resp, replacing any response already cached under the
+// same key, then evicts the least recently used responses until the
+// cache fits in its maximum size
+func (c *responseCache) put(resp *cachedResponse) {
+	size := resp.size()
+	if size > c.cfg.MaxEntryBytes || size > c.cfg.MaxBytes {
+		return
+	}
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if elt, ok := c.entries[resp.key]; ok {
+		c.remove(elt)
+	}
+	c.entries[resp.key] = c.lru.PushFront(resp)
+	c.stats.Bytes += size
+	c.stats.Stores++
+	for c.stats.Bytes > c.cfg.MaxBytes {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// remove removes elt from the cache. Callers must hold c.mut
+func (c *responseCache) remove(elt *list.Element) {
+	resp := elt.Value.(*cachedResponse)
+	c.lru.Remove(elt)
+	delete(c.entries, resp.key)
+	c.stats.Bytes -= resp.size()
+}
+
+// MarshalJSON returns the cache's hit, miss and eviction counters,
+// and how much it's holding
+func (c *responseCache) MarshalJSON() ([]byte, error) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	stats := c.stats
+	stats.Entries = len(c.entries)
+	return json.Marshal(stats)
+}
+
+// cacheControl returns the directives in the Cache-Control headers
+// of h. Directives without a value map to the empty string
+func cacheControl(h http.Header) map[string]string {
+	ret := map[string]string{}
+	for _, val := range h.Values("Cache-Control") {
+		for _, directive := range strings.Split(val, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			name, arg := directive, ""
+			if i := strings.Index(directive, "="); i >= 0 {
+				name, arg = directive[:i], strings.Trim(directive[i+1:], `"`)
+			}
+			ret[strings.ToLower(name)] = arg
+		}
+	}
+	return ret
+}
+
+// cacheableStatuses are the response statuses that may be cached
+var cacheableStatuses = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+}
+
+// responseTTL returns how long a response with status and header may
+// be cached for, according to its Cache-Control header. defaultTTL is
+// used if the header doesn't say, and the result is capped at maxTTL.
+// Returns 0 if the response may not be cached
+func responseTTL(
+	status int,
+	header http.Header,
+	defaultTTL,
+	maxTTL time.Duration,
+) time.Duration {
+	if !cacheableStatuses[status] || header.Get("Set-Cookie") != "" {
+		return 0
+	}
+	// the cache is shared between all clients, so it can't
+	// hold responses that differ for every request
+	if header.Get("Vary") == "*" {
+		return 0
+	}
+	cc := cacheControl(header)
+	for _, directive := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := cc[directive]; ok {
+			return 0
+		}
+	}
+	ttl := defaultTTL
+	// s-maxage is for shared caches like this one, so it takes
+	// precedence over max-age
+	for _, directive := range []string{"max-age", "s-maxage"} {
+		if arg, ok := cc[directive]; ok {
+			secs, err := strconv.Atoi(arg)
+			if err != nil || secs < 0 {
+				return 0
+			}
+			ttl = time.Duration(secs) * time.Second
+		}
+	}
+	if ttl > maxTTL {
+		ttl = maxTTL
+	}
+	return ttl
+}
+
+// cacheRecorder passes a response through to its ResponseWriter and
+// keeps a copy of it, up to maxBytes of body, so that it can be cached
+type cacheRecorder struct {
+	http.ResponseWriter
+	maxBytes int64
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	tooLarge bool
+}
+
+func (c *cacheRecorder) WriteHeader(code int) {
+	if c.status == 0 {
+		c.status = code
+		c.header = c.ResponseWriter.Header().Clone()
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *cacheRecorder) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.WriteHeader(http.StatusOK)
+	}
+	if !c.tooLarge {
+		if int64(c.body.Len()+len(b)) > c.maxBytes {
+			c.tooLarge = true
+			c.body.Reset()
+		} else {
+			c.body.Write(b)
+		}
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher, so that streamed responses like
+// server-sent events aren't held back by the recorder
+func (c *cacheRecorder) Flush() {
+	if flusher, ok := c.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// responseCacheMiddleware serves GET and HEAD requests from cache if
+// the request's host has a response cache policy and a fresh response
+// is cached for the request. Otherwise, it calls next and caches the
+// response to GET requests if its Cache-Control header allows.
+//
+// Requests that are served from cache never reach the count middleware,
+// so they don't wake up or keep up the backend
+func responseCacheMiddleware(
+	lggr logr.Logger,
+	routingTable routing.TableReader,
+	cache *responseCache,
+	next http.Handler,
+) http.Handler {
+	lggr = lggr.WithName("responseCacheMiddleware")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// let the next handler deal with requests that have
+		// no host or an unknown one
+		host, err := getHost(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		target, err := lookupTarget(r.Context(), routingTable, host)
+		if err != nil || target.ResponseCache == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// responses to authorized requests are likely to be
+		// specific to the client, so they're never shared
+		if r.Header.Get("Authorization") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := host + r.URL.RequestURI()
+		reqCC := cacheControl(r.Header)
+		_, noStore := reqCC["no-store"]
+		_, noCache := reqCC["no-cache"]
+		now := time.Now()
+		if !noStore && !noCache {
+			if resp, ok := cache.get(now, key, r); ok {
+				lggr.V(1).Info("serving response from cache", "host", host, "key", key)
+				for name, vals := range resp.header {
+					w.Header()[name] = vals
+				}
+				w.Header().Set("Age", strconv.Itoa(int(now.Sub(resp.storeAt).Seconds())))
+				w.Header().Set(cacheStatusHeader, "HIT")
+				w.WriteHeader(resp.status)
+				if r.Method != http.MethodHead {
+					w.Write(resp.body)
+				}
+				return
+			}
+		}
+
+		w.Header().Set(cacheStatusHeader, "MISS")
+		rec := &cacheRecorder{ResponseWriter: w, maxBytes: cache.cfg.MaxEntryBytes}
+		next.ServeHTTP(rec, r)
+		// only full GET responses can be served for both GET and HEAD
+		if r.Method != http.MethodGet || noStore || rec.tooLarge || rec.status == 0 {
+			return
+		}
+		// an event stream is only ever a snapshot of its events
+		if isEventStream(rec.header) {
+			return
+		}
+		ttl := responseTTL(
+			rec.status,
+			rec.header,
+			time.Duration(target.ResponseCache.DefaultTTLSeconds)*time.Second,
+			cache.cfg.MaxTTL,
+		)
+		if ttl <= 0 {
+			return
+		}
+		rec.header.Del(cacheStatusHeader)
+		vary := map[string]string{}
+		for _, val := range rec.header.Values("Vary") {
+			for _, name := range strings.Split(val, ",") {
+				name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+				if name != "" {
+					vary[name] = r.Header.Get(name)
+				}
+			}
+		}
+		storeAt := time.Now()
+		cache.put(&cachedResponse{
+			key:     key,
+			status:  rec.status,
+			header:  rec.header,
+			body:    rec.body.Bytes(),
+			storeAt: storeAt,
+			expires: storeAt.Add(ttl),
+			vary:    vary,
+		})
+	})
+}
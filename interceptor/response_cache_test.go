@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/interceptor/config"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseTTL(t *testing.T) {
+	const (
+		defaultTTL = 10 * time.Second
+		maxTTL     = time.Minute
+	)
+	type testCase struct {
+		name   string
+		status int
+		header http.Header
+		ttl    time.Duration
+	}
+	cases := []testCase{
+		{"no header uses default", 200, http.Header{}, defaultTTL},
+		{"max-age", 200, http.Header{"Cache-Control": {"public, max-age=30"}}, 30 * time.Second},
+		{"s-maxage wins", 200, http.Header{"Cache-Control": {"max-age=30, s-maxage=5"}}, 5 * time.Second},
+		{"capped at max", 200, http.Header{"Cache-Control": {"max-age=3600"}}, maxTTL},
+		{"no-store", 200, http.Header{"Cache-Control": {"no-store"}}, 0},
+		{"no-cache", 200, http.Header{"Cache-Control": {"no-cache"}}, 0},
+		{"private", 200, http.Header{"Cache-Control": {"private, max-age=30"}}, 0},
+		{"bad max-age", 200, http.Header{"Cache-Control": {"max-age=abc"}}, 0},
+		{"set-cookie", 200, http.Header{"Set-Cookie": {"a=b"}}, 0},
+		{"vary star", 200, http.Header{"Vary": {"*"}}, 0},
+		{"404 cacheable", 404, http.Header{}, defaultTTL},
+		{"500 not cacheable", 500, http.Header{"Cache-Control": {"max-age=30"}}, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			require.Equal(
+				t,
+				c.ttl,
+				responseTTL(c.status, c.header, defaultTTL, maxTTL),
+			)
+		})
+	}
+}
+
+func TestResponseCacheEviction(t *testing.T) {
+	r := require.New(t)
+	cache := newResponseCache(config.ResponseCache{
+		MaxBytes:      25,
+		MaxEntryBytes: 15,
+		MaxTTL:        time.Minute,
+	})
+	now := time.Now()
+	newResp := func(key string, body string) *cachedResponse {
+		return &cachedResponse{
+			key:     key,
+			status:  200,
+			header:  http.Header{},
+			body:    []byte(body),
+			storeAt: now,
+			expires: now.Add(time.Minute),
+		}
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+
+	cache.put(newResp("a", "0123456789"))
+	cache.put(newResp("b", "0123456789"))
+	// a is now the most recently used, so b gets evicted
+	_, ok := cache.get(now, "a", req)
+	r.True(ok)
+	cache.put(newResp("c", "0123456789"))
+	_, ok = cache.get(now, "b", req)
+	r.False(ok)
+	_, ok = cache.get(now, "a", req)
+	r.True(ok)
+	_, ok = cache.get(now, "c", req)
+	r.True(ok)
+	// too large for a single entry
+	cache.put(newResp("d", "01234567890123456789"))
+	_, ok = cache.get(now, "d", req)
+	r.False(ok)
+	// expired
+	_, ok = cache.get(now.Add(time.Minute), "a", req)
+	r.False(ok)
+
+	b, err := json.Marshal(cache)
+	r.NoError(err)
+	r.JSONEq(
+		`{"hits":3,"misses":3,"stores":3,"evictions":1,"entries":1,"bytes":11}`,
+		string(b),
+	)
+}
+
+func TestResponseCacheMiddleware(t *testing.T) {
+	const (
+		host        = "TestResponseCacheMiddleware.testing"
+		uncachedHst = "uncached.testing"
+	)
+	r := require.New(t)
+	table := routing.NewTable()
+	target := routing.NewTarget("testsvc", 8080, "testdepl", 100)
+	r.NoError(table.AddTarget(uncachedHst, target))
+	target.ResponseCache = &routing.ResponseCachePolicy{DefaultTTLSeconds: 30}
+	r.NoError(table.AddTarget(host, target))
+
+	numCalls := 0
+	hdl := responseCacheMiddleware(
+		logr.Discard(),
+		table,
+		newResponseCache(config.ResponseCache{
+			MaxBytes:      1024,
+			MaxEntryBytes: 1024,
+			MaxTTL:        time.Minute,
+		}),
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			numCalls++
+			w.Header().Set("Vary", "Accept-Language")
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(200)
+			w.Write([]byte("hello " + req.Header.Get("Accept-Language")))
+		}),
+	)
+	do := func(method, host string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/path?q=1", nil)
+		req.Host = host
+		for name, vals := range header {
+			req.Header[name] = vals
+		}
+		res := httptest.NewRecorder()
+		hdl.ServeHTTP(res, req)
+		return res
+	}
+	en := http.Header{"Accept-Language": {"en"}}
+
+	res := do("GET", host, en)
+	r.Equal(200, res.Code)
+	r.Equal("MISS", res.Header().Get(cacheStatusHeader))
+	r.Equal("hello en", res.Body.String())
+	r.Equal(1, numCalls)
+
+	// served from cache, without calling the backend
+	res = do("GET", host, en)
+	r.Equal(200, res.Code)
+	r.Equal("HIT", res.Header().Get(cacheStatusHeader))
+	r.Equal("text/plain", res.Header().Get("Content-Type"))
+	r.Equal("hello en", res.Body.String())
+	r.Equal(1, numCalls)
+
+	// HEAD requests are served from cached GET responses
+	res = do("HEAD", host, en)
+	r.Equal("HIT", res.Header().Get(cacheStatusHeader))
+	r.Empty(res.Body.String())
+	r.Equal(1, numCalls)
+
+	// a different value for a Vary header isn't a match
+	res = do("GET", host, http.Header{"Accept-Language": {"de"}})
+	r.Equal("MISS", res.Header().Get(cacheStatusHeader))
+	r.Equal("hello de", res.Body.String())
+	r.Equal(2, numCalls)
+
+	// the client can ask to skip the cache
+	res = do("GET", host, http.Header{
+		"Accept-Language": {"de"},
+		"Cache-Control":   {"no-cache"},
+	})
+	r.Equal("MISS", res.Header().Get(cacheStatusHeader))
+	r.Equal(3, numCalls)
+
+	// authorized requests, other methods, and hosts
+	// without a response cache policy are never cached
+	for i := 0; i < 2; i++ {
+		do("GET", host, http.Header{"Authorization": {"Bearer abc"}})
+		do("POST", host, en)
+		do("GET", uncachedHst, en)
+	}
+	r.Equal(9, numCalls)
+}
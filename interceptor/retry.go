@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// isIdempotent returns true if requests with the given method
+// can safely be sent to the backend more than once
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet,
+		http.MethodHead,
+		http.MethodOptions,
+		http.MethodTrace,
+		http.MethodPut,
+		http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// retryBudget limits the number of retries that are in flight to
+// a single host, relative to the number of requests that are in
+// flight to it. This prevents retries from amplifying load on a
+// backend that is already failing.
+type retryBudget struct {
+	mut      *sync.Mutex
+	percent  int
+	requests int
+	retries  int
+}
+
+func newRetryBudget(percent int) *retryBudget {
+	return &retryBudget{
+		mut:     new(sync.Mutex),
+		percent: percent,
+	}
+}
+
+// startRequest records a new in-flight request. callers must
+// call the returned function when the request is done
+func (b *retryBudget) startRequest() func() {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	b.requests++
+	return func() {
+		b.mut.Lock()
+		defer b.mut.Unlock()
+		b.requests--
+	}
+}
+
+// tryStartRetry records a new in-flight retry and returns true
+// if the budget allows it. If it returns true, callers must call
+// finishRetry when the retry is done
+func (b *retryBudget) tryStartRetry() bool {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	allowed := b.requests * b.percent / 100
+	// always allow at least one retry in flight, so that
+	// hosts with very little traffic can still retry
+	if allowed < 1 {
+		allowed = 1
+	}
+	if b.retries >= allowed {
+		return false
+	}
+	b.retries++
+	return true
+}
+
+func (b *retryBudget) finishRetry() {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	b.retries--
+}
+
+// retryBudgets holds a retryBudget for each host
+type retryBudgets struct {
+	mut     *sync.Mutex
+	budgets map[string]*retryBudget
+}
+
+func newRetryBudgets() *retryBudgets {
+	return &retryBudgets{
+		mut:     new(sync.Mutex),
+		budgets: map[string]*retryBudget{},
+	}
+}
+
+// forHost returns the retryBudget for host, creating it with the
+// given percentage if it doesn't exist yet. If it already exists
+// with a different percentage, the percentage is updated
+func (r *retryBudgets) forHost(host string, percent int) *retryBudget {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	budget, ok := r.budgets[host]
+	if !ok {
+		budget = newRetryBudget(percent)
+		r.budgets[host] = budget
+		return budget
+	}
+	budget.mut.Lock()
+	budget.percent = percent
+	budget.mut.Unlock()
+	return budget
+}
+
+// retryRoundTripper is an http.RoundTripper that retries idempotent
+// requests that fail before a response is received from the backend,
+// backing off exponentially between attempts.
+//
+// Requests with a body are never retried, since the body can't be
+// read more than once.
+type retryRoundTripper struct {
+	next    http.RoundTripper
+	policy  routing.RetryPolicy
+	budget  *retryBudget
+	backoff wait.Backoff
+}
+
+func newRetryRoundTripper(
+	next http.RoundTripper,
+	policy routing.RetryPolicy,
+	budget *retryBudget,
+) *retryRoundTripper {
+	return &retryRoundTripper{
+		next:   next,
+		policy: policy,
+		budget: budget,
+		backoff: wait.Backoff{
+			Duration: time.Duration(policy.BackoffMS) * time.Millisecond,
+			Factor:   2,
+			Jitter:   0.5,
+			Steps:    policy.Attempts,
+		},
+	}
+}
+
+func (rt *retryRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	finishRequest := rt.budget.startRequest()
+	defer finishRequest()
+
+	res, err := rt.next.RoundTrip(req)
+	if err == nil || !rt.canRetry(req) {
+		return res, err
+	}
+
+	// copy the backoff so that concurrent requests
+	// don't share the same steps
+	backoff := rt.backoff
+	for i := 0; i < rt.policy.Attempts; i++ {
+		if !rt.budget.tryStartRetry() {
+			return nil, fmt.Errorf("retry budget exhausted (%w)", err)
+		}
+		t := time.NewTimer(backoff.Step())
+		select {
+		case <-req.Context().Done():
+			t.Stop()
+			rt.budget.finishRetry()
+			return nil, fmt.Errorf(
+				"context done while waiting to retry (%s): %w",
+				req.Context().Err(),
+				err,
+			)
+		case <-t.C:
+		}
+		res, err = rt.next.RoundTrip(req)
+		rt.budget.finishRetry()
+		if err == nil {
+			return res, nil
+		}
+	}
+	return nil, err
+}
+
+func (rt *retryRoundTripper) canRetry(req *http.Request) bool {
+	if !isIdempotent(req.Method) {
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody
+}
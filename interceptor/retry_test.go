@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRoundTripper fails the first numFailures calls to RoundTrip,
+// then succeeds with a 200 response
+type fakeRoundTripper struct {
+	mut         *sync.Mutex
+	numFailures int
+	numCalls    int
+}
+
+func (f *fakeRoundTripper) RoundTrip(*http.Request) (*http.Response, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	f.numCalls++
+	if f.numCalls <= f.numFailures {
+		return nil, errors.New("connection refused")
+	}
+	return &http.Response{StatusCode: 200}, nil
+}
+
+func newTestRetryRoundTripper(
+	numFailures int,
+	policy routing.RetryPolicy,
+) (*fakeRoundTripper, *retryRoundTripper) {
+	fake := &fakeRoundTripper{mut: new(sync.Mutex), numFailures: numFailures}
+	return fake, newRetryRoundTripper(
+		fake,
+		policy,
+		newRetryBudget(policy.BudgetPercent),
+	)
+}
+
+func TestRetryRoundTripperSucceedsAfterRetries(t *testing.T) {
+	r := require.New(t)
+	fake, rt := newTestRetryRoundTripper(2, routing.RetryPolicy{
+		Attempts:      3,
+		BackoffMS:     1,
+		BudgetPercent: 100,
+	})
+	req, err := http.NewRequest("GET", "/testretry", nil)
+	r.NoError(err)
+	res, err := rt.RoundTrip(req)
+	r.NoError(err)
+	r.Equal(200, res.StatusCode)
+	r.Equal(3, fake.numCalls)
+}
+
+func TestRetryRoundTripperGivesUp(t *testing.T) {
+	r := require.New(t)
+	fake, rt := newTestRetryRoundTripper(10, routing.RetryPolicy{
+		Attempts:      2,
+		BackoffMS:     1,
+		BudgetPercent: 100,
+	})
+	req, err := http.NewRequest("GET", "/testretry", nil)
+	r.NoError(err)
+	_, err = rt.RoundTrip(req)
+	r.Error(err)
+	// the initial attempt plus 2 retries
+	r.Equal(3, fake.numCalls)
+}
+
+func TestRetryRoundTripperNonIdempotent(t *testing.T) {
+	r := require.New(t)
+	policy := routing.RetryPolicy{
+		Attempts:      3,
+		BackoffMS:     1,
+		BudgetPercent: 100,
+	}
+
+	// POST requests should never be retried
+	fake, rt := newTestRetryRoundTripper(1, policy)
+	req, err := http.NewRequest("POST", "/testretry", nil)
+	r.NoError(err)
+	_, err = rt.RoundTrip(req)
+	r.Error(err)
+	r.Equal(1, fake.numCalls)
+
+	// neither should requests with a body
+	fake, rt = newTestRetryRoundTripper(1, policy)
+	req, err = http.NewRequest("PUT", "/testretry", strings.NewReader("body"))
+	r.NoError(err)
+	_, err = rt.RoundTrip(req)
+	r.Error(err)
+	r.Equal(1, fake.numCalls)
+}
+
+func TestRetryBudget(t *testing.T) {
+	r := require.New(t)
+	budget := newRetryBudget(50)
+
+	// with no requests in flight, one retry is still allowed
+	r.True(budget.tryStartRetry())
+	r.False(budget.tryStartRetry())
+	budget.finishRetry()
+
+	// with 4 requests in flight and a 50% budget,
+	// 2 retries are allowed
+	for i := 0; i < 4; i++ {
+		defer budget.startRequest()()
+	}
+	r.True(budget.tryStartRetry())
+	r.True(budget.tryStartRetry())
+	r.False(budget.tryStartRetry())
+	budget.finishRetry()
+	r.True(budget.tryStartRetry())
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/kedacore/http-add-on/pkg/routing"
+)
+
+type pinnedRouteKey struct{}
+
+// pinnedRoute is what a request's host routed to when the request
+// was accepted
+type pinnedRoute struct {
+	host   string
+	target routing.Target
+	err    error
+}
+
+// pinRouteMiddleware looks up the target of each request's host in
+// routingTable once, when the request is accepted, and pins the
+// request to it. Handlers further down the chain use lookupTarget, so
+// that a request is authorized, counted, waited on and forwarded for
+// the same target, even if the routing table changes while it's in
+// flight. Changes to the table only apply to the requests that come
+// after them
+func pinRouteMiddleware(
+	routingTable routing.TableReader,
+	next http.Handler,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// requests with no host are turned away further down
+		host, err := getHost(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		target, err := routingTable.Lookup(host)
+		pinned := &pinnedRoute{host: host, target: target, err: err}
+		next.ServeHTTP(
+			w,
+			r.WithContext(context.WithValue(r.Context(), pinnedRouteKey{}, pinned)),
+		)
+	})
+}
+
+// lookupTarget returns the target of host for the request that ctx
+// belongs to. That's the target that the request was pinned to when it
+// was accepted, or, if it wasn't pinned to one for host, the one that
+// routingTable has now
+func lookupTarget(
+	ctx context.Context,
+	routingTable routing.TableReader,
+	host string,
+) (routing.Target, error) {
+	if pinned, ok := ctx.Value(pinnedRouteKey{}).(*pinnedRoute); ok && pinned.host == host {
+		return pinned.target, pinned.err
+	}
+	return routingTable.Lookup(host)
+}
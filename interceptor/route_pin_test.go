@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/go-logr/logr"
+	kedanet "github.com/kedacore/http-add-on/pkg/net"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"github.com/stretchr/testify/require"
+)
+
+// requests that are in flight when their host's target changes should
+// still be forwarded to the target they were accepted for
+func TestPinRouteMiddleware(t *testing.T) {
+	const host = "TestPinRouteMiddleware.testing"
+	r := require.New(t)
+	srv, srvURL, err := kedanet.StartTestServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(200)
+			w.Write([]byte("old target"))
+		}),
+	)
+	r.NoError(err)
+	defer srv.Close()
+	srvHost, srvPort, err := splitHostPort(srvURL.Host)
+	r.NoError(err)
+	routingTable := routing.NewTable()
+	r.NoError(routingTable.AddTarget(host, routing.Target{
+		Service:    srvHost,
+		Port:       srvPort,
+		Deployment: "olddepl",
+	}))
+
+	// the target changes while the request waits for its backend
+	waitFunc := func(_ context.Context, target routing.Target) error {
+		r.Equal("olddepl", target.Deployment)
+		newTable := routing.NewTable()
+		r.NoError(newTable.AddTarget(host, routing.Target{
+			Service:    "nosuchsvc",
+			Port:       9091,
+			Deployment: "newdepl",
+		}))
+		routingTable.Replace(newTable)
+		return nil
+	}
+	timeouts := defaultTimeouts()
+	hdl := pinRouteMiddleware(
+		routingTable,
+		newForwardingHandler(
+			logr.Discard(),
+			routingTable,
+			retryDialContextFunc(timeouts, timeouts.DefaultBackoff()),
+			waitFunc,
+			forwardingConfig{
+				waitTimeout:       timeouts.DeploymentReplicas,
+				respHeaderTimeout: timeouts.ResponseHeader,
+			},
+		),
+	)
+	res, req, err := reqAndRes("/testfwd")
+	r.NoError(err)
+	req.Host = host
+	hdl.ServeHTTP(res, req)
+	r.Equal(200, res.Code)
+	r.Equal("old target", res.Body.String())
+
+	// new requests get the new target
+	target, err := lookupTarget(context.Background(), routingTable, host)
+	r.NoError(err)
+	r.Equal("newdepl", target.Deployment)
+}
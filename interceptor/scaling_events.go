@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/operator/api/v1alpha1"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// scalingEventStarted is emitted when a request arrives for a host
+	// whose workload is scaled to zero
+	scalingEventStarted = "sh.keda.http.scalefromzero.started"
+	// scalingEventCompleted is emitted when a host that was scaling
+	// from zero has a ready backend, which makes it warm
+	scalingEventCompleted = "sh.keda.http.scalefromzero.completed"
+	// scalingEventTimedOut is emitted when a request gives up waiting
+	// for a host that was scaling from zero
+	scalingEventTimedOut = "sh.keda.http.coldstart.timedout"
+)
+
+// scalingEvent is something that happened to a host's scale from
+// zero. It's the data of the CloudEvents that scalingEvents emits
+type scalingEvent struct {
+	Type             string `json:"-"`
+	Host             string `json:"host"`
+	HTTPScaledObject string `json:"httpScaledObject,omitempty"`
+	// DurationMS is the time since the scale from zero started, for
+	// events that end it
+	DurationMS float64 `json:"durationMS,omitempty"`
+}
+
+// scalingEventSink is where scalingEvents sends its events
+type scalingEventSink interface {
+	send(ctx context.Context, evt scalingEvent) error
+}
+
+// scalingEvents tracks which hosts are scaling from zero, and sends an
+// event to its sink when one starts, completes or times out. Each
+// scale from zero only sends one event of each kind, no matter how
+// many requests wait on it
+type scalingEvents struct {
+	lggr    logr.Logger
+	sink    scalingEventSink
+	timeout time.Duration
+	mut     *sync.Mutex
+	// starts holds the time that each host
+	// that's scaling from zero started at
+	starts map[string]time.Time
+}
+
+func newScalingEvents(
+	lggr logr.Logger,
+	sink scalingEventSink,
+	timeout time.Duration,
+) *scalingEvents {
+	return &scalingEvents{
+		lggr:    lggr.WithName("scalingEvents"),
+		sink:    sink,
+		timeout: timeout,
+		mut:     new(sync.Mutex),
+		starts:  map[string]time.Time{},
+	}
+}
+
+// started records that host, which routes to target, got a request at
+// arrival while its workload had no replicas. It does nothing if s is
+// nil, or host is scaling from zero already
+func (s *scalingEvents) started(host string, target routing.Target, arrival time.Time) {
+	if s == nil {
+		return
+	}
+	s.mut.Lock()
+	if _, ok := s.starts[host]; ok {
+		s.mut.Unlock()
+		return
+	}
+	s.starts[host] = arrival
+	s.mut.Unlock()
+	s.emit(scalingEvent{
+		Type:             scalingEventStarted,
+		Host:             host,
+		HTTPScaledObject: target.HTTPScaledObject,
+	})
+}
+
+// finished records that host stopped scaling from zero, with an event
+// of type typ, which is either scalingEventCompleted or
+// scalingEventTimedOut. It does nothing if s is nil, or host wasn't
+// scaling from zero
+func (s *scalingEvents) finished(host string, target routing.Target, typ string) {
+	if s == nil {
+		return
+	}
+	s.mut.Lock()
+	start, ok := s.starts[host]
+	delete(s.starts, host)
+	s.mut.Unlock()
+	if !ok {
+		return
+	}
+	s.emit(scalingEvent{
+		Type:             typ,
+		Host:             host,
+		HTTPScaledObject: target.HTTPScaledObject,
+		DurationMS:       durationMS(time.Since(start)),
+	})
+}
+
+// emit sends evt to s's sink in the background, so that
+// requests never wait on it
+func (s *scalingEvents) emit(evt scalingEvent) {
+	go func() {
+		ctx, done := context.WithTimeout(context.Background(), s.timeout)
+		defer done()
+		if err := s.sink.send(ctx, evt); err != nil {
+			s.lggr.Error(err, "emitting scaling event", "type", evt.Type, "host", evt.Host)
+		}
+	}()
+}
+
+// httpScalingEvents POSTs scaling events to a URL as CloudEvents, in
+// structured mode
+type httpScalingEvents struct {
+	cl     *http.Client
+	url    string
+	source string
+}
+
+func (h *httpScalingEvents) send(ctx context.Context, evt scalingEvent) error {
+	body, err := json.Marshal(newCloudEvent(h.source, evt.Type, evt.Host, evt))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", h.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", cloudEventsContentType)
+	res, err := h.cl.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("scaling event sink responded with %d", res.StatusCode)
+	}
+	return nil
+}
+
+// k8sScalingEvents records scaling events as Kubernetes Events on the
+// HTTPScaledObjects of their hosts
+type k8sScalingEvents struct {
+	cl kubernetes.Interface
+	ns string
+}
+
+func (k *k8sScalingEvents) send(ctx context.Context, evt scalingEvent) error {
+	var eventType, reason, message string
+	switch evt.Type {
+	case scalingEventStarted:
+		eventType = corev1.EventTypeNormal
+		reason = "ScaleFromZeroStarted"
+		message = fmt.Sprintf("host %s got a request while scaled to zero", evt.Host)
+	case scalingEventCompleted:
+		eventType = corev1.EventTypeNormal
+		reason = "ScaleFromZeroCompleted"
+		message = fmt.Sprintf(
+			"host %s is ready after %s",
+			evt.Host,
+			time.Duration(evt.DurationMS*float64(time.Millisecond)).Round(time.Millisecond),
+		)
+	case scalingEventTimedOut:
+		eventType = corev1.EventTypeWarning
+		reason = "ColdStartTimedOut"
+		message = fmt.Sprintf(
+			"host %s wasn't ready after %s",
+			evt.Host,
+			time.Duration(evt.DurationMS*float64(time.Millisecond)).Round(time.Millisecond),
+		)
+	default:
+		return fmt.Errorf("unknown scaling event type %s", evt.Type)
+	}
+	return recordHTTPScaledObjectEvent(
+		ctx,
+		k.cl,
+		k.ns,
+		evt.Host,
+		evt.HTTPScaledObject,
+		eventType,
+		reason,
+		message,
+	)
+}
+
+// recordHTTPScaledObjectEvent creates a Kubernetes Event on the
+// HTTPScaledObject called name, in ns, which host belongs to. Returns
+// an error if name is empty, which it is in routing tables from older
+// operators
+func recordHTTPScaledObjectEvent(
+	ctx context.Context,
+	cl kubernetes.Interface,
+	ns,
+	host,
+	name,
+	eventType,
+	reason,
+	message string,
+) error {
+	if name == "" {
+		return fmt.Errorf("no HTTPScaledObject known for host %s", host)
+	}
+	now := metav1.Now()
+	_, err := cl.CoreV1().Events(ns).Create(ctx, &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: name + ".",
+			Namespace:    ns,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion: v1alpha1.GroupVersion.String(),
+			Kind:       "HTTPScaledObject",
+			Name:       name,
+			Namespace:  ns,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         corev1.EventSource{Component: "keda-http-interceptor"},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}, metav1.CreateOptions{})
+	return err
+}
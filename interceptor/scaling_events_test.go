@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/interceptor/config"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+type fakeScalingEventSink struct {
+	mut    sync.Mutex
+	events []scalingEvent
+}
+
+func (f *fakeScalingEventSink) send(_ context.Context, evt scalingEvent) error {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	f.events = append(f.events, evt)
+	return nil
+}
+
+func (f *fakeScalingEventSink) types() []string {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	ret := []string{}
+	for _, evt := range f.events {
+		ret = append(ret, evt.Type)
+	}
+	return ret
+}
+
+func TestColdStartTrackerScalingEvents(t *testing.T) {
+	const host = "TestColdStartTrackerScalingEvents.testing"
+	r := require.New(t)
+	ctx := context.Background()
+	sink := &fakeScalingEventSink{}
+	tracker := newColdStartTracker(
+		logr.Discard(),
+		config.ColdStart{},
+		func(context.Context, routing.Target) (int32, error) {
+			return 0, nil
+		},
+		nil,
+	)
+	tracker.scaling = newScalingEvents(logr.Discard(), sink, time.Second)
+	target := routing.Target{
+		Service:          "svc",
+		Port:             8080,
+		Deployment:       "depl",
+		HTTPScaledObject: "myapp",
+	}
+
+	// requests that wait on the same scale from zero only
+	// start and complete it once
+	tracker.track(ctx, httptest.NewRecorder(), host, target, time.Now())
+	tracker.track(ctx, httptest.NewRecorder(), host, target, time.Now())
+	tracker.waited(host, target, nil)
+	tracker.waited(host, target, nil)
+	r.Eventually(func() bool {
+		return len(sink.types()) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	tracker.track(ctx, httptest.NewRecorder(), host, target, time.Now())
+	// clients that go away don't end the scale from zero
+	tracker.waited(host, target, fmt.Errorf("waiting (%w)", context.Canceled))
+	tracker.waited(host, target, fmt.Errorf("waiting (%w)", context.DeadlineExceeded))
+	r.Eventually(func() bool {
+		return len(sink.types()) == 4
+	}, time.Second, 10*time.Millisecond)
+	r.ElementsMatch([]string{
+		scalingEventStarted,
+		scalingEventCompleted,
+		scalingEventStarted,
+		scalingEventTimedOut,
+	}, sink.types())
+
+	// trackers without scaling events don't send any
+	tracker.scaling = nil
+	tracker.track(ctx, httptest.NewRecorder(), host, target, time.Now())
+	tracker.waited(host, target, nil)
+	var nilTracker *coldStartTracker
+	nilTracker.waited(host, target, nil)
+}
+
+func TestHTTPScalingEvents(t *testing.T) {
+	r := require.New(t)
+	bodies := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.Equal(cloudEventsContentType, req.Header.Get("Content-Type"))
+		body, err := ioutil.ReadAll(req.Body)
+		r.NoError(err)
+		bodies <- body
+	}))
+	defer srv.Close()
+	sink := &httpScalingEvents{cl: srv.Client(), url: srv.URL, source: "/testing"}
+
+	r.NoError(sink.send(context.Background(), scalingEvent{
+		Type:             scalingEventCompleted,
+		Host:             "myhost.com",
+		HTTPScaledObject: "myapp",
+		DurationMS:       1500,
+	}))
+	event := struct {
+		cloudEvent
+		Data scalingEvent `json:"data"`
+	}{}
+	r.NoError(json.Unmarshal(<-bodies, &event))
+	r.Equal(scalingEventCompleted, event.Type)
+	r.Equal("/testing", event.Source)
+	r.Equal("myhost.com", event.Subject)
+	r.Equal("myapp", event.Data.HTTPScaledObject)
+	r.Equal(1500.0, event.Data.DurationMS)
+}
+
+func TestK8sScalingEvents(t *testing.T) {
+	const ns = "testns"
+	r := require.New(t)
+	ctx := context.Background()
+	cl := fake.NewSimpleClientset()
+	sink := &k8sScalingEvents{cl: cl, ns: ns}
+
+	// the event needs the HTTPScaledObject's name
+	r.Error(sink.send(ctx, scalingEvent{Type: scalingEventStarted, Host: "host"}))
+
+	r.NoError(sink.send(ctx, scalingEvent{
+		Type:             scalingEventTimedOut,
+		Host:             "host",
+		HTTPScaledObject: "myapp",
+		DurationMS:       20000,
+	}))
+	list, err := cl.CoreV1().Events(ns).List(ctx, metav1.ListOptions{})
+	r.NoError(err)
+	r.Len(list.Items, 1)
+	evt := list.Items[0]
+	r.Equal("myapp", evt.InvolvedObject.Name)
+	r.Equal("ColdStartTimedOut", evt.Reason)
+	r.Equal(corev1.EventTypeWarning, evt.Type)
+	r.Contains(evt.Message, "20s")
+}
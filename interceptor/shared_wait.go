@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/kedacore/http-add-on/pkg/routing"
+)
+
+// sharedWait is a wait for a target's workload that's shared by all
+// the requests that are waiting for it at once
+type sharedWait struct {
+	done   chan struct{}
+	err    error
+	cancel context.CancelFunc
+	// waiters is the number of requests that are waiting
+	waiters int
+}
+
+// sharedWaits makes the requests that wait for the same target at the
+// same time share one call to a forwardWaitFunc, so that a burst of
+// requests to a host that's scaling from zero costs one watch, and one
+// goroutine, instead of one of each per request. Requests that are
+// waiting only hold a reference to the shared wait
+type sharedWaits struct {
+	wait  forwardWaitFunc
+	mut   *sync.Mutex
+	waits map[string]*sharedWait
+}
+
+// newSharedForwardWaitFunc returns a forwardWaitFunc that shares calls
+// to wait among the requests that wait for the same target at once
+func newSharedForwardWaitFunc(wait forwardWaitFunc) forwardWaitFunc {
+	s := &sharedWaits{
+		wait:  wait,
+		mut:   new(sync.Mutex),
+		waits: map[string]*sharedWait{},
+	}
+	return s.forwardWait
+}
+
+// sharedWaitKey returns the key of the shared wait for target. Targets
+// with the same workload and Service wait for the same thing
+func sharedWaitKey(target routing.Target) string {
+	return fmt.Sprintf(
+		"%s/%s/%s/%s",
+		target.APIVersion,
+		target.Kind,
+		target.Deployment,
+		target.Service,
+	)
+}
+
+func (s *sharedWaits) forwardWait(ctx context.Context, target routing.Target) error {
+	key := sharedWaitKey(target)
+	s.mut.Lock()
+	w, ok := s.waits[key]
+	if !ok {
+		// the shared wait isn't tied to any request's context, so
+		// that it goes on when the request that started it leaves.
+		// it's cancelled once no request is waiting anymore
+		waitCtx, cancel := context.WithCancel(context.Background())
+		w = &sharedWait{done: make(chan struct{}), cancel: cancel}
+		s.waits[key] = w
+		go func() {
+			err := s.wait(waitCtx, target)
+			s.mut.Lock()
+			if s.waits[key] == w {
+				delete(s.waits, key)
+			}
+			w.err = err
+			s.mut.Unlock()
+			cancel()
+			close(w.done)
+		}()
+	}
+	w.waiters++
+	s.mut.Unlock()
+
+	select {
+	case <-w.done:
+		return w.err
+	case <-ctx.Done():
+		s.mut.Lock()
+		w.waiters--
+		if w.waiters == 0 {
+			w.cancel()
+			if s.waits[key] == w {
+				delete(s.waits, key)
+			}
+		}
+		s.mut.Unlock()
+		return fmt.Errorf(
+			"context marked done while waiting for %s %s (%w)",
+			target.Kind,
+			target.Deployment,
+			ctx.Err(),
+		)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/interceptor/config"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func TestSharedForwardWaitFunc(t *testing.T) {
+	r := require.New(t)
+	var calls int32
+	finish := make(chan struct{})
+	waitFunc := newSharedForwardWaitFunc(func(ctx context.Context, _ routing.Target) error {
+		atomic.AddInt32(&calls, 1)
+		select {
+		case <-finish:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	target := routing.Target{Service: "svc", Port: 8080, Deployment: "depl"}
+
+	// requests that wait for the same target at once share a wait
+	const numWaiters = 100
+	var wg sync.WaitGroup
+	errs := make(chan error, numWaiters)
+	for i := 0; i < numWaiters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- waitFunc(context.Background(), target)
+		}()
+	}
+	r.Eventually(func() bool {
+		return atomic.LoadInt32(&calls) == 1
+	}, time.Second, 10*time.Millisecond)
+	close(finish)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		r.NoError(err)
+	}
+	r.EqualValues(1, atomic.LoadInt32(&calls))
+
+	// once the shared wait is done, the next request starts a new one
+	r.NoError(waitFunc(context.Background(), target))
+	r.EqualValues(2, atomic.LoadInt32(&calls))
+}
+
+func TestSharedForwardWaitFuncCanceled(t *testing.T) {
+	r := require.New(t)
+	waitCanceled := make(chan struct{})
+	started := make(chan struct{}, 2)
+	s := &sharedWaits{
+		wait: func(ctx context.Context, _ routing.Target) error {
+			started <- struct{}{}
+			<-ctx.Done()
+			close(waitCanceled)
+			return ctx.Err()
+		},
+		mut:   new(sync.Mutex),
+		waits: map[string]*sharedWait{},
+	}
+	waitFunc := s.forwardWait
+	target := routing.Target{Service: "svc", Port: 8080, Deployment: "depl"}
+	waiters := func() int {
+		s.mut.Lock()
+		defer s.mut.Unlock()
+		w, ok := s.waits[sharedWaitKey(target)]
+		if !ok {
+			return 0
+		}
+		return w.waiters
+	}
+
+	ctx1, done1 := context.WithCancel(context.Background())
+	ctx2, done2 := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	go func() { errs <- waitFunc(ctx1, target) }()
+	<-started
+	go func() { errs <- waitFunc(ctx2, target) }()
+	r.Eventually(func() bool {
+		return waiters() == 2
+	}, time.Second, time.Millisecond)
+
+	// the shared wait goes on when the request that started it leaves
+	done1()
+	r.True(errors.Is(<-errs, context.Canceled))
+	select {
+	case <-waitCanceled:
+		r.Fail("the shared wait was canceled while a request waits for it")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// and stops when the last one does
+	done2()
+	r.True(errors.Is(<-errs, context.Canceled))
+	select {
+	case <-waitCanceled:
+	case <-time.After(time.Second):
+		r.Fail("the shared wait wasn't canceled when no request waits for it")
+	}
+	r.Len(started, 0)
+	r.Equal(0, waiters())
+}
+
+// broadcastDeploymentCache is a k8s.DeploymentCache that hands out
+// watches the way the informer cache does, with a filtered watch of a
+// shared broadcaster per call, so that watches cost what they would
+// in a cluster
+type broadcastDeploymentCache struct {
+	depl        appsv1.Deployment
+	broadcaster *watch.Broadcaster
+}
+
+func (b *broadcastDeploymentCache) Get(string) (appsv1.Deployment, error) {
+	return b.depl, nil
+}
+
+func (b *broadcastDeploymentCache) Watch(name string) watch.Interface {
+	return watch.Filter(b.broadcaster.Watch(), func(evt watch.Event) (watch.Event, bool) {
+		depl, ok := evt.Object.(*appsv1.Deployment)
+		return evt, ok && depl.Name == name
+	})
+}
+
+// BenchmarkPendingRequests measures the memory that each request
+// holds while it waits for a cold backend, as B/pending, with a wait
+// per request like before waits were shared, and with shared waits.
+// The memory of a pod divided by B/pending is the number of requests
+// that can wait in it at once
+func BenchmarkPendingRequests(b *testing.B) {
+	const (
+		host        = "BenchmarkPendingRequests.testing"
+		numRequests = 2000
+	)
+	for _, bm := range []struct {
+		name string
+		wait func(forwardWaitFunc) forwardWaitFunc
+	}{
+		{name: "per-request", wait: func(wait forwardWaitFunc) forwardWaitFunc { return wait }},
+		{name: "shared", wait: newSharedForwardWaitFunc},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			cache := &broadcastDeploymentCache{
+				depl:        appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "testdepl"}},
+				broadcaster: watch.NewBroadcaster(5, watch.DropIfChannelFull),
+			}
+			defer cache.broadcaster.Shutdown()
+			routingTable := routing.NewTable()
+			routingTable.AddTarget(host, routing.Target{
+				Service:    "nosuchsvc",
+				Port:       9091,
+				Deployment: "testdepl",
+			})
+			// the limiter only counts the pending requests
+			limiter := newPendingLimiter(config.Backpressure{
+				MaxPendingRequests: numRequests,
+				RetryAfter:         time.Second,
+			})
+			timeouts := defaultTimeouts()
+			hdl := newForwardingHandler(
+				logr.Discard(),
+				routingTable,
+				retryDialContextFunc(timeouts, timeouts.DefaultBackoff()),
+				bm.wait(newDeployReplicasForwardWaitFunc(logr.Discard(), cache)),
+				forwardingConfig{
+					waitTimeout:       time.Hour,
+					respHeaderTimeout: timeouts.ResponseHeader,
+					pendingLimit:      limiter,
+				},
+			)
+
+			var total uint64
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				before := heapAndStacks()
+				b.StartTimer()
+				ctx, done := context.WithCancel(context.Background())
+				var wg sync.WaitGroup
+				for j := 0; j < numRequests; j++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						res, req, err := reqAndRes("/")
+						if err != nil {
+							b.Error(err)
+							return
+						}
+						req.Host = host
+						hdl.ServeHTTP(res, req.WithContext(ctx))
+					}()
+				}
+				for atomic.LoadInt64(&limiter.pending) < numRequests {
+					time.Sleep(time.Millisecond)
+				}
+				b.StopTimer()
+				if after := heapAndStacks(); after > before {
+					total += after - before
+				}
+				b.StartTimer()
+				done()
+				wg.Wait()
+			}
+			b.ReportMetric(float64(total)/float64(b.N*numRequests), "B/pending")
+		})
+	}
+}
+
+// heapAndStacks returns the bytes of heap and goroutine stacks in
+// use, after a GC
+func heapAndStacks() uint64 {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapInuse + stats.StackInuse
+}
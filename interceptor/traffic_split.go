@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+
+	"github.com/kedacore/http-add-on/pkg/routing"
+)
+
+type canaryKey struct{}
+
+// splitFunc returns true if a request should go to a canary that
+// gets weight percent of the requests
+type splitFunc func(weight int) bool
+
+// randomSplit sends each request to the canary with a probability
+// of weight percent
+func randomSplit(weight int) bool {
+	return rand.Intn(100) < weight
+}
+
+// routedToCanary returns true if the request that ctx belongs to was
+// routed to its host's canary by trafficSplitMiddleware
+func routedToCanary(ctx context.Context) bool {
+	canary, _ := ctx.Value(canaryKey{}).(bool)
+	return canary
+}
+
+// routedTarget returns the Target that the request that ctx belongs to
+// should be forwarded to, given its host's target
+func routedTarget(ctx context.Context, target routing.Target) routing.Target {
+	if routedToCanary(ctx) {
+		return target.ForCanary()
+	}
+	return target
+}
+
+// queueKey returns the key under which the request that ctx belongs
+// to is counted in the queue
+func queueKey(ctx context.Context, host string) string {
+	if routedToCanary(ctx) {
+		return routing.CanaryQueueKey(host)
+	}
+	return host
+}
+
+// trafficSplitMiddleware decides, for requests to hosts with a canary,
+// whether each request goes to the canary or to the host's main
+// workload. Handlers further down the chain use routedTarget and
+// queueKey to act on that decision, so that the canary's requests are
+// forwarded to it and counted apart from the main workload's
+func trafficSplitMiddleware(
+	routingTable routing.TableReader,
+	split splitFunc,
+	next http.Handler,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// let the next handler deal with requests that have
+		// no host or an unknown one
+		host, err := getHost(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		target, err := lookupTarget(r.Context(), routingTable, host)
+		if err != nil || target.Canary == nil || !split(target.Canary.Weight) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if logEntry := accessLogEntryFromContext(r.Context()); logEntry != nil {
+			logEntry.Canary = true
+		}
+		next.ServeHTTP(
+			w,
+			r.WithContext(context.WithValue(r.Context(), canaryKey{}, true)),
+		)
+	})
+}
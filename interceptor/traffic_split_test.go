@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/queue"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrafficSplitMiddleware(t *testing.T) {
+	const (
+		host         = "TestTrafficSplitMiddleware.testing"
+		noCanaryHost = "nocanary.testing"
+	)
+	r := require.New(t)
+	table := routing.NewTable()
+	target := routing.NewTarget("testsvc", 8080, "testdepl", 100)
+	r.NoError(table.AddTarget(noCanaryHost, target))
+	target.Canary = &routing.CanaryTarget{
+		Service:    "canarysvc",
+		Port:       8081,
+		Deployment: "canarydepl",
+		Weight:     30,
+	}
+	r.NoError(table.AddTarget(host, target))
+
+	q := queue.NewMemory()
+	toCanary := false
+	splitWeights := []int{}
+	split := func(weight int) bool {
+		splitWeights = append(splitWeights, weight)
+		return toCanary
+	}
+	// the service and the queue counts that the
+	// backend saw for the last request
+	var gotService string
+	var gotCounts map[string]int
+	hdl := trafficSplitMiddleware(
+		table,
+		split,
+		countMiddleware(
+			logr.Discard(),
+			q,
+			table,
+			http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				host, err := getHost(req)
+				r.NoError(err)
+				target, err := table.Lookup(host)
+				r.NoError(err)
+				gotService = routedTarget(req.Context(), target).Service
+				cur, err := q.Current()
+				r.NoError(err)
+				gotCounts = cur.Counts
+			}),
+		),
+	)
+	do := func(host string) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Host = host
+		hdl.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	do(host)
+	r.Equal("testsvc", gotService)
+	r.Equal(1, gotCounts[host])
+	r.Equal(0, gotCounts[routing.CanaryQueueKey(host)])
+
+	toCanary = true
+	do(host)
+	r.Equal("canarysvc", gotService)
+	r.Equal(0, gotCounts[host])
+	r.Equal(1, gotCounts[routing.CanaryQueueKey(host)])
+
+	// hosts without a canary are never split
+	do(noCanaryHost)
+	r.Equal("testsvc", gotService)
+	r.Equal(1, gotCounts[noCanaryHost])
+	r.Equal([]int{30, 30}, splitWeights)
+}
+
+func TestRandomSplit(t *testing.T) {
+	r := require.New(t)
+	for i := 0; i < 100; i++ {
+		r.False(randomSplit(0))
+		r.True(randomSplit(100))
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	kedanet "github.com/kedacore/http-add-on/pkg/net"
+	"github.com/kedacore/http-add-on/pkg/routing"
+)
+
+// transportSettings are the tunable settings of a backend's transport
+type transportSettings struct {
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	idleConnTimeout     time.Duration
+	// dialTimeout is the maximum time to establish a connection,
+	// including retries. 0 means there's no limit other than the
+	// retries' own
+	dialTimeout           time.Duration
+	forceAttemptHTTP2     bool
+	tlsHandshakeTimeout   time.Duration
+	expectContinueTimeout time.Duration
+	respHeaderTimeout     time.Duration
+	// proxyProtocol is the version of the PROXY protocol header that's
+	// sent on each new connection. Empty means none is
+	proxyProtocol string
+	// revision is the revision whose pods new connections are dialed
+	// to. The zero value means any pod. A backend whose revision is
+	// flipped gets a new transport, so that requests stop reusing the
+	// connections to the old revision's pods
+	revision routing.RevisionPin
+	// upstreamTLS is how connections are secured. The zero value
+	// means they aren't
+	upstreamTLS upstreamTLSSettings
+}
+
+// upstreamTLSSettings are the TLS settings of a backend's transport
+type upstreamTLSSettings struct {
+	enabled            bool
+	serverName         string
+	insecureSkipVerify bool
+	// caSecret is the name of the Secret with the CA bundle that the
+	// backend's certificate is verified against. Empty means the
+	// system roots are used
+	caSecret string
+	// caVersion is the resource version of caSecret, so that a backend
+	// whose CA bundle is rotated gets a new transport
+	caVersion string
+}
+
+// pooledTransport is a backend's transport, along with the
+// settings that it was created with
+type pooledTransport struct {
+	transport *http.Transport
+	settings  transportSettings
+}
+
+// transportPool holds one shared http.Transport per backend, so that
+// keep-alive connections to each backend are reused across requests,
+// and each backend's connections can be tuned separately.
+//
+// Transports are never removed from the pool, but their idle connections
+// are closed after their idle timeout, so a transport for a backend that
+// no longer exists costs little
+type transportPool struct {
+	dialCtxFunc kedanet.DialContextFunc
+	fwdCfg      forwardingConfig
+	mut         *sync.Mutex
+	transports  map[string]pooledTransport
+}
+
+func newTransportPool(
+	dialCtxFunc kedanet.DialContextFunc,
+	fwdCfg forwardingConfig,
+) *transportPool {
+	return &transportPool{
+		dialCtxFunc: dialCtxFunc,
+		fwdCfg:      fwdCfg,
+		mut:         new(sync.Mutex),
+		transports:  map[string]pooledTransport{},
+	}
+}
+
+// setConfig makes p use fwdCfg from now on. Each backend's transport
+// is replaced the next time it's used, if its settings changed.
+// Requests that are using the old transport aren't interrupted
+func (p *transportPool) setConfig(fwdCfg forwardingConfig) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	p.fwdCfg = fwdCfg
+}
+
+// settingsFor returns the transportSettings for target, which are
+// p's defaults overridden by target's TransportPolicy, its response
+// header timeout and its upstream TLS policy. Callers must hold p.mut
+func (p *transportPool) settingsFor(target routing.Target) transportSettings {
+	ret := transportSettings{
+		maxIdleConns:          p.fwdCfg.maxIdleConns,
+		maxIdleConnsPerHost:   p.fwdCfg.maxIdleConnsPerHost,
+		idleConnTimeout:       p.fwdCfg.idleConnTimeout,
+		forceAttemptHTTP2:     p.fwdCfg.forceAttemptHTTP2,
+		tlsHandshakeTimeout:   p.fwdCfg.tlsHandshakeTimeout,
+		expectContinueTimeout: p.fwdCfg.expectContinueTimeout,
+		respHeaderTimeout:     p.fwdCfg.respHeaderTimeout,
+		proxyProtocol:         p.fwdCfg.proxyProtocolUpstream,
+	}
+	if target.Revision != nil {
+		ret.revision = *target.Revision
+	}
+	if upstreamTLS := target.UpstreamTLS; upstreamTLS != nil {
+		ret.upstreamTLS = upstreamTLSSettings{
+			enabled:            true,
+			serverName:         upstreamTLS.ServerName,
+			insecureSkipVerify: upstreamTLS.InsecureSkipVerify,
+			caSecret:           upstreamTLS.CASecretName,
+		}
+		if upstreamTLS.CASecretName != "" {
+			ret.upstreamTLS.caVersion = p.fwdCfg.upstreamCAs.version(upstreamTLS.CASecretName)
+		}
+	}
+	if timeouts := target.Timeouts; timeouts != nil && timeouts.ResponseHeaderMS > 0 {
+		ret.respHeaderTimeout = time.Duration(timeouts.ResponseHeaderMS) * time.Millisecond
+	}
+	policy := target.Transport
+	if policy == nil {
+		return ret
+	}
+	if policy.MaxIdleConns > 0 {
+		ret.maxIdleConns = policy.MaxIdleConns
+		ret.maxIdleConnsPerHost = policy.MaxIdleConns
+	}
+	if policy.IdleConnTimeoutSeconds > 0 {
+		ret.idleConnTimeout = time.Duration(policy.IdleConnTimeoutSeconds) * time.Second
+	}
+	if policy.DialTimeoutMS > 0 {
+		ret.dialTimeout = time.Duration(policy.DialTimeoutMS) * time.Millisecond
+	}
+	return ret
+}
+
+// forTarget returns the shared transport for target's backend. If
+// target's settings changed since the transport was created, the old
+// transport's idle connections are closed and a new one takes its place
+func (p *transportPool) forTarget(target routing.Target) *http.Transport {
+	key := fmt.Sprintf("%s:%d", target.Service, target.Port)
+	if target.URL != "" {
+		key = target.URL
+	}
+
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	settings := p.settingsFor(target)
+	existing, ok := p.transports[key]
+	if ok && existing.settings == settings {
+		return existing.transport
+	}
+	if ok {
+		existing.transport.CloseIdleConnections()
+	}
+	transport := p.newTransport(settings)
+	p.transports[key] = pooledTransport{
+		transport: transport,
+		settings:  settings,
+	}
+	return transport
+}
+
+func (p *transportPool) newTransport(settings transportSettings) *http.Transport {
+	dialCtxFunc := p.dialCtxFunc
+	if settings.dialTimeout > 0 {
+		dialCtxFunc = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			ctx, done := context.WithTimeout(ctx, settings.dialTimeout)
+			defer done()
+			return p.dialCtxFunc(ctx, network, addr)
+		}
+	}
+	if settings.revision != (routing.RevisionPin{}) {
+		pin := settings.revision
+		dialRevision := dialCtxFunc
+		dialCtxFunc = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialRevision(withRevision(ctx, &pin), network, addr)
+		}
+	}
+	if settings.proxyProtocol != "" {
+		dialCtxFunc = kedanet.DialContextWithProxyHeader(
+			dialCtxFunc,
+			settings.proxyProtocol,
+		)
+	}
+	var tlsCfg *tls.Config
+	if settings.upstreamTLS.enabled {
+		tlsCfg = &tls.Config{
+			ServerName:         settings.upstreamTLS.serverName,
+			InsecureSkipVerify: settings.upstreamTLS.insecureSkipVerify,
+		}
+		if settings.upstreamTLS.caSecret != "" {
+			tlsCfg.RootCAs = p.fwdCfg.upstreamCAs.certPool(settings.upstreamTLS.caSecret)
+		}
+	}
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialCtxFunc,
+		ForceAttemptHTTP2:     settings.forceAttemptHTTP2,
+		MaxIdleConns:          settings.maxIdleConns,
+		MaxIdleConnsPerHost:   settings.maxIdleConnsPerHost,
+		IdleConnTimeout:       settings.idleConnTimeout,
+		TLSHandshakeTimeout:   settings.tlsHandshakeTimeout,
+		ExpectContinueTimeout: settings.expectContinueTimeout,
+		ResponseHeaderTimeout: settings.respHeaderTimeout,
+		TLSClientConfig:       tlsCfg,
+		// each connection's PROXY header names a single client, so
+		// connections can't be shared between clients
+		DisableKeepAlives: settings.proxyProtocol != "",
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	kedanet "github.com/kedacore/http-add-on/pkg/net"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestTransportPoolForTarget(t *testing.T) {
+	r := require.New(t)
+	pool := newTransportPool(
+		(&net.Dialer{}).DialContext,
+		forwardingConfig{
+			maxIdleConns:        100,
+			maxIdleConnsPerHost: 10,
+			idleConnTimeout:     time.Minute,
+		},
+	)
+	target := routing.NewTarget("testsvc", 8080, "testdepl", 100)
+
+	// the same backend always gets the same transport
+	transport := pool.forTarget(target)
+	r.Same(transport, pool.forTarget(target))
+	r.Equal(100, transport.MaxIdleConns)
+	r.Equal(10, transport.MaxIdleConnsPerHost)
+	r.Equal(time.Minute, transport.IdleConnTimeout)
+
+	// other backends get their own
+	other := routing.NewTarget("othersvc", 8080, "otherdepl", 100)
+	r.NotSame(transport, pool.forTarget(other))
+
+	// new settings replace the backend's transport
+	target.Transport = &routing.TransportPolicy{
+		MaxIdleConns:           50,
+		IdleConnTimeoutSeconds: 5,
+	}
+	tuned := pool.forTarget(target)
+	r.NotSame(transport, tuned)
+	r.Same(tuned, pool.forTarget(target))
+	r.Equal(50, tuned.MaxIdleConns)
+	r.Equal(50, tuned.MaxIdleConnsPerHost)
+	r.Equal(5*time.Second, tuned.IdleConnTimeout)
+}
+
+func TestTransportPoolDialTimeout(t *testing.T) {
+	r := require.New(t)
+	// the dialer never connects, so every dial lasts as
+	// long as its context
+	pool := newTransportPool(
+		func(ctx context.Context, network, addr string) (net.Conn, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+		forwardingConfig{},
+	)
+	target := routing.NewTarget("testsvc", 8080, "testdepl", 100)
+	target.Transport = &routing.TransportPolicy{DialTimeoutMS: 50}
+	transport := pool.forTarget(target)
+
+	start := time.Now()
+	_, err := transport.DialContext(context.Background(), "tcp", "testsvc:8080")
+	r.Error(err)
+	r.Less(time.Since(start), time.Second)
+}
+
+// flipping a backend's revision replaces its transport, whose dials
+// are pinned to the new revision
+func TestTransportPoolRevision(t *testing.T) {
+	r := require.New(t)
+	dialed := make(chan *routing.RevisionPin, 1)
+	pool := newTransportPool(
+		func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed <- revisionFromContext(ctx)
+			return nil, fmt.Errorf("not dialing")
+		},
+		forwardingConfig{},
+	)
+	target := routing.NewTarget("testsvc", 8080, "testdepl", 100)
+	unpinned := pool.forTarget(target)
+
+	target.Revision = &routing.RevisionPin{Label: "version", Value: "blue"}
+	blue := pool.forTarget(target)
+	r.NotSame(unpinned, blue)
+	r.Same(blue, pool.forTarget(target))
+	_, err := blue.DialContext(context.Background(), "tcp", "testsvc:8080")
+	r.Error(err)
+	r.Equal(target.Revision, <-dialed)
+
+	target.Revision = &routing.RevisionPin{Label: "version", Value: "green"}
+	green := pool.forTarget(target)
+	r.NotSame(blue, green)
+	_, err = green.DialContext(context.Background(), "tcp", "testsvc:8080")
+	r.Error(err)
+	r.Equal(target.Revision, <-dialed)
+}
+
+// with PROXY protocol emission on, each new connection to the backend
+// starts with a header naming the request's client
+func TestTransportPoolProxyProtocol(t *testing.T) {
+	r := require.New(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	r.NoError(err)
+	ln = kedanet.NewProxyProtocolListener(ln, time.Second, nil)
+	remoteAddrs := make(chan string, 1)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		remoteAddrs <- req.RemoteAddr
+	})}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	pool := newTransportPool(
+		(&net.Dialer{}).DialContext,
+		forwardingConfig{proxyProtocolUpstream: kedanet.ProxyProtocolV2},
+	)
+	transport := pool.forTarget(routing.NewTarget("testsvc", 8080, "testdepl", 100))
+	r.True(transport.DisableKeepAlives)
+
+	ctx := context.WithValue(
+		context.Background(),
+		http.LocalAddrContextKey,
+		&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 8080},
+	)
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+ln.Addr().String(), nil)
+	r.NoError(err)
+	req.RemoteAddr = "203.0.113.7:51234"
+	req = req.WithContext(withClientAddrs(req))
+	res, err := transport.RoundTrip(req)
+	r.NoError(err)
+	res.Body.Close()
+	r.Equal("203.0.113.7:51234", <-remoteAddrs)
+}
+
+// backends with a certificate from a private CA are verified against
+// the CA bundle in the Secret that their target names
+func TestTransportPoolUpstreamTLS(t *testing.T) {
+	const ns = "testns"
+	r := require.New(t)
+	ctx, done := context.WithCancel(context.Background())
+	defer done()
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(200)
+	}))
+	defer backend.Close()
+	caPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: backend.Certificate().Raw,
+	})
+	cl := k8sfake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: "backend-ca"},
+		Data:       map[string][]byte{upstreamCASecretKey: caPEM},
+	})
+	cas := newUpstreamCAs(logr.Discard(), cl, ns, time.Minute)
+	go cas.start(ctx)
+	r.Eventually(cas.hasSynced, time.Second, 10*time.Millisecond)
+
+	pool := newTransportPool(
+		(&net.Dialer{}).DialContext,
+		forwardingConfig{upstreamCAs: cas},
+	)
+	get := func(target routing.Target) error {
+		req, err := http.NewRequest("GET", backend.URL, nil)
+		r.NoError(err)
+		res, err := pool.forTarget(target).RoundTrip(req)
+		if err != nil {
+			return err
+		}
+		return res.Body.Close()
+	}
+
+	// the httptest certificate is for example.com
+	target := routing.NewTarget("testsvc", 8443, "testdepl", 100)
+	target.UpstreamTLS = &routing.UpstreamTLSPolicy{
+		CASecretName: "backend-ca",
+		ServerName:   "example.com",
+	}
+	r.NoError(get(target))
+
+	// the system roots don't trust the private CA
+	target.UpstreamTLS = &routing.UpstreamTLSPolicy{ServerName: "example.com"}
+	r.Error(get(target))
+
+	// neither does a missing CA bundle
+	target.UpstreamTLS = &routing.UpstreamTLSPolicy{
+		CASecretName: "missing",
+		ServerName:   "example.com",
+	}
+	r.Error(get(target))
+
+	// the certificate isn't for the default server name
+	target.UpstreamTLS = &routing.UpstreamTLSPolicy{
+		CASecretName: "backend-ca",
+		ServerName:   "testsvc",
+	}
+	r.Error(get(target))
+
+	target.UpstreamTLS = &routing.UpstreamTLSPolicy{InsecureSkipVerify: true}
+	r.NoError(get(target))
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kedacore/http-add-on/pkg/routing"
+)
+
+type hijackerKey struct{}
+
+// hijackerMiddleware keeps the ResponseWriter of each CONNECT request
+// in its context, so that the forwarding handler can take over the
+// client's connection for a tunnel. The middlewares in between wrap the
+// ResponseWriter in ones that can't be hijacked
+func hijackerMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hj, ok := w.(http.Hijacker); ok && r.Method == http.MethodConnect {
+			r = r.WithContext(context.WithValue(r.Context(), hijackerKey{}, hj))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// connectPort returns the port that the CONNECT request r asks to
+// tunnel to
+func connectPort(r *http.Request) (int, error) {
+	_, portStr, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(portStr)
+}
+
+// tunnel serves the CONNECT request r by opening a raw TCP tunnel
+// between its client and port on target's host. It blocks until both
+// sides are done with the tunnel, so that the count middleware and
+// the connection tracker count it as long as it's open. established
+// is called once the client was told that the tunnel is open
+func (f *forwardingHandler) tunnel(
+	w http.ResponseWriter,
+	r *http.Request,
+	target routing.Target,
+	port int,
+	established func(),
+) {
+	reqID := requestIDFromContext(r.Context())
+	hj, ok := r.Context().Value(hijackerKey{}).(http.Hijacker)
+	if !ok {
+		// HTTP/2 connections can't be taken over
+		w.WriteHeader(501)
+		w.Write([]byte("tunnels aren't supported on this connection"))
+		return
+	}
+	svcURL, err := target.ServiceURL()
+	if err != nil {
+		f.lggr.Error(err, "tunneling failed", "requestID", reqID)
+		w.WriteHeader(500)
+		w.Write([]byte("error getting backend service URL"))
+		return
+	}
+	addr := net.JoinHostPort(svcURL.Hostname(), strconv.Itoa(port))
+	backend, err := f.dial(withRevision(r.Context(), target.Revision), "tcp", addr)
+	if err != nil {
+		f.lggr.Error(err, "dialing tunnel backend", "requestID", reqID, "address", addr)
+		markDropped(r.Context(), dropReasonUpstream5xx)
+		w.WriteHeader(502)
+		w.Write([]byte(fmt.Sprintf("error dialing backend (%s)", err)))
+		return
+	}
+	defer backend.Close()
+	client, clientBuf, err := hj.Hijack()
+	if err != nil {
+		f.lggr.Error(err, "hijacking tunnel connection", "requestID", reqID)
+		return
+	}
+	defer client.Close()
+	// the server's timeouts are for requests. Tunnels stay open as
+	// long as they're used
+	client.SetDeadline(time.Time{})
+	if _, err := io.WriteString(
+		client,
+		"HTTP/1.1 200 Connection established\r\n\r\n",
+	); err != nil {
+		return
+	}
+	established()
+
+	done := make(chan struct{}, 2)
+	// bytes that the client sent right after the request may
+	// already be in the server's buffer, so they're read from there
+	go copyTunnel(backend, clientBuf.Reader, done)
+	go copyTunnel(client, backend, done)
+	<-done
+	<-done
+}
+
+// copyTunnel copies src to dst, one direction of a tunnel, until src
+// is done, then sends on done. If src ended cleanly, dst is closed for
+// writing, so that the other side sees the end of the stream and the
+// other direction goes on. Otherwise dst is closed, which ends the
+// other direction too
+func copyTunnel(dst net.Conn, src io.Reader, done chan<- struct{}) {
+	buf := proxyBuffers.Get()
+	_, err := io.CopyBuffer(dst, src, buf)
+	proxyBuffers.Put(buf)
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok && err == nil {
+		cw.CloseWrite()
+	} else {
+		dst.Close()
+	}
+	done <- struct{}{}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/interceptor/config"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"github.com/stretchr/testify/require"
+)
+
+// startEchoServer starts a TCP server that writes back everything
+// that it reads
+func startEchoServer(t *testing.T) (net.Listener, int) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l, l.Addr().(*net.TCPAddr).Port
+}
+
+// connect sends a CONNECT request for authority over a new connection
+// to addr, and returns the connection and the response
+func connect(t *testing.T, addr, authority string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	_, err = fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", authority, authority)
+	require.NoError(t, err)
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	require.NoError(t, err)
+	return conn, br, res
+}
+
+func TestTunnel(t *testing.T) {
+	const (
+		host     = "TestTunnel.testing"
+		noTunnel = "TestTunnelNoTunnel.testing"
+	)
+	r := require.New(t)
+	echo, echoPort := startEchoServer(t)
+	defer echo.Close()
+
+	routingTable := routing.NewTable()
+	r.NoError(routingTable.AddTarget(host, routing.Target{
+		Service:    "127.0.0.1",
+		Port:       echoPort,
+		Deployment: "testdepl",
+		Tunnel:     &routing.TunnelPolicy{},
+	}))
+	r.NoError(routingTable.AddTarget(noTunnel, routing.Target{
+		Service:    "127.0.0.1",
+		Port:       echoPort,
+		Deployment: "testdepl",
+	}))
+	waited := make(chan string, 10)
+	waitFunc := func(_ context.Context, target routing.Target) error {
+		waited <- target.Deployment
+		return nil
+	}
+	timeouts := defaultTimeouts()
+	srv := httptest.NewServer(hijackerMiddleware(pinRouteMiddleware(
+		routingTable,
+		newForwardingHandler(
+			logr.Discard(),
+			routingTable,
+			retryDialContextFunc(timeouts, timeouts.DefaultBackoff()),
+			waitFunc,
+			forwardingConfig{
+				waitTimeout:       timeouts.DeploymentReplicas,
+				respHeaderTimeout: timeouts.ResponseHeader,
+			},
+		),
+	)))
+	defer srv.Close()
+	srvAddr := srv.Listener.Addr().String()
+
+	// the tunnel waits for the backend, then passes bytes both ways
+	conn, br, res := connect(t, srvAddr, net.JoinHostPort(host, strconv.Itoa(echoPort)))
+	defer conn.Close()
+	r.Equal(200, res.StatusCode)
+	r.Equal("testdepl", <-waited)
+	_, err := conn.Write([]byte("ping"))
+	r.NoError(err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(br, buf)
+	r.NoError(err)
+	r.Equal("ping", string(buf))
+
+	// closing the client's side of the tunnel closes the backend's
+	r.NoError(conn.(*net.TCPConn).CloseWrite())
+	_, err = br.ReadByte()
+	r.Equal(io.EOF, err)
+
+	// only the target's port is allowed by default
+	conn, _, res = connect(t, srvAddr, net.JoinHostPort(host, "5432"))
+	defer conn.Close()
+	r.Equal(403, res.StatusCode)
+
+	// targets without a tunnel turn CONNECT requests away
+	conn, _, res = connect(t, srvAddr, net.JoinHostPort(noTunnel, strconv.Itoa(echoPort)))
+	defer conn.Close()
+	r.Equal(405, res.StatusCode)
+
+	// neither woke the backend up
+	r.Empty(waited)
+}
+
+// open tunnels hold on to a worker of the fair scheduler only until
+// they're established, so they can't starve ordinary requests
+func TestTunnelReleasesWorker(t *testing.T) {
+	const (
+		tunnelHost = "TestTunnelReleasesWorker.tunnel.testing"
+		host       = "TestTunnelReleasesWorker.testing"
+		numTunnels = 3
+	)
+	r := require.New(t)
+	echo, echoPort := startEchoServer(t)
+	defer echo.Close()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer backend.Close()
+	backendPort := backend.Listener.Addr().(*net.TCPAddr).Port
+
+	routingTable := routing.NewTable()
+	r.NoError(routingTable.AddTarget(tunnelHost, routing.Target{
+		Service:    "127.0.0.1",
+		Port:       echoPort,
+		Deployment: "testdepl",
+		Tunnel:     &routing.TunnelPolicy{},
+	}))
+	r.NoError(routingTable.AddTarget(host, routing.Target{
+		Service: "127.0.0.1",
+		Port:    backendPort,
+	}))
+	scheduler := newFairScheduler(config.FairScheduler{
+		Workers:             1,
+		MaxHostSharePercent: 100,
+	})
+	timeouts := defaultTimeouts()
+	srv := httptest.NewServer(hijackerMiddleware(pinRouteMiddleware(
+		routingTable,
+		newForwardingHandler(
+			logr.Discard(),
+			routingTable,
+			retryDialContextFunc(timeouts, timeouts.DefaultBackoff()),
+			func(context.Context, routing.Target) error { return nil },
+			forwardingConfig{
+				waitTimeout:       timeouts.DeploymentReplicas,
+				respHeaderTimeout: timeouts.ResponseHeader,
+				scheduler:         scheduler,
+			},
+		),
+	)))
+	defer srv.Close()
+
+	// with a single worker, each tunnel after the first only opens
+	// if the ones before it gave the worker back
+	for i := 0; i < numTunnels; i++ {
+		conn, _, res := connect(
+			t,
+			srv.Listener.Addr().String(),
+			net.JoinHostPort(tunnelHost, strconv.Itoa(echoPort)),
+		)
+		defer conn.Close()
+		r.Equal(200, res.StatusCode)
+	}
+	r.Equal(
+		fairSchedulerStats{Scheduled: numTunnels},
+		fairSchedulerStatsFor(scheduler, tunnelHost),
+	)
+
+	// and while they're all open, ordinary requests still get through
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	r.NoError(err)
+	req.Host = host
+	ctx, done := context.WithTimeout(context.Background(), 5*time.Second)
+	defer done()
+	res, err := srv.Client().Do(req.WithContext(ctx))
+	r.NoError(err)
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	r.NoError(err)
+	r.Equal(200, res.StatusCode)
+	r.Equal("hello", string(body))
+	r.Equal(0, fairSchedulerStatsFor(scheduler, host).Running)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listerv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// upstreamCASecretKey is the key of the PEM CA bundle in an upstream
+// CA Secret
+const upstreamCASecretKey = "ca.crt"
+
+// upstreamCAs holds the CA bundles that the certificates of HTTPS
+// backends are verified against, from the Secrets in a namespace, kept
+// up to date by a shared informer. Call start to run the informer.
+//
+// A nil *upstreamCAs is valid, and has no CA bundles, so that backends
+// that name one fail verification rather than trust the system roots
+type upstreamCAs struct {
+	lggr     logr.Logger
+	factory  informers.SharedInformerFactory
+	informer cache.SharedIndexInformer
+	secrets  listerv1.SecretNamespaceLister
+}
+
+// newUpstreamCAs creates a new upstreamCAs for the Secrets in
+// namespace ns
+func newUpstreamCAs(
+	lggr logr.Logger,
+	cl kubernetes.Interface,
+	ns string,
+	resyncEvery time.Duration,
+) *upstreamCAs {
+	factory := informers.NewSharedInformerFactoryWithOptions(
+		cl,
+		resyncEvery,
+		informers.WithNamespace(ns),
+	)
+	secretInformer := factory.Core().V1().Secrets()
+	return &upstreamCAs{
+		lggr:     lggr.WithName("upstreamCAs"),
+		factory:  factory,
+		informer: secretInformer.Informer(),
+		secrets:  secretInformer.Lister().Secrets(ns),
+	}
+}
+
+// start runs the informer, waits for its cache to sync, then
+// blocks until ctx is done
+func (u *upstreamCAs) start(ctx context.Context) error {
+	u.factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), u.informer.HasSynced) {
+		return errors.New("upstream CA Secrets cache never synced")
+	}
+	<-ctx.Done()
+	return errors.Wrap(ctx.Err(), "context is done")
+}
+
+// hasSynced returns true once the informer's cache has synced
+func (u *upstreamCAs) hasSynced() bool {
+	return u.informer.HasSynced()
+}
+
+// version returns the resource version of the Secret called name, so
+// that callers can tell when its CA bundle changed. It's empty if
+// there's no such Secret
+func (u *upstreamCAs) version(name string) string {
+	if u == nil {
+		return ""
+	}
+	secret, err := u.secrets.Get(name)
+	if err != nil {
+		return ""
+	}
+	return secret.ResourceVersion
+}
+
+// certPool returns the CA bundle in the Secret called name. If the
+// Secret is missing or has no valid certificates, the returned pool is
+// empty, so that every certificate fails verification against it
+func (u *upstreamCAs) certPool(name string) *x509.CertPool {
+	ret := x509.NewCertPool()
+	if u == nil {
+		return ret
+	}
+	lggr := u.lggr.WithValues("secret", name)
+	secret, err := u.secrets.Get(name)
+	if err != nil {
+		lggr.Error(err, "getting upstream CA Secret")
+		return ret
+	}
+	if !ret.AppendCertsFromPEM(secret.Data[upstreamCASecretKey]) {
+		lggr.Error(
+			errors.New("upstream CA Secret has no PEM certificates"),
+			"invalid upstream CA Secret",
+			"key",
+			upstreamCASecretKey,
+		)
+	}
+	return ret
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kedacore/http-add-on/pkg/routing"
+)
+
+// waitingRoomPage is the built-in page that browsers get while their
+// backend starts. Its only argument is the number of seconds after
+// which it reloads
+const waitingRoomPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="%[1]d">
+<title>Starting up</title>
+</head>
+<body>
+<h1>Starting up</h1>
+<p>This site is starting up. This page will reload in %[1]d seconds.</p>
+</body>
+</html>
+`
+
+// waitingRoom is when a request gets a waiting page instead of
+// waiting for its backend, and how often the page reloads
+type waitingRoom struct {
+	threshold time.Duration
+	refresh   int
+}
+
+// waitingRoomFor returns the waiting room for r, which is going to
+// target, or nil if r doesn't get one. Only requests from browsers,
+// which are GET and HEAD requests that accept HTML, get one, since
+// other clients can't follow the page's refresh
+func waitingRoomFor(r *http.Request, target routing.Target) *waitingRoom {
+	policy := target.WaitingRoom
+	if policy == nil {
+		return nil
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return nil
+	}
+	if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		return nil
+	}
+	return &waitingRoom{
+		threshold: time.Duration(policy.ThresholdMS) * time.Millisecond,
+		refresh:   policy.RefreshSeconds,
+	}
+}
+
+// write writes the waiting page to w, with the headers that make
+// browsers reload it and caches not store it. The page for the
+// waitingRoom class in target's error pages, or the default ones,
+// replaces the built-in page
+func (room *waitingRoom) write(
+	w http.ResponseWriter,
+	pages *errorPages,
+	target *routing.Target,
+) {
+	refresh := strconv.Itoa(room.refresh)
+	w.Header().Set("Refresh", refresh)
+	w.Header().Set("Retry-After", refresh)
+	w.Header().Set("Cache-Control", "no-store")
+	if page, ok := pages.lookup(errorClassWaitingRoom, target, 503); ok {
+		page.write(w)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(503)
+	fmt.Fprintf(w, waitingRoomPage, room.refresh)
+}
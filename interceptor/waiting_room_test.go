@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"github.com/stretchr/testify/require"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWaitingRoomFor(t *testing.T) {
+	r := require.New(t)
+	target := routing.Target{
+		WaitingRoom: &routing.WaitingRoomPolicy{
+			ThresholdMS:    1500,
+			RefreshSeconds: 3,
+		},
+	}
+	_, req, err := reqAndRes("/")
+	r.NoError(err)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+	room := waitingRoomFor(req, target)
+	r.NotNil(room)
+	r.Equal(1500*time.Millisecond, room.threshold)
+	r.Equal(3, room.refresh)
+
+	// clients that don't accept HTML don't get one
+	req.Header.Set("Accept", "application/json")
+	r.Nil(waitingRoomFor(req, target))
+
+	// neither do requests that a refresh can't repeat
+	req.Header.Set("Accept", "text/html")
+	req.Method = http.MethodPost
+	r.Nil(waitingRoomFor(req, target))
+
+	// nor requests to hosts without a waiting room
+	req.Method = http.MethodGet
+	r.Nil(waitingRoomFor(req, routing.Target{}))
+}
+
+func TestWaitingRoomPage(t *testing.T) {
+	const (
+		ns           = "testns"
+		host         = "TestWaitingRoomPage.testing"
+		hostWithPage = "page.TestWaitingRoomPage.testing"
+	)
+	r := require.New(t)
+	ctx, done := context.WithCancel(context.Background())
+	defer done()
+	cl := k8sfake.NewSimpleClientset(
+		newErrorPagesConfigMap(ns, "apppages", map[string]string{
+			errorClassWaitingRoom: "<html><body>hang on</body></html>",
+		}),
+	)
+	pages := newErrorPages(logr.Discard(), cl, ns, "", time.Minute)
+	go pages.start(ctx)
+	r.Eventually(pages.hasSynced, time.Second, 10*time.Millisecond)
+
+	// the backend never becomes ready
+	waitFunc := func(ctx context.Context, _ routing.Target) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	routingTable := routing.NewTable()
+	target := routing.Target{
+		Service:    "testsvc",
+		Port:       8080,
+		Deployment: "testdepl",
+		WaitingRoom: &routing.WaitingRoomPolicy{
+			ThresholdMS:    10,
+			RefreshSeconds: 2,
+		},
+	}
+	r.NoError(routingTable.AddTarget(host, target))
+	timeouts := defaultTimeouts()
+	hdl := newForwardingHandler(
+		logr.Discard(),
+		routingTable,
+		retryDialContextFunc(timeouts, timeouts.DefaultBackoff()),
+		waitFunc,
+		forwardingConfig{
+			waitTimeout:       time.Minute,
+			respHeaderTimeout: timeouts.ResponseHeader,
+			errorPages:        pages,
+		},
+	)
+
+	// browsers get the built-in page once the threshold passes,
+	// rather than waiting for the whole cold start
+	res, req, err := reqAndRes("/testfwd")
+	r.NoError(err)
+	req.Host = host
+	req.Header.Set("Accept", "text/html")
+	start := time.Now()
+	hdl.ServeHTTP(res, req)
+	r.Less(time.Since(start), 10*time.Second)
+	r.Equal(503, res.Code)
+	r.Equal("2", res.Header().Get("Refresh"))
+	r.Equal("2", res.Header().Get("Retry-After"))
+	r.Equal("no-store", res.Header().Get("Cache-Control"))
+	r.Contains(res.Body.String(), `<meta http-equiv="refresh" content="2">`)
+
+	// the host's own page replaces the built-in one
+	target.ErrorPagesConfigMap = "apppages"
+	r.NoError(routingTable.AddTarget(hostWithPage, target))
+	res, req, err = reqAndRes("/testfwd")
+	r.NoError(err)
+	req.Host = hostWithPage
+	req.Header.Set("Accept", "text/html")
+	hdl.ServeHTTP(res, req)
+	r.Equal(503, res.Code)
+	r.Equal("2", res.Header().Get("Refresh"))
+	r.Equal("<html><body>hang on</body></html>", res.Body.String())
+}
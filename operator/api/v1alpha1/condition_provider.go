@@ -2,15 +2,15 @@ package v1alpha1
 
 import (
 	"context"
-	"time"
 
 	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 // SaveStatus will trigger an object update to save the current status
-// conditions
+// conditions, along with the generation they were observed at
 func (httpso *HTTPScaledObject) SaveStatus(
 	ctx context.Context,
 	logger logr.Logger,
@@ -18,6 +18,8 @@ func (httpso *HTTPScaledObject) SaveStatus(
 ) {
 	logger.Info("Updating status on HTTPScaledObject", "resource version", httpso.ResourceVersion)
 
+	httpso.Status.ObservedGeneration = httpso.Generation
+	httpso.Status.TargetWorkload = httpso.Spec.ScaleTargetRef.Reference()
 	err := cl.Status().Update(ctx, httpso)
 	if err != nil {
 		logger.Error(err, "failed to update status on HTTPScaledObject", "httpso", httpso)
@@ -26,29 +28,46 @@ func (httpso *HTTPScaledObject) SaveStatus(
 	}
 }
 
-// AddCondition adds a new condition to the HTTPScaledObject
-func (httpso *HTTPScaledObject) AddCondition(condition HTTPScaledObjectCondition) *HTTPScaledObject {
-	httpso.Status.Conditions = append(httpso.Status.Conditions, condition)
+// SetCondition adds the condition of type condType to the HTTPScaledObject,
+// or updates it if it's already there. The condition's last transition
+// time only changes if its status changes
+func (httpso *HTTPScaledObject) SetCondition(
+	condType HTTPScaledObjectConditionType,
+	status metav1.ConditionStatus,
+	reason HTTPScaledObjectConditionReason,
+	message string,
+) *HTTPScaledObject {
+	meta.SetStatusCondition(&httpso.Status.Conditions, metav1.Condition{
+		Type:               string(condType),
+		Status:             status,
+		ObservedGeneration: httpso.Generation,
+		Reason:             string(reason),
+		Message:            message,
+	})
 	return httpso
 }
 
-// CreateCondition initializes a new status condition
-func CreateCondition(
-	condType HTTPScaledObjectCreationStatus,
-	status metav1.ConditionStatus,
-	reason HTTPScaledObjectConditionReason,
-) *HTTPScaledObjectCondition {
-	cond := HTTPScaledObjectCondition{
-		Timestamp: time.Now().Format(time.RFC3339),
-		Type:      condType,
-		Status:    status,
-		Reason:    reason,
-	}
-	return &cond
+// RemoveCondition removes the condition of type condType from the
+// HTTPScaledObject, if it has one
+func (httpso *HTTPScaledObject) RemoveCondition(
+	condType HTTPScaledObjectConditionType,
+) *HTTPScaledObject {
+	meta.RemoveStatusCondition(&httpso.Status.Conditions, string(condType))
+	return httpso
+}
+
+// GetCondition returns the condition of type condType,
+// or nil if the HTTPScaledObject doesn't have one
+func (httpso *HTTPScaledObject) GetCondition(
+	condType HTTPScaledObjectConditionType,
+) *metav1.Condition {
+	return meta.FindStatusCondition(httpso.Status.Conditions, string(condType))
 }
 
-// SetMessage sets the optional reason for the condition
-func (c *HTTPScaledObjectCondition) SetMessage(message string) *HTTPScaledObjectCondition {
-	c.Message = message
-	return c
+// IsConditionTrue returns whether the HTTPScaledObject has
+// a condition of type condType with a True status
+func (httpso *HTTPScaledObject) IsConditionTrue(
+	condType HTTPScaledObjectConditionType,
+) bool {
+	return meta.IsStatusConditionTrue(httpso.Status.Conditions, string(condType))
 }
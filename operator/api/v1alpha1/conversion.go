@@ -0,0 +1,12 @@
+package v1alpha1
+
+// AdditionalHostsAnnotation holds the hosts of a newer version of an
+// HTTPScaledObject that don't fit in the spec's single host, as a
+// comma-separated list, so that they survive conversion to this
+// version and back
+const AdditionalHostsAnnotation = "http.keda.sh/additional-hosts"
+
+// Hub marks this version as the one that all the other versions of
+// HTTPScaledObject convert to and from. It's also the version that's
+// stored
+func (*HTTPScaledObject) Hub() {}
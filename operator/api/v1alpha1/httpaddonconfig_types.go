@@ -0,0 +1,125 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// HTTPAddonConfigName is the name of the only HTTPAddonConfig that the
+// operator uses. Any others are ignored
+const HTTPAddonConfigName = "default"
+
+// InterceptorAutoscaling is the type of the condition that says
+// whether the interceptor's ScaledObject is in place
+const InterceptorAutoscaling = "InterceptorAutoscaling"
+
+const (
+	// DefaultInterceptorMinReplicas is the minimum number of
+	// interceptor replicas if the HTTPAddonConfig doesn't set one
+	DefaultInterceptorMinReplicas = 1
+	// DefaultInterceptorMaxReplicas is the maximum number of
+	// interceptor replicas if the HTTPAddonConfig doesn't set one
+	DefaultInterceptorMaxReplicas = 10
+	// DefaultInterceptorTargetPendingRequests is the target number of
+	// in-flight requests per interceptor replica if the HTTPAddonConfig
+	// sets no target at all
+	DefaultInterceptorTargetPendingRequests = 200
+)
+
+// HTTPAddonConfigSpec defines the desired state of the HTTP add-on as
+// a whole, rather than that of a single app
+type HTTPAddonConfigSpec struct {
+	// (optional) How the operator autoscales the interceptor. If it's not set, the interceptor isn't autoscaled
+	//+optional
+	InterceptorScaling *InterceptorScaling `json:"interceptorScaling,omitempty"`
+}
+
+// InterceptorScaling configures the ScaledObject that the operator
+// creates for the interceptor's Deployment, so that the interceptor
+// fleet scales with the traffic to all the apps behind it
+type InterceptorScaling struct {
+	// Minimum number of interceptor replicas (Default 1)
+	//+optional
+	//+kubebuilder:validation:Minimum=1
+	MinReplicas int32 `json:"minReplicas,omitempty" description:"Minimum number of interceptor replicas (Default 1)"`
+	// Maximum number of interceptor replicas (Default 10)
+	//+optional
+	//+kubebuilder:validation:Minimum=1
+	MaxReplicas int32 `json:"maxReplicas,omitempty" description:"Maximum number of interceptor replicas (Default 10)"`
+	// (optional) Target average CPU utilization of the interceptor's pods, as a percentage of their CPU requests
+	//+optional
+	//+kubebuilder:validation:Minimum=0
+	TargetCPUUtilization int32 `json:"targetCPUUtilization,omitempty" description:"Target average CPU utilization of the interceptor's pods, as a percentage of their CPU requests"`
+	// (optional) Target number of in-flight requests, across all hosts, per interceptor replica (Default 200 if no target is set)
+	//+optional
+	//+kubebuilder:validation:Minimum=0
+	TargetPendingRequests int32 `json:"targetPendingRequests,omitempty" description:"Target number of in-flight requests, across all hosts, per interceptor replica (Default 200 if no target is set)"`
+}
+
+// Replicas returns the minimum and maximum number of interceptor
+// replicas, with defaults filled in
+func (s *InterceptorScaling) Replicas() (int32, int32) {
+	min, max := s.MinReplicas, s.MaxReplicas
+	if min == 0 {
+		min = DefaultInterceptorMinReplicas
+	}
+	if max == 0 {
+		max = DefaultInterceptorMaxReplicas
+	}
+	if max < min {
+		max = min
+	}
+	return min, max
+}
+
+// PendingRequestsTarget returns the target number of in-flight
+// requests per interceptor replica, or 0 if the interceptor is only
+// scaled on CPU
+func (s *InterceptorScaling) PendingRequestsTarget() int32 {
+	if s.TargetPendingRequests == 0 && s.TargetCPUUtilization == 0 {
+		return DefaultInterceptorTargetPendingRequests
+	}
+	return s.TargetPendingRequests
+}
+
+// HTTPAddonConfigStatus defines the observed state of HTTPAddonConfig
+type HTTPAddonConfigStatus struct {
+	// The most recent generation of the HTTPAddonConfig that the operator observed
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty" description:"The most recent generation of the HTTPAddonConfig that the operator observed"`
+	// The latest observations of the HTTPAddonConfig's state
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" description:"The latest observations of the HTTPAddonConfig's state"`
+}
+
+// +kubebuilder:object:root=true
+
+// HTTPAddonConfig configures the HTTP add-on as a whole. The operator
+// only uses the one named HTTPAddonConfigName
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:path=httpaddonconfigs,scope=Cluster
+// +kubebuilder:printcolumn:name="InterceptorAutoscaling",type="string",JSONPath=".status.conditions[?(@.type==\"InterceptorAutoscaling\")].status"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+type HTTPAddonConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   HTTPAddonConfigSpec   `json:"spec,omitempty"`
+	Status HTTPAddonConfigStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// HTTPAddonConfigList contains a list of HTTPAddonConfig
+type HTTPAddonConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []HTTPAddonConfig `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&HTTPAddonConfig{}, &HTTPAddonConfigList{})
+}
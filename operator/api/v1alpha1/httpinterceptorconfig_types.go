@@ -0,0 +1,159 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// HTTPInterceptorConfigName is the name of the only
+// HTTPInterceptorConfig that the operator uses. Any others are ignored
+const HTTPInterceptorConfigName = "default"
+
+const (
+	// InterceptorConfigRendered is the type of the condition that
+	// says whether the interceptor's ConfigMap and Secret are up to
+	// date with the HTTPInterceptorConfig
+	InterceptorConfigRendered = "ConfigRendered"
+	// InterceptorConfigRolledOut is the type of the condition that
+	// says whether every interceptor replica runs with the rendered
+	// configuration
+	InterceptorConfigRolledOut = "RolledOut"
+)
+
+// HTTPInterceptorConfigSpec defines the desired tuning of the
+// interceptor. Settings that aren't set are left to the interceptor's
+// environment variables, or its defaults
+type HTTPInterceptorConfigSpec struct {
+	// (optional) Timeouts for the interceptor's connections and requests to the backends
+	//+optional
+	Timeouts *InterceptorTimeouts `json:"timeouts,omitempty"`
+	// (optional) Limits on the requests that the interceptor handles
+	//+optional
+	Limits *InterceptorLimits `json:"limits,omitempty"`
+	// (optional) TLS for the interceptor's proxy server
+	//+optional
+	TLS *InterceptorTLS `json:"tls,omitempty"`
+	// (optional) The interceptor's logging
+	//+optional
+	Logging *InterceptorLogging `json:"logging,omitempty"`
+}
+
+// InterceptorTimeouts are the timeouts that the interceptor uses when
+// it forwards requests
+type InterceptorTimeouts struct {
+	// (optional) Maximum time to establish a connection to a backend, in milliseconds
+	//+optional
+	//+kubebuilder:validation:Minimum=1
+	ConnectMS int32 `json:"connectMS,omitempty" description:"Maximum time to establish a connection to a backend, in milliseconds"`
+	// (optional) Interval between keep-alive probes on connections to the backends, in milliseconds
+	//+optional
+	//+kubebuilder:validation:Minimum=1
+	KeepAliveMS int32 `json:"keepAliveMS,omitempty" description:"Interval between keep-alive probes on connections to the backends, in milliseconds"`
+	// (optional) Maximum time to wait for a backend's response headers, in milliseconds
+	//+optional
+	//+kubebuilder:validation:Minimum=1
+	ResponseHeaderMS int32 `json:"responseHeaderMS,omitempty" description:"Maximum time to wait for a backend's response headers, in milliseconds"`
+	// (optional) Maximum time that a request waits for its backend to have replicas, in milliseconds
+	//+optional
+	//+kubebuilder:validation:Minimum=1
+	WaitForReplicasMS int32 `json:"waitForReplicasMS,omitempty" description:"Maximum time that a request waits for its backend to have replicas, in milliseconds"`
+	// (optional) Time after which an idle connection to a backend is closed, in seconds
+	//+optional
+	//+kubebuilder:validation:Minimum=1
+	IdleConnSeconds int32 `json:"idleConnSeconds,omitempty" description:"Time after which an idle connection to a backend is closed, in seconds"`
+}
+
+// InterceptorLimits are the limits on the requests that the
+// interceptor handles, across all hosts
+type InterceptorLimits struct {
+	// (optional) Maximum size of request bodies, in bytes, for hosts that don't set their own
+	//+optional
+	//+kubebuilder:validation:Minimum=1
+	MaxRequestBodyBytes int64 `json:"maxRequestBodyBytes,omitempty" description:"Maximum size of request bodies, in bytes, for hosts that don't set their own"`
+	// (optional) Maximum size of response bodies, in bytes, for hosts that don't set their own
+	//+optional
+	//+kubebuilder:validation:Minimum=1
+	MaxResponseBodyBytes int64 `json:"maxResponseBodyBytes,omitempty" description:"Maximum size of response bodies, in bytes, for hosts that don't set their own"`
+	// (optional) Maximum number of requests that wait for their backends at once
+	//+optional
+	//+kubebuilder:validation:Minimum=1
+	MaxPendingRequests int32 `json:"maxPendingRequests,omitempty" description:"Maximum number of requests that wait for their backends at once"`
+	// (optional) Maximum number of idle connections to each backend
+	//+optional
+	//+kubebuilder:validation:Minimum=1
+	MaxIdleConnsPerHost int32 `json:"maxIdleConnsPerHost,omitempty" description:"Maximum number of idle connections to each backend"`
+	// (optional) Number of requests that wait for each host's backend at once, past which clients are told when to retry
+	//+optional
+	//+kubebuilder:validation:Minimum=1
+	SoftMaxPendingRequestsPerHost int32 `json:"softMaxPendingRequestsPerHost,omitempty" description:"Number of requests that wait for each host's backend at once, past which clients are told when to retry"`
+}
+
+// InterceptorTLS makes the interceptor's proxy server serve TLS with
+// the certificate in a Secret, which the operator copies into the
+// interceptor's own Secret
+type InterceptorTLS struct {
+	// The Secret, in the interceptor's namespace, with the certificate and key in tls.crt and tls.key, and optionally a client CA bundle in ca.crt
+	//+kubebuilder:validation:MinLength=1
+	SecretName string `json:"secretName" description:"The Secret, in the interceptor's namespace, with the certificate and key in tls.crt and tls.key, and optionally a client CA bundle in ca.crt"`
+	// (optional) Whether clients must present a certificate when the Secret has a client CA bundle (Default require)
+	//+optional
+	//+kubebuilder:validation:Enum=require;optional
+	ClientAuth string `json:"clientAuth,omitempty" description:"Whether clients must present a certificate when the Secret has a client CA bundle (Default require)"`
+}
+
+// InterceptorLogging is the interceptor's logging
+type InterceptorLogging struct {
+	// The lowest level of the messages that are logged
+	//+kubebuilder:validation:Enum=debug;info;warn;error
+	Level string `json:"level" description:"The lowest level of the messages that are logged"`
+}
+
+// HTTPInterceptorConfigStatus defines the observed state of
+// HTTPInterceptorConfig
+type HTTPInterceptorConfigStatus struct {
+	// The most recent generation of the HTTPInterceptorConfig that the operator observed
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty" description:"The most recent generation of the HTTPInterceptorConfig that the operator observed"`
+	// The hash of the configuration that was last rendered for the interceptor
+	// +optional
+	ConfigHash string `json:"configHash,omitempty" description:"The hash of the configuration that was last rendered for the interceptor"`
+	// The number of interceptor replicas that run with the rendered configuration
+	// +optional
+	UpdatedReplicas int32 `json:"updatedReplicas,omitempty" description:"The number of interceptor replicas that run with the rendered configuration"`
+	// The latest observations of the HTTPInterceptorConfig's state
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" description:"The latest observations of the HTTPInterceptorConfig's state"`
+}
+
+// +kubebuilder:object:root=true
+
+// HTTPInterceptorConfig tunes the interceptor. The operator only uses
+// the one named HTTPInterceptorConfigName
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:path=httpinterceptorconfigs,scope=Cluster
+// +kubebuilder:printcolumn:name="ConfigRendered",type="string",JSONPath=".status.conditions[?(@.type==\"ConfigRendered\")].status"
+// +kubebuilder:printcolumn:name="RolledOut",type="string",JSONPath=".status.conditions[?(@.type==\"RolledOut\")].status"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+type HTTPInterceptorConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   HTTPInterceptorConfigSpec   `json:"spec,omitempty"`
+	Status HTTPInterceptorConfigStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// HTTPInterceptorConfigList contains a list of HTTPInterceptorConfig
+type HTTPInterceptorConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []HTTPInterceptorConfig `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&HTTPInterceptorConfig{}, &HTTPInterceptorConfigList{})
+}
@@ -17,16 +17,42 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	autoscalingv2beta2 "k8s.io/api/autoscaling/v2beta2"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
-// HTTPScaledObjectCreationStatus describes the creation status
-// of the scaler's additional resources such as Services, Ingresses and Deployments
-// +kubebuilder:validation:Enum=Created;Error;Pending;Unknown;Terminating;Terminated;Ready
-type HTTPScaledObjectCreationStatus string
+// HTTPScaledObjectConditionType is the type of a status condition
+// on an HTTPScaledObject
+type HTTPScaledObjectConditionType string
+
+const (
+	// Ready indicates that all of the other conditions are true, and
+	// requests to the HTTPScaledObject's host are routed and scaled
+	Ready HTTPScaledObjectConditionType = "Ready"
+	// RoutingConfigured indicates that the host is in the routing table
+	RoutingConfigured HTTPScaledObjectConditionType = "RoutingConfigured"
+	// ScaledObjectCreated indicates that the KEDA ScaledObject
+	// for the target workload was created
+	ScaledObjectCreated HTTPScaledObjectConditionType = "ScaledObjectCreated"
+	// TargetWorkloadFound indicates that the workload
+	// in the scaleTargetRef exists
+	TargetWorkloadFound HTTPScaledObjectConditionType = "TargetWorkloadFound"
+	// ResourcesRemoved indicates, on an HTTPScaledObject that's being
+	// deleted, that its routing table entry and ScaledObjects are gone
+	ResourcesRemoved HTTPScaledObjectConditionType = "ResourcesRemoved"
+	// DryRun indicates that the operator only computes the changes that
+	// it would make for the HTTPScaledObject, without applying them.
+	// Its message lists those changes
+	DryRun HTTPScaledObjectConditionType = "DryRun"
+)
 
 // HTTPScaledObjectConditionReason describes the reason why the condition transitioned
-// +kubebuilder:validation:Enum=ErrorCreatingAppScaledObject;AppScaledObjectCreated;TerminatingResources;AppScaledObjectTerminated;AppScaledObjectTerminationError;PendingCreation;HTTPScaledObjectIsReady;
 type HTTPScaledObjectConditionReason string
 
 const (
@@ -37,55 +63,55 @@ const (
 	AppScaledObjectTerminationError HTTPScaledObjectConditionReason = "AppScaledObjectTerminationError"
 	PendingCreation                 HTTPScaledObjectConditionReason = "PendingCreation"
 	HTTPScaledObjectIsReady         HTTPScaledObjectConditionReason = "HTTPScaledObjectIsReady"
+	HTTPScaledObjectIsNotReady      HTTPScaledObjectConditionReason = "HTTPScaledObjectIsNotReady"
+	RoutingTableUpdated             HTTPScaledObjectConditionReason = "RoutingTableUpdated"
+	ErrorUpdatingRoutingTable       HTTPScaledObjectConditionReason = "ErrorUpdatingRoutingTable"
+	TargetDeploymentFound           HTTPScaledObjectConditionReason = "TargetDeploymentFound"
+	TargetDeploymentNotFound        HTTPScaledObjectConditionReason = "TargetDeploymentNotFound"
+	ErrorGettingTargetDeployment    HTTPScaledObjectConditionReason = "ErrorGettingTargetDeployment"
+	TargetWorkloadForbidden         HTTPScaledObjectConditionReason = "TargetWorkloadForbidden"
+	InvalidPausedReplicas           HTTPScaledObjectConditionReason = "InvalidPausedReplicas"
+	ExternalBackend                 HTTPScaledObjectConditionReason = "ExternalBackend"
+	ResourcesRemaining              HTTPScaledObjectConditionReason = "ResourcesRemaining"
+	AllResourcesRemoved             HTTPScaledObjectConditionReason = "AllResourcesRemoved"
+	ErrorVerifyingRemoval           HTTPScaledObjectConditionReason = "ErrorVerifyingRemoval"
+	DryRunChanges                   HTTPScaledObjectConditionReason = "DryRunChanges"
+	DryRunNoChanges                 HTTPScaledObjectConditionReason = "DryRunNoChanges"
+	InvalidRevision                 HTTPScaledObjectConditionReason = "InvalidRevision"
 )
 
-const (
-	// Created indicates the resource has been created
-	Created HTTPScaledObjectCreationStatus = "Created"
-	// Terminated indicates the resource has been terminated
-	Terminated HTTPScaledObjectCreationStatus = "Terminated"
-	// Error indicates the resource had an error
-	Error HTTPScaledObjectCreationStatus = "Error"
-	// Pending indicates the resource hasn't been created
-	Pending HTTPScaledObjectCreationStatus = "Pending"
-	// Terminating indicates that the resource is marked for deletion but hasn't
-	// been deleted yet
-	Terminating HTTPScaledObjectCreationStatus = "Terminating"
-	// Unknown indicates the status is unavailable
-	Unknown HTTPScaledObjectCreationStatus = "Unknown"
-	// Ready indicates the object is fully created
-	Ready HTTPScaledObjectCreationStatus = "Ready"
-)
-
-// Condition to store the condition state
-type HTTPScaledObjectCondition struct {
-	// Timestamp of the condition
-	// +optional
-	Timestamp string `json:"timestamp" description:"Timestamp of this condition"`
-	// Type of condition
-	// +required
-	Type HTTPScaledObjectCreationStatus `json:"type" description:"type of status condition"`
-	// Status of the condition, one of True, False, Unknown.
-	// +required
-	Status metav1.ConditionStatus `json:"status" description:"status of the condition, one of True, False, Unknown"`
-	// The reason for the condition's last transition.
-	// +optional
-	Reason HTTPScaledObjectConditionReason `json:"reason,omitempty" description:"one-word CamelCase reason for the condition's last transition"`
-	// A human readable message indicating details about the transition.
-	// +optional
-	Message string `json:"message,omitempty" description:"human-readable message indicating details about last transition"`
-}
-
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 // Important: Run "make" to regenerate code after modifying this file
 
 type ReplicaStruct struct {
 	// Minimum amount of replicas to have in the deployment (Default 0)
+	//+kubebuilder:default=0
+	//+kubebuilder:validation:Minimum=0
 	Min int32 `json:"min,omitempty" description:"Minimum amount of replicas to have in the deployment (Default 0)"`
 	// Maximum amount of replicas to have in the deployment (Default 100)
+	//+kubebuilder:default=100
+	//+kubebuilder:validation:Minimum=0
 	Max int32 `json:"max,omitempty" description:"Maximum amount of replicas to have in the deployment (Default 100)"`
 }
 
+// DefaultMaxReplicas is the maximum number of replicas of the workload
+// when the ReplicaStruct doesn't set one
+const DefaultMaxReplicas int32 = 100
+
+// Bounds returns the minimum and maximum number of replicas of the
+// workload, with the defaults filled in. The maximum is never below
+// the minimum
+func (r ReplicaStruct) Bounds() (int32, int32) {
+	min, max := r.Min, r.Max
+	if max == 0 {
+		max = DefaultMaxReplicas
+	}
+	if max < min {
+		max = min
+	}
+	return min, max
+}
+
 // HTTPScaledObjectSpec defines the desired state of HTTPScaledObject
 type HTTPScaledObjectSpec struct {
 	// The host to route. All requests with this host in the "Host"
@@ -97,25 +123,742 @@ type HTTPScaledObjectSpec struct {
 	ScaleTargetRef *ScaleTargetRef `json:"scaleTargetRef"`
 	// (optional) Replica information
 	//+optional
+	//+kubebuilder:default={}
 	Replicas ReplicaStruct `json:"replicas,omitempty"`
 	//(optional) Target metric value
 	TargetPendingRequests int32 `json:"targetPendingRequests,omitempty" description:"The target metric value for the HPA (Default 100)"`
+	// (optional) Policy for retrying requests that fail to reach the backend
+	//+optional
+	RetryPolicy *RetryPolicy `json:"retryPolicy,omitempty"`
+	// (optional) Limits on the sizes of request and response bodies
+	//+optional
+	BodyLimits *BodyLimits `json:"bodyLimits,omitempty"`
+	// (optional) Caching of responses to GET and HEAD requests in the interceptor
+	//+optional
+	ResponseCache *ResponseCache `json:"responseCache,omitempty"`
+	// (optional) A second workload that gets a share of the requests to the host, for canary rollouts
+	//+optional
+	Canary *Canary `json:"canary,omitempty"`
+	// (optional) Tuning for the connections that the interceptor keeps open to the backend
+	//+optional
+	Transport *Transport `json:"transport,omitempty"`
+	// (optional) Advanced options for the KEDA ScaledObject, and the HPA that it creates, that scale the workload
+	//+optional
+	Advanced *AdvancedConfig `json:"advanced,omitempty"`
+	// (optional) Custom responses for requests that the interceptor can't forward to the backend
+	//+optional
+	ErrorPages *ErrorPages `json:"errorPages,omitempty"`
+	// (optional) The metric to scale the workload on, either requests, activeConnections or requestsAwaitingResponse (Default requests)
+	//+optional
+	//+kubebuilder:default=requests
+	//+kubebuilder:validation:Enum=requests;activeConnections;requestsAwaitingResponse
+	ScalingMetric ScalingMetric `json:"scalingMetric,omitempty" description:"The metric to scale the workload on, either requests, activeConnections or requestsAwaitingResponse (Default requests)"`
+	// (optional) A second service that gets copies of a percentage of the requests, whose responses are discarded
+	//+optional
+	Mirror *Mirror `json:"mirror,omitempty"`
+	// (optional) Seconds without requests after which the host is reported as inactive, so its workload can scale to zero (Default 0, as soon as there are no requests)
+	//+optional
+	//+kubebuilder:validation:Minimum=0
+	ScaledownPeriod *int32 `json:"scaledownPeriod,omitempty" description:"Seconds without requests after which the host is reported as inactive (Default 0)"`
+	// (optional) How the interceptor authenticates requests before forwarding them. Unauthenticated requests are rejected, and never count toward scaling
+	//+optional
+	Auth *Auth `json:"auth,omitempty"`
+	// (optional) Limit on the number of requests that each interceptor forwards to the backend at once. Requests past it wait in a queue, and count toward scaling while they do
+	//+optional
+	Concurrency *Concurrency `json:"concurrency,omitempty"`
+	// (optional) Page that browsers get, instead of waiting, when the backend takes longer than a threshold to start. The page reloads itself until the backend is ready
+	//+optional
+	WaitingRoom *WaitingRoom `json:"waitingRoom,omitempty"`
+	// (optional) Requests, like health checks from load balancers, that are forwarded to the backend but don't count toward scaling
+	//+optional
+	Probes *Probes `json:"probes,omitempty"`
+	// (optional) Only accept requests from clients with a verified certificate that has one of the allowed SANs. The interceptor must verify client certificates
+	//+optional
+	ClientCertificate *ClientCertificate `json:"clientCertificate,omitempty"`
+	// (optional) Exponentially weighted moving average that the scaler reports instead of the raw counts, so that spikes don't make the replicas oscillate
+	//+optional
+	Smoothing *Smoothing `json:"smoothing,omitempty"`
+	// (optional) Let clients open raw TCP tunnels to the backend with HTTP CONNECT
+	//+optional
+	Tunnel *Tunnel `json:"tunnel,omitempty"`
+	// (optional) Headers that the interceptor sets on and removes from requests, and the Host header that it sends, when it forwards them to the backend
+	//+optional
+	RequestHeaders *RequestHeaders `json:"requestHeaders,omitempty"`
+	// (optional) How long the backend may take to respond to each request before the interceptor gives up on it with a 504
+	//+optional
+	Timeouts *Timeouts `json:"timeouts,omitempty"`
+	// (optional) Client IP addresses that may, and may not, send requests to the backend
+	//+optional
+	IPFilter *IPFilter `json:"ipFilter,omitempty"`
+	// (optional) How the workload's metric is compared with its target, either AverageValue, which targets the requests per replica, or Value, which targets the requests to all of them (Default AverageValue)
+	//+optional
+	//+kubebuilder:default=AverageValue
+	//+kubebuilder:validation:Enum=AverageValue;Value
+	ScalingBehavior ScalingBehavior `json:"scalingBehavior,omitempty" description:"How the workload's metric is compared with its target, either AverageValue, which targets the requests per replica, or Value, which targets the requests to all of them (Default AverageValue)"`
+	// (optional) Headers that the interceptor sets on and removes from the responses that it sends back for the backend, like CORS defaults
+	//+optional
+	ResponseHeaders *ResponseHeaders `json:"responseHeaders,omitempty"`
+	// (optional) HTTP methods of the requests that count toward scaling, like POST and PUT. Requests with other methods are forwarded to the backend but not counted. Empty counts every request
+	//+optional
+	CountedMethods []string `json:"countedMethods,omitempty" description:"HTTP methods of the requests that count toward scaling, like POST and PUT. Requests with other methods are forwarded to the backend but not counted. Empty counts every request"`
+	// (optional) How the interceptor connects to the backend over TLS, for backends that only serve HTTPS
+	//+optional
+	UpstreamTLS *UpstreamTLS `json:"upstreamTLS,omitempty"`
 }
 
-// ScaleTargetRef contains all the details about an HTTP application to scale and route to
-type ScaleTargetRef struct {
-	// The name of the deployment to scale according to HTTP traffic
-	Deployment string `json:"deployment"`
-	// The name of the service to route to
+// Timeouts are the latency budget of the requests that the interceptor
+// forwards to an HTTPScaledObject's backend. Requests that go over it
+// get a 504 response, rather than waiting until the client gives up.
+// The time that a request waits for the backend to scale up from zero
+// doesn't count toward it
+type Timeouts struct {
+	// (optional) Maximum time to wait for the backend's response headers, in milliseconds. Defaults to the interceptor's
+	//+optional
+	//+kubebuilder:validation:Minimum=1
+	ResponseHeaderMS int32 `json:"responseHeaderMS,omitempty" description:"Maximum time to wait for the backend's response headers, in milliseconds. Defaults to the interceptor's"`
+	// (optional) Maximum time for the backend's whole response, including retries and the body, in milliseconds. Not set means there's no limit
+	//+optional
+	//+kubebuilder:validation:Minimum=1
+	ResponseMS int32 `json:"responseMS,omitempty" description:"Maximum time for the backend's whole response, including retries and the body, in milliseconds. Not set means there's no limit"`
+}
+
+// IPFilter restricts which clients may send requests to an
+// HTTPScaledObject's backend, by IP address. Clients in Deny are
+// always rejected, and if Allow isn't empty, clients that aren't in it
+// are rejected too. Rejected requests get a 403 response, and aren't
+// counted toward scaling
+type IPFilter struct {
+	// (optional) CIDRs or IP addresses of the clients that may send requests. Empty means every client that isn't denied
+	//+optional
+	Allow []string `json:"allow,omitempty" description:"CIDRs or IP addresses of the clients that may send requests. Empty means every client that isn't denied"`
+	// (optional) CIDRs or IP addresses of the clients that may not send requests, even if they're allowed
+	//+optional
+	Deny []string `json:"deny,omitempty" description:"CIDRs or IP addresses of the clients that may not send requests, even if they're allowed"`
+}
+
+// RequestHeaders rewrites the requests that the interceptor forwards to
+// an HTTPScaledObject's backend. They're rewritten after they were
+// routed, so the rewrite doesn't change which HTTPScaledObject a
+// request goes to. Headers in Remove are removed before the ones in
+// Set are set
+type RequestHeaders struct {
+	// (optional) Headers to set on forwarded requests, replacing any values that the client sent
+	//+optional
+	Set map[string]string `json:"set,omitempty" description:"Headers to set on forwarded requests, replacing any values that the client sent"`
+	// (optional) Headers to remove from forwarded requests
+	//+optional
+	Remove []string `json:"remove,omitempty" description:"Headers to remove from forwarded requests"`
+	// (optional) The Host header of forwarded requests, like the Service's DNS name. Defaults to the Service's name and port
+	//+optional
+	Host string `json:"host,omitempty" description:"The Host header of forwarded requests, like the Service's DNS name. Defaults to the Service's name and port"`
+}
+
+// ResponseHeaders rewrites the responses that the interceptor sends
+// back for an HTTPScaledObject's backend, including the ones that it
+// sends itself when the backend fails or times out. Headers in Remove
+// are removed first, then the ones in Set are set, and then the ones
+// in Defaults are set if the response doesn't have them
+type ResponseHeaders struct {
+	// (optional) Headers to set on responses, replacing any values that the backend sent
+	//+optional
+	Set map[string]string `json:"set,omitempty" description:"Headers to set on responses, replacing any values that the backend sent"`
+	// (optional) Headers to set on responses that the backend didn't set itself
+	//+optional
+	Defaults map[string]string `json:"defaults,omitempty" description:"Headers to set on responses that the backend didn't set itself"`
+	// (optional) Headers to remove from responses, like the ones that give away the backend's software
+	//+optional
+	Remove []string `json:"remove,omitempty" description:"Headers to remove from responses, like the ones that give away the backend's software"`
+}
+
+// Tunnel lets clients open raw TCP tunnels to an HTTPScaledObject's
+// backend through the interceptor with HTTP CONNECT, for apps that
+// don't speak HTTP, like databases. The CONNECT request's authority is
+// the HTTPScaledObject's host and the port to tunnel to. Each open
+// tunnel is counted like a request that's in flight, so the backend
+// isn't scaled to zero while a tunnel is open
+type Tunnel struct {
+	// (optional) The ports of the Service that clients may tunnel to. Defaults to the port in scaleTargetRef
+	//+optional
+	Ports []int32 `json:"ports,omitempty" description:"The ports of the Service that clients may tunnel to"`
+}
+
+// Smoothing makes the scaler report an exponentially weighted moving
+// average of an HTTPScaledObject's counts to KEDA, instead of the raw
+// counts. Each time KEDA asks for the metric, the newest count gets
+// FactorPercent percent of the weight, and the previous average gets
+// the rest. Whether the workload is active, and so scaling from and
+// to zero, is still decided on the raw counts
+type Smoothing struct {
+	// The weight, in percent, of the newest count in the average. Lower values smooth more. 100 turns smoothing off
+	//+kubebuilder:validation:Minimum=1
+	//+kubebuilder:validation:Maximum=100
+	FactorPercent int32 `json:"factorPercent" description:"The weight, in percent, of the newest count in the average. Lower values smooth more"`
+}
+
+// ClientCertificate restricts an HTTPScaledObject's host to clients
+// that present a certificate, over mutual TLS to the interceptor, that
+// has at least one of AllowedSANs as a DNS name, URI or email address.
+// The certificate is verified against the interceptor's client CA
+// bundle, so this only works if the interceptor serves TLS and
+// verifies client certificates
+type ClientCertificate struct {
+	// The DNS names, URIs, like SPIFFE IDs, and email addresses that client certificates may have
+	//+kubebuilder:validation:MinItems=1
+	AllowedSANs []string `json:"allowedSANs" description:"The DNS names, URIs and email addresses that client certificates may have"`
+}
+
+// Probes picks out the requests to an HTTPScaledObject's host that are
+// forwarded as usual, but aren't counted, so that health checks from
+// load balancers don't scale the backend up or keep it from scaling
+// to zero. A request is a probe if its path or its User-Agent header
+// matches
+type Probes struct {
+	// (optional) Paths of probe requests, like /healthz. A path that ends with * matches every path that starts with the rest of it
+	//+optional
+	Paths []string `json:"paths,omitempty" description:"Paths of probe requests, like /healthz. A path that ends with * matches every path that starts with the rest of it"`
+	// (optional) Prefixes of the User-Agent headers of probe requests, like kube-probe/ or ELB-HealthChecker/
+	//+optional
+	UserAgents []string `json:"userAgents,omitempty" description:"Prefixes of the User-Agent headers of probe requests"`
+}
+
+// WaitingRoom configures the page that the interceptor sends to
+// browsers whose requests wait for a cold backend for longer than
+// ThresholdMS, instead of holding their connections open until the
+// backend is ready. The page has a Refresh header, so browsers retry
+// the request every RefreshSeconds. Only GET and HEAD requests that
+// accept text/html get it. The page can be replaced with a
+// waitingRoom page in the HTTPScaledObject's error pages
+type WaitingRoom struct {
+	// Milliseconds that a browser's request waits for the backend before it gets the waiting page
+	//+kubebuilder:validation:Minimum=0
+	ThresholdMS int32 `json:"thresholdMS" description:"Milliseconds that a browser's request waits for the backend before it gets the waiting page"`
+	// (optional) Seconds after which the waiting page reloads (Default 5)
+	//+optional
+	//+kubebuilder:default=5
+	//+kubebuilder:validation:Minimum=1
+	RefreshSeconds int32 `json:"refreshSeconds,omitempty" description:"Seconds after which the waiting page reloads (Default 5)"`
+}
+
+// Concurrency limits the number of requests to an HTTPScaledObject's
+// host that each interceptor replica forwards to the backend at once,
+// to protect backends that can only serve a few at a time. Requests
+// past the limit wait in a queue, in the order they arrived, and get a
+// 503 response if the queue is full or they wait too long
+type Concurrency struct {
+	// Maximum number of requests that each interceptor forwards to the backend at once. 0 means no limit
+	//+kubebuilder:validation:Minimum=0
+	MaxInFlight int32 `json:"maxInFlight" description:"Maximum number of requests that each interceptor forwards to the backend at once. 0 means no limit"`
+	// (optional) Maximum number of requests that wait for a request in flight to finish. 0 rejects requests past the limit right away (Default is the interceptor's KEDA_HTTP_CONCURRENCY_MAX_QUEUED)
+	//+optional
+	//+kubebuilder:validation:Minimum=0
+	MaxQueued *int32 `json:"maxQueued,omitempty" description:"Maximum number of requests that wait for a request in flight to finish. 0 rejects requests past the limit right away"`
+	// (optional) Maximum time that a request waits in the queue, in milliseconds (Default is the interceptor's KEDA_HTTP_CONCURRENCY_QUEUE_TIMEOUT)
+	//+optional
+	//+kubebuilder:validation:Minimum=0
+	QueueTimeoutMS int32 `json:"queueTimeoutMS,omitempty" description:"Maximum time that a request waits in the queue, in milliseconds"`
+}
+
+// Auth is how the interceptor authenticates the requests to an
+// HTTPScaledObject's host. Only one of its methods should be set. If
+// more than one is, the first of bearerToken, jwt and forwardAuth is
+// used
+type Auth struct {
+	// (optional) Accept requests with a static bearer token
+	//+optional
+	BearerToken *BearerTokenAuth `json:"bearerToken,omitempty"`
+	// (optional) Accept requests with a JSON Web Token that a key from a JWKS URL signed
+	//+optional
+	JWT *JWTAuth `json:"jwt,omitempty"`
+	// (optional) Ask an external service whether to accept each request
+	//+optional
+	ForwardAuth *ForwardAuth `json:"forwardAuth,omitempty"`
+}
+
+// BearerTokenAuth accepts requests whose Authorization header has the
+// bearer token in the token key of a Secret in the interceptor's
+// namespace
+type BearerTokenAuth struct {
+	// The name of the Secret, in the interceptor's namespace, with the token under its token key
+	SecretName string `json:"secretName" description:"The name of the Secret, in the interceptor's namespace, with the token under its token key"`
+}
+
+// JWTAuth accepts requests whose Authorization header has a bearer
+// JSON Web Token that was signed by one of the keys at a JWKS URL, and
+// hasn't expired
+type JWTAuth struct {
+	// The URL of the JSON Web Key Set with the keys that tokens may be signed with
+	//+kubebuilder:validation:Pattern=`^https?://`
+	JWKSURL string `json:"jwksURL" description:"The URL of the JSON Web Key Set with the keys that tokens may be signed with"`
+	// (optional) The issuer that tokens must have in their iss claim
+	//+optional
+	Issuer string `json:"issuer,omitempty" description:"The issuer that tokens must have in their iss claim"`
+	// (optional) The audience that tokens must have in their aud claim
+	//+optional
+	Audience string `json:"audience,omitempty" description:"The audience that tokens must have in their aud claim"`
+}
+
+// ForwardAuth sends the method, URI and headers of each request to an
+// external service, and accepts the request if the service responds
+// with a 2xx status. Otherwise, the service's response goes back to
+// the client
+type ForwardAuth struct {
+	// The URL of the service that authenticates requests
+	//+kubebuilder:validation:Pattern=`^https?://`
+	URL string `json:"url" description:"The URL of the service that authenticates requests"`
+	// (optional) The headers of the service's response to copy into the request before it's forwarded, like the authenticated user's ID
+	//+optional
+	ResponseHeaders []string `json:"responseHeaders,omitempty" description:"The headers of the service's response to copy into the request before it's forwarded"`
+}
+
+// Mirror is a service that gets copies of a percentage of the requests
+// to an HTTPScaledObject's host, for testing a new version of an app
+// with production traffic. The interceptor sends the copies in the
+// background and discards the mirror's responses, so the mirror never
+// affects the responses to the requests, and isn't scaled
+type Mirror struct {
+	// The name of the service to send the copies of the requests to
 	Service string `json:"service"`
-	// The port to route to
+	// The port to send the copies of the requests to
 	Port int32 `json:"port"`
+	// Percentage of requests to mirror, from 0 to 100
+	//+kubebuilder:validation:Minimum=0
+	//+kubebuilder:validation:Maximum=100
+	Percent int32 `json:"percent" description:"Percentage of requests to mirror, from 0 to 100"`
+}
+
+// ScalingMetric is the metric that an HTTPScaledObject's workload is
+// scaled on
+type ScalingMetric string
+
+const (
+	// ScalingMetricRequests scales on the requests that are
+	// pending or in flight to the host
+	ScalingMetricRequests ScalingMetric = "requests"
+	// ScalingMetricActiveConnections scales on the client connections
+	// that are open to the host, for their whole lifetime. It suits
+	// apps with long-lived connections, like server-sent events or
+	// long polling
+	ScalingMetricActiveConnections ScalingMetric = "activeConnections"
+	// ScalingMetricRequestsAwaitingResponse scales on the requests to
+	// the host that haven't got their response headers yet. Requests
+	// that are streaming their response bodies, like large downloads,
+	// still keep the host active, but they don't add replicas
+	ScalingMetricRequestsAwaitingResponse ScalingMetric = "requestsAwaitingResponse"
+)
+
+// ScalingBehavior is how an HTTPScaledObject's metric is compared with
+// its target. It's the metric type of the trigger of the ScaledObject
+// that scales the workload
+type ScalingBehavior string
+
+const (
+	// ScalingBehaviorAverageValue divides the metric by the number of
+	// replicas before it's compared with the target, so the target is
+	// the queue depth of each replica
+	ScalingBehaviorAverageValue ScalingBehavior = "AverageValue"
+	// ScalingBehaviorValue compares the metric with the target as it
+	// is, so the target is the total queue depth, which the replicas
+	// are scaled to keep the metric at
+	ScalingBehaviorValue ScalingBehavior = "Value"
+)
+
+// ErrorPages references a ConfigMap, in the HTTPScaledObject's namespace,
+// with the responses that the interceptor sends when it can't forward a
+// request to the backend. For each error class (coldStartTimeout,
+// upstreamError), the ConfigMap may hold the response body under the
+// class's name, and its status code and content type under the class's
+// name with a .status and a .contentType suffix respectively
+type ErrorPages struct {
+	// The name of the ConfigMap with the error pages
+	ConfigMapName string `json:"configMapName" description:"The name of the ConfigMap with the error pages"`
+}
+
+// AdvancedConfig holds advanced options that the operator copies into the
+// KEDA ScaledObjects that it creates for an HTTPScaledObject. It mirrors
+// the advanced section of the ScaledObject's spec
+type AdvancedConfig struct {
+	// Options for the HPA that KEDA creates for the workload
+	//+optional
+	HorizontalPodAutoscalerConfig *HorizontalPodAutoscalerConfig `json:"horizontalPodAutoscalerConfig,omitempty"`
+	// Scale the workload back to its original replica count when the ScaledObject is deleted (Default false)
+	//+optional
+	RestoreToOriginalReplicaCount bool `json:"restoreToOriginalReplicaCount,omitempty" description:"Scale the workload back to its original replica count when the ScaledObject is deleted (Default false)"`
+}
+
+// HorizontalPodAutoscalerConfig holds the options for the HPA that KEDA
+// creates for a workload
+type HorizontalPodAutoscalerConfig struct {
+	// The HPA's scale up and scale down behavior, including its scaling policies and stabilization windows
+	//+optional
+	Behavior *autoscalingv2beta2.HorizontalPodAutoscalerBehavior `json:"behavior,omitempty"`
+}
+
+// Transport tunes the pool of keep-alive connections that the interceptor
+// keeps open to an HTTPScaledObject's backend. A value of 0 uses the
+// interceptor's default
+type Transport struct {
+	// Maximum number of idle keep-alive connections to keep open to the backend
+	MaxIdleConns int32 `json:"maxIdleConns,omitempty" description:"Maximum number of idle keep-alive connections to keep open to the backend"`
+	// Time after which an idle connection to the backend is closed, in seconds
+	IdleConnTimeoutSeconds int32 `json:"idleConnTimeoutSeconds,omitempty" description:"Time after which an idle connection to the backend is closed, in seconds"`
+	// Maximum time to establish a new connection to the backend, including retries, in milliseconds
+	DialTimeoutMS int32 `json:"dialTimeoutMS,omitempty" description:"Maximum time to establish a new connection to the backend, including retries, in milliseconds"`
+}
+
+// UpstreamTLS is how the interceptor connects to an HTTPScaledObject's
+// backend over TLS. The CA bundle is read from the ca.crt key of a
+// Secret in the interceptor's namespace
+type UpstreamTLS struct {
+	// The scheme that requests are forwarded to the backend with, either http or https (Default https)
+	//+optional
+	//+kubebuilder:default=https
+	//+kubebuilder:validation:Enum=http;https
+	Scheme string `json:"scheme,omitempty" description:"The scheme that requests are forwarded to the backend with, either http or https (Default https)"`
+	// (optional) The name of the Secret, in the interceptor's namespace, with the PEM CA bundle that the backend's certificate is verified against under its ca.crt key. Empty verifies it against the interceptor's system roots
+	//+optional
+	CASecretName string `json:"caSecretName,omitempty" description:"The name of the Secret, in the interceptor's namespace, with the PEM CA bundle that the backend's certificate is verified against under its ca.crt key. Empty verifies it against the interceptor's system roots"`
+	// (optional) The server name that's sent with SNI and that the backend's certificate is verified against, instead of the Service's name
+	//+optional
+	ServerName string `json:"serverName,omitempty" description:"The server name that's sent with SNI and that the backend's certificate is verified against, instead of the Service's name"`
+	// (optional) Don't verify the backend's certificate. Connections are still encrypted, but not authenticated, so only use it for testing
+	//+optional
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty" description:"Don't verify the backend's certificate. Connections are still encrypted, but not authenticated, so only use it for testing"`
+}
+
+// Canary is a second workload that serves a percentage of the requests
+// to an HTTPScaledObject's host, while the scaleTargetRef serves the
+// rest. Each workload is scaled on the requests that it gets, so both
+// scale independently
+type Canary struct {
+	// The workload to send the canary's share of requests to, and to autoscale
+	ScaleTargetRef ScaleTargetRef `json:"scaleTargetRef"`
+	// Percentage of requests to send to the canary, from 0 to 100
+	//+kubebuilder:validation:Minimum=0
+	//+kubebuilder:validation:Maximum=100
+	Weight int32 `json:"weight" description:"Percentage of requests to send to the canary, from 0 to 100"`
+}
+
+// ResponseCache configures the interceptor to cache responses for an
+// HTTPScaledObject, so that repeated GET and HEAD requests can be served
+// without waking up the backend. Responses are cached for as long as
+// their Cache-Control header allows, up to the interceptor's maximum
+type ResponseCache struct {
+	// Time to cache responses without max-age or s-maxage in their Cache-Control header, in seconds. 0 means they aren't cached (Default 0)
+	DefaultTTLSeconds int32 `json:"defaultTTLSeconds,omitempty" description:"Time to cache responses without max-age or s-maxage in their Cache-Control header, in seconds. 0 means they aren't cached (Default 0)"`
+}
+
+// BodyLimits configures the maximum sizes of the request and response
+// bodies that the interceptor proxies for an HTTPScaledObject. A value
+// of 0 uses the interceptor's default
+type BodyLimits struct {
+	// Maximum size of a request body, in bytes. Larger requests get a 413 response
+	MaxRequestBytes int64 `json:"maxRequestBytes,omitempty" description:"Maximum size of a request body, in bytes. Larger requests get a 413 response"`
+	// Maximum size of a response body, in bytes. Larger responses are cut off
+	MaxResponseBytes int64 `json:"maxResponseBytes,omitempty" description:"Maximum size of a response body, in bytes. Larger responses are cut off"`
+}
+
+// RetryPolicy configures how the interceptor retries requests that fail
+// before the backend sends a response, for example when a pod is Ready
+// but briefly refusing connections. Only idempotent requests are retried
+type RetryPolicy struct {
+	// Maximum number of retries for a single request (Default 0)
+	Attempts int32 `json:"attempts,omitempty" description:"Maximum number of retries for a single request (Default 0)"`
+	// Time to wait before the first retry, in milliseconds. It doubles after every retry (Default 100)
+	BackoffMS int32 `json:"backoffMS,omitempty" description:"Time to wait before the first retry, in milliseconds. It doubles after every retry (Default 100)"`
+	// Maximum percentage of in-flight requests that may be retries at any time (Default 20)
+	BudgetPercent int32 `json:"budgetPercent,omitempty" description:"Maximum percentage of in-flight requests that may be retries at any time (Default 20)"`
+}
+
+// PausedReplicasAnnotation is the annotation that pauses autoscaling for
+// an HTTPScaledObject. Its value is the number of replicas to pin the
+// workload at until the annotation is removed
+const PausedReplicasAnnotation = "http.keda.sh/paused-replicas"
+
+// PausedReplicas returns the number of replicas that the
+// PausedReplicasAnnotation pins httpso's workload at, or nil if httpso
+// isn't paused. Returns an error if the annotation isn't a non-negative
+// integer
+func (httpso *HTTPScaledObject) PausedReplicas() (*int32, error) {
+	val, ok := httpso.GetAnnotations()[PausedReplicasAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	replicas, err := strconv.ParseInt(val, 10, 32)
+	if err != nil || replicas < 0 {
+		return nil, fmt.Errorf(
+			"invalid %s annotation %q, it must be a non-negative integer",
+			PausedReplicasAnnotation,
+			val,
+		)
+	}
+	ret := int32(replicas)
+	return &ret, nil
+}
+
+// DryRunAnnotation is the annotation that makes the operator record the
+// changes that it would make for an HTTPScaledObject, instead of
+// making them, unless its value is "false"
+const DryRunAnnotation = "http.keda.sh/dry-run"
+
+// DryRun returns true if httpso has the DryRunAnnotation, and its value
+// isn't a false one. A value that isn't a boolean at all is true, so
+// that a typo doesn't apply the changes that were meant to be previewed
+func (httpso *HTTPScaledObject) DryRun() bool {
+	val, ok := httpso.GetAnnotations()[DryRunAnnotation]
+	if !ok {
+		return false
+	}
+	dryRun, err := strconv.ParseBool(val)
+	return err != nil || dryRun
+}
+
+const (
+	// RevisionAnnotation is the annotation that pins an
+	// HTTPScaledObject's host to one revision of its workload. The
+	// interceptor only routes requests to the Service's pods whose
+	// RevisionLabelAnnotation label has this value, so that traffic can
+	// be flipped between revisions by updating the annotation, without
+	// editing the Service's selector
+	RevisionAnnotation = "http.keda.sh/revision"
+	// RevisionLabelAnnotation is the pod label that RevisionAnnotation
+	// is matched against. It defaults to DefaultRevisionLabel
+	RevisionLabelAnnotation = "http.keda.sh/revision-label"
+	// DefaultRevisionLabel is the pod label that RevisionAnnotation is
+	// matched against when RevisionLabelAnnotation isn't set
+	DefaultRevisionLabel = "app.kubernetes.io/version"
+)
+
+// RevisionPin is the pod label and value that an HTTPScaledObject's
+// revision annotations pin its host to
+// +kubebuilder:object:generate=false
+type RevisionPin struct {
+	Label string
+	Value string
+}
+
+// RevisionPin returns the revision that httpso's revision annotations
+// pin its host to, or nil if it isn't pinned. Returns an error if the
+// label isn't a valid label key, or the revision isn't a valid, non
+// empty, label value
+func (httpso *HTTPScaledObject) RevisionPin() (*RevisionPin, error) {
+	annotations := httpso.GetAnnotations()
+	val, ok := annotations[RevisionAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	label := DefaultRevisionLabel
+	if l, ok := annotations[RevisionLabelAnnotation]; ok {
+		label = l
+	}
+	if errs := validation.IsQualifiedName(label); len(errs) > 0 {
+		return nil, fmt.Errorf(
+			"invalid %s annotation %q, it must be a label key: %s",
+			RevisionLabelAnnotation,
+			label,
+			strings.Join(errs, ", "),
+		)
+	}
+	if errs := validation.IsValidLabelValue(val); val == "" || len(errs) > 0 {
+		return nil, fmt.Errorf(
+			"invalid %s annotation %q, it must be a non-empty label value",
+			RevisionAnnotation,
+			val,
+		)
+	}
+	return &RevisionPin{Label: label, Value: val}, nil
+}
+
+const (
+	// FaultDelayAnnotation is the annotation that makes the interceptor
+	// delay requests to an HTTPScaledObject's host before forwarding
+	// them. Its value is a duration, like 500ms
+	FaultDelayAnnotation = "http.keda.sh/fault-delay"
+	// FaultDelayPercentAnnotation is the percentage of requests that
+	// are delayed. It defaults to 100 if only the delay is set
+	FaultDelayPercentAnnotation = "http.keda.sh/fault-delay-percent"
+	// FaultAbortStatusAnnotation is the annotation that makes the
+	// interceptor answer requests to an HTTPScaledObject's host with an
+	// error status, without forwarding them
+	FaultAbortStatusAnnotation = "http.keda.sh/fault-abort-status"
+	// FaultAbortPercentAnnotation is the percentage of requests that
+	// are aborted. It defaults to 100 if only the status is set
+	FaultAbortPercentAnnotation = "http.keda.sh/fault-abort-percent"
+)
+
+// FaultInjection is the latency and the errors that the interceptor
+// injects into the requests to an HTTPScaledObject's host, as set by
+// its fault annotations. It's only honored by interceptors that have
+// fault injection enabled
+// +kubebuilder:object:generate=false
+type FaultInjection struct {
+	Delay        time.Duration
+	DelayPercent int32
+	AbortStatus  int32
+	AbortPercent int32
+}
+
+// FaultInjection returns the faults that httpso's fault annotations
+// ask for, or nil if it has none. Returns an error if any of them is
+// invalid
+func (httpso *HTTPScaledObject) FaultInjection() (*FaultInjection, error) {
+	annotations := httpso.GetAnnotations()
+	ret := &FaultInjection{}
+	if val, ok := annotations[FaultDelayAnnotation]; ok {
+		delay, err := time.ParseDuration(val)
+		if err != nil || delay < 0 {
+			return nil, fmt.Errorf(
+				"invalid %s annotation %q, it must be a non-negative duration",
+				FaultDelayAnnotation,
+				val,
+			)
+		}
+		ret.Delay = delay
+		ret.DelayPercent = 100
+	}
+	if val, ok := annotations[FaultAbortStatusAnnotation]; ok {
+		status, err := strconv.ParseInt(val, 10, 32)
+		if err != nil || status < 400 || status > 599 {
+			return nil, fmt.Errorf(
+				"invalid %s annotation %q, it must be an HTTP error status",
+				FaultAbortStatusAnnotation,
+				val,
+			)
+		}
+		ret.AbortStatus = int32(status)
+		ret.AbortPercent = 100
+	}
+	for annotation, percent := range map[string]*int32{
+		FaultDelayPercentAnnotation: &ret.DelayPercent,
+		FaultAbortPercentAnnotation: &ret.AbortPercent,
+	} {
+		val, ok := annotations[annotation]
+		if !ok {
+			continue
+		}
+		parsed, err := strconv.ParseInt(val, 10, 32)
+		if err != nil || parsed < 0 || parsed > 100 {
+			return nil, fmt.Errorf(
+				"invalid %s annotation %q, it must be an integer from 0 to 100",
+				annotation,
+				val,
+			)
+		}
+		*percent = int32(parsed)
+	}
+	if ret.Delay == 0 || ret.DelayPercent == 0 {
+		ret.Delay, ret.DelayPercent = 0, 0
+	}
+	if ret.AbortStatus == 0 || ret.AbortPercent == 0 {
+		ret.AbortStatus, ret.AbortPercent = 0, 0
+	}
+	if *ret == (FaultInjection{}) {
+		return nil, nil
+	}
+	return ret, nil
+}
+
+const (
+	// DefaultScaleTargetAPIVersion is the API version of the workload
+	// to scale when the scaleTargetRef doesn't set one
+	DefaultScaleTargetAPIVersion = "apps/v1"
+	// DefaultScaleTargetKind is the kind of the workload to scale when
+	// the scaleTargetRef doesn't set one
+	DefaultScaleTargetKind = "Deployment"
+)
+
+// ScaleTargetRef contains all the details about an HTTP application to scale and route to
+type ScaleTargetRef struct {
+	// The name of the deployment to scale according to HTTP traffic. Deprecated in favor of name
+	//+optional
+	Deployment string `json:"deployment,omitempty"`
+	// The API version of the workload to scale (Default apps/v1)
+	//+optional
+	APIVersion string `json:"apiVersion,omitempty"`
+	// The kind of the workload to scale. It must implement the scale subresource (Default Deployment)
+	//+optional
+	Kind string `json:"kind,omitempty"`
+	// The name of the workload to scale according to HTTP traffic. Takes precedence over deployment
+	//+optional
+	Name string `json:"name,omitempty"`
+	// The name of the service to route to. It may be an ExternalName service, in which case nothing is scaled unless name or deployment is set. Not used if url is set
+	//+optional
+	Service string `json:"service,omitempty"`
+	// The port to route to. Not used if url is set
+	//+optional
+	Port int32 `json:"port,omitempty"`
+	// (optional) The URL of a backend outside the cluster to route to instead of the service. Nothing is scaled, so name and deployment are ignored
+	//+optional
+	//+kubebuilder:validation:Pattern=`^https?://`
+	URL string `json:"url,omitempty"`
+}
+
+// IsExternal returns true if s routes to a URL outside the cluster
+// instead of to a service, in which case there's no workload to scale.
+// ExternalName services can only be told apart by looking them up, so
+// this returns false for them
+func (s *ScaleTargetRef) IsExternal() bool {
+	return s.URL != ""
+}
+
+// WorkloadName returns the name of the workload to scale, which is
+// Name if it's set and Deployment otherwise
+func (s *ScaleTargetRef) WorkloadName() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return s.Deployment
+}
+
+// Reference returns the kind and name of the workload to scale, like
+// Deployment/xkcd, or the URL of the backend if it's outside the
+// cluster. It's empty if there's neither
+func (s *ScaleTargetRef) Reference() string {
+	if s == nil {
+		return ""
+	}
+	if s.IsExternal() {
+		return s.URL
+	}
+	name := s.WorkloadName()
+	if name == "" {
+		return ""
+	}
+	return s.WorkloadKind() + "/" + name
+}
+
+// WorkloadAPIVersion returns the API version of the workload to scale,
+// or DefaultScaleTargetAPIVersion if it's not set
+func (s *ScaleTargetRef) WorkloadAPIVersion() string {
+	if s.APIVersion == "" {
+		return DefaultScaleTargetAPIVersion
+	}
+	return s.APIVersion
+}
+
+// WorkloadKind returns the kind of the workload to scale, or
+// DefaultScaleTargetKind if it's not set
+func (s *ScaleTargetRef) WorkloadKind() string {
+	if s.Kind == "" {
+		return DefaultScaleTargetKind
+	}
+	return s.Kind
 }
 
 // HTTPScaledObjectStatus defines the observed state of HTTPScaledObject
 type HTTPScaledObjectStatus struct {
-	// List of auditable conditions of the operator
-	Conditions []HTTPScaledObjectCondition `json:"conditions,omitempty" description:"List of auditable conditions of the operator"`
+	// The most recent generation of the HTTPScaledObject that the operator observed
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty" description:"The most recent generation of the HTTPScaledObject that the operator observed"`
+	// The latest observations of the HTTPScaledObject's state
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" description:"The latest observations of the HTTPScaledObject's state"`
+	// The kind and name of the workload that the HTTPScaledObject scales, or the URL of its backend outside the cluster
+	// +optional
+	TargetWorkload string `json:"targetWorkload,omitempty" description:"The kind and name of the workload that the HTTPScaledObject scales, or the URL of its backend outside the cluster"`
 }
 
 // +kubebuilder:object:root=true
@@ -123,14 +866,16 @@ type HTTPScaledObjectStatus struct {
 // HTTPScaledObject is the Schema for the scaledobjects API
 // +k8s:openapi-gen=true
 // +kubebuilder:subresource:status
+// +kubebuilder:storageversion
 // +kubebuilder:resource:path=httpscaledobjects,scope=Namespaced,shortName=httpso
-// +kubebuilder:printcolumn:name="ScaleTargetDeploymentName",type="string",JSONPath=".spec.scaleTargetRef.deploymentName"
-// +kubebuilder:printcolumn:name="ScaleTargetServiceName",type="string",JSONPath=".spec.scaleTargetRef"
-// +kubebuilder:printcolumn:name="ScaleTargetPort",type="integer",JSONPath=".spec.scaleTargetRef"
+// +kubebuilder:printcolumn:name="Host",type="string",JSONPath=".spec.host"
+// +kubebuilder:printcolumn:name="TargetWorkload",type="string",JSONPath=".status.targetWorkload"
+// +kubebuilder:printcolumn:name="ScaleTargetServiceName",type="string",JSONPath=".spec.scaleTargetRef.service",priority=1
+// +kubebuilder:printcolumn:name="ScaleTargetPort",type="integer",JSONPath=".spec.scaleTargetRef.port",priority=1
 // +kubebuilder:printcolumn:name="MinReplicas",type="integer",JSONPath=".spec.replicas.min"
 // +kubebuilder:printcolumn:name="MaxReplicas",type="integer",JSONPath=".spec.replicas.max"
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
 // +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
-// +kubebuilder:printcolumn:name="Active",type="string",JSONPath=".status.conditions[?(@.type==\"HTTPScaledObjectIsReady\")].status"
 
 type HTTPScaledObject struct {
 	metav1.TypeMeta   `json:",inline"`
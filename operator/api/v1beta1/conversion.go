@@ -0,0 +1,170 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"strings"
+
+	"github.com/kedacore/http-add-on/operator/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+)
+
+// ConvertTo converts src to the hub version, v1alpha1. The first host
+// becomes the hub's host, and the others are kept in its
+// v1alpha1.AdditionalHostsAnnotation
+func (src *HTTPScaledObject) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1alpha1.HTTPScaledObject)
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	annotations := dst.GetAnnotations()
+	delete(annotations, v1alpha1.AdditionalHostsAnnotation)
+	if len(src.Spec.Hosts) > 0 {
+		dst.Spec.Host = src.Spec.Hosts[0]
+	}
+	if len(src.Spec.Hosts) > 1 {
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[v1alpha1.AdditionalHostsAnnotation] = strings.Join(src.Spec.Hosts[1:], ",")
+	}
+	dst.SetAnnotations(annotations)
+
+	dst.Spec.ScaleTargetRef = src.Spec.ScaleTargetRef.convertTo()
+	dst.Spec.Replicas = src.Spec.Replicas
+	if src.Spec.ScalingMetric != nil {
+		dst.Spec.ScalingMetric = src.Spec.ScalingMetric.Type
+		dst.Spec.TargetPendingRequests = src.Spec.ScalingMetric.TargetValue
+	}
+	dst.Spec.RetryPolicy = src.Spec.RetryPolicy.DeepCopy()
+	dst.Spec.BodyLimits = src.Spec.BodyLimits.DeepCopy()
+	dst.Spec.ResponseCache = src.Spec.ResponseCache.DeepCopy()
+	if src.Spec.Canary != nil {
+		dst.Spec.Canary = &v1alpha1.Canary{
+			ScaleTargetRef: *src.Spec.Canary.ScaleTargetRef.convertTo(),
+			Weight:         src.Spec.Canary.Weight,
+		}
+	}
+	dst.Spec.Transport = src.Spec.Transport.DeepCopy()
+	dst.Spec.Advanced = src.Spec.Advanced.DeepCopy()
+	dst.Spec.ErrorPages = src.Spec.ErrorPages.DeepCopy()
+	dst.Spec.Mirror = src.Spec.Mirror.DeepCopy()
+	if src.Spec.ScaledownPeriod != nil {
+		period := *src.Spec.ScaledownPeriod
+		dst.Spec.ScaledownPeriod = &period
+	}
+	dst.Spec.Auth = src.Spec.Auth.DeepCopy()
+	dst.Spec.Concurrency = src.Spec.Concurrency.DeepCopy()
+	dst.Spec.WaitingRoom = src.Spec.WaitingRoom.DeepCopy()
+	dst.Spec.Probes = src.Spec.Probes.DeepCopy()
+	dst.Spec.ClientCertificate = src.Spec.ClientCertificate.DeepCopy()
+	dst.Spec.Smoothing = src.Spec.Smoothing.DeepCopy()
+	dst.Spec.Tunnel = src.Spec.Tunnel.DeepCopy()
+	dst.Spec.RequestHeaders = src.Spec.RequestHeaders.DeepCopy()
+	dst.Spec.Timeouts = src.Spec.Timeouts.DeepCopy()
+	dst.Spec.IPFilter = src.Spec.IPFilter.DeepCopy()
+	dst.Spec.ScalingBehavior = src.Spec.ScalingBehavior
+	dst.Spec.ResponseHeaders = src.Spec.ResponseHeaders.DeepCopy()
+	dst.Spec.CountedMethods = append([]string(nil), src.Spec.CountedMethods...)
+	dst.Spec.UpstreamTLS = src.Spec.UpstreamTLS.DeepCopy()
+	src.Status.DeepCopyInto(&dst.Status)
+	return nil
+}
+
+// ConvertFrom converts the hub version, v1alpha1, to dst. The hub's
+// host comes first in dst's hosts, followed by the ones in its
+// v1alpha1.AdditionalHostsAnnotation
+func (dst *HTTPScaledObject) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1alpha1.HTTPScaledObject)
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	annotations := dst.GetAnnotations()
+	dst.Spec.Hosts = []string{src.Spec.Host}
+	if additional := annotations[v1alpha1.AdditionalHostsAnnotation]; additional != "" {
+		dst.Spec.Hosts = append(dst.Spec.Hosts, strings.Split(additional, ",")...)
+	}
+	delete(annotations, v1alpha1.AdditionalHostsAnnotation)
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+	dst.SetAnnotations(annotations)
+
+	if src.Spec.ScaleTargetRef != nil {
+		dst.Spec.ScaleTargetRef = convertFrom(src.Spec.ScaleTargetRef)
+	}
+	dst.Spec.Replicas = src.Spec.Replicas
+	if src.Spec.ScalingMetric != "" || src.Spec.TargetPendingRequests != 0 {
+		dst.Spec.ScalingMetric = &ScalingMetricSpec{
+			Type:        src.Spec.ScalingMetric,
+			TargetValue: src.Spec.TargetPendingRequests,
+		}
+	}
+	dst.Spec.RetryPolicy = src.Spec.RetryPolicy.DeepCopy()
+	dst.Spec.BodyLimits = src.Spec.BodyLimits.DeepCopy()
+	dst.Spec.ResponseCache = src.Spec.ResponseCache.DeepCopy()
+	if src.Spec.Canary != nil {
+		dst.Spec.Canary = &Canary{
+			ScaleTargetRef: convertFrom(&src.Spec.Canary.ScaleTargetRef),
+			Weight:         src.Spec.Canary.Weight,
+		}
+	}
+	dst.Spec.Transport = src.Spec.Transport.DeepCopy()
+	dst.Spec.Advanced = src.Spec.Advanced.DeepCopy()
+	dst.Spec.ErrorPages = src.Spec.ErrorPages.DeepCopy()
+	dst.Spec.Mirror = src.Spec.Mirror.DeepCopy()
+	if src.Spec.ScaledownPeriod != nil {
+		period := *src.Spec.ScaledownPeriod
+		dst.Spec.ScaledownPeriod = &period
+	}
+	dst.Spec.Auth = src.Spec.Auth.DeepCopy()
+	dst.Spec.Concurrency = src.Spec.Concurrency.DeepCopy()
+	dst.Spec.WaitingRoom = src.Spec.WaitingRoom.DeepCopy()
+	dst.Spec.Probes = src.Spec.Probes.DeepCopy()
+	dst.Spec.ClientCertificate = src.Spec.ClientCertificate.DeepCopy()
+	dst.Spec.Smoothing = src.Spec.Smoothing.DeepCopy()
+	dst.Spec.Tunnel = src.Spec.Tunnel.DeepCopy()
+	dst.Spec.RequestHeaders = src.Spec.RequestHeaders.DeepCopy()
+	dst.Spec.Timeouts = src.Spec.Timeouts.DeepCopy()
+	dst.Spec.IPFilter = src.Spec.IPFilter.DeepCopy()
+	dst.Spec.ScalingBehavior = src.Spec.ScalingBehavior
+	dst.Spec.ResponseHeaders = src.Spec.ResponseHeaders.DeepCopy()
+	dst.Spec.CountedMethods = append([]string(nil), src.Spec.CountedMethods...)
+	dst.Spec.UpstreamTLS = src.Spec.UpstreamTLS.DeepCopy()
+	src.Status.DeepCopyInto(&dst.Status)
+	return nil
+}
+
+func (ref ScaleTargetRef) convertTo() *v1alpha1.ScaleTargetRef {
+	return &v1alpha1.ScaleTargetRef{
+		APIVersion: ref.APIVersion,
+		Kind:       ref.Kind,
+		Name:       ref.Name,
+		Service:    ref.Service,
+		Port:       ref.Port,
+		URL:        ref.URL,
+	}
+}
+
+// convertFrom converts ref to a ScaleTargetRef. The deprecated
+// deployment field is folded into the name
+func convertFrom(ref *v1alpha1.ScaleTargetRef) ScaleTargetRef {
+	return ScaleTargetRef{
+		APIVersion: ref.APIVersion,
+		Kind:       ref.Kind,
+		Name:       ref.WorkloadName(),
+		Service:    ref.Service,
+		Port:       ref.Port,
+		URL:        ref.URL,
+	}
+}
@@ -0,0 +1,138 @@
+package v1beta1
+
+import (
+	"testing"
+
+	"github.com/kedacore/http-add-on/operator/api/v1alpha1"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestConvertRoundTrip(t *testing.T) {
+	r := require.New(t)
+	scaledownPeriod := int32(300)
+	maxQueued := int32(10)
+	orig := &HTTPScaledObject{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "testns",
+			Name:        "testapp",
+			Annotations: map[string]string{"keep": "me"},
+		},
+		Spec: HTTPScaledObjectSpec{
+			Hosts: []string{"myapp.com", "www.myapp.com", "myapp.net"},
+			ScaleTargetRef: ScaleTargetRef{
+				Name:    "testdepl",
+				Service: "testsvc",
+				Port:    8080,
+			},
+			Replicas: v1alpha1.ReplicaStruct{Min: 1, Max: 10},
+			ScalingMetric: &ScalingMetricSpec{
+				Type:        v1alpha1.ScalingMetricActiveConnections,
+				TargetValue: 50,
+			},
+			Canary: &Canary{
+				ScaleTargetRef: ScaleTargetRef{
+					Name:    "testcanary",
+					Service: "testcanarysvc",
+					Port:    8080,
+				},
+				Weight: 10,
+			},
+			ErrorPages:      &v1alpha1.ErrorPages{ConfigMapName: "pages"},
+			Mirror:          &v1alpha1.Mirror{Service: "shadowsvc", Port: 8080, Percent: 5},
+			ScaledownPeriod: &scaledownPeriod,
+			Auth: &v1alpha1.Auth{
+				JWT: &v1alpha1.JWTAuth{JWKSURL: "https://auth.myapp.com/jwks.json"},
+			},
+			Concurrency: &v1alpha1.Concurrency{MaxInFlight: 4, MaxQueued: &maxQueued},
+			WaitingRoom: &v1alpha1.WaitingRoom{ThresholdMS: 2000, RefreshSeconds: 3},
+			Probes: &v1alpha1.Probes{
+				Paths:      []string{"/healthz"},
+				UserAgents: []string{"kube-probe/"},
+			},
+			ClientCertificate: &v1alpha1.ClientCertificate{
+				AllowedSANs: []string{"spiffe://cluster.local/ns/default/sa/frontend"},
+			},
+			Smoothing: &v1alpha1.Smoothing{FactorPercent: 30},
+			Tunnel:    &v1alpha1.Tunnel{Ports: []int32{5432}},
+			RequestHeaders: &v1alpha1.RequestHeaders{
+				Set:    map[string]string{"X-Env": "prod"},
+				Remove: []string{"Cookie"},
+				Host:   "app.default.svc.cluster.local",
+			},
+			Timeouts: &v1alpha1.Timeouts{ResponseHeaderMS: 500, ResponseMS: 2000},
+			IPFilter: &v1alpha1.IPFilter{
+				Allow: []string{"10.0.0.0/8"},
+				Deny:  []string{"10.1.2.3"},
+			},
+			ScalingBehavior: v1alpha1.ScalingBehaviorValue,
+			ResponseHeaders: &v1alpha1.ResponseHeaders{
+				Set:      map[string]string{"X-Served-By": "keda"},
+				Defaults: map[string]string{"Access-Control-Allow-Origin": "*"},
+				Remove:   []string{"Server"},
+			},
+			CountedMethods: []string{"POST", "PUT"},
+			UpstreamTLS: &v1alpha1.UpstreamTLS{
+				Scheme:       "https",
+				CASecretName: "backend-ca",
+				ServerName:   "backend.example.com",
+			},
+		},
+	}
+
+	hub := &v1alpha1.HTTPScaledObject{}
+	r.NoError(orig.ConvertTo(hub))
+	r.Equal("myapp.com", hub.Spec.Host)
+	r.Equal("www.myapp.com,myapp.net", hub.GetAnnotations()[v1alpha1.AdditionalHostsAnnotation])
+	r.Equal("testdepl", hub.Spec.ScaleTargetRef.WorkloadName())
+	r.Equal(v1alpha1.ScalingMetricActiveConnections, hub.Spec.ScalingMetric)
+	r.Equal(int32(50), hub.Spec.TargetPendingRequests)
+	r.Equal("testcanary", hub.Spec.Canary.ScaleTargetRef.WorkloadName())
+	r.Equal(int32(300), *hub.Spec.ScaledownPeriod)
+	// the original's annotations aren't changed
+	r.NotContains(orig.GetAnnotations(), v1alpha1.AdditionalHostsAnnotation)
+
+	converted := &HTTPScaledObject{}
+	r.NoError(converted.ConvertFrom(hub))
+	r.Equal(orig, converted)
+}
+
+func TestConvertFromDeprecatedFields(t *testing.T) {
+	r := require.New(t)
+	hub := &v1alpha1.HTTPScaledObject{
+		Spec: v1alpha1.HTTPScaledObjectSpec{
+			Host: "myapp.com",
+			ScaleTargetRef: &v1alpha1.ScaleTargetRef{
+				Deployment: "testdepl",
+				Service:    "testsvc",
+				Port:       8080,
+			},
+		},
+	}
+	converted := &HTTPScaledObject{}
+	r.NoError(converted.ConvertFrom(hub))
+	r.Equal([]string{"myapp.com"}, converted.Spec.Hosts)
+	r.Equal("testdepl", converted.Spec.ScaleTargetRef.Name)
+	r.Nil(converted.Spec.ScalingMetric)
+	r.Nil(converted.GetAnnotations())
+}
+
+func TestConvertRoundTripExternalURL(t *testing.T) {
+	r := require.New(t)
+	orig := &HTTPScaledObject{
+		Spec: HTTPScaledObjectSpec{
+			Hosts: []string{"legacy.myapp.com"},
+			ScaleTargetRef: ScaleTargetRef{
+				URL: "https://legacy.example.com:8443",
+			},
+		},
+	}
+	hub := &v1alpha1.HTTPScaledObject{}
+	r.NoError(orig.ConvertTo(hub))
+	r.True(hub.Spec.ScaleTargetRef.IsExternal())
+	r.Equal("https://legacy.example.com:8443", hub.Spec.ScaleTargetRef.URL)
+
+	converted := &HTTPScaledObject{}
+	r.NoError(converted.ConvertFrom(hub))
+	r.Equal(orig, converted)
+}
@@ -0,0 +1,200 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"github.com/kedacore/http-add-on/operator/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
+// Important: Run "make" to regenerate code after modifying this file
+
+// HTTPScaledObjectSpec defines the desired state of HTTPScaledObject
+type HTTPScaledObjectSpec struct {
+	// The hosts to route. Requests with the first host in the "Host"
+	// header are routed to the Service and port in the scaleTargetRef.
+	// The other hosts are kept, but not routed yet
+	//+kubebuilder:validation:MinItems=1
+	Hosts []string `json:"hosts"`
+	// The workload to route HTTP requests to, and to autoscale
+	ScaleTargetRef ScaleTargetRef `json:"scaleTargetRef"`
+	// (optional) Replica information
+	//+optional
+	//+kubebuilder:default={}
+	Replicas v1alpha1.ReplicaStruct `json:"replicas,omitempty"`
+	// (optional) The metric to scale the workload on, and its target value
+	//+optional
+	ScalingMetric *ScalingMetricSpec `json:"scalingMetric,omitempty"`
+	// (optional) Policy for retrying requests that fail to reach the backend
+	//+optional
+	RetryPolicy *v1alpha1.RetryPolicy `json:"retryPolicy,omitempty"`
+	// (optional) Limits on the sizes of request and response bodies
+	//+optional
+	BodyLimits *v1alpha1.BodyLimits `json:"bodyLimits,omitempty"`
+	// (optional) Caching of responses to GET and HEAD requests in the interceptor
+	//+optional
+	ResponseCache *v1alpha1.ResponseCache `json:"responseCache,omitempty"`
+	// (optional) A second workload that gets a share of the requests to the hosts, for canary rollouts
+	//+optional
+	Canary *Canary `json:"canary,omitempty"`
+	// (optional) Tuning for the connections that the interceptor keeps open to the backend
+	//+optional
+	Transport *v1alpha1.Transport `json:"transport,omitempty"`
+	// (optional) Advanced options for the KEDA ScaledObject, and the HPA that it creates, that scale the workload
+	//+optional
+	Advanced *v1alpha1.AdvancedConfig `json:"advanced,omitempty"`
+	// (optional) Custom responses for requests that the interceptor can't forward to the backend
+	//+optional
+	ErrorPages *v1alpha1.ErrorPages `json:"errorPages,omitempty"`
+	// (optional) A second service that gets copies of a percentage of the requests, whose responses are discarded
+	//+optional
+	Mirror *v1alpha1.Mirror `json:"mirror,omitempty"`
+	// (optional) Seconds without requests after which the host is reported as inactive, so its workload can scale to zero (Default 0, as soon as there are no requests)
+	//+optional
+	//+kubebuilder:validation:Minimum=0
+	ScaledownPeriod *int32 `json:"scaledownPeriod,omitempty" description:"Seconds without requests after which the host is reported as inactive (Default 0)"`
+	// (optional) How the interceptor authenticates requests before forwarding them. Unauthenticated requests are rejected, and never count toward scaling
+	//+optional
+	Auth *v1alpha1.Auth `json:"auth,omitempty"`
+	// (optional) Limit on the number of requests that each interceptor forwards to the backend at once. Requests past it wait in a queue, and count toward scaling while they do
+	//+optional
+	Concurrency *v1alpha1.Concurrency `json:"concurrency,omitempty"`
+	// (optional) Page that browsers get, instead of waiting, when the backend takes longer than a threshold to start. The page reloads itself until the backend is ready
+	//+optional
+	WaitingRoom *v1alpha1.WaitingRoom `json:"waitingRoom,omitempty"`
+	// (optional) Requests, like health checks from load balancers, that are forwarded to the backend but don't count toward scaling
+	//+optional
+	Probes *v1alpha1.Probes `json:"probes,omitempty"`
+	// (optional) Only accept requests from clients with a verified certificate that has one of the allowed SANs. The interceptor must verify client certificates
+	//+optional
+	ClientCertificate *v1alpha1.ClientCertificate `json:"clientCertificate,omitempty"`
+	// (optional) Exponentially weighted moving average that the scaler reports instead of the raw counts, so that spikes don't make the replicas oscillate
+	//+optional
+	Smoothing *v1alpha1.Smoothing `json:"smoothing,omitempty"`
+	// (optional) Let clients open raw TCP tunnels to the backend with HTTP CONNECT
+	//+optional
+	Tunnel *v1alpha1.Tunnel `json:"tunnel,omitempty"`
+	// (optional) Headers that the interceptor sets on and removes from requests, and the Host header that it sends, when it forwards them to the backend
+	//+optional
+	RequestHeaders *v1alpha1.RequestHeaders `json:"requestHeaders,omitempty"`
+	// (optional) How long the backend may take to respond to each request before the interceptor gives up on it with a 504
+	//+optional
+	Timeouts *v1alpha1.Timeouts `json:"timeouts,omitempty"`
+	// (optional) Client IP addresses that may, and may not, send requests to the backend
+	//+optional
+	IPFilter *v1alpha1.IPFilter `json:"ipFilter,omitempty"`
+	// (optional) How the workload's metric is compared with its target, either AverageValue, which targets the requests per replica, or Value, which targets the requests to all of them (Default AverageValue)
+	//+optional
+	//+kubebuilder:default=AverageValue
+	//+kubebuilder:validation:Enum=AverageValue;Value
+	ScalingBehavior v1alpha1.ScalingBehavior `json:"scalingBehavior,omitempty"`
+	// (optional) Headers that the interceptor sets on and removes from the responses that it sends back for the backend, like CORS defaults
+	//+optional
+	ResponseHeaders *v1alpha1.ResponseHeaders `json:"responseHeaders,omitempty"`
+	// (optional) HTTP methods of the requests that count toward scaling, like POST and PUT. Requests with other methods are forwarded to the backend but not counted. Empty counts every request
+	//+optional
+	CountedMethods []string `json:"countedMethods,omitempty"`
+	// (optional) How the interceptor connects to the backend over TLS, for backends that only serve HTTPS
+	//+optional
+	UpstreamTLS *v1alpha1.UpstreamTLS `json:"upstreamTLS,omitempty"`
+}
+
+// ScaleTargetRef is the workload to scale, and the Service and port
+// to route its requests to
+type ScaleTargetRef struct {
+	// The API version of the workload to scale (Default apps/v1)
+	//+optional
+	APIVersion string `json:"apiVersion,omitempty"`
+	// The kind of the workload to scale. It must implement the scale subresource (Default Deployment)
+	//+optional
+	Kind string `json:"kind,omitempty"`
+	// The name of the workload to scale according to HTTP traffic. Leave it empty to route to an ExternalName service without scaling anything
+	//+optional
+	Name string `json:"name,omitempty"`
+	// The name of the service to route to. Not used if url is set
+	//+optional
+	Service string `json:"service,omitempty"`
+	// The port to route to. Not used if url is set
+	//+optional
+	Port int32 `json:"port,omitempty"`
+	// (optional) The URL of a backend outside the cluster to route to instead of the service. Nothing is scaled, so name is ignored
+	//+optional
+	//+kubebuilder:validation:Pattern=`^https?://`
+	URL string `json:"url,omitempty"`
+}
+
+// ScalingMetricSpec is the metric that the workload is scaled on, and
+// the value of that metric that each replica should handle
+type ScalingMetricSpec struct {
+	// The metric to scale the workload on, either requests, activeConnections or requestsAwaitingResponse (Default requests)
+	//+optional
+	//+kubebuilder:validation:Enum=requests;activeConnections;requestsAwaitingResponse
+	Type v1alpha1.ScalingMetric `json:"type,omitempty" description:"The metric to scale the workload on, either requests, activeConnections or requestsAwaitingResponse (Default requests)"`
+	// The target value of the metric for each replica (Default 100)
+	//+optional
+	TargetValue int32 `json:"targetValue,omitempty" description:"The target value of the metric for each replica (Default 100)"`
+}
+
+// Canary is a second workload that serves a percentage of the requests
+// to an HTTPScaledObject's hosts, while the scaleTargetRef serves the
+// rest. Each workload is scaled on the requests that it gets, so both
+// scale independently
+type Canary struct {
+	// The workload to send the canary's share of requests to, and to autoscale
+	ScaleTargetRef ScaleTargetRef `json:"scaleTargetRef"`
+	// Percentage of requests to send to the canary, from 0 to 100
+	//+kubebuilder:validation:Minimum=0
+	//+kubebuilder:validation:Maximum=100
+	Weight int32 `json:"weight" description:"Percentage of requests to send to the canary, from 0 to 100"`
+}
+
+// +kubebuilder:object:root=true
+
+// HTTPScaledObject is the Schema for the scaledobjects API
+// +k8s:openapi-gen=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:path=httpscaledobjects,scope=Namespaced,shortName=httpso
+// +kubebuilder:printcolumn:name="Hosts",type="string",JSONPath=".spec.hosts"
+// +kubebuilder:printcolumn:name="TargetWorkload",type="string",JSONPath=".status.targetWorkload"
+// +kubebuilder:printcolumn:name="ScaleTargetServiceName",type="string",JSONPath=".spec.scaleTargetRef.service",priority=1
+// +kubebuilder:printcolumn:name="ScaleTargetPort",type="integer",JSONPath=".spec.scaleTargetRef.port",priority=1
+// +kubebuilder:printcolumn:name="MinReplicas",type="integer",JSONPath=".spec.replicas.min"
+// +kubebuilder:printcolumn:name="MaxReplicas",type="integer",JSONPath=".spec.replicas.max"
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+type HTTPScaledObject struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   HTTPScaledObjectSpec            `json:"spec,omitempty"`
+	Status v1alpha1.HTTPScaledObjectStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// HTTPScaledObjectList contains a list of HTTPScaledObject
+type HTTPScaledObjectList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []HTTPScaledObject `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&HTTPScaledObject{}, &HTTPScaledObjectList{})
+}
@@ -16,5 +16,11 @@ type AppInfo struct {
 }
 
 func AppScaledObjectName(httpso *v1alpha1.HTTPScaledObject) string {
-	return fmt.Sprintf("%s-app", httpso.Spec.ScaleTargetRef.Deployment)
+	return fmt.Sprintf("%s-app", httpso.Spec.ScaleTargetRef.WorkloadName())
+}
+
+// CanaryScaledObjectName returns the name of the ScaledObject that
+// scales the canary workload of httpso, if it has one
+func CanaryScaledObjectName(httpso *v1alpha1.HTTPScaledObject) string {
+	return fmt.Sprintf("%s-canary", AppScaledObjectName(httpso))
 }
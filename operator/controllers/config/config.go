@@ -13,6 +13,11 @@ type Interceptor struct {
 	ServiceName string `envconfig:"INTERCEPTOR_SERVICE_NAME" required:"true"`
 	ProxyPort   int32  `envconfig:"INTERCEPTOR_PROXY_PORT" required:"true"`
 	AdminPort   int32  `envconfig:"INTERCEPTOR_ADMIN_PORT" required:"true"`
+	// DeploymentName and Namespace locate the interceptor's
+	// Deployment, which the operator autoscales if the
+	// HTTPAddonConfig asks it to
+	DeploymentName string
+	Namespace      string
 }
 
 // ExternalScaler holds static configuration info for the external scaler
@@ -51,6 +56,25 @@ func (i Interceptor) AdminPortString() string {
 	return strconv.Itoa(int(i.AdminPort))
 }
 
+// ScaledObjectName returns the name of the ScaledObject that the
+// operator creates to autoscale the interceptor's Deployment
+func (i Interceptor) ScaledObjectName() string {
+	return i.DeploymentName
+}
+
+// ConfigMapName returns the name of the ConfigMap that the operator
+// renders the HTTPInterceptorConfig into, for the interceptor's
+// config file
+func (i Interceptor) ConfigMapName() string {
+	return fmt.Sprintf("%s-config", i.DeploymentName)
+}
+
+// TLSSecretName returns the name of the Secret that the operator
+// copies the HTTPInterceptorConfig's TLS certificate into
+func (i Interceptor) TLSSecretName() string {
+	return fmt.Sprintf("%s-tls", i.DeploymentName)
+}
+
 // NewInterceptorFromEnv gets interceptor configuration values from environment variables and/or
 // sensible defaults if values were missing.
 // and returns the interceptor struct to match. Returns an error if required values were missing.
@@ -63,9 +87,11 @@ func NewInterceptorFromEnv() (*Interceptor, error) {
 	proxyPort := env.GetInt32Or("KEDAHTTP_INTERCEPTOR_PROXY_PORT", 8091)
 
 	return &Interceptor{
-		ServiceName: serviceName,
-		AdminPort:   adminPort,
-		ProxyPort:   proxyPort,
+		ServiceName:    serviceName,
+		AdminPort:      adminPort,
+		ProxyPort:      proxyPort,
+		DeploymentName: env.GetOr("KEDAHTTP_INTERCEPTOR_DEPLOYMENT", "keda-add-ons-http-interceptor"),
+		Namespace:      env.GetOr("KEDAHTTP_INTERCEPTOR_NAMESPACE", "keda"),
 	}, nil
 }
 
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Namespaces holds the namespaces that the operator reconciles
+// HTTPScaledObjects in
+type Namespaces struct {
+	// Watch is the list of namespaces to watch. Empty means the
+	// operator watches all namespaces in the cluster
+	Watch []string
+	// Ignore is the list of namespaces whose HTTPScaledObjects are
+	// never reconciled, even if they're in Watch
+	Ignore []string
+}
+
+// ParseNamespaces creates a Namespaces from the comma-separated
+// namespace lists in watch and ignore. Returns an error if a namespace
+// is both watched and ignored
+func ParseNamespaces(watch, ignore string) (*Namespaces, error) {
+	ret := &Namespaces{
+		Watch:  splitNamespaces(watch),
+		Ignore: splitNamespaces(ignore),
+	}
+	for _, ns := range ret.Ignore {
+		if contains(ret.Watch, ns) {
+			return nil, fmt.Errorf(
+				"namespace %s is both watched and ignored",
+				ns,
+			)
+		}
+	}
+	return ret, nil
+}
+
+// ClusterWide returns true if n doesn't restrict the watched
+// namespaces, so the operator must watch the whole cluster
+func (n Namespaces) ClusterWide() bool {
+	return len(n.Watch) == 0
+}
+
+// Allowed returns true if HTTPScaledObjects in ns should be reconciled
+func (n Namespaces) Allowed(ns string) bool {
+	if contains(n.Ignore, ns) {
+		return false
+	}
+	return n.ClusterWide() || contains(n.Watch, ns)
+}
+
+func splitNamespaces(s string) []string {
+	ret := []string{}
+	for _, ns := range strings.Split(s, ",") {
+		ns = strings.TrimSpace(ns)
+		if ns != "" && !contains(ret, ns) {
+			ret = append(ret, ns)
+		}
+	}
+	return ret
+}
+
+func contains(l []string, s string) bool {
+	for _, elt := range l {
+		if elt == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNamespaces(t *testing.T) {
+	r := require.New(t)
+	ns, err := ParseNamespaces(" ns1, ns2,,ns1", "")
+	r.NoError(err)
+	r.Equal([]string{"ns1", "ns2"}, ns.Watch)
+	r.Empty(ns.Ignore)
+	r.False(ns.ClusterWide())
+
+	_, err = ParseNamespaces("ns1,ns2", "ns2")
+	r.Error(err)
+}
+
+func TestNamespacesAllowed(t *testing.T) {
+	r := require.New(t)
+
+	all, err := ParseNamespaces("", "")
+	r.NoError(err)
+	r.True(all.ClusterWide())
+	r.True(all.Allowed("anything"))
+
+	clusterWide, err := ParseNamespaces("", "kube-system,kube-public")
+	r.NoError(err)
+	r.True(clusterWide.ClusterWide())
+	r.True(clusterWide.Allowed("default"))
+	r.False(clusterWide.Allowed("kube-system"))
+	r.False(clusterWide.Allowed("kube-public"))
+
+	watched, err := ParseNamespaces("ns1,ns2", "")
+	r.NoError(err)
+	r.True(watched.Allowed("ns1"))
+	r.True(watched.Allowed("ns2"))
+	r.False(watched.Allowed("ns3"))
+}
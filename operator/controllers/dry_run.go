@@ -0,0 +1,321 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/operator/api/v1alpha1"
+	"github.com/kedacore/http-add-on/operator/controllers/config"
+	"github.com/kedacore/http-add-on/pkg/k8s"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// dryRun returns true if the changes for httpso are only computed and
+// recorded, instead of applied, because either the operator or httpso
+// is in dry-run mode
+func (rec *HTTPScaledObjectReconciler) dryRun(httpso *v1alpha1.HTTPScaledObject) bool {
+	return rec.DryRun || httpso.DryRun()
+}
+
+// dryRunApplicationResources records the changes that
+// createOrUpdateApplicationResources would make to httpso's
+// ScaledObjects and to its entry in the routing table ConfigMap, in
+// the DryRun condition of httpso, without making them. Nothing but
+// httpso's status is written
+func (rec *HTTPScaledObjectReconciler) dryRunApplicationResources(
+	ctx context.Context,
+	logger logr.Logger,
+	appInfo config.AppInfo,
+	httpso *v1alpha1.HTTPScaledObject,
+) error {
+	defer httpso.SaveStatus(context.Background(), logger, rec.Client)
+
+	if _, err := httpso.PausedReplicas(); err != nil {
+		rec.setDryRunCondition(
+			httpso,
+			v1alpha1.DryRunNoChanges,
+			fmt.Sprintf("No changes until the annotation is fixed: %s", err),
+		)
+		return nil
+	}
+	if _, err := httpso.RevisionPin(); err != nil {
+		rec.setDryRunCondition(
+			httpso,
+			v1alpha1.DryRunNoChanges,
+			fmt.Sprintf("No changes until the annotation is fixed: %s", err),
+		)
+		return nil
+	}
+	external, err := isExternalBackend(ctx, rec.Client, appInfo, httpso)
+	if err != nil {
+		return err
+	}
+	target := routing.NewTargetFromHTTPScaledObject(
+		httpso,
+		rec.BaseConfig.TargetPendingRequests,
+	)
+	changes := []string{}
+	if external {
+		// the ScaledObjects left over from when httpso had a
+		// workload would be deleted
+		for _, name := range []string{
+			config.AppScaledObjectName(httpso),
+			config.CanaryScaledObjectName(httpso),
+		} {
+			change, err := planScaledObjectDeletion(ctx, rec.Client, appInfo.Namespace, name)
+			if err != nil {
+				return err
+			}
+			changes = appendChange(changes, change)
+		}
+	} else if appInfo.Name == "" {
+		rec.setDryRunCondition(
+			httpso,
+			v1alpha1.DryRunNoChanges,
+			"No changes, scaleTargetRef has neither name nor deployment set",
+		)
+		return nil
+	} else {
+		appScaledObject, canaryScaledObject, err := desiredScaledObjects(
+			appInfo,
+			appInfo.ExternalScalerConfig.HostName(appInfo.Namespace),
+			target.TargetPendingRequests,
+			httpso,
+		)
+		if err != nil {
+			return err
+		}
+		change, err := planScaledObject(ctx, rec.Client, httpso, appScaledObject)
+		if err != nil {
+			return err
+		}
+		changes = appendChange(changes, change)
+		if canaryScaledObject != nil {
+			change, err = planScaledObject(ctx, rec.Client, httpso, canaryScaledObject)
+		} else {
+			change, err = planScaledObjectDeletion(
+				ctx,
+				rec.Client,
+				appInfo.Namespace,
+				config.CanaryScaledObjectName(httpso),
+			)
+		}
+		if err != nil {
+			return err
+		}
+		changes = appendChange(changes, change)
+	}
+
+	change, err := planRoute(ctx, rec.Client, appInfo.Namespace, httpso.Spec.Host, target)
+	if err != nil {
+		return err
+	}
+	changes = appendChange(changes, change)
+
+	if len(changes) == 0 {
+		rec.setDryRunCondition(httpso, v1alpha1.DryRunNoChanges, "No changes")
+		return nil
+	}
+	rec.setDryRunCondition(httpso, v1alpha1.DryRunChanges, strings.Join(changes, "; "))
+	return nil
+}
+
+// dryRunRemoval records the resources that removeApplicationResources
+// would remove for httpso, which is being deleted, in the DryRun
+// condition of httpso and in an Event, without removing them. Deletion
+// itself isn't dry-run: httpso's finalizer is still removed, so that
+// objects that were finalized by an operator that wasn't in dry-run
+// mode don't stay in Terminating forever
+func (rec *HTTPScaledObjectReconciler) dryRunRemoval(
+	ctx context.Context,
+	logger logr.Logger,
+	appInfo config.AppInfo,
+	httpso *v1alpha1.HTTPScaledObject,
+) error {
+	remaining, err := remainingResources(ctx, rec.Client, rec.RoutingTable, appInfo, httpso)
+	if err != nil {
+		httpso.SaveStatus(context.Background(), logger, rec.Client)
+		return err
+	}
+	if len(remaining) == 0 {
+		rec.setDryRunCondition(httpso, v1alpha1.DryRunNoChanges, "Nothing to remove")
+	} else {
+		rec.setDryRunCondition(
+			httpso,
+			v1alpha1.DryRunChanges,
+			fmt.Sprintf("Would remove %s", strings.Join(remaining, ", ")),
+		)
+	}
+	// the status is saved first, since httpso may be gone
+	// as soon as its finalizer is removed
+	httpso.SaveStatus(context.Background(), logger, rec.Client)
+	return finalizeScaledObject(ctx, logger, rec.Client, httpso)
+}
+
+// setDryRunCondition sets the DryRun condition on httpso. An Event with
+// the same reason and message is recorded if the message changed, so
+// that the changes don't get recorded again every time httpso is
+// reconciled
+func (rec *HTTPScaledObjectReconciler) setDryRunCondition(
+	httpso *v1alpha1.HTTPScaledObject,
+	reason v1alpha1.HTTPScaledObjectConditionReason,
+	message string,
+) {
+	prev := httpso.GetCondition(v1alpha1.DryRun)
+	httpso.SetCondition(v1alpha1.DryRun, v1.ConditionTrue, reason, message)
+	if prev == nil || prev.Message != message {
+		rec.recordEvent(httpso, corev1.EventTypeNormal, string(reason), message)
+	}
+}
+
+// appendChange appends change to changes, unless it's empty
+func appendChange(changes []string, change string) []string {
+	if change == "" {
+		return changes
+	}
+	return append(changes, change)
+}
+
+// planScaledObject returns the change that createOrReconcileScaledObject
+// would make to bring the ScaledObject with desired's name in line with
+// desired, or an empty string if it wouldn't make any
+func planScaledObject(
+	ctx context.Context,
+	cl client.Client,
+	owner v1.Object,
+	desired *unstructured.Unstructured,
+) (string, error) {
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(desired.GroupVersionKind())
+	err := cl.Get(ctx, client.ObjectKeyFromObject(desired), existing)
+	if apierrs.IsNotFound(err) || meta.IsNoMatchError(err) {
+		return fmt.Sprintf("Would create ScaledObject %s", desired.GetName()), nil
+	} else if err != nil {
+		return "", err
+	}
+	drifted, err := scaledObjectDrift(existing, desired, owner)
+	if err != nil {
+		return "", err
+	}
+	if len(drifted) == 0 {
+		return "", nil
+	}
+	return fmt.Sprintf(
+		"Would update ScaledObject %s (%s)",
+		desired.GetName(),
+		strings.Join(drifted, ", "),
+	), nil
+}
+
+// planScaledObjectDeletion returns the deletion of the ScaledObject
+// called name in namespace, or an empty string if it doesn't exist
+func planScaledObjectDeletion(
+	ctx context.Context,
+	cl client.Client,
+	namespace,
+	name string,
+) (string, error) {
+	scaledObject := &unstructured.Unstructured{}
+	scaledObject.SetGroupVersionKind(schema.GroupVersionKind{
+		Group:   "keda.sh",
+		Kind:    "ScaledObject",
+		Version: "v1alpha1",
+	})
+	err := cl.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, scaledObject)
+	if apierrs.IsNotFound(err) || meta.IsNoMatchError(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Would delete ScaledObject %s", name), nil
+}
+
+// planRoute returns the change that addAndUpdateRoutingTable would make
+// to host's entry in the routing table ConfigMap in namespace, which
+// is the table that the interceptors load, or an empty string if it
+// wouldn't make any
+func planRoute(
+	ctx context.Context,
+	cl client.Client,
+	namespace,
+	host string,
+	target routing.Target,
+) (string, error) {
+	cm, err := k8s.GetConfigMap(ctx, cl, namespace, routing.ConfigMapRoutingTableName)
+	if apierrs.IsNotFound(err) {
+		return fmt.Sprintf("Would add host %s to the routing table", host), nil
+	} else if err != nil {
+		return "", err
+	}
+	table, err := routing.FetchTableFromConfigMap(cm, nil)
+	if err != nil {
+		return "", err
+	}
+	prev, err := table.Lookup(routing.NamespacedHost(namespace, host))
+	if err != nil {
+		return fmt.Sprintf("Would add host %s to the routing table", host), nil
+	}
+	drifted, err := targetDrift(prev, target)
+	if err != nil {
+		return "", err
+	}
+	if len(drifted) == 0 {
+		return "", nil
+	}
+	return fmt.Sprintf(
+		"Would update host %s in the routing table (%s)",
+		host,
+		strings.Join(drifted, ", "),
+	), nil
+}
+
+// targetDrift returns the JSON fields, sorted, that differ between prev
+// and target, as they're saved in the routing table ConfigMap
+func targetDrift(prev, target routing.Target) ([]string, error) {
+	prevFields, err := targetFields(prev)
+	if err != nil {
+		return nil, err
+	}
+	targetFields, err := targetFields(target)
+	if err != nil {
+		return nil, err
+	}
+	ret := []string{}
+	for key, val := range targetFields {
+		if !reflect.DeepEqual(prevFields[key], val) {
+			ret = append(ret, key)
+		}
+	}
+	for key := range prevFields {
+		if _, ok := targetFields[key]; !ok {
+			ret = append(ret, key)
+		}
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// targetFields returns target's JSON fields
+func targetFields(target routing.Target) (map[string]interface{}, error) {
+	targetBytes, err := json.Marshal(target)
+	if err != nil {
+		return nil, err
+	}
+	ret := map[string]interface{}{}
+	if err := json.Unmarshal(targetBytes, &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
@@ -0,0 +1,223 @@
+package controllers
+
+import (
+	"github.com/kedacore/http-add-on/operator/api/v1alpha1"
+	"github.com/kedacore/http-add-on/pkg/k8s"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("Dry run", func() {
+	var testInfra *commonTestInfra
+	BeforeEach(func() {
+		testInfra = newCommonTestInfra("testns", "testapp")
+	})
+	It("Should only be on for a dry-run annotation that isn't false", func() {
+		httpso := &testInfra.httpso
+		Expect(httpso.DryRun()).To(BeFalse())
+		for val, dryRun := range map[string]bool{
+			"true":  true,
+			"false": false,
+			// a typo doesn't apply the changes
+			"ture": true,
+		} {
+			httpso.SetAnnotations(map[string]string{v1alpha1.DryRunAnnotation: val})
+			Expect(httpso.DryRun()).To(Equal(dryRun), val)
+		}
+	})
+	It("Should record the changes without making them", func() {
+		httpso := &testInfra.httpso
+		httpso.Spec.Host = "myhost.com"
+		Expect(testInfra.cl.Create(testInfra.ctx, httpso)).To(BeNil())
+		recorder := record.NewFakeRecorder(10)
+		rec := &HTTPScaledObjectReconciler{
+			Client:       testInfra.cl,
+			Log:          testInfra.logger,
+			RoutingTable: routing.NewTable(),
+			Recorder:     recorder,
+			DryRun:       true,
+		}
+
+		Expect(rec.dryRunApplicationResources(
+			testInfra.ctx,
+			testInfra.logger,
+			testInfra.cfg,
+			httpso,
+		)).To(BeNil())
+		const created = "Would create ScaledObject testapp-app; Would add host myhost.com to the routing table"
+		cond := httpso.GetCondition(v1alpha1.DryRun)
+		Expect(cond).ToNot(BeNil())
+		Expect(cond.Reason).To(Equal(string(v1alpha1.DryRunChanges)))
+		Expect(cond.Message).To(Equal(created))
+		Expect(recorder.Events).To(Receive(Equal("Normal DryRunChanges " + created)))
+		// nothing was created
+		scaledObject := &unstructured.Unstructured{}
+		scaledObject.SetGroupVersionKind(schema.GroupVersionKind{
+			Group:   "keda.sh",
+			Kind:    "ScaledObject",
+			Version: "v1alpha1",
+		})
+		err := testInfra.cl.Get(testInfra.ctx, client.ObjectKey{
+			Namespace: testInfra.ns,
+			Name:      "testapp-app",
+		}, scaledObject)
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+		_, err = k8s.GetConfigMap(testInfra.ctx, testInfra.cl, testInfra.ns, routing.ConfigMapRoutingTableName)
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+		_, err = rec.RoutingTable.Lookup(routing.NamespacedHost(testInfra.ns, "myhost.com"))
+		Expect(err).ToNot(BeNil())
+
+		// the same changes aren't recorded again
+		Expect(rec.dryRunApplicationResources(
+			testInfra.ctx,
+			testInfra.logger,
+			testInfra.cfg,
+			httpso,
+		)).To(BeNil())
+		Expect(recorder.Events).ToNot(Receive())
+
+		// once they're applied, the condition is gone, and there's
+		// nothing left to change
+		Expect(rec.createOrUpdateApplicationResources(
+			testInfra.ctx,
+			testInfra.logger,
+			testInfra.cfg,
+			httpso,
+		)).To(BeNil())
+		Expect(httpso.GetCondition(v1alpha1.DryRun)).To(BeNil())
+		for len(recorder.Events) > 0 {
+			<-recorder.Events
+		}
+		Expect(rec.dryRunApplicationResources(
+			testInfra.ctx,
+			testInfra.logger,
+			testInfra.cfg,
+			httpso,
+		)).To(BeNil())
+		cond = httpso.GetCondition(v1alpha1.DryRun)
+		Expect(cond.Reason).To(Equal(string(v1alpha1.DryRunNoChanges)))
+		Expect(recorder.Events).To(Receive(Equal("Normal DryRunNoChanges No changes")))
+
+		// a change to the spec shows up in both the ScaledObject and
+		// the routing table, field by field
+		httpso.Spec.TargetPendingRequests = 42
+		httpso.Spec.Replicas.Max = 5
+		Expect(rec.dryRunApplicationResources(
+			testInfra.ctx,
+			testInfra.logger,
+			testInfra.cfg,
+			httpso,
+		)).To(BeNil())
+		Expect(httpso.GetCondition(v1alpha1.DryRun).Message).To(Equal(
+			"Would update ScaledObject testapp-app (spec.maxReplicaCount, spec.triggers); " +
+				"Would update host myhost.com in the routing table (target)",
+		))
+	})
+	It("Should record what would be removed", func() {
+		httpso := &testInfra.httpso
+		httpso.Spec.Host = "myhost.com"
+		Expect(testInfra.cl.Create(testInfra.ctx, httpso)).To(BeNil())
+		rec := &HTTPScaledObjectReconciler{
+			Client:       testInfra.cl,
+			Log:          testInfra.logger,
+			RoutingTable: routing.NewTable(),
+		}
+		Expect(rec.createOrUpdateApplicationResources(
+			testInfra.ctx,
+			testInfra.logger,
+			testInfra.cfg,
+			httpso,
+		)).To(BeNil())
+
+		Expect(rec.dryRunRemoval(
+			testInfra.ctx,
+			testInfra.logger,
+			testInfra.cfg,
+			httpso,
+		)).To(BeNil())
+		cond := httpso.GetCondition(v1alpha1.DryRun)
+		Expect(cond.Reason).To(Equal(string(v1alpha1.DryRunChanges)))
+		Expect(cond.Message).To(Equal(
+			"Would remove routing table entry, ConfigMap keda-http-routing-table entry, ScaledObject testapp-app",
+		))
+		remaining, err := remainingResources(testInfra.ctx, testInfra.cl, rec.RoutingTable, testInfra.cfg, httpso)
+		Expect(err).To(BeNil())
+		Expect(remaining).To(HaveLen(3))
+	})
+	It("Should still remove the finalizer of a deleted object", func() {
+		httpso := &testInfra.httpso
+		httpso.Spec.Host = "myhost.com"
+		// the finalizer was set by an operator that
+		// wasn't in dry-run mode
+		httpso.SetFinalizers([]string{httpScaledObjectFinalizer})
+		now := metav1.Now()
+		httpso.SetDeletionTimestamp(&now)
+		Expect(testInfra.cl.Create(testInfra.ctx, httpso)).To(BeNil())
+		table := routing.NewTable()
+		Expect((&HTTPScaledObjectReconciler{
+			Client:       testInfra.cl,
+			Log:          testInfra.logger,
+			RoutingTable: table,
+		}).createOrUpdateApplicationResources(
+			testInfra.ctx,
+			testInfra.logger,
+			testInfra.cfg,
+			httpso,
+		)).To(BeNil())
+
+		recorder := record.NewFakeRecorder(10)
+		rec := &HTTPScaledObjectReconciler{
+			Client:       testInfra.cl,
+			Log:          testInfra.logger,
+			RoutingTable: table,
+			Recorder:     recorder,
+			DryRun:       true,
+		}
+		_, err := rec.Reconcile(testInfra.ctx, ctrl.Request{
+			NamespacedName: client.ObjectKeyFromObject(httpso),
+		})
+		Expect(err).To(BeNil())
+		Expect(recorder.Events).To(Receive(Equal(
+			"Normal DryRunChanges Would remove routing table entry, " +
+				"ConfigMap keda-http-routing-table entry, ScaledObject testapp-app",
+		)))
+		// nothing was removed, but the finalizer
+		remaining, err := remainingResources(testInfra.ctx, testInfra.cl, table, testInfra.cfg, httpso)
+		Expect(err).To(BeNil())
+		Expect(remaining).To(HaveLen(3))
+		fetched := &v1alpha1.HTTPScaledObject{}
+		err = testInfra.cl.Get(testInfra.ctx, client.ObjectKeyFromObject(httpso), fetched)
+		if err == nil {
+			Expect(fetched.GetFinalizers()).ToNot(ContainElement(httpScaledObjectFinalizer))
+		} else {
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		}
+	})
+	It("Should diff routing targets by their JSON fields", func() {
+		prev := routing.Target{Service: "testsvc", Port: 8080, Deployment: "testdepl"}
+		drift, err := targetDrift(prev, prev)
+		Expect(err).To(BeNil())
+		Expect(drift).To(BeEmpty())
+
+		target := prev
+		target.Port = 9090
+		target.CountedMethods = []string{"POST"}
+		target.Probes = &routing.ProbePolicy{Paths: []string{"/healthz"}}
+		drift, err = targetDrift(prev, target)
+		Expect(err).To(BeNil())
+		Expect(drift).To(Equal([]string{"countedMethods", "port", "probes"}))
+
+		// fields that are removed count too
+		drift, err = targetDrift(target, prev)
+		Expect(err).To(BeNil())
+		Expect(drift).To(Equal([]string{"countedMethods", "port", "probes"}))
+	})
+})
@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+// reasons of the Events that the operator records on HTTPScaledObjects
+// for actions that have no condition reason of their own. Other Events
+// reuse the reason of the condition that the action sets
+const (
+	// eventReasonScaledObjectCreated is recorded when a KEDA
+	// ScaledObject is created for an HTTPScaledObject
+	eventReasonScaledObjectCreated = "ScaledObjectCreated"
+	// eventReasonScaledObjectUpdated is recorded when a KEDA
+	// ScaledObject that drifted from its HTTPScaledObject is
+	// updated back
+	eventReasonScaledObjectUpdated = "ScaledObjectUpdated"
+	// eventReasonInvalidFaultInjection is recorded when an
+	// HTTPScaledObject's fault injection annotations are invalid
+	eventReasonInvalidFaultInjection = "InvalidFaultInjection"
+)
+
+// recordEvent records an Event on obj with recorder. A nil recorder
+// records nothing, so that callers don't all have to check
+func recordEvent(
+	recorder record.EventRecorder,
+	obj runtime.Object,
+	eventType,
+	reason,
+	message string,
+) {
+	if recorder == nil {
+		return
+	}
+	recorder.Event(obj, eventType, reason, message)
+}
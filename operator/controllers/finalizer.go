@@ -2,9 +2,17 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/go-logr/logr"
 	httpv1alpha1 "github.com/kedacore/http-add-on/operator/api/v1alpha1"
+	"github.com/kedacore/http-add-on/operator/controllers/config"
+	"github.com/kedacore/http-add-on/pkg/k8s"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -82,3 +90,56 @@ func remove(list []string, s string) []string {
 	}
 	return list
 }
+
+// remainingResources returns the resources of httpso that are still
+// around after removeApplicationResources deleted them: its host in the
+// routing table, or in the routing table ConfigMap that the
+// interceptors load, and its ScaledObjects, which KEDA may take a
+// while to finalize. It returns an empty slice once they're all gone
+func remainingResources(
+	ctx context.Context,
+	cl client.Client,
+	table *routing.Table,
+	appInfo config.AppInfo,
+	httpso *httpv1alpha1.HTTPScaledObject,
+) ([]string, error) {
+	ret := []string{}
+	key := routing.NamespacedHost(appInfo.Namespace, httpso.Spec.Host)
+	if _, err := table.Lookup(key); err == nil {
+		ret = append(ret, "routing table entry")
+	}
+	cm, err := k8s.GetConfigMap(ctx, cl, appInfo.Namespace, routing.ConfigMapRoutingTableName)
+	if err != nil && !apierrs.IsNotFound(err) {
+		return nil, err
+	} else if err == nil {
+		cmTable, err := routing.FetchTableFromConfigMap(cm, nil)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := cmTable.Lookup(key); err == nil {
+			ret = append(ret, fmt.Sprintf("ConfigMap %s entry", routing.ConfigMapRoutingTableName))
+		}
+	}
+	for _, name := range []string{
+		config.AppScaledObjectName(httpso),
+		config.CanaryScaledObjectName(httpso),
+	} {
+		scaledObject := &unstructured.Unstructured{}
+		scaledObject.SetGroupVersionKind(schema.GroupVersionKind{
+			Group:   "keda.sh",
+			Kind:    "ScaledObject",
+			Version: "v1alpha1",
+		})
+		err := cl.Get(ctx, client.ObjectKey{
+			Namespace: appInfo.Namespace,
+			Name:      name,
+		}, scaledObject)
+		if apierrs.IsNotFound(err) || meta.IsNoMatchError(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		ret = append(ret, fmt.Sprintf("ScaledObject %s", name))
+	}
+	return ret, nil
+}
@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	httpv1alpha1 "github.com/kedacore/http-add-on/operator/api/v1alpha1"
+	"github.com/kedacore/http-add-on/operator/controllers/config"
+	"github.com/kedacore/http-add-on/pkg/k8s"
+)
+
+// HTTPAddonConfigReconciler reconciles the HTTPAddonConfig, which
+// configures the add-on as a whole. It manages the ScaledObject that
+// autoscales the interceptor
+type HTTPAddonConfigReconciler struct {
+	client.Client
+	Log                  logr.Logger
+	InterceptorConfig    config.Interceptor
+	ExternalScalerConfig config.ExternalScaler
+}
+
+// +kubebuilder:rbac:groups=http.keda.sh,resources=httpaddonconfigs,verbs=get;list;watch
+// +kubebuilder:rbac:groups=http.keda.sh,resources=httpaddonconfigs/status,verbs=get;update;patch
+
+// Reconcile creates, updates or deletes the interceptor's ScaledObject
+// according to the HTTPAddonConfig. HTTPAddonConfigs with any other
+// name than httpv1alpha1.HTTPAddonConfigName are ignored
+func (rec *HTTPAddonConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := rec.Log.WithValues("HTTPAddonConfig.Name", req.Name)
+	if req.Name != httpv1alpha1.HTTPAddonConfigName {
+		logger.Info(
+			"ignoring HTTPAddonConfig, only the one with the expected name is used",
+			"expectedName",
+			httpv1alpha1.HTTPAddonConfigName,
+		)
+		return ctrl.Result{}, nil
+	}
+
+	addonCfg := &httpv1alpha1.HTTPAddonConfig{}
+	if err := rec.Client.Get(ctx, req.NamespacedName, addonCfg); err != nil {
+		if errors.IsNotFound(err) {
+			// the ScaledObject is owned by the HTTPAddonConfig, so
+			// it's garbage collected. this only cleans up any that
+			// were left behind
+			logger.Info("HTTPAddonConfig not found, the interceptor isn't autoscaled")
+			return ctrl.Result{}, rec.deleteInterceptorScaledObject(ctx)
+		}
+		return ctrl.Result{}, err
+	}
+	if addonCfg.GetDeletionTimestamp() != nil {
+		return ctrl.Result{}, nil
+	}
+
+	err := rec.reconcileInterceptorScaledObject(ctx, logger, addonCfg)
+	if err != nil {
+		meta.SetStatusCondition(&addonCfg.Status.Conditions, metav1.Condition{
+			Type:    httpv1alpha1.InterceptorAutoscaling,
+			Status:  metav1.ConditionFalse,
+			Reason:  "ErrorReconcilingScaledObject",
+			Message: err.Error(),
+		})
+	}
+	addonCfg.Status.ObservedGeneration = addonCfg.Generation
+	if statusErr := rec.Client.Status().Update(ctx, addonCfg); statusErr != nil {
+		logger.Error(statusErr, "updating HTTPAddonConfig status")
+		if err == nil {
+			err = statusErr
+		}
+	}
+	return ctrl.Result{}, err
+}
+
+// reconcileInterceptorScaledObject creates the interceptor's
+// ScaledObject as addonCfg describes it, or reconciles the existing
+// one back to it. If addonCfg doesn't ask for the interceptor to be
+// autoscaled, the ScaledObject is deleted instead
+func (rec *HTTPAddonConfigReconciler) reconcileInterceptorScaledObject(
+	ctx context.Context,
+	logger logr.Logger,
+	addonCfg *httpv1alpha1.HTTPAddonConfig,
+) error {
+	scaling := addonCfg.Spec.InterceptorScaling
+	if scaling == nil {
+		if err := rec.deleteInterceptorScaledObject(ctx); err != nil {
+			return err
+		}
+		meta.SetStatusCondition(&addonCfg.Status.Conditions, metav1.Condition{
+			Type:    httpv1alpha1.InterceptorAutoscaling,
+			Status:  metav1.ConditionFalse,
+			Reason:  "NotConfigured",
+			Message: "interceptorScaling isn't set",
+		})
+		return nil
+	}
+
+	interceptorCfg := rec.InterceptorConfig
+	minReplicas, maxReplicas := scaling.Replicas()
+	scaledObject, err := k8s.NewInterceptorScaledObject(
+		interceptorCfg.Namespace,
+		interceptorCfg.ScaledObjectName(),
+		interceptorCfg.DeploymentName,
+		rec.ExternalScalerConfig.HostName(interceptorCfg.Namespace),
+		minReplicas,
+		maxReplicas,
+		scaling.TargetCPUUtilization,
+		scaling.PendingRequestsTarget(),
+	)
+	if err != nil {
+		return err
+	}
+	// the owner reference lets the operator watch the ScaledObject
+	// for changes, and garbage collects it with addonCfg
+	scaledObject.SetOwnerReferences([]metav1.OwnerReference{
+		*metav1.NewControllerRef(addonCfg, httpv1alpha1.GroupVersion.WithKind("HTTPAddonConfig")),
+	})
+	if err := rec.Client.Create(ctx, scaledObject); err != nil {
+		if !errors.IsAlreadyExists(err) {
+			return err
+		}
+		if _, err := reconcileScaledObject(ctx, rec.Client, logger, addonCfg, scaledObject); err != nil {
+			return err
+		}
+	}
+	meta.SetStatusCondition(&addonCfg.Status.Conditions, metav1.Condition{
+		Type:    httpv1alpha1.InterceptorAutoscaling,
+		Status:  metav1.ConditionTrue,
+		Reason:  "ScaledObjectReconciled",
+		Message: "the interceptor's ScaledObject is up to date",
+	})
+	return nil
+}
+
+// deleteInterceptorScaledObject deletes the interceptor's ScaledObject,
+// if it exists
+func (rec *HTTPAddonConfigReconciler) deleteInterceptorScaledObject(ctx context.Context) error {
+	scaledObject := &unstructured.Unstructured{}
+	scaledObject.SetNamespace(rec.InterceptorConfig.Namespace)
+	scaledObject.SetName(rec.InterceptorConfig.ScaledObjectName())
+	scaledObject.SetGroupVersionKind(schema.GroupVersionKind{
+		Group:   "keda.sh",
+		Kind:    "ScaledObject",
+		Version: "v1alpha1",
+	})
+	if err := rec.Client.Delete(ctx, scaledObject); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// SetupWithManager starts up reconciliation with the given manager
+func (rec *HTTPAddonConfigReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// watch the ScaledObject too, so that edits to or
+	// deletions of it get reconciled back
+	scaledObject := &unstructured.Unstructured{}
+	scaledObject.SetGroupVersionKind(schema.GroupVersionKind{
+		Group:   "keda.sh",
+		Kind:    "ScaledObject",
+		Version: "v1alpha1",
+	})
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&httpv1alpha1.HTTPAddonConfig{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Owns(scaledObject, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Complete(rec)
+}
@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"context"
+
+	logrtest "github.com/go-logr/logr/testing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/kedacore/http-add-on/operator/api/v1alpha1"
+	"github.com/kedacore/http-add-on/operator/controllers/config"
+)
+
+var _ = Describe("HTTPAddonConfig", func() {
+	Context("Reconciling the interceptor's ScaledObject", func() {
+		var (
+			ctx context.Context
+			cl  client.Client
+			rec *HTTPAddonConfigReconciler
+			req ctrl.Request
+		)
+		BeforeEach(func() {
+			ctx = context.Background()
+			cl = fake.NewFakeClient()
+			rec = &HTTPAddonConfigReconciler{
+				Client: cl,
+				Log:    logrtest.NullLogger{},
+				InterceptorConfig: config.Interceptor{
+					DeploymentName: "interceptor",
+					Namespace:      "keda",
+				},
+				ExternalScalerConfig: config.ExternalScaler{
+					ServiceName: "scaler",
+					Port:        9090,
+				},
+			}
+			req = ctrl.Request{
+				NamespacedName: types.NamespacedName{Name: v1alpha1.HTTPAddonConfigName},
+			}
+		})
+
+		getScaledObject := func() (*unstructured.Unstructured, error) {
+			u := &unstructured.Unstructured{}
+			u.SetGroupVersionKind(schema.GroupVersionKind{
+				Group:   "keda.sh",
+				Kind:    "ScaledObject",
+				Version: "v1alpha1",
+			})
+			err := cl.Get(ctx, client.ObjectKey{
+				Namespace: "keda",
+				Name:      "interceptor",
+			}, u)
+			return u, err
+		}
+
+		It("Should create the ScaledObject, then delete it when scaling is turned off", func() {
+			addonCfg := &v1alpha1.HTTPAddonConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: v1alpha1.HTTPAddonConfigName},
+				Spec: v1alpha1.HTTPAddonConfigSpec{
+					InterceptorScaling: &v1alpha1.InterceptorScaling{
+						MinReplicas:          2,
+						TargetCPUUtilization: 70,
+					},
+				},
+			}
+			Expect(cl.Create(ctx, addonCfg)).To(BeNil())
+
+			_, err := rec.Reconcile(ctx, req)
+			Expect(err).To(BeNil())
+
+			so, err := getScaledObject()
+			Expect(err).To(BeNil())
+			Expect(so.GetOwnerReferences()).To(HaveLen(1))
+			Expect(so.GetOwnerReferences()[0].Kind).To(Equal("HTTPAddonConfig"))
+			spec, err := getKeyAsMap(so.Object, "spec")
+			Expect(err).To(BeNil())
+			Expect(spec["minReplicaCount"]).To(BeNumerically("==", 2))
+			Expect(spec["maxReplicaCount"]).To(BeNumerically("==", v1alpha1.DefaultInterceptorMaxReplicas))
+			// only a target CPU utilization was set, so there's
+			// no external-push trigger
+			triggers, ok := spec["triggers"].([]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(triggers).To(HaveLen(1))
+			trigger, ok := triggers[0].(map[string]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(trigger["type"]).To(Equal("cpu"))
+
+			Expect(cl.Get(ctx, client.ObjectKeyFromObject(addonCfg), addonCfg)).To(BeNil())
+			Expect(meta.IsStatusConditionTrue(
+				addonCfg.Status.Conditions,
+				v1alpha1.InterceptorAutoscaling,
+			)).To(BeTrue())
+
+			addonCfg.Spec.InterceptorScaling = nil
+			Expect(cl.Update(ctx, addonCfg)).To(BeNil())
+			_, err = rec.Reconcile(ctx, req)
+			Expect(err).To(BeNil())
+
+			_, err = getScaledObject()
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+			Expect(cl.Get(ctx, client.ObjectKeyFromObject(addonCfg), addonCfg)).To(BeNil())
+			Expect(meta.IsStatusConditionFalse(
+				addonCfg.Status.Conditions,
+				v1alpha1.InterceptorAutoscaling,
+			)).To(BeTrue())
+		})
+
+		It("Should ignore HTTPAddonConfigs with other names", func() {
+			addonCfg := &v1alpha1.HTTPAddonConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: "other"},
+				Spec: v1alpha1.HTTPAddonConfigSpec{
+					InterceptorScaling: &v1alpha1.InterceptorScaling{},
+				},
+			}
+			Expect(cl.Create(ctx, addonCfg)).To(BeNil())
+
+			_, err := rec.Reconcile(ctx, ctrl.Request{
+				NamespacedName: types.NamespacedName{Name: "other"},
+			})
+			Expect(err).To(BeNil())
+			_, err = getScaledObject()
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		})
+	})
+
+	Context("Filling in InterceptorScaling defaults", func() {
+		It("Should scale on pending requests if no target is set", func() {
+			scaling := &v1alpha1.InterceptorScaling{}
+			min, max := scaling.Replicas()
+			Expect(min).To(BeNumerically("==", v1alpha1.DefaultInterceptorMinReplicas))
+			Expect(max).To(BeNumerically("==", v1alpha1.DefaultInterceptorMaxReplicas))
+			Expect(scaling.PendingRequestsTarget()).To(BeNumerically("==", v1alpha1.DefaultInterceptorTargetPendingRequests))
+
+			scaling = &v1alpha1.InterceptorScaling{MinReplicas: 20, TargetCPUUtilization: 50}
+			min, max = scaling.Replicas()
+			Expect(min).To(BeNumerically("==", 20))
+			Expect(max).To(BeNumerically("==", 20))
+			Expect(scaling.PendingRequestsTarget()).To(BeNumerically("==", 0))
+		})
+	})
+})
@@ -0,0 +1,276 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	httpv1alpha1 "github.com/kedacore/http-add-on/operator/api/v1alpha1"
+	"github.com/kedacore/http-add-on/operator/controllers/config"
+)
+
+// HTTPInterceptorConfigReconciler reconciles the HTTPInterceptorConfig,
+// which tunes the interceptor. It renders the HTTPInterceptorConfig
+// into a ConfigMap with the interceptor's config file and a Secret
+// with its TLS certificate, mounts them into the interceptor's
+// Deployment and tracks the rollout
+type HTTPInterceptorConfigReconciler struct {
+	client.Client
+	Log               logr.Logger
+	InterceptorConfig config.Interceptor
+}
+
+// +kubebuilder:rbac:groups=http.keda.sh,resources=httpinterceptorconfigs,verbs=get;list;watch
+// +kubebuilder:rbac:groups=http.keda.sh,resources=httpinterceptorconfigs/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=configmaps;secrets,verbs=get;list;watch;create;update
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;update
+
+// Reconcile renders the HTTPInterceptorConfig for the interceptor and
+// rolls it out. If it's deleted, the interceptor's Deployment goes back
+// to its own configuration. HTTPInterceptorConfigs with any other name
+// than httpv1alpha1.HTTPInterceptorConfigName are ignored
+func (rec *HTTPInterceptorConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := rec.Log.WithValues("HTTPInterceptorConfig.Name", req.Name)
+	if req.Name != httpv1alpha1.HTTPInterceptorConfigName {
+		logger.Info(
+			"ignoring HTTPInterceptorConfig, only the one with the expected name is used",
+			"expectedName",
+			httpv1alpha1.HTTPInterceptorConfigName,
+		)
+		return ctrl.Result{}, nil
+	}
+
+	interceptorCfg := &httpv1alpha1.HTTPInterceptorConfig{}
+	if err := rec.Client.Get(ctx, req.NamespacedName, interceptorCfg); err != nil {
+		if errors.IsNotFound(err) {
+			// the ConfigMap and the Secret are owned by the
+			// HTTPInterceptorConfig, so they're garbage collected.
+			// the Deployment isn't, so it's unmounted here
+			logger.Info("HTTPInterceptorConfig not found, the interceptor uses its own configuration")
+			return ctrl.Result{}, rec.unmountInterceptorConfig(ctx)
+		}
+		return ctrl.Result{}, err
+	}
+	if interceptorCfg.GetDeletionTimestamp() != nil {
+		return ctrl.Result{}, nil
+	}
+
+	hash, err := rec.renderInterceptorConfig(ctx, interceptorCfg)
+	if err != nil {
+		meta.SetStatusCondition(&interceptorCfg.Status.Conditions, metav1.Condition{
+			Type:    httpv1alpha1.InterceptorConfigRendered,
+			Status:  metav1.ConditionFalse,
+			Reason:  "ErrorRenderingConfig",
+			Message: err.Error(),
+		})
+	} else {
+		interceptorCfg.Status.ConfigHash = hash
+		meta.SetStatusCondition(&interceptorCfg.Status.Conditions, metav1.Condition{
+			Type:    httpv1alpha1.InterceptorConfigRendered,
+			Status:  metav1.ConditionTrue,
+			Reason:  "ConfigRendered",
+			Message: "the interceptor's ConfigMap and Secret are up to date",
+		})
+		err = rec.rollOutInterceptorConfig(ctx, logger, interceptorCfg, hash)
+	}
+	interceptorCfg.Status.ObservedGeneration = interceptorCfg.Generation
+	if statusErr := rec.Client.Status().Update(ctx, interceptorCfg); statusErr != nil {
+		logger.Error(statusErr, "updating HTTPInterceptorConfig status")
+		if err == nil {
+			err = statusErr
+		}
+	}
+	return ctrl.Result{}, err
+}
+
+// renderInterceptorConfig creates or updates the interceptor's
+// ConfigMap and TLS Secret from interceptorCfg, and returns the hash of
+// the rendered config file
+func (rec *HTTPInterceptorConfigReconciler) renderInterceptorConfig(
+	ctx context.Context,
+	interceptorCfg *httpv1alpha1.HTTPInterceptorConfig,
+) (string, error) {
+	ns := rec.InterceptorConfig.Namespace
+	ownerRef := *metav1.NewControllerRef(
+		interceptorCfg,
+		httpv1alpha1.GroupVersion.WithKind("HTTPInterceptorConfig"),
+	)
+
+	tlsData := map[string][]byte{}
+	if tls := interceptorCfg.Spec.TLS; tls != nil {
+		src := &corev1.Secret{}
+		if err := rec.Client.Get(ctx, client.ObjectKey{
+			Namespace: ns,
+			Name:      tls.SecretName,
+		}, src); err != nil {
+			return "", fmt.Errorf("getting TLS Secret %s/%s: %w", ns, tls.SecretName, err)
+		}
+		for _, key := range []string{
+			corev1.TLSCertKey,
+			corev1.TLSPrivateKeyKey,
+			corev1.ServiceAccountRootCAKey,
+		} {
+			if val, ok := src.Data[key]; ok {
+				tlsData[key] = val
+			}
+		}
+		if len(tlsData[corev1.TLSCertKey]) == 0 || len(tlsData[corev1.TLSPrivateKeyKey]) == 0 {
+			return "", fmt.Errorf(
+				"TLS Secret %s/%s must have %s and %s",
+				ns,
+				tls.SecretName,
+				corev1.TLSCertKey,
+				corev1.TLSPrivateKeyKey,
+			)
+		}
+	}
+	_, hasClientCA := tlsData[corev1.ServiceAccountRootCAKey]
+	configFile, hash, err := renderInterceptorConfigFile(
+		interceptorConfigValues(interceptorCfg.Spec, hasClientCA),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+		Namespace: ns,
+		Name:      rec.InterceptorConfig.ConfigMapName(),
+	}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, rec.Client, cm, func() error {
+		cm.SetOwnerReferences([]metav1.OwnerReference{ownerRef})
+		cm.Data = map[string]string{interceptorConfigFileKey: configFile}
+		return nil
+	}); err != nil {
+		return "", fmt.Errorf("rendering ConfigMap: %w", err)
+	}
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Namespace: ns,
+		Name:      rec.InterceptorConfig.TLSSecretName(),
+	}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, rec.Client, secret, func() error {
+		secret.SetOwnerReferences([]metav1.OwnerReference{ownerRef})
+		secret.Data = tlsData
+		return nil
+	}); err != nil {
+		return "", fmt.Errorf("rendering Secret: %w", err)
+	}
+	return hash, nil
+}
+
+// rollOutInterceptorConfig mounts the rendered configuration into the
+// interceptor's Deployment, with hash on its pod template, and records
+// how far the rollout got in interceptorCfg's status
+func (rec *HTTPInterceptorConfigReconciler) rollOutInterceptorConfig(
+	ctx context.Context,
+	logger logr.Logger,
+	interceptorCfg *httpv1alpha1.HTTPInterceptorConfig,
+	hash string,
+) error {
+	depl := &appsv1.Deployment{}
+	if err := rec.Client.Get(ctx, rec.interceptorDeploymentKey(), depl); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		interceptorCfg.Status.UpdatedReplicas = 0
+		meta.SetStatusCondition(&interceptorCfg.Status.Conditions, metav1.Condition{
+			Type:    httpv1alpha1.InterceptorConfigRolledOut,
+			Status:  metav1.ConditionFalse,
+			Reason:  "DeploymentNotFound",
+			Message: fmt.Sprintf("the interceptor's Deployment %s wasn't found", rec.interceptorDeploymentKey()),
+		})
+		return nil
+	}
+	if mountInterceptorConfig(
+		depl,
+		rec.InterceptorConfig.ConfigMapName(),
+		rec.InterceptorConfig.TLSSecretName(),
+		hash,
+	) {
+		logger.Info("rolling out interceptor configuration", "configHash", hash)
+		if err := rec.Client.Update(ctx, depl); err != nil {
+			return err
+		}
+	}
+	rolledOut, updated := interceptorRolledOut(depl)
+	interceptorCfg.Status.UpdatedReplicas = updated
+	if !rolledOut {
+		meta.SetStatusCondition(&interceptorCfg.Status.Conditions, metav1.Condition{
+			Type:    httpv1alpha1.InterceptorConfigRolledOut,
+			Status:  metav1.ConditionFalse,
+			Reason:  "RollingOut",
+			Message: fmt.Sprintf("%d interceptor replicas run with configuration %s", updated, hash),
+		})
+		return nil
+	}
+	meta.SetStatusCondition(&interceptorCfg.Status.Conditions, metav1.Condition{
+		Type:    httpv1alpha1.InterceptorConfigRolledOut,
+		Status:  metav1.ConditionTrue,
+		Reason:  "RolledOut",
+		Message: fmt.Sprintf("every interceptor replica runs with configuration %s", hash),
+	})
+	return nil
+}
+
+// unmountInterceptorConfig takes the rendered configuration back out
+// of the interceptor's Deployment, if it's there
+func (rec *HTTPInterceptorConfigReconciler) unmountInterceptorConfig(ctx context.Context) error {
+	depl := &appsv1.Deployment{}
+	if err := rec.Client.Get(ctx, rec.interceptorDeploymentKey(), depl); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if !unmountInterceptorConfig(depl) {
+		return nil
+	}
+	return rec.Client.Update(ctx, depl)
+}
+
+func (rec *HTTPInterceptorConfigReconciler) interceptorDeploymentKey() client.ObjectKey {
+	return client.ObjectKey{
+		Namespace: rec.InterceptorConfig.Namespace,
+		Name:      rec.InterceptorConfig.DeploymentName,
+	}
+}
+
+// SetupWithManager starts up reconciliation with the given manager
+func (rec *HTTPInterceptorConfigReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// the interceptor's Deployment and the Secrets in its namespace
+	// aren't owned by the HTTPInterceptorConfig, so they're mapped
+	// to it: the Deployment for rollout progress, and the Secrets
+	// for certificate rotations
+	toConfig := handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+		if obj.GetNamespace() != rec.InterceptorConfig.Namespace {
+			return nil
+		}
+		if _, isDepl := obj.(*appsv1.Deployment); isDepl &&
+			obj.GetName() != rec.InterceptorConfig.DeploymentName {
+			return nil
+		}
+		return []reconcile.Request{{
+			NamespacedName: types.NamespacedName{Name: httpv1alpha1.HTTPInterceptorConfigName},
+		}}
+	})
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&httpv1alpha1.HTTPInterceptorConfig{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Owns(&corev1.ConfigMap{}).
+		Watches(&source.Kind{Type: &appsv1.Deployment{}}, toConfig).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, toConfig).
+		Complete(rec)
+}
@@ -0,0 +1,192 @@
+package controllers
+
+import (
+	"context"
+
+	logrtest "github.com/go-logr/logr/testing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
+
+	"github.com/kedacore/http-add-on/operator/api/v1alpha1"
+	"github.com/kedacore/http-add-on/operator/controllers/config"
+)
+
+var _ = Describe("HTTPInterceptorConfig", func() {
+	Context("Rendering and rolling out the interceptor's configuration", func() {
+		var (
+			ctx   context.Context
+			cl    client.Client
+			rec   *HTTPInterceptorConfigReconciler
+			req   ctrl.Request
+			depl  *appsv1.Deployment
+			icfg  *v1alpha1.HTTPInterceptorConfig
+			deplK client.ObjectKey
+		)
+		BeforeEach(func() {
+			ctx = context.Background()
+			cl = fake.NewFakeClient()
+			rec = &HTTPInterceptorConfigReconciler{
+				Client: cl,
+				Log:    logrtest.NullLogger{},
+				InterceptorConfig: config.Interceptor{
+					DeploymentName: "interceptor",
+					Namespace:      "keda",
+				},
+			}
+			req = ctrl.Request{
+				NamespacedName: types.NamespacedName{Name: v1alpha1.HTTPInterceptorConfigName},
+			}
+			replicas := int32(2)
+			depl = &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "keda", Name: "interceptor"},
+				Spec: appsv1.DeploymentSpec{
+					Replicas: &replicas,
+					Template: corev1.PodTemplateSpec{
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{{
+								Name: "interceptor",
+								Env:  []corev1.EnvVar{{Name: "KEDA_HTTP_PROXY_PORT", Value: "8080"}},
+							}},
+						},
+					},
+				},
+			}
+			deplK = client.ObjectKeyFromObject(depl)
+			Expect(cl.Create(ctx, depl)).To(BeNil())
+			icfg = &v1alpha1.HTTPInterceptorConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: v1alpha1.HTTPInterceptorConfigName},
+				Spec: v1alpha1.HTTPInterceptorConfigSpec{
+					Timeouts: &v1alpha1.InterceptorTimeouts{ConnectMS: 250},
+					Limits: &v1alpha1.InterceptorLimits{
+						MaxPendingRequests:            1000,
+						SoftMaxPendingRequestsPerHost: 100,
+					},
+					TLS:     &v1alpha1.InterceptorTLS{SecretName: "certs"},
+					Logging: &v1alpha1.InterceptorLogging{Level: "debug"},
+				},
+			}
+		})
+
+		getConfigFile := func() map[string]string {
+			cm := &corev1.ConfigMap{}
+			Expect(cl.Get(ctx, client.ObjectKey{
+				Namespace: "keda",
+				Name:      "interceptor-config",
+			}, cm)).To(BeNil())
+			ret := map[string]string{}
+			Expect(yaml.Unmarshal([]byte(cm.Data[interceptorConfigFileKey]), &ret)).To(BeNil())
+			return ret
+		}
+
+		It("Should render the configuration, mount it and track the rollout", func() {
+			Expect(cl.Create(ctx, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "keda", Name: "certs"},
+				Data: map[string][]byte{
+					corev1.TLSCertKey:       []byte("cert"),
+					corev1.TLSPrivateKeyKey: []byte("key"),
+				},
+			})).To(BeNil())
+			Expect(cl.Create(ctx, icfg)).To(BeNil())
+
+			_, err := rec.Reconcile(ctx, req)
+			Expect(err).To(BeNil())
+
+			Expect(getConfigFile()).To(Equal(map[string]string{
+				"KEDA_HTTP_CONNECT_TIMEOUT":                    "250ms",
+				"KEDA_HTTP_MAX_PENDING_REQUESTS":               "1000",
+				"KEDA_HTTP_SOFT_MAX_PENDING_REQUESTS_PER_HOST": "100",
+				"KEDA_HTTP_PROXY_TLS_CERT_FILE":                "/etc/keda-http/tls/tls.crt",
+				"KEDA_HTTP_PROXY_TLS_KEY_FILE":                 "/etc/keda-http/tls/tls.key",
+				"KEDA_HTTP_LOG_LEVEL":                          "debug",
+			}))
+			secret := &corev1.Secret{}
+			Expect(cl.Get(ctx, client.ObjectKey{
+				Namespace: "keda",
+				Name:      "interceptor-tls",
+			}, secret)).To(BeNil())
+			Expect(secret.Data).To(HaveKeyWithValue(corev1.TLSCertKey, []byte("cert")))
+			Expect(secret.GetOwnerReferences()).To(HaveLen(1))
+			Expect(secret.GetOwnerReferences()[0].Kind).To(Equal("HTTPInterceptorConfig"))
+
+			Expect(cl.Get(ctx, deplK, depl)).To(BeNil())
+			tmpl := depl.Spec.Template
+			Expect(cl.Get(ctx, client.ObjectKeyFromObject(icfg), icfg)).To(BeNil())
+			Expect(icfg.Status.ConfigHash).ToNot(BeEmpty())
+			Expect(tmpl.Annotations).To(HaveKeyWithValue(
+				interceptorConfigHashAnnotation,
+				icfg.Status.ConfigHash,
+			))
+			Expect(tmpl.Spec.Volumes).To(HaveLen(2))
+			Expect(tmpl.Spec.Containers[0].VolumeMounts).To(HaveLen(2))
+			Expect(tmpl.Spec.Containers[0].Env).To(ContainElement(corev1.EnvVar{
+				Name:  "KEDA_HTTP_CONFIG_FILE",
+				Value: "/etc/keda-http/config/config.yaml",
+			}))
+			Expect(meta.IsStatusConditionTrue(
+				icfg.Status.Conditions,
+				v1alpha1.InterceptorConfigRendered,
+			)).To(BeTrue())
+			Expect(meta.IsStatusConditionFalse(
+				icfg.Status.Conditions,
+				v1alpha1.InterceptorConfigRolledOut,
+			)).To(BeTrue())
+
+			// the Deployment finishes rolling out
+			depl.Status = appsv1.DeploymentStatus{
+				ObservedGeneration: depl.Generation,
+				Replicas:           2,
+				UpdatedReplicas:    2,
+				AvailableReplicas:  2,
+			}
+			Expect(cl.Status().Update(ctx, depl)).To(BeNil())
+			_, err = rec.Reconcile(ctx, req)
+			Expect(err).To(BeNil())
+			Expect(cl.Get(ctx, client.ObjectKeyFromObject(icfg), icfg)).To(BeNil())
+			Expect(icfg.Status.UpdatedReplicas).To(BeNumerically("==", 2))
+			Expect(meta.IsStatusConditionTrue(
+				icfg.Status.Conditions,
+				v1alpha1.InterceptorConfigRolledOut,
+			)).To(BeTrue())
+
+			// deleting the HTTPInterceptorConfig unmounts it
+			Expect(cl.Delete(ctx, icfg)).To(BeNil())
+			_, err = rec.Reconcile(ctx, req)
+			Expect(err).To(BeNil())
+			Expect(cl.Get(ctx, deplK, depl)).To(BeNil())
+			tmpl = depl.Spec.Template
+			Expect(tmpl.Annotations).ToNot(HaveKey(interceptorConfigHashAnnotation))
+			Expect(tmpl.Spec.Volumes).To(BeEmpty())
+			Expect(tmpl.Spec.Containers[0].VolumeMounts).To(BeEmpty())
+			Expect(tmpl.Spec.Containers[0].Env).To(Equal([]corev1.EnvVar{
+				{Name: "KEDA_HTTP_PROXY_PORT", Value: "8080"},
+			}))
+		})
+
+		It("Should not touch the Deployment if the TLS Secret is missing", func() {
+			Expect(cl.Create(ctx, icfg)).To(BeNil())
+
+			_, err := rec.Reconcile(ctx, req)
+			Expect(err).ToNot(BeNil())
+
+			Expect(cl.Get(ctx, client.ObjectKeyFromObject(icfg), icfg)).To(BeNil())
+			cond := meta.FindStatusCondition(
+				icfg.Status.Conditions,
+				v1alpha1.InterceptorConfigRendered,
+			)
+			Expect(cond).ToNot(BeNil())
+			Expect(cond.Status).To(Equal(metav1.ConditionFalse))
+			Expect(cond.Reason).To(Equal("ErrorRenderingConfig"))
+			Expect(cl.Get(ctx, deplK, depl)).To(BeNil())
+			Expect(depl.Spec.Template.Annotations).ToNot(HaveKey(interceptorConfigHashAnnotation))
+		})
+	})
+})
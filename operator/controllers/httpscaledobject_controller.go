@@ -18,12 +18,18 @@ package controllers
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -43,12 +49,23 @@ type HTTPScaledObjectReconciler struct {
 	ExternalScalerConfig config.ExternalScaler
 	BaseConfig           config.Base
 	RoutingTable         *routing.Table
+	// Namespaces restricts the namespaces whose HTTPScaledObjects
+	// are reconciled. The zero value reconciles all of them
+	Namespaces config.Namespaces
+	// Recorder records Events on HTTPScaledObjects. nil means
+	// none are recorded
+	Recorder record.EventRecorder
+	// DryRun makes the reconciler record the changes that it would
+	// make for every HTTPScaledObject in their DryRun conditions,
+	// instead of making them, like the DryRunAnnotation does for one
+	DryRun bool
 }
 
 // +kubebuilder:rbac:groups=keda.sh,resources=scaledobjects,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=http.keda.sh,resources=httpscaledobjects,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=http.keda.sh,resources=httpscaledobjects/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups="",resources=pods;services;configmaps;endpoints;endpoint,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 // +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;delete
 // +kubebuilder:rbac:groups=networking,resources=ingresses,verbs=get;list;watch;create;delete
 // +kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;create;update;delete
@@ -80,7 +97,7 @@ func (rec *HTTPScaledObjectReconciler) Reconcile(ctx context.Context, req ctrl.R
 	}
 
 	appInfo := config.AppInfo{
-		Name:                 httpso.Spec.ScaleTargetRef.Deployment,
+		Name:                 httpso.Spec.ScaleTargetRef.WorkloadName(),
 		Namespace:            req.Namespace,
 		InterceptorConfig:    rec.InterceptorConfig,
 		ExternalScalerConfig: rec.ExternalScalerConfig,
@@ -88,6 +105,9 @@ func (rec *HTTPScaledObjectReconciler) Reconcile(ctx context.Context, req ctrl.R
 
 	if httpso.GetDeletionTimestamp() != nil {
 		logger.Info("Deletion timestamp found", "httpscaledobject", *httpso)
+		if rec.dryRun(httpso) {
+			return ctrl.Result{}, rec.dryRunRemoval(ctx, logger, appInfo, httpso)
+		}
 		// if it was marked deleted, delete all the related objects
 		// and don't schedule for another reconcile. Kubernetes
 		// will finalize them
@@ -101,10 +121,60 @@ func (rec *HTTPScaledObjectReconciler) Reconcile(ctx context.Context, req ctrl.R
 				RequeueAfter: 1000 * time.Millisecond,
 			}, removeErr
 		}
-		// after we've deleted app objects, we can finalize
+		// the finalizer stays until everything that was deleted is
+		// verifiably gone, so that nothing outlives the HTTPScaledObject
+		remaining, err := remainingResources(ctx, rec.Client, rec.RoutingTable, appInfo, httpso)
+		if err != nil {
+			logger.Error(err, "Verifying removal of application objects")
+			httpso.SetCondition(
+				httpv1alpha1.ResourcesRemoved,
+				v1.ConditionUnknown,
+				httpv1alpha1.ErrorVerifyingRemoval,
+				err.Error(),
+			)
+			httpso.SaveStatus(ctx, logger, rec.Client)
+			// the error requeues with the controller's backoff
+			return ctrl.Result{}, err
+		}
+		if len(remaining) > 0 {
+			msg := fmt.Sprintf("Waiting for removal of %s", strings.Join(remaining, ", "))
+			logger.Info(msg)
+			httpso.SetCondition(
+				httpv1alpha1.ResourcesRemoved,
+				v1.ConditionFalse,
+				httpv1alpha1.ResourcesRemaining,
+				msg,
+			)
+			httpso.SaveStatus(ctx, logger, rec.Client)
+			rec.recordEvent(httpso, corev1.EventTypeNormal, string(httpv1alpha1.ResourcesRemaining), msg)
+			// requeueing without an error still backs off
+			// exponentially, until the resources are gone
+			return ctrl.Result{Requeue: true}, nil
+		}
+		httpso.SetCondition(
+			httpv1alpha1.ResourcesRemoved,
+			v1.ConditionTrue,
+			httpv1alpha1.AllResourcesRemoved,
+			"Routing table entry and ScaledObjects removed",
+		)
+		httpso.SaveStatus(ctx, logger, rec.Client)
+		rec.recordEvent(
+			httpso,
+			corev1.EventTypeNormal,
+			string(httpv1alpha1.AllResourcesRemoved),
+			"Routing table entry and ScaledObjects removed",
+		)
+		// after we've verified that app objects are gone, we can finalize
 		return ctrl.Result{}, finalizeScaledObject(ctx, logger, rec.Client, httpso)
 	}
 
+	// in dry-run mode, nothing but the status is written, not even
+	// the finalizer
+	if rec.dryRun(httpso) {
+		logger.Info("Dry run, recording changes without applying them")
+		return ctrl.Result{}, rec.dryRunApplicationResources(ctx, logger, appInfo, httpso)
+	}
+
 	// ensure finalizer is set on this resource
 	if err := ensureFinalizer(ctx, logger, rec.Client, httpso); err != nil {
 		return ctrl.Result{}, err
@@ -115,10 +185,11 @@ func (rec *HTTPScaledObjectReconciler) Reconcile(ctx context.Context, req ctrl.R
 		"Reconciling HTTPScaledObject",
 		"Namespace",
 		req.Namespace,
-		"DeploymentName",
+		"WorkloadKind",
+		httpso.Spec.ScaleTargetRef.WorkloadKind(),
+		"WorkloadName",
 		appInfo.Name,
 	)
-
 	// Create required app objects for the application defined by the CRD
 	if err := rec.createOrUpdateApplicationResources(
 		ctx,
@@ -138,17 +209,50 @@ func (rec *HTTPScaledObjectReconciler) Reconcile(ctx context.Context, req ctrl.R
 
 		return ctrl.Result{}, err
 	}
-	httpso.AddCondition(*httpv1alpha1.CreateCondition(httpv1alpha1.Ready, v1.ConditionTrue, httpv1alpha1.HTTPScaledObjectIsReady).SetMessage("Finished object creation")).
-		SaveStatus(ctx, logger, rec.Client)
 
 	// success reconciling
 	logger.Info("Reconcile success")
+	if !httpso.IsConditionTrue(httpv1alpha1.TargetWorkloadFound) &&
+		!isTargetWorkloadForbidden(httpso) {
+		// the operator doesn't watch deployments, so check back
+		// later to see whether the target deployment was created.
+		// It doesn't while it's forbidden from getting it, since
+		// that only changes with its RBAC
+		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+	}
 	return ctrl.Result{}, nil
 }
 
+// recordEvent records an Event on httpso, if rec has a Recorder
+func (rec *HTTPScaledObjectReconciler) recordEvent(
+	httpso *httpv1alpha1.HTTPScaledObject,
+	eventType,
+	reason,
+	message string,
+) {
+	recordEvent(rec.Recorder, httpso, eventType, reason, message)
+}
+
 // SetupWithManager starts up reconciliation with the given manager
 func (rec *HTTPScaledObjectReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// watch the ScaledObjects that HTTPScaledObjects own, so that
+	// edits to or deletions of them get reconciled back
+	scaledObject := &unstructured.Unstructured{}
+	scaledObject.SetGroupVersionKind(schema.GroupVersionKind{
+		Group:   "keda.sh",
+		Kind:    "ScaledObject",
+		Version: "v1alpha1",
+	})
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&httpv1alpha1.HTTPScaledObject{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		// annotations don't change the generation, but the
+		// paused replicas annotation needs to be reconciled
+		For(&httpv1alpha1.HTTPScaledObject{}, builder.WithPredicates(predicate.Or(
+			predicate.GenerationChangedPredicate{},
+			predicate.AnnotationChangedPredicate{},
+		))).
+		Owns(scaledObject, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		WithEventFilter(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			return rec.Namespaces.Allowed(obj.GetNamespace())
+		})).
 		Complete(rec)
 }
@@ -2,15 +2,21 @@ package controllers
 
 import (
 	"context"
+	"fmt"
+	"reflect"
+	"strings"
 
 	"github.com/go-logr/logr"
 	"github.com/kedacore/http-add-on/operator/api/v1alpha1"
 	"github.com/kedacore/http-add-on/operator/controllers/config"
 	"github.com/kedacore/http-add-on/pkg/routing"
+	corev1 "k8s.io/api/core/v1"
 	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 func (rec *HTTPScaledObjectReconciler) removeApplicationResources(
@@ -22,11 +28,13 @@ func (rec *HTTPScaledObjectReconciler) removeApplicationResources(
 
 	defer httpso.SaveStatus(context.Background(), logger, rec.Client)
 	// Set initial statuses
-	httpso.AddCondition(*v1alpha1.CreateCondition(
-		v1alpha1.Terminating,
-		v1.ConditionUnknown,
+	httpso.SetCondition(
+		v1alpha1.Ready,
+		v1.ConditionFalse,
 		v1alpha1.TerminatingResources,
-	).SetMessage("Received termination signal"))
+		"Received termination signal",
+	)
+	httpso.RemoveCondition(v1alpha1.DryRun)
 
 	logger = rec.Log.WithValues(
 		"reconciler.appObjects",
@@ -51,19 +59,31 @@ func (rec *HTTPScaledObjectReconciler) removeApplicationResources(
 			logger.Info("App ScaledObject not found, moving on")
 		} else {
 			logger.Error(err, "Deleting scaledobject")
-			httpso.AddCondition(*v1alpha1.CreateCondition(
-				v1alpha1.Error,
-				v1.ConditionFalse,
+			httpso.SetCondition(
+				v1alpha1.ScaledObjectCreated,
+				v1.ConditionUnknown,
 				v1alpha1.AppScaledObjectTerminationError,
-			).SetMessage(err.Error()))
+				err.Error(),
+			)
 			return err
 		}
 	}
-	httpso.AddCondition(*v1alpha1.CreateCondition(
-		v1alpha1.Terminated,
-		v1.ConditionTrue,
+	if err := deleteCanaryScaledObject(ctx, rec.Client, appInfo, httpso); err != nil {
+		logger.Error(err, "Deleting canary scaledobject")
+		httpso.SetCondition(
+			v1alpha1.ScaledObjectCreated,
+			v1.ConditionUnknown,
+			v1alpha1.AppScaledObjectTerminationError,
+			err.Error(),
+		)
+		return err
+	}
+	httpso.SetCondition(
+		v1alpha1.ScaledObjectCreated,
+		v1.ConditionFalse,
 		v1alpha1.AppScaledObjectTerminated,
-	))
+		"App ScaledObject deleted",
+	)
 
 	if err := removeAndUpdateRoutingTable(
 		ctx,
@@ -75,6 +95,12 @@ func (rec *HTTPScaledObjectReconciler) removeApplicationResources(
 	); err != nil {
 		return err
 	}
+	httpso.SetCondition(
+		v1alpha1.RoutingConfigured,
+		v1.ConditionFalse,
+		v1alpha1.TerminatingResources,
+		"Host removed from the routing table",
+	)
 
 	return nil
 }
@@ -86,6 +112,31 @@ func (rec *HTTPScaledObjectReconciler) createOrUpdateApplicationResources(
 	httpso *v1alpha1.HTTPScaledObject,
 ) error {
 	defer httpso.SaveStatus(context.Background(), logger, rec.Client)
+	// deferred calls run in reverse order, so Ready is
+	// updated from the other conditions before saving
+	defer setReadyCondition(httpso)
+	// the operator requeues while the workload is missing, so only
+	// record an Event when it goes missing
+	wasMissing := isTargetWorkloadMissing(httpso)
+	wasForbidden := isTargetWorkloadForbidden(httpso)
+	defer func() {
+		if !wasMissing && isTargetWorkloadMissing(httpso) {
+			rec.recordEvent(
+				httpso,
+				corev1.EventTypeWarning,
+				string(v1alpha1.TargetDeploymentNotFound),
+				httpso.GetCondition(v1alpha1.TargetWorkloadFound).Message,
+			)
+		}
+		if !wasForbidden && isTargetWorkloadForbidden(httpso) {
+			rec.recordEvent(
+				httpso,
+				corev1.EventTypeWarning,
+				string(v1alpha1.TargetWorkloadForbidden),
+				httpso.GetCondition(v1alpha1.TargetWorkloadFound).Message,
+			)
+		}
+	}()
 	logger = rec.Log.WithValues(
 		"reconciler.appObjects",
 		"addObjects",
@@ -95,12 +146,101 @@ func (rec *HTTPScaledObjectReconciler) createOrUpdateApplicationResources(
 		appInfo.Namespace,
 	)
 
+	// changes are applied again, so the ones that were recorded
+	// in dry-run mode don't apply anymore
+	httpso.RemoveCondition(v1alpha1.DryRun)
+
 	// set initial statuses
-	httpso.AddCondition(*v1alpha1.CreateCondition(
-		v1alpha1.Pending,
-		v1.ConditionUnknown,
-		v1alpha1.PendingCreation,
-	).SetMessage("Identified HTTPScaledObject creation signal"))
+	if httpso.GetCondition(v1alpha1.Ready) == nil {
+		httpso.SetCondition(
+			v1alpha1.Ready,
+			v1.ConditionUnknown,
+			v1alpha1.PendingCreation,
+			"Identified HTTPScaledObject creation signal",
+		)
+	}
+
+	if _, err := httpso.FaultInjection(); err != nil {
+		// unlike a typo in the paused replicas annotation, a typo
+		// here can't do any harm, so the annotations are ignored
+		logger.Error(err, "ignoring fault injection annotations")
+		rec.recordEvent(
+			httpso,
+			corev1.EventTypeWarning,
+			eventReasonInvalidFaultInjection,
+			fmt.Sprintf("Ignoring fault injection annotations: %s", err),
+		)
+	}
+
+	// a typo in the revision would send requests to every revision,
+	// so nothing changes until it's fixed, like with the paused
+	// replicas annotation
+	if _, err := httpso.RevisionPin(); err != nil {
+		logger.Error(err, "not reconciling until the annotation is fixed")
+		httpso.SetCondition(
+			v1alpha1.RoutingConfigured,
+			v1.ConditionFalse,
+			v1alpha1.InvalidRevision,
+			err.Error(),
+		)
+		rec.recordEvent(
+			httpso,
+			corev1.EventTypeWarning,
+			string(v1alpha1.InvalidRevision),
+			fmt.Sprintf("Not reconciling until the annotation is fixed: %s", err),
+		)
+		return nil
+	}
+
+	external, err := isExternalBackend(ctx, rec.Client, appInfo, httpso)
+	if err != nil {
+		return err
+	}
+	if external {
+		// the interceptor still routes to the backend, but there's
+		// nothing to scale
+		return rec.createOrUpdateExternalBackend(ctx, logger, appInfo, httpso)
+	}
+	if appInfo.Name == "" {
+		// there's nothing to scale, and nothing to route to until
+		// the service becomes an ExternalName one
+		logger.Info("scaleTargetRef has neither name nor deployment set, not reconciling")
+		httpso.SetCondition(
+			v1alpha1.TargetWorkloadFound,
+			v1.ConditionFalse,
+			v1alpha1.TargetDeploymentNotFound,
+			"scaleTargetRef has neither name nor deployment set",
+		)
+		return nil
+	}
+
+	if err := checkTargetWorkload(ctx, rec.Client, appInfo, httpso); err != nil {
+		return err
+	}
+
+	// leave everything as it is until the annotation is fixed, so that
+	// a typo doesn't resume autoscaling in the middle of an incident
+	if _, err := httpso.PausedReplicas(); err != nil {
+		logger.Error(err, "not reconciling until the annotation is fixed")
+		httpso.SetCondition(
+			v1alpha1.ScaledObjectCreated,
+			v1.ConditionFalse,
+			v1alpha1.InvalidPausedReplicas,
+			err.Error(),
+		)
+		rec.recordEvent(
+			httpso,
+			corev1.EventTypeWarning,
+			string(v1alpha1.InvalidPausedReplicas),
+			fmt.Sprintf("Not reconciling until the annotation is fixed: %s", err),
+		)
+		return nil
+	}
+
+	target := routing.NewTargetFromHTTPScaledObject(
+		httpso,
+		rec.BaseConfig.TargetPendingRequests,
+	)
 
 	// create the KEDA core ScaledObjects (not the HTTP one) for
 	// the app deployment and the interceptor deployment.
@@ -112,31 +252,251 @@ func (rec *HTTPScaledObjectReconciler) createOrUpdateApplicationResources(
 		rec.Client,
 		logger,
 		appInfo.ExternalScalerConfig.HostName(appInfo.Namespace),
+		target.TargetPendingRequests,
 		httpso,
+		rec.Recorder,
 	); err != nil {
 		return err
 	}
 
-	targetPendingReqs := httpso.Spec.TargetPendingRequests
-	if targetPendingReqs == 0 {
-		targetPendingReqs = rec.BaseConfig.TargetPendingRequests
+	return rec.addRoute(ctx, logger, httpso, target)
+}
+
+// createOrUpdateExternalBackend routes httpso's host to a backend that
+// has no workload to scale, like a URL outside the cluster or an
+// ExternalName service. Any ScaledObjects left over from when httpso
+// had a workload are deleted
+func (rec *HTTPScaledObjectReconciler) createOrUpdateExternalBackend(
+	ctx context.Context,
+	logger logr.Logger,
+	appInfo config.AppInfo,
+	httpso *v1alpha1.HTTPScaledObject,
+) error {
+	httpso.SetCondition(
+		v1alpha1.TargetWorkloadFound,
+		v1.ConditionTrue,
+		v1alpha1.ExternalBackend,
+		"External backend, there's no workload to scale",
+	)
+	if err := deleteScaledObjects(ctx, rec.Client, appInfo, httpso); err != nil {
+		logger.Error(err, "Deleting ScaledObjects of external backend")
+		httpso.SetCondition(
+			v1alpha1.ScaledObjectCreated,
+			v1.ConditionFalse,
+			v1alpha1.AppScaledObjectTerminationError,
+			err.Error(),
+		)
+		return err
 	}
+	httpso.SetCondition(
+		v1alpha1.ScaledObjectCreated,
+		v1.ConditionTrue,
+		v1alpha1.ExternalBackend,
+		"External backend, no ScaledObject needed",
+	)
+	target := routing.NewTargetFromHTTPScaledObject(
+		httpso,
+		rec.BaseConfig.TargetPendingRequests,
+	)
+	return rec.addRoute(ctx, logger, httpso, target)
+}
 
+// addRoute adds httpso's host to the routing table, pointing at
+// target, and sets the RoutingConfigured condition accordingly. An
+// Event is recorded if the host's entry in the routing table changed
+func (rec *HTTPScaledObjectReconciler) addRoute(
+	ctx context.Context,
+	logger logr.Logger,
+	httpso *v1alpha1.HTTPScaledObject,
+	target routing.Target,
+) error {
+	prev, lookupErr := rec.RoutingTable.Lookup(
+		routing.NamespacedHost(httpso.ObjectMeta.Namespace, httpso.Spec.Host),
+	)
 	if err := addAndUpdateRoutingTable(
 		ctx,
 		logger,
 		rec.Client,
 		rec.RoutingTable,
 		httpso.Spec.Host,
-		routing.NewTarget(
-			httpso.Spec.ScaleTargetRef.Service,
-			int(httpso.Spec.ScaleTargetRef.Port),
-			httpso.Spec.ScaleTargetRef.Deployment,
-			targetPendingReqs,
-		),
+		target,
 		httpso.ObjectMeta.Namespace,
 	); err != nil {
+		httpso.SetCondition(
+			v1alpha1.RoutingConfigured,
+			v1.ConditionFalse,
+			v1alpha1.ErrorUpdatingRoutingTable,
+			err.Error(),
+		)
+		rec.recordEvent(
+			httpso,
+			corev1.EventTypeWarning,
+			string(v1alpha1.ErrorUpdatingRoutingTable),
+			fmt.Sprintf("Updating the routing table: %s", err),
+		)
+		return err
+	}
+	httpso.SetCondition(
+		v1alpha1.RoutingConfigured,
+		v1.ConditionTrue,
+		v1alpha1.RoutingTableUpdated,
+		"Host added to the routing table",
+	)
+	if lookupErr != nil {
+		rec.recordEvent(
+			httpso,
+			corev1.EventTypeNormal,
+			string(v1alpha1.RoutingTableUpdated),
+			fmt.Sprintf("Host %s added to the routing table", httpso.Spec.Host),
+		)
+	} else if !reflect.DeepEqual(prev, target) {
+		rec.recordEvent(
+			httpso,
+			corev1.EventTypeNormal,
+			string(v1alpha1.RoutingTableUpdated),
+			fmt.Sprintf("Host %s updated in the routing table", httpso.Spec.Host),
+		)
+	}
+	return nil
+}
+
+// isExternalBackend returns true if httpso routes to a backend that has
+// no workload to scale. That's either a URL outside the cluster, or an
+// ExternalName service when the scaleTargetRef doesn't name a workload
+func isExternalBackend(
+	ctx context.Context,
+	cl client.Client,
+	appInfo config.AppInfo,
+	httpso *v1alpha1.HTTPScaledObject,
+) (bool, error) {
+	scaleTargetRef := httpso.Spec.ScaleTargetRef
+	if scaleTargetRef.IsExternal() {
+		return true, nil
+	}
+	if appInfo.Name != "" || scaleTargetRef.Service == "" {
+		return false, nil
+	}
+	svc := &corev1.Service{}
+	err := cl.Get(ctx, client.ObjectKey{
+		Namespace: appInfo.Namespace,
+		Name:      scaleTargetRef.Service,
+	}, svc)
+	if apierrs.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return svc.Spec.Type == corev1.ServiceTypeExternalName, nil
+}
+
+// checkTargetWorkload sets the TargetWorkloadFound condition on httpso
+// according to whether the workload to scale exists. A missing
+// workload is not an error, since it may be created later.
+//
+// The operator only has RBAC access to Deployments by default, so a
+// forbidden error for any other kind isn't an error either. The
+// condition is just Unknown
+func checkTargetWorkload(
+	ctx context.Context,
+	cl client.Client,
+	appInfo config.AppInfo,
+	httpso *v1alpha1.HTTPScaledObject,
+) error {
+	scaleTargetRef := httpso.Spec.ScaleTargetRef
+	kind := scaleTargetRef.WorkloadKind()
+	workload := &unstructured.Unstructured{}
+	workload.SetAPIVersion(scaleTargetRef.WorkloadAPIVersion())
+	workload.SetKind(kind)
+	err := cl.Get(ctx, client.ObjectKey{
+		Namespace: appInfo.Namespace,
+		Name:      appInfo.Name,
+	}, workload)
+	if apierrs.IsNotFound(err) || meta.IsNoMatchError(err) {
+		httpso.SetCondition(
+			v1alpha1.TargetWorkloadFound,
+			v1.ConditionFalse,
+			v1alpha1.TargetDeploymentNotFound,
+			fmt.Sprintf("%s %s not found", kind, appInfo.Name),
+		)
+		return nil
+	} else if apierrs.IsForbidden(err) {
+		// getting it again won't work until the operator's
+		// ClusterRole allows it, so this is terminal
+		httpso.SetCondition(
+			v1alpha1.TargetWorkloadFound,
+			v1.ConditionFalse,
+			v1alpha1.TargetWorkloadForbidden,
+			fmt.Sprintf(
+				"the operator isn't allowed to get %s %s. Grant its ClusterRole get on %s, then update the HTTPScaledObject (%s)",
+				kind,
+				appInfo.Name,
+				kind,
+				err,
+			),
+		)
+		return nil
+	} else if err != nil {
+		httpso.SetCondition(
+			v1alpha1.TargetWorkloadFound,
+			v1.ConditionUnknown,
+			v1alpha1.ErrorGettingTargetDeployment,
+			err.Error(),
+		)
 		return err
 	}
+	httpso.SetCondition(
+		v1alpha1.TargetWorkloadFound,
+		v1.ConditionTrue,
+		v1alpha1.TargetDeploymentFound,
+		fmt.Sprintf("%s %s found", kind, appInfo.Name),
+	)
 	return nil
 }
+
+// isTargetWorkloadMissing returns true if httpso's TargetWorkloadFound
+// condition says that its workload doesn't exist
+func isTargetWorkloadMissing(httpso *v1alpha1.HTTPScaledObject) bool {
+	cond := httpso.GetCondition(v1alpha1.TargetWorkloadFound)
+	return cond != nil &&
+		cond.Status == v1.ConditionFalse &&
+		cond.Reason == string(v1alpha1.TargetDeploymentNotFound)
+}
+
+// isTargetWorkloadForbidden returns true if httpso's TargetWorkloadFound
+// condition says that the operator isn't allowed to get its workload
+func isTargetWorkloadForbidden(httpso *v1alpha1.HTTPScaledObject) bool {
+	cond := httpso.GetCondition(v1alpha1.TargetWorkloadFound)
+	return cond != nil &&
+		cond.Status == v1.ConditionFalse &&
+		cond.Reason == string(v1alpha1.TargetWorkloadForbidden)
+}
+
+// setReadyCondition sets the Ready condition on httpso to true if all
+// the other conditions are true, and to false otherwise
+func setReadyCondition(httpso *v1alpha1.HTTPScaledObject) {
+	notReady := []string{}
+	for _, condType := range []v1alpha1.HTTPScaledObjectConditionType{
+		v1alpha1.TargetWorkloadFound,
+		v1alpha1.ScaledObjectCreated,
+		v1alpha1.RoutingConfigured,
+	} {
+		if !httpso.IsConditionTrue(condType) {
+			notReady = append(notReady, string(condType))
+		}
+	}
+	if len(notReady) > 0 {
+		httpso.SetCondition(
+			v1alpha1.Ready,
+			v1.ConditionFalse,
+			v1alpha1.HTTPScaledObjectIsNotReady,
+			fmt.Sprintf("Conditions not true: %s", strings.Join(notReady, ", ")),
+		)
+		return
+	}
+	httpso.SetCondition(
+		v1alpha1.Ready,
+		v1.ConditionTrue,
+		v1alpha1.HTTPScaledObjectIsReady,
+		"Finished object creation",
+	)
+}
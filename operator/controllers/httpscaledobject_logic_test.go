@@ -0,0 +1,326 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+
+	"github.com/kedacore/http-add-on/operator/api/v1alpha1"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("HTTPScaledObject conditions", func() {
+	var testInfra *commonTestInfra
+	BeforeEach(func() {
+		testInfra = newCommonTestInfra("testns", "testapp")
+	})
+	It("Should only be Ready when all other conditions are true", func() {
+		httpso := &testInfra.httpso
+
+		// the target deployment doesn't exist yet
+		err := checkTargetWorkload(testInfra.ctx, testInfra.cl, testInfra.cfg, httpso)
+		Expect(err).To(BeNil())
+		Expect(httpso.IsConditionTrue(v1alpha1.TargetWorkloadFound)).To(BeFalse())
+		cond := httpso.GetCondition(v1alpha1.TargetWorkloadFound)
+		Expect(cond).ToNot(BeNil())
+		Expect(cond.Reason).To(Equal(string(v1alpha1.TargetDeploymentNotFound)))
+
+		httpso.SetCondition(
+			v1alpha1.ScaledObjectCreated,
+			metav1.ConditionTrue,
+			v1alpha1.AppScaledObjectCreated,
+			"",
+		).SetCondition(
+			v1alpha1.RoutingConfigured,
+			metav1.ConditionTrue,
+			v1alpha1.RoutingTableUpdated,
+			"",
+		)
+		setReadyCondition(httpso)
+		Expect(httpso.IsConditionTrue(v1alpha1.Ready)).To(BeFalse())
+		Expect(httpso.GetCondition(v1alpha1.Ready).Message).To(ContainSubstring(string(v1alpha1.TargetWorkloadFound)))
+
+		// once the deployment is created, the HTTPScaledObject is Ready
+		Expect(testInfra.cl.Create(testInfra.ctx, &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: testInfra.cfg.Namespace,
+				Name:      testInfra.cfg.Name,
+			},
+		})).To(BeNil())
+		err = checkTargetWorkload(testInfra.ctx, testInfra.cl, testInfra.cfg, httpso)
+		Expect(err).To(BeNil())
+		Expect(httpso.IsConditionTrue(v1alpha1.TargetWorkloadFound)).To(BeTrue())
+		setReadyCondition(httpso)
+		Expect(httpso.IsConditionTrue(v1alpha1.Ready)).To(BeTrue())
+		// each condition type only appears once
+		Expect(len(httpso.Status.Conditions)).To(Equal(4))
+	})
+	It("Should report the target workload for the printer columns", func() {
+		ref := testInfra.httpso.Spec.ScaleTargetRef
+		Expect(ref.Reference()).To(Equal("Deployment/testapp"))
+
+		ref.Kind = "StatefulSet"
+		Expect(ref.Reference()).To(Equal("StatefulSet/testapp"))
+
+		ref.Deployment = ""
+		ref.URL = "https://backend.example.com"
+		Expect(ref.Reference()).To(Equal("https://backend.example.com"))
+
+		var noRef *v1alpha1.ScaleTargetRef
+		Expect(noRef.Reference()).To(BeEmpty())
+	})
+	It("Should look up workloads of any kind in the scaleTargetRef", func() {
+		httpso := &testInfra.httpso
+		httpso.Spec.ScaleTargetRef.APIVersion = "apps/v1"
+		httpso.Spec.ScaleTargetRef.Kind = "StatefulSet"
+
+		// a deployment with the same name doesn't count
+		Expect(testInfra.cl.Create(testInfra.ctx, &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: testInfra.cfg.Namespace,
+				Name:      testInfra.cfg.Name,
+			},
+		})).To(BeNil())
+		err := checkTargetWorkload(testInfra.ctx, testInfra.cl, testInfra.cfg, httpso)
+		Expect(err).To(BeNil())
+		Expect(httpso.IsConditionTrue(v1alpha1.TargetWorkloadFound)).To(BeFalse())
+		Expect(httpso.GetCondition(v1alpha1.TargetWorkloadFound).Message).To(ContainSubstring("StatefulSet"))
+
+		Expect(testInfra.cl.Create(testInfra.ctx, &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: testInfra.cfg.Namespace,
+				Name:      testInfra.cfg.Name,
+			},
+		})).To(BeNil())
+		err = checkTargetWorkload(testInfra.ctx, testInfra.cl, testInfra.cfg, httpso)
+		Expect(err).To(BeNil())
+		Expect(httpso.IsConditionTrue(v1alpha1.TargetWorkloadFound)).To(BeTrue())
+	})
+	It("Should stop checking a workload that the operator isn't allowed to get", func() {
+		httpso := &testInfra.httpso
+		cl := forbiddenGetClient{Client: testInfra.cl}
+		err := checkTargetWorkload(testInfra.ctx, cl, testInfra.cfg, httpso)
+		Expect(err).To(BeNil())
+		cond := httpso.GetCondition(v1alpha1.TargetWorkloadFound)
+		Expect(cond.Status).To(Equal(metav1.ConditionFalse))
+		Expect(cond.Reason).To(Equal(string(v1alpha1.TargetWorkloadForbidden)))
+		Expect(cond.Message).To(ContainSubstring("Deployment"))
+		Expect(isTargetWorkloadForbidden(httpso)).To(BeTrue())
+		Expect(isTargetWorkloadMissing(httpso)).To(BeFalse())
+
+		// it's checked again when the HTTPScaledObject is updated
+		err = checkTargetWorkload(testInfra.ctx, testInfra.cl, testInfra.cfg, httpso)
+		Expect(err).To(BeNil())
+		Expect(isTargetWorkloadForbidden(httpso)).To(BeFalse())
+		Expect(isTargetWorkloadMissing(httpso)).To(BeTrue())
+	})
+	It("Should treat URLs and ExternalName services without a workload as external backends", func() {
+		httpso := &testInfra.httpso
+		cfg := testInfra.cfg
+
+		// a workload is scaled even if its service is an ExternalName
+		Expect(testInfra.cl.Create(testInfra.ctx, &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: cfg.Namespace,
+				Name:      httpso.Spec.ScaleTargetRef.Service,
+			},
+			Spec: corev1.ServiceSpec{
+				Type:         corev1.ServiceTypeExternalName,
+				ExternalName: "legacy.example.com",
+			},
+		})).To(BeNil())
+		external, err := isExternalBackend(testInfra.ctx, testInfra.cl, cfg, httpso)
+		Expect(err).To(BeNil())
+		Expect(external).To(BeFalse())
+
+		httpso.Spec.ScaleTargetRef.Deployment = ""
+		cfg.Name = ""
+		external, err = isExternalBackend(testInfra.ctx, testInfra.cl, cfg, httpso)
+		Expect(err).To(BeNil())
+		Expect(external).To(BeTrue())
+
+		// a service that doesn't exist yet isn't external
+		httpso.Spec.ScaleTargetRef.Service = "nosuchsvc"
+		external, err = isExternalBackend(testInfra.ctx, testInfra.cl, cfg, httpso)
+		Expect(err).To(BeNil())
+		Expect(external).To(BeFalse())
+
+		httpso.Spec.ScaleTargetRef.URL = "https://legacy.example.com"
+		external, err = isExternalBackend(testInfra.ctx, testInfra.cl, cfg, httpso)
+		Expect(err).To(BeNil())
+		Expect(external).To(BeTrue())
+	})
+	It("Should only verify removal once the routing entry and ScaledObjects are gone", func() {
+		httpso := &testInfra.httpso
+		httpso.Spec.Host = "myhost.com"
+		table := routing.NewTable()
+		Expect(testInfra.cl.Create(testInfra.ctx, httpso)).To(BeNil())
+		Expect(createScaledObjects(
+			testInfra.ctx,
+			testInfra.cfg,
+			testInfra.cl,
+			testInfra.logger,
+			"mysvc.myns.svc.cluster.local:9090",
+			100,
+			httpso,
+			nil,
+		)).To(BeNil())
+		Expect(addAndUpdateRoutingTable(
+			testInfra.ctx,
+			testInfra.logger,
+			testInfra.cl,
+			table,
+			httpso.Spec.Host,
+			routing.Target{Service: "testapp", Port: 8081, Deployment: "testapp"},
+			testInfra.ns,
+		)).To(BeNil())
+
+		remaining, err := remainingResources(testInfra.ctx, testInfra.cl, table, testInfra.cfg, httpso)
+		Expect(err).To(BeNil())
+		Expect(remaining).To(ConsistOf(
+			"routing table entry",
+			"ConfigMap keda-http-routing-table entry",
+			"ScaledObject testapp-app",
+		))
+
+		rec := &HTTPScaledObjectReconciler{
+			Client:       testInfra.cl,
+			Log:          testInfra.logger,
+			RoutingTable: table,
+		}
+		Expect(rec.removeApplicationResources(
+			testInfra.ctx,
+			testInfra.logger,
+			testInfra.cfg,
+			httpso,
+		)).To(BeNil())
+		remaining, err = remainingResources(testInfra.ctx, testInfra.cl, table, testInfra.cfg, httpso)
+		Expect(err).To(BeNil())
+		Expect(remaining).To(BeEmpty())
+	})
+	It("Should record Events for the actions it takes", func() {
+		httpso := &testInfra.httpso
+		httpso.Spec.Host = "myhost.com"
+		Expect(testInfra.cl.Create(testInfra.ctx, httpso)).To(BeNil())
+		recorder := record.NewFakeRecorder(10)
+		rec := &HTTPScaledObjectReconciler{
+			Client:       testInfra.cl,
+			Log:          testInfra.logger,
+			RoutingTable: routing.NewTable(),
+			Recorder:     recorder,
+		}
+
+		// the target deployment doesn't exist yet
+		Expect(rec.createOrUpdateApplicationResources(
+			testInfra.ctx,
+			testInfra.logger,
+			testInfra.cfg,
+			httpso,
+		)).To(BeNil())
+		Expect(recorder.Events).To(Receive(Equal("Normal ScaledObjectCreated Created ScaledObject testapp-app")))
+		Expect(recorder.Events).To(Receive(Equal("Normal RoutingTableUpdated Host myhost.com added to the routing table")))
+		Expect(recorder.Events).To(Receive(Equal("Warning TargetDeploymentNotFound Deployment testapp not found")))
+
+		// nothing changed, so nothing is recorded
+		Expect(rec.createOrUpdateApplicationResources(
+			testInfra.ctx,
+			testInfra.logger,
+			testInfra.cfg,
+			httpso,
+		)).To(BeNil())
+		Expect(recorder.Events).ToNot(Receive())
+
+		// an invalid annotation is a validation failure
+		httpso.SetAnnotations(map[string]string{
+			v1alpha1.PausedReplicasAnnotation: "notanumber",
+		})
+		Expect(rec.createOrUpdateApplicationResources(
+			testInfra.ctx,
+			testInfra.logger,
+			testInfra.cfg,
+			httpso,
+		)).To(BeNil())
+		Expect(recorder.Events).To(Receive(HavePrefix("Warning InvalidPausedReplicas")))
+	})
+	It("Should flip the pinned revision in the routing table", func() {
+		httpso := &testInfra.httpso
+		httpso.Spec.Host = "myhost.com"
+		httpso.SetAnnotations(map[string]string{
+			v1alpha1.RevisionAnnotation:      "blue",
+			v1alpha1.RevisionLabelAnnotation: "example.com/color",
+		})
+		Expect(testInfra.cl.Create(testInfra.ctx, httpso)).To(BeNil())
+		recorder := record.NewFakeRecorder(10)
+		rec := &HTTPScaledObjectReconciler{
+			Client:       testInfra.cl,
+			Log:          testInfra.logger,
+			RoutingTable: routing.NewTable(),
+			Recorder:     recorder,
+		}
+		pinned := func() *routing.RevisionPin {
+			target, err := rec.RoutingTable.Lookup(
+				routing.NamespacedHost(testInfra.ns, httpso.Spec.Host),
+			)
+			Expect(err).To(BeNil())
+			return target.Revision
+		}
+
+		Expect(rec.createOrUpdateApplicationResources(
+			testInfra.ctx,
+			testInfra.logger,
+			testInfra.cfg,
+			httpso,
+		)).To(BeNil())
+		Expect(pinned()).To(Equal(&routing.RevisionPin{Label: "example.com/color", Value: "blue"}))
+
+		httpso.GetAnnotations()[v1alpha1.RevisionAnnotation] = "green"
+		Expect(rec.createOrUpdateApplicationResources(
+			testInfra.ctx,
+			testInfra.logger,
+			testInfra.cfg,
+			httpso,
+		)).To(BeNil())
+		Expect(pinned()).To(Equal(&routing.RevisionPin{Label: "example.com/color", Value: "green"}))
+
+		// an invalid revision leaves the routing table as it was
+		httpso.GetAnnotations()[v1alpha1.RevisionAnnotation] = "not a revision"
+		for len(recorder.Events) > 0 {
+			<-recorder.Events
+		}
+		Expect(rec.createOrUpdateApplicationResources(
+			testInfra.ctx,
+			testInfra.logger,
+			testInfra.cfg,
+			httpso,
+		)).To(BeNil())
+		Expect(recorder.Events).To(Receive(HavePrefix("Warning InvalidRevision")))
+		Expect(httpso.IsConditionTrue(v1alpha1.RoutingConfigured)).To(BeFalse())
+		Expect(pinned()).To(Equal(&routing.RevisionPin{Label: "example.com/color", Value: "green"}))
+	})
+})
+
+// forbiddenGetClient is a client.Client that isn't allowed
+// to get anything
+type forbiddenGetClient struct {
+	client.Client
+}
+
+func (forbiddenGetClient) Get(
+	_ context.Context,
+	key client.ObjectKey,
+	_ client.Object,
+) error {
+	return apierrs.NewForbidden(
+		schema.GroupResource{Group: "apps", Resource: "deployments"},
+		key.Name,
+		errors.New("no RBAC"),
+	)
+}
@@ -0,0 +1,246 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/yaml"
+
+	httpv1alpha1 "github.com/kedacore/http-add-on/operator/api/v1alpha1"
+)
+
+const (
+	// interceptorConfigFileKey is the key of the interceptor's config
+	// file in the ConfigMap that the HTTPInterceptorConfig is
+	// rendered into
+	interceptorConfigFileKey = "config.yaml"
+	// interceptorConfigDir and interceptorTLSDir are where the
+	// interceptor's containers mount the rendered ConfigMap and the
+	// TLS Secret
+	interceptorConfigDir = "/etc/keda-http/config"
+	interceptorTLSDir    = "/etc/keda-http/tls"
+	// interceptorConfigVolume and interceptorTLSVolume are the names
+	// of the volumes that the operator adds to the interceptor's pods
+	interceptorConfigVolume = "keda-http-interceptor-config"
+	interceptorTLSVolume    = "keda-http-interceptor-tls"
+	// interceptorConfigHashAnnotation is the annotation on the
+	// interceptor's pod template with the hash of the rendered
+	// configuration. Changing it rolls the interceptor out
+	interceptorConfigHashAnnotation = "http.keda.sh/interceptor-config-hash"
+	// interceptorConfigFileEnv is the environment variable that
+	// points the interceptor at its config file
+	interceptorConfigFileEnv = "KEDA_HTTP_CONFIG_FILE"
+)
+
+// interceptorConfigValues returns the interceptor configuration that
+// spec asks for, as a map from the interceptor's configuration keys to
+// their values. Settings that spec doesn't set are left out, so the
+// interceptor's environment variables or defaults apply to them.
+// hasClientCA says whether the TLS Secret has a client CA bundle
+func interceptorConfigValues(
+	spec httpv1alpha1.HTTPInterceptorConfigSpec,
+	hasClientCA bool,
+) map[string]string {
+	ret := map[string]string{}
+	setMS := func(key string, ms int32) {
+		if ms > 0 {
+			ret[key] = fmt.Sprintf("%dms", ms)
+		}
+	}
+	setInt := func(key string, val int64) {
+		if val > 0 {
+			ret[key] = fmt.Sprintf("%d", val)
+		}
+	}
+	if t := spec.Timeouts; t != nil {
+		setMS("KEDA_HTTP_CONNECT_TIMEOUT", t.ConnectMS)
+		setMS("KEDA_HTTP_KEEP_ALIVE", t.KeepAliveMS)
+		setMS("KEDA_RESPONSE_HEADER_TIMEOUT", t.ResponseHeaderMS)
+		setMS("KEDA_CONDITION_WAIT_TIMEOUT", t.WaitForReplicasMS)
+		if t.IdleConnSeconds > 0 {
+			ret["KEDA_HTTP_IDLE_CONN_TIMEOUT"] = fmt.Sprintf("%ds", t.IdleConnSeconds)
+		}
+	}
+	if l := spec.Limits; l != nil {
+		setInt("KEDA_HTTP_MAX_REQUEST_BODY_BYTES", l.MaxRequestBodyBytes)
+		setInt("KEDA_HTTP_MAX_RESPONSE_BODY_BYTES", l.MaxResponseBodyBytes)
+		setInt("KEDA_HTTP_MAX_PENDING_REQUESTS", int64(l.MaxPendingRequests))
+		setInt("KEDA_HTTP_MAX_IDLE_CONNS_PER_HOST", int64(l.MaxIdleConnsPerHost))
+		setInt(
+			"KEDA_HTTP_SOFT_MAX_PENDING_REQUESTS_PER_HOST",
+			int64(l.SoftMaxPendingRequestsPerHost),
+		)
+	}
+	if tls := spec.TLS; tls != nil {
+		ret["KEDA_HTTP_PROXY_TLS_CERT_FILE"] = path.Join(interceptorTLSDir, corev1.TLSCertKey)
+		ret["KEDA_HTTP_PROXY_TLS_KEY_FILE"] = path.Join(interceptorTLSDir, corev1.TLSPrivateKeyKey)
+		if hasClientCA {
+			ret["KEDA_HTTP_PROXY_TLS_CLIENT_CA_FILE"] = path.Join(
+				interceptorTLSDir,
+				corev1.ServiceAccountRootCAKey,
+			)
+		}
+		if tls.ClientAuth != "" {
+			ret["KEDA_HTTP_PROXY_TLS_CLIENT_AUTH"] = tls.ClientAuth
+		}
+	}
+	if logging := spec.Logging; logging != nil && logging.Level != "" {
+		ret["KEDA_HTTP_LOG_LEVEL"] = logging.Level
+	}
+	return ret
+}
+
+// renderInterceptorConfigFile returns the interceptor's config file
+// for values, and its hash. The file's keys are sorted, so the same
+// values always render to the same file
+func renderInterceptorConfigFile(values map[string]string) (string, string, error) {
+	b, err := yaml.Marshal(values)
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256(b)
+	return string(b), hex.EncodeToString(sum[:8]), nil
+}
+
+// mountInterceptorConfig makes the interceptor's pods, from depl's
+// pod template, read their config file from the ConfigMap named
+// configMapName and mount the Secret named tlsSecretName for their TLS
+// certificate. hash is set as the template's config hash annotation,
+// so that changing it rolls the interceptor out. Returns whether depl
+// changed
+func mountInterceptorConfig(
+	depl *appsv1.Deployment,
+	configMapName,
+	tlsSecretName,
+	hash string,
+) bool {
+	before := depl.Spec.Template.DeepCopy()
+	tmpl := &depl.Spec.Template
+	if tmpl.Annotations == nil {
+		tmpl.Annotations = map[string]string{}
+	}
+	tmpl.Annotations[interceptorConfigHashAnnotation] = hash
+	// both volumes are optional, so that pods still start
+	// while the operator hasn't created them yet
+	optional := true
+	setVolume(&tmpl.Spec, corev1.Volume{
+		Name: interceptorConfigVolume,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: configMapName},
+				Optional:             &optional,
+			},
+		},
+	})
+	setVolume(&tmpl.Spec, corev1.Volume{
+		Name: interceptorTLSVolume,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: tlsSecretName,
+				Optional:   &optional,
+			},
+		},
+	})
+	for i := range tmpl.Spec.Containers {
+		container := &tmpl.Spec.Containers[i]
+		setVolumeMount(container, corev1.VolumeMount{
+			Name:      interceptorConfigVolume,
+			MountPath: interceptorConfigDir,
+			ReadOnly:  true,
+		})
+		setVolumeMount(container, corev1.VolumeMount{
+			Name:      interceptorTLSVolume,
+			MountPath: interceptorTLSDir,
+			ReadOnly:  true,
+		})
+		setEnv(container, corev1.EnvVar{
+			Name:  interceptorConfigFileEnv,
+			Value: path.Join(interceptorConfigDir, interceptorConfigFileKey),
+		})
+	}
+	return !equality.Semantic.DeepEqual(*before, *tmpl)
+}
+
+// unmountInterceptorConfig undoes mountInterceptorConfig on depl.
+// Returns whether depl changed
+func unmountInterceptorConfig(depl *appsv1.Deployment) bool {
+	before := depl.Spec.Template.DeepCopy()
+	tmpl := &depl.Spec.Template
+	delete(tmpl.Annotations, interceptorConfigHashAnnotation)
+	volumes := tmpl.Spec.Volumes[:0]
+	for _, vol := range tmpl.Spec.Volumes {
+		if vol.Name != interceptorConfigVolume && vol.Name != interceptorTLSVolume {
+			volumes = append(volumes, vol)
+		}
+	}
+	tmpl.Spec.Volumes = volumes
+	for i := range tmpl.Spec.Containers {
+		container := &tmpl.Spec.Containers[i]
+		mounts := container.VolumeMounts[:0]
+		for _, mount := range container.VolumeMounts {
+			if mount.Name != interceptorConfigVolume && mount.Name != interceptorTLSVolume {
+				mounts = append(mounts, mount)
+			}
+		}
+		container.VolumeMounts = mounts
+		env := container.Env[:0]
+		for _, envVar := range container.Env {
+			if envVar.Name != interceptorConfigFileEnv {
+				env = append(env, envVar)
+			}
+		}
+		container.Env = env
+	}
+	return !equality.Semantic.DeepEqual(*before, *tmpl)
+}
+
+// interceptorRolledOut returns whether every replica of depl runs
+// with its current pod template, and how many do
+func interceptorRolledOut(depl *appsv1.Deployment) (bool, int32) {
+	if depl.Status.ObservedGeneration < depl.Generation {
+		return false, 0
+	}
+	replicas := int32(1)
+	if depl.Spec.Replicas != nil {
+		replicas = *depl.Spec.Replicas
+	}
+	updated := depl.Status.UpdatedReplicas
+	return updated == replicas &&
+		depl.Status.Replicas == updated &&
+		depl.Status.AvailableReplicas == updated, updated
+}
+
+func setVolume(spec *corev1.PodSpec, vol corev1.Volume) {
+	for i := range spec.Volumes {
+		if spec.Volumes[i].Name == vol.Name {
+			spec.Volumes[i] = vol
+			return
+		}
+	}
+	spec.Volumes = append(spec.Volumes, vol)
+}
+
+func setVolumeMount(container *corev1.Container, mount corev1.VolumeMount) {
+	for i := range container.VolumeMounts {
+		if container.VolumeMounts[i].Name == mount.Name {
+			container.VolumeMounts[i] = mount
+			return
+		}
+	}
+	container.VolumeMounts = append(container.VolumeMounts, mount)
+}
+
+func setEnv(container *corev1.Container, envVar corev1.EnvVar) {
+	for i := range container.Env {
+		if container.Env[i].Name == envVar.Name {
+			container.Env[i] = envVar
+			return
+		}
+	}
+	container.Env = append(container.Env, envVar)
+}
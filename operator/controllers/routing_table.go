@@ -11,6 +11,9 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// removeAndUpdateRoutingTable removes host in namespace from table, then
+// writes namespace's part of table to the routing table ConfigMap in
+// namespace
 func removeAndUpdateRoutingTable(
 	ctx context.Context,
 	lggr logr.Logger,
@@ -20,7 +23,7 @@ func removeAndUpdateRoutingTable(
 	namespace string,
 ) error {
 	lggr = lggr.WithName("removeAndUpdateRoutingTable")
-	if err := table.RemoveTarget(host); err != nil {
+	if err := table.RemoveTarget(routing.NamespacedHost(namespace, host)); err != nil {
 		lggr.Error(
 			err,
 			"could not remove host from routing table, progressing anyway",
@@ -32,6 +35,10 @@ func removeAndUpdateRoutingTable(
 	return updateRoutingMap(ctx, lggr, cl, namespace, table)
 }
 
+// addAndUpdateRoutingTable adds or replaces target for host in namespace
+// in table, then writes namespace's part of table to the routing table ConfigMap
+// in namespace. table's keys are stamped with routing.NamespacedHost,
+// so the same host in two namespaces doesn't collide
 func addAndUpdateRoutingTable(
 	ctx context.Context,
 	lggr logr.Logger,
@@ -42,7 +49,11 @@ func addAndUpdateRoutingTable(
 	namespace string,
 ) error {
 	lggr = lggr.WithName("addAndUpdateRoutingTable")
-	if err := table.AddTarget(host, target); err != nil {
+	key := routing.NamespacedHost(namespace, host)
+	// replace the existing target, if there is one, so that changes
+	// to the HTTPScaledObject make it to the routing table
+	table.RemoveTarget(key)
+	if err := table.AddTarget(key, target); err != nil {
 		lggr.Error(
 			err,
 			"could not add host to routing table, progressing anyway",
@@ -53,6 +64,11 @@ func addAndUpdateRoutingTable(
 	return updateRoutingMap(ctx, lggr, cl, namespace, table)
 }
 
+// updateRoutingMap creates or patches the routing table ConfigMap in
+// namespace so that it holds the targets in table for namespace. The
+// interceptors in each namespace only ever see their own hosts. The keys
+// stay namespaced, like the keys of the counts that the scaler gets, and
+// interceptors strip the namespace when they load the table
 func updateRoutingMap(
 	ctx context.Context,
 	lggr logr.Logger,
@@ -61,6 +77,7 @@ func updateRoutingMap(
 	table *routing.Table,
 ) error {
 	lggr = lggr.WithName("updateRoutingMap")
+	table = table.ForNamespace(namespace)
 	routingConfigMap, err := k8s.GetConfigMap(ctx, cl, namespace, routing.ConfigMapRoutingTableName)
 	// if there is an error other than not found on the ConfigMap, we should
 	// fail
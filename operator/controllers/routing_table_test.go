@@ -5,6 +5,8 @@ import (
 	"testing"
 
 	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/k8s"
+	"github.com/kedacore/http-add-on/pkg/queue"
 	"github.com/kedacore/http-add-on/pkg/routing"
 	"github.com/stretchr/testify/require"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
@@ -40,7 +42,10 @@ func TestRoutingTable(t *testing.T) {
 	// https://github.com/kubernetes-sigs/controller-runtime/issues/1633
 	// to be implemented.
 
-	retTarget, err := table.Lookup(host)
+	retTarget, err := table.Lookup(routing.NamespacedHost(ns, host))
+	r.NoError(err)
+	r.Equal(target, retTarget)
+	retTarget, err = table.ForNamespace(ns).Lookup(routing.NamespacedHost(ns, host))
 	r.NoError(err)
 	r.Equal(target, retTarget)
 
@@ -58,6 +63,55 @@ func TestRoutingTable(t *testing.T) {
 	// https://github.com/kubernetes-sigs/controller-runtime/issues/1633
 	// to be implemnented
 
-	_, err = table.Lookup(host)
+	_, err = table.Lookup(routing.NamespacedHost(ns, host))
+	r.Error(err)
+}
+
+func TestRoutingTableSameHostManyNamespaces(t *testing.T) {
+	table := routing.NewTable()
+	const (
+		host = "myhost.com"
+		ns1  = "testns1"
+		ns2  = "testns2"
+	)
+	r := require.New(t)
+	ctx := context.Background()
+	cl := fake.NewClientBuilder().Build()
+	target1 := routing.Target{Service: "svc1", Port: 8080, Deployment: "depl1"}
+	target2 := routing.Target{Service: "svc2", Port: 8080, Deployment: "depl2"}
+	r.NoError(addAndUpdateRoutingTable(ctx, logr.Discard(), cl, table, host, target1, ns1))
+	r.NoError(addAndUpdateRoutingTable(ctx, logr.Discard(), cl, table, host, target2, ns2))
+
+	// each namespace has its own target for the same host
+	ret, err := table.ForNamespace(ns1).Lookup(routing.NamespacedHost(ns1, host))
+	r.NoError(err)
+	r.Equal(target1, ret)
+	ret, err = table.ForNamespace(ns2).Lookup(routing.NamespacedHost(ns2, host))
+	r.NoError(err)
+	r.Equal(target2, ret)
+
+	// each namespace's ConfigMap only has its own host
+	for ns, target := range map[string]routing.Target{ns1: target1, ns2: target2} {
+		cm, err := k8s.GetConfigMap(ctx, cl, ns, routing.ConfigMapRoutingTableName)
+		r.NoError(err)
+		cmTable, err := routing.FetchTableFromConfigMap(cm, queue.NewMemory())
+		r.NoError(err)
+		ret, err := cmTable.Lookup(routing.NamespacedHost(ns, host))
+		r.NoError(err)
+		r.Equal(target, ret)
+		// the interceptors in ns see plain hosts
+		nsTable := routing.NewNamespacedTable(ns)
+		nsTable.Replace(cmTable)
+		ret, err = nsTable.Lookup(host)
+		r.NoError(err)
+		r.Equal(target, ret)
+	}
+
+	// removing the host in one namespace leaves the other alone
+	r.NoError(removeAndUpdateRoutingTable(ctx, logr.Discard(), cl, table, host, ns1))
+	_, err = table.ForNamespace(ns1).Lookup(routing.NamespacedHost(ns1, host))
 	r.Error(err)
+	ret, err = table.ForNamespace(ns2).Lookup(routing.NamespacedHost(ns2, host))
+	r.NoError(err)
+	r.Equal(target2, ret)
 }
@@ -2,61 +2,386 @@ package controllers
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
 
 	"github.com/go-logr/logr"
 	"github.com/kedacore/http-add-on/operator/api/v1alpha1"
 	"github.com/kedacore/http-add-on/operator/controllers/config"
 	"github.com/kedacore/http-add-on/pkg/k8s"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-// create ScaledObjects for the app and interceptor
+// create the ScaledObject for the app, owned by httpso. If it already
+// exists but has drifted from what httpso describes, update it back.
+//
+// If httpso has a canary, also create the ScaledObject that scales the
+// canary on the requests to its own queue key. Otherwise, delete the
+// canary ScaledObject in case httpso used to have a canary.
+//
+// Events for the ScaledObjects that are created or updated are
+// recorded on httpso with recorder, which may be nil
 func createScaledObjects(
 	ctx context.Context,
 	appInfo config.AppInfo,
 	cl client.Client,
 	logger logr.Logger,
 	externalScalerHostName string,
+	targetPendingRequests int32,
 	httpso *v1alpha1.HTTPScaledObject,
+	recorder record.EventRecorder,
 ) error {
 
 	logger.Info("Creating scaled objects", "external scaler host name", externalScalerHostName)
 
-	appScaledObject, appErr := k8s.NewScaledObject(
-		appInfo.Namespace,
-		config.AppScaledObjectName(httpso),
-		appInfo.Name,
+	appScaledObject, canaryScaledObject, err := desiredScaledObjects(
+		appInfo,
 		externalScalerHostName,
-		httpso.Spec.Host,
-		httpso.Spec.Replicas.Min,
-		httpso.Spec.Replicas.Max,
+		targetPendingRequests,
+		httpso,
 	)
-	if appErr != nil {
-		return appErr
-	}
-
-	logger.Info("Creating App ScaledObject", "ScaledObject", *appScaledObject)
-	if err := cl.Create(ctx, appScaledObject); err != nil {
-		if errors.IsAlreadyExists(err) {
-			logger.Info("User app scaled object already exists, moving on")
-		} else {
-			logger.Error(err, "Creating ScaledObject")
-			httpso.AddCondition(*v1alpha1.CreateCondition(
-				v1alpha1.Error,
-				v1.ConditionFalse,
-				v1alpha1.ErrorCreatingAppScaledObject,
-			).SetMessage(err.Error()))
+	if err != nil {
+		return err
+	}
+	if err := createOrReconcileScaledObject(ctx, cl, logger, recorder, httpso, appScaledObject); err != nil {
+		return err
+	}
+
+	if canaryScaledObject != nil {
+		if err := createOrReconcileScaledObject(ctx, cl, logger, recorder, httpso, canaryScaledObject); err != nil {
 			return err
 		}
+	} else if err := deleteCanaryScaledObject(ctx, cl, appInfo, httpso); err != nil {
+		logger.Error(err, "Deleting canary ScaledObject")
+		httpso.SetCondition(
+			v1alpha1.ScaledObjectCreated,
+			v1.ConditionFalse,
+			v1alpha1.ErrorCreatingAppScaledObject,
+			err.Error(),
+		)
+		return err
 	}
 
-	httpso.AddCondition(*v1alpha1.CreateCondition(
-		v1alpha1.Created,
+	httpso.SetCondition(
+		v1alpha1.ScaledObjectCreated,
 		v1.ConditionTrue,
 		v1alpha1.AppScaledObjectCreated,
-	).SetMessage("App ScaledObject created"))
+		"App ScaledObject created",
+	)
 
 	return nil
 }
+
+// desiredScaledObjects returns the ScaledObjects that httpso describes,
+// owned by httpso: the one for the app, and the one for its canary, or
+// nil if it has no canary
+func desiredScaledObjects(
+	appInfo config.AppInfo,
+	externalScalerHostName string,
+	targetPendingRequests int32,
+	httpso *v1alpha1.HTTPScaledObject,
+) (*unstructured.Unstructured, *unstructured.Unstructured, error) {
+	minReplicas, maxReplicas := httpso.Spec.Replicas.Bounds()
+
+	appScaledObject, err := k8s.NewScaledObject(
+		appInfo.Namespace,
+		config.AppScaledObjectName(httpso),
+		httpso.Spec.ScaleTargetRef.WorkloadAPIVersion(),
+		httpso.Spec.ScaleTargetRef.WorkloadKind(),
+		appInfo.Name,
+		externalScalerHostName,
+		httpso.Spec.Host,
+		minReplicas,
+		maxReplicas,
+		targetPendingRequests,
+		string(httpso.Spec.ScalingMetric),
+		string(httpso.Spec.ScalingBehavior),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := setAdvanced(appScaledObject, httpso.Spec.Advanced); err != nil {
+		return nil, nil, err
+	}
+	setOwner(httpso, appScaledObject)
+
+	canary := httpso.Spec.Canary
+	if canary == nil {
+		return appScaledObject, nil, nil
+	}
+	canaryScaledObject, err := k8s.NewScaledObject(
+		appInfo.Namespace,
+		config.CanaryScaledObjectName(httpso),
+		canary.ScaleTargetRef.WorkloadAPIVersion(),
+		canary.ScaleTargetRef.WorkloadKind(),
+		canary.ScaleTargetRef.WorkloadName(),
+		externalScalerHostName,
+		routing.CanaryQueueKey(httpso.Spec.Host),
+		minReplicas,
+		maxReplicas,
+		targetPendingRequests,
+		string(httpso.Spec.ScalingMetric),
+		string(httpso.Spec.ScalingBehavior),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := setAdvanced(canaryScaledObject, httpso.Spec.Advanced); err != nil {
+		return nil, nil, err
+	}
+	setOwner(httpso, canaryScaledObject)
+	return appScaledObject, canaryScaledObject, nil
+}
+
+// setOwner makes httpso the controller of scaledObject. The owner
+// reference lets the operator watch the ScaledObject for changes, and
+// garbage collects it with httpso. If httpso is paused, scaledObject
+// gets the annotation that makes KEDA pin the workload too
+func setOwner(httpso *v1alpha1.HTTPScaledObject, scaledObject *unstructured.Unstructured) {
+	scaledObject.SetOwnerReferences([]v1.OwnerReference{
+		*v1.NewControllerRef(httpso, v1alpha1.GroupVersion.WithKind("HTTPScaledObject")),
+	})
+	// the annotation was validated before any ScaledObject was created
+	if paused, _ := httpso.PausedReplicas(); paused != nil {
+		scaledObject.SetAnnotations(map[string]string{
+			k8s.PausedReplicasAnnotation: strconv.Itoa(int(*paused)),
+		})
+	}
+}
+
+// setAdvanced sets the advanced section of scaledObject's spec to
+// advanced. If advanced is nil, the section is left unset, so that an
+// existing ScaledObject's advanced section is left alone when it's
+// reconciled
+func setAdvanced(
+	scaledObject *unstructured.Unstructured,
+	advanced *v1alpha1.AdvancedConfig,
+) error {
+	if advanced == nil {
+		return nil
+	}
+	advancedMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(advanced)
+	if err != nil {
+		return err
+	}
+	return unstructured.SetNestedMap(scaledObject.Object, advancedMap, "spec", "advanced")
+}
+
+// createOrReconcileScaledObject creates scaledObject, which is owned by
+// httpso, or reconciles the existing one back to it. Sets the
+// ScaledObjectCreated condition on httpso if that fails. Records an
+// Event on httpso with recorder if scaledObject is created or updated
+func createOrReconcileScaledObject(
+	ctx context.Context,
+	cl client.Client,
+	logger logr.Logger,
+	recorder record.EventRecorder,
+	httpso *v1alpha1.HTTPScaledObject,
+	scaledObject *unstructured.Unstructured,
+) error {
+	logger.Info("Creating App ScaledObject", "ScaledObject", *scaledObject)
+	err := cl.Create(ctx, scaledObject)
+	if err == nil {
+		recordEvent(
+			recorder,
+			httpso,
+			corev1.EventTypeNormal,
+			eventReasonScaledObjectCreated,
+			fmt.Sprintf("Created ScaledObject %s", scaledObject.GetName()),
+		)
+		return nil
+	}
+	if errors.IsAlreadyExists(err) {
+		logger.Info("User app scaled object already exists, reconciling it")
+		var updated bool
+		updated, err = reconcileScaledObject(ctx, cl, logger, httpso, scaledObject)
+		if err == nil && updated {
+			recordEvent(
+				recorder,
+				httpso,
+				corev1.EventTypeNormal,
+				eventReasonScaledObjectUpdated,
+				fmt.Sprintf("Updated ScaledObject %s, which had drifted", scaledObject.GetName()),
+			)
+		}
+	}
+	if err != nil {
+		logger.Error(err, "Creating ScaledObject")
+		httpso.SetCondition(
+			v1alpha1.ScaledObjectCreated,
+			v1.ConditionFalse,
+			v1alpha1.ErrorCreatingAppScaledObject,
+			err.Error(),
+		)
+		recordEvent(
+			recorder,
+			httpso,
+			corev1.EventTypeWarning,
+			string(v1alpha1.ErrorCreatingAppScaledObject),
+			fmt.Sprintf("Creating ScaledObject %s: %s", scaledObject.GetName(), err),
+		)
+		return err
+	}
+	return nil
+}
+
+// deleteCanaryScaledObject deletes the ScaledObject for the canary of
+// httpso, if it exists
+func deleteCanaryScaledObject(
+	ctx context.Context,
+	cl client.Client,
+	appInfo config.AppInfo,
+	httpso *v1alpha1.HTTPScaledObject,
+) error {
+	scaledObject := &unstructured.Unstructured{}
+	scaledObject.SetNamespace(appInfo.Namespace)
+	scaledObject.SetName(config.CanaryScaledObjectName(httpso))
+	scaledObject.SetGroupVersionKind(schema.GroupVersionKind{
+		Group:   "keda.sh",
+		Kind:    "ScaledObject",
+		Version: "v1alpha1",
+	})
+	if err := cl.Delete(ctx, scaledObject); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// deleteScaledObjects deletes the ScaledObjects for the app and the
+// canary of httpso, if they exist
+func deleteScaledObjects(
+	ctx context.Context,
+	cl client.Client,
+	appInfo config.AppInfo,
+	httpso *v1alpha1.HTTPScaledObject,
+) error {
+	scaledObject := &unstructured.Unstructured{}
+	scaledObject.SetNamespace(appInfo.Namespace)
+	scaledObject.SetName(config.AppScaledObjectName(httpso))
+	scaledObject.SetGroupVersionKind(schema.GroupVersionKind{
+		Group:   "keda.sh",
+		Kind:    "ScaledObject",
+		Version: "v1alpha1",
+	})
+	if err := cl.Delete(ctx, scaledObject); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return deleteCanaryScaledObject(ctx, cl, appInfo, httpso)
+}
+
+// reconcileScaledObject updates the existing ScaledObject with the same
+// name as desired if it has drifted from desired, according to
+// scaledObjectDrift. Fields in the existing spec that desired doesn't
+// set are left alone, since KEDA or other tools may have set them.
+// Returns true if it was updated
+func reconcileScaledObject(
+	ctx context.Context,
+	cl client.Client,
+	logger logr.Logger,
+	owner v1.Object,
+	desired *unstructured.Unstructured,
+) (bool, error) {
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(desired.GroupVersionKind())
+	if err := cl.Get(ctx, client.ObjectKeyFromObject(desired), existing); err != nil {
+		return false, err
+	}
+	drifted, err := scaledObjectDrift(existing, desired, owner)
+	if err != nil {
+		return false, err
+	}
+	if len(drifted) == 0 {
+		return false, nil
+	}
+
+	logger.Info(
+		"ScaledObject drifted from its owner, updating it",
+		"drifted",
+		drifted,
+	)
+	existingSpec, ok := existing.Object["spec"].(map[string]interface{})
+	if !ok {
+		existingSpec = map[string]interface{}{}
+	}
+	desiredSpec, _ := desired.Object["spec"].(map[string]interface{})
+	for key, desiredVal := range desiredSpec {
+		existingSpec[key] = desiredVal
+	}
+	existing.Object["spec"] = existingSpec
+	existingAnnotations := existing.GetAnnotations()
+	if existingAnnotations == nil {
+		existingAnnotations = map[string]string{}
+	}
+	if desiredPaused, ok := desired.GetAnnotations()[k8s.PausedReplicasAnnotation]; ok {
+		existingAnnotations[k8s.PausedReplicasAnnotation] = desiredPaused
+	} else {
+		delete(existingAnnotations, k8s.PausedReplicasAnnotation)
+	}
+	existing.SetAnnotations(existingAnnotations)
+	if !v1.IsControlledBy(existing, owner) {
+		existing.SetOwnerReferences(desired.GetOwnerReferences())
+	}
+	if err := cl.Update(ctx, existing); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// scaledObjectDrift returns the fields of existing that have drifted
+// from desired, sorted, or an empty slice if none have. Those are the
+// fields that desired's spec sets, the paused replicas annotation, and
+// the owner references if existing isn't controlled by owner. Other
+// fields and annotations are ignored, since other tools may have set
+// them
+func scaledObjectDrift(
+	existing *unstructured.Unstructured,
+	desired *unstructured.Unstructured,
+	owner v1.Object,
+) ([]string, error) {
+	ret := []string{}
+	existingSpec, _ := existing.Object["spec"].(map[string]interface{})
+	desiredSpec, _ := desired.Object["spec"].(map[string]interface{})
+	for key, desiredVal := range desiredSpec {
+		equal, err := jsonEqual(existingSpec[key], desiredVal)
+		if err != nil {
+			return nil, err
+		}
+		if !equal {
+			ret = append(ret, "spec."+key)
+		}
+	}
+	desiredPaused, desiredIsPaused := desired.GetAnnotations()[k8s.PausedReplicasAnnotation]
+	existingPaused, existingIsPaused := existing.GetAnnotations()[k8s.PausedReplicasAnnotation]
+	if desiredIsPaused != existingIsPaused || desiredPaused != existingPaused {
+		ret = append(ret, fmt.Sprintf("metadata.annotations[%s]", k8s.PausedReplicasAnnotation))
+	}
+	if !v1.IsControlledBy(existing, owner) {
+		ret = append(ret, "metadata.ownerReferences")
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// jsonEqual returns whether a and b encode to the same JSON. This
+// compares unstructured values regardless of their numeric types
+func jsonEqual(a, b interface{}) (bool, error) {
+	aBytes, err := json.Marshal(a)
+	if err != nil {
+		return false, err
+	}
+	bBytes, err := json.Marshal(b)
+	if err != nil {
+		return false, err
+	}
+	return string(aBytes) == string(bBytes), nil
+}
@@ -6,17 +6,25 @@ import (
 
 	"github.com/kedacore/http-add-on/operator/api/v1alpha1"
 	"github.com/kedacore/http-add-on/operator/controllers/config"
+	"github.com/kedacore/http-add-on/pkg/k8s"
+	"github.com/kedacore/http-add-on/pkg/routing"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	autoscalingv2beta2 "k8s.io/api/autoscaling/v2beta2"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 var _ = Describe("UserApp", func() {
 	Context("Creating a ScaledObject", func() {
-		const externalScalerHostName = "mysvc.myns.svc.cluster.local:9090"
+		const (
+			externalScalerHostName = "mysvc.myns.svc.cluster.local:9090"
+			targetPendingRequests  = int32(123)
+		)
 
 		var testInfra *commonTestInfra
 		BeforeEach(func() {
@@ -29,21 +37,21 @@ var _ = Describe("UserApp", func() {
 				testInfra.cl,
 				testInfra.logger,
 				externalScalerHostName,
+				targetPendingRequests,
 				&testInfra.httpso,
+				nil,
 			)
 			Expect(err).To(BeNil())
 
-			// make sure that httpso has the AppScaledObjectCreated
+			// make sure that httpso has the ScaledObjectCreated
 			// condition on it
 			Expect(len(testInfra.httpso.Status.Conditions)).To(Equal(1))
 
 			cond1 := testInfra.httpso.Status.Conditions[0]
-			cond1ts, err := time.Parse(time.RFC3339, cond1.Timestamp)
-			Expect(err).To(BeNil())
-			Expect(time.Since(cond1ts) >= 0).To(BeTrue())
-			Expect(cond1.Type).To(Equal(v1alpha1.Created))
+			Expect(time.Since(cond1.LastTransitionTime.Time) >= 0).To(BeTrue())
+			Expect(cond1.Type).To(Equal(string(v1alpha1.ScaledObjectCreated)))
 			Expect(cond1.Status).To(Equal(metav1.ConditionTrue))
-			Expect(cond1.Reason).To(Equal(v1alpha1.AppScaledObjectCreated))
+			Expect(cond1.Reason).To(Equal(string(v1alpha1.AppScaledObjectCreated)))
 
 			// check that the app ScaledObject was created
 			u := &unstructured.Unstructured{}
@@ -68,6 +76,388 @@ var _ = Describe("UserApp", func() {
 			Expect(err).To(BeNil())
 			Expect(spec["minReplicaCount"]).To(BeNumerically("==", testInfra.httpso.Spec.Replicas.Min))
 			Expect(spec["maxReplicaCount"]).To(BeNumerically("==", testInfra.httpso.Spec.Replicas.Max))
+
+			triggers, ok := spec["triggers"].([]interface{})
+			Expect(ok).To(BeTrue())
+			Expect(len(triggers)).To(Equal(1))
+			trigger, ok := triggers[0].(map[string]interface{})
+			Expect(ok).To(BeTrue())
+			triggerMeta, err := getKeyAsMap(trigger, "metadata")
+			Expect(err).To(BeNil())
+			Expect(triggerMeta["targetPendingRequests"]).To(Equal("123"))
+			// the scaler's default scaling metric is used
+			Expect(triggerMeta).ToNot(HaveKey("scalingMetric"))
+			// and KEDA's default metric type
+			Expect(trigger).ToNot(HaveKey("metricType"))
+
+			// the ScaledObject should be owned by the HTTPScaledObject
+			Expect(metav1.IsControlledBy(u, &testInfra.httpso)).To(BeTrue())
+		})
+		It("Should reconcile drift in an existing ScaledObject", func() {
+			recorder := record.NewFakeRecorder(10)
+			err := createScaledObjects(
+				testInfra.ctx,
+				testInfra.cfg,
+				testInfra.cl,
+				testInfra.logger,
+				externalScalerHostName,
+				targetPendingRequests,
+				&testInfra.httpso,
+				recorder,
+			)
+			Expect(err).To(BeNil())
+			Expect(recorder.Events).To(Receive(Equal("Normal ScaledObjectCreated Created ScaledObject testapp-app")))
+
+			u := &unstructured.Unstructured{}
+			u.SetGroupVersionKind(schema.GroupVersionKind{
+				Group:   "keda.sh",
+				Kind:    "ScaledObject",
+				Version: "v1alpha1",
+			})
+			objectKey := client.ObjectKey{
+				Namespace: testInfra.cfg.Namespace,
+				Name:      config.AppScaledObjectName(&testInfra.httpso),
+			}
+			Expect(testInfra.cl.Get(testInfra.ctx, objectKey, u)).To(BeNil())
+
+			// simulate a user editing the ScaledObject
+			spec, err := getKeyAsMap(u.Object, "spec")
+			Expect(err).To(BeNil())
+			spec["maxReplicaCount"] = int64(999)
+			spec["cooldownPeriod"] = int64(30)
+			u.SetOwnerReferences(nil)
+			Expect(testInfra.cl.Update(testInfra.ctx, u)).To(BeNil())
+
+			err = createScaledObjects(
+				testInfra.ctx,
+				testInfra.cfg,
+				testInfra.cl,
+				testInfra.logger,
+				externalScalerHostName,
+				targetPendingRequests,
+				&testInfra.httpso,
+				recorder,
+			)
+			Expect(err).To(BeNil())
+
+			Expect(testInfra.cl.Get(testInfra.ctx, objectKey, u)).To(BeNil())
+			spec, err = getKeyAsMap(u.Object, "spec")
+			Expect(err).To(BeNil())
+			Expect(spec["maxReplicaCount"]).To(BeNumerically("==", testInfra.httpso.Spec.Replicas.Max))
+			// fields the operator doesn't manage are left alone
+			Expect(spec["cooldownPeriod"]).To(BeNumerically("==", 30))
+			Expect(metav1.IsControlledBy(u, &testInfra.httpso)).To(BeTrue())
+			Expect(recorder.Events).To(Receive(ContainSubstring("Normal ScaledObjectUpdated")))
+
+			// a ScaledObject that hasn't drifted isn't updated
+			err = createScaledObjects(
+				testInfra.ctx,
+				testInfra.cfg,
+				testInfra.cl,
+				testInfra.logger,
+				externalScalerHostName,
+				targetPendingRequests,
+				&testInfra.httpso,
+				recorder,
+			)
+			Expect(err).To(BeNil())
+			Expect(recorder.Events).ToNot(Receive())
+		})
+		It("Should create and delete the ScaledObject for a canary", func() {
+			testInfra.httpso.Spec.Host = "myhost.com"
+			testInfra.httpso.Spec.Canary = &v1alpha1.Canary{
+				ScaleTargetRef: v1alpha1.ScaleTargetRef{
+					Name:    "testapp-canary",
+					Service: "testapp-canary",
+					Port:    8081,
+				},
+				Weight: 10,
+			}
+			err := createScaledObjects(
+				testInfra.ctx,
+				testInfra.cfg,
+				testInfra.cl,
+				testInfra.logger,
+				externalScalerHostName,
+				targetPendingRequests,
+				&testInfra.httpso,
+				nil,
+			)
+			Expect(err).To(BeNil())
+
+			u := &unstructured.Unstructured{}
+			u.SetGroupVersionKind(schema.GroupVersionKind{
+				Group:   "keda.sh",
+				Kind:    "ScaledObject",
+				Version: "v1alpha1",
+			})
+			objectKey := client.ObjectKey{
+				Namespace: testInfra.cfg.Namespace,
+				Name:      config.CanaryScaledObjectName(&testInfra.httpso),
+			}
+			Expect(testInfra.cl.Get(testInfra.ctx, objectKey, u)).To(BeNil())
+			Expect(metav1.IsControlledBy(u, &testInfra.httpso)).To(BeTrue())
+
+			spec, err := getKeyAsMap(u.Object, "spec")
+			Expect(err).To(BeNil())
+			scaleTargetRef, err := getKeyAsMap(spec, "scaleTargetRef")
+			Expect(err).To(BeNil())
+			Expect(scaleTargetRef["name"]).To(Equal("testapp-canary"))
+			// the canary is scaled on its own queue key
+			triggers, ok := spec["triggers"].([]interface{})
+			Expect(ok).To(BeTrue())
+			trigger, ok := triggers[0].(map[string]interface{})
+			Expect(ok).To(BeTrue())
+			triggerMeta, err := getKeyAsMap(trigger, "metadata")
+			Expect(err).To(BeNil())
+			Expect(triggerMeta["host"]).To(Equal(routing.CanaryQueueKey("myhost.com")))
+
+			// removing the canary deletes its ScaledObject
+			testInfra.httpso.Spec.Canary = nil
+			err = createScaledObjects(
+				testInfra.ctx,
+				testInfra.cfg,
+				testInfra.cl,
+				testInfra.logger,
+				externalScalerHostName,
+				targetPendingRequests,
+				&testInfra.httpso,
+				nil,
+			)
+			Expect(err).To(BeNil())
+			err = testInfra.cl.Get(testInfra.ctx, objectKey, u)
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		})
+		It("Should fill in the default maximum replicas", func() {
+			testInfra.httpso.Spec.Replicas = v1alpha1.ReplicaStruct{Min: 2}
+			err := createScaledObjects(
+				testInfra.ctx,
+				testInfra.cfg,
+				testInfra.cl,
+				testInfra.logger,
+				externalScalerHostName,
+				targetPendingRequests,
+				&testInfra.httpso,
+				nil,
+			)
+			Expect(err).To(BeNil())
+
+			u := &unstructured.Unstructured{}
+			u.SetGroupVersionKind(schema.GroupVersionKind{
+				Group:   "keda.sh",
+				Kind:    "ScaledObject",
+				Version: "v1alpha1",
+			})
+			objectKey := client.ObjectKey{
+				Namespace: testInfra.cfg.Namespace,
+				Name:      config.AppScaledObjectName(&testInfra.httpso),
+			}
+			Expect(testInfra.cl.Get(testInfra.ctx, objectKey, u)).To(BeNil())
+			spec, err := getKeyAsMap(u.Object, "spec")
+			Expect(err).To(BeNil())
+			Expect(spec["minReplicaCount"]).To(BeNumerically("==", 2))
+			Expect(spec["maxReplicaCount"]).To(BeNumerically("==", v1alpha1.DefaultMaxReplicas))
+		})
+		It("Should pass the scaling metric to the scaler", func() {
+			testInfra.httpso.Spec.ScalingMetric = v1alpha1.ScalingMetricActiveConnections
+			err := createScaledObjects(
+				testInfra.ctx,
+				testInfra.cfg,
+				testInfra.cl,
+				testInfra.logger,
+				externalScalerHostName,
+				targetPendingRequests,
+				&testInfra.httpso,
+				nil,
+			)
+			Expect(err).To(BeNil())
+
+			u := &unstructured.Unstructured{}
+			u.SetGroupVersionKind(schema.GroupVersionKind{
+				Group:   "keda.sh",
+				Kind:    "ScaledObject",
+				Version: "v1alpha1",
+			})
+			objectKey := client.ObjectKey{
+				Namespace: testInfra.cfg.Namespace,
+				Name:      config.AppScaledObjectName(&testInfra.httpso),
+			}
+			Expect(testInfra.cl.Get(testInfra.ctx, objectKey, u)).To(BeNil())
+			triggers, _, err := unstructured.NestedSlice(u.Object, "spec", "triggers")
+			Expect(err).To(BeNil())
+			Expect(len(triggers)).To(Equal(1))
+			scalingMetric, _, err := unstructured.NestedString(
+				triggers[0].(map[string]interface{}),
+				"metadata", "scalingMetric",
+			)
+			Expect(err).To(BeNil())
+			Expect(scalingMetric).To(Equal("activeConnections"))
+		})
+		It("Should set the trigger's metric type from the scaling behavior", func() {
+			testInfra.httpso.Spec.ScalingBehavior = v1alpha1.ScalingBehaviorValue
+			err := createScaledObjects(
+				testInfra.ctx,
+				testInfra.cfg,
+				testInfra.cl,
+				testInfra.logger,
+				externalScalerHostName,
+				targetPendingRequests,
+				&testInfra.httpso,
+				nil,
+			)
+			Expect(err).To(BeNil())
+
+			u := &unstructured.Unstructured{}
+			u.SetGroupVersionKind(schema.GroupVersionKind{
+				Group:   "keda.sh",
+				Kind:    "ScaledObject",
+				Version: "v1alpha1",
+			})
+			objectKey := client.ObjectKey{
+				Namespace: testInfra.cfg.Namespace,
+				Name:      config.AppScaledObjectName(&testInfra.httpso),
+			}
+			Expect(testInfra.cl.Get(testInfra.ctx, objectKey, u)).To(BeNil())
+			triggers, _, err := unstructured.NestedSlice(u.Object, "spec", "triggers")
+			Expect(err).To(BeNil())
+			Expect(len(triggers)).To(Equal(1))
+			trigger := triggers[0].(map[string]interface{})
+			Expect(trigger["metricType"]).To(Equal("Value"))
+			behavior, _, err := unstructured.NestedString(
+				trigger,
+				"metadata", "scalingBehavior",
+			)
+			Expect(err).To(BeNil())
+			Expect(behavior).To(Equal("Value"))
+		})
+		It("Should copy the advanced options into the ScaledObject", func() {
+			stabilizationWindow := int32(60)
+			testInfra.httpso.Spec.Advanced = &v1alpha1.AdvancedConfig{
+				RestoreToOriginalReplicaCount: true,
+				HorizontalPodAutoscalerConfig: &v1alpha1.HorizontalPodAutoscalerConfig{
+					Behavior: &autoscalingv2beta2.HorizontalPodAutoscalerBehavior{
+						ScaleDown: &autoscalingv2beta2.HPAScalingRules{
+							StabilizationWindowSeconds: &stabilizationWindow,
+							Policies: []autoscalingv2beta2.HPAScalingPolicy{
+								{
+									Type:          autoscalingv2beta2.PercentScalingPolicy,
+									Value:         50,
+									PeriodSeconds: 30,
+								},
+							},
+						},
+					},
+				},
+			}
+			err := createScaledObjects(
+				testInfra.ctx,
+				testInfra.cfg,
+				testInfra.cl,
+				testInfra.logger,
+				externalScalerHostName,
+				targetPendingRequests,
+				&testInfra.httpso,
+				nil,
+			)
+			Expect(err).To(BeNil())
+
+			u := &unstructured.Unstructured{}
+			u.SetGroupVersionKind(schema.GroupVersionKind{
+				Group:   "keda.sh",
+				Kind:    "ScaledObject",
+				Version: "v1alpha1",
+			})
+			objectKey := client.ObjectKey{
+				Namespace: testInfra.cfg.Namespace,
+				Name:      config.AppScaledObjectName(&testInfra.httpso),
+			}
+			Expect(testInfra.cl.Get(testInfra.ctx, objectKey, u)).To(BeNil())
+			restore, _, err := unstructured.NestedBool(
+				u.Object,
+				"spec", "advanced", "restoreToOriginalReplicaCount",
+			)
+			Expect(err).To(BeNil())
+			Expect(restore).To(BeTrue())
+			window, _, err := unstructured.NestedInt64(
+				u.Object,
+				"spec", "advanced", "horizontalPodAutoscalerConfig",
+				"behavior", "scaleDown", "stabilizationWindowSeconds",
+			)
+			Expect(err).To(BeNil())
+			Expect(window).To(BeNumerically("==", 60))
+
+			// changes to the advanced options are reconciled
+			stabilizationWindow = 120
+			err = createScaledObjects(
+				testInfra.ctx,
+				testInfra.cfg,
+				testInfra.cl,
+				testInfra.logger,
+				externalScalerHostName,
+				targetPendingRequests,
+				&testInfra.httpso,
+				nil,
+			)
+			Expect(err).To(BeNil())
+			Expect(testInfra.cl.Get(testInfra.ctx, objectKey, u)).To(BeNil())
+			window, _, err = unstructured.NestedInt64(
+				u.Object,
+				"spec", "advanced", "horizontalPodAutoscalerConfig",
+				"behavior", "scaleDown", "stabilizationWindowSeconds",
+			)
+			Expect(err).To(BeNil())
+			Expect(window).To(BeNumerically("==", 120))
+		})
+		It("Should pause and resume the ScaledObject with the HTTPScaledObject", func() {
+			testInfra.httpso.SetAnnotations(map[string]string{
+				v1alpha1.PausedReplicasAnnotation: "3",
+			})
+			err := createScaledObjects(
+				testInfra.ctx,
+				testInfra.cfg,
+				testInfra.cl,
+				testInfra.logger,
+				externalScalerHostName,
+				targetPendingRequests,
+				&testInfra.httpso,
+				nil,
+			)
+			Expect(err).To(BeNil())
+
+			u := &unstructured.Unstructured{}
+			u.SetGroupVersionKind(schema.GroupVersionKind{
+				Group:   "keda.sh",
+				Kind:    "ScaledObject",
+				Version: "v1alpha1",
+			})
+			objectKey := client.ObjectKey{
+				Namespace: testInfra.cfg.Namespace,
+				Name:      config.AppScaledObjectName(&testInfra.httpso),
+			}
+			Expect(testInfra.cl.Get(testInfra.ctx, objectKey, u)).To(BeNil())
+			Expect(u.GetAnnotations()[k8s.PausedReplicasAnnotation]).To(Equal("3"))
+
+			// other annotations on the ScaledObject are left alone
+			annotations := u.GetAnnotations()
+			annotations["example.com/owner"] = "team"
+			u.SetAnnotations(annotations)
+			Expect(testInfra.cl.Update(testInfra.ctx, u)).To(BeNil())
+
+			testInfra.httpso.SetAnnotations(nil)
+			err = createScaledObjects(
+				testInfra.ctx,
+				testInfra.cfg,
+				testInfra.cl,
+				testInfra.logger,
+				externalScalerHostName,
+				targetPendingRequests,
+				&testInfra.httpso,
+				nil,
+			)
+			Expect(err).To(BeNil())
+			Expect(testInfra.cl.Get(testInfra.ctx, objectKey, u)).To(BeNil())
+			Expect(u.GetAnnotations()).ToNot(HaveKey(k8s.PausedReplicasAnnotation))
+			Expect(u.GetAnnotations()["example.com/owner"]).To(Equal("team"))
 		})
 	})
 })
@@ -22,15 +22,19 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"time"
 
 	"golang.org/x/sync/errgroup"
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	httpv1alpha1 "github.com/kedacore/http-add-on/operator/api/v1alpha1"
+	httpv1beta1 "github.com/kedacore/http-add-on/operator/api/v1beta1"
 	"github.com/kedacore/http-add-on/operator/controllers"
 	"github.com/kedacore/http-add-on/operator/controllers/config"
 	"github.com/kedacore/http-add-on/pkg/routing"
@@ -46,34 +50,102 @@ func init() {
 	_ = clientgoscheme.AddToScheme(scheme)
 
 	_ = httpv1alpha1.AddToScheme(scheme)
+	_ = httpv1beta1.AddToScheme(scheme)
 	// +kubebuilder:scaffold:scheme
 }
 
 func main() {
 	var metricsAddr string
+	var probeAddr string
 	var enableLeaderElection bool
+	var leaderElectionID string
+	var leaderElectionNamespace string
 	var adminPort int
+	var watchNamespaces string
+	var ignoreNamespaces string
+	var enableConversionWebhook bool
+	var dryRun bool
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
+	flag.StringVar(&probeAddr, "health-probe-addr", ":8081", "The address the /healthz and /readyz probe endpoints bind to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(
+		&leaderElectionID,
+		"leader-election-id",
+		"f8508ff1.keda.sh",
+		"The name of the Lease that operator replicas compete for when leader election is enabled",
+	)
+	flag.StringVar(
+		&leaderElectionNamespace,
+		"leader-election-namespace",
+		"",
+		"The namespace in which to create the leader election Lease. Defaults to the namespace the operator is running in",
+	)
 	flag.IntVar(
 		&adminPort,
 		"admin-port",
 		9090,
 		"The port on which to run the admin server. This is the port on which RPCs will be accepted to get the routing table",
 	)
+	flag.StringVar(
+		&watchNamespaces,
+		"watch-namespaces",
+		"",
+		"Comma-separated list of namespaces in which to reconcile HTTPScaledObjects. Empty means all namespaces in the cluster",
+	)
+	flag.StringVar(
+		&ignoreNamespaces,
+		"ignore-namespaces",
+		"",
+		"Comma-separated list of namespaces in which to never reconcile HTTPScaledObjects",
+	)
+	flag.BoolVar(
+		&enableConversionWebhook,
+		"enable-conversion-webhook",
+		false,
+		"Serve the webhook that converts HTTPScaledObjects between API versions. It needs a serving certificate in /tmp/k8s-webhook-server/serving-certs",
+	)
+	flag.BoolVar(
+		&dryRun,
+		"dry-run",
+		false,
+		"Record the changes to ScaledObjects and the routing table that HTTPScaledObjects would cause in their DryRun conditions and in Events, instead of making them",
+	)
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:             scheme,
-		MetricsBindAddress: metricsAddr,
-		Port:               9443,
-		LeaderElection:     enableLeaderElection,
-		LeaderElectionID:   "f8508ff1.keda.sh",
-	})
+	namespaces, err := config.ParseNamespaces(watchNamespaces, ignoreNamespaces)
+	if err != nil {
+		setupLog.Error(err, "invalid namespace flags")
+		os.Exit(1)
+	}
+	mgrOpts := ctrl.Options{
+		Scheme:                  scheme,
+		MetricsBindAddress:      metricsAddr,
+		HealthProbeBindAddress:  probeAddr,
+		Port:                    9443,
+		LeaderElection:          enableLeaderElection,
+		LeaderElectionID:        leaderElectionID,
+		LeaderElectionNamespace: leaderElectionNamespace,
+	}
+	switch len(namespaces.Watch) {
+	case 0:
+		setupLog.Info(
+			"watching all namespaces",
+			"ignoredNamespaces",
+			namespaces.Ignore,
+		)
+	case 1:
+		mgrOpts.Namespace = namespaces.Watch[0]
+		setupLog.Info("watching one namespace", "namespace", mgrOpts.Namespace)
+	default:
+		mgrOpts.NewCache = cache.MultiNamespacedCacheBuilder(namespaces.Watch)
+		setupLog.Info("watching namespaces", "namespaces", namespaces.Watch)
+	}
+
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), mgrOpts)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
@@ -106,12 +178,49 @@ func main() {
 		ExternalScalerConfig: *externalScalerCfg,
 		BaseConfig:           *baseConfig,
 		RoutingTable:         routingTable,
+		Namespaces:           *namespaces,
+		Recorder:             mgr.GetEventRecorderFor("keda-http-add-on-operator"),
+		DryRun:               dryRun,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "HTTPScaledObject")
 		os.Exit(1)
 	}
+	if err := (&controllers.HTTPAddonConfigReconciler{
+		Client:               mgr.GetClient(),
+		Log:                  ctrl.Log.WithName("controllers").WithName("HTTPAddonConfig"),
+		InterceptorConfig:    *interceptorCfg,
+		ExternalScalerConfig: *externalScalerCfg,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "HTTPAddonConfig")
+		os.Exit(1)
+	}
+	if err := (&controllers.HTTPInterceptorConfigReconciler{
+		Client:            mgr.GetClient(),
+		Log:               ctrl.Log.WithName("controllers").WithName("HTTPInterceptorConfig"),
+		InterceptorConfig: *interceptorCfg,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "HTTPInterceptorConfig")
+		os.Exit(1)
+	}
+	if enableConversionWebhook {
+		if err := (&httpv1beta1.HTTPScaledObject{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "HTTPScaledObject")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
+	if err := mgr.AddHealthzCheck("ping", healthz.Ping); err != nil {
+		setupLog.Error(err, "unable to set up health check")
+		os.Exit(1)
+	}
+	// the operator isn't ready to reconcile until
+	// its informers have their initial lists
+	if err := mgr.AddReadyzCheck("informers", cacheSyncedCheck(mgr.GetCache())); err != nil {
+		setupLog.Error(err, "unable to set up ready check")
+		os.Exit(1)
+	}
+
 	ctx := context.Background()
 	errGrp, _ := errgroup.WithContext(ctx)
 
@@ -137,3 +246,16 @@ func main() {
 
 	setupLog.Error(errGrp.Wait(), "running the operator")
 }
+
+// cacheSyncedCheck returns a healthz.Checker that fails until all the
+// informers in c have synced
+func cacheSyncedCheck(c cache.Cache) healthz.Checker {
+	return func(req *http.Request) error {
+		ctx, done := context.WithTimeout(req.Context(), time.Second)
+		defer done()
+		if !c.WaitForCacheSync(ctx) {
+			return fmt.Errorf("informer caches are not synced")
+		}
+		return nil
+	}
+}
@@ -0,0 +1,45 @@
+package brotli
+
+// bitWriter packs bits into bytes, least significant bit first
+type bitWriter struct {
+	buf []byte
+	// acc holds the nbits bits that don't make up a full byte yet
+	acc   uint64
+	nbits uint
+}
+
+func (b *bitWriter) reset() {
+	b.buf = b.buf[:0]
+	b.acc = 0
+	b.nbits = 0
+}
+
+// writeBits writes the n low bits of v, with n at most 56
+func (b *bitWriter) writeBits(n uint, v uint64) {
+	b.acc |= (v & (1<<n - 1)) << b.nbits
+	b.nbits += n
+	for b.nbits >= 8 {
+		b.buf = append(b.buf, byte(b.acc))
+		b.acc >>= 8
+		b.nbits -= 8
+	}
+}
+
+// align pads b with zeros to a byte boundary
+func (b *bitWriter) align() {
+	if b.nbits > 0 {
+		b.writeBits(8-b.nbits, 0)
+	}
+}
+
+// appendBits writes all the bits in other to b
+func (b *bitWriter) appendBits(other *bitWriter) {
+	if b.nbits == 0 {
+		b.buf = append(b.buf, other.buf...)
+	} else {
+		for _, c := range other.buf {
+			b.writeBits(8, uint64(c))
+		}
+	}
+	b.writeBits(other.nbits, other.acc)
+}
@@ -0,0 +1,842 @@
+package brotli
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// decode decompresses the brotli stream in data, following RFC 7932,
+// so that what a Writer writes can be checked by decompressing it. It
+// doesn't have the static dictionary, so streams that refer to it,
+// which a Writer never writes, are an error. So are literal contexts
+// in the UTF8 and signed context modes
+func decode(data []byte) ([]byte, error) {
+	br := &bitReader{data: data}
+	window, err := readWindowBits(br)
+	if err != nil {
+		return nil, err
+	}
+	d := &decoder{
+		br:        br,
+		maxWindow: 1<<window - 16,
+		dists:     [4]int{4, 11, 15, 16},
+	}
+	for {
+		last, err := d.metaBlock()
+		if err != nil {
+			return nil, err
+		}
+		if last {
+			return d.out, nil
+		}
+	}
+}
+
+var errDecodeEOF = errors.New("brotli: unexpected end of stream")
+
+// bitReader reads bits, least significant bit first
+type bitReader struct {
+	data []byte
+	pos  uint
+}
+
+func (b *bitReader) readBits(n uint) (int, error) {
+	ret := 0
+	for i := uint(0); i < n; i++ {
+		if b.pos>>3 >= uint(len(b.data)) {
+			return 0, errDecodeEOF
+		}
+		bit := int(b.data[b.pos>>3]>>(b.pos&7)) & 1
+		ret |= bit << i
+		b.pos++
+	}
+	return ret, nil
+}
+
+// align skips the bits up to the next byte boundary, which must be zeros
+func (b *bitReader) align() error {
+	for b.pos&7 != 0 {
+		bit, err := b.readBits(1)
+		if err != nil {
+			return err
+		}
+		if bit != 0 {
+			return errors.New("brotli: nonzero padding")
+		}
+	}
+	return nil
+}
+
+func readWindowBits(br *bitReader) (uint, error) {
+	bit, err := br.readBits(1)
+	if err != nil || bit == 0 {
+		return 16, err
+	}
+	n, err := br.readBits(3)
+	if err != nil || n != 0 {
+		return uint(17 + n), err
+	}
+	n, err = br.readBits(3)
+	switch {
+	case err != nil:
+		return 0, err
+	case n == 1:
+		return 0, errors.New("brotli: invalid window bits")
+	case n != 0:
+		return uint(8 + n), nil
+	}
+	return 17, nil
+}
+
+// readVarLen reads the variable length code of the number of block
+// types and of prefix trees, which is from 1 to 256
+func readVarLen(br *bitReader) (int, error) {
+	bit, err := br.readBits(1)
+	if err != nil || bit == 0 {
+		return 1, err
+	}
+	n, err := br.readBits(3)
+	if err != nil || n == 0 {
+		return 2, err
+	}
+	extra, err := br.readBits(uint(n))
+	return 1 + 1<<n + extra, err
+}
+
+// huffman is a canonical prefix code, decoded the way that zlib's
+// puff does it: counts has the number of codes of each length, and
+// symbols the symbols in the order of their codes
+type huffman struct {
+	counts  [maxCodeLength + 1]int
+	symbols []int
+	// single is the only symbol of a code that has just the one,
+	// which takes no bits
+	single int
+}
+
+func newHuffman(lengths []int) (*huffman, error) {
+	h := &huffman{single: -1}
+	used := 0
+	for sym, l := range lengths {
+		h.counts[l]++
+		if l > 0 {
+			used++
+			h.single = sym
+		}
+	}
+	h.counts[0] = 0
+	if used == 0 {
+		return nil, errors.New("brotli: empty prefix code")
+	}
+	if used > 1 {
+		h.single = -1
+		// the code has to be complete
+		left := 1
+		for l := 1; l <= maxCodeLength; l++ {
+			left = left<<1 - h.counts[l]
+			if left < 0 {
+				return nil, errors.New("brotli: oversubscribed prefix code")
+			}
+		}
+		if left != 0 {
+			return nil, errors.New("brotli: incomplete prefix code")
+		}
+	}
+	var offsets [maxCodeLength + 2]int
+	for l := 1; l <= maxCodeLength; l++ {
+		offsets[l+1] = offsets[l] + h.counts[l]
+	}
+	h.symbols = make([]int, offsets[maxCodeLength+1])
+	for sym, l := range lengths {
+		if l > 0 {
+			h.symbols[offsets[l]] = sym
+			offsets[l]++
+		}
+	}
+	return h, nil
+}
+
+func (h *huffman) read(br *bitReader) (int, error) {
+	if h.single >= 0 {
+		return h.single, nil
+	}
+	code, first, index := 0, 0, 0
+	for l := 1; l <= maxCodeLength; l++ {
+		bit, err := br.readBits(1)
+		if err != nil {
+			return 0, err
+		}
+		code |= bit
+		count := h.counts[l]
+		if code-count < first {
+			return h.symbols[index+code-first], nil
+		}
+		index += count
+		first += count
+		first <<= 1
+		code <<= 1
+	}
+	return 0, errors.New("brotli: invalid prefix code")
+}
+
+// readPrefixCode reads a simple or complex prefix code of an alphabet
+// of alphabetSize symbols
+func readPrefixCode(br *bitReader, alphabetSize int) (*huffman, error) {
+	hskip, err := br.readBits(2)
+	if err != nil {
+		return nil, err
+	}
+	if hskip == 1 {
+		return readSimplePrefixCode(br, alphabetSize)
+	}
+	// the lengths of the codes of code lengths
+	// are read with a fixed code
+	var lengthLengths [18]int
+	space := 32
+	used := 0
+	for _, sym := range codeLengthOrder[hskip:] {
+		l := 0
+		two, err := br.readBits(2)
+		if err != nil {
+			return nil, err
+		}
+		switch two {
+		case 0:
+			l = 0
+		case 1:
+			l = 4
+		case 2:
+			l = 3
+		default:
+			bit, err := br.readBits(1)
+			if err != nil {
+				return nil, err
+			}
+			if bit == 0 {
+				l = 2
+			} else if bit, err = br.readBits(1); err != nil {
+				return nil, err
+			} else if bit == 0 {
+				l = 1
+			} else {
+				l = 5
+			}
+		}
+		lengthLengths[sym] = l
+		if l > 0 {
+			space -= 32 >> l
+			used++
+			if space <= 0 {
+				break
+			}
+		}
+	}
+	if used != 1 && space != 0 {
+		return nil, errors.New("brotli: invalid code length code")
+	}
+	lengthsCode, err := newHuffman(lengthLengths[:])
+	if err != nil {
+		return nil, err
+	}
+
+	lengths := make([]int, alphabetSize)
+	space = 1 << maxCodeLength
+	prevLength := 8
+	repeat, prevSym := 0, -1
+	for sym := 0; sym < alphabetSize && space > 0; {
+		code, err := lengthsCode.read(br)
+		if err != nil {
+			return nil, err
+		}
+		if code < 16 {
+			lengths[sym] = code
+			sym++
+			if code > 0 {
+				prevLength = code
+				space -= 1 << maxCodeLength >> code
+			}
+			repeat, prevSym = 0, code
+			continue
+		}
+		extraBits, length := uint(2), prevLength
+		if code == zeroRun {
+			extraBits, length = 3, 0
+		}
+		extra, err := br.readBits(extraBits)
+		if err != nil {
+			return nil, err
+		}
+		old := 0
+		if prevSym == code {
+			old = repeat
+			repeat = (repeat - 2) << extraBits
+		} else {
+			repeat = 0
+		}
+		repeat += extra + 3
+		if sym+repeat-old > alphabetSize {
+			return nil, errors.New("brotli: code lengths past the alphabet")
+		}
+		for i := old; i < repeat; i++ {
+			lengths[sym] = length
+			sym++
+			if length > 0 {
+				space -= 1 << maxCodeLength >> length
+			}
+		}
+		prevSym = code
+	}
+	if space != 0 {
+		return nil, errors.New("brotli: incomplete prefix code")
+	}
+	return newHuffman(lengths)
+}
+
+func readSimplePrefixCode(br *bitReader, alphabetSize int) (*huffman, error) {
+	nsym, err := br.readBits(2)
+	if err != nil {
+		return nil, err
+	}
+	nsym++
+	alphabetBits := uint(0)
+	for 1<<alphabetBits < alphabetSize {
+		alphabetBits++
+	}
+	symbols := make([]int, nsym)
+	for i := range symbols {
+		sym, err := br.readBits(alphabetBits)
+		if err != nil {
+			return nil, err
+		}
+		if sym >= alphabetSize {
+			return nil, errors.New("brotli: symbol past the alphabet")
+		}
+		symbols[i] = sym
+	}
+	var codeLengths []int
+	switch nsym {
+	case 1:
+		codeLengths = []int{1}
+	case 2:
+		codeLengths = []int{1, 1}
+	case 3:
+		codeLengths = []int{1, 2, 2}
+	default:
+		treeSelect, err := br.readBits(1)
+		if err != nil {
+			return nil, err
+		}
+		codeLengths = []int{2, 2, 2, 2}
+		if treeSelect == 1 {
+			codeLengths = []int{1, 2, 3, 3}
+		}
+	}
+	lengths := make([]int, alphabetSize)
+	for i, sym := range symbols {
+		if lengths[sym] != 0 {
+			return nil, errors.New("brotli: repeated symbol in a simple prefix code")
+		}
+		lengths[sym] = codeLengths[i]
+	}
+	return newHuffman(lengths)
+}
+
+// blockCountBase and blockCountExtra are the shortest block count of
+// each block count code, and its number of extra bits
+var (
+	blockCountBase = [26]int{
+		1, 5, 9, 13, 17, 25, 33, 41, 49, 65, 81, 97, 113,
+		145, 177, 209, 241, 305, 369, 497, 753, 1265, 2289, 4337, 8433, 16625,
+	}
+	blockCountExtra = [26]uint{
+		2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5,
+		5, 5, 5, 6, 6, 7, 8, 9, 10, 11, 12, 13, 24,
+	}
+)
+
+// blockSwitcher keeps track of the block type of a category
+type blockSwitcher struct {
+	numTypes      int
+	types, counts *huffman
+	// current is the current block type, prev the one before, and
+	// left the number of symbols left in the current block
+	current, prev, left int
+}
+
+func readBlockSwitcher(br *bitReader) (*blockSwitcher, error) {
+	numTypes, err := readVarLen(br)
+	if err != nil {
+		return nil, err
+	}
+	b := &blockSwitcher{numTypes: numTypes, prev: 1, left: 1 << 30}
+	if numTypes < 2 {
+		return b, nil
+	}
+	if b.types, err = readPrefixCode(br, numTypes+2); err != nil {
+		return nil, err
+	}
+	if b.counts, err = readPrefixCode(br, 26); err != nil {
+		return nil, err
+	}
+	b.left, err = b.readCount(br)
+	return b, err
+}
+
+func (b *blockSwitcher) readCount(br *bitReader) (int, error) {
+	code, err := b.counts.read(br)
+	if err != nil {
+		return 0, err
+	}
+	extra, err := br.readBits(blockCountExtra[code])
+	return blockCountBase[code] + extra, err
+}
+
+// next returns the block type of the next symbol of b's category,
+// switching to the next block if the current one is done
+func (b *blockSwitcher) next(br *bitReader) (int, error) {
+	if b.left == 0 {
+		code, err := b.types.read(br)
+		if err != nil {
+			return 0, err
+		}
+		next := code - 2
+		switch code {
+		case 0:
+			next = b.prev
+		case 1:
+			next = (b.current + 1) % b.numTypes
+		}
+		b.prev, b.current = b.current, next
+		if b.left, err = b.readCount(br); err != nil {
+			return 0, err
+		}
+	}
+	b.left--
+	return b.current, nil
+}
+
+// readContextMap reads a context map of size entries, of trees from 0
+// to numTrees-1
+func readContextMap(br *bitReader, size, numTrees int) ([]int, error) {
+	ret := make([]int, size)
+	if numTrees < 2 {
+		return ret, nil
+	}
+	rleMax := 0
+	if bit, err := br.readBits(1); err != nil {
+		return nil, err
+	} else if bit == 1 {
+		n, err := br.readBits(4)
+		if err != nil {
+			return nil, err
+		}
+		rleMax = n + 1
+	}
+	code, err := readPrefixCode(br, numTrees+rleMax)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < size; {
+		sym, err := code.read(br)
+		if err != nil {
+			return nil, err
+		}
+		if sym == 0 || sym > rleMax {
+			if sym > 0 {
+				sym -= rleMax
+			}
+			ret[i] = sym
+			i++
+			continue
+		}
+		extra, err := br.readBits(uint(sym))
+		if err != nil {
+			return nil, err
+		}
+		run := 1<<sym + extra
+		if i+run > size {
+			return nil, errors.New("brotli: context map run past its end")
+		}
+		// the entries are zero already
+		i += run
+	}
+	if imtf, err := br.readBits(1); err != nil {
+		return nil, err
+	} else if imtf == 1 {
+		var mtf [256]int
+		for i := range mtf {
+			mtf[i] = i
+		}
+		for i, idx := range ret {
+			v := mtf[idx]
+			ret[i] = v
+			copy(mtf[1:idx+1], mtf[:idx])
+			mtf[0] = v
+		}
+	}
+	return ret, nil
+}
+
+// decoder holds the state that carries over from one
+// meta-block to the next
+type decoder struct {
+	br        *bitReader
+	out       []byte
+	maxWindow int
+	// dists is the ring of the last four distances,
+	// with the last one at the front
+	dists [4]int
+}
+
+// metaBlock decodes the next meta-block, and returns true if it was
+// the last one
+func (d *decoder) metaBlock() (bool, error) {
+	br := d.br
+	last, err := br.readBits(1)
+	if err != nil {
+		return false, err
+	}
+	if last == 1 {
+		empty, err := br.readBits(1)
+		if err != nil {
+			return false, err
+		}
+		if empty == 1 {
+			return true, br.align()
+		}
+	}
+	nibbles, err := br.readBits(2)
+	if err != nil {
+		return false, err
+	}
+	if nibbles == 3 {
+		if last == 1 {
+			return false, errors.New("brotli: last meta-block is metadata")
+		}
+		return false, d.skipMetadata()
+	}
+	mlen, err := br.readBits(uint(4 * (nibbles + 4)))
+	if err != nil {
+		return false, err
+	}
+	mlen++
+	if last == 0 {
+		uncompressed, err := br.readBits(1)
+		if err != nil {
+			return false, err
+		}
+		if uncompressed == 1 {
+			if err := br.align(); err != nil {
+				return false, err
+			}
+			start := br.pos >> 3
+			if start+uint(mlen) > uint(len(br.data)) {
+				return false, errDecodeEOF
+			}
+			d.out = append(d.out, br.data[start:start+uint(mlen)]...)
+			br.pos += uint(mlen) * 8
+			return false, nil
+		}
+	}
+	if err := d.compressed(mlen); err != nil {
+		return false, err
+	}
+	if last == 1 {
+		return true, br.align()
+	}
+	return false, nil
+}
+
+func (d *decoder) skipMetadata() error {
+	br := d.br
+	if reserved, err := br.readBits(1); err != nil {
+		return err
+	} else if reserved != 0 {
+		return errors.New("brotli: reserved bit is set")
+	}
+	skipBytes, err := br.readBits(2)
+	if err != nil {
+		return err
+	}
+	skipLen := 0
+	if skipBytes > 0 {
+		if skipLen, err = br.readBits(uint(8 * skipBytes)); err != nil {
+			return err
+		}
+		skipLen++
+	}
+	if err := br.align(); err != nil {
+		return err
+	}
+	if br.pos>>3+uint(skipLen) > uint(len(br.data)) {
+		return errDecodeEOF
+	}
+	br.pos += uint(skipLen) * 8
+	return nil
+}
+
+// cellInsertCode and cellCopyCode are the first insert and copy
+// length codes of each cell of 64 insert-and-copy length symbols
+var (
+	cellInsertCode = [11]int{0, 0, 0, 0, 8, 8, 0, 16, 8, 16, 16}
+	cellCopyCode   = [11]int{0, 8, 0, 8, 0, 8, 16, 0, 16, 8, 16}
+)
+
+func (d *decoder) compressed(mlen int) error {
+	br := d.br
+	var switchers [3]*blockSwitcher
+	for i := range switchers {
+		s, err := readBlockSwitcher(br)
+		if err != nil {
+			return err
+		}
+		switchers[i] = s
+	}
+	literalTypes, commandTypes, distanceTypes := switchers[0], switchers[1], switchers[2]
+	postfix, err := br.readBits(2)
+	if err != nil {
+		return err
+	}
+	direct, err := br.readBits(4)
+	if err != nil {
+		return err
+	}
+	direct <<= postfix
+	modes := make([]int, literalTypes.numTypes)
+	for i := range modes {
+		if modes[i], err = br.readBits(2); err != nil {
+			return err
+		}
+	}
+	numLiteralTrees, err := readVarLen(br)
+	if err != nil {
+		return err
+	}
+	literalMap, err := readContextMap(br, 64*literalTypes.numTypes, numLiteralTrees)
+	if err != nil {
+		return err
+	}
+	numDistanceTrees, err := readVarLen(br)
+	if err != nil {
+		return err
+	}
+	distanceMap, err := readContextMap(br, 4*distanceTypes.numTypes, numDistanceTrees)
+	if err != nil {
+		return err
+	}
+	readCodes := func(n, alphabetSize int) ([]*huffman, error) {
+		ret := make([]*huffman, n)
+		for i := range ret {
+			var err error
+			if ret[i], err = readPrefixCode(br, alphabetSize); err != nil {
+				return nil, err
+			}
+		}
+		return ret, nil
+	}
+	literalCodes, err := readCodes(numLiteralTrees, numLiterals)
+	if err != nil {
+		return err
+	}
+	commandCodes, err := readCodes(commandTypes.numTypes, numCommands)
+	if err != nil {
+		return err
+	}
+	distanceCodes, err := readCodes(numDistanceTrees, 16+direct+48<<postfix)
+	if err != nil {
+		return err
+	}
+
+	end := len(d.out) + mlen
+	for len(d.out) < end {
+		commandType, err := commandTypes.next(br)
+		if err != nil {
+			return err
+		}
+		symbol, err := commandCodes[commandType].read(br)
+		if err != nil {
+			return err
+		}
+		// the first two cells reuse the last distance
+		cell := symbol >> 6
+		implicitDistance := cell < 2
+		insertCode := cellInsertCode[cell] + symbol>>3&7
+		copyCode := cellCopyCode[cell] + symbol&7
+		insertLen, err := br.readBits(insertExtra[insertCode])
+		if err != nil {
+			return err
+		}
+		insertLen += insertBase[insertCode]
+		copyLen, err := br.readBits(copyExtra[copyCode])
+		if err != nil {
+			return err
+		}
+		copyLen += copyBase[copyCode]
+
+		for i := 0; i < insertLen; i++ {
+			literalType, err := literalTypes.next(br)
+			if err != nil {
+				return err
+			}
+			// the context only matters if there's
+			// more than one tree to pick from
+			ctx := 0
+			if numLiteralTrees > 1 {
+				if ctx, err = d.literalContext(modes[literalType]); err != nil {
+					return err
+				}
+			}
+			lit, err := literalCodes[literalMap[64*literalType+ctx]].read(br)
+			if err != nil {
+				return err
+			}
+			d.out = append(d.out, byte(lit))
+		}
+		if len(d.out) >= end {
+			break
+		}
+
+		distance := d.dists[0]
+		if !implicitDistance {
+			distanceType, err := distanceTypes.next(br)
+			if err != nil {
+				return err
+			}
+			ctx := copyLen - 2
+			if ctx > 3 {
+				ctx = 3
+			}
+			code, err := distanceCodes[distanceMap[4*distanceType+ctx]].read(br)
+			if err != nil {
+				return err
+			}
+			if distance, err = d.distance(code, postfix, direct); err != nil {
+				return err
+			}
+		}
+		maxDistance := len(d.out)
+		if maxDistance > d.maxWindow {
+			maxDistance = d.maxWindow
+		}
+		if distance <= 0 || distance > maxDistance {
+			return fmt.Errorf("brotli: distance %d is past the window, or a dictionary reference", distance)
+		}
+		if len(d.out)+copyLen > end {
+			return errors.New("brotli: copy past the end of the meta-block")
+		}
+		for i := 0; i < copyLen; i++ {
+			d.out = append(d.out, d.out[len(d.out)-distance])
+		}
+	}
+	if len(d.out) != end {
+		return errors.New("brotli: meta-block longer than its length")
+	}
+	return nil
+}
+
+// literalContext returns the context of the next literal in mode
+func (d *decoder) literalContext(mode int) (int, error) {
+	var p1 byte
+	if len(d.out) > 0 {
+		p1 = d.out[len(d.out)-1]
+	}
+	switch mode {
+	case 0:
+		return int(p1 & 0x3f), nil
+	case 1:
+		return int(p1 >> 2), nil
+	}
+	return 0, fmt.Errorf("brotli: context mode %d isn't supported", mode)
+}
+
+// distance decodes the distance code code, and
+// updates the ring of the last distances with it
+func (d *decoder) distance(code, postfix, direct int) (int, error) {
+	var distance int
+	switch {
+	case code == 0:
+		// the last distance, which doesn't go in the ring again
+		return d.dists[0], nil
+	case code < 16:
+		offsets := [16]int{0, 0, 0, 0, -1, 1, -2, 2, -3, 3, -1, 1, -2, 2, -3, 3}
+		switch {
+		case code < 4:
+			distance = d.dists[code]
+		case code < 10:
+			distance = d.dists[0] + offsets[code]
+		default:
+			distance = d.dists[1] + offsets[code]
+		}
+		if distance <= 0 {
+			return 0, errors.New("brotli: invalid last distance")
+		}
+	case code < 16+direct:
+		distance = code - 15
+	default:
+		x := code - direct - 16
+		ndistbits := uint(1 + x>>(postfix+1))
+		hcode := x >> postfix
+		lcode := x & (1<<postfix - 1)
+		offset := (2+hcode&1)<<ndistbits - 4
+		extra, err := d.br.readBits(ndistbits)
+		if err != nil {
+			return 0, err
+		}
+		distance = (offset+extra)<<postfix + lcode + direct + 1
+	}
+	d.dists = [4]int{distance, d.dists[0], d.dists[1], d.dists[2]}
+	return distance, nil
+}
+
+// referenceInputs are the inputs of the streams in testdata. Each
+// stream is named after its input, and the quality that the reference
+// encoder, libbrotlienc's BrotliEncoderCompress, compressed it at with
+// a window of 18 bits. Qualities above 2 use the static dictionary and
+// context modes that decode doesn't have
+func referenceInputs() map[string][]byte {
+	var js bytes.Buffer
+	js.WriteString("[")
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&js, `{"id":%d,"name":"item-%d","price":%d.%02d},`, i, i, i*7%100, i*13%100)
+	}
+	js.WriteString("]")
+	var structured []byte
+	for i := 0; i < 8000; i++ {
+		structured = append(structured, byte(i%7), byte(i*31%251), 0, 0x10)
+	}
+	random := make([]byte, 5000)
+	rand.New(rand.NewSource(1)).Read(random)
+	return map[string][]byte{
+		"json":       js.Bytes(),
+		"structured": structured,
+		"random":     random,
+	}
+}
+
+// Test to make sure decode decompresses streams from the reference
+// encoder, so that it can be trusted to check what a Writer writes
+func TestDecodeReference(t *testing.T) {
+	r := require.New(t)
+	inputs := referenceInputs()
+	files, err := filepath.Glob(filepath.Join("testdata", "*.br"))
+	r.NoError(err)
+	r.NotEmpty(files)
+	for _, file := range files {
+		stream, err := os.ReadFile(file)
+		r.NoError(err)
+		name := strings.SplitN(filepath.Base(file), ".", 2)[0]
+		input, ok := inputs[name]
+		r.True(ok, "no input for %s", file)
+		got, err := decode(stream)
+		r.NoError(err, file)
+		r.Equal(input, got, file)
+	}
+}
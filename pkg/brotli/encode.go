@@ -0,0 +1,435 @@
+package brotli
+
+import "sort"
+
+const (
+	numLiterals  = 256
+	numCommands  = 704
+	numDistances = 64
+	// maxCodeLength is the longest that a symbol's code may be, and
+	// maxCodeLengthCodeLength the longest that the code of a code
+	// length may be
+	maxCodeLength           = 15
+	maxCodeLengthCodeLength = 5
+)
+
+// insertBase and insertExtra are the shortest insert length of each
+// insert length code, and its number of extra bits. copyBase and
+// copyExtra are the same for copy length codes
+var (
+	insertBase = [24]int{
+		0, 1, 2, 3, 4, 5, 6, 8, 10, 14, 18, 26,
+		34, 50, 66, 98, 130, 194, 322, 578, 1090, 2114, 6210, 22594,
+	}
+	insertExtra = [24]uint{
+		0, 0, 0, 0, 0, 0, 1, 1, 2, 2, 3, 3,
+		4, 4, 5, 5, 6, 7, 8, 9, 10, 12, 14, 24,
+	}
+	copyBase = [24]int{
+		2, 3, 4, 5, 6, 7, 8, 9, 10, 12, 14, 18,
+		22, 30, 38, 54, 70, 102, 134, 198, 326, 582, 1094, 2118,
+	}
+	copyExtra = [24]uint{
+		0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 2, 2,
+		3, 3, 4, 4, 5, 5, 6, 7, 8, 9, 10, 24,
+	}
+)
+
+// codeLengthOrder is the order that the lengths of the codes of
+// code lengths are written in
+var codeLengthOrder = [18]int{1, 2, 3, 4, 0, 5, 17, 6, 16, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// codeLengthCodeLengthBits is the fixed code, and its length, that each
+// length of a code of a code length is written with
+var codeLengthCodeLengthBits = [6]struct {
+	bits uint64
+	n    uint
+}{{0, 2}, {7, 4}, {3, 3}, {2, 2}, {1, 2}, {15, 4}}
+
+// encodedCommand is a command, with its lengths and distance
+// split into symbols and extra bits
+type encodedCommand struct {
+	command
+	symbol      int
+	insertExtra uint64
+	insertBits  uint
+	copyExtra   uint64
+	copyBits    uint
+	distSymbol  int
+	distExtra   uint64
+	distBits    uint
+}
+
+// encodeCompressed writes a compressed meta-block, which isn't the
+// last one, with the literals in hist from start on and cmds to bw
+func encodeCompressed(bw *bitWriter, hist []byte, start int, cmds []command) {
+	var literalCounts [numLiterals]uint32
+	var commandCounts [numCommands]uint32
+	var distanceCounts [numDistances]uint32
+	encoded := make([]encodedCommand, len(cmds))
+	pos := start
+	for i, cmd := range cmds {
+		enc := encodedCommand{command: cmd}
+		insertCode := lengthCode(insertBase[:], cmd.insert)
+		enc.insertExtra = uint64(cmd.insert - insertBase[insertCode])
+		enc.insertBits = insertExtra[insertCode]
+		// an insert-only command still has a copy length, which
+		// the decoder ignores at the end of the meta-block
+		copyCode := 0
+		if cmd.copy > 0 {
+			copyCode = lengthCode(copyBase[:], cmd.copy)
+			enc.copyExtra = uint64(cmd.copy - copyBase[copyCode])
+			enc.copyBits = copyExtra[copyCode]
+			enc.distSymbol, enc.distExtra, enc.distBits = distanceCode(cmd.distance)
+			distanceCounts[enc.distSymbol]++
+		}
+		enc.symbol = commandSymbol(insertCode, copyCode)
+		commandCounts[enc.symbol]++
+		for _, b := range hist[pos : pos+cmd.insert] {
+			literalCounts[b]++
+		}
+		pos += cmd.insert + cmd.copy
+		encoded[i] = enc
+	}
+
+	writeMetaBlockHeader(bw, len(hist)-start, false)
+	// a single block type of each category
+	bw.writeBits(1, 0)
+	bw.writeBits(1, 0)
+	bw.writeBits(1, 0)
+	// NPOSTFIX and NDIRECT
+	bw.writeBits(2, 0)
+	bw.writeBits(4, 0)
+	// the literal context mode, and a single
+	// prefix code for literals and distances
+	bw.writeBits(2, 0)
+	bw.writeBits(1, 0)
+	bw.writeBits(1, 0)
+	literals := writePrefixCode(bw, literalCounts[:], 8)
+	commands := writePrefixCode(bw, commandCounts[:], 10)
+	distances := writePrefixCode(bw, distanceCounts[:], 6)
+
+	pos = start
+	for _, enc := range encoded {
+		commands.write(bw, enc.symbol)
+		bw.writeBits(enc.insertBits, enc.insertExtra)
+		bw.writeBits(enc.copyBits, enc.copyExtra)
+		for _, b := range hist[pos : pos+enc.insert] {
+			literals.write(bw, int(b))
+		}
+		pos += enc.insert
+		if enc.copy == 0 {
+			continue
+		}
+		distances.write(bw, enc.distSymbol)
+		bw.writeBits(enc.distBits, enc.distExtra)
+		pos += enc.copy
+	}
+}
+
+// writeMetaBlockHeader writes the header of a meta-block of mlen bytes,
+// which isn't the last one, to bw. mlen must be at most 1<<24
+func writeMetaBlockHeader(bw *bitWriter, mlen int, uncompressed bool) {
+	nibbles := uint(4)
+	for ; nibbles < 6 && (mlen-1)>>(4*nibbles) > 0; nibbles++ {
+	}
+	bw.writeBits(1, 0)
+	bw.writeBits(2, uint64(nibbles-4))
+	bw.writeBits(4*nibbles, uint64(mlen-1))
+	if uncompressed {
+		bw.writeBits(1, 1)
+	} else {
+		bw.writeBits(1, 0)
+	}
+}
+
+// lengthCode returns the index of the last of bases
+// that's no longer than length
+func lengthCode(bases []int, length int) int {
+	code := len(bases) - 1
+	for bases[code] > length {
+		code--
+	}
+	return code
+}
+
+// commandSymbol returns the symbol of the insert-and-copy length code
+// for insertCode and copyCode, with an explicit distance
+func commandSymbol(insertCode, copyCode int) int {
+	var cell int
+	switch {
+	case insertCode < 8 && copyCode < 8:
+		cell = 128
+	case insertCode < 8 && copyCode < 16:
+		cell = 192
+	case insertCode < 16 && copyCode < 8:
+		cell = 256
+	case insertCode < 16 && copyCode < 16:
+		cell = 320
+	case insertCode < 8:
+		cell = 384
+	case copyCode < 8:
+		cell = 448
+	case insertCode < 16:
+		cell = 512
+	case copyCode < 16:
+		cell = 576
+	default:
+		cell = 640
+	}
+	return cell + (insertCode&7)<<3 + copyCode&7
+}
+
+// distanceCode returns the distance symbol for distance, and its extra
+// bits, without any direct distance codes or postfix bits
+func distanceCode(distance int) (int, uint64, uint) {
+	d := distance + 3
+	bucket := uint(0)
+	for d>>(bucket+1) > 0 {
+		bucket++
+	}
+	// bucket is log2(d) now, and there are one
+	// fewer extra bits than that
+	nbits := bucket - 1
+	prefix := (d >> nbits) & 1
+	symbol := 16 + 2*int(nbits-1) + prefix
+	extra := uint64(d - (2+prefix)<<nbits)
+	return symbol, extra, nbits
+}
+
+// prefixCode is the code of each symbol of an alphabet, with its bits
+// reversed so that they can be written least significant bit first,
+// and the length of each code
+type prefixCode struct {
+	codes   []uint16
+	lengths []uint8
+}
+
+func (c prefixCode) write(bw *bitWriter, symbol int) {
+	bw.writeBits(uint(c.lengths[symbol]), uint64(c.codes[symbol]))
+}
+
+// writePrefixCode writes a prefix code for the symbols with nonzero
+// counts, which are of an alphabet that takes alphabetBits bits, to bw
+// and returns it
+func writePrefixCode(bw *bitWriter, counts []uint32, alphabetBits uint) prefixCode {
+	used := 0
+	last := 0
+	for sym, count := range counts {
+		if count > 0 {
+			used++
+			last = sym
+		}
+	}
+	if used < 2 {
+		// a simple prefix code with one symbol, which
+		// doesn't take any bits at all
+		bw.writeBits(2, 1)
+		bw.writeBits(2, 0)
+		bw.writeBits(alphabetBits, uint64(last))
+		return prefixCode{
+			codes:   make([]uint16, len(counts)),
+			lengths: make([]uint8, len(counts)),
+		}
+	}
+	all := codeLengths(counts, maxCodeLength)
+	// the decoder stops reading lengths once the code is
+	// complete, so the ones after the last symbol are left out
+	lengths := all[:last+1]
+
+	tokens := codeLengthTokens(lengths)
+	var lengthCounts [18]uint32
+	for _, t := range tokens {
+		lengthCounts[t.symbol]++
+	}
+	lengthLengths := codeLengths(lengthCounts[:], maxCodeLengthCodeLength)
+	lengthsCode := prefixCode{codes: canonicalCodes(lengthLengths), lengths: lengthLengths}
+	lastLength := 0
+	usedLengths := 0
+	for i, sym := range codeLengthOrder {
+		if lengthLengths[sym] > 0 {
+			lastLength = i
+			usedLengths++
+		}
+	}
+	if usedLengths < 2 {
+		// a single code length takes no bits, and the decoder
+		// reads the lengths of all the codes of code lengths
+		lastLength = len(codeLengthOrder) - 1
+		lengthLengths[tokens[0].symbol] = 1
+	}
+	// HSKIP
+	bw.writeBits(2, 0)
+	for _, sym := range codeLengthOrder[:lastLength+1] {
+		bits := codeLengthCodeLengthBits[lengthLengths[sym]]
+		bw.writeBits(bits.n, bits.bits)
+	}
+	if usedLengths >= 2 {
+		for _, t := range tokens {
+			lengthsCode.write(bw, t.symbol)
+			bw.writeBits(t.extraBits, t.extra)
+		}
+	}
+
+	return prefixCode{codes: canonicalCodes(all), lengths: all}
+}
+
+// codeLengthToken is a symbol of the code length alphabet, which is
+// either a code length or, for zeroRun, a run of zero code lengths,
+// along with its extra bits
+type codeLengthToken struct {
+	symbol    int
+	extra     uint64
+	extraBits uint
+}
+
+// zeroRun is the code length symbol that repeats a zero code length
+// 3 to 10 times, as its 3 extra bits say. Each of the zeroRuns that
+// follow another multiplies the previous repeat count, less 2, by 8
+const zeroRun = 17
+
+// codeLengthTokens returns the tokens that lengths are written as.
+// Runs of 3 or more zeros are written as zeroRuns
+func codeLengthTokens(lengths []uint8) []codeLengthToken {
+	tokens := []codeLengthToken{}
+	for i := 0; i < len(lengths); {
+		if lengths[i] != 0 {
+			tokens = append(tokens, codeLengthToken{symbol: int(lengths[i])})
+			i++
+			continue
+		}
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == 0 {
+			run++
+		}
+		i += run
+		if run < 3 {
+			for ; run > 0; run-- {
+				tokens = append(tokens, codeLengthToken{})
+			}
+			continue
+		}
+		// the extra bits are the digits, in base 8, of the
+		// repeat count, most significant first, with each
+		// digit but the last one offset by 1
+		digits := []uint64{}
+		for rest := run - 3; ; rest-- {
+			digits = append(digits, uint64(rest&7))
+			rest >>= 3
+			if rest == 0 {
+				break
+			}
+		}
+		for j := len(digits) - 1; j >= 0; j-- {
+			tokens = append(tokens, codeLengthToken{
+				symbol:    zeroRun,
+				extra:     digits[j],
+				extraBits: 3,
+			})
+		}
+	}
+	return tokens
+}
+
+// codeLengths returns the lengths of a prefix code for the symbols
+// with nonzero counts, none of them longer than maxLength. If there's
+// only one such symbol, its length is 0
+func codeLengths(counts []uint32, maxLength uint8) []uint8 {
+	type leaf struct {
+		symbol int
+		count  uint32
+	}
+	leaves := []leaf{}
+	for sym, count := range counts {
+		if count > 0 {
+			leaves = append(leaves, leaf{symbol: sym, count: count})
+		}
+	}
+	lengths := make([]uint8, len(counts))
+	for {
+		sort.Slice(leaves, func(i, j int) bool {
+			if leaves[i].count != leaves[j].count {
+				return leaves[i].count < leaves[j].count
+			}
+			return leaves[i].symbol < leaves[j].symbol
+		})
+		// the leaves come first, then the internal nodes in the
+		// order they're made, which is also the order of their
+		// weights, so the two lightest nodes are always at the
+		// front of either part
+		n := len(leaves)
+		weights := make([]uint64, 2*n-1)
+		parents := make([]int, 2*n-1)
+		for i, l := range leaves {
+			weights[i] = uint64(l.count)
+		}
+		nextLeaf, nextNode := 0, n
+		lightest := func(made int) int {
+			if nextLeaf < n && (nextNode >= made || weights[nextLeaf] <= weights[nextNode]) {
+				nextLeaf++
+				return nextLeaf - 1
+			}
+			nextNode++
+			return nextNode - 1
+		}
+		for made := n; made < 2*n-1; made++ {
+			a := lightest(made)
+			b := lightest(made)
+			weights[made] = weights[a] + weights[b]
+			parents[a], parents[b] = made, made
+		}
+		depths := make([]uint8, 2*n-1)
+		longest := uint8(0)
+		for i := 2*n - 3; i >= 0; i-- {
+			depths[i] = depths[parents[i]] + 1
+			if depths[i] > longest {
+				longest = depths[i]
+			}
+		}
+		if longest <= maxLength {
+			for i, l := range leaves {
+				lengths[l.symbol] = depths[i]
+			}
+			return lengths
+		}
+		// flatten the counts until the
+		// tree is shallow enough
+		for i := range leaves {
+			leaves[i].count = (leaves[i].count + 1) / 2
+		}
+	}
+}
+
+// canonicalCodes returns the canonical prefix code for lengths, with
+// each code's bits reversed
+func canonicalCodes(lengths []uint8) []uint16 {
+	var lengthCounts [maxCodeLength + 1]int
+	for _, l := range lengths {
+		lengthCounts[l]++
+	}
+	lengthCounts[0] = 0
+	var next [maxCodeLength + 1]int
+	code := 0
+	for l := 1; l <= maxCodeLength; l++ {
+		code = (code + lengthCounts[l-1]) << 1
+		next[l] = code
+	}
+	codes := make([]uint16, len(lengths))
+	for sym, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		codes[sym] = reverseBits(uint16(next[l]), l)
+		next[l]++
+	}
+	return codes
+}
+
+func reverseBits(code uint16, n uint8) uint16 {
+	ret := uint16(0)
+	for i := uint8(0); i < n; i++ {
+		ret = ret<<1 | code&1
+		code >>= 1
+	}
+	return ret
+}
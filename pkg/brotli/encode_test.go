@@ -0,0 +1,105 @@
+package brotli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodeLengths(t *testing.T) {
+	r := require.New(t)
+
+	// Fibonacci counts make the deepest trees,
+	// which have to be flattened
+	counts := make([]uint32, 30)
+	a, b := uint32(1), uint32(1)
+	for i := range counts {
+		counts[i] = a
+		a, b = b, a+b
+	}
+	lengths := codeLengths(counts, maxCodeLength)
+	space := 0
+	for _, l := range lengths {
+		r.NotZero(l)
+		r.LessOrEqual(l, uint8(maxCodeLength))
+		space += 1 << (maxCodeLength - l)
+	}
+	// the code is complete
+	r.Equal(1<<maxCodeLength, space)
+	// more frequent symbols don't get longer codes
+	for i := 1; i < len(lengths); i++ {
+		r.LessOrEqual(lengths[i], lengths[i-1])
+	}
+
+	r.Equal([]uint8{0, 0, 0}, codeLengths([]uint32{0, 5, 0}, maxCodeLength))
+}
+
+func TestCanonicalCodes(t *testing.T) {
+	r := require.New(t)
+	// the example in RFC 1951, section 3.2.2, with each
+	// code's bits reversed
+	codes := canonicalCodes([]uint8{3, 3, 3, 3, 3, 2, 4, 4})
+	r.Equal([]uint16{0b010, 0b110, 0b001, 0b101, 0b011, 0b00, 0b0111, 0b1111}, codes)
+}
+
+func TestCodeLengthTokens(t *testing.T) {
+	r := require.New(t)
+	for _, run := range []int{1, 2, 3, 10, 11, 12, 97, 700} {
+		lengths := make([]uint8, run+1)
+		lengths[run] = 4
+		// decode the tokens like the decoder does
+		decoded := []uint8{}
+		repeat := 0
+		prevRun := false
+		for _, tok := range codeLengthTokens(lengths) {
+			if tok.symbol != zeroRun {
+				decoded = append(decoded, uint8(tok.symbol))
+				prevRun = false
+				continue
+			}
+			old := 0
+			if prevRun {
+				old = repeat
+				repeat = (repeat - 2) << 3
+			} else {
+				repeat = 0
+			}
+			repeat += int(tok.extra) + 3
+			for i := old; i < repeat; i++ {
+				decoded = append(decoded, 0)
+			}
+			prevRun = true
+		}
+		r.Equal(lengths, decoded, "run of %d", run)
+	}
+}
+
+func TestDistanceCode(t *testing.T) {
+	r := require.New(t)
+	for _, distance := range []int{1, 2, 3, 4, 5, 100, 4096, 65536, maxDistance} {
+		symbol, extra, nbits := distanceCode(distance)
+		r.GreaterOrEqual(symbol, 16)
+		r.Less(symbol, numDistances)
+		r.Less(extra, uint64(1)<<nbits)
+		// decode it like the decoder does, without
+		// direct distance codes or postfix bits
+		x := symbol - 16
+		ndistbits := 1 + x>>1
+		offset := (2+x&1)<<ndistbits - 4
+		r.Equal(uint(ndistbits), nbits)
+		r.Equal(distance, offset+int(extra)+1)
+	}
+}
+
+func TestCommandSymbol(t *testing.T) {
+	r := require.New(t)
+	r.Equal(128, commandSymbol(0, 0))
+	r.Equal(128+3<<3+5, commandSymbol(3, 5))
+	r.Equal(192+7, commandSymbol(0, 15))
+	r.Equal(256+1<<3, commandSymbol(9, 0))
+	r.Equal(384+2, commandSymbol(0, 18))
+	r.Equal(448+1<<3, commandSymbol(17, 0))
+	r.Equal(512, commandSymbol(8, 16))
+	r.Equal(576+7<<3+7, commandSymbol(23, 15))
+	r.Equal(703, commandSymbol(23, 23))
+}
@@ -0,0 +1,241 @@
+// Package brotli has a brotli (RFC 7932) encoder that favors speed
+// over compression ratio, for compressing responses on the fly
+package brotli
+
+import (
+	"errors"
+	"io"
+)
+
+const (
+	// windowBits is the log2 of the window size that the streams
+	// declare. It's big enough for the longest distance that a
+	// Writer ever uses
+	windowBits  = 18
+	maxDistance = 1<<windowBits - 16
+	// blockSize is the most input that goes into a single
+	// meta-block
+	blockSize = 1 << 16
+	// historySize is how much of the input that was already
+	// compressed is kept around for matches
+	historySize = 1 << 16
+	minMatch    = 4
+	hashBits    = 14
+)
+
+var errClosed = errors.New("brotli: write to a closed Writer")
+
+// Writer compresses what's written to it into a brotli stream. It finds
+// matches with a single-entry hash table, and gives each meta-block its
+// own prefix codes, without context modeling or the static dictionary.
+// Meta-blocks that don't get any smaller are stored uncompressed.
+//
+// Use NewWriter to create one of these
+type Writer struct {
+	dst io.Writer
+	bw  bitWriter
+	// hist holds the input that was compressed recently, followed by
+	// the input that wasn't compressed yet, from start on. base is
+	// the position of hist[0] in the whole input
+	hist  []byte
+	start int
+	base  int
+	// table holds the position in the whole input, plus one, of the
+	// last input that hashed to each entry
+	table       [1 << hashBits]int
+	wroteHeader bool
+	closed      bool
+	err         error
+}
+
+// NewWriter creates a new Writer that writes the compressed stream
+// to dst
+func NewWriter(dst io.Writer) *Writer {
+	w := &Writer{}
+	w.Reset(dst)
+	return w
+}
+
+// Reset discards w's state, and makes it write a new stream to dst,
+// so that it can be reused
+func (w *Writer) Reset(dst io.Writer) {
+	w.dst = dst
+	w.bw.reset()
+	w.hist = w.hist[:0]
+	w.start = 0
+	w.base = 0
+	for i := range w.table {
+		w.table[i] = 0
+	}
+	w.wroteHeader = false
+	w.closed = false
+	w.err = nil
+}
+
+// Write compresses p. Compressed data is written to the underlying
+// writer once there's a full meta-block of it, or when w is flushed or
+// closed
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.closed {
+		return 0, errClosed
+	}
+	n := len(p)
+	for len(p) > 0 {
+		room := blockSize - (len(w.hist) - w.start)
+		if room > len(p) {
+			room = len(p)
+		}
+		w.hist = append(w.hist, p[:room]...)
+		p = p[room:]
+		if len(w.hist)-w.start == blockSize {
+			w.encodePending()
+			if err := w.writeOut(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Flush compresses what was written to w so far, and writes all of it
+// to the underlying writer, so that a reader can decompress it
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.closed {
+		return errClosed
+	}
+	w.encodePending()
+	// an empty metadata meta-block pads the stream
+	// to a byte boundary
+	w.writeHeader()
+	w.bw.writeBits(1, 0)
+	w.bw.writeBits(2, 3)
+	w.bw.writeBits(1, 0)
+	w.bw.writeBits(2, 0)
+	w.bw.align()
+	return w.writeOut()
+}
+
+// Close compresses what was written to w so far, and finishes the
+// stream. It doesn't close the underlying writer
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.closed {
+		return nil
+	}
+	w.encodePending()
+	w.writeHeader()
+	// an empty last meta-block
+	w.bw.writeBits(1, 1)
+	w.bw.writeBits(1, 1)
+	w.bw.align()
+	w.closed = true
+	return w.writeOut()
+}
+
+// writeHeader writes the stream header, unless it was already
+func (w *Writer) writeHeader() {
+	if w.wroteHeader {
+		return
+	}
+	w.bw.writeBits(1, 1)
+	w.bw.writeBits(3, windowBits-17)
+	w.wroteHeader = true
+}
+
+// writeOut writes the complete bytes that w encoded
+// to the underlying writer
+func (w *Writer) writeOut() error {
+	if len(w.bw.buf) == 0 {
+		return nil
+	}
+	_, err := w.dst.Write(w.bw.buf)
+	w.bw.buf = w.bw.buf[:0]
+	if err != nil {
+		w.err = err
+	}
+	return err
+}
+
+// encodePending encodes the input that wasn't encoded yet as a
+// meta-block, then drops the input that's too old for matches
+func (w *Writer) encodePending() {
+	if w.start == len(w.hist) {
+		return
+	}
+	w.writeHeader()
+	cmds := w.findMatches(w.start, len(w.hist))
+	data := w.hist[w.start:]
+	var block bitWriter
+	encodeCompressed(&block, w.hist, w.start, cmds)
+	if (len(block.buf)*8+int(block.nbits))/8 < len(data) {
+		w.bw.appendBits(&block)
+	} else {
+		writeMetaBlockHeader(&w.bw, len(data), true)
+		w.bw.align()
+		w.bw.buf = append(w.bw.buf, data...)
+	}
+	w.start = len(w.hist)
+	if drop := w.start - historySize; drop > 0 {
+		w.hist = w.hist[:copy(w.hist, w.hist[drop:])]
+		w.start -= drop
+		w.base += drop
+	}
+}
+
+// command is an insert of literals, followed by a copy
+// of earlier input
+type command struct {
+	insert   int
+	copy     int
+	distance int
+}
+
+// findMatches splits w.hist[start:end] into commands. The last one
+// only inserts, unless the input ends with a copy
+func (w *Writer) findMatches(start, end int) []command {
+	cmds := []command{}
+	hist := w.hist
+	litStart := start
+	i := start
+	for i+minMatch <= end {
+		cur := load32(hist, i)
+		h := hash(cur)
+		cand := w.table[h] - 1 - w.base
+		w.table[h] = w.base + i + 1
+		if cand < 0 || i-cand > maxDistance || load32(hist, cand) != cur {
+			i++
+			continue
+		}
+		length := minMatch
+		for i+length < end && hist[cand+length] == hist[i+length] {
+			length++
+		}
+		cmds = append(cmds, command{
+			insert:   i - litStart,
+			copy:     length,
+			distance: i - cand,
+		})
+		i += length
+		litStart = i
+	}
+	if litStart < end {
+		cmds = append(cmds, command{insert: end - litStart})
+	}
+	return cmds
+}
+
+func load32(b []byte, i int) uint32 {
+	return uint32(b[i]) | uint32(b[i+1])<<8 | uint32(b[i+2])<<16 | uint32(b[i+3])<<24
+}
+
+func hash(u uint32) uint32 {
+	return (u * 0x1e35a7bd) >> (32 - hashBits)
+}
@@ -0,0 +1,161 @@
+package brotli
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter(t *testing.T) {
+	r := require.New(t)
+
+	// these streams decode to their input with
+	// the reference decoder
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	r.NoError(w.Close())
+	r.Equal([]byte{0x33}, out.Bytes())
+
+	out.Reset()
+	w.Reset(&out)
+	_, err := w.Write([]byte(strings.Repeat("abc", 20)))
+	r.NoError(err)
+	r.NoError(w.Close())
+	r.Equal([]byte{
+		0x83, 0x1d, 0x00, 0x00, 0x80, 0x0d, 0xe0,
+		0x80, 0xf8, 0x8b, 0x6f, 0x22, 0x9a, 0x66,
+	}, out.Bytes())
+
+	// input that doesn't get any smaller is stored
+	out.Reset()
+	w.Reset(&out)
+	_, err = w.Write([]byte("hello, "))
+	r.NoError(err)
+	r.NoError(w.Close())
+	r.Equal(append(
+		[]byte{0x03, 0x03, 0x80},
+		append([]byte("hello, "), 0x03)...,
+	), out.Bytes())
+
+	_, err = w.Write([]byte("hello"))
+	r.Error(err)
+}
+
+func TestWriterFlush(t *testing.T) {
+	r := require.New(t)
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	_, err := w.Write([]byte("hello, "))
+	r.NoError(err)
+	// nothing is written until there's a
+	// full block, or w is flushed
+	r.Equal(0, out.Len())
+	r.NoError(w.Flush())
+	r.Equal(append(
+		[]byte{0x03, 0x03, 0x80},
+		append([]byte("hello, "), 0x06)...,
+	), out.Bytes())
+
+	// a full block is written as soon as it's there
+	out.Reset()
+	w.Reset(&out)
+	_, err = w.Write(bytes.Repeat([]byte("abcd"), blockSize/4))
+	r.NoError(err)
+	r.NotZero(out.Len())
+	r.Less(out.Len(), blockSize/100)
+}
+
+// Test to make sure that what a Writer writes decompresses to what was
+// written to it, however it was written
+func TestWriterRoundTrip(t *testing.T) {
+	random := make([]byte, 3*blockSize+123)
+	rand.New(rand.NewSource(1)).Read(random)
+	repetitive := bytes.Repeat([]byte("<li class=\"item\">hello, world</li>\n"), 10000)
+	multiBlock := make([]byte, 0, 5*blockSize)
+	for i := 0; len(multiBlock) < cap(multiBlock); i++ {
+		multiBlock = append(multiBlock, fmt.Sprintf("line %d of %d\n", i, i%97)...)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		input []byte
+		// chunk is the size of the writes, all at once if it's 0,
+		// and flush is whether the Writer is flushed after each
+		chunk int
+		flush bool
+	}{
+		{name: "empty"},
+		{name: "small", input: []byte("hello, world")},
+		{name: "multiple blocks", input: multiBlock},
+		{name: "incompressible", input: random},
+		{name: "repetitive", input: repetitive},
+		{name: "small writes", input: multiBlock, chunk: 1000},
+		{name: "flushed", input: multiBlock[:2*blockSize+500], chunk: 5000, flush: true},
+		{name: "flushed across blocks", input: random, chunk: blockSize + 7, flush: true},
+		{name: "flushed repetitive", input: repetitive[:20000], chunk: 333, flush: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+			var out bytes.Buffer
+			w := NewWriter(&out)
+			for p := tc.input; len(p) > 0; {
+				n := len(p)
+				if tc.chunk > 0 && n > tc.chunk {
+					n = tc.chunk
+				}
+				written, err := w.Write(p[:n])
+				r.NoError(err)
+				r.Equal(n, written)
+				p = p[n:]
+				if tc.flush {
+					r.NoError(w.Flush())
+					// everything so far can be
+					// decompressed right away
+					flushed := len(tc.input) - len(p)
+					r.Equal(tc.input[:flushed], decodeFlushed(t, out.Bytes()))
+				}
+			}
+			r.NoError(w.Close())
+			got, err := decode(out.Bytes())
+			r.NoError(err)
+			r.Equal(len(tc.input), len(got))
+			r.True(bytes.Equal(tc.input, got), "decompressed output differs from the input")
+		})
+	}
+}
+
+// decodeFlushed decodes the stream that a Writer wrote up to a
+// flush, by finishing it with an empty last meta-block
+func decodeFlushed(t *testing.T, stream []byte) []byte {
+	t.Helper()
+	got, err := decode(append(append([]byte{}, stream...), 0x03))
+	require.NoError(t, err)
+	return got
+}
+
+// Test to make sure the Writer's output is no bigger than needed, for
+// input that compresses well and input that doesn't compress at all
+func TestWriterRatio(t *testing.T) {
+	r := require.New(t)
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	_, err := w.Write(bytes.Repeat([]byte("<li class=\"item\">hello, world</li>\n"), 10000))
+	r.NoError(err)
+	r.NoError(w.Close())
+	r.Less(out.Len(), 1000)
+
+	// incompressible blocks are stored, with a few
+	// bytes of headers each
+	random := make([]byte, 3*blockSize)
+	rand.New(rand.NewSource(1)).Read(random)
+	out.Reset()
+	w.Reset(&out)
+	_, err = w.Write(random)
+	r.NoError(err)
+	r.NoError(w.Close())
+	r.LessOrEqual(out.Len(), len(random)+4*3+2)
+}
@@ -0,0 +1,361 @@
+// Package config loads the configuration of the HTTP Add-on's binaries
+// from, in increasing order of precedence, the defaults in their
+// envconfig struct tags, a YAML config file, environment variables and
+// command line flags
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// ConfigFileEnv is the environment variable that holds the path of the
+// config file, if the --config-file flag isn't passed
+const ConfigFileEnv = "KEDA_HTTP_CONFIG_FILE"
+
+// Validator is implemented by specs that have constraints beyond what
+// envconfig can check, like a field that only takes a few values
+type Validator interface {
+	Validate() error
+}
+
+// Loader loads specs, which are pointers to structs whose fields have
+// envconfig tags, from layered sources.
+//
+// Every source uses the same keys, which are the environment variable
+// names in the specs' envconfig tags. The config file is a YAML map from
+// keys to values, and values are given on the command line with
+// --set KEY=VALUE
+type Loader struct {
+	specs       []interface{}
+	flags       *flag.FlagSet
+	file        string
+	sets        keyValues
+	printConfig bool
+	known       map[string]struct{}
+	// fromEnv holds the keys that were in the
+	// environment before the first Load
+	fromEnv map[string]struct{}
+	// fromFile holds the keys that the last
+	// load put in the environment from the file
+	fromFile map[string]struct{}
+}
+
+// NewLoader creates a new Loader for specs. name is the name of the
+// binary, for usage messages
+func NewLoader(name string, specs ...interface{}) *Loader {
+	ret := &Loader{
+		specs:    specs,
+		flags:    flag.NewFlagSet(name, flag.ContinueOnError),
+		sets:     keyValues{},
+		known:    map[string]struct{}{},
+		fromEnv:  map[string]struct{}{},
+		fromFile: map[string]struct{}{},
+	}
+	ret.flags.StringVar(
+		&ret.file,
+		"config-file",
+		os.Getenv(ConfigFileEnv),
+		fmt.Sprintf("YAML file to read the configuration from (or set %s)", ConfigFileEnv),
+	)
+	ret.flags.Var(
+		ret.sets,
+		"set",
+		"KEY=VALUE to set a configuration value, overriding the config file and environment. Can be repeated",
+	)
+	ret.flags.BoolVar(
+		&ret.printConfig,
+		"print-config",
+		false,
+		"print the loaded configuration as a config file, and exit",
+	)
+	return ret
+}
+
+// Load parses the command line arguments args, then loads l's specs
+// from the layered sources and validates them.
+//
+// The config file and --set values are put in the process' environment,
+// so that anything else that reads the environment sees the same
+// configuration
+func (l *Loader) Load(args []string) error {
+	if err := l.flags.Parse(args); err != nil {
+		return err
+	}
+	for _, spec := range l.specs {
+		for _, key := range specKeys(spec) {
+			l.known[key] = struct{}{}
+			if _, inEnv := os.LookupEnv(key); inEnv {
+				l.fromEnv[key] = struct{}{}
+			}
+		}
+	}
+	for key := range l.sets {
+		if _, ok := l.known[key]; !ok {
+			return fmt.Errorf("unknown key %q in --set", key)
+		}
+	}
+	return l.load(l.specs)
+}
+
+// Reload re-reads the config file and loads specs, which must be new
+// values of some of the specs that l was created with, from it. The
+// environment and the command line arguments from Load still override
+// the file. Keys that were removed from the file go back to their
+// defaults.
+//
+// The specs that Load filled in aren't changed, so callers can hand
+// the new values to the parts of the program that can use them while
+// they're running
+func (l *Loader) Reload(specs ...interface{}) error {
+	return l.load(specs)
+}
+
+// ConfigFile returns the path of the config file, or the empty string
+// if there isn't one
+func (l *Loader) ConfigFile() string {
+	return l.file
+}
+
+func (l *Loader) load(specs []interface{}) error {
+	fromFile := map[string]string{}
+	if l.file != "" {
+		var err error
+		fromFile, err = readConfigFile(l.file)
+		if err != nil {
+			return err
+		}
+	}
+	for key := range fromFile {
+		if _, ok := l.known[key]; !ok {
+			return fmt.Errorf("unknown key %q in config file %s", key, l.file)
+		}
+	}
+
+	// the environment overrides the config
+	// file, and --set overrides both
+	for key := range l.fromFile {
+		if _, ok := fromFile[key]; ok {
+			continue
+		}
+		if err := os.Unsetenv(key); err != nil {
+			return errors.Wrapf(err, "unsetting %s", key)
+		}
+		delete(l.fromFile, key)
+	}
+	for key, val := range fromFile {
+		if _, inEnv := l.fromEnv[key]; inEnv {
+			continue
+		}
+		if err := os.Setenv(key, val); err != nil {
+			return errors.Wrapf(err, "setting %s", key)
+		}
+		l.fromFile[key] = struct{}{}
+	}
+	for key, val := range l.sets {
+		if err := os.Setenv(key, val); err != nil {
+			return errors.Wrapf(err, "setting %s", key)
+		}
+	}
+
+	for _, spec := range specs {
+		if err := envconfig.Process("", spec); err != nil {
+			return errors.Wrap(err, "loading configuration")
+		}
+		if validator, ok := spec.(Validator); ok {
+			if err := validator.Validate(); err != nil {
+				return errors.Wrap(err, "invalid configuration")
+			}
+		}
+	}
+	return nil
+}
+
+// PrintConfig returns true if --print-config was passed
+func (l *Loader) PrintConfig() bool {
+	return l.printConfig
+}
+
+// Print writes l's loaded specs to w as a config file
+func (l *Loader) Print(w io.Writer) error {
+	vals := map[string]string{}
+	for _, spec := range l.specs {
+		for key, val := range specValues(spec) {
+			vals[key] = val
+		}
+	}
+	b, err := yaml.Marshal(vals)
+	if err != nil {
+		return errors.Wrap(err, "marshaling configuration")
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// MustLoad loads specs with a new Loader, from the command line
+// arguments in os.Args, and returns the Loader. If --print-config was
+// passed, it prints the configuration to stdout and exits. If loading
+// failed, it prints the error to stderr and exits with a non-zero status
+func MustLoad(name string, specs ...interface{}) *Loader {
+	l := NewLoader(name, specs...)
+	if err := l.Load(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if !l.PrintConfig() {
+		return l
+	}
+	if err := l.Print(os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+	return l
+}
+
+// keyValues is a flag.Value that collects
+// repeated KEY=VALUE arguments
+type keyValues map[string]string
+
+func (k keyValues) String() string {
+	pairs := make([]string, 0, len(k))
+	for key, val := range k {
+		pairs = append(pairs, key+"="+val)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (k keyValues) Set(s string) error {
+	split := strings.SplitN(s, "=", 2)
+	if len(split) != 2 || split[0] == "" {
+		return fmt.Errorf("%q isn't KEY=VALUE", s)
+	}
+	k[split[0]] = split[1]
+	return nil
+}
+
+// readConfigFile reads the YAML config file at path. Values are
+// converted to the strings that envconfig parses, so lists become
+// comma-separated values and maps become comma-separated key:value
+// pairs
+func readConfigFile(path string) (map[string]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading config file %s", path)
+	}
+	raw := map[string]interface{}{}
+	if err := yaml.Unmarshal(b, &raw); err != nil {
+		return nil, errors.Wrapf(err, "parsing config file %s", path)
+	}
+	ret := make(map[string]string, len(raw))
+	for key, val := range raw {
+		ret[key] = fileValueString(val)
+	}
+	return ret, nil
+}
+
+func fileValueString(val interface{}) string {
+	switch v := val.(type) {
+	case nil:
+		return ""
+	case float64:
+		// YAML numbers come back as float64s, so
+		// format whole numbers without a decimal point
+		if v == float64(int64(v)) {
+			return fmt.Sprintf("%d", int64(v))
+		}
+		return fmt.Sprint(v)
+	case []interface{}:
+		elts := make([]string, 0, len(v))
+		for _, elt := range v {
+			elts = append(elts, fileValueString(elt))
+		}
+		return strings.Join(elts, ",")
+	case map[string]interface{}:
+		pairs := make([]string, 0, len(v))
+		for mapKey, mapVal := range v {
+			pairs = append(pairs, mapKey+":"+fileValueString(mapVal))
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// specFields calls fn with the key and value of every field of spec,
+// which is a pointer to a struct, that has an envconfig tag
+func specFields(spec interface{}, fn func(key string, val reflect.Value)) {
+	v := reflect.Indirect(reflect.ValueOf(spec))
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := t.Field(i).Tag.Get("envconfig")
+		if key == "" {
+			continue
+		}
+		fn(key, v.Field(i))
+	}
+}
+
+func specKeys(spec interface{}) []string {
+	ret := []string{}
+	specFields(spec, func(key string, _ reflect.Value) {
+		ret = append(ret, key)
+	})
+	return ret
+}
+
+// specValues returns the value of each of spec's fields, in the format
+// that envconfig parses
+func specValues(spec interface{}) map[string]string {
+	ret := map[string]string{}
+	specFields(spec, func(key string, val reflect.Value) {
+		ret[key] = specValueString(val)
+	})
+	return ret
+}
+
+func specValueString(val reflect.Value) string {
+	switch val.Kind() {
+	case reflect.Ptr:
+		if val.IsNil() {
+			return ""
+		}
+		return specValueString(val.Elem())
+	case reflect.Slice:
+		elts := make([]string, 0, val.Len())
+		for i := 0; i < val.Len(); i++ {
+			elts = append(elts, specValueString(val.Index(i)))
+		}
+		return strings.Join(elts, ",")
+	case reflect.Map:
+		pairs := make([]string, 0, val.Len())
+		iter := val.MapRange()
+		for iter.Next() {
+			pairs = append(
+				pairs,
+				specValueString(iter.Key())+":"+specValueString(iter.Value()),
+			)
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ",")
+	default:
+		// this covers time.Duration too, since
+		// it's a fmt.Stringer
+		return fmt.Sprint(val.Interface())
+	}
+}
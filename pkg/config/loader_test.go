@@ -0,0 +1,171 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testSpec struct {
+	Port    int               `envconfig:"KEDA_HTTP_TEST_LOADER_PORT" default:"8080"`
+	Name    string            `envconfig:"KEDA_HTTP_TEST_LOADER_NAME" default:"default"`
+	Timeout time.Duration     `envconfig:"KEDA_HTTP_TEST_LOADER_TIMEOUT" default:"1s"`
+	Hosts   []string          `envconfig:"KEDA_HTTP_TEST_LOADER_HOSTS" default:""`
+	Labels  map[string]string `envconfig:"KEDA_HTTP_TEST_LOADER_LABELS" default:""`
+}
+
+func (t *testSpec) Validate() error {
+	if t.Port <= 0 {
+		return errors.New("port must be positive")
+	}
+	return nil
+}
+
+// clearTestEnv unsets the test spec's environment variables, which
+// Load sets, before and after the test
+func clearTestEnv(t *testing.T) {
+	clear := func() {
+		for _, key := range specKeys(&testSpec{}) {
+			os.Unsetenv(key)
+		}
+	}
+	clear()
+	t.Cleanup(clear)
+}
+
+func writeConfigFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0600))
+	return path
+}
+
+func TestLoaderLayering(t *testing.T) {
+	r := require.New(t)
+	clearTestEnv(t)
+	path := writeConfigFile(t, `
+KEDA_HTTP_TEST_LOADER_PORT: 9090
+KEDA_HTTP_TEST_LOADER_NAME: fromfile
+KEDA_HTTP_TEST_LOADER_TIMEOUT: 5s
+KEDA_HTTP_TEST_LOADER_HOSTS:
+- a.com
+- b.com
+KEDA_HTTP_TEST_LOADER_LABELS:
+  app: web
+`)
+	r.NoError(os.Setenv("KEDA_HTTP_TEST_LOADER_NAME", "fromenv"))
+	r.NoError(os.Setenv("KEDA_HTTP_TEST_LOADER_TIMEOUT", "2s"))
+
+	spec := new(testSpec)
+	l := NewLoader("test", spec)
+	r.NoError(l.Load([]string{
+		"--config-file", path,
+		"--set", "KEDA_HTTP_TEST_LOADER_TIMEOUT=3s",
+	}))
+	// the file overrides the defaults, the environment overrides
+	// the file, and --set overrides the environment
+	r.Equal(9090, spec.Port)
+	r.Equal("fromenv", spec.Name)
+	r.Equal(3*time.Second, spec.Timeout)
+	r.Equal([]string{"a.com", "b.com"}, spec.Hosts)
+	r.Equal(map[string]string{"app": "web"}, spec.Labels)
+	r.False(l.PrintConfig())
+}
+
+func TestLoaderDefaults(t *testing.T) {
+	r := require.New(t)
+	clearTestEnv(t)
+	spec := new(testSpec)
+	r.NoError(NewLoader("test", spec).Load(nil))
+	r.Equal(8080, spec.Port)
+	r.Equal("default", spec.Name)
+	r.Equal(time.Second, spec.Timeout)
+}
+
+func TestLoaderErrors(t *testing.T) {
+	clearTestEnv(t)
+	testCases := map[string][]string{
+		"unknown key in file": {
+			"--config-file",
+			writeConfigFile(t, "KEDA_HTTP_TEST_LOADER_PROT: 1\n"),
+		},
+		"unknown key in --set": {"--set", "KEDA_HTTP_TEST_LOADER_PROT=1"},
+		"malformed --set":      {"--set", "KEDA_HTTP_TEST_LOADER_PORT"},
+		"missing file":         {"--config-file", "/does/not/exist.yaml"},
+		"unparseable value":    {"--set", "KEDA_HTTP_TEST_LOADER_PORT=abc"},
+		"invalid value":        {"--set", "KEDA_HTTP_TEST_LOADER_PORT=-1"},
+	}
+	for name, args := range testCases {
+		t.Run(name, func(t *testing.T) {
+			clearTestEnv(t)
+			l := NewLoader("test", new(testSpec))
+			l.flags.SetOutput(ioutil.Discard)
+			require.Error(t, l.Load(args))
+		})
+	}
+}
+
+func TestLoaderPrint(t *testing.T) {
+	r := require.New(t)
+	clearTestEnv(t)
+	spec := new(testSpec)
+	l := NewLoader("test", spec)
+	r.NoError(l.Load([]string{
+		"--print-config",
+		"--set", "KEDA_HTTP_TEST_LOADER_HOSTS=a.com,b.com",
+	}))
+	r.True(l.PrintConfig())
+	buf := new(bytes.Buffer)
+	r.NoError(l.Print(buf))
+
+	// the printed configuration loads back into the same values
+	clearTestEnv(t)
+	reloaded := new(testSpec)
+	path := writeConfigFile(t, buf.String())
+	r.NoError(NewLoader("test", reloaded).Load([]string{"--config-file", path}))
+	r.Equal(spec.Port, reloaded.Port)
+	r.Equal(spec.Name, reloaded.Name)
+	r.Equal(spec.Timeout, reloaded.Timeout)
+	r.Equal(spec.Hosts, reloaded.Hosts)
+	r.Empty(reloaded.Labels)
+}
+
+func TestLoaderReload(t *testing.T) {
+	r := require.New(t)
+	clearTestEnv(t)
+	path := writeConfigFile(t, `
+KEDA_HTTP_TEST_LOADER_PORT: 9090
+KEDA_HTTP_TEST_LOADER_NAME: first
+`)
+	r.NoError(os.Setenv("KEDA_HTTP_TEST_LOADER_TIMEOUT", "2s"))
+	spec := new(testSpec)
+	l := NewLoader("test", spec)
+	r.NoError(l.Load([]string{"--config-file", path}))
+	r.Equal(path, l.ConfigFile())
+	r.Equal(9090, spec.Port)
+
+	// the environment still overrides the file, and keys that were
+	// removed from the file go back to their defaults
+	r.NoError(ioutil.WriteFile(path, []byte(`
+KEDA_HTTP_TEST_LOADER_NAME: second
+KEDA_HTTP_TEST_LOADER_TIMEOUT: 5s
+`), 0600))
+	reloaded := new(testSpec)
+	r.NoError(l.Reload(reloaded))
+	r.Equal(8080, reloaded.Port)
+	r.Equal("second", reloaded.Name)
+	r.Equal(2*time.Second, reloaded.Timeout)
+	// the spec from Load doesn't change
+	r.Equal(9090, spec.Port)
+	r.Equal("first", spec.Name)
+
+	// an invalid file is an error
+	r.NoError(ioutil.WriteFile(path, []byte("KEDA_HTTP_TEST_LOADER_PROT: 1\n"), 0600))
+	r.Error(l.Reload(new(testSpec)))
+}
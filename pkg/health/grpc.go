@@ -0,0 +1,59 @@
+package health
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// NewGRPCServer creates a server for the grpc.health.v1.Health service
+// whose overall status, for the empty service name, is SERVING as long
+// as the gRPC server runs, like /livez. services start out NOT_SERVING
+// until UpdateGRPC runs checks for them
+func NewGRPCServer(services ...string) *health.Server {
+	ret := health.NewServer()
+	for _, svc := range services {
+		ret.SetServingStatus(svc, healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+	return ret
+}
+
+// UpdateGRPC runs checks every interval, and sets the status of
+// services in srv to SERVING if they all pass and to NOT_SERVING
+// otherwise, like /readyz. When ctx is done, it sets every service in
+// srv to NOT_SERVING, so that clients that watch it move on, and
+// returns
+func UpdateGRPC(
+	ctx context.Context,
+	srv *health.Server,
+	interval time.Duration,
+	checks map[string]Check,
+	services ...string,
+) {
+	update := func() {
+		status := healthpb.HealthCheckResponse_SERVING
+		for _, check := range checks {
+			if check() != nil {
+				status = healthpb.HealthCheckResponse_NOT_SERVING
+				break
+			}
+		}
+		for _, svc := range services {
+			srv.SetServingStatus(svc, status)
+		}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	update()
+	for {
+		select {
+		case <-ctx.Done():
+			srv.Shutdown()
+			return
+		case <-ticker.C:
+			update()
+		}
+	}
+}
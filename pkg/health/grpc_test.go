@@ -0,0 +1,50 @@
+package health
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestUpdateGRPC(t *testing.T) {
+	const svc = "test.Service"
+	r := require.New(t)
+	ctx, done := context.WithCancel(context.Background())
+	defer done()
+	srv := NewGRPCServer(svc)
+	status := func(name string) healthpb.HealthCheckResponse_ServingStatus {
+		res, err := srv.Check(ctx, &healthpb.HealthCheckRequest{Service: name})
+		r.NoError(err)
+		return res.Status
+	}
+	r.Equal(healthpb.HealthCheckResponse_NOT_SERVING, status(svc))
+	r.Equal(healthpb.HealthCheckResponse_SERVING, status(""))
+
+	f := &Flag{}
+	updated := make(chan struct{})
+	go func() {
+		defer close(updated)
+		UpdateGRPC(ctx, srv, 10*time.Millisecond, map[string]Check{"flag": f.Check}, svc)
+	}()
+	// the service only serves once its checks pass
+	time.Sleep(50 * time.Millisecond)
+	r.Equal(healthpb.HealthCheckResponse_NOT_SERVING, status(svc))
+	f.Set()
+	r.Eventually(func() bool {
+		return status(svc) == healthpb.HealthCheckResponse_SERVING
+	}, time.Second, 10*time.Millisecond)
+	f.Unset()
+	r.Eventually(func() bool {
+		return status(svc) == healthpb.HealthCheckResponse_NOT_SERVING
+	}, time.Second, 10*time.Millisecond)
+
+	// once ctx is done, nothing is serving anymore
+	f.Set()
+	done()
+	<-updated
+	r.Equal(healthpb.HealthCheckResponse_NOT_SERVING, status(svc))
+	r.Equal(healthpb.HealthCheckResponse_NOT_SERVING, status(""))
+}
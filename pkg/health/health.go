@@ -0,0 +1,168 @@
+// Package health has the liveness and readiness probe endpoints that
+// the interceptor and scaler serve, so that Kubernetes doesn't send
+// traffic to a pod before all of its dependencies are ready
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// Check returns nil if the dependency it checks is ready, and an
+// error describing why not otherwise
+type Check func() error
+
+// Flag is a Check that passes once Set is called, until Unset is
+// called. It's for dependencies that signal their own readiness, like
+// a server that has started listening. The zero value is unset
+type Flag struct {
+	v int32
+	// Reason is the error message that Check returns while
+	// the flag is unset
+	Reason string
+}
+
+// Set marks f as ready
+func (f *Flag) Set() {
+	atomic.StoreInt32(&f.v, 1)
+}
+
+// Unset marks f as not ready
+func (f *Flag) Unset() {
+	atomic.StoreInt32(&f.v, 0)
+}
+
+// Check returns nil if f is set and an error otherwise
+func (f *Flag) Check() error {
+	if atomic.LoadInt32(&f.v) == 1 {
+		return nil
+	}
+	if f.Reason == "" {
+		return fmt.Errorf("not ready")
+	}
+	return fmt.Errorf("%s", f.Reason)
+}
+
+// SyncedCheck returns a Check for a cache or informer with a
+// HasSynced-style function
+func SyncedCheck(name string, hasSynced func() bool) Check {
+	return func() error {
+		if !hasSynced() {
+			return fmt.Errorf("%s is not synced", name)
+		}
+		return nil
+	}
+}
+
+// failing runs all of checks, in the order of their names, and returns
+// the failures
+func failing(names []string, checks map[string]Check) []string {
+	failures := []string{}
+	for _, name := range names {
+		if err := checks[name](); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", name, err))
+		}
+	}
+	return failures
+}
+
+// sortedNames returns the names of checks, sorted
+func sortedNames(checks map[string]Check) []string {
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WaitFor blocks until all of checks pass, polling them every
+// pollInterval, and logs the ones that are still failing every
+// logInterval. It returns an error with the failing checks if they
+// don't all pass within timeout, or if ctx is done first. A timeout of
+// 0 means it waits until ctx is done
+func WaitFor(
+	ctx context.Context,
+	lggr logr.Logger,
+	timeout,
+	pollInterval,
+	logInterval time.Duration,
+	checks map[string]Check,
+) error {
+	lggr = lggr.WithName("pkg.health.WaitFor")
+	names := sortedNames(checks)
+	start := time.Now()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	poll := time.NewTicker(pollInterval)
+	defer poll.Stop()
+	lastLog := start
+	for {
+		failures := failing(names, checks)
+		if len(failures) == 0 {
+			lggr.Info("all checks passed", "elapsed", time.Since(start).String())
+			return nil
+		}
+		if time.Since(lastLog) >= logInterval {
+			lastLog = time.Now()
+			lggr.Info(
+				"waiting for checks to pass",
+				"elapsed", time.Since(start).String(),
+				"failures", failures,
+			)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf(
+				"checks didn't pass after %s (%w): %s",
+				time.Since(start).Round(time.Millisecond),
+				ctx.Err(),
+				strings.Join(failures, "; "),
+			)
+		case <-poll.C:
+		}
+	}
+}
+
+// Paths are the paths of the probe routes that AddRoutes adds
+var Paths = []string{"/healthz", "/livez", "/readyz"}
+
+// AddRoutes adds the probe routes to mux:
+//
+//   - /healthz and /livez return 200 as long as the process can serve
+//     HTTP. Kubernetes should restart the pod if they fail
+//   - /readyz runs all of checks and returns 200 if they all pass, or
+//     503 with the failures otherwise. Kubernetes should stop routing
+//     traffic to the pod while it fails
+func AddRoutes(lggr logr.Logger, mux *http.ServeMux, checks map[string]Check) {
+	lggr = lggr.WithName("pkg.health.AddRoutes")
+	alive := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		w.Write([]byte("ok"))
+	}
+	mux.HandleFunc("/healthz", alive)
+	mux.HandleFunc("/livez", alive)
+
+	names := sortedNames(checks)
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		failures := failing(names, checks)
+		if len(failures) > 0 {
+			lggr.V(1).Info("readiness check failed", "failures", failures)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(strings.Join(failures, "\n")))
+			return
+		}
+		w.WriteHeader(200)
+		w.Write([]byte("ok"))
+	})
+}
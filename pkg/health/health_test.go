@@ -0,0 +1,91 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlag(t *testing.T) {
+	r := require.New(t)
+	f := &Flag{Reason: "server isn't listening"}
+	r.EqualError(f.Check(), "server isn't listening")
+	f.Set()
+	r.NoError(f.Check())
+	f.Unset()
+	r.Error(f.Check())
+	r.Error((&Flag{}).Check())
+}
+
+func TestRoutes(t *testing.T) {
+	r := require.New(t)
+	synced := false
+	flag := &Flag{Reason: "not serving"}
+	mux := http.NewServeMux()
+	AddRoutes(logr.Discard(), mux, map[string]Check{
+		"cache":   SyncedCheck("the cache", func() bool { return synced }),
+		"server":  flag.Check,
+		"healthy": func() error { return nil },
+	})
+	get := func(path string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", path, nil)
+		r.NoError(err)
+		mux.ServeHTTP(res, req)
+		return res
+	}
+
+	// liveness doesn't depend on the checks
+	r.Equal(200, get("/healthz").Code)
+	r.Equal(200, get("/livez").Code)
+
+	res := get("/readyz")
+	r.Equal(503, res.Code)
+	r.Contains(res.Body.String(), "cache: the cache is not synced")
+	r.Contains(res.Body.String(), "server: not serving")
+	r.NotContains(res.Body.String(), "healthy")
+
+	synced = true
+	flag.Set()
+	res = get("/readyz")
+	r.Equal(200, res.Code)
+	r.Equal("ok", res.Body.String())
+}
+
+func TestSyncedCheck(t *testing.T) {
+	r := require.New(t)
+	r.NoError(SyncedCheck("x", func() bool { return true })())
+	r.EqualError(SyncedCheck("x", func() bool { return false })(), "x is not synced")
+}
+
+func TestWaitFor(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	flag := &Flag{Reason: "not loaded"}
+	checks := map[string]Check{
+		"table":   flag.Check,
+		"healthy": func() error { return nil },
+	}
+
+	// the checks that never pass are in the timeout's error
+	err := WaitFor(ctx, logr.Discard(), 50*time.Millisecond, time.Millisecond, time.Millisecond, checks)
+	r.Error(err)
+	r.Contains(err.Error(), "table: not loaded")
+	r.NotContains(err.Error(), "healthy")
+
+	// it returns once the checks pass
+	time.AfterFunc(20*time.Millisecond, flag.Set)
+	r.NoError(WaitFor(ctx, logr.Discard(), time.Second, time.Millisecond, time.Millisecond, checks))
+
+	// without a timeout, it waits until ctx is done
+	flag.Unset()
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	err = WaitFor(ctx, logr.Discard(), 0, time.Millisecond, time.Second, checks)
+	r.ErrorIs(err, context.DeadlineExceeded)
+}
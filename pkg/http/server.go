@@ -2,14 +2,30 @@ package http
 
 import (
 	"context"
+	"crypto/tls"
+	"net"
 	"net/http"
 )
 
-func ServeContext(ctx context.Context, addr string, hdl http.Handler) error {
+// ServerOption customizes the http.Server that ServeContext or
+// ServeContextTLS runs
+type ServerOption func(*http.Server)
+
+// ServeContext serves hdl on addr until ctx is done. opts are applied
+// to the server before it starts
+func ServeContext(
+	ctx context.Context,
+	addr string,
+	hdl http.Handler,
+	opts ...ServerOption,
+) error {
 	srv := &http.Server{
 		Handler: hdl,
 		Addr:    addr,
 	}
+	for _, opt := range opts {
+		opt(srv)
+	}
 
 	go func() {
 		<-ctx.Done()
@@ -17,3 +33,76 @@ func ServeContext(ctx context.Context, addr string, hdl http.Handler) error {
 	}()
 	return srv.ListenAndServe()
 }
+
+// ServeContextTLS is like ServeContext, but serves TLS with tlsCfg.
+// tlsCfg must provide the server certificate, with Certificates,
+// GetCertificate or GetConfigForClient
+func ServeContextTLS(
+	ctx context.Context,
+	addr string,
+	tlsCfg *tls.Config,
+	hdl http.Handler,
+	opts ...ServerOption,
+) error {
+	srv := &http.Server{
+		Handler:   hdl,
+		Addr:      addr,
+		TLSConfig: tlsCfg,
+	}
+	for _, opt := range opts {
+		opt(srv)
+	}
+
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(ctx)
+	}()
+	return srv.ListenAndServeTLS("", "")
+}
+
+// ServeListenerContext is like ServeContext, but serves on ln instead
+// of listening on an address, so that callers can wrap the listener.
+// ln is closed when ServeListenerContext returns
+func ServeListenerContext(
+	ctx context.Context,
+	ln net.Listener,
+	hdl http.Handler,
+	opts ...ServerOption,
+) error {
+	srv := &http.Server{
+		Handler: hdl,
+	}
+	for _, opt := range opts {
+		opt(srv)
+	}
+
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(ctx)
+	}()
+	return srv.Serve(ln)
+}
+
+// ServeListenerContextTLS is like ServeContextTLS, but serves on ln.
+// ln is closed when ServeListenerContextTLS returns
+func ServeListenerContextTLS(
+	ctx context.Context,
+	ln net.Listener,
+	tlsCfg *tls.Config,
+	hdl http.Handler,
+	opts ...ServerOption,
+) error {
+	srv := &http.Server{
+		Handler:   hdl,
+		TLSConfig: tlsCfg,
+	}
+	for _, opt := range opts {
+		opt(srv)
+	}
+
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(ctx)
+	}()
+	return srv.ServeTLS(ln, "", "")
+}
@@ -0,0 +1,118 @@
+package k8s
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	"k8s.io/client-go/rest"
+	crcache "sigs.k8s.io/controller-runtime/pkg/cache"
+)
+
+// CacheOption customizes the cache that NewCache creates
+type CacheOption func(*cacheOptions)
+
+type cacheOptions struct {
+	namespaces []string
+	selectors  crcache.SelectorsByObject
+}
+
+// WithSelectors restricts the informers of the cache to the objects that
+// match selectors, if there's one for their kind. Objects that don't
+// match are never listed or watched, so they don't take up memory
+func WithSelectors(selectors crcache.SelectorsByObject) CacheOption {
+	return func(opts *cacheOptions) {
+		opts.selectors = selectors
+	}
+}
+
+// WithNamespaces adds namespaces to the ones that the cache holds
+// objects in. Each namespace gets its own informers, which only list
+// and watch that namespace, so a cache for a few namespaces doesn't
+// need access to the whole cluster
+func WithNamespaces(namespaces ...string) CacheOption {
+	return func(opts *cacheOptions) {
+		opts.namespaces = append(opts.namespaces, namespaces...)
+	}
+}
+
+// newCacheOptions returns the options that opts set, for a cache in
+// namespace ns. The namespaces are deduplicated and sorted. An empty
+// ns is every namespace, so other namespaces are left out
+func newCacheOptions(ns string, opts ...CacheOption) cacheOptions {
+	ret := cacheOptions{namespaces: []string{ns}}
+	for _, opt := range opts {
+		opt(&ret)
+	}
+	if ns == "" {
+		ret.namespaces = []string{""}
+		return ret
+	}
+	seen := map[string]bool{}
+	namespaces := []string{}
+	for _, namespace := range ret.namespaces {
+		if namespace != "" && !seen[namespace] {
+			seen[namespace] = true
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	sort.Strings(namespaces)
+	ret.namespaces = namespaces
+	return ret
+}
+
+// NewCache creates a controller-runtime cache for the objects in
+// namespace ns, or in every namespace if ns is empty. The caches in
+// this package, like InformerDeploymentCache, share it, so every kind
+// of object that they hold is listed and watched only once. The
+// informers re-deliver all of their objects every resyncEvery, as a
+// fallback in case they missed a change. opts restrict the objects
+// that it holds, or add namespaces to it. Call StartCache to run the
+// cache
+func NewCache(
+	cfg *rest.Config,
+	ns string,
+	resyncEvery time.Duration,
+	opts ...CacheOption,
+) (crcache.Cache, error) {
+	cacheOpts := newCacheOptions(ns, opts...)
+	crOpts := crcache.Options{
+		Resync:            &resyncEvery,
+		SelectorsByObject: cacheOpts.selectors,
+	}
+	newCache := crcache.New
+	if len(cacheOpts.namespaces) == 1 {
+		crOpts.Namespace = cacheOpts.namespaces[0]
+	} else {
+		newCache = crcache.MultiNamespacedCacheBuilder(cacheOpts.namespaces)
+	}
+	ret, err := newCache(cfg, crOpts)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating the cache")
+	}
+	return ret, nil
+}
+
+// StartCache runs c's informers until ctx is done. It returns an error
+// if they couldn't be synced. Informers that are added to c after it
+// started are started right away
+func StartCache(ctx context.Context, lggr logr.Logger, c crcache.Cache) error {
+	lggr = lggr.WithName("pkg.k8s.StartCache")
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.Start(ctx)
+	}()
+	if !c.WaitForCacheSync(ctx) {
+		if ctx.Err() != nil {
+			return errors.Wrap(ctx.Err(), "context is done")
+		}
+		return errors.New("failed to sync the cache")
+	}
+	lggr.Info("cache synced")
+	if err := <-errCh; err != nil {
+		return errors.Wrap(err, "running the cache")
+	}
+	return errors.Wrap(ctx.Err(), "context is done")
+}
@@ -0,0 +1,251 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	crcache "sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// fakeCache is a controller-runtime cache whose informers list and
+// watch with a client-go clientset, like the fake one, rather than a
+// rest.Config. Like a real cache, it's scoped to a namespace and
+// restricts each kind of object with its selector
+type fakeCache struct {
+	cl          kubernetes.Interface
+	ns          string
+	resyncEvery time.Duration
+	selectors   crcache.SelectorsByObject
+	// mut protects factories and ctx
+	mut       *sync.Mutex
+	factories map[reflect.Type]informers.SharedInformerFactory
+	started   chan struct{}
+	ctx       context.Context
+}
+
+var _ crcache.Cache = &fakeCache{}
+
+func newFakeCache(
+	cl kubernetes.Interface,
+	ns string,
+	resyncEvery time.Duration,
+	selectors crcache.SelectorsByObject,
+) *fakeCache {
+	return &fakeCache{
+		cl:          cl,
+		ns:          ns,
+		resyncEvery: resyncEvery,
+		selectors:   selectors,
+		mut:         new(sync.Mutex),
+		factories:   map[reflect.Type]informers.SharedInformerFactory{},
+		started:     make(chan struct{}),
+	}
+}
+
+func (f *fakeCache) informerFor(obj runtime.Object) (cache.SharedIndexInformer, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	typ := reflect.TypeOf(obj)
+	switch obj.(type) {
+	case *appsv1.DeploymentList:
+		typ = reflect.TypeOf(&appsv1.Deployment{})
+	case *v1.EndpointsList:
+		typ = reflect.TypeOf(&v1.Endpoints{})
+	case *v1.PodList:
+		typ = reflect.TypeOf(&v1.Pod{})
+	case *discoveryv1.EndpointSliceList:
+		typ = reflect.TypeOf(&discoveryv1.EndpointSlice{})
+	}
+	factory, ok := f.factories[typ]
+	if !ok {
+		opts := []informers.SharedInformerOption{informers.WithNamespace(f.ns)}
+		for selObj, sel := range f.selectors {
+			if reflect.TypeOf(selObj) == typ {
+				sel := sel
+				opts = append(opts, informers.WithTweakListOptions(sel.ApplyToList))
+			}
+		}
+		factory = informers.NewSharedInformerFactoryWithOptions(f.cl, f.resyncEvery, opts...)
+		f.factories[typ] = factory
+	}
+	var informer cache.SharedIndexInformer
+	switch obj.(type) {
+	case *appsv1.Deployment, *appsv1.DeploymentList:
+		informer = factory.Apps().V1().Deployments().Informer()
+	case *v1.Endpoints, *v1.EndpointsList:
+		informer = factory.Core().V1().Endpoints().Informer()
+	case *v1.Pod, *v1.PodList:
+		informer = factory.Core().V1().Pods().Informer()
+	case *discoveryv1.EndpointSlice, *discoveryv1.EndpointSliceList:
+		informer = factory.Discovery().V1().EndpointSlices().Informer()
+	default:
+		return nil, fmt.Errorf("fakeCache doesn't support %T", obj)
+	}
+	// like in a real cache, informers that are
+	// added after the cache started start right away
+	select {
+	case <-f.started:
+		factory.Start(f.ctx.Done())
+	default:
+	}
+	return informer, nil
+}
+
+func (f *fakeCache) GetInformer(_ context.Context, obj client.Object) (crcache.Informer, error) {
+	return f.informerFor(obj)
+}
+
+func (f *fakeCache) GetInformerForKind(context.Context, schema.GroupVersionKind) (crcache.Informer, error) {
+	return nil, fmt.Errorf("fakeCache doesn't support GetInformerForKind")
+}
+
+func (f *fakeCache) Start(ctx context.Context) error {
+	f.mut.Lock()
+	f.ctx = ctx
+	close(f.started)
+	for _, factory := range f.factories {
+		factory.Start(ctx.Done())
+	}
+	f.mut.Unlock()
+	<-ctx.Done()
+	return nil
+}
+
+func (f *fakeCache) WaitForCacheSync(ctx context.Context) bool {
+	select {
+	case <-f.started:
+	case <-ctx.Done():
+		return false
+	}
+	f.mut.Lock()
+	factories := make([]informers.SharedInformerFactory, 0, len(f.factories))
+	for _, factory := range f.factories {
+		factories = append(factories, factory)
+	}
+	f.mut.Unlock()
+	for _, factory := range factories {
+		for _, synced := range factory.WaitForCacheSync(ctx.Done()) {
+			if !synced {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (f *fakeCache) IndexField(context.Context, client.Object, string, client.IndexerFunc) error {
+	return fmt.Errorf("fakeCache doesn't support IndexField")
+}
+
+func (f *fakeCache) Get(_ context.Context, key client.ObjectKey, obj client.Object) error {
+	informer, err := f.informerFor(obj)
+	if err != nil {
+		return err
+	}
+	item, exists, err := informer.GetIndexer().GetByKey(key.String())
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return apierrors.NewNotFound(schema.GroupResource{}, key.Name)
+	}
+	reflect.ValueOf(obj).Elem().Set(
+		reflect.ValueOf(item.(runtime.Object).DeepCopyObject()).Elem(),
+	)
+	return nil
+}
+
+func (f *fakeCache) List(_ context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	informer, err := f.informerFor(list)
+	if err != nil {
+		return err
+	}
+	listOpts := &client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+	items := []runtime.Object{}
+	for _, item := range informer.GetIndexer().List() {
+		obj := item.(client.Object)
+		if listOpts.Namespace != "" && obj.GetNamespace() != listOpts.Namespace {
+			continue
+		}
+		if listOpts.LabelSelector != nil &&
+			!listOpts.LabelSelector.Matches(labels.Set(obj.GetLabels())) {
+			continue
+		}
+		items = append(items, obj.DeepCopyObject())
+	}
+	return meta.SetList(list, items)
+}
+
+func TestStartCache(t *testing.T) {
+	r := require.New(t)
+	ctx, done := context.WithCancel(context.Background())
+	const ns = "testns"
+	cl := k8sfake.NewSimpleClientset(&v1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: "testsvc"},
+	})
+	c := newFakeCache(cl, ns, time.Minute, nil)
+	endptsCache, err := NewInformerEndpointsCache(ctx, c, ns)
+	r.NoError(err)
+	errCh := make(chan error)
+	go func() {
+		errCh <- StartCache(ctx, logr.Discard(), c)
+	}()
+	r.Eventually(endptsCache.HasSynced, time.Second, 10*time.Millisecond)
+	_, err = endptsCache.Get("testsvc")
+	r.NoError(err)
+
+	// caches that are created after the cache
+	// started are synced too
+	deplCache, err := NewInformerDeploymentCache(ctx, logr.Discard(), c, ns, WatchBackoff{})
+	r.NoError(err)
+	r.Eventually(deplCache.HasSynced, time.Second, 10*time.Millisecond)
+
+	done()
+	select {
+	case err := <-errCh:
+		r.ErrorIs(err, context.Canceled)
+	case <-time.After(time.Second):
+		r.FailNow("the cache didn't stop")
+	}
+}
+
+func TestNewCacheOptions(t *testing.T) {
+	r := require.New(t)
+	r.Equal([]string{"testns"}, newCacheOptions("testns").namespaces)
+
+	selectors := crcache.SelectorsByObject{
+		&appsv1.Deployment{}: {Label: labels.SelectorFromSet(labels.Set{"app": "testing"})},
+	}
+	opts := newCacheOptions(
+		"testns",
+		WithNamespaces("other", "testns", ""),
+		WithNamespaces("another"),
+		WithSelectors(selectors),
+	)
+	r.Equal([]string{"another", "other", "testns"}, opts.namespaces)
+	r.Equal(selectors, opts.selectors)
+
+	// a cache for every namespace already has the others
+	r.Equal([]string{""}, newCacheOptions("", WithNamespaces("other")).namespaces)
+}
@@ -38,6 +38,17 @@ type ConfigMapGetterWatcher interface {
 	ConfigMapWatcher
 }
 
+// ConfigMapGetterCreatorUpdater is a pared down version of a ConfigMapInterface
+// (found here: https://pkg.go.dev/k8s.io/client-go@v0.21.3/kubernetes/typed/core/v1#ConfigMapInterface).
+//
+// Pass this whenever possible to functions that need to get, create and update
+// individual ConfigMaps in Kubernetes, and nothing else.
+type ConfigMapGetterCreatorUpdater interface {
+	ConfigMapGetter
+	Create(ctx context.Context, configMap *corev1.ConfigMap, opts metav1.CreateOptions) (*corev1.ConfigMap, error)
+	Update(ctx context.Context, configMap *corev1.ConfigMap, opts metav1.UpdateOptions) (*corev1.ConfigMap, error)
+}
+
 // newConfigMap creates a new configMap structure
 func NewConfigMap(
 	namespace string,
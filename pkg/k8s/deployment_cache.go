@@ -5,13 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"sync"
-	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/pkg/errors"
 	appsv1 "k8s.io/api/apps/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	crcache "sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 type DeploymentCache interface {
@@ -19,190 +20,160 @@ type DeploymentCache interface {
 	Watch(name string) watch.Interface
 }
 
-type K8sDeploymentCache struct {
-	latest      map[string]appsv1.Deployment
-	rwm         *sync.RWMutex
-	cl          DeploymentListerWatcher
+// InformerDeploymentCache is a DeploymentCache that's kept up to date
+// by the Deployments informer of a controller-runtime cache (see
+// NewCache)
+type InformerDeploymentCache struct {
+	cache       crcache.Cache
+	informer    crcache.Informer
+	ns          string
 	broadcaster *watch.Broadcaster
+	circuit     *watchCircuit
 }
 
-func NewK8sDeploymentCache(
+// NewInformerDeploymentCache creates a new InformerDeploymentCache for
+// the Deployments in namespace ns that c holds. Restrict c to the
+// Deployments that need to be cached with WithSelectors when it's
+// created. c may hold other namespaces too, with WithNamespaces, in
+// which case each namespace gets its own InformerDeploymentCache on
+// the same c. The cache stops delivering events to watchers when ctx
+// is done.
+//
+// When the informer's list or watch on the API server fails, it backs
+// off according to backoff before it reconnects. This must be called
+// before c is started, since the backoff can't be changed after that
+func NewInformerDeploymentCache(
 	ctx context.Context,
 	lggr logr.Logger,
-	cl DeploymentListerWatcher,
-) (*K8sDeploymentCache, error) {
-	lggr = lggr.WithName("pkg.k8s.NewK8sDeploymentCache")
-	bcaster := watch.NewBroadcaster(5, watch.DropIfChannelFull)
-
-	ret := &K8sDeploymentCache{
-		latest:      map[string]appsv1.Deployment{},
-		rwm:         new(sync.RWMutex),
-		broadcaster: bcaster,
-		cl:          cl,
-	}
-	deployList, err := cl.List(ctx, metav1.ListOptions{})
-	if err != nil {
-		lggr.Error(
-			err,
-			"failed to fetch initial deployment list",
-		)
+	c crcache.Cache,
+	ns string,
+	backoff WatchBackoff,
+) (*InformerDeploymentCache, error) {
+	if err := backoff.Validate(); err != nil {
 		return nil, err
 	}
-	ret.mergeAndBroadcastList(deployList)
-	return ret, nil
-}
-
-func (k *K8sDeploymentCache) MarshalJSON() ([]byte, error) {
-	k.rwm.RLock()
-	defer k.rwm.RUnlock()
-	ret := map[string]int32{}
-	for name, depl := range k.latest {
-		ret[name] = depl.Status.ReadyReplicas
-	}
-	return json.Marshal(ret)
-}
-
-func (k *K8sDeploymentCache) StartWatcher(
-	ctx context.Context,
-	lggr logr.Logger,
-	fetchTickDur time.Duration,
-) error {
-	lggr = lggr.WithName(
-		"pkg.k8s.K8sDeploymentCache.StartWatcher",
-	)
-	watcher, err := k.cl.Watch(ctx, metav1.ListOptions{})
+	informer, err := c.GetInformer(ctx, &appsv1.Deployment{})
 	if err != nil {
-		lggr.Error(
-			err,
-			"couldn't create new watch stream",
-		)
-		return errors.Wrap(
-			err,
-			"error creating new watch stream",
-		)
+		return nil, errors.Wrap(err, "getting the deployment informer")
 	}
-
-	ch := watcher.ResultChan()
-	fetchTicker := time.NewTicker(fetchTickDur)
-	defer fetchTicker.Stop()
-	for {
-		select {
-		case <-fetchTicker.C:
-			deplList, err := k.cl.List(ctx, metav1.ListOptions{})
-			if err != nil {
-				lggr.Error(
-					err,
-					"error with periodic deployment fetch",
-				)
-				return errors.Wrap(
-					err,
-					"error with periodic deployment fetch",
-				)
-			}
-			k.mergeAndBroadcastList(deplList)
-		case evt, validRecv := <-ch:
-			// handle closed watch stream
-			if !validRecv {
-				newWatcher, err := k.cl.Watch(ctx, metav1.ListOptions{})
-				if err != nil {
-					lggr.Error(
-						err,
-						"watch stream was closed and couldn't re-open it",
-					)
-					return errors.Wrap(
-						err,
-						"failed to re-open watch stream",
-					)
-				}
-				ch = newWatcher.ResultChan()
-			} else {
-				if err := k.addEvt(evt); err != nil {
-					lggr.Error(
-						err,
-						"couldn't add event to the deployment cache",
-					)
-					return errors.Wrap(
-						err,
-						"error adding event to the deployment cache",
-					)
-				}
-				k.broadcaster.Action(evt.Type, evt.Object)
-			}
-		case <-ctx.Done():
-			lggr.Error(
-				ctx.Err(),
-				"context is done",
-			)
-			return errors.Wrap(
-				ctx.Err(),
-				"context is marked done",
-			)
-		}
+	ret := &InformerDeploymentCache{
+		cache:       c,
+		informer:    informer,
+		ns:          ns,
+		broadcaster: watch.NewBroadcaster(5, watch.DropIfChannelFull),
+		circuit: newWatchCircuit(
+			ctx,
+			lggr.WithName("pkg.k8s.InformerDeploymentCache"),
+			backoff,
+		),
 	}
-}
-
-// mergeList adds each deployment in lst to the internal
-// list of events and broadcasts a new event for each
-// one.
-func (k *K8sDeploymentCache) mergeAndBroadcastList(
-	lst *appsv1.DeploymentList,
-) {
-	k.rwm.Lock()
-	defer k.rwm.Unlock()
-	for _, depl := range lst.Items {
-		k.latest[depl.ObjectMeta.Name] = depl
-		// if the deployment isn't already in the cache,
-		// we need to broadcast an ADDED event, otherwise
-		// broadcast a MODIFIED event
-		_, ok := k.latest[depl.ObjectMeta.Name]
-		evtType := watch.Modified
-		if !ok {
-			evtType = watch.Added
+	// controller-runtime's informers are client-go shared informers,
+	// which retry with a fixed backoff unless they're given a handler
+	if withHandler, ok := informer.(interface {
+		SetWatchErrorHandler(cache.WatchErrorHandler) error
+	}); ok {
+		if err := withHandler.SetWatchErrorHandler(ret.circuit.onError); err != nil {
+			return nil, errors.Wrap(err, "setting the deployment informer's backoff")
 		}
-
-		k.broadcaster.Action(evtType, &depl)
 	}
+	// the resource version that the informer synced to only moves
+	// once a list or watch succeeds, which closes the circuit
+	if withVersion, ok := informer.(interface {
+		LastSyncResourceVersion() string
+	}); ok {
+		ret.circuit.syncVersion = withVersion.LastSyncResourceVersion
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			ret.broadcast(watch.Added, obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldDepl, oldOK := oldObj.(*appsv1.Deployment)
+			newDepl, newOK := newObj.(*appsv1.Deployment)
+			// resyncs re-deliver what's already cached, but a
+			// change came from the API server
+			if oldOK && newOK && oldDepl.ResourceVersion != newDepl.ResourceVersion {
+				ret.circuit.onSuccess()
+			}
+			ret.broadcast(watch.Modified, newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			ret.broadcast(watch.Deleted, obj)
+		},
+	})
+	go func() {
+		<-ctx.Done()
+		ret.broadcaster.Shutdown()
+	}()
+	return ret, nil
 }
 
-// addEvt checks to make sure evt.Object is an actual
-// Deployment. if it isn't, returns a descriptive error.
-// otherwise, adds evt to the internal events list
-func (k *K8sDeploymentCache) addEvt(evt watch.Event) error {
-	k.rwm.Lock()
-	defer k.rwm.Unlock()
-	depl, ok := evt.Object.(*appsv1.Deployment)
-	// if we didn't get back a deployment in the event,
-	// something is wrong that we can't fix, so just continue
+func (i *InformerDeploymentCache) broadcast(evtType watch.EventType, obj interface{}) {
+	// deletes may come through as tombstones, which
+	// don't have a usable Deployment in them
+	depl, ok := obj.(*appsv1.Deployment)
 	if !ok {
-		return fmt.Errorf(
-			"watch event did not contain a Deployment",
-		)
+		return
 	}
-	k.latest[depl.GetObjectMeta().GetName()] = *depl
-	return nil
+	i.broadcaster.Action(evtType, depl)
 }
 
-func (k *K8sDeploymentCache) Get(name string) (appsv1.Deployment, error) {
-	k.rwm.RLock()
-	defer k.rwm.RUnlock()
-	depl, ok := k.latest[name]
-	if !ok {
-		return appsv1.Deployment{}, fmt.Errorf("no deployment %s found", name)
+// HasSynced returns true once the informer has its initial
+// list of Deployments
+func (i *InformerDeploymentCache) HasSynced() bool {
+	return i.informer.HasSynced()
+}
+
+// Healthy returns an error if the circuit of the informer's
+// WatchBackoff is open, in which case the cache may be out of date. It
+// closes as soon as a list or watch succeeds
+func (i *InformerDeploymentCache) Healthy() error {
+	return i.circuit.Check()
+}
+
+func (i *InformerDeploymentCache) Get(name string) (appsv1.Deployment, error) {
+	var depl appsv1.Deployment
+	if err := i.cache.Get(context.Background(), ObjKey(i.ns, name), &depl); err != nil {
+		return appsv1.Deployment{}, err
 	}
 	return depl, nil
 }
 
-func (k *K8sDeploymentCache) Watch(name string) watch.Interface {
-	watcher := k.broadcaster.Watch()
+// Watch returns a watch.Interface that gets an event every time the
+// Deployment called name, in the cache's namespace, changes. Every
+// caller gets its own stream of events, fanned out from the informer's
+// single watch on the API server. Events are dropped for callers that
+// fall behind, rather than hold up the informer, so callers that wait
+// for a change must also re-Get the Deployment now and then
+func (i *InformerDeploymentCache) Watch(name string) watch.Interface {
+	watcher := i.broadcaster.Watch()
 	return watch.Filter(watcher, func(evt watch.Event) (watch.Event, bool) {
 		depl, ok := evt.Object.(*appsv1.Deployment)
 		if !ok {
 			return evt, false
 		}
-		return evt, depl.ObjectMeta.Name == name
+		return evt, depl.ObjectMeta.Namespace == i.ns && depl.ObjectMeta.Name == name
 	})
 }
 
+// MarshalJSON returns the number of ready replicas for each of
+// the Deployments in the cache
+func (i *InformerDeploymentCache) MarshalJSON() ([]byte, error) {
+	var deplList appsv1.DeploymentList
+	if err := i.cache.List(
+		context.Background(),
+		&deplList,
+		client.InNamespace(i.ns),
+	); err != nil {
+		return nil, err
+	}
+	ret := map[string]int32{}
+	for _, depl := range deplList.Items {
+		ret[depl.Name] = depl.Status.ReadyReplicas
+	}
+	return json.Marshal(ret)
+}
+
 // MemoryDeploymentCache is a purely in-memory DeploymentCache implementation.
 //
 // To ensure this is concurrency-safe, be sure to use RWM properly to protect
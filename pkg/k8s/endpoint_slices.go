@@ -0,0 +1,212 @@
+package k8s
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/client-go/tools/cache"
+	crcache "sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// InformerEndpointSliceCache holds the latest state of the
+// EndpointSlices for one or more Services, kept up to date by the
+// EndpointSlices informer of a controller-runtime cache (see
+// NewCache).
+//
+// Unlike fetching the Service's Endpoints on demand, callers can use
+// Updated to find out about new and removed endpoints as soon as
+// they happen
+type InformerEndpointSliceCache struct {
+	cache    crcache.Cache
+	informer crcache.Informer
+	ns       string
+	// updatedMut protects updatedCh, which is closed and
+	// replaced every time the EndpointSlices change
+	updatedMut *sync.RWMutex
+	updatedCh  chan struct{}
+}
+
+// EndpointSliceSelector returns the selector that restricts a cache
+// to the EndpointSlices of the Services svcNames. Pass it to NewCache
+// for caches that only an InformerEndpointSliceCache for svcNames uses
+func EndpointSliceSelector(svcNames ...string) crcache.SelectorsByObject {
+	selector := labels.NewSelector()
+	// the requirement is only invalid for an empty list of
+	// Services, which selects nothing anyway
+	if req, err := labels.NewRequirement(
+		discoveryv1.LabelServiceName,
+		selection.In,
+		svcNames,
+	); err == nil {
+		selector = selector.Add(*req)
+	}
+	return crcache.SelectorsByObject{
+		&discoveryv1.EndpointSlice{}: {Label: selector},
+	}
+}
+
+// NewInformerEndpointSliceCache creates a new InformerEndpointSliceCache
+// for the EndpointSlices of the Services svcNames in namespace ns that
+// c holds. Updated is notified once c has the initial list of them, and
+// every time any of them change
+func NewInformerEndpointSliceCache(
+	ctx context.Context,
+	c crcache.Cache,
+	ns string,
+	svcNames ...string,
+) (*InformerEndpointSliceCache, error) {
+	informer, err := c.GetInformer(ctx, &discoveryv1.EndpointSlice{})
+	if err != nil {
+		return nil, errors.Wrap(err, "getting the endpoint slices informer")
+	}
+	ret := &InformerEndpointSliceCache{
+		cache:      c,
+		informer:   informer,
+		ns:         ns,
+		updatedMut: new(sync.RWMutex),
+		updatedCh:  make(chan struct{}),
+	}
+	svcs := make(map[string]struct{}, len(svcNames))
+	for _, svcName := range svcNames {
+		svcs[svcName] = struct{}{}
+	}
+	isSvcSlice := func(obj interface{}) bool {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		slice, ok := obj.(*discoveryv1.EndpointSlice)
+		if !ok {
+			return false
+		}
+		_, ok = svcs[slice.Labels[discoveryv1.LabelServiceName]]
+		return ok
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if isSvcSlice(obj) {
+				ret.notify()
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldSlice, oldOK := oldObj.(*discoveryv1.EndpointSlice)
+			newSlice, newOK := newObj.(*discoveryv1.EndpointSlice)
+			// resyncs re-deliver slices that didn't change
+			if oldOK && newOK && oldSlice.ResourceVersion == newSlice.ResourceVersion {
+				return
+			}
+			if isSvcSlice(oldObj) || isSvcSlice(newObj) {
+				ret.notify()
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if isSvcSlice(obj) {
+				ret.notify()
+			}
+		},
+	})
+	go func() {
+		// let anyone who was waiting for the
+		// initial list know that it's there
+		if cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+			ret.notify()
+		}
+	}()
+	return ret, nil
+}
+
+func (i *InformerEndpointSliceCache) notify() {
+	i.updatedMut.Lock()
+	defer i.updatedMut.Unlock()
+	close(i.updatedCh)
+	i.updatedCh = make(chan struct{})
+}
+
+// Updated returns a channel that is closed the next time the
+// EndpointSlices change. Callers that want to be notified of every
+// change should call this again after each notification, and before
+// reading the endpoints, so they don't miss any changes
+func (i *InformerEndpointSliceCache) Updated() <-chan struct{} {
+	i.updatedMut.RLock()
+	defer i.updatedMut.RUnlock()
+	return i.updatedCh
+}
+
+// HasSynced returns true once the informer has its initial
+// list of EndpointSlices
+func (i *InformerEndpointSliceCache) HasSynced() bool {
+	return i.informer.HasSynced()
+}
+
+// GetEndpoints is a GetEndpointsFunc that returns the Endpoints for
+// the Service serviceName, built from the EndpointSlices in the cache.
+// namespace is ignored, since the cache only holds the EndpointSlices
+// of a single namespace
+func (i *InformerEndpointSliceCache) GetEndpoints(
+	ctx context.Context,
+	_,
+	serviceName string,
+) (*v1.Endpoints, error) {
+	var sliceList discoveryv1.EndpointSliceList
+	if err := i.cache.List(
+		ctx,
+		&sliceList,
+		client.InNamespace(i.ns),
+		client.MatchingLabels{discoveryv1.LabelServiceName: serviceName},
+	); err != nil {
+		return nil, errors.Wrap(err, "listing endpoint slices")
+	}
+	slices := make([]*discoveryv1.EndpointSlice, len(sliceList.Items))
+	for idx := range sliceList.Items {
+		slices[idx] = &sliceList.Items[idx]
+	}
+	ret := EndpointsFromSlices(slices)
+	ret.Name = serviceName
+	return ret, nil
+}
+
+// EndpointsFromSlices returns an Endpoints with all the addresses in
+// slices, in a single subset. Endpoints that aren't ready go in the
+// subset's NotReadyAddresses. An address that's in more than one
+// slice, which can happen briefly while endpoints move between
+// slices, is only included once
+func EndpointsFromSlices(slices []*discoveryv1.EndpointSlice) *v1.Endpoints {
+	subset := v1.EndpointSubset{}
+	seen := map[string]struct{}{}
+	for _, slice := range slices {
+		for _, endpt := range slice.Endpoints {
+			// a nil Ready condition means the
+			// readiness is unknown, which is
+			// interpreted as ready
+			ready := endpt.Conditions.Ready == nil || *endpt.Conditions.Ready
+			for _, addr := range endpt.Addresses {
+				if _, ok := seen[addr]; ok {
+					continue
+				}
+				seen[addr] = struct{}{}
+				epAddr := v1.EndpointAddress{
+					IP:        addr,
+					TargetRef: endpt.TargetRef,
+				}
+				if endpt.Hostname != nil {
+					epAddr.Hostname = *endpt.Hostname
+				}
+				if ready {
+					subset.Addresses = append(subset.Addresses, epAddr)
+				} else {
+					subset.NotReadyAddresses = append(subset.NotReadyAddresses, epAddr)
+				}
+			}
+		}
+	}
+	ret := &v1.Endpoints{}
+	if len(subset.Addresses) > 0 || len(subset.NotReadyAddresses) > 0 {
+		ret.Subsets = []v1.EndpointSubset{subset}
+	}
+	return ret
+}
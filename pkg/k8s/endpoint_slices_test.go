@@ -0,0 +1,161 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/require"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func newEndpointSlice(ns, name, svcName string, endpts ...discoveryv1.Endpoint) *discoveryv1.EndpointSlice {
+	return &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: ns,
+			Name:      name,
+			Labels:    map[string]string{discoveryv1.LabelServiceName: svcName},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints:   endpts,
+	}
+}
+
+func TestEndpointsFromSlices(t *testing.T) {
+	r := require.New(t)
+	notReady := false
+	endpts := EndpointsFromSlices([]*discoveryv1.EndpointSlice{
+		newEndpointSlice(
+			"testns",
+			"slice1",
+			"testsvc",
+			discoveryv1.Endpoint{Addresses: []string{"1.2.3.4"}},
+			discoveryv1.Endpoint{
+				Addresses:  []string{"1.2.3.5"},
+				Conditions: discoveryv1.EndpointConditions{Ready: &notReady},
+			},
+		),
+		// the same address in another slice only counts once
+		newEndpointSlice(
+			"testns",
+			"slice2",
+			"testsvc",
+			discoveryv1.Endpoint{Addresses: []string{"1.2.3.4", "2.3.4.5"}},
+		),
+	})
+	r.Equal(2, ReadyAddresses(endpts))
+	r.Equal("1.2.3.4", endpts.Subsets[0].Addresses[0].IP)
+	r.Equal("2.3.4.5", endpts.Subsets[0].Addresses[1].IP)
+	r.Len(endpts.Subsets[0].NotReadyAddresses, 1)
+
+	r.Empty(EndpointsFromSlices(nil).Subsets)
+}
+
+func TestInformerEndpointSliceCache(t *testing.T) {
+	const (
+		ns      = "testns"
+		svcName = "testsvc"
+	)
+	r := require.New(t)
+	ctx, done := context.WithCancel(context.Background())
+	defer done()
+	cl := k8sfake.NewSimpleClientset(
+		newEndpointSlice(ns, "slice1", svcName, discoveryv1.Endpoint{
+			Addresses: []string{"1.2.3.4"},
+		}),
+		// another Service's slices are ignored
+		newEndpointSlice(ns, "otherslice", "othersvc", discoveryv1.Endpoint{
+			Addresses: []string{"5.6.7.8"},
+		}),
+	)
+	c := newFakeCache(cl, ns, time.Minute, EndpointSliceSelector(svcName))
+	slices, err := NewInformerEndpointSliceCache(ctx, c, ns, svcName)
+	r.NoError(err)
+	updated := slices.Updated()
+	go StartCache(ctx, logr.Discard(), c)
+	r.Eventually(slices.HasSynced, time.Second, 10*time.Millisecond)
+
+	endpts, err := slices.GetEndpoints(ctx, ns, svcName)
+	r.NoError(err)
+	r.Equal(svcName, endpts.Name)
+	r.Equal(1, ReadyAddresses(endpts))
+
+	// a new slice is noticed right away
+	select {
+	case <-updated:
+	case <-time.After(time.Second):
+		r.Fail("the initial sync wasn't notified")
+	}
+	updated = slices.Updated()
+	_, err = cl.DiscoveryV1().EndpointSlices(ns).Create(
+		ctx,
+		newEndpointSlice(ns, "slice2", svcName, discoveryv1.Endpoint{
+			Addresses: []string{"2.3.4.5"},
+		}),
+		metav1.CreateOptions{},
+	)
+	r.NoError(err)
+	select {
+	case <-updated:
+	case <-time.After(time.Second):
+		r.Fail("the new endpoint slice wasn't notified")
+	}
+	endpts, err = slices.GetEndpoints(ctx, ns, svcName)
+	r.NoError(err)
+	r.Equal(2, ReadyAddresses(endpts))
+}
+
+func TestInformerEndpointSliceCacheServices(t *testing.T) {
+	const ns = "testns"
+	r := require.New(t)
+	ctx, done := context.WithCancel(context.Background())
+	defer done()
+	cl := k8sfake.NewSimpleClientset(
+		newEndpointSlice(ns, "slice-a", "svc-a", discoveryv1.Endpoint{
+			Addresses: []string{"1.2.3.4"},
+		}),
+		newEndpointSlice(ns, "slice-b", "svc-b", discoveryv1.Endpoint{
+			Addresses: []string{"2.3.4.5"},
+		}),
+		newEndpointSlice(ns, "otherslice", "othersvc", discoveryv1.Endpoint{
+			Addresses: []string{"5.6.7.8"},
+		}),
+	)
+	c := newFakeCache(cl, ns, time.Minute, EndpointSliceSelector("svc-a", "svc-b"))
+	slices, err := NewInformerEndpointSliceCache(ctx, c, ns, "svc-a", "svc-b")
+	r.NoError(err)
+	go StartCache(ctx, logr.Discard(), c)
+	r.Eventually(slices.HasSynced, time.Second, 10*time.Millisecond)
+
+	// each Service's endpoints are apart
+	endpts, err := slices.GetEndpoints(ctx, ns, "svc-a")
+	r.NoError(err)
+	r.Equal(1, ReadyAddresses(endpts))
+	r.Equal("1.2.3.4", endpts.Subsets[0].Addresses[0].IP)
+	endpts, err = slices.GetEndpoints(ctx, ns, "svc-b")
+	r.NoError(err)
+	r.Equal("2.3.4.5", endpts.Subsets[0].Addresses[0].IP)
+	// and the cache doesn't hold the other Services'
+	endpts, err = slices.GetEndpoints(ctx, ns, "othersvc")
+	r.NoError(err)
+	r.Equal(0, ReadyAddresses(endpts))
+
+	// a change to any of the Services' slices is an update
+	updated := slices.Updated()
+	_, err = cl.DiscoveryV1().EndpointSlices(ns).Create(
+		ctx,
+		newEndpointSlice(ns, "slice-b2", "svc-b", discoveryv1.Endpoint{
+			Addresses: []string{"3.4.5.6"},
+		}),
+		metav1.CreateOptions{},
+	)
+	r.NoError(err)
+	select {
+	case <-updated:
+	case <-time.After(time.Second):
+		r.FailNow("the cache wasn't updated")
+	}
+}
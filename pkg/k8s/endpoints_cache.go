@@ -0,0 +1,143 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	crcache "sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// EndpointsCache holds the latest state of the Endpoints for the
+// Services in a namespace
+type EndpointsCache interface {
+	Get(name string) (v1.Endpoints, error)
+	Watch(name string) watch.Interface
+}
+
+// ReadyAddresses returns the number of addresses in endpts that are
+// ready to receive traffic. Addresses in NotReadyAddresses don't count
+func ReadyAddresses(endpts *v1.Endpoints) int {
+	ret := 0
+	for _, subset := range endpts.Subsets {
+		ret += len(subset.Addresses)
+	}
+	return ret
+}
+
+// InformerEndpointsCache is an EndpointsCache that's kept up to date
+// by the Endpoints informer of a controller-runtime cache (see
+// NewCache)
+type InformerEndpointsCache struct {
+	cache       crcache.Cache
+	informer    crcache.Informer
+	ns          string
+	broadcaster *watch.Broadcaster
+}
+
+// NewInformerEndpointsCache creates a new InformerEndpointsCache for
+// the Endpoints in namespace ns that c holds. Watchers only get events
+// for Endpoints that changed, not for the ones that c's informer
+// re-delivers when it resyncs. The cache stops delivering events to
+// watchers when ctx is done
+func NewInformerEndpointsCache(
+	ctx context.Context,
+	c crcache.Cache,
+	ns string,
+) (*InformerEndpointsCache, error) {
+	informer, err := c.GetInformer(ctx, &v1.Endpoints{})
+	if err != nil {
+		return nil, errors.Wrap(err, "getting the endpoints informer")
+	}
+	ret := &InformerEndpointsCache{
+		cache:       c,
+		informer:    informer,
+		ns:          ns,
+		broadcaster: watch.NewBroadcaster(5, watch.DropIfChannelFull),
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			ret.broadcast(watch.Added, obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldEndpts, oldOK := oldObj.(*v1.Endpoints)
+			newEndpts, newOK := newObj.(*v1.Endpoints)
+			// waiters only care about changes
+			if oldOK && newOK && oldEndpts.ResourceVersion == newEndpts.ResourceVersion {
+				return
+			}
+			ret.broadcast(watch.Modified, newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			ret.broadcast(watch.Deleted, obj)
+		},
+	})
+	go func() {
+		<-ctx.Done()
+		ret.broadcaster.Shutdown()
+	}()
+	return ret, nil
+}
+
+func (i *InformerEndpointsCache) broadcast(evtType watch.EventType, obj interface{}) {
+	// deletes may come through as tombstones, which
+	// don't have a usable Endpoints in them
+	endpts, ok := obj.(*v1.Endpoints)
+	if !ok {
+		return
+	}
+	i.broadcaster.Action(evtType, endpts)
+}
+
+// HasSynced returns true once the informer has its initial
+// list of Endpoints
+func (i *InformerEndpointsCache) HasSynced() bool {
+	return i.informer.HasSynced()
+}
+
+func (i *InformerEndpointsCache) Get(name string) (v1.Endpoints, error) {
+	var endpts v1.Endpoints
+	if err := i.cache.Get(context.Background(), ObjKey(i.ns, name), &endpts); err != nil {
+		return v1.Endpoints{}, err
+	}
+	return endpts, nil
+}
+
+// Watch returns a watch.Interface that gets an event every time the
+// Endpoints called name, in the cache's namespace, change. Events are
+// dropped for callers that fall behind, rather than hold up the
+// informer, so callers that wait for a change must also re-Get the
+// Endpoints now and then
+func (i *InformerEndpointsCache) Watch(name string) watch.Interface {
+	watcher := i.broadcaster.Watch()
+	return watch.Filter(watcher, func(evt watch.Event) (watch.Event, bool) {
+		endpts, ok := evt.Object.(*v1.Endpoints)
+		if !ok {
+			return evt, false
+		}
+		return evt, endpts.ObjectMeta.Name == name
+	})
+}
+
+// MarshalJSON returns the number of ready addresses for each of the
+// Endpoints in the cache
+func (i *InformerEndpointsCache) MarshalJSON() ([]byte, error) {
+	var endptsList v1.EndpointsList
+	if err := i.cache.List(
+		context.Background(),
+		&endptsList,
+		client.InNamespace(i.ns),
+	); err != nil {
+		return nil, err
+	}
+	ret := map[string]int{}
+	for idx := range endptsList.Items {
+		endpts := &endptsList.Items[idx]
+		ret[endpts.Name] = ReadyAddresses(endpts)
+	}
+	return json.Marshal(ret)
+}
@@ -0,0 +1,93 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReadyAddresses(t *testing.T) {
+	r := require.New(t)
+	endpts := &v1.Endpoints{
+		Subsets: []v1.EndpointSubset{
+			{
+				Addresses:         []v1.EndpointAddress{{IP: "1.2.3.4"}},
+				NotReadyAddresses: []v1.EndpointAddress{{IP: "1.2.3.5"}},
+			},
+			{
+				Addresses: []v1.EndpointAddress{{IP: "2.3.4.5"}},
+			},
+		},
+	}
+	r.Equal(2, ReadyAddresses(endpts))
+	r.Equal(0, ReadyAddresses(&v1.Endpoints{}))
+}
+
+func TestInformerEndpointsCache(t *testing.T) {
+	r := require.New(t)
+	ctx, done := context.WithCancel(context.Background())
+	defer done()
+	const ns = "testns"
+	const name = "testsvc"
+	endpts := &v1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       ns,
+			ResourceVersion: "1",
+		},
+	}
+	cl := k8sfake.NewSimpleClientset(endpts)
+	c := newFakeCache(cl, ns, time.Minute, nil)
+	endptsCache, err := NewInformerEndpointsCache(ctx, c, ns)
+	r.NoError(err)
+	go StartCache(ctx, logr.Discard(), c)
+
+	r.Eventually(endptsCache.HasSynced, time.Second, 10*time.Millisecond)
+	_, err = endptsCache.Get(name)
+	r.NoError(err)
+	_, err = endptsCache.Get("nosuchsvc")
+	r.Error(err)
+
+	watcher := endptsCache.Watch(name)
+	defer watcher.Stop()
+	// the fake clientset doesn't bump resource versions like
+	// the API server does, and the cache ignores updates that
+	// don't, since they're resyncs
+	endpts = endpts.DeepCopy()
+	endpts.ResourceVersion = "2"
+	endpts.Subsets = []v1.EndpointSubset{
+		{Addresses: []v1.EndpointAddress{{IP: "1.2.3.4"}}},
+	}
+	_, err = cl.CoreV1().Endpoints(ns).Update(ctx, endpts, metav1.UpdateOptions{})
+	r.NoError(err)
+
+	// the informer may still be delivering the initial add
+	// event, so skip anything before the modification
+	timeout := time.After(time.Second)
+	for gotModified := false; !gotModified; {
+		select {
+		case evt := <-watcher.ResultChan():
+			if evt.Type == watch.Modified {
+				r.Equal(1, ReadyAddresses(evt.Object.(*v1.Endpoints)))
+				gotModified = true
+			}
+		case <-timeout:
+			r.FailNow("didn't get an event for the updated endpoints")
+		}
+	}
+	got, err := endptsCache.Get(name)
+	r.NoError(err)
+	r.Equal(1, ReadyAddresses(&got))
+
+	b, err := json.Marshal(endptsCache)
+	r.NoError(err)
+	r.JSONEq(`{"testsvc": 1}`, string(b))
+}
@@ -1,10 +1,13 @@
 package k8s
 
 import (
+	"fmt"
 	"net/url"
+	"sync"
 
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
 )
 
 // FakeEndpointsForURL creates and returns a new *v1.Endpoints with a
@@ -36,3 +39,54 @@ func FakeEndpointsForURL(
 		},
 	}
 }
+
+// FakeEndpointsCache is an in-memory EndpointsCache for tests. Use
+// Set to change the Endpoints it returns and SetWatcher to control
+// the events that Watch returns
+type FakeEndpointsCache struct {
+	Mut      *sync.RWMutex
+	Current  map[string]v1.Endpoints
+	Watchers map[string]*watch.RaceFreeFakeWatcher
+}
+
+func NewFakeEndpointsCache() *FakeEndpointsCache {
+	return &FakeEndpointsCache{
+		Mut:      &sync.RWMutex{},
+		Current:  make(map[string]v1.Endpoints),
+		Watchers: make(map[string]*watch.RaceFreeFakeWatcher),
+	}
+}
+
+func (f *FakeEndpointsCache) Get(name string) (v1.Endpoints, error) {
+	f.Mut.RLock()
+	defer f.Mut.RUnlock()
+	ret, ok := f.Current[name]
+	if ok {
+		return ret, nil
+	}
+	return v1.Endpoints{}, fmt.Errorf("no endpoints %s found", name)
+}
+
+func (f *FakeEndpointsCache) Watch(name string) watch.Interface {
+	f.Mut.RLock()
+	defer f.Mut.RUnlock()
+	watcher, ok := f.Watchers[name]
+	if !ok {
+		return watch.NewRaceFreeFake()
+	}
+	return watcher
+}
+
+func (f *FakeEndpointsCache) Set(name string, endpts v1.Endpoints) {
+	f.Mut.Lock()
+	defer f.Mut.Unlock()
+	f.Current[name] = endpts
+}
+
+func (f *FakeEndpointsCache) SetWatcher(name string) *watch.RaceFreeFakeWatcher {
+	f.Mut.Lock()
+	defer f.Mut.Unlock()
+	watcher := watch.NewRaceFreeFake()
+	f.Watchers[name] = watcher
+	return watcher
+}
@@ -0,0 +1,107 @@
+package k8s
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// LeaderElectionConfig holds the parameters used to acquire
+// and hold a Lease-based leader election lock
+type LeaderElectionConfig struct {
+	// Namespace is the namespace in which the Lease lives
+	Namespace string
+	// LeaseName is the name of the Lease object that the
+	// candidates compete for
+	LeaseName string
+	// Identity uniquely identifies this candidate. This is
+	// usually the pod name
+	Identity string
+	// LeaseDuration is how long non-leaders wait before
+	// trying to take over an un-renewed lease
+	LeaseDuration time.Duration
+	// RenewDeadline is how long the leader keeps retrying
+	// to refresh the lease before giving it up
+	RenewDeadline time.Duration
+	// RetryPeriod is how long candidates wait between
+	// attempts to acquire or renew the lease
+	RetryPeriod time.Duration
+}
+
+// RunWithLeaderElection blocks until this process becomes the leader
+// for the Lease described in cfg, then calls fn with a context that is
+// cancelled as soon as leadership is lost.
+//
+// Returns the error that fn returned, or a non-nil error if leadership
+// was lost before fn returned. Returns ctx.Err() if ctx is done before
+// leadership is ever acquired.
+func RunWithLeaderElection(
+	ctx context.Context,
+	lggr logr.Logger,
+	cl kubernetes.Interface,
+	cfg LeaderElectionConfig,
+	fn func(context.Context) error,
+) error {
+	lggr = lggr.WithName("pkg.k8s.RunWithLeaderElection").WithValues(
+		"lease",
+		cfg.LeaseName,
+		"identity",
+		cfg.Identity,
+	)
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      cfg.LeaseName,
+			Namespace: cfg.Namespace,
+		},
+		Client: cl.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: cfg.Identity,
+		},
+	}
+
+	ctx, done := context.WithCancel(ctx)
+	defer done()
+	errCh := make(chan error, 1)
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   cfg.LeaseDuration,
+		RenewDeadline:   cfg.RenewDeadline,
+		RetryPeriod:     cfg.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            cfg.LeaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaderCtx context.Context) {
+				lggr.Info("acquired leadership")
+				errCh <- fn(leaderCtx)
+				// fn is done, so give up the lease so that
+				// another candidate can take over
+				done()
+			},
+			OnStoppedLeading: func() {
+				lggr.Info("lost leadership")
+			},
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "creating leader elector")
+	}
+
+	lggr.Info("waiting to acquire leadership")
+	elector.Run(ctx)
+
+	select {
+	case err := <-errCh:
+		return err
+	default:
+	}
+	if ctx.Err() != nil {
+		return errors.Wrap(ctx.Err(), "context is done")
+	}
+	return errors.New("lost leadership")
+}
@@ -0,0 +1,95 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testLeaderElectionConfig(identity string) LeaderElectionConfig {
+	return LeaderElectionConfig{
+		Namespace:     "testns",
+		LeaseName:     "testlease",
+		Identity:      identity,
+		LeaseDuration: 2 * time.Second,
+		RenewDeadline: 1 * time.Second,
+		RetryPeriod:   100 * time.Millisecond,
+	}
+}
+
+func TestRunWithLeaderElection(t *testing.T) {
+	r := require.New(t)
+	ctx, done := context.WithTimeout(context.Background(), 5*time.Second)
+	defer done()
+	cl := fake.NewSimpleClientset()
+	cfg := testLeaderElectionConfig("candidate1")
+
+	expectedErr := errors.New("test error")
+	err := RunWithLeaderElection(
+		ctx,
+		logr.Discard(),
+		cl,
+		cfg,
+		func(context.Context) error {
+			return expectedErr
+		},
+	)
+	r.Equal(expectedErr, err)
+
+	lease, err := cl.CoordinationV1().Leases(cfg.Namespace).Get(
+		ctx,
+		cfg.LeaseName,
+		metav1.GetOptions{},
+	)
+	r.NoError(err)
+	r.NotNil(lease.Spec.HolderIdentity)
+}
+
+func TestRunWithLeaderElectionNotLeader(t *testing.T) {
+	r := require.New(t)
+	ctx, done := context.WithCancel(context.Background())
+	defer done()
+	cl := fake.NewSimpleClientset()
+
+	// the first candidate holds the lease until the end of the test
+	leaderRunning := make(chan struct{})
+	go RunWithLeaderElection(
+		ctx,
+		logr.Discard(),
+		cl,
+		testLeaderElectionConfig("candidate1"),
+		func(ctx context.Context) error {
+			close(leaderRunning)
+			<-ctx.Done()
+			return nil
+		},
+	)
+	select {
+	case <-leaderRunning:
+	case <-time.After(5 * time.Second):
+		r.FailNow("first candidate never became the leader")
+	}
+
+	// the second candidate should never run its function
+	followerCtx, followerDone := context.WithTimeout(ctx, 500*time.Millisecond)
+	defer followerDone()
+	ran := false
+	err := RunWithLeaderElection(
+		followerCtx,
+		logr.Discard(),
+		cl,
+		testLeaderElectionConfig("candidate2"),
+		func(context.Context) error {
+			ran = true
+			return nil
+		},
+	)
+	r.Error(err)
+	r.False(ran)
+}
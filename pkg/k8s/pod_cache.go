@@ -0,0 +1,56 @@
+package k8s
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	crcache "sigs.k8s.io/controller-runtime/pkg/cache"
+)
+
+// PodCache holds the labels of the Pods in a namespace
+type PodCache interface {
+	// Labels returns the labels of the Pod called name, and false if
+	// there's no such Pod
+	Labels(name string) (map[string]string, bool)
+}
+
+// InformerPodCache is a PodCache that's kept up to date by the Pod
+// informer of a controller-runtime cache (see NewCache)
+type InformerPodCache struct {
+	cache    crcache.Cache
+	informer crcache.Informer
+	ns       string
+}
+
+// NewInformerPodCache creates a new InformerPodCache for the Pods in
+// namespace ns that c holds
+func NewInformerPodCache(
+	ctx context.Context,
+	c crcache.Cache,
+	ns string,
+) (*InformerPodCache, error) {
+	informer, err := c.GetInformer(ctx, &v1.Pod{})
+	if err != nil {
+		return nil, errors.Wrap(err, "getting the pod informer")
+	}
+	return &InformerPodCache{
+		cache:    c,
+		informer: informer,
+		ns:       ns,
+	}, nil
+}
+
+// HasSynced returns true once the informer has its initial
+// list of Pods
+func (i *InformerPodCache) HasSynced() bool {
+	return i.informer.HasSynced()
+}
+
+func (i *InformerPodCache) Labels(name string) (map[string]string, bool) {
+	var pod v1.Pod
+	if err := i.cache.Get(context.Background(), ObjKey(i.ns, name), &pod); err != nil {
+		return nil, false
+	}
+	return pod.Labels, true
+}
@@ -0,0 +1,31 @@
+package k8s
+
+import "sync"
+
+// FakePodCache is an in-memory PodCache for tests. Use Set to change
+// the labels that it returns
+type FakePodCache struct {
+	mut    *sync.RWMutex
+	labels map[string]map[string]string
+}
+
+func NewFakePodCache() *FakePodCache {
+	return &FakePodCache{
+		mut:    new(sync.RWMutex),
+		labels: map[string]map[string]string{},
+	}
+}
+
+// Set makes f return podLabels for the Pod called name
+func (f *FakePodCache) Set(name string, podLabels map[string]string) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	f.labels[name] = podLabels
+}
+
+func (f *FakePodCache) Labels(name string) (map[string]string, bool) {
+	f.mut.RLock()
+	defer f.mut.RUnlock()
+	ret, ok := f.labels[name]
+	return ret, ok
+}
@@ -0,0 +1,38 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestInformerPodCache(t *testing.T) {
+	r := require.New(t)
+	ctx, done := context.WithCancel(context.Background())
+	defer done()
+	const ns = "testns"
+	cl := k8sfake.NewSimpleClientset(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "testpod",
+			Namespace: ns,
+			Labels:    map[string]string{"app.kubernetes.io/version": "blue"},
+		},
+	})
+	c := newFakeCache(cl, ns, time.Minute, nil)
+	pods, err := NewInformerPodCache(ctx, c, ns)
+	r.NoError(err)
+	go StartCache(ctx, logr.Discard(), c)
+
+	r.Eventually(pods.HasSynced, time.Second, 10*time.Millisecond)
+	podLabels, ok := pods.Labels("testpod")
+	r.True(ok)
+	r.Equal(map[string]string{"app.kubernetes.io/version": "blue"}, podLabels)
+	_, ok = pods.Labels("nosuchpod")
+	r.False(ok)
+}
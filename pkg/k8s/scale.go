@@ -0,0 +1,165 @@
+package k8s
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/scale"
+)
+
+// ScaleReader reads the replica state of any workload that implements
+// the scale subresource, like Deployments, StatefulSets, Argo Rollouts
+// or custom resources
+type ScaleReader interface {
+	// Replicas returns the current number of replicas of the workload
+	// with the given API version, kind and name
+	Replicas(ctx context.Context, ns, apiVersion, kind, name string) (int32, error)
+}
+
+type scaleClientReader struct {
+	scales scale.ScalesGetter
+	mapper meta.RESTMapper
+}
+
+// NewScaleReader creates a ScaleReader that reads the scale subresource
+// with scales, and uses mapper to find the resource for a given kind
+func NewScaleReader(scales scale.ScalesGetter, mapper meta.RESTMapper) ScaleReader {
+	return &scaleClientReader{scales: scales, mapper: mapper}
+}
+
+// NewScaleReaderForConfig creates a ScaleReader for the cluster in cfg.
+// It discovers the resources that the cluster serves lazily, and
+// rediscovers them when it sees a kind it doesn't know about
+func NewScaleReaderForConfig(cfg *rest.Config) (ScaleReader, error) {
+	discoveryCl, err := discovery.NewDiscoveryClientForConfig(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating discovery client")
+	}
+	cachedDiscovery := memory.NewMemCacheClient(discoveryCl)
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(cachedDiscovery)
+	scales, err := scale.NewForConfig(
+		cfg,
+		mapper,
+		dynamic.LegacyAPIPathResolverFunc,
+		scale.NewDiscoveryScaleKindResolver(cachedDiscovery),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating scale client")
+	}
+	return &scaleClientReader{
+		scales: scales,
+		mapper: &resettingRESTMapper{
+			RESTMapper: mapper,
+			reset:      mapper.Reset,
+		},
+	}, nil
+}
+
+func (s *scaleClientReader) Replicas(
+	ctx context.Context,
+	ns,
+	apiVersion,
+	kind,
+	name string,
+) (int32, error) {
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return 0, errors.Wrapf(err, "parsing API version %q", apiVersion)
+	}
+	mapping, err := s.mapper.RESTMapping(gv.WithKind(kind).GroupKind(), gv.Version)
+	if err != nil {
+		return 0, errors.Wrapf(err, "finding the resource for %s %s", apiVersion, kind)
+	}
+	sc, err := s.scales.Scales(ns).Get(
+		ctx,
+		mapping.Resource.GroupResource(),
+		name,
+		metav1.GetOptions{},
+	)
+	if err != nil {
+		return 0, errors.Wrapf(err, "getting the scale of %s %s/%s", kind, ns, name)
+	}
+	return sc.Status.Replicas, nil
+}
+
+// resettingRESTMapper resets the discovery cache behind its RESTMapper
+// when it doesn't know about a kind, so that CRDs installed after the
+// interceptor started are found
+type resettingRESTMapper struct {
+	meta.RESTMapper
+	reset func()
+}
+
+func (r *resettingRESTMapper) RESTMapping(
+	gk schema.GroupKind,
+	versions ...string,
+) (*meta.RESTMapping, error) {
+	mapping, err := r.RESTMapper.RESTMapping(gk, versions...)
+	if meta.IsNoMatchError(err) {
+		r.reset()
+		return r.RESTMapper.RESTMapping(gk, versions...)
+	}
+	return mapping, err
+}
+
+type scaleCacheKey struct {
+	ns, apiVersion, kind, name string
+}
+
+type scaleCacheEntry struct {
+	replicas int32
+	fetched  time.Time
+}
+
+type cachedScaleReader struct {
+	inner ScaleReader
+	ttl   time.Duration
+	mut   *sync.Mutex
+	cache map[scaleCacheKey]scaleCacheEntry
+}
+
+// NewCachedScaleReader creates a ScaleReader that remembers the
+// replicas that inner returns for ttl, so that callers on hot paths
+// don't make an API call every time. Errors are never cached
+func NewCachedScaleReader(inner ScaleReader, ttl time.Duration) ScaleReader {
+	return &cachedScaleReader{
+		inner: inner,
+		ttl:   ttl,
+		mut:   new(sync.Mutex),
+		cache: map[scaleCacheKey]scaleCacheEntry{},
+	}
+}
+
+func (c *cachedScaleReader) Replicas(
+	ctx context.Context,
+	ns,
+	apiVersion,
+	kind,
+	name string,
+) (int32, error) {
+	key := scaleCacheKey{ns: ns, apiVersion: apiVersion, kind: kind, name: name}
+	c.mut.Lock()
+	entry, ok := c.cache[key]
+	c.mut.Unlock()
+	if ok && time.Since(entry.fetched) < c.ttl {
+		return entry.replicas, nil
+	}
+	replicas, err := c.inner.Replicas(ctx, ns, apiVersion, kind, name)
+	if err != nil {
+		return 0, err
+	}
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.cache[key] = scaleCacheEntry{replicas: replicas, fetched: time.Now()}
+	return replicas, nil
+}
@@ -0,0 +1,49 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// FakeScaleReader is a ScaleReader that returns replica counts
+// from memory. Use Set to change them
+type FakeScaleReader struct {
+	Mut      *sync.RWMutex
+	Current  map[string]int32
+	NumCalls int
+}
+
+func NewFakeScaleReader() *FakeScaleReader {
+	return &FakeScaleReader{
+		Mut:     &sync.RWMutex{},
+		Current: make(map[string]int32),
+	}
+}
+
+func fakeScaleKey(ns, apiVersion, kind, name string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", ns, apiVersion, kind, name)
+}
+
+func (f *FakeScaleReader) Replicas(
+	_ context.Context,
+	ns,
+	apiVersion,
+	kind,
+	name string,
+) (int32, error) {
+	f.Mut.Lock()
+	defer f.Mut.Unlock()
+	f.NumCalls++
+	ret, ok := f.Current[fakeScaleKey(ns, apiVersion, kind, name)]
+	if !ok {
+		return 0, fmt.Errorf("no %s %s/%s found", kind, ns, name)
+	}
+	return ret, nil
+}
+
+func (f *FakeScaleReader) Set(ns, apiVersion, kind, name string, replicas int32) {
+	f.Mut.Lock()
+	defer f.Mut.Unlock()
+	f.Current[fakeScaleKey(ns, apiVersion, kind, name)] = replicas
+}
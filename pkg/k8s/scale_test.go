@@ -0,0 +1,93 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	scalefake "k8s.io/client-go/scale/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestScaleReaderReplicas(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	const ns = "testns"
+	const name = "testrollout"
+	rolloutGV := schema.GroupVersion{Group: "argoproj.io", Version: "v1alpha1"}
+
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{rolloutGV})
+	mapper.Add(rolloutGV.WithKind("Rollout"), meta.RESTScopeNamespace)
+
+	scales := &scalefake.FakeScaleClient{}
+	var gotResource schema.GroupVersionResource
+	scales.AddReactor(
+		"get",
+		"rollouts",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			getAction := action.(k8stesting.GetAction)
+			gotResource = getAction.GetResource()
+			r.Equal(ns, getAction.GetNamespace())
+			r.Equal(name, getAction.GetName())
+			r.Equal("scale", getAction.GetSubresource())
+			return true, &autoscalingv1.Scale{
+				Status: autoscalingv1.ScaleStatus{Replicas: 3},
+			}, nil
+		},
+	)
+
+	reader := NewScaleReader(scales, mapper)
+	replicas, err := reader.Replicas(ctx, ns, rolloutGV.String(), "Rollout", name)
+	r.NoError(err)
+	r.Equal(int32(3), replicas)
+	r.Equal("argoproj.io", gotResource.Group)
+	r.Equal("rollouts", gotResource.Resource)
+
+	// kinds that the mapper doesn't know about should fail
+	_, err = reader.Replicas(ctx, ns, rolloutGV.String(), "Unknown", name)
+	r.Error(err)
+	// and so should invalid API versions
+	_, err = reader.Replicas(ctx, ns, "a/b/c", "Rollout", name)
+	r.Error(err)
+}
+
+func TestCachedScaleReader(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	const ns = "testns"
+	const apiVersion = "argoproj.io/v1alpha1"
+	const kind = "Rollout"
+	const name = "testrollout"
+
+	inner := NewFakeScaleReader()
+	cached := NewCachedScaleReader(inner, time.Hour)
+
+	// errors shouldn't be cached
+	_, err := cached.Replicas(ctx, ns, apiVersion, kind, name)
+	r.Error(err)
+	inner.Set(ns, apiVersion, kind, name, 2)
+	replicas, err := cached.Replicas(ctx, ns, apiVersion, kind, name)
+	r.NoError(err)
+	r.Equal(int32(2), replicas)
+	r.Equal(2, inner.NumCalls)
+
+	// within the TTL, the cached value should come back
+	// without calling the inner reader
+	inner.Set(ns, apiVersion, kind, name, 5)
+	replicas, err = cached.Replicas(ctx, ns, apiVersion, kind, name)
+	r.NoError(err)
+	r.Equal(int32(2), replicas)
+	r.Equal(2, inner.NumCalls)
+
+	// with an expired TTL, the inner reader should be called again
+	expired := NewCachedScaleReader(inner, 0)
+	replicas, err = expired.Replicas(ctx, ns, apiVersion, kind, name)
+	r.NoError(err)
+	r.Equal(int32(5), replicas)
+	r.Equal(3, inner.NumCalls)
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"embed"
+	"strconv"
 	"text/template"
 
 	unstructured "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -15,6 +16,11 @@ import (
 //go:embed templates
 var scaledObjectTemplateFS embed.FS
 
+// PausedReplicasAnnotation is the annotation that makes KEDA stop
+// autoscaling a ScaledObject's workload, and pin it at the number
+// of replicas in the annotation's value
+const PausedReplicasAnnotation = "autoscaling.keda.sh/paused-replicas"
+
 // DeleteScaledObject deletes a scaled object with the given name
 func DeleteScaledObject(ctx context.Context, name string, namespace string, cl client.Client) error {
 	scaledObj := &unstructured.Unstructured{}
@@ -32,15 +38,25 @@ func DeleteScaledObject(ctx context.Context, name string, namespace string, cl c
 	return nil
 }
 
-// NewScaledObject creates a new ScaledObject in memory
+// NewScaledObject creates a new ScaledObject in memory. The
+// ScaledObject scales the workload with the given API version, kind
+// and name, which must implement the scale subresource. scalingMetric
+// is passed on to the external scaler, and may be empty for its default.
+// scalingBehavior is the metric type of the ScaledObject's trigger,
+// either AverageValue or Value, and may be empty for KEDA's default
 func NewScaledObject(
 	namespace,
 	name,
-	deploymentName,
+	scaleTargetAPIVersion,
+	scaleTargetKind,
+	scaleTargetName,
 	scalerAddress,
 	host string,
 	minReplicas,
-	maxReplicas int32,
+	maxReplicas,
+	targetPendingRequests int32,
+	scalingMetric,
+	scalingBehavior string,
 ) (*unstructured.Unstructured, error) {
 	// https://keda.sh/docs/1.5/faq/
 	// https://github.com/kedacore/keda/blob/aa0ea79450a1c7549133aab46f5b916efa2364ab/api/v1alpha1/scaledobject_types.go
@@ -61,14 +77,20 @@ func NewScaledObject(
 
 	var scaledObjectTemplateBuffer bytes.Buffer
 	if tplErr := tpl.Execute(&scaledObjectTemplateBuffer, map[string]interface{}{
-		"Name":           name,
-		"Namespace":      namespace,
-		"Labels":         labels,
-		"MinReplicas":    minReplicas,
-		"MaxReplicas":    maxReplicas,
-		"DeploymentName": deploymentName,
-		"ScalerAddress":  scalerAddress,
-		"Host":           host,
+		"Name":                  name,
+		"Namespace":             namespace,
+		"Labels":                labels,
+		"MinReplicas":           minReplicas,
+		"MaxReplicas":           maxReplicas,
+		"ScaleTargetAPIVersion": scaleTargetAPIVersion,
+		"ScaleTargetKind":       scaleTargetKind,
+		"ScaleTargetName":       scaleTargetName,
+		"ScalerAddress":         scalerAddress,
+		"Host":                  host,
+		// scaler metadata values must be strings
+		"TargetPendingRequests": strconv.Itoa(int(targetPendingRequests)),
+		"ScalingMetric":         scalingMetric,
+		"ScalingBehavior":       scalingBehavior,
 	}); tplErr != nil {
 		return nil, tplErr
 	}
@@ -83,3 +105,52 @@ func NewScaledObject(
 		Object: decodedYaml,
 	}, nil
 }
+
+// NewInterceptorScaledObject creates a new ScaledObject in memory that
+// scales the interceptor's Deployment. It's scaled on the average CPU
+// utilization of its pods if targetCPUUtilization isn't 0, and on the
+// in-flight requests to all hosts, which the external scaler reports
+// for the "interceptor" host, if targetPendingRequests isn't 0
+func NewInterceptorScaledObject(
+	namespace,
+	name,
+	deploymentName,
+	scalerAddress string,
+	minReplicas,
+	maxReplicas,
+	targetCPUUtilization,
+	targetPendingRequests int32,
+) (*unstructured.Unstructured, error) {
+	labels := map[string]interface{}{}
+	for k, v := range Labels(name) {
+		labels[k] = v
+	}
+	tpl, err := template.ParseFS(
+		scaledObjectTemplateFS,
+		"templates/interceptor_scaledobject.yaml",
+	)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, map[string]interface{}{
+		"Name":                 name,
+		"Namespace":            namespace,
+		"Labels":               labels,
+		"MinReplicas":          minReplicas,
+		"MaxReplicas":          maxReplicas,
+		"DeploymentName":       deploymentName,
+		"ScalerAddress":        scalerAddress,
+		"TargetCPUUtilization": targetCPUUtilization,
+		// the template quotes the metadata values, which
+		// must be strings
+		"TargetPendingRequests": targetPendingRequests,
+	}); err != nil {
+		return nil, err
+	}
+	var decoded map[string]interface{}
+	if err := yaml.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		return nil, err
+	}
+	return &unstructured.Unstructured{Object: decoded}, nil
+}
@@ -0,0 +1,229 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/client-go/tools/cache"
+)
+
+// clientGoMaxBackoff is the longest that client-go's reflectors back
+// off by themselves before they retry a failed list or watch
+const clientGoMaxBackoff = 30 * time.Second
+
+// WatchBackoff is how an informer backs off after its list or watch on
+// the API server fails, on top of client-go's own backoff. The zero
+// value adds no backoff and never opens the circuit
+type WatchBackoff struct {
+	// Initial is how long the informer waits after the first failure
+	// in a row. Each failure after that doubles the wait
+	Initial time.Duration
+	// Max caps the wait between retries
+	Max time.Duration
+	// Jitter is the fraction of the wait, between 0 and 1, that is
+	// randomized, so that interceptors that lost their watches at
+	// the same time don't all reconnect at the same time
+	Jitter float64
+	// MaxRetries is the number of failures in a row after which the
+	// circuit opens, and the informer's cache reports itself as
+	// unhealthy until a list or watch succeeds. 0 means it never opens
+	MaxRetries int
+}
+
+// Validate returns an error if b's settings don't make sense
+func (b WatchBackoff) Validate() error {
+	if b.Initial < 0 || b.Max < 0 {
+		return fmt.Errorf("watch backoff durations must not be negative")
+	}
+	if b.Max > 0 && b.Initial > b.Max {
+		return fmt.Errorf(
+			"initial watch backoff %s is longer than the max %s",
+			b.Initial,
+			b.Max,
+		)
+	}
+	if b.Jitter < 0 || b.Jitter > 1 {
+		return fmt.Errorf("watch backoff jitter %v must be between 0 and 1", b.Jitter)
+	}
+	if b.MaxRetries < 0 {
+		return fmt.Errorf("watch max retries must not be negative")
+	}
+	return nil
+}
+
+// delay returns how long to wait after failures failures in a row,
+// before jitter
+func (b WatchBackoff) delay(failures int) time.Duration {
+	if b.Initial <= 0 || failures <= 0 {
+		return 0
+	}
+	ret := b.Initial
+	for i := 1; i < failures; i++ {
+		ret *= 2
+		if b.Max > 0 && ret >= b.Max {
+			return b.Max
+		}
+	}
+	if b.Max > 0 && ret > b.Max {
+		return b.Max
+	}
+	return ret
+}
+
+// jittered returns d with up to b.Jitter of it randomized
+func (b WatchBackoff) jittered(d time.Duration) time.Duration {
+	if b.Jitter <= 0 || d <= 0 {
+		return d
+	}
+	spread := time.Duration(float64(d) * b.Jitter)
+	return d - spread + time.Duration(rand.Int63n(int64(2*spread)+1))
+}
+
+// watchCircuit counts an informer's failed lists and watches in a row,
+// and backs off after each of them according to its WatchBackoff. The
+// count starts over once a list or watch succeeds
+type watchCircuit struct {
+	lggr    logr.Logger
+	ctx     context.Context
+	backoff WatchBackoff
+	now     func() time.Time
+	sleep   func(context.Context, time.Duration)
+	// syncVersion returns the resource version that the informer last
+	// synced to. It only changes when a list or watch succeeds. nil
+	// means successes are only seen through onSuccess
+	syncVersion func() string
+
+	mut       sync.Mutex
+	failures  int
+	lastError time.Time
+	lastErr   error
+	// errVersion is what syncVersion returned at the last failure
+	errVersion string
+}
+
+func newWatchCircuit(
+	ctx context.Context,
+	lggr logr.Logger,
+	backoff WatchBackoff,
+) *watchCircuit {
+	return &watchCircuit{
+		lggr:    lggr,
+		ctx:     ctx,
+		backoff: backoff,
+		now:     time.Now,
+		sleep:   sleepContext,
+	}
+}
+
+// resetAfter is how long after a failure the count of failures in a
+// row starts over, even if no success was seen. Retries happen within
+// the longest backoff plus client-go's, so a quieter stretch than that
+// means one succeeded
+func (w *watchCircuit) resetAfter() time.Duration {
+	return w.backoff.Max + w.backoff.Initial + clientGoMaxBackoff
+}
+
+// onSuccess records that a list or watch succeeded, like when the
+// informer delivered a change from the API server, which closes the
+// circuit
+func (w *watchCircuit) onSuccess() {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	w.reset()
+}
+
+// reset starts the count of failures in a row over. Callers must hold
+// w.mut
+func (w *watchCircuit) reset() {
+	if w.failures == 0 {
+		return
+	}
+	if w.backoff.MaxRetries > 0 && w.failures >= w.backoff.MaxRetries {
+		w.lggr.Info("list or watch succeeded, closing the circuit", "failures", w.failures)
+	}
+	w.failures = 0
+}
+
+// progressed returns true if the informer synced since the last
+// failure. Callers must hold w.mut
+func (w *watchCircuit) progressed() bool {
+	return w.syncVersion != nil && w.failures > 0 && w.syncVersion() != w.errVersion
+}
+
+// onError is the informer's cache.WatchErrorHandler. client-go calls
+// it from the reflector's retry loop, so the retry waits until it
+// returns
+func (w *watchCircuit) onError(_ *cache.Reflector, err error) {
+	w.mut.Lock()
+	now := w.now()
+	if w.progressed() || (!w.lastError.IsZero() && now.Sub(w.lastError) > w.resetAfter()) {
+		w.reset()
+	}
+	w.failures++
+	w.lastError = now
+	w.lastErr = err
+	if w.syncVersion != nil {
+		w.errVersion = w.syncVersion()
+	}
+	failures := w.failures
+	w.mut.Unlock()
+
+	delay := w.backoff.jittered(w.backoff.delay(failures))
+	w.lggr.Error(
+		err,
+		"list or watch failed, backing off",
+		"failures",
+		failures,
+		"delay",
+		delay,
+	)
+	if w.backoff.MaxRetries > 0 && failures == w.backoff.MaxRetries {
+		w.lggr.Info(
+			"too many failures in a row, opening the circuit until a list or watch succeeds",
+			"maxRetries",
+			w.backoff.MaxRetries,
+		)
+	}
+	w.sleep(w.ctx, delay)
+}
+
+// Check returns an error if the circuit is open, that is, if the last
+// MaxRetries lists or watches in a row failed, and none succeeded since
+func (w *watchCircuit) Check() error {
+	if w.backoff.MaxRetries <= 0 {
+		return nil
+	}
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	if w.progressed() || (w.failures > 0 && w.now().Sub(w.lastError) > w.resetAfter()) {
+		w.reset()
+	}
+	if w.failures == 0 {
+		return nil
+	}
+	if w.failures < w.backoff.MaxRetries {
+		return nil
+	}
+	return fmt.Errorf(
+		"%d lists or watches in a row failed, the last with: %v",
+		w.failures,
+		w.lastErr,
+	)
+}
+
+// sleepContext sleeps for d, or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
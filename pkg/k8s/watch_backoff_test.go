@@ -0,0 +1,143 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestWatchBackoffDelay(t *testing.T) {
+	r := require.New(t)
+	b := WatchBackoff{Initial: time.Second, Max: 5 * time.Second}
+	r.NoError(b.Validate())
+	r.Equal(time.Duration(0), b.delay(0))
+	r.Equal(time.Second, b.delay(1))
+	r.Equal(2*time.Second, b.delay(2))
+	r.Equal(4*time.Second, b.delay(3))
+	r.Equal(5*time.Second, b.delay(4))
+	r.Equal(5*time.Second, b.delay(100))
+
+	// the zero value doesn't back off
+	r.Equal(time.Duration(0), WatchBackoff{}.delay(3))
+
+	b.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := b.jittered(4 * time.Second)
+		r.GreaterOrEqual(int64(d), int64(2*time.Second))
+		r.LessOrEqual(int64(d), int64(6*time.Second))
+	}
+
+	r.Error(WatchBackoff{Initial: 2 * time.Second, Max: time.Second}.Validate())
+	r.Error(WatchBackoff{Jitter: 1.5}.Validate())
+	r.Error(WatchBackoff{MaxRetries: -1}.Validate())
+}
+
+func TestWatchCircuit(t *testing.T) {
+	r := require.New(t)
+	now := time.Now()
+	var slept []time.Duration
+	w := newWatchCircuit(
+		context.Background(),
+		logr.Discard(),
+		WatchBackoff{Initial: time.Second, Max: 4 * time.Second, MaxRetries: 3},
+	)
+	w.now = func() time.Time { return now }
+	w.sleep = func(_ context.Context, d time.Duration) {
+		slept = append(slept, d)
+	}
+
+	watchErr := errors.New("connection refused")
+	for i := 0; i < 2; i++ {
+		w.onError(nil, watchErr)
+		now = now.Add(time.Second)
+	}
+	r.NoError(w.Check())
+	w.onError(nil, watchErr)
+	r.Error(w.Check())
+	w.onError(nil, watchErr)
+	r.Equal(
+		[]time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second},
+		slept,
+	)
+
+	// after a quiet stretch, a retry must have succeeded
+	now = now.Add(w.resetAfter() + time.Second)
+	r.NoError(w.Check())
+	slept = nil
+	w.onError(nil, watchErr)
+	r.Equal([]time.Duration{time.Second}, slept)
+	r.NoError(w.Check())
+}
+
+func TestWatchCircuitClosesOnSuccess(t *testing.T) {
+	r := require.New(t)
+	now := time.Now()
+	version := "1"
+	w := newWatchCircuit(
+		context.Background(),
+		logr.Discard(),
+		WatchBackoff{Initial: time.Second, Max: 4 * time.Second, MaxRetries: 2},
+	)
+	w.now = func() time.Time { return now }
+	w.sleep = func(context.Context, time.Duration) {}
+	w.syncVersion = func() string { return version }
+
+	watchErr := errors.New("connection refused")
+	w.onError(nil, watchErr)
+	w.onError(nil, watchErr)
+	r.Error(w.Check())
+	// the informer synced, so a list or watch succeeded, long
+	// before the quiet stretch is over
+	version = "2"
+	now = now.Add(time.Second)
+	r.NoError(w.Check())
+
+	// the count starts over after a success
+	w.onError(nil, watchErr)
+	r.NoError(w.Check())
+	w.onError(nil, watchErr)
+	r.Error(w.Check())
+	// a change from the API server closes it too
+	w.onSuccess()
+	r.NoError(w.Check())
+}
+
+// the deployment cache's informer should back off through the circuit
+// when it can't list Deployments
+func TestInformerDeploymentCacheBackoff(t *testing.T) {
+	r := require.New(t)
+	ctx, done := context.WithCancel(context.Background())
+	defer done()
+	const ns = "testns"
+	cl := k8sfake.NewSimpleClientset()
+	cl.PrependReactor(
+		"list",
+		"deployments",
+		func(k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, errors.New("the server is unavailable")
+		},
+	)
+	c := newFakeCache(cl, ns, time.Minute, nil)
+	deplCache, err := NewInformerDeploymentCache(
+		ctx,
+		logr.Discard(),
+		c,
+		ns,
+		WatchBackoff{Initial: time.Millisecond, Max: time.Millisecond, MaxRetries: 1},
+	)
+	r.NoError(err)
+	r.NoError(deplCache.Healthy())
+	go StartCache(ctx, logr.Discard(), c)
+
+	r.Eventually(func() bool {
+		return deplCache.Healthy() != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	r.False(deplCache.HasSynced())
+}
@@ -7,7 +7,15 @@ import (
 )
 
 func NewZapr() (logr.Logger, error) {
+	return NewZaprWithLevel(zap.NewAtomicLevelAt(zap.InfoLevel))
+}
+
+// NewZaprWithLevel is like NewZapr, but the logger only logs messages
+// at level or above. Callers can change level while the logger is in
+// use
+func NewZaprWithLevel(level zap.AtomicLevel) (logr.Logger, error) {
 	zapCfg := zap.NewProductionConfig()
+	zapCfg.Level = level
 	zapCfg.Sampling = &zap.SamplingConfig{
 		Initial:    1,
 		Thereafter: 5,
@@ -0,0 +1,405 @@
+package net
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ProxyProtocolV1 is the human-readable version of the PROXY
+	// protocol
+	ProxyProtocolV1 = "v1"
+	// ProxyProtocolV2 is the binary version of the PROXY protocol
+	ProxyProtocolV2 = "v2"
+)
+
+// proxyV2Signature starts every PROXY protocol v2 header
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyV1Prefix starts every PROXY protocol v1 header
+var proxyV1Prefix = []byte("PROXY ")
+
+// proxyV1MaxLen is the longest a v1 header can be, including its CRLF
+const proxyV1MaxLen = 107
+
+// proxyProtocolListener is a net.Listener that reads PROXY protocol
+// headers off of the connections it accepts. See
+// NewProxyProtocolListener
+type proxyProtocolListener struct {
+	net.Listener
+	headerTimeout time.Duration
+	trusted       []*net.IPNet
+	conns         chan net.Conn
+	errs          chan error
+	done          chan struct{}
+	closeOnce     sync.Once
+}
+
+// NewProxyProtocolListener returns a net.Listener that accepts
+// connections from inner, and reads a PROXY protocol v1 or v2 header
+// off of each of them before it returns them from Accept. The returned
+// connections' RemoteAddr and LocalAddr are the client's and the
+// address it connected to, as the header says.
+//
+// Only connections from peers in trusted may send a header, and all of
+// them may if trusted is empty. A connection without a header, like a
+// health check that bypasses the load balancer, is returned as it is.
+// A connection that doesn't send its whole header within headerTimeout,
+// or sends an invalid one, is closed.
+//
+// Headers are read in the background, so a slow client doesn't hold up
+// the connections behind it
+func NewProxyProtocolListener(
+	inner net.Listener,
+	headerTimeout time.Duration,
+	trusted []*net.IPNet,
+) net.Listener {
+	ret := &proxyProtocolListener{
+		Listener:      inner,
+		headerTimeout: headerTimeout,
+		trusted:       trusted,
+		conns:         make(chan net.Conn),
+		errs:          make(chan error),
+		done:          make(chan struct{}),
+	}
+	go ret.acceptLoop()
+	return ret
+}
+
+func (l *proxyProtocolListener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.errs <- err:
+			case <-l.done:
+				return
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Temporary() {
+				continue
+			}
+			return
+		}
+		go l.readHeader(conn)
+	}
+}
+
+func (l *proxyProtocolListener) readHeader(conn net.Conn) {
+	if !l.trusts(conn.RemoteAddr()) {
+		l.deliver(conn)
+		return
+	}
+	if l.headerTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(l.headerTimeout))
+	}
+	reader := bufio.NewReader(conn)
+	src, dst, err := ReadProxyHeader(reader)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	l.deliver(&proxyProtocolConn{
+		Conn:   conn,
+		reader: reader,
+		remote: src,
+		local:  dst,
+	})
+}
+
+func (l *proxyProtocolListener) deliver(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+func (l *proxyProtocolListener) trusts(addr net.Addr) bool {
+	if len(l.trusted) == 0 {
+		return true
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, ipNet := range l.trusted {
+		if ipNet.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *proxyProtocolListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *proxyProtocolListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	return l.Listener.Close()
+}
+
+// proxyProtocolConn is a connection whose PROXY protocol header was
+// read. Reads continue from reader, which may have buffered the start
+// of the connection's data along with the header
+type proxyProtocolConn struct {
+	net.Conn
+	reader *bufio.Reader
+	// remote and local are the addresses from the header,
+	// or nil if it didn't have any
+	remote net.Addr
+	local  net.Addr
+}
+
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyProtocolConn) LocalAddr() net.Addr {
+	if c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
+
+// ReadProxyHeader reads a PROXY protocol v1 or v2 header from r and
+// returns the source and destination addresses in it. If r doesn't
+// start with a header, nothing is read and the addresses are nil. So
+// are they for headers without addresses, like the ones that load
+// balancers send with their health checks
+func ReadProxyHeader(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	start, err := r.Peek(len(proxyV1Prefix))
+	if err != nil {
+		// a connection that closes before it sent enough
+		// bytes to tell has no header, and nothing to read
+		if errors.Is(err, io.EOF) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	if bytes.Equal(start, proxyV1Prefix) {
+		return readProxyV1(r)
+	}
+	if !bytes.Equal(start, proxyV2Signature[:len(start)]) {
+		return nil, nil, nil
+	}
+	start, err = r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(start, proxyV2Signature) {
+		return nil, nil, nil
+	}
+	return readProxyV2(r)
+}
+
+func readProxyV1(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyV1MaxLen {
+			return nil, nil, errors.New("PROXY v1 header is too long")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("invalid PROXY v1 header %q", strings.TrimSpace(string(line)))
+	}
+	src, err := parseProxyV1Addr(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := parseProxyV1Addr(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+func parseProxyV1Addr(ipStr, portStr string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP %q in PROXY v1 header", ipStr)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q in PROXY v1 header", portStr)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func readProxyV2(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	hdr := make([]byte, len(proxyV2Signature)+4)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, nil, err
+	}
+	verCmd, family := hdr[12], hdr[13]
+	length := binary.BigEndian.Uint16(hdr[14:16])
+	if verCmd>>4 != 2 {
+		return nil, nil, fmt.Errorf("invalid PROXY v2 version %d", verCmd>>4)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, nil, err
+	}
+	switch verCmd & 0xF {
+	case 0:
+		// LOCAL, like a health check from the load balancer itself
+		return nil, nil, nil
+	case 1:
+	default:
+		return nil, nil, fmt.Errorf("invalid PROXY v2 command %d", verCmd&0xF)
+	}
+	var ipLen int
+	switch family >> 4 {
+	case 1:
+		ipLen = net.IPv4len
+	case 2:
+		ipLen = net.IPv6len
+	default:
+		// UNSPEC or unix sockets, whose addresses aren't useful
+		return nil, nil, nil
+	}
+	if len(payload) < 2*ipLen+4 {
+		return nil, nil, errors.New("PROXY v2 header is too short for its addresses")
+	}
+	srcIP := net.IP(payload[:ipLen])
+	dstIP := net.IP(payload[ipLen : 2*ipLen])
+	srcPort := binary.BigEndian.Uint16(payload[2*ipLen:])
+	dstPort := binary.BigEndian.Uint16(payload[2*ipLen+2:])
+	// any TLVs after the addresses are ignored
+	return &net.TCPAddr{IP: srcIP, Port: int(srcPort)},
+		&net.TCPAddr{IP: dstIP, Port: int(dstPort)},
+		nil
+}
+
+// ProxyHeader returns the PROXY protocol header of version for a
+// connection from src to dst. If either isn't a TCP address, the header
+// has no addresses
+func ProxyHeader(version string, src, dst net.Addr) ([]byte, error) {
+	srcTCP, srcOK := src.(*net.TCPAddr)
+	dstTCP, dstOK := dst.(*net.TCPAddr)
+	ok := srcOK && dstOK && srcTCP != nil && dstTCP != nil
+	// both addresses must be of the same family
+	if ok && (srcTCP.IP.To4() == nil) != (dstTCP.IP.To4() == nil) {
+		ok = false
+	}
+	switch version {
+	case ProxyProtocolV1:
+		if !ok {
+			return []byte("PROXY UNKNOWN\r\n"), nil
+		}
+		family := "TCP4"
+		srcIP, dstIP := srcTCP.IP.To4(), dstTCP.IP.To4()
+		if srcIP == nil {
+			family = "TCP6"
+			srcIP, dstIP = srcTCP.IP.To16(), dstTCP.IP.To16()
+		}
+		return []byte(fmt.Sprintf(
+			"PROXY %s %s %s %d %d\r\n",
+			family,
+			srcIP,
+			dstIP,
+			srcTCP.Port,
+			dstTCP.Port,
+		)), nil
+	case ProxyProtocolV2:
+		buf := bytes.NewBuffer(nil)
+		buf.Write(proxyV2Signature)
+		if !ok {
+			// LOCAL, with no addresses
+			buf.Write([]byte{0x20, 0x00, 0x00, 0x00})
+			return buf.Bytes(), nil
+		}
+		family := byte(0x11)
+		srcIP, dstIP := srcTCP.IP.To4(), dstTCP.IP.To4()
+		if srcIP == nil {
+			family = 0x21
+			srcIP, dstIP = srcTCP.IP.To16(), dstTCP.IP.To16()
+		}
+		buf.Write([]byte{0x21, family})
+		binary.Write(buf, binary.BigEndian, uint16(2*len(srcIP)+4))
+		buf.Write(srcIP)
+		buf.Write(dstIP)
+		binary.Write(buf, binary.BigEndian, uint16(srcTCP.Port))
+		binary.Write(buf, binary.BigEndian, uint16(dstTCP.Port))
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unknown PROXY protocol version %q", version)
+	}
+}
+
+type proxyAddrsKey struct{}
+
+type proxyAddrs struct {
+	src net.Addr
+	dst net.Addr
+}
+
+// WithProxyAddrs returns a copy of ctx that holds the source and
+// destination addresses that DialContextWithProxyHeader sends
+func WithProxyAddrs(ctx context.Context, src, dst net.Addr) context.Context {
+	return context.WithValue(ctx, proxyAddrsKey{}, proxyAddrs{src: src, dst: dst})
+}
+
+// DialContextWithProxyHeader returns a DialContextFunc that dials with
+// dial, and then sends a PROXY protocol header of version on the new
+// connection, with the addresses that WithProxyAddrs put in the dial's
+// context. Without them, the header has no addresses.
+//
+// A header describes its whole connection, so connections that were
+// dialed this way must not be reused for other clients
+func DialContextWithProxyHeader(dial DialContextFunc, version string) DialContextFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		addrs, _ := ctx.Value(proxyAddrsKey{}).(proxyAddrs)
+		hdr, err := ProxyHeader(version, addrs.src, addrs.dst)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if _, err := conn.Write(hdr); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
@@ -0,0 +1,177 @@
+package net
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadProxyHeader(t *testing.T) {
+	r := require.New(t)
+	src := &net.TCPAddr{IP: net.ParseIP("203.0.113.7").To4(), Port: 51234}
+	dst := &net.TCPAddr{IP: net.ParseIP("10.0.0.1").To4(), Port: 8080}
+	src6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::7"), Port: 51234}
+	dst6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443}
+
+	for _, version := range []string{ProxyProtocolV1, ProxyProtocolV2} {
+		for _, addrs := range [][2]*net.TCPAddr{{src, dst}, {src6, dst6}} {
+			hdr, err := ProxyHeader(version, addrs[0], addrs[1])
+			r.NoError(err)
+			reader := bufio.NewReader(io.MultiReader(
+				bytes.NewReader(hdr),
+				strings.NewReader("GET / HTTP/1.1\r\n"),
+			))
+			gotSrc, gotDst, err := ReadProxyHeader(reader)
+			r.NoError(err, version)
+			r.Equal(addrs[0].String(), gotSrc.String(), version)
+			r.Equal(addrs[1].String(), gotDst.String(), version)
+			// the data after the header is left to read
+			rest, err := io.ReadAll(reader)
+			r.NoError(err)
+			r.Equal("GET / HTTP/1.1\r\n", string(rest))
+		}
+
+		// headers without addresses are valid, and have none
+		hdr, err := ProxyHeader(version, nil, nil)
+		r.NoError(err)
+		gotSrc, gotDst, err := ReadProxyHeader(bufio.NewReader(bytes.NewReader(hdr)))
+		r.NoError(err, version)
+		r.Nil(gotSrc)
+		r.Nil(gotDst)
+	}
+
+	// a connection without a header is left alone
+	reader := bufio.NewReader(strings.NewReader("GET / HTTP/1.1\r\n"))
+	gotSrc, gotDst, err := ReadProxyHeader(reader)
+	r.NoError(err)
+	r.Nil(gotSrc)
+	r.Nil(gotDst)
+	rest, err := io.ReadAll(reader)
+	r.NoError(err)
+	r.Equal("GET / HTTP/1.1\r\n", string(rest))
+
+	for _, invalid := range []string{
+		"PROXY TCP4 203.0.113.7\r\n",
+		"PROXY TCP4 nope 10.0.0.1 51234 8080\r\n",
+		"PROXY TCP4 203.0.113.7 10.0.0.1 51234 99999\r\n",
+		"PROXY " + strings.Repeat("x", 200) + "\r\n",
+	} {
+		_, _, err := ReadProxyHeader(bufio.NewReader(strings.NewReader(invalid)))
+		r.Error(err, invalid)
+	}
+
+	// a truncated v2 header
+	hdr, err := ProxyHeader(ProxyProtocolV2, src, dst)
+	r.NoError(err)
+	_, _, err = ReadProxyHeader(bufio.NewReader(bytes.NewReader(hdr[:len(hdr)-3])))
+	r.Error(err)
+
+	_, err = ProxyHeader("v3", src, dst)
+	r.Error(err)
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	r := require.New(t)
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	r.NoError(err)
+	ln := NewProxyProtocolListener(inner, time.Second, nil)
+	defer ln.Close()
+
+	src := &net.TCPAddr{IP: net.ParseIP("203.0.113.7").To4(), Port: 51234}
+	dst := &net.TCPAddr{IP: net.ParseIP("10.0.0.1").To4(), Port: 8080}
+	hdr, err := ProxyHeader(ProxyProtocolV2, src, dst)
+	r.NoError(err)
+
+	client, err := net.Dial("tcp", inner.Addr().String())
+	r.NoError(err)
+	defer client.Close()
+	_, err = client.Write(append(hdr, []byte("hello")...))
+	r.NoError(err)
+
+	conn, err := ln.Accept()
+	r.NoError(err)
+	defer conn.Close()
+	r.Equal(src.String(), conn.RemoteAddr().String())
+	r.Equal(dst.String(), conn.LocalAddr().String())
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	r.NoError(err)
+	r.Equal("hello", string(buf))
+
+	// a client that sends an invalid header is closed, and never
+	// returned from Accept
+	bad, err := net.Dial("tcp", inner.Addr().String())
+	r.NoError(err)
+	defer bad.Close()
+	_, err = bad.Write([]byte("PROXY garbage\r\n"))
+	r.NoError(err)
+	r.NoError(bad.SetReadDeadline(time.Now().Add(2 * time.Second)))
+	_, err = bad.Read(buf)
+	r.ErrorIs(err, io.EOF)
+}
+
+func TestProxyProtocolListenerUntrusted(t *testing.T) {
+	r := require.New(t)
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	r.NoError(err)
+	_, trusted, err := net.ParseCIDR("192.0.2.0/24")
+	r.NoError(err)
+	ln := NewProxyProtocolListener(inner, time.Second, []*net.IPNet{trusted})
+	defer ln.Close()
+
+	src := &net.TCPAddr{IP: net.ParseIP("203.0.113.7").To4(), Port: 51234}
+	hdr, err := ProxyHeader(ProxyProtocolV1, src, inner.Addr())
+	r.NoError(err)
+	client, err := net.Dial("tcp", inner.Addr().String())
+	r.NoError(err)
+	defer client.Close()
+	_, err = client.Write(hdr)
+	r.NoError(err)
+
+	// the header from an untrusted peer isn't read, so the client can't
+	// make up its own address
+	conn, err := ln.Accept()
+	r.NoError(err)
+	defer conn.Close()
+	r.Equal(client.LocalAddr().String(), conn.RemoteAddr().String())
+	buf := make([]byte, len(hdr))
+	_, err = io.ReadFull(conn, buf)
+	r.NoError(err)
+	r.Equal(hdr, buf)
+
+	// Accept returns an error once the listener is closed
+	r.NoError(ln.Close())
+	_, err = ln.Accept()
+	r.Error(err)
+}
+
+func TestDialContextWithProxyHeader(t *testing.T) {
+	r := require.New(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	r.NoError(err)
+	defer ln.Close()
+
+	var dialer net.Dialer
+	dial := DialContextWithProxyHeader(dialer.DialContext, ProxyProtocolV1)
+	src := &net.TCPAddr{IP: net.ParseIP("203.0.113.7").To4(), Port: 51234}
+	dst := &net.TCPAddr{IP: net.ParseIP("10.0.0.1").To4(), Port: 8080}
+	ctx := WithProxyAddrs(context.Background(), src, dst)
+	conn, err := dial(ctx, "tcp", ln.Addr().String())
+	r.NoError(err)
+	defer conn.Close()
+
+	accepted, err := ln.Accept()
+	r.NoError(err)
+	defer accepted.Close()
+	gotSrc, gotDst, err := ReadProxyHeader(bufio.NewReader(accepted))
+	r.NoError(err)
+	r.Equal(src.String(), gotSrc.String())
+	r.Equal(dst.String(), gotDst.String())
+}
@@ -1,7 +1,9 @@
 package queue
 
 import (
+	"os"
 	"sync"
+	"time"
 )
 
 // CountReader represents the size of a virtual HTTP queue, possibly
@@ -36,20 +38,94 @@ type Counter interface {
 	Remove(host string) bool
 }
 
+// PendingTracker is implemented by Counters that can tell the requests
+// that are waiting for their host's backend to be ready apart from the
+// ones that are being proxied to it. Requests that are counted but not
+// pending are considered active
+type PendingTracker interface {
+	// StartPending records that a request counted under host started
+	// waiting for its backend. The returned func records that the
+	// request stopped waiting, and must be called exactly once
+	StartPending(host string) func()
+}
+
+// StreamingTracker is implemented by Counters that can tell the
+// requests that got their response headers, and are streaming their
+// response bodies, apart from the ones that are still waiting for a
+// response. Streaming requests are still counted, and are active
+type StreamingTracker interface {
+	// StartStreaming records that a request counted under host got
+	// its response headers. The returned func records that its
+	// response ended, and must be called exactly once
+	StartStreaming(host string) func()
+}
+
+// ConnectionTracker is implemented by Counters that can count the
+// client connections that are open to each host, for the whole
+// lifetime of each connection
+type ConnectionTracker interface {
+	// ResizeConnections changes the number of open
+	// connections to host by delta
+	ResizeConnections(host string, delta int)
+}
+
 // Memory is a Counter implementation that
 // holds the HTTP queue in memory only. Always use
 // NewMemory to create one of these.
 type Memory struct {
-	countMap map[string]int
-	mut      *sync.RWMutex
+	countMap   map[string]int
+	mut        *sync.RWMutex
+	source     string
+	epoch      int64
+	generation uint64
+	// pending holds the start time of each pending request,
+	// by host and then by an ID unique to the request
+	pending   map[string]map[uint64]time.Time
+	pendingID uint64
+	// waits holds the recent waits of the requests
+	// to each host that stopped pending
+	waits   map[string]*waitWindow
+	connMap map[string]int
+	// streaming holds the number of requests to each host
+	// that are streaming their responses
+	streaming map[string]int
+	// lastRequest holds the last time that a request
+	// to each host started or finished
+	lastRequest map[string]time.Time
+	// started and finished count the requests to each host that
+	// started and finished since r was created
+	started  map[string]uint64
+	finished map[string]uint64
+	now      func() time.Time
 }
 
-// NewMemoryQueue creates a new empty in-memory queue
+var _ PendingTracker = &Memory{}
+var _ ConnectionTracker = &Memory{}
+var _ StreamingTracker = &Memory{}
+
+// NewMemoryQueue creates a new empty in-memory queue.
+//
+// Snapshots returned from Current are tagged with this host's
+// name as their Source, the time at which this function was called
+// as their Epoch, and an increasing Generation
 func NewMemory() *Memory {
 	lock := new(sync.RWMutex)
+	// the hostname is the pod name in Kubernetes. if it's not
+	// available, snapshots are just left untagged
+	source, _ := os.Hostname()
 	return &Memory{
-		countMap: make(map[string]int),
-		mut:      lock,
+		countMap:    make(map[string]int),
+		mut:         lock,
+		source:      source,
+		epoch:       time.Now().UnixNano(),
+		pending:     make(map[string]map[uint64]time.Time),
+		waits:       make(map[string]*waitWindow),
+		connMap:     make(map[string]int),
+		streaming:   make(map[string]int),
+		lastRequest: make(map[string]time.Time),
+		started:     make(map[string]uint64),
+		finished:    make(map[string]uint64),
+		now:         time.Now,
 	}
 }
 
@@ -60,6 +136,12 @@ func (r *Memory) Resize(host string, delta int) error {
 	r.mut.Lock()
 	defer r.mut.Unlock()
 	r.countMap[host] += delta
+	r.lastRequest[host] = r.now()
+	if delta > 0 {
+		r.started[host] += uint64(delta)
+	} else {
+		r.finished[host] += uint64(-delta)
+	}
 	return nil
 }
 
@@ -77,14 +159,106 @@ func (r *Memory) Remove(host string) bool {
 	defer r.mut.Unlock()
 	_, ok := r.countMap[host]
 	delete(r.countMap, host)
+	delete(r.pending, host)
+	delete(r.waits, host)
+	delete(r.connMap, host)
+	delete(r.streaming, host)
+	delete(r.lastRequest, host)
+	delete(r.started, host)
+	delete(r.finished, host)
 	return ok
 }
 
+// ResizeConnections implements ConnectionTracker
+func (r *Memory) ResizeConnections(host string, delta int) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.connMap[host] += delta
+	if r.connMap[host] <= 0 {
+		delete(r.connMap, host)
+	}
+}
+
+// StartPending implements PendingTracker. The time that the request
+// spent pending is recorded in host's wait histogram once it stops
+func (r *Memory) StartPending(host string) func() {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.pendingID++
+	id := r.pendingID
+	if r.pending[host] == nil {
+		r.pending[host] = make(map[uint64]time.Time)
+	}
+	start := r.now()
+	r.pending[host][id] = start
+	return func() {
+		r.mut.Lock()
+		defer r.mut.Unlock()
+		now := r.now()
+		if r.waits[host] == nil {
+			r.waits[host] = &waitWindow{}
+		}
+		r.waits[host].observe(now, now.Sub(start))
+		delete(r.pending[host], id)
+		if len(r.pending[host]) == 0 {
+			delete(r.pending, host)
+		}
+	}
+}
+
+// StartStreaming implements StreamingTracker
+func (r *Memory) StartStreaming(host string) func() {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.streaming[host]++
+	return func() {
+		r.mut.Lock()
+		defer r.mut.Unlock()
+		r.streaming[host]--
+		if r.streaming[host] <= 0 {
+			delete(r.streaming, host)
+		}
+	}
+}
+
 // Current returns the current size of the queue.
 func (r *Memory) Current() (*Counts, error) {
-	r.mut.RLock()
-	defer r.mut.RUnlock()
+	// take the write lock, since the generation is
+	// incremented for every snapshot
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.generation++
+	now := r.now()
 	cts := NewCounts()
-	cts.Counts = r.countMap
+	for host, count := range r.countMap {
+		cts.Counts[host] = count
+		hc := HostCounts{
+			Connections: r.connMap[host],
+			Streaming:   r.streaming[host],
+			Started:     r.started[host],
+			Finished:    r.finished[host],
+		}
+		for _, start := range r.pending[host] {
+			hc.Pending++
+			if age := now.Sub(start).Milliseconds(); age > hc.OldestPendingAgeMS {
+				hc.OldestPendingAgeMS = age
+			}
+		}
+		hc.Active = count - hc.Pending
+		if hc.Active < 0 {
+			hc.Active = 0
+		}
+		if waits, ok := r.waits[host]; ok {
+			hc.Waits = waits.histogram(now)
+		}
+		if last, ok := r.lastRequest[host]; ok {
+			age := now.Sub(last).Milliseconds()
+			hc.LastRequestAgeMS = &age
+		}
+		cts.Hosts[host] = hc
+	}
+	cts.Source = r.source
+	cts.Epoch = r.epoch
+	cts.Generation = r.generation
 	return cts, nil
 }
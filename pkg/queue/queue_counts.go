@@ -3,8 +3,78 @@ package queue
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 )
 
+// CountsVersion is the version of the Counts wire format that this
+// package produces. Version 1 payloads only have a total count per
+// host. Version 2 payloads also have a HostCounts breakdown per host.
+// Version 3 breakdowns also have monotonic Started and Finished
+// counters. Version 4 breakdowns also have a Streaming count. Version
+// 5 breakdowns also have a histogram of recent Waits
+const CountsVersion = 5
+
+// HostCounts is the breakdown of a single host's count
+type HostCounts struct {
+	// Active is the number of requests that are being proxied
+	// to the host's backend
+	Active int `json:"active"`
+	// Pending is the number of requests that are waiting for
+	// the host's backend to be ready, usually on a cold start
+	Pending int `json:"pending"`
+	// OldestPendingAgeMS is how long, in milliseconds, the oldest
+	// pending request has been waiting. It's 0 if there are none
+	OldestPendingAgeMS int64 `json:"oldestPendingAgeMS"`
+	// Connections is the number of client connections that are open
+	// to the host, whether or not they have a request in flight
+	Connections int `json:"connections"`
+	// LastRequestAgeMS is how long ago, in milliseconds, a request to
+	// the host last started or finished. It's nil if there has been no
+	// request since the interceptor started, or if the interceptor
+	// predates it
+	LastRequestAgeMS *int64 `json:"lastRequestAgeMS,omitempty"`
+	// Started and Finished are the number of requests to the host that
+	// started and finished since the interceptor started. They only
+	// ever grow within an Epoch, so a consumer can tell that requests
+	// came and went between two snapshots even if the host's count is
+	// the same in both. They're 0 in snapshots older than version 3
+	Started  uint64 `json:"started,omitempty"`
+	Finished uint64 `json:"finished,omitempty"`
+	// Streaming is the number of active requests that got their
+	// response headers, and are streaming their response bodies. It's
+	// 0 in snapshots older than version 4
+	Streaming int `json:"streaming,omitempty"`
+	// Waits is the distribution of the time that the requests to the
+	// host which stopped pending in the last WaitWindow spent pending.
+	// It's nil if none did, or in snapshots older than version 5
+	Waits *WaitHistogram `json:"waits,omitempty"`
+}
+
+// Add returns the sum of h and other. The sum's OldestPendingAgeMS is
+// the larger of the two, its LastRequestAgeMS the smaller, and its
+// Waits has the waits of both
+func (h HostCounts) Add(other HostCounts) HostCounts {
+	ret := HostCounts{
+		Active:             h.Active + other.Active,
+		Pending:            h.Pending + other.Pending,
+		OldestPendingAgeMS: h.OldestPendingAgeMS,
+		Connections:        h.Connections + other.Connections,
+		LastRequestAgeMS:   h.LastRequestAgeMS,
+		Started:            h.Started + other.Started,
+		Finished:           h.Finished + other.Finished,
+		Streaming:          h.Streaming + other.Streaming,
+		Waits:              h.Waits.Add(other.Waits),
+	}
+	if other.OldestPendingAgeMS > ret.OldestPendingAgeMS {
+		ret.OldestPendingAgeMS = other.OldestPendingAgeMS
+	}
+	if other.LastRequestAgeMS != nil &&
+		(ret.LastRequestAgeMS == nil || *other.LastRequestAgeMS < *ret.LastRequestAgeMS) {
+		ret.LastRequestAgeMS = other.LastRequestAgeMS
+	}
+	return ret
+}
+
 // Counts is a snapshot of the HTTP pending request queue counts
 // for each host.
 // This is a json.Marshaler, json.Unmarshaler, and fmt.Stringer
@@ -16,25 +86,160 @@ type Counts struct {
 	json.Unmarshaler
 	fmt.Stringer
 	Counts map[string]int
+	// Source identifies the process (usually the interceptor pod)
+	// that produced this snapshot. It is empty if the producer
+	// didn't tag the snapshot
+	Source string
+	// Epoch identifies a single lifetime of the process that
+	// produced this snapshot. It changes every time that process
+	// restarts
+	Epoch int64
+	// Generation increases by one for every snapshot taken within
+	// the same Epoch. Consumers can use it to discard snapshots that
+	// are older than ones they've already seen
+	Generation uint64
+	// Version is the wire format version of this snapshot. See
+	// CountsVersion
+	Version int
+	// Hosts is the breakdown of each host's count. It's empty for
+	// snapshots older than version 2. Use Host to read it, so that
+	// older snapshots are handled too
+	Hosts map[string]HostCounts
+}
+
+// countsJSON is the wire format for Counts
+type countsJSON struct {
+	Counts     map[string]int `json:"counts"`
+	Source     string         `json:"source,omitempty"`
+	Epoch      int64          `json:"epoch,omitempty"`
+	Generation uint64         `json:"generation,omitempty"`
+	// Version is omitted for version 1, which predates it
+	Version int                   `json:"version,omitempty"`
+	Hosts   map[string]HostCounts `json:"hosts,omitempty"`
 }
 
 // NewQueueCounts creates a new empty QueueCounts struct
 func NewCounts() *Counts {
 	return &Counts{
-		Counts: map[string]int{},
+		Counts:  map[string]int{},
+		Version: CountsVersion,
+		Hosts:   map[string]HostCounts{},
+	}
+}
+
+// Host returns the breakdown of host's count. Snapshots older than
+// version 2 have no breakdown, so all of host's requests are
+// considered active for them
+func (q *Counts) Host(host string) HostCounts {
+	if hc, ok := q.Hosts[host]; ok {
+		return hc
+	}
+	return HostCounts{Active: q.Counts[host]}
+}
+
+// NewerThan returns true if q was taken after other by the same
+// source. Snapshots from a different source or epoch are always
+// considered newer, since their generations can't be compared.
+func (q *Counts) NewerThan(other *Counts) bool {
+	if q.Source != other.Source || q.Epoch != other.Epoch {
+		return true
 	}
+	return q.Generation > other.Generation
+}
+
+// RestartedSince returns true if q was produced by the same source as
+// prev, but after that source restarted, so q's counts and counters
+// started over from zero. Untagged snapshots have no epoch, so for them
+// a counter that went backwards is taken as a restart
+func (q *Counts) RestartedSince(prev *Counts) bool {
+	if q.Source != prev.Source {
+		return false
+	}
+	if q.Source != "" || q.Epoch != prev.Epoch {
+		return q.Epoch != prev.Epoch
+	}
+	for host, hc := range q.Hosts {
+		prevHC, ok := prev.Hosts[host]
+		if ok && (hc.Started < prevHC.Started || hc.Finished < prevHC.Finished) {
+			return true
+		}
+	}
+	return false
 }
 
 // MarshalJSON implements json.Marshaler
 func (q *Counts) MarshalJSON() ([]byte, error) {
-	return json.Marshal(q.Counts)
+	return json.Marshal(countsJSON{
+		Counts:     q.Counts,
+		Source:     q.Source,
+		Epoch:      q.Epoch,
+		Generation: q.Generation,
+		Version:    q.Version,
+		Hosts:      q.Hosts,
+	})
 }
 
-// UnmarshalJSON implements json.Unmarshaler
+// UnmarshalJSON implements json.Unmarshaler. It also accepts the
+// untagged format, which is a plain JSON object mapping each host
+// to its count
 func (q *Counts) UnmarshalJSON(data []byte) error {
+	wire := countsJSON{}
+	if err := json.Unmarshal(data, &wire); err == nil && wire.Counts != nil {
+		q.Counts = wire.Counts
+		q.Source = wire.Source
+		q.Epoch = wire.Epoch
+		q.Generation = wire.Generation
+		q.Version = wire.Version
+		if q.Version == 0 {
+			q.Version = 1
+		}
+		q.Hosts = wire.Hosts
+		return nil
+	}
+	q.Version = 1
 	return json.Unmarshal(data, &q.Counts)
 }
 
+// NamespacedKey returns the key that the counts of key, which is a host
+// or a key derived from one, are kept under once they leave the
+// namespace ns. Hosts can't contain a '/', so keys from different
+// namespaces never collide
+func NamespacedKey(ns, key string) string {
+	return fmt.Sprintf("%s/%s", ns, key)
+}
+
+// SplitNamespacedKey splits a key that NamespacedKey created into its
+// namespace and key. It returns false if key isn't namespaced, which is
+// the case for keys from interceptors that predate namespaced keys
+func SplitNamespacedKey(key string) (string, string, bool) {
+	split := strings.SplitN(key, "/", 2)
+	if len(split) != 2 {
+		return "", key, false
+	}
+	return split[0], split[1], true
+}
+
+// WithNamespace returns a copy of q whose keys are namespaced with ns.
+// Keys that are already namespaced are left as they are
+func (q *Counts) WithNamespace(ns string) *Counts {
+	ret := *q
+	ret.Counts = make(map[string]int, len(q.Counts))
+	ret.Hosts = make(map[string]HostCounts, len(q.Hosts))
+	qualify := func(key string) string {
+		if _, _, ok := SplitNamespacedKey(key); ok {
+			return key
+		}
+		return NamespacedKey(ns, key)
+	}
+	for key, count := range q.Counts {
+		ret.Counts[qualify(key)] = count
+	}
+	for key, hc := range q.Hosts {
+		ret.Hosts[qualify(key)] = hc
+	}
+	return &ret
+}
+
 // String implements fmt.Stringer
 func (q *Counts) String() string {
 	return fmt.Sprintf("%v", q.Counts)
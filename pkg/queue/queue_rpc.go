@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	nethttp "net/http"
 	"net/url"
@@ -14,10 +15,19 @@ import (
 
 const countsPath = "/queue"
 
-func AddCountsRoute(lggr logr.Logger, mux *nethttp.ServeMux, q CountReader) {
+// AddCountsRoute adds the route that serves q's counts to mux. If ns
+// isn't empty, the served keys are namespaced with it (see
+// NamespacedKey), so that the scaler can tell hosts in different
+// namespaces apart
+func AddCountsRoute(
+	lggr logr.Logger,
+	mux *nethttp.ServeMux,
+	q CountReader,
+	ns string,
+) {
 	lggr = lggr.WithName("pkg.queue.AddCountsRoute")
 	lggr.Info("adding queue counts route", "path", countsPath)
-	mux.Handle(countsPath, newSizeHandler(lggr, q))
+	mux.Handle(countsPath, newSizeHandler(lggr, q, ns))
 }
 
 // newForwardingHandler takes in the service URL for the app backend
@@ -26,6 +36,7 @@ func AddCountsRoute(lggr logr.Logger, mux *nethttp.ServeMux, q CountReader) {
 func newSizeHandler(
 	lggr logr.Logger,
 	q CountReader,
+	ns string,
 ) nethttp.Handler {
 	return http.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
 
@@ -38,6 +49,9 @@ func newSizeHandler(
 			))
 			return
 		}
+		if ns != "" {
+			cur = cur.WithNamespace(ns)
+		}
 		if err := json.NewEncoder(w).Encode(cur); err != nil {
 			lggr.Error(err, "encoding QueueCounts")
 			w.WriteHeader(500)
@@ -51,7 +65,8 @@ func newSizeHandler(
 
 // GetQueueCounts issues an RPC call to get the queue counts
 // from the given hostAndPort. Note that the hostAndPort should
-// not end with a "/" and shouldn't include a path.
+// not end with a "/" and shouldn't include a path. The call is
+// canceled when ctx is done.
 func GetCounts(
 	ctx context.Context,
 	lggr logr.Logger,
@@ -59,7 +74,16 @@ func GetCounts(
 	interceptorURL url.URL,
 ) (*Counts, error) {
 	interceptorURL.Path = countsPath
-	resp, err := httpCl.Get(interceptorURL.String())
+	req, err := nethttp.NewRequestWithContext(
+		ctx,
+		"GET",
+		interceptorURL.String(),
+		nil,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating the queue counts request")
+	}
+	resp, err := httpCl.Do(req)
 	if err != nil {
 		errMsg := fmt.Sprintf(
 			"requesting the queue counts from %s",
@@ -68,6 +92,9 @@ func GetCounts(
 		return nil, errors.Wrap(err, errMsg)
 	}
 	defer resp.Body.Close()
+	// whatever is left of the body is read, so that
+	// the connection can be reused for the next call
+	defer io.Copy(io.Discard, resp.Body)
 	counts := NewCounts()
 	if err := json.NewDecoder(resp.Body).Decode(counts); err != nil {
 		return nil, errors.Wrap(
@@ -20,15 +20,15 @@ func TestQueueSizeHandlerSuccess(t *testing.T) {
 		err:     nil,
 	}
 
-	handler := newSizeHandler(lggr, reader)
+	handler := newSizeHandler(lggr, reader, "")
 	req, rec := pkghttp.NewTestCtx("GET", "/queue")
 	handler.ServeHTTP(rec, req)
 	r.Equal(200, rec.Code, "response code")
-	respMap := map[string]int{}
-	decodeErr := json.NewDecoder(rec.Body).Decode(&respMap)
+	respCounts := NewCounts()
+	decodeErr := json.NewDecoder(rec.Body).Decode(respCounts)
 	r.NoError(decodeErr)
-	r.Equalf(1, len(respMap), "response JSON length was not 1")
-	sizeVal, ok := respMap["sample.com"]
+	r.Equalf(1, len(respCounts.Counts), "response JSON length was not 1")
+	sizeVal, ok := respCounts.Counts["sample.com"]
 	r.Truef(ok, "'sample.com' entry not available in return JSON")
 	r.Equalf(reader.current, sizeVal, "returned JSON queue size was wrong")
 
@@ -38,6 +38,28 @@ func TestQueueSizeHandlerSuccess(t *testing.T) {
 	r.Equal(500, rec.Code, "response code was not expected")
 }
 
+func TestQueueSizeHandlerNamespaced(t *testing.T) {
+	lggr := logr.Discard()
+	r := require.New(t)
+	reader := &FakeCountReader{current: 12}
+
+	handler := newSizeHandler(lggr, reader, "testns")
+	req, rec := pkghttp.NewTestCtx("GET", "/queue")
+	handler.ServeHTTP(rec, req)
+	r.Equal(200, rec.Code, "response code")
+	respCounts := NewCounts()
+	r.NoError(json.NewDecoder(rec.Body).Decode(respCounts))
+	r.Equal(map[string]int{"testns/sample.com": 12}, respCounts.Counts)
+
+	ns, host, ok := SplitNamespacedKey("testns/sample.com")
+	r.True(ok)
+	r.Equal("testns", ns)
+	r.Equal("sample.com", host)
+	_, host, ok = SplitNamespacedKey("sample.com")
+	r.False(ok)
+	r.Equal("sample.com", host)
+}
+
 func TestQueueSizeHandlerFail(t *testing.T) {
 	lggr := logr.Discard()
 	r := require.New(t)
@@ -46,7 +68,7 @@ func TestQueueSizeHandlerFail(t *testing.T) {
 		err:     errors.New("test error"),
 	}
 
-	handler := newSizeHandler(lggr, reader)
+	handler := newSizeHandler(lggr, reader, "")
 	req, rec := pkghttp.NewTestCtx("GET", "/queue")
 	handler.ServeHTTP(rec, req)
 	r.Equal(500, rec.Code, "response code")
@@ -61,7 +83,7 @@ func TestQueueSizeHandlerIntegration(t *testing.T) {
 		err:     nil,
 	}
 
-	hdl := kedanet.NewTestHTTPHandlerWrapper(newSizeHandler(lggr, reader))
+	hdl := kedanet.NewTestHTTPHandlerWrapper(newSizeHandler(lggr, reader, ""))
 	srv, url, err := kedanet.StartTestServer(hdl)
 	r.NoError(err)
 	defer srv.Close()
@@ -76,3 +98,42 @@ func TestQueueSizeHandlerIntegration(t *testing.T) {
 	r.Equal(1, len(reqs))
 
 }
+
+func TestCountsJSON(t *testing.T) {
+	r := require.New(t)
+	counts := NewCounts()
+	counts.Counts["host1"] = 123
+	counts.Source = "interceptor1"
+	counts.Epoch = 456
+	counts.Generation = 789
+	counts.Hosts["host1"] = HostCounts{Active: 120, Pending: 3, OldestPendingAgeMS: 1500}
+	b, err := json.Marshal(counts)
+	r.NoError(err)
+
+	decoded := NewCounts()
+	r.NoError(json.Unmarshal(b, decoded))
+	r.Equal(counts.Counts, decoded.Counts)
+	r.Equal(counts.Source, decoded.Source)
+	r.Equal(counts.Epoch, decoded.Epoch)
+	r.Equal(counts.Generation, decoded.Generation)
+	r.Equal(CountsVersion, decoded.Version)
+	r.Equal(counts.Hosts["host1"], decoded.Host("host1"))
+
+	// version 1 counts have no breakdown, so all
+	// their requests are considered active
+	decoded = NewCounts()
+	r.NoError(json.Unmarshal(
+		[]byte(`{"counts":{"host1":123},"source":"interceptor1"}`),
+		decoded,
+	))
+	r.Equal(1, decoded.Version)
+	r.Equal(HostCounts{Active: 123}, decoded.Host("host1"))
+
+	// untagged counts from older interceptors are
+	// just a map of hosts to counts
+	decoded = NewCounts()
+	r.NoError(json.Unmarshal([]byte(`{"host1":123}`), decoded))
+	r.Equal(map[string]int{"host1": 123}, decoded.Counts)
+	r.Empty(decoded.Source)
+	r.Equal(1, decoded.Version)
+}
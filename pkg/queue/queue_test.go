@@ -0,0 +1,190 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryPending(t *testing.T) {
+	r := require.New(t)
+	q := NewMemory()
+	now := time.Now()
+	q.now = func() time.Time { return now }
+
+	r.NoError(q.Resize("host1", 3))
+	doneFirst := q.StartPending("host1")
+	now = now.Add(2 * time.Second)
+	doneSecond := q.StartPending("host1")
+	now = now.Add(time.Second)
+
+	// the requests started 3 seconds ago
+	lastRequestAgeMS := int64(3000)
+	cts, err := q.Current()
+	r.NoError(err)
+	r.Equal(CountsVersion, cts.Version)
+	r.Equal(3, cts.Counts["host1"])
+	r.Equal(HostCounts{
+		Active:             1,
+		Pending:            2,
+		OldestPendingAgeMS: 3000,
+		LastRequestAgeMS:   &lastRequestAgeMS,
+		Started:            3,
+	}, cts.Host("host1"))
+
+	// once the oldest request is done waiting,
+	// the next oldest one's age is reported, and
+	// its wait is in the histogram
+	doneFirst()
+	waits := NewWaitHistogram()
+	waits.Observe(3 * time.Second)
+	cts, err = q.Current()
+	r.NoError(err)
+	r.Equal(HostCounts{
+		Active:             2,
+		Pending:            1,
+		OldestPendingAgeMS: 1000,
+		LastRequestAgeMS:   &lastRequestAgeMS,
+		Started:            3,
+		Waits:              waits,
+	}, cts.Host("host1"))
+
+	doneSecond()
+	waits.Observe(time.Second)
+	cts, err = q.Current()
+	r.NoError(err)
+	r.Equal(HostCounts{
+		Active:           3,
+		LastRequestAgeMS: &lastRequestAgeMS,
+		Started:          3,
+		Waits:            waits,
+	}, cts.Host("host1"))
+}
+
+func TestMemoryLastRequest(t *testing.T) {
+	r := require.New(t)
+	q := NewMemory()
+	now := time.Now()
+	q.now = func() time.Time { return now }
+
+	// hosts without requests have no last request
+	q.Ensure("host1")
+	cts, err := q.Current()
+	r.NoError(err)
+	r.Nil(cts.Host("host1").LastRequestAgeMS)
+
+	// a request's start and finish both count as requests
+	r.NoError(q.Resize("host1", 1))
+	now = now.Add(5 * time.Second)
+	r.NoError(q.Resize("host1", -1))
+	now = now.Add(2 * time.Second)
+	cts, err = q.Current()
+	r.NoError(err)
+	r.Equal(int64(2000), *cts.Host("host1").LastRequestAgeMS)
+
+	// the most recent request of many hosts' wins
+	other := HostCounts{LastRequestAgeMS: cts.Host("host1").LastRequestAgeMS}
+	older := int64(9000)
+	r.Equal(int64(2000), *HostCounts{LastRequestAgeMS: &older}.Add(other).LastRequestAgeMS)
+	r.Equal(int64(2000), *HostCounts{}.Add(other).LastRequestAgeMS)
+}
+
+func TestMemoryMonotonicCounters(t *testing.T) {
+	r := require.New(t)
+	q := NewMemory()
+
+	// the count goes back to 0, but the counters
+	// show that requests came and went
+	r.NoError(q.Resize("host1", 2))
+	r.NoError(q.Resize("host1", -1))
+	first, err := q.Current()
+	r.NoError(err)
+	r.NoError(q.Resize("host1", 1))
+	r.NoError(q.Resize("host1", -2))
+	second, err := q.Current()
+	r.NoError(err)
+	r.Equal(0, second.Counts["host1"])
+	r.Equal(uint64(3), second.Host("host1").Started)
+	r.Equal(uint64(3), second.Host("host1").Finished)
+	r.False(second.RestartedSince(first))
+
+	// a new queue is a restart of the same source
+	restarted, err := NewMemory().Current()
+	r.NoError(err)
+	restarted.Epoch = second.Epoch + 1
+	r.True(restarted.RestartedSince(second))
+
+	// untagged snapshots restart when their counters go backwards
+	first.Source, second.Source = "", ""
+	first.Epoch, second.Epoch = 0, 0
+	r.False(second.RestartedSince(first))
+	r.True(first.RestartedSince(second))
+}
+
+func TestMemoryConnections(t *testing.T) {
+	r := require.New(t)
+	q := NewMemory()
+	q.Ensure("host1")
+	q.ResizeConnections("host1", 2)
+	cts, err := q.Current()
+	r.NoError(err)
+	r.Equal(HostCounts{Connections: 2}, cts.Host("host1"))
+
+	q.ResizeConnections("host1", -2)
+	cts, err = q.Current()
+	r.NoError(err)
+	r.Equal(HostCounts{}, cts.Host("host1"))
+
+	// connections that close after their host was
+	// removed don't leave negative counts behind
+	q.ResizeConnections("host1", 1)
+	r.True(q.Remove("host1"))
+	q.ResizeConnections("host1", -1)
+	q.Ensure("host1")
+	cts, err = q.Current()
+	r.NoError(err)
+	r.Equal(HostCounts{}, cts.Host("host1"))
+}
+
+func TestMemoryStreaming(t *testing.T) {
+	r := require.New(t)
+	q := NewMemory()
+	now := time.Now()
+	q.now = func() time.Time { return now }
+	r.NoError(q.Resize("host1", 2))
+	doneStreaming := q.StartStreaming("host1")
+	lastRequestAgeMS := int64(0)
+	cts, err := q.Current()
+	r.NoError(err)
+	r.Equal(2, cts.Counts["host1"])
+	r.Equal(HostCounts{
+		Active:           2,
+		Streaming:        1,
+		LastRequestAgeMS: &lastRequestAgeMS,
+		Started:          2,
+	}, cts.Host("host1"))
+
+	doneStreaming()
+	cts, err = q.Current()
+	r.NoError(err)
+	r.Equal(0, cts.Host("host1").Streaming)
+
+	// streams that end after their host was removed
+	// don't leave negative counts behind
+	doneStreaming = q.StartStreaming("host1")
+	r.True(q.Remove("host1"))
+	doneStreaming()
+	q.Ensure("host1")
+	cts, err = q.Current()
+	r.NoError(err)
+	r.Equal(HostCounts{}, cts.Host("host1"))
+}
+
+func TestHostCountsAdd(t *testing.T) {
+	r := require.New(t)
+	sum := HostCounts{Active: 1, Pending: 2, OldestPendingAgeMS: 100, Connections: 1}.Add(
+		HostCounts{Active: 3, Pending: 4, OldestPendingAgeMS: 50, Connections: 2},
+	)
+	r.Equal(HostCounts{Active: 4, Pending: 6, OldestPendingAgeMS: 100, Connections: 3}, sum)
+}
@@ -0,0 +1,104 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// RegistrationPath is the path on the scaler's health server at which
+// interceptors register themselves, so that interceptors that aren't
+// in one of the scaler's fleets, like the interceptors of a single
+// namespace, still have their counts scraped
+const RegistrationPath = "/interceptors"
+
+// Registration is how an interceptor pod tells the scaler where to
+// scrape its counts
+type Registration struct {
+	// Namespace is the namespace that the interceptor runs in, and
+	// routes the hosts of
+	Namespace string `json:"namespace"`
+	// Pod is the name of the interceptor's pod
+	Pod string `json:"pod"`
+	// Address is the host:port of the interceptor's admin server
+	Address string `json:"address"`
+	// TTLSeconds is how long the scaler keeps the registration.
+	// Interceptors register again before it runs out
+	TTLSeconds int `json:"ttlSeconds"`
+}
+
+// Key identifies the interceptor that r registers
+func (r Registration) Key() string {
+	return r.Namespace + "/" + r.Pod
+}
+
+// Register sends reg to the scaler at scalerURL, with token as its
+// bearer token. It returns an error if the scaler didn't accept it
+func Register(
+	ctx context.Context,
+	cl *http.Client,
+	scalerURL url.URL,
+	token string,
+	reg Registration,
+) error {
+	body, err := json.Marshal(reg)
+	if err != nil {
+		return err
+	}
+	return sendRegistration(ctx, cl, http.MethodPost, scalerURL, token, body)
+}
+
+// Deregister tells the scaler at scalerURL to stop scraping the
+// interceptor that reg registered
+func Deregister(
+	ctx context.Context,
+	cl *http.Client,
+	scalerURL url.URL,
+	token string,
+	reg Registration,
+) error {
+	body, err := json.Marshal(reg)
+	if err != nil {
+		return err
+	}
+	return sendRegistration(ctx, cl, http.MethodDelete, scalerURL, token, body)
+}
+
+func sendRegistration(
+	ctx context.Context,
+	cl *http.Client,
+	method string,
+	scalerURL url.URL,
+	token string,
+	body []byte,
+) error {
+	scalerURL.Path = RegistrationPath
+	req, err := http.NewRequestWithContext(
+		ctx,
+		method,
+		scalerURL.String(),
+		bytes.NewReader(body),
+	)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	res, err := cl.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf(
+			"%s %s returned %d",
+			method,
+			scalerURL.String(),
+			res.StatusCode,
+		)
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/k8s"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// SnapshotStore knows how to persist snapshots of queue counts
+// so that they survive process restarts
+type SnapshotStore interface {
+	// Save persists counts, replacing any previously saved snapshot
+	Save(ctx context.Context, counts *Counts) error
+	// Load returns the most recently saved snapshot. Returns
+	// nil and a nil error if no snapshot was ever saved
+	Load(ctx context.Context) (*Counts, error)
+}
+
+// FileSnapshotStore is a SnapshotStore that saves snapshots as
+// JSON in a file on the local filesystem, usually on a volume
+// that outlives the container
+type FileSnapshotStore struct {
+	path string
+}
+
+var _ SnapshotStore = &FileSnapshotStore{}
+
+// NewFileSnapshotStore creates a new FileSnapshotStore that
+// saves snapshots to path
+func NewFileSnapshotStore(path string) *FileSnapshotStore {
+	return &FileSnapshotStore{path: path}
+}
+
+// Save implements SnapshotStore. It writes to a temporary file first
+// and then renames it, so that a crash mid-write never leaves
+// a corrupted snapshot behind
+func (f *FileSnapshotStore) Save(_ context.Context, counts *Counts) error {
+	b, err := json.Marshal(counts)
+	if err != nil {
+		return errors.Wrap(err, "encoding snapshot")
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Dir(f.path), ".snapshot")
+	if err != nil {
+		return errors.Wrap(err, "creating temporary snapshot file")
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(b); err != nil {
+		tmpFile.Close()
+		return errors.Wrap(err, "writing temporary snapshot file")
+	}
+	if err := tmpFile.Close(); err != nil {
+		return errors.Wrap(err, "closing temporary snapshot file")
+	}
+	return os.Rename(tmpFile.Name(), f.path)
+}
+
+// Load implements SnapshotStore
+func (f *FileSnapshotStore) Load(context.Context) (*Counts, error) {
+	b, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "reading snapshot file")
+	}
+	ret := NewCounts()
+	if err := json.Unmarshal(b, ret); err != nil {
+		return nil, errors.Wrap(err, "decoding snapshot file")
+	}
+	return ret, nil
+}
+
+// ConfigMapSnapshotStore is a SnapshotStore that saves snapshots
+// as JSON under a single key in a shared ConfigMap. Each process
+// that shares the ConfigMap must use a different key
+type ConfigMapSnapshotStore struct {
+	cl   k8s.ConfigMapGetterCreatorUpdater
+	ns   string
+	name string
+	key  string
+}
+
+var _ SnapshotStore = &ConfigMapSnapshotStore{}
+
+// NewConfigMapSnapshotStore creates a new ConfigMapSnapshotStore
+// that saves snapshots to the key field of the ConfigMap called
+// name in namespace ns. The ConfigMap is created if it doesn't exist
+func NewConfigMapSnapshotStore(
+	cl k8s.ConfigMapGetterCreatorUpdater,
+	ns,
+	name,
+	key string,
+) *ConfigMapSnapshotStore {
+	return &ConfigMapSnapshotStore{
+		cl:   cl,
+		ns:   ns,
+		name: name,
+		key:  key,
+	}
+}
+
+// Save implements SnapshotStore
+func (c *ConfigMapSnapshotStore) Save(ctx context.Context, counts *Counts) error {
+	b, err := json.Marshal(counts)
+	if err != nil {
+		return errors.Wrap(err, "encoding snapshot")
+	}
+	// other processes update their own keys in the same
+	// ConfigMap, so retry if we conflict with them
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := c.cl.Get(ctx, c.name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			cm = k8s.NewConfigMap(
+				c.ns,
+				c.name,
+				map[string]string{},
+				map[string]string{c.key: string(b)},
+			)
+			_, err := c.cl.Create(ctx, cm, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				// another process created it first. return a
+				// conflict so that we try again with an update
+				return apierrors.NewConflict(
+					corev1.Resource("configmaps"),
+					c.name,
+					err,
+				)
+			}
+			return err
+		} else if err != nil {
+			return err
+		}
+		newCM := cm.DeepCopy()
+		if newCM.Data == nil {
+			newCM.Data = map[string]string{}
+		}
+		newCM.Data[c.key] = string(b)
+		_, err = c.cl.Update(ctx, newCM, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// Load implements SnapshotStore
+func (c *ConfigMapSnapshotStore) Load(ctx context.Context) (*Counts, error) {
+	cm, err := c.cl.Get(ctx, c.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(
+			err,
+			fmt.Sprintf("fetching snapshot ConfigMap %s", c.name),
+		)
+	}
+	data, ok := cm.Data[c.key]
+	if !ok {
+		return nil, nil
+	}
+	ret := NewCounts()
+	if err := json.Unmarshal([]byte(data), ret); err != nil {
+		return nil, errors.Wrap(
+			err,
+			fmt.Sprintf("decoding key %s in snapshot ConfigMap %s", c.key, c.name),
+		)
+	}
+	return ret, nil
+}
+
+// StartSnapshotter saves a snapshot of q's counts to store every
+// saveEvery. It only returns when ctx is done. Failures to save
+// are logged, but otherwise ignored
+func StartSnapshotter(
+	ctx context.Context,
+	lggr logr.Logger,
+	store SnapshotStore,
+	q CountReader,
+	saveEvery time.Duration,
+) error {
+	lggr = lggr.WithName("pkg.queue.StartSnapshotter")
+	ticker := time.NewTicker(saveEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "context is done")
+		case <-ticker.C:
+			counts, err := q.Current()
+			if err != nil {
+				lggr.Error(err, "getting current counts to snapshot")
+				continue
+			}
+			if err := store.Save(ctx, counts); err != nil {
+				lggr.Error(err, "saving counts snapshot")
+			}
+		}
+	}
+}
+
+// RestoreSnapshot loads the most recent snapshot from store and adds
+// its counts to q. Since the requests those counts represent didn't
+// survive the restart, the restored counts are removed from q again
+// after expireAfter. This holds the counts steady while clients retry,
+// rather than letting them drop to zero immediately.
+func RestoreSnapshot(
+	ctx context.Context,
+	lggr logr.Logger,
+	store SnapshotStore,
+	q Counter,
+	expireAfter time.Duration,
+) error {
+	lggr = lggr.WithName("pkg.queue.RestoreSnapshot")
+	snapshot, err := store.Load(ctx)
+	if err != nil {
+		return errors.Wrap(err, "loading counts snapshot")
+	}
+	if snapshot == nil {
+		lggr.Info("no counts snapshot found, not restoring")
+		return nil
+	}
+	restored := map[string]int{}
+	for host, count := range snapshot.Counts {
+		if count <= 0 {
+			continue
+		}
+		if err := q.Resize(host, count); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("restoring count for host %s", host))
+		}
+		restored[host] = count
+	}
+	lggr.Info("restored counts snapshot", "counts", restored)
+
+	go func() {
+		t := time.NewTimer(expireAfter)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		cur, err := q.Current()
+		if err != nil {
+			lggr.Error(err, "getting current counts to expire the restored snapshot")
+			return
+		}
+		for host, count := range restored {
+			// don't resurrect hosts that were removed
+			// from the queue in the meantime
+			if _, ok := cur.Counts[host]; !ok {
+				continue
+			}
+			if err := q.Resize(host, -count); err != nil {
+				lggr.Error(err, "expiring restored count", "host", host)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,92 @@
+package queue
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testSnapshotStore(t *testing.T, store SnapshotStore) {
+	t.Helper()
+	r := require.New(t)
+	ctx := context.Background()
+
+	// nothing has been saved yet
+	loaded, err := store.Load(ctx)
+	r.NoError(err)
+	r.Nil(loaded)
+
+	counts := NewCounts()
+	counts.Counts["host1"] = 1
+	counts.Counts["host2"] = 2
+	r.NoError(store.Save(ctx, counts))
+	loaded, err = store.Load(ctx)
+	r.NoError(err)
+	r.Equal(counts.Counts, loaded.Counts)
+
+	// saving again should replace the previous snapshot
+	counts.Counts = map[string]int{"host3": 3}
+	r.NoError(store.Save(ctx, counts))
+	loaded, err = store.Load(ctx)
+	r.NoError(err)
+	r.Equal(counts.Counts, loaded.Counts)
+}
+
+func TestFileSnapshotStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	testSnapshotStore(t, NewFileSnapshotStore(path))
+}
+
+func TestConfigMapSnapshotStore(t *testing.T) {
+	r := require.New(t)
+	const (
+		ns     = "testns"
+		cmName = "testcm"
+	)
+	cmClient := fake.NewSimpleClientset().CoreV1().ConfigMaps(ns)
+	store1 := NewConfigMapSnapshotStore(cmClient, ns, cmName, "interceptor1")
+	testSnapshotStore(t, store1)
+
+	// a second store shares the ConfigMap but has its own key
+	store2 := NewConfigMapSnapshotStore(cmClient, ns, cmName, "interceptor2")
+	testSnapshotStore(t, store2)
+	loaded, err := store1.Load(context.Background())
+	r.NoError(err)
+	r.Equal(map[string]int{"host3": 3}, loaded.Counts)
+}
+
+func TestRestoreSnapshot(t *testing.T) {
+	r := require.New(t)
+	ctx, done := context.WithCancel(context.Background())
+	defer done()
+	store := NewFileSnapshotStore(filepath.Join(t.TempDir(), "snapshot.json"))
+	snapshot := NewCounts()
+	snapshot.Counts["host1"] = 10
+	snapshot.Counts["host2"] = 20
+	r.NoError(store.Save(ctx, snapshot))
+
+	q := NewMemory()
+	r.NoError(q.Resize("host1", 1))
+	const expireAfter = 50 * time.Millisecond
+	r.NoError(RestoreSnapshot(ctx, logr.Discard(), store, q, expireAfter))
+	cur, err := q.Current()
+	r.NoError(err)
+	r.Equal(map[string]int{"host1": 11, "host2": 20}, cur.Counts)
+
+	// host2 was removed in the meantime, so it
+	// shouldn't come back when the snapshot expires
+	q.Remove("host2")
+	r.Eventually(func() bool {
+		cur, err := q.Current()
+		r.NoError(err)
+		return cur.Counts["host1"] == 1
+	}, time.Second, expireAfter/5)
+	cur, err = q.Current()
+	r.NoError(err)
+	r.Equal(map[string]int{"host1": 1}, cur.Counts)
+}
@@ -0,0 +1,164 @@
+package queue
+
+import (
+	"math"
+	"time"
+)
+
+// WaitBucketsMS are the upper bounds, in milliseconds, of the buckets
+// of the wait histograms
+var WaitBucketsMS = []int64{
+	50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000,
+}
+
+const (
+	// WaitSlotDuration is the span of time that each slot of a host's
+	// wait window covers
+	WaitSlotDuration = 10 * time.Second
+	// waitSlots is the number of slots in a host's wait window
+	waitSlots = 6
+	// WaitWindow is the span of time that the wait histograms in
+	// HostCounts cover. Waits that ended longer ago age out of them
+	WaitWindow = waitSlots * WaitSlotDuration
+)
+
+// WaitHistogram is the distribution of the time that requests spent
+// pending, waiting for their host's backend to be ready. Buckets are
+// cumulative, like Prometheus': each one counts the waits that took at
+// most LeMS milliseconds. Waits longer than the last bucket are only in
+// Count and SumMS
+type WaitHistogram struct {
+	Buckets []WaitBucket `json:"buckets"`
+	Count   uint64       `json:"count"`
+	SumMS   int64        `json:"sumMS"`
+}
+
+// WaitBucket is a single bucket of a WaitHistogram
+type WaitBucket struct {
+	LeMS  int64  `json:"leMS"`
+	Count uint64 `json:"count"`
+}
+
+// NewWaitHistogram creates a new empty WaitHistogram with the buckets
+// in WaitBucketsMS
+func NewWaitHistogram() *WaitHistogram {
+	ret := &WaitHistogram{
+		Buckets: make([]WaitBucket, len(WaitBucketsMS)),
+	}
+	for i, le := range WaitBucketsMS {
+		ret.Buckets[i].LeMS = le
+	}
+	return ret
+}
+
+// Observe records a wait that took d
+func (h *WaitHistogram) Observe(d time.Duration) {
+	ms := d.Milliseconds()
+	h.Count++
+	h.SumMS += ms
+	for i := range h.Buckets {
+		if ms <= h.Buckets[i].LeMS {
+			h.Buckets[i].Count++
+		}
+	}
+}
+
+// Add returns a new WaitHistogram with the waits of both h and other.
+// Buckets are matched by their bounds, so that histograms from
+// interceptors with different buckets can still be added; the buckets
+// that only one of them has are left out. Either may be nil, and the
+// sum is nil if both are
+func (h *WaitHistogram) Add(other *WaitHistogram) *WaitHistogram {
+	if h == nil && other == nil {
+		return nil
+	}
+	if h == nil {
+		h, other = other, h
+	}
+	ret := &WaitHistogram{
+		Buckets: make([]WaitBucket, len(h.Buckets)),
+		Count:   h.Count,
+		SumMS:   h.SumMS,
+	}
+	copy(ret.Buckets, h.Buckets)
+	if other == nil {
+		return ret
+	}
+	ret.Count += other.Count
+	ret.SumMS += other.SumMS
+	otherCounts := make(map[int64]uint64, len(other.Buckets))
+	for _, bucket := range other.Buckets {
+		otherCounts[bucket.LeMS] = bucket.Count
+	}
+	buckets := ret.Buckets[:0]
+	for _, bucket := range ret.Buckets {
+		otherCount, ok := otherCounts[bucket.LeMS]
+		if !ok {
+			continue
+		}
+		bucket.Count += otherCount
+		buckets = append(buckets, bucket)
+	}
+	ret.Buckets = buckets
+	return ret
+}
+
+// Quantile returns an estimate of the q quantile of h's waits, with q
+// between 0 and 1. It's the upper bound of the bucket that the
+// quantile falls in, so it's never lower than the real quantile. If it
+// falls past the last bucket, it's that bucket's bound or the mean of
+// all of h's waits, whichever is higher. Returns 0 if h is nil or has
+// no waits
+func (h *WaitHistogram) Quantile(q float64) time.Duration {
+	if h == nil || h.Count == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(h.Count)))
+	if rank == 0 {
+		rank = 1
+	}
+	for _, bucket := range h.Buckets {
+		if bucket.Count >= rank {
+			return time.Duration(bucket.LeMS) * time.Millisecond
+		}
+	}
+	ret := h.SumMS / int64(h.Count)
+	if n := len(h.Buckets); n > 0 && h.Buckets[n-1].LeMS > ret {
+		ret = h.Buckets[n-1].LeMS
+	}
+	return time.Duration(ret) * time.Millisecond
+}
+
+// waitWindow is a ring of WaitHistograms, one per WaitSlotDuration,
+// so that a host's waits age out of its histogram after WaitWindow
+type waitWindow struct {
+	slots [waitSlots]*WaitHistogram
+	// starts is the start of the span of time
+	// that each of slots covers
+	starts [waitSlots]time.Time
+}
+
+// observe records a wait that took d and ended at now
+func (w *waitWindow) observe(now time.Time, d time.Duration) {
+	start := now.Truncate(WaitSlotDuration)
+	i := int(start.UnixNano()/int64(WaitSlotDuration)) % waitSlots
+	if w.slots[i] == nil || !w.starts[i].Equal(start) {
+		w.slots[i] = NewWaitHistogram()
+		w.starts[i] = start
+	}
+	w.slots[i].Observe(d)
+}
+
+// histogram returns the sum of the slots of w that cover the
+// WaitWindow before now, or nil if none of them has waits
+func (w *waitWindow) histogram(now time.Time) *WaitHistogram {
+	var ret *WaitHistogram
+	oldest := now.Truncate(WaitSlotDuration).Add(-WaitWindow + WaitSlotDuration)
+	for i, slot := range w.slots {
+		if slot == nil || w.starts[i].Before(oldest) {
+			continue
+		}
+		ret = ret.Add(slot)
+	}
+	return ret
+}
@@ -0,0 +1,109 @@
+package queue
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitHistogram(t *testing.T) {
+	r := require.New(t)
+	var nilHist *WaitHistogram
+	r.Nil(nilHist.Add(nil))
+	r.Zero(nilHist.Quantile(0.95))
+
+	hist := NewWaitHistogram()
+	for _, d := range []time.Duration{
+		20 * time.Millisecond,
+		80 * time.Millisecond,
+		400 * time.Millisecond,
+		2 * time.Second,
+	} {
+		hist.Observe(d)
+	}
+	r.EqualValues(4, hist.Count)
+	r.EqualValues(2500, hist.SumMS)
+	r.Equal(WaitBucket{LeMS: 50, Count: 1}, hist.Buckets[0])
+	r.Equal(WaitBucket{LeMS: 500, Count: 3}, hist.Buckets[3])
+	r.Equal(WaitBucket{LeMS: 60000, Count: 4}, hist.Buckets[len(hist.Buckets)-1])
+	// quantiles are the bounds of the buckets they fall in
+	r.Equal(100*time.Millisecond, hist.Quantile(0.5))
+	r.Equal(2500*time.Millisecond, hist.Quantile(0.95))
+
+	// sums don't change either histogram
+	sum := hist.Add(hist)
+	r.EqualValues(8, sum.Count)
+	r.EqualValues(6, sum.Buckets[3].Count)
+	r.EqualValues(3, hist.Buckets[3].Count)
+	r.Equal(hist, nilHist.Add(hist))
+	r.NotSame(hist, nilHist.Add(hist))
+
+	// only the buckets that both have are kept
+	other := &WaitHistogram{
+		Buckets: []WaitBucket{{LeMS: 100, Count: 1}, {LeMS: 200, Count: 1}},
+		Count:   1,
+		SumMS:   90,
+	}
+	sum = hist.Add(other)
+	r.Equal([]WaitBucket{{LeMS: 100, Count: 3}}, sum.Buckets)
+	r.EqualValues(5, sum.Count)
+
+	// waits past the last bucket are estimated by the mean
+	long := NewWaitHistogram()
+	long.Observe(100 * time.Second)
+	long.Observe(140 * time.Second)
+	r.Equal(120*time.Second, long.Quantile(0.99))
+
+	// it survives a round trip through the wire format
+	hc := HostCounts{Pending: 1, Waits: hist}
+	data, err := json.Marshal(hc)
+	r.NoError(err)
+	decoded := HostCounts{}
+	r.NoError(json.Unmarshal(data, &decoded))
+	r.Equal(hc, decoded)
+}
+
+func TestMemoryWaitWindow(t *testing.T) {
+	r := require.New(t)
+	q := NewMemory()
+	// start at the start of a slot, so that
+	// the waits below fall in different slots
+	start := time.Unix(0, 0).Add(1000 * WaitSlotDuration)
+	now := start
+	q.now = func() time.Time { return now }
+
+	r.NoError(q.Resize("host1", 1))
+	done := q.StartPending("host1")
+	now = now.Add(time.Second)
+	done()
+	now = now.Add(WaitSlotDuration)
+	done = q.StartPending("host1")
+	now = now.Add(3 * time.Second)
+	done()
+
+	cts, err := q.Current()
+	r.NoError(err)
+	waits := cts.Host("host1").Waits
+	r.NotNil(waits)
+	r.EqualValues(2, waits.Count)
+	r.EqualValues(4000, waits.SumMS)
+
+	// each wait ages out of the histogram
+	// once its slot is older than the window
+	now = start.Add(WaitWindow + WaitSlotDuration/2)
+	cts, err = q.Current()
+	r.NoError(err)
+	r.EqualValues(1, cts.Host("host1").Waits.Count)
+	r.EqualValues(3000, cts.Host("host1").Waits.SumMS)
+	now = now.Add(WaitSlotDuration)
+	cts, err = q.Current()
+	r.NoError(err)
+	r.Nil(cts.Host("host1").Waits)
+
+	// Add sums the histograms too
+	sum := HostCounts{Waits: waits}.Add(HostCounts{Waits: waits})
+	r.EqualValues(4, sum.Waits.Count)
+	r.EqualValues(2, waits.Count)
+}
@@ -56,10 +56,26 @@ func FetchTableFromConfigMap(configMap *corev1.ConfigMap, q queue.Counter) (*Tab
 	return ret, nil
 }
 
-// updateQueueFromTable ensures that every host in the routing table
-// exists in the given queue, and no hosts exist in the queue that
-// don't exist in the routing table. It uses q.Ensure() and q.Remove()
-// to do those things, respectively.
+// logTableDiff logs what changed in a routing table when it was
+// replaced, if anything did
+func logTableDiff(lggr logr.Logger, diff TableDiff) {
+	if diff.Empty() {
+		return
+	}
+	lggr.Info(
+		"routing table changed",
+		"oldVersion", diff.OldVersion,
+		"newVersion", diff.NewVersion,
+		"added", diff.Added,
+		"removed", diff.Removed,
+		"changed", diff.Changed,
+	)
+}
+
+// updateQueueFromTable ensures that every host in the routing table,
+// and the canary queue key of every host with a canary, exists in the
+// given queue, and no other keys exist in the queue. It uses
+// q.Ensure() and q.Remove() to do those things, respectively.
 func updateQueueFromTable(
 	lggr logr.Logger,
 	table *Table,
@@ -68,8 +84,9 @@ func updateQueueFromTable(
 	// ensure that every host is in the queue, even if it has
 	// zero pending requests. This is important so that the
 	// scaler can report on all applications.
-	for host := range table.m {
-		q.Ensure(host)
+	keys := table.queueKeys()
+	for key := range keys {
+		q.Ensure(key)
 	}
 
 	// ensure that the queue doesn't have any extra hosts that don't exist in the table
@@ -81,9 +98,9 @@ func updateQueueFromTable(
 		)
 		return errors.Wrap(err, "pkg.routing.updateQueueFromTable")
 	}
-	for host := range qCur.Counts {
-		if _, err := table.Lookup(host); err != nil {
-			q.Remove(host)
+	for key := range qCur.Counts {
+		if _, ok := keys[key]; !ok {
+			q.Remove(key)
 		}
 	}
 	return nil
@@ -143,7 +160,7 @@ func GetTable(
 		)
 	}
 
-	table.Replace(newTable)
+	logTableDiff(lggr, table.Replace(newTable))
 	if err := updateQueueFromTable(lggr, table, q); err != nil {
 		lggr.Error(
 			err,
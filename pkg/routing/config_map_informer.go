@@ -0,0 +1,92 @@
+package routing
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/queue"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// StartConfigMapRoutingTableInformer starts a shared informer on the
+// ConfigMap called ConfigMapRoutingTableName in namespace ns. Every time
+// the informer sees that ConfigMap added or changed, it decodes it into
+// a routing table, calls table.Replace(newTable), and updates q so that
+// it has exactly the hosts in the new table.
+//
+// The informer also re-delivers the ConfigMap every resyncEvery, as a
+// fallback in case an update was missed. It only returns when ctx is
+// done, or if the informer's cache couldn't be synced
+func StartConfigMapRoutingTableInformer(
+	ctx context.Context,
+	lggr logr.Logger,
+	cl kubernetes.Interface,
+	ns string,
+	resyncEvery time.Duration,
+	table *Table,
+	q queue.Counter,
+) error {
+	lggr = lggr.WithName("pkg.routing.StartConfigMapRoutingTableInformer")
+	factory := informers.NewSharedInformerFactoryWithOptions(
+		cl,
+		resyncEvery,
+		informers.WithNamespace(ns),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector(
+				"metadata.name",
+				ConfigMapRoutingTableName,
+			).String()
+		}),
+	)
+	informer := factory.Core().V1().ConfigMaps().Informer()
+
+	update := func(obj interface{}) {
+		cm, ok := obj.(*corev1.ConfigMap)
+		// the field selector should make sure that only the routing
+		// table ConfigMap comes through here. This check is just to
+		// be defensive.
+		if !ok || cm.Name != ConfigMapRoutingTableName {
+			return
+		}
+		newTable, err := FetchTableFromConfigMap(cm, q)
+		if err != nil {
+			// keep serving the previous table rather than
+			// dropping all the routes
+			lggr.Error(
+				err,
+				"failed decoding routing table ConfigMap, keeping the previous table",
+			)
+			return
+		}
+		logTableDiff(lggr, table.Replace(newTable))
+		if err := updateQueueFromTable(lggr, table, q); err != nil {
+			lggr.Error(
+				err,
+				"failed to update queue from table on ConfigMap change event",
+			)
+		}
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: update,
+		UpdateFunc: func(_, newObj interface{}) {
+			update(newObj)
+		},
+	})
+
+	factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		if ctx.Err() != nil {
+			return errors.Wrap(ctx.Err(), "context is done")
+		}
+		return errors.New("failed to sync the routing table ConfigMap informer")
+	}
+	<-ctx.Done()
+	return errors.Wrap(ctx.Err(), "context is done")
+}
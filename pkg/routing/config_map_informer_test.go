@@ -0,0 +1,84 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/queue"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestConfigMapRoutingTableInformer(t *testing.T) {
+	const ns = "testns"
+	r := require.New(t)
+	ctx, done := context.WithCancel(context.Background())
+	defer done()
+
+	initialTable := NewTable()
+	initialTable.AddTarget("host1", NewTarget("svc1", 8080, "depl1", 100))
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ConfigMapRoutingTableName,
+			Namespace: ns,
+		},
+		Data: map[string]string{},
+	}
+	r.NoError(SaveTableToConfigMap(initialTable, cm))
+	cl := fake.NewSimpleClientset(cm)
+
+	q := queue.NewFakeCounter()
+	table := NewTable()
+	grp, ctx := errgroup.WithContext(ctx)
+	grp.Go(func() error {
+		err := StartConfigMapRoutingTableInformer(
+			ctx,
+			logr.Discard(),
+			cl,
+			ns,
+			time.Minute,
+			table,
+			q,
+		)
+		// we purposefully cancel the context below,
+		// so we need to ignore that error.
+		if !errors.Is(err, context.Canceled) {
+			return err
+		}
+		return nil
+	})
+
+	hasHosts := func(hosts ...string) func() bool {
+		return func() bool {
+			for _, host := range hosts {
+				if _, err := table.Lookup(host); err != nil {
+					return false
+				}
+			}
+			counts, err := q.Current()
+			r.NoError(err)
+			return len(counts.Counts) == len(hosts)
+		}
+	}
+	r.Eventually(hasHosts("host1"), time.Second, 10*time.Millisecond)
+
+	// changes to the ConfigMap should show up without
+	// waiting for a resync
+	newTable := NewTable()
+	newTable.AddTarget("host2", NewTarget("svc2", 8080, "depl2", 100))
+	r.NoError(SaveTableToConfigMap(newTable, cm))
+	_, err := cl.CoreV1().ConfigMaps(ns).Update(ctx, cm, metav1.UpdateOptions{})
+	r.NoError(err)
+	r.Eventually(hasHosts("host2"), time.Second, 10*time.Millisecond)
+	_, err = table.Lookup("host1")
+	r.Error(err)
+
+	done()
+	r.NoError(grp.Wait())
+}
@@ -0,0 +1,233 @@
+package routing
+
+import (
+	"strings"
+
+	"github.com/kedacore/http-add-on/operator/api/v1alpha1"
+)
+
+const (
+	defaultRetryBackoffMS            = 100
+	defaultRetryBudgetPercent        = 20
+	defaultWaitingRoomRefreshSeconds = 5
+)
+
+// NewTargetFromHTTPScaledObject creates the routing table Target for
+// httpso. defaultTargetPendingRequests is used if httpso doesn't set
+// its own target pending requests
+func NewTargetFromHTTPScaledObject(
+	httpso *v1alpha1.HTTPScaledObject,
+	defaultTargetPendingRequests int32,
+) Target {
+	targetPendingReqs := httpso.Spec.TargetPendingRequests
+	if targetPendingReqs == 0 {
+		targetPendingReqs = defaultTargetPendingRequests
+	}
+	scaleTargetRef := httpso.Spec.ScaleTargetRef
+	ret := NewTarget(
+		scaleTargetRef.Service,
+		int(scaleTargetRef.Port),
+		scaleTargetRef.WorkloadName(),
+		targetPendingReqs,
+	)
+	ret.HTTPScaledObject = httpso.Name
+	if scaleTargetRef.IsExternal() {
+		// there's nothing to scale or wait on for backends
+		// outside the cluster
+		ret.URL = scaleTargetRef.URL
+		ret.Deployment = ""
+	}
+	ret.MinReplicas, _ = httpso.Spec.Replicas.Bounds()
+	if period := httpso.Spec.ScaledownPeriod; period != nil {
+		ret.ScaledownPeriodSeconds = *period
+	}
+	ret.APIVersion = scaleTargetRef.APIVersion
+	ret.Kind = scaleTargetRef.Kind
+	ret.RetryPolicy = retryPolicyFromSpec(httpso.Spec.RetryPolicy)
+	if limits := httpso.Spec.BodyLimits; limits != nil {
+		ret.MaxRequestBodyBytes = limits.MaxRequestBytes
+		ret.MaxResponseBodyBytes = limits.MaxResponseBytes
+	}
+	if canary := httpso.Spec.Canary; canary != nil {
+		ret.Canary = &CanaryTarget{
+			Service:    canary.ScaleTargetRef.Service,
+			Port:       int(canary.ScaleTargetRef.Port),
+			Deployment: canary.ScaleTargetRef.WorkloadName(),
+			APIVersion: canary.ScaleTargetRef.APIVersion,
+			Kind:       canary.ScaleTargetRef.Kind,
+			Weight:     int(canary.Weight),
+		}
+	}
+	if cache := httpso.Spec.ResponseCache; cache != nil {
+		ret.ResponseCache = &ResponseCachePolicy{
+			DefaultTTLSeconds: int(cache.DefaultTTLSeconds),
+		}
+	}
+	if transport := httpso.Spec.Transport; transport != nil {
+		ret.Transport = &TransportPolicy{
+			MaxIdleConns:           int(transport.MaxIdleConns),
+			IdleConnTimeoutSeconds: int(transport.IdleConnTimeoutSeconds),
+			DialTimeoutMS:          int(transport.DialTimeoutMS),
+		}
+	}
+	if pages := httpso.Spec.ErrorPages; pages != nil {
+		ret.ErrorPagesConfigMap = pages.ConfigMapName
+	}
+	if mirror := httpso.Spec.Mirror; mirror != nil && mirror.Percent > 0 {
+		ret.Mirror = &MirrorTarget{
+			Service: mirror.Service,
+			Port:    int(mirror.Port),
+			Percent: int(mirror.Percent),
+		}
+	}
+	ret.Auth = authPolicyFromSpec(httpso.Spec.Auth)
+	if probes := httpso.Spec.Probes; probes != nil &&
+		(len(probes.Paths) > 0 || len(probes.UserAgents) > 0) {
+		ret.Probes = &ProbePolicy{
+			Paths:      probes.Paths,
+			UserAgents: probes.UserAgents,
+		}
+	}
+	if cert := httpso.Spec.ClientCertificate; cert != nil && len(cert.AllowedSANs) > 0 {
+		ret.ClientCertificate = &ClientCertificatePolicy{
+			AllowedSANs: cert.AllowedSANs,
+		}
+	}
+	if smoothing := httpso.Spec.Smoothing; smoothing != nil &&
+		smoothing.FactorPercent > 0 && smoothing.FactorPercent < 100 {
+		ret.SmoothingFactorPercent = smoothing.FactorPercent
+	}
+	if tunnel := httpso.Spec.Tunnel; tunnel != nil {
+		ret.Tunnel = &TunnelPolicy{}
+		for _, port := range tunnel.Ports {
+			ret.Tunnel.Ports = append(ret.Tunnel.Ports, int(port))
+		}
+	}
+	if rewrite := httpso.Spec.RequestHeaders; rewrite != nil &&
+		(len(rewrite.Set) > 0 || len(rewrite.Remove) > 0 || rewrite.Host != "") {
+		ret.RequestHeaders = &HeaderRewrite{
+			Set:    rewrite.Set,
+			Remove: rewrite.Remove,
+			Host:   rewrite.Host,
+		}
+	}
+	if rewrite := httpso.Spec.ResponseHeaders; rewrite != nil &&
+		(len(rewrite.Set) > 0 || len(rewrite.Defaults) > 0 || len(rewrite.Remove) > 0) {
+		ret.ResponseHeaders = &ResponseHeaderRewrite{
+			Set:      rewrite.Set,
+			Defaults: rewrite.Defaults,
+			Remove:   rewrite.Remove,
+		}
+	}
+	for _, method := range httpso.Spec.CountedMethods {
+		ret.CountedMethods = append(ret.CountedMethods, strings.ToUpper(method))
+	}
+	if tls := httpso.Spec.UpstreamTLS; tls != nil && tls.Scheme != "http" {
+		ret.UpstreamTLS = &UpstreamTLSPolicy{
+			CASecretName:       tls.CASecretName,
+			ServerName:         tls.ServerName,
+			InsecureSkipVerify: tls.InsecureSkipVerify,
+		}
+	}
+	if timeouts := httpso.Spec.Timeouts; timeouts != nil &&
+		(timeouts.ResponseHeaderMS > 0 || timeouts.ResponseMS > 0) {
+		ret.Timeouts = &TimeoutPolicy{
+			ResponseHeaderMS: int(timeouts.ResponseHeaderMS),
+			ResponseMS:       int(timeouts.ResponseMS),
+		}
+	}
+	if filter := httpso.Spec.IPFilter; filter != nil &&
+		(len(filter.Allow) > 0 || len(filter.Deny) > 0) {
+		ret.IPFilter = &IPFilterPolicy{
+			Allow: filter.Allow,
+			Deny:  filter.Deny,
+		}
+	}
+	if room := httpso.Spec.WaitingRoom; room != nil {
+		ret.WaitingRoom = &WaitingRoomPolicy{
+			ThresholdMS:    int(room.ThresholdMS),
+			RefreshSeconds: int(room.RefreshSeconds),
+		}
+		if ret.WaitingRoom.RefreshSeconds <= 0 {
+			ret.WaitingRoom.RefreshSeconds = defaultWaitingRoomRefreshSeconds
+		}
+	}
+	if concurrency := httpso.Spec.Concurrency; concurrency != nil &&
+		concurrency.MaxInFlight > 0 {
+		ret.Concurrency = &ConcurrencyPolicy{
+			MaxInFlight:    int(concurrency.MaxInFlight),
+			QueueTimeoutMS: int(concurrency.QueueTimeoutMS),
+		}
+		if concurrency.MaxQueued != nil {
+			maxQueued := int(*concurrency.MaxQueued)
+			ret.Concurrency.MaxQueued = &maxQueued
+		}
+	}
+	// an invalid annotation doesn't pause anything. the operator
+	// reports it in httpso's status instead
+	ret.PausedReplicas, _ = httpso.PausedReplicas()
+	// nor does it pin a revision
+	if pin, _ := httpso.RevisionPin(); pin != nil {
+		ret.Revision = &RevisionPin{Label: pin.Label, Value: pin.Value}
+	}
+	// invalid fault annotations don't inject anything either
+	if fault, _ := httpso.FaultInjection(); fault != nil {
+		ret.Fault = &FaultPolicy{
+			DelayMS:      fault.Delay.Milliseconds(),
+			DelayPercent: int(fault.DelayPercent),
+			AbortStatus:  int(fault.AbortStatus),
+			AbortPercent: int(fault.AbortPercent),
+		}
+	}
+	return ret
+}
+
+// retryPolicyFromSpec converts the retry policy in an HTTPScaledObject
+// spec into the one stored in the routing table, filling in defaults
+// for unset fields. Returns nil if spec is nil or has no attempts
+func retryPolicyFromSpec(spec *v1alpha1.RetryPolicy) *RetryPolicy {
+	if spec == nil || spec.Attempts <= 0 {
+		return nil
+	}
+	ret := &RetryPolicy{
+		Attempts:      int(spec.Attempts),
+		BackoffMS:     int(spec.BackoffMS),
+		BudgetPercent: int(spec.BudgetPercent),
+	}
+	if ret.BackoffMS <= 0 {
+		ret.BackoffMS = defaultRetryBackoffMS
+	}
+	if ret.BudgetPercent <= 0 {
+		ret.BudgetPercent = defaultRetryBudgetPercent
+	}
+	return ret
+}
+
+// authPolicyFromSpec converts the auth in an HTTPScaledObject spec
+// into the policy stored in the routing table. Returns nil if spec is
+// nil or sets no method
+func authPolicyFromSpec(spec *v1alpha1.Auth) *AuthPolicy {
+	switch {
+	case spec == nil:
+		return nil
+	case spec.BearerToken != nil:
+		return &AuthPolicy{
+			Type:       AuthTypeBearerToken,
+			SecretName: spec.BearerToken.SecretName,
+		}
+	case spec.JWT != nil:
+		return &AuthPolicy{
+			Type:     AuthTypeJWT,
+			JWKSURL:  spec.JWT.JWKSURL,
+			Issuer:   spec.JWT.Issuer,
+			Audience: spec.JWT.Audience,
+		}
+	case spec.ForwardAuth != nil:
+		return &AuthPolicy{
+			Type:            AuthTypeForwardAuth,
+			URL:             spec.ForwardAuth.URL,
+			ResponseHeaders: spec.ForwardAuth.ResponseHeaders,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package routing
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/operator/api/v1alpha1"
+	"github.com/kedacore/http-add-on/pkg/queue"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// HTTPScaledObjectsResource is the resource that
+// StartHTTPScaledObjectRoutingTableInformer watches
+var HTTPScaledObjectsResource = v1alpha1.GroupVersion.WithResource("httpscaledobjects")
+
+// StartHTTPScaledObjectRoutingTableInformer starts a dynamic informer on
+// the HTTPScaledObjects in namespace ns that match labelSelector, or on
+// all of them if labelSelector is empty. Every time an HTTPScaledObject
+// is added, changed or deleted, it rebuilds the routing table from all
+// the HTTPScaledObjects it knows of, calls table.Replace(newTable), and
+// updates q so that it has exactly the hosts in the new table.
+//
+// This builds the routing table without going through the routing table
+// ConfigMap that the operator maintains. defaultTargetPendingRequests is
+// used for HTTPScaledObjects that don't set their own target.
+//
+// The informer also rebuilds the table every resyncEvery, as a fallback
+// in case an event was missed. It only returns when ctx is done, or if
+// the informer's cache couldn't be synced
+func StartHTTPScaledObjectRoutingTableInformer(
+	ctx context.Context,
+	lggr logr.Logger,
+	cl dynamic.Interface,
+	ns,
+	labelSelector string,
+	resyncEvery time.Duration,
+	defaultTargetPendingRequests int32,
+	table *Table,
+	q queue.Counter,
+) error {
+	lggr = lggr.WithName("pkg.routing.StartHTTPScaledObjectRoutingTableInformer")
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(
+		cl,
+		resyncEvery,
+		ns,
+		func(opts *metav1.ListOptions) {
+			opts.LabelSelector = labelSelector
+		},
+	)
+	informer := factory.ForResource(HTTPScaledObjectsResource).Informer()
+
+	rebuild := func(interface{}) {
+		newTable := tableFromHTTPScaledObjects(
+			lggr,
+			informer.GetStore().List(),
+			defaultTargetPendingRequests,
+		)
+		logTableDiff(lggr, table.Replace(newTable))
+		if err := updateQueueFromTable(lggr, table, q); err != nil {
+			lggr.Error(
+				err,
+				"failed to update queue from table on HTTPScaledObject change event",
+			)
+		}
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: rebuild,
+		UpdateFunc: func(_, newObj interface{}) {
+			rebuild(newObj)
+		},
+		DeleteFunc: rebuild,
+	})
+
+	factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		if ctx.Err() != nil {
+			return errors.Wrap(ctx.Err(), "context is done")
+		}
+		return errors.New("failed to sync the HTTPScaledObject informer")
+	}
+	// with no HTTPScaledObjects, no event would ever build the
+	// table, so build it once here to mark it as loaded
+	rebuild(nil)
+	<-ctx.Done()
+	return errors.Wrap(ctx.Err(), "context is done")
+}
+
+// tableFromHTTPScaledObjects builds a routing table from objs, which
+// should all be *unstructured.Unstructured HTTPScaledObjects. Hosts are
+// keyed by NamespacedHost. If more than one HTTPScaledObject in the same
+// namespace has the same host, the oldest one wins. HTTPScaledObjects
+// that are being deleted are left out
+func tableFromHTTPScaledObjects(
+	lggr logr.Logger,
+	objs []interface{},
+	defaultTargetPendingRequests int32,
+) *Table {
+	httpsos := make([]*v1alpha1.HTTPScaledObject, 0, len(objs))
+	for _, obj := range objs {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		httpso := &v1alpha1.HTTPScaledObject{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(
+			u.Object,
+			httpso,
+		); err != nil {
+			lggr.Error(
+				err,
+				"failed decoding HTTPScaledObject, leaving it out of the routing table",
+				"namespace",
+				u.GetNamespace(),
+				"name",
+				u.GetName(),
+			)
+			continue
+		}
+		if httpso.GetDeletionTimestamp() != nil ||
+			httpso.Spec.ScaleTargetRef == nil {
+			continue
+		}
+		httpsos = append(httpsos, httpso)
+	}
+	sort.Slice(httpsos, func(i, j int) bool {
+		iTime, jTime := httpsos[i].CreationTimestamp, httpsos[j].CreationTimestamp
+		if !iTime.Equal(&jTime) {
+			return iTime.Before(&jTime)
+		}
+		return httpsos[i].Namespace+"/"+httpsos[i].Name <
+			httpsos[j].Namespace+"/"+httpsos[j].Name
+	})
+
+	ret := NewTable()
+	for _, httpso := range httpsos {
+		target := NewTargetFromHTTPScaledObject(httpso, defaultTargetPendingRequests)
+		if err := ret.AddTarget(
+			NamespacedHost(httpso.Namespace, httpso.Spec.Host),
+			target,
+		); err != nil {
+			lggr.Error(
+				err,
+				"HTTPScaledObject has the same host as an older one, leaving it out of the routing table",
+				"namespace",
+				httpso.Namespace,
+				"name",
+				httpso.Name,
+			)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,938 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/operator/api/v1alpha1"
+	"github.com/kedacore/http-add-on/pkg/queue"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func TestRetryPolicyFromSpec(t *testing.T) {
+	r := require.New(t)
+	r.Nil(retryPolicyFromSpec(nil))
+	r.Nil(retryPolicyFromSpec(&v1alpha1.RetryPolicy{}))
+
+	// unset fields should get defaults
+	r.Equal(
+		&RetryPolicy{
+			Attempts:      3,
+			BackoffMS:     defaultRetryBackoffMS,
+			BudgetPercent: defaultRetryBudgetPercent,
+		},
+		retryPolicyFromSpec(&v1alpha1.RetryPolicy{Attempts: 3}),
+	)
+	r.Equal(
+		&RetryPolicy{
+			Attempts:      2,
+			BackoffMS:     50,
+			BudgetPercent: 10,
+		},
+		retryPolicyFromSpec(&v1alpha1.RetryPolicy{
+			Attempts:      2,
+			BackoffMS:     50,
+			BudgetPercent: 10,
+		}),
+	)
+}
+
+func newTestHTTPScaledObject(
+	ns,
+	name,
+	host string,
+	created time.Time,
+) *unstructured.Unstructured {
+	httpso := &v1alpha1.HTTPScaledObject{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1alpha1.GroupVersion.String(),
+			Kind:       "HTTPScaledObject",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         ns,
+			Name:              name,
+			CreationTimestamp: metav1.NewTime(created),
+		},
+		Spec: v1alpha1.HTTPScaledObjectSpec{
+			Host: host,
+			ScaleTargetRef: &v1alpha1.ScaleTargetRef{
+				Deployment: name,
+				Service:    name,
+				Port:       8080,
+			},
+		},
+	}
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(httpso)
+	if err != nil {
+		panic(err)
+	}
+	return &unstructured.Unstructured{Object: obj}
+}
+
+func TestNewTargetFromHTTPScaledObjectScaleTarget(t *testing.T) {
+	r := require.New(t)
+	httpso := &v1alpha1.HTTPScaledObject{
+		Spec: v1alpha1.HTTPScaledObjectSpec{
+			ScaleTargetRef: &v1alpha1.ScaleTargetRef{
+				Deployment: "testdepl",
+				Service:    "testsvc",
+				Port:       8080,
+			},
+		},
+	}
+	target := NewTargetFromHTTPScaledObject(httpso, 100)
+	r.Equal("testdepl", target.Deployment)
+	r.True(target.IsDeployment())
+
+	// name takes precedence over deployment, and any
+	// kind with the scale subresource is allowed
+	httpso.Spec.ScaleTargetRef.APIVersion = "argoproj.io/v1alpha1"
+	httpso.Spec.ScaleTargetRef.Kind = "Rollout"
+	httpso.Spec.ScaleTargetRef.Name = "testrollout"
+	target = NewTargetFromHTTPScaledObject(httpso, 100)
+	r.Equal("testrollout", target.Deployment)
+	r.Equal("argoproj.io/v1alpha1", target.APIVersion)
+	r.Equal("Rollout", target.Kind)
+	r.False(target.IsDeployment())
+}
+
+func TestNewTargetFromHTTPScaledObjectResponseCache(t *testing.T) {
+	r := require.New(t)
+	httpso := &v1alpha1.HTTPScaledObject{
+		Spec: v1alpha1.HTTPScaledObjectSpec{
+			ScaleTargetRef: &v1alpha1.ScaleTargetRef{
+				Deployment: "testdepl",
+				Service:    "testsvc",
+				Port:       8080,
+			},
+		},
+	}
+	r.Nil(NewTargetFromHTTPScaledObject(httpso, 100).ResponseCache)
+
+	httpso.Spec.ResponseCache = &v1alpha1.ResponseCache{DefaultTTLSeconds: 30}
+	r.Equal(
+		&ResponseCachePolicy{DefaultTTLSeconds: 30},
+		NewTargetFromHTTPScaledObject(httpso, 100).ResponseCache,
+	)
+}
+
+func TestNewTargetFromHTTPScaledObjectTransport(t *testing.T) {
+	r := require.New(t)
+	httpso := &v1alpha1.HTTPScaledObject{
+		Spec: v1alpha1.HTTPScaledObjectSpec{
+			ScaleTargetRef: &v1alpha1.ScaleTargetRef{
+				Deployment: "testdepl",
+				Service:    "testsvc",
+				Port:       8080,
+			},
+		},
+	}
+	r.Nil(NewTargetFromHTTPScaledObject(httpso, 100).Transport)
+
+	httpso.Spec.Transport = &v1alpha1.Transport{
+		MaxIdleConns:           50,
+		IdleConnTimeoutSeconds: 30,
+		DialTimeoutMS:          250,
+	}
+	r.Equal(
+		&TransportPolicy{
+			MaxIdleConns:           50,
+			IdleConnTimeoutSeconds: 30,
+			DialTimeoutMS:          250,
+		},
+		NewTargetFromHTTPScaledObject(httpso, 100).Transport,
+	)
+}
+
+func TestNewTargetFromHTTPScaledObjectErrorPages(t *testing.T) {
+	r := require.New(t)
+	httpso := &v1alpha1.HTTPScaledObject{
+		Spec: v1alpha1.HTTPScaledObjectSpec{
+			ScaleTargetRef: &v1alpha1.ScaleTargetRef{
+				Deployment: "testdepl",
+				Service:    "testsvc",
+				Port:       8080,
+			},
+		},
+	}
+	r.Empty(NewTargetFromHTTPScaledObject(httpso, 100).ErrorPagesConfigMap)
+
+	httpso.Spec.ErrorPages = &v1alpha1.ErrorPages{ConfigMapName: "testpages"}
+	r.Equal("testpages", NewTargetFromHTTPScaledObject(httpso, 100).ErrorPagesConfigMap)
+}
+
+func TestNewTargetFromHTTPScaledObjectAuth(t *testing.T) {
+	r := require.New(t)
+	httpso := &v1alpha1.HTTPScaledObject{
+		Spec: v1alpha1.HTTPScaledObjectSpec{
+			ScaleTargetRef: &v1alpha1.ScaleTargetRef{
+				Deployment: "testdepl",
+				Service:    "testsvc",
+				Port:       8080,
+			},
+		},
+	}
+	r.Nil(NewTargetFromHTTPScaledObject(httpso, 100).Auth)
+
+	// auth without a method doesn't authenticate anything
+	httpso.Spec.Auth = &v1alpha1.Auth{}
+	r.Nil(NewTargetFromHTTPScaledObject(httpso, 100).Auth)
+
+	httpso.Spec.Auth.ForwardAuth = &v1alpha1.ForwardAuth{
+		URL:             "http://auth.default:8080/check",
+		ResponseHeaders: []string{"X-User"},
+	}
+	r.Equal(&AuthPolicy{
+		Type:            AuthTypeForwardAuth,
+		URL:             "http://auth.default:8080/check",
+		ResponseHeaders: []string{"X-User"},
+	}, NewTargetFromHTTPScaledObject(httpso, 100).Auth)
+
+	// the bearer token takes precedence over the other methods
+	httpso.Spec.Auth.BearerToken = &v1alpha1.BearerTokenAuth{SecretName: "testtoken"}
+	r.Equal(&AuthPolicy{
+		Type:       AuthTypeBearerToken,
+		SecretName: "testtoken",
+	}, NewTargetFromHTTPScaledObject(httpso, 100).Auth)
+}
+
+func TestNewTargetFromHTTPScaledObjectMirror(t *testing.T) {
+	r := require.New(t)
+	httpso := &v1alpha1.HTTPScaledObject{
+		Spec: v1alpha1.HTTPScaledObjectSpec{
+			ScaleTargetRef: &v1alpha1.ScaleTargetRef{
+				Deployment: "testdepl",
+				Service:    "testsvc",
+				Port:       8080,
+			},
+		},
+	}
+	r.Nil(NewTargetFromHTTPScaledObject(httpso, 100).Mirror)
+
+	// a mirror that gets no requests isn't a mirror
+	httpso.Spec.Mirror = &v1alpha1.Mirror{Service: "shadowsvc", Port: 9090}
+	r.Nil(NewTargetFromHTTPScaledObject(httpso, 100).Mirror)
+
+	httpso.Spec.Mirror.Percent = 10
+	mirror := NewTargetFromHTTPScaledObject(httpso, 100).Mirror
+	r.Equal(&MirrorTarget{Service: "shadowsvc", Port: 9090, Percent: 10}, mirror)
+	u, err := mirror.ServiceURL()
+	r.NoError(err)
+	r.Equal("http://shadowsvc:9090", u.String())
+}
+
+func TestNewTargetFromHTTPScaledObjectConcurrency(t *testing.T) {
+	r := require.New(t)
+	httpso := &v1alpha1.HTTPScaledObject{
+		Spec: v1alpha1.HTTPScaledObjectSpec{
+			ScaleTargetRef: &v1alpha1.ScaleTargetRef{
+				Deployment: "testdepl",
+				Service:    "testsvc",
+				Port:       8080,
+			},
+		},
+	}
+	r.Nil(NewTargetFromHTTPScaledObject(httpso, 100).Concurrency)
+
+	// a limit of 0 doesn't limit anything
+	httpso.Spec.Concurrency = &v1alpha1.Concurrency{}
+	r.Nil(NewTargetFromHTTPScaledObject(httpso, 100).Concurrency)
+
+	httpso.Spec.Concurrency.MaxInFlight = 4
+	httpso.Spec.Concurrency.QueueTimeoutMS = 500
+	r.Equal(&ConcurrencyPolicy{
+		MaxInFlight:    4,
+		QueueTimeoutMS: 500,
+	}, NewTargetFromHTTPScaledObject(httpso, 100).Concurrency)
+
+	maxQueued := int32(0)
+	httpso.Spec.Concurrency.MaxQueued = &maxQueued
+	policy := NewTargetFromHTTPScaledObject(httpso, 100).Concurrency
+	r.NotNil(policy.MaxQueued)
+	r.Equal(0, *policy.MaxQueued)
+}
+
+func TestNewTargetFromHTTPScaledObjectWaitingRoom(t *testing.T) {
+	r := require.New(t)
+	httpso := &v1alpha1.HTTPScaledObject{
+		Spec: v1alpha1.HTTPScaledObjectSpec{
+			ScaleTargetRef: &v1alpha1.ScaleTargetRef{
+				Deployment: "testdepl",
+				Service:    "testsvc",
+				Port:       8080,
+			},
+		},
+	}
+	r.Nil(NewTargetFromHTTPScaledObject(httpso, 100).WaitingRoom)
+
+	// the page reloads every 5 seconds by default
+	httpso.Spec.WaitingRoom = &v1alpha1.WaitingRoom{ThresholdMS: 1500}
+	r.Equal(&WaitingRoomPolicy{
+		ThresholdMS:    1500,
+		RefreshSeconds: 5,
+	}, NewTargetFromHTTPScaledObject(httpso, 100).WaitingRoom)
+
+	httpso.Spec.WaitingRoom.RefreshSeconds = 2
+	r.Equal(2, NewTargetFromHTTPScaledObject(httpso, 100).WaitingRoom.RefreshSeconds)
+}
+
+func TestNewTargetFromHTTPScaledObjectWarm(t *testing.T) {
+	r := require.New(t)
+	httpso := &v1alpha1.HTTPScaledObject{
+		Spec: v1alpha1.HTTPScaledObjectSpec{
+			ScaleTargetRef: &v1alpha1.ScaleTargetRef{
+				Deployment: "testdepl",
+				Service:    "testsvc",
+				Port:       8080,
+			},
+		},
+	}
+	target := NewTargetFromHTTPScaledObject(httpso, 100)
+	r.Equal(int32(0), target.MinReplicas)
+	r.False(target.Warm())
+
+	httpso.Spec.Replicas.Min = 2
+	target = NewTargetFromHTTPScaledObject(httpso, 100)
+	r.Equal(int32(2), target.MinReplicas)
+	r.True(target.Warm())
+
+	// a workload that's paused at zero replicas is cold, whatever its
+	// minimum, and one that's paused at some is warm
+	httpso.SetAnnotations(map[string]string{
+		v1alpha1.PausedReplicasAnnotation: "0",
+	})
+	target = NewTargetFromHTTPScaledObject(httpso, 100)
+	r.False(target.Warm())
+	httpso.Spec.Replicas.Min = 0
+	httpso.SetAnnotations(map[string]string{
+		v1alpha1.PausedReplicasAnnotation: "1",
+	})
+	target = NewTargetFromHTTPScaledObject(httpso, 100)
+	r.True(target.Warm())
+}
+
+func TestNewTargetFromHTTPScaledObjectProbes(t *testing.T) {
+	r := require.New(t)
+	httpso := &v1alpha1.HTTPScaledObject{
+		Spec: v1alpha1.HTTPScaledObjectSpec{
+			ScaleTargetRef: &v1alpha1.ScaleTargetRef{
+				Deployment: "testdepl",
+				Service:    "testsvc",
+				Port:       8080,
+			},
+		},
+	}
+	r.Nil(NewTargetFromHTTPScaledObject(httpso, 100).Probes)
+
+	// an empty section has nothing to match
+	httpso.Spec.Probes = &v1alpha1.Probes{}
+	r.Nil(NewTargetFromHTTPScaledObject(httpso, 100).Probes)
+
+	httpso.Spec.Probes.Paths = []string{"/healthz", "/status/*"}
+	httpso.Spec.Probes.UserAgents = []string{"kube-probe/"}
+	probes := NewTargetFromHTTPScaledObject(httpso, 100).Probes
+	r.Equal(&ProbePolicy{
+		Paths:      []string{"/healthz", "/status/*"},
+		UserAgents: []string{"kube-probe/"},
+	}, probes)
+
+	r.True(probes.Matches("/healthz", "curl/7.79.1"))
+	r.False(probes.Matches("/healthz/deep", "curl/7.79.1"))
+	r.True(probes.Matches("/status/ready", ""))
+	r.True(probes.Matches("/", "kube-probe/1.22"))
+	r.False(probes.Matches("/", "Mozilla/5.0"))
+	r.False(probes.Matches("/", ""))
+
+	var noProbes *ProbePolicy
+	r.False(noProbes.Matches("/healthz", "kube-probe/1.22"))
+}
+
+func TestNewTargetFromHTTPScaledObjectScaledownPeriod(t *testing.T) {
+	r := require.New(t)
+	httpso := &v1alpha1.HTTPScaledObject{
+		ObjectMeta: metav1.ObjectMeta{Name: "testapp"},
+		Spec: v1alpha1.HTTPScaledObjectSpec{
+			ScaleTargetRef: &v1alpha1.ScaleTargetRef{
+				Deployment: "testdepl",
+				Service:    "testsvc",
+				Port:       8080,
+			},
+		},
+	}
+	target := NewTargetFromHTTPScaledObject(httpso, 100)
+	r.Equal(int32(0), target.ScaledownPeriodSeconds)
+	r.Equal("testapp", target.HTTPScaledObject)
+
+	period := int32(300)
+	httpso.Spec.ScaledownPeriod = &period
+	r.Equal(int32(300), NewTargetFromHTTPScaledObject(httpso, 100).ScaledownPeriodSeconds)
+}
+
+func TestNewTargetFromHTTPScaledObjectExternal(t *testing.T) {
+	r := require.New(t)
+	httpso := &v1alpha1.HTTPScaledObject{
+		Spec: v1alpha1.HTTPScaledObjectSpec{
+			ScaleTargetRef: &v1alpha1.ScaleTargetRef{
+				Name: "testdepl",
+				URL:  "https://legacy.example.com",
+			},
+		},
+	}
+	// the workload is ignored for URLs
+	target := NewTargetFromHTTPScaledObject(httpso, 100)
+	r.Equal("https://legacy.example.com", target.URL)
+	r.False(target.HasWorkload())
+}
+
+func TestNewTargetFromHTTPScaledObjectFault(t *testing.T) {
+	r := require.New(t)
+	httpso := &v1alpha1.HTTPScaledObject{
+		Spec: v1alpha1.HTTPScaledObjectSpec{
+			ScaleTargetRef: &v1alpha1.ScaleTargetRef{
+				Deployment: "testdepl",
+				Service:    "testsvc",
+				Port:       8080,
+			},
+		},
+	}
+	r.Nil(NewTargetFromHTTPScaledObject(httpso, 100).Fault)
+
+	// percentages default to 100
+	httpso.SetAnnotations(map[string]string{
+		v1alpha1.FaultDelayAnnotation:        "1.5s",
+		v1alpha1.FaultAbortStatusAnnotation:  "503",
+		v1alpha1.FaultAbortPercentAnnotation: "10",
+	})
+	r.Equal(&FaultPolicy{
+		DelayMS:      1500,
+		DelayPercent: 100,
+		AbortStatus:  503,
+		AbortPercent: 10,
+	}, NewTargetFromHTTPScaledObject(httpso, 100).Fault)
+
+	// a percentage without a fault doesn't inject anything
+	httpso.SetAnnotations(map[string]string{
+		v1alpha1.FaultDelayPercentAnnotation: "50",
+	})
+	r.Nil(NewTargetFromHTTPScaledObject(httpso, 100).Fault)
+
+	// invalid annotations don't inject anything
+	for annotation, val := range map[string]string{
+		v1alpha1.FaultDelayAnnotation:        "soon",
+		v1alpha1.FaultAbortStatusAnnotation:  "200",
+		v1alpha1.FaultAbortPercentAnnotation: "101",
+	} {
+		httpso.SetAnnotations(map[string]string{
+			v1alpha1.FaultDelayAnnotation:       "1s",
+			v1alpha1.FaultAbortStatusAnnotation: "500",
+			annotation:                          val,
+		})
+		_, err := httpso.FaultInjection()
+		r.Error(err)
+		r.Nil(NewTargetFromHTTPScaledObject(httpso, 100).Fault)
+	}
+}
+
+func TestNewTargetFromHTTPScaledObjectPaused(t *testing.T) {
+	r := require.New(t)
+	httpso := &v1alpha1.HTTPScaledObject{
+		Spec: v1alpha1.HTTPScaledObjectSpec{
+			ScaleTargetRef: &v1alpha1.ScaleTargetRef{
+				Deployment: "testdepl",
+				Service:    "testsvc",
+				Port:       8080,
+			},
+		},
+	}
+	r.Nil(NewTargetFromHTTPScaledObject(httpso, 100).PausedReplicas)
+
+	httpso.SetAnnotations(map[string]string{
+		v1alpha1.PausedReplicasAnnotation: "2",
+	})
+	paused := NewTargetFromHTTPScaledObject(httpso, 100).PausedReplicas
+	r.NotNil(paused)
+	r.Equal(int32(2), *paused)
+
+	// invalid annotations don't pause anything
+	for _, val := range []string{"-1", "two", ""} {
+		httpso.SetAnnotations(map[string]string{
+			v1alpha1.PausedReplicasAnnotation: val,
+		})
+		_, err := httpso.PausedReplicas()
+		r.Error(err)
+		r.Nil(NewTargetFromHTTPScaledObject(httpso, 100).PausedReplicas)
+	}
+}
+
+func TestNewTargetFromHTTPScaledObjectRevision(t *testing.T) {
+	r := require.New(t)
+	httpso := &v1alpha1.HTTPScaledObject{
+		Spec: v1alpha1.HTTPScaledObjectSpec{
+			ScaleTargetRef: &v1alpha1.ScaleTargetRef{
+				Deployment: "testdepl",
+				Service:    "testsvc",
+				Port:       8080,
+			},
+		},
+	}
+	r.Nil(NewTargetFromHTTPScaledObject(httpso, 100).Revision)
+
+	// the label defaults to the app version
+	httpso.SetAnnotations(map[string]string{
+		v1alpha1.RevisionAnnotation: "blue",
+	})
+	r.Equal(&RevisionPin{
+		Label: v1alpha1.DefaultRevisionLabel,
+		Value: "blue",
+	}, NewTargetFromHTTPScaledObject(httpso, 100).Revision)
+
+	httpso.SetAnnotations(map[string]string{
+		v1alpha1.RevisionAnnotation:      "green",
+		v1alpha1.RevisionLabelAnnotation: "example.com/color",
+	})
+	pin := NewTargetFromHTTPScaledObject(httpso, 100).Revision
+	r.Equal(&RevisionPin{Label: "example.com/color", Value: "green"}, pin)
+	r.True(pin.Matches(map[string]string{"example.com/color": "green", "app": "x"}))
+	r.False(pin.Matches(map[string]string{"example.com/color": "blue"}))
+	r.False(pin.Matches(nil))
+
+	// a label without a revision doesn't pin anything
+	httpso.SetAnnotations(map[string]string{
+		v1alpha1.RevisionLabelAnnotation: "example.com/color",
+	})
+	r.Nil(NewTargetFromHTTPScaledObject(httpso, 100).Revision)
+
+	// invalid annotations don't pin anything
+	for _, annotations := range []map[string]string{
+		{v1alpha1.RevisionAnnotation: ""},
+		{v1alpha1.RevisionAnnotation: "not a label value"},
+		{v1alpha1.RevisionAnnotation: "blue", v1alpha1.RevisionLabelAnnotation: "bad label/"},
+	} {
+		httpso.SetAnnotations(annotations)
+		_, err := httpso.RevisionPin()
+		r.Error(err, annotations)
+		r.Nil(NewTargetFromHTTPScaledObject(httpso, 100).Revision)
+	}
+}
+
+func TestNewTargetFromHTTPScaledObjectCanary(t *testing.T) {
+	r := require.New(t)
+	httpso := &v1alpha1.HTTPScaledObject{
+		Spec: v1alpha1.HTTPScaledObjectSpec{
+			ScaleTargetRef: &v1alpha1.ScaleTargetRef{
+				Deployment: "testdepl",
+				Service:    "testsvc",
+				Port:       8080,
+			},
+		},
+	}
+	r.Nil(NewTargetFromHTTPScaledObject(httpso, 100).Canary)
+
+	httpso.Spec.Canary = &v1alpha1.Canary{
+		ScaleTargetRef: v1alpha1.ScaleTargetRef{
+			Name:    "testdepl-canary",
+			Service: "testsvc-canary",
+			Port:    8081,
+		},
+		Weight: 25,
+	}
+	r.Equal(
+		&CanaryTarget{
+			Service:    "testsvc-canary",
+			Port:       8081,
+			Deployment: "testdepl-canary",
+			Weight:     25,
+		},
+		NewTargetFromHTTPScaledObject(httpso, 100).Canary,
+	)
+}
+
+func TestTableFromHTTPScaledObjects(t *testing.T) {
+	r := require.New(t)
+	now := time.Now().Truncate(time.Second)
+	table := tableFromHTTPScaledObjects(
+		logr.Discard(),
+		[]interface{}{
+			newTestHTTPScaledObject("testns", "newer", "host1", now),
+			newTestHTTPScaledObject("testns", "older", "host1", now.Add(-time.Minute)),
+			newTestHTTPScaledObject("testns", "other", "host2", now),
+		},
+		123,
+	)
+	// the oldest HTTPScaledObject wins a host conflict
+	target, err := table.Lookup(NamespacedHost("testns", "host1"))
+	r.NoError(err)
+	r.Equal("older", target.Deployment)
+	r.Equal(int32(123), target.TargetPendingRequests)
+	target, err = table.Lookup(NamespacedHost("testns", "host2"))
+	r.NoError(err)
+	r.Equal("other", target.Deployment)
+}
+
+func TestHTTPScaledObjectRoutingTableInformer(t *testing.T) {
+	const ns = "testns"
+	r := require.New(t)
+	ctx, done := context.WithCancel(context.Background())
+	defer done()
+
+	scheme := runtime.NewScheme()
+	cl := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		scheme,
+		map[schema.GroupVersionResource]string{
+			HTTPScaledObjectsResource: "HTTPScaledObjectList",
+		},
+		newTestHTTPScaledObject(ns, "app1", "host1", time.Now()),
+	)
+	q := queue.NewFakeCounter()
+	table := NewNamespacedTable(ns)
+	grp, ctx := errgroup.WithContext(ctx)
+	grp.Go(func() error {
+		err := StartHTTPScaledObjectRoutingTableInformer(
+			ctx,
+			logr.Discard(),
+			cl,
+			ns,
+			"",
+			time.Minute,
+			100,
+			table,
+			q,
+		)
+		// we purposefully cancel the context below,
+		// so we need to ignore that error.
+		if !errors.Is(err, context.Canceled) {
+			return err
+		}
+		return nil
+	})
+
+	hasHosts := func(hosts ...string) func() bool {
+		return func() bool {
+			for _, host := range hosts {
+				if _, err := table.Lookup(host); err != nil {
+					return false
+				}
+			}
+			counts, err := q.Current()
+			r.NoError(err)
+			return len(counts.Counts) == len(hosts)
+		}
+	}
+	r.Eventually(hasHosts("host1"), time.Second, 10*time.Millisecond)
+
+	// new and deleted HTTPScaledObjects should show
+	// up in the table right away
+	httpsoClient := cl.Resource(HTTPScaledObjectsResource).Namespace(ns)
+	_, err := httpsoClient.Create(
+		ctx,
+		newTestHTTPScaledObject(ns, "app2", "host2", time.Now()),
+		metav1.CreateOptions{},
+	)
+	r.NoError(err)
+	r.Eventually(hasHosts("host1", "host2"), time.Second, 10*time.Millisecond)
+	r.NoError(httpsoClient.Delete(ctx, "app1", metav1.DeleteOptions{}))
+	r.Eventually(hasHosts("host2"), time.Second, 10*time.Millisecond)
+
+	done()
+	r.NoError(grp.Wait())
+}
+
+// an informer with a label selector should only route the hosts of
+// the HTTPScaledObjects that match it
+func TestHTTPScaledObjectRoutingTableInformerLabelSelector(t *testing.T) {
+	const ns = "testns"
+	r := require.New(t)
+	ctx, done := context.WithCancel(context.Background())
+	defer done()
+
+	teamA := newTestHTTPScaledObject(ns, "app1", "host1", time.Now())
+	teamA.SetLabels(map[string]string{"team": "a"})
+	teamB := newTestHTTPScaledObject(ns, "app2", "host2", time.Now())
+	teamB.SetLabels(map[string]string{"team": "b"})
+	cl := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			HTTPScaledObjectsResource: "HTTPScaledObjectList",
+		},
+		teamA,
+		teamB,
+	)
+	table := NewNamespacedTable(ns)
+	grp, ctx := errgroup.WithContext(ctx)
+	grp.Go(func() error {
+		err := StartHTTPScaledObjectRoutingTableInformer(
+			ctx,
+			logr.Discard(),
+			cl,
+			ns,
+			"team=a",
+			time.Minute,
+			100,
+			table,
+			queue.NewFakeCounter(),
+		)
+		if !errors.Is(err, context.Canceled) {
+			return err
+		}
+		return nil
+	})
+	r.Eventually(table.HasSynced, time.Second, 10*time.Millisecond)
+	_, err := table.Lookup("host1")
+	r.NoError(err)
+	_, err = table.Lookup("host2")
+	r.Error(err)
+
+	done()
+	r.NoError(grp.Wait())
+}
+
+func TestNewTargetFromHTTPScaledObjectClientCertificate(t *testing.T) {
+	r := require.New(t)
+	httpso := &v1alpha1.HTTPScaledObject{
+		Spec: v1alpha1.HTTPScaledObjectSpec{
+			ScaleTargetRef: &v1alpha1.ScaleTargetRef{
+				Deployment: "testdepl",
+				Service:    "testsvc",
+				Port:       8080,
+			},
+		},
+	}
+	r.Nil(NewTargetFromHTTPScaledObject(httpso, 100).ClientCertificate)
+
+	// a section without SANs would reject every client, so it's
+	// left out like one that isn't there
+	httpso.Spec.ClientCertificate = &v1alpha1.ClientCertificate{}
+	r.Nil(NewTargetFromHTTPScaledObject(httpso, 100).ClientCertificate)
+
+	httpso.Spec.ClientCertificate.AllowedSANs = []string{
+		"spiffe://cluster.local/ns/default/sa/frontend",
+	}
+	r.Equal(&ClientCertificatePolicy{
+		AllowedSANs: []string{"spiffe://cluster.local/ns/default/sa/frontend"},
+	}, NewTargetFromHTTPScaledObject(httpso, 100).ClientCertificate)
+}
+
+func TestNewTargetFromHTTPScaledObjectSmoothing(t *testing.T) {
+	r := require.New(t)
+	httpso := &v1alpha1.HTTPScaledObject{
+		Spec: v1alpha1.HTTPScaledObjectSpec{
+			ScaleTargetRef: &v1alpha1.ScaleTargetRef{
+				Deployment: "testdepl",
+				Service:    "testsvc",
+				Port:       8080,
+			},
+		},
+	}
+	r.Zero(NewTargetFromHTTPScaledObject(httpso, 100).SmoothingFactorPercent)
+
+	httpso.Spec.Smoothing = &v1alpha1.Smoothing{FactorPercent: 30}
+	r.Equal(int32(30), NewTargetFromHTTPScaledObject(httpso, 100).SmoothingFactorPercent)
+
+	// the newest count getting all of the weight is no smoothing
+	httpso.Spec.Smoothing.FactorPercent = 100
+	r.Zero(NewTargetFromHTTPScaledObject(httpso, 100).SmoothingFactorPercent)
+}
+
+func TestNewTargetFromHTTPScaledObjectTunnel(t *testing.T) {
+	r := require.New(t)
+	httpso := &v1alpha1.HTTPScaledObject{
+		Spec: v1alpha1.HTTPScaledObjectSpec{
+			ScaleTargetRef: &v1alpha1.ScaleTargetRef{
+				Deployment: "testdepl",
+				Service:    "testsvc",
+				Port:       8080,
+			},
+		},
+	}
+	target := NewTargetFromHTTPScaledObject(httpso, 100)
+	r.Nil(target.Tunnel)
+	r.False(target.Tunnel.Allows(8080, target.Port))
+
+	// no ports means only the target's port
+	httpso.Spec.Tunnel = &v1alpha1.Tunnel{}
+	target = NewTargetFromHTTPScaledObject(httpso, 100)
+	r.True(target.Tunnel.Allows(8080, target.Port))
+	r.False(target.Tunnel.Allows(5432, target.Port))
+
+	httpso.Spec.Tunnel.Ports = []int32{5432}
+	target = NewTargetFromHTTPScaledObject(httpso, 100)
+	r.Equal(&TunnelPolicy{Ports: []int{5432}}, target.Tunnel)
+	r.True(target.Tunnel.Allows(5432, target.Port))
+	r.False(target.Tunnel.Allows(8080, target.Port))
+}
+
+func TestNewTargetFromHTTPScaledObjectRequestHeaders(t *testing.T) {
+	r := require.New(t)
+	httpso := &v1alpha1.HTTPScaledObject{
+		Spec: v1alpha1.HTTPScaledObjectSpec{
+			ScaleTargetRef: &v1alpha1.ScaleTargetRef{
+				Deployment: "testdepl",
+				Service:    "testsvc",
+				Port:       8080,
+			},
+		},
+	}
+	r.Nil(NewTargetFromHTTPScaledObject(httpso, 100).RequestHeaders)
+
+	// an empty rewrite doesn't rewrite anything
+	httpso.Spec.RequestHeaders = &v1alpha1.RequestHeaders{}
+	r.Nil(NewTargetFromHTTPScaledObject(httpso, 100).RequestHeaders)
+
+	httpso.Spec.RequestHeaders = &v1alpha1.RequestHeaders{
+		Set:    map[string]string{"X-Env": "prod"},
+		Remove: []string{"Cookie"},
+		Host:   "testsvc.testns.svc.cluster.local",
+	}
+	r.Equal(&HeaderRewrite{
+		Set:    map[string]string{"X-Env": "prod"},
+		Remove: []string{"Cookie"},
+		Host:   "testsvc.testns.svc.cluster.local",
+	}, NewTargetFromHTTPScaledObject(httpso, 100).RequestHeaders)
+}
+
+func TestNewTargetFromHTTPScaledObjectResponseHeaders(t *testing.T) {
+	r := require.New(t)
+	httpso := &v1alpha1.HTTPScaledObject{
+		Spec: v1alpha1.HTTPScaledObjectSpec{
+			ScaleTargetRef: &v1alpha1.ScaleTargetRef{
+				Deployment: "testdepl",
+				Service:    "testsvc",
+				Port:       8080,
+			},
+		},
+	}
+	r.Nil(NewTargetFromHTTPScaledObject(httpso, 100).ResponseHeaders)
+
+	// an empty rewrite doesn't rewrite anything
+	httpso.Spec.ResponseHeaders = &v1alpha1.ResponseHeaders{}
+	r.Nil(NewTargetFromHTTPScaledObject(httpso, 100).ResponseHeaders)
+
+	httpso.Spec.ResponseHeaders = &v1alpha1.ResponseHeaders{
+		Set:      map[string]string{"X-Served-By": "keda"},
+		Defaults: map[string]string{"Access-Control-Allow-Origin": "*"},
+		Remove:   []string{"Server"},
+	}
+	r.Equal(&ResponseHeaderRewrite{
+		Set:      map[string]string{"X-Served-By": "keda"},
+		Defaults: map[string]string{"Access-Control-Allow-Origin": "*"},
+		Remove:   []string{"Server"},
+	}, NewTargetFromHTTPScaledObject(httpso, 100).ResponseHeaders)
+}
+
+func TestNewTargetFromHTTPScaledObjectTimeouts(t *testing.T) {
+	r := require.New(t)
+	httpso := &v1alpha1.HTTPScaledObject{
+		Spec: v1alpha1.HTTPScaledObjectSpec{
+			ScaleTargetRef: &v1alpha1.ScaleTargetRef{
+				Deployment: "testdepl",
+				Service:    "testsvc",
+				Port:       8080,
+			},
+		},
+	}
+	r.Nil(NewTargetFromHTTPScaledObject(httpso, 100).Timeouts)
+
+	httpso.Spec.Timeouts = &v1alpha1.Timeouts{}
+	r.Nil(NewTargetFromHTTPScaledObject(httpso, 100).Timeouts)
+
+	httpso.Spec.Timeouts = &v1alpha1.Timeouts{ResponseHeaderMS: 500, ResponseMS: 2000}
+	r.Equal(
+		&TimeoutPolicy{ResponseHeaderMS: 500, ResponseMS: 2000},
+		NewTargetFromHTTPScaledObject(httpso, 100).Timeouts,
+	)
+}
+
+func TestNewTargetFromHTTPScaledObjectIPFilter(t *testing.T) {
+	r := require.New(t)
+	httpso := &v1alpha1.HTTPScaledObject{
+		Spec: v1alpha1.HTTPScaledObjectSpec{
+			ScaleTargetRef: &v1alpha1.ScaleTargetRef{
+				Deployment: "testdepl",
+				Service:    "testsvc",
+				Port:       8080,
+			},
+		},
+	}
+	r.Nil(NewTargetFromHTTPScaledObject(httpso, 100).IPFilter)
+
+	// an empty filter lets every client in
+	httpso.Spec.IPFilter = &v1alpha1.IPFilter{}
+	r.Nil(NewTargetFromHTTPScaledObject(httpso, 100).IPFilter)
+
+	httpso.Spec.IPFilter = &v1alpha1.IPFilter{
+		Allow: []string{"10.0.0.0/8"},
+		Deny:  []string{"10.1.2.3"},
+	}
+	r.Equal(&IPFilterPolicy{
+		Allow: []string{"10.0.0.0/8"},
+		Deny:  []string{"10.1.2.3"},
+	}, NewTargetFromHTTPScaledObject(httpso, 100).IPFilter)
+}
+
+func TestNewTargetFromHTTPScaledObjectCountedMethods(t *testing.T) {
+	r := require.New(t)
+	httpso := &v1alpha1.HTTPScaledObject{
+		Spec: v1alpha1.HTTPScaledObjectSpec{
+			ScaleTargetRef: &v1alpha1.ScaleTargetRef{
+				Deployment: "testdepl",
+				Service:    "testsvc",
+				Port:       8080,
+			},
+		},
+	}
+	target := NewTargetFromHTTPScaledObject(httpso, 100)
+	r.Nil(target.CountedMethods)
+	r.True(target.CountsMethod("GET"))
+
+	httpso.Spec.CountedMethods = []string{"post", "PUT"}
+	target = NewTargetFromHTTPScaledObject(httpso, 100)
+	r.Equal([]string{"POST", "PUT"}, target.CountedMethods)
+	r.True(target.CountsMethod("POST"))
+	r.True(target.CountsMethod("PUT"))
+	r.False(target.CountsMethod("GET"))
+}
+
+func TestNewTargetFromHTTPScaledObjectUpstreamTLS(t *testing.T) {
+	r := require.New(t)
+	httpso := &v1alpha1.HTTPScaledObject{
+		Spec: v1alpha1.HTTPScaledObjectSpec{
+			ScaleTargetRef: &v1alpha1.ScaleTargetRef{
+				Deployment: "testdepl",
+				Service:    "testsvc",
+				Port:       8443,
+			},
+		},
+	}
+	target := NewTargetFromHTTPScaledObject(httpso, 100)
+	r.Nil(target.UpstreamTLS)
+	u, err := target.ServiceURL()
+	r.NoError(err)
+	r.Equal("http://testsvc:8443", u.String())
+
+	httpso.Spec.UpstreamTLS = &v1alpha1.UpstreamTLS{
+		Scheme:       "https",
+		CASecretName: "backend-ca",
+		ServerName:   "backend.example.com",
+	}
+	target = NewTargetFromHTTPScaledObject(httpso, 100)
+	r.Equal(&UpstreamTLSPolicy{
+		CASecretName: "backend-ca",
+		ServerName:   "backend.example.com",
+	}, target.UpstreamTLS)
+	u, err = target.ServiceURL()
+	r.NoError(err)
+	r.Equal("https://testsvc:8443", u.String())
+
+	// the http scheme turns TLS off, whatever else is set
+	httpso.Spec.UpstreamTLS.Scheme = "http"
+	r.Nil(NewTargetFromHTTPScaledObject(httpso, 100).UpstreamTLS)
+}
@@ -2,20 +2,521 @@ package routing
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/url"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/kedacore/http-add-on/pkg/queue"
 )
 
 var ErrTargetNotFound = errors.New("Target not found")
 
 type Target struct {
-	Service               string `json:"service"`
-	Port                  int    `json:"port"`
-	Deployment            string `json:"deployment"`
-	TargetPendingRequests int32  `json:"target"`
+	Service string `json:"service"`
+	Port    int    `json:"port"`
+	// Deployment is the name of the workload that serves the Target.
+	// Despite its name, it may be of any kind that APIVersion and
+	// Kind specify
+	Deployment            string       `json:"deployment"`
+	TargetPendingRequests int32        `json:"target"`
+	RetryPolicy           *RetryPolicy `json:"retryPolicy,omitempty"`
+	// APIVersion is the API version of the workload that serves the
+	// Target. Empty means apps/v1
+	APIVersion string `json:"apiVersion,omitempty"`
+	// Kind is the kind of the workload that serves the Target. It
+	// must implement the scale subresource. Empty means Deployment
+	Kind string `json:"kind,omitempty"`
+	// MaxRequestBodyBytes is the maximum size of a request body to
+	// the Target. 0 means the interceptor's default applies
+	MaxRequestBodyBytes int64 `json:"maxRequestBodyBytes,omitempty"`
+	// MaxResponseBodyBytes is the maximum size of a response body
+	// from the Target. 0 means the interceptor's default applies
+	MaxResponseBodyBytes int64 `json:"maxResponseBodyBytes,omitempty"`
+	// ResponseCache is how the interceptor caches responses from the
+	// Target. nil means they aren't cached
+	ResponseCache *ResponseCachePolicy `json:"responseCache,omitempty"`
+	// Canary is a second workload that gets a share of the requests
+	// to the Target. nil means all requests go to the Target
+	Canary *CanaryTarget `json:"canary,omitempty"`
+	// Transport tunes the connections that the interceptor keeps open
+	// to the Target. nil means the interceptor's defaults apply
+	Transport *TransportPolicy `json:"transport,omitempty"`
+	// PausedReplicas is the number of replicas that the Target's
+	// workload, and its canary's, are pinned at while autoscaling is
+	// paused. nil means autoscaling isn't paused
+	PausedReplicas *int32 `json:"pausedReplicas,omitempty"`
+	// ErrorPagesConfigMap is the name of the ConfigMap with the
+	// responses that the interceptor sends when it can't forward a
+	// request to the Target. Empty means the interceptor's defaults
+	ErrorPagesConfigMap string `json:"errorPagesConfigMap,omitempty"`
+	// Mirror is a service that gets copies of a share of the requests
+	// to the Target. nil means requests aren't mirrored
+	Mirror *MirrorTarget `json:"mirror,omitempty"`
+	// HTTPScaledObject is the name of the HTTPScaledObject that the
+	// Target was created from. It's empty in tables from operators
+	// that predate it
+	HTTPScaledObject string `json:"httpScaledObject,omitempty"`
+	// ScaledownPeriodSeconds is how long the Target must go without
+	// requests before it's reported as inactive. 0 means it's inactive
+	// as soon as it has no requests
+	ScaledownPeriodSeconds int32 `json:"scaledownPeriodSeconds,omitempty"`
+	// URL is the URL of a backend outside the cluster that requests to
+	// the Target go to, instead of Service and Port. Empty means they
+	// go to Service
+	URL string `json:"url,omitempty"`
+	// Fault is the latency and the errors that the interceptor injects
+	// into requests to the Target, if it has fault injection enabled.
+	// nil means no faults are injected
+	Fault *FaultPolicy `json:"fault,omitempty"`
+	// Auth is how the interceptor authenticates requests to the
+	// Target before forwarding them. nil means they aren't
+	// authenticated
+	Auth *AuthPolicy `json:"auth,omitempty"`
+	// Concurrency limits the requests that the interceptor forwards
+	// to the Target at once. nil means they aren't limited
+	Concurrency *ConcurrencyPolicy `json:"concurrency,omitempty"`
+	// WaitingRoom is the page that browsers get when the Target takes
+	// too long to start. nil means they wait for it
+	WaitingRoom *WaitingRoomPolicy `json:"waitingRoom,omitempty"`
+	// MinReplicas is the number of replicas that the Target's
+	// workload, and its canary's, never scale below. 0 means they
+	// scale to zero
+	MinReplicas int32 `json:"minReplicas,omitempty"`
+	// Probes are the requests to the Target that are forwarded but
+	// not counted. nil means every request is counted
+	Probes *ProbePolicy `json:"probes,omitempty"`
+	// ClientCertificate restricts the Target to clients with a
+	// verified certificate. nil means any client may send requests
+	ClientCertificate *ClientCertificatePolicy `json:"clientCertificate,omitempty"`
+	// SmoothingFactorPercent is the weight, in percent, of the newest
+	// count in the moving average that the scaler reports for the
+	// Target. 0 means the scaler reports the raw counts
+	SmoothingFactorPercent int32 `json:"smoothingFactorPercent,omitempty"`
+	// Tunnel lets clients open raw TCP tunnels to the Target with HTTP
+	// CONNECT. nil means CONNECT requests are refused
+	Tunnel *TunnelPolicy `json:"tunnel,omitempty"`
+	// RequestHeaders rewrites the requests that the interceptor
+	// forwards to the Target. nil means they're forwarded with the
+	// client's headers
+	RequestHeaders *HeaderRewrite `json:"requestHeaders,omitempty"`
+	// Timeouts is the latency budget of the requests to the Target.
+	// nil means the interceptor's defaults apply
+	Timeouts *TimeoutPolicy `json:"timeouts,omitempty"`
+	// IPFilter restricts the Target to clients with some IP
+	// addresses. nil means any client may send requests
+	IPFilter *IPFilterPolicy `json:"ipFilter,omitempty"`
+	// ResponseHeaders rewrites the responses that the interceptor
+	// sends back for the Target. nil means they're sent as they are
+	ResponseHeaders *ResponseHeaderRewrite `json:"responseHeaders,omitempty"`
+	// CountedMethods are the HTTP methods of the requests to the
+	// Target that count toward scaling. Empty means every request
+	// counts
+	CountedMethods []string `json:"countedMethods,omitempty"`
+	// Revision pins the Target to the pods of its Service that are of
+	// one revision. nil means requests go to any of the Service's pods
+	Revision *RevisionPin `json:"revision,omitempty"`
+	// UpstreamTLS is how the interceptor connects to the Target over
+	// TLS. nil means requests are forwarded to it over plain HTTP
+	UpstreamTLS *UpstreamTLSPolicy `json:"upstreamTLS,omitempty"`
+}
+
+// UpstreamTLSPolicy is how the interceptor verifies the certificate of
+// a Target that only serves HTTPS
+type UpstreamTLSPolicy struct {
+	// CASecretName is the name of the Secret, in the interceptor's
+	// namespace, with the CA bundle that the Target's certificate is
+	// verified against. Empty means the system roots are used
+	CASecretName string `json:"caSecretName,omitempty"`
+	// ServerName is the name that's sent with SNI and that the
+	// Target's certificate is verified against. Empty means the
+	// Service's name
+	ServerName string `json:"serverName,omitempty"`
+	// InsecureSkipVerify is true if the Target's certificate isn't
+	// verified at all
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+}
+
+// RevisionPin is the revision of a Target's workload that requests
+// are routed to: the pods of its Service whose Label label has the
+// value Value
+type RevisionPin struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
+}
+
+// Matches returns true if a pod with podLabels is of the revision that
+// p pins. A nil p matches every pod
+func (p *RevisionPin) Matches(podLabels map[string]string) bool {
+	if p == nil {
+		return true
+	}
+	val, ok := podLabels[p.Label]
+	return ok && val == p.Value
+}
+
+// IPFilterPolicy is the IP addresses of the clients that may, and may
+// not, send requests to a Target. Each entry is a CIDR, like
+// 10.0.0.0/8, or a single IP address
+type IPFilterPolicy struct {
+	// Allow are the clients that may send requests. Empty means
+	// every client that Deny doesn't reject
+	Allow []string `json:"allow,omitempty"`
+	// Deny are the clients that may not send requests,
+	// even if they're in Allow
+	Deny []string `json:"deny,omitempty"`
+}
+
+// Allows returns true if the client at ip may send requests to the
+// Target. A nil IPFilterPolicy allows every client. Returns false and
+// an error if any entry in p isn't a valid CIDR or IP address, so that
+// a typo doesn't let clients in
+func (p *IPFilterPolicy) Allows(ip net.IP) (bool, error) {
+	if p == nil {
+		return true, nil
+	}
+	denied, err := matchesAny(p.Deny, ip)
+	if err != nil || denied {
+		return false, err
+	}
+	if len(p.Allow) == 0 {
+		return true, nil
+	}
+	return matchesAny(p.Allow, ip)
+}
+
+// matchesAny returns true if ip is in any of the CIDRs or IP addresses
+// in entries. Returns an error if any of them is invalid
+func matchesAny(entries []string, ip net.IP) (bool, error) {
+	ret := false
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			entryIP := net.ParseIP(entry)
+			if entryIP == nil {
+				return false, fmt.Errorf("invalid IP address %q", entry)
+			}
+			ret = ret || entryIP.Equal(ip)
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return false, fmt.Errorf("invalid CIDR %q", entry)
+		}
+		ret = ret || (ip != nil && ipNet.Contains(ip))
+	}
+	return ret, nil
+}
+
+// TimeoutPolicy is how long a Target's backend may take to respond to
+// each request. 0 means the interceptor's default
+type TimeoutPolicy struct {
+	// ResponseHeaderMS is the maximum time, in milliseconds, to
+	// wait for the backend's response headers
+	ResponseHeaderMS int `json:"responseHeaderMS,omitempty"`
+	// ResponseMS is the maximum time, in milliseconds, for the
+	// backend's whole response, including retries and the body.
+	// 0 means there's no limit
+	ResponseMS int `json:"responseMS,omitempty"`
+}
+
+// HeaderRewrite is the headers that the interceptor sets on and
+// removes from the requests that it forwards to a Target, after
+// they're routed
+type HeaderRewrite struct {
+	// Set are the headers to set, replacing any
+	// values that the client sent
+	Set map[string]string `json:"set,omitempty"`
+	// Remove are the headers to remove. They're
+	// removed before the ones in Set are set
+	Remove []string `json:"remove,omitempty"`
+	// Host is the Host header to send. Empty means
+	// the Target's Service name and port
+	Host string `json:"host,omitempty"`
+}
+
+// ResponseHeaderRewrite is the headers that the interceptor sets on
+// and removes from the responses that it sends back for a Target
+type ResponseHeaderRewrite struct {
+	// Set are the headers to set, replacing any
+	// values that the backend sent
+	Set map[string]string `json:"set,omitempty"`
+	// Defaults are the headers to set if the
+	// response doesn't have them already
+	Defaults map[string]string `json:"defaults,omitempty"`
+	// Remove are the headers to remove. They're removed
+	// before the ones in Set and Defaults are set
+	Remove []string `json:"remove,omitempty"`
+}
+
+// TunnelPolicy is the ports of a Target's Service that clients may
+// open raw TCP tunnels to
+type TunnelPolicy struct {
+	// Ports are the ports that clients may tunnel to. Empty means
+	// only the Target's port
+	Ports []int `json:"ports,omitempty"`
+}
+
+// Allows returns true if clients may tunnel to port, given that the
+// Target's port is targetPort. A nil TunnelPolicy allows nothing
+func (p *TunnelPolicy) Allows(port, targetPort int) bool {
+	if p == nil {
+		return false
+	}
+	if len(p.Ports) == 0 {
+		return port == targetPort
+	}
+	for _, allowed := range p.Ports {
+		if port == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientCertificatePolicy is the certificates that clients must
+// present, over mutual TLS, to send requests to a Target
+type ClientCertificatePolicy struct {
+	// AllowedSANs are the DNS names, URIs and email addresses that
+	// client certificates may have. A certificate needs only one
+	AllowedSANs []string `json:"allowedSANs"`
+}
+
+// ProbePolicy picks out the requests to a Target, like health checks
+// from load balancers, that don't count toward scaling
+type ProbePolicy struct {
+	// Paths are the paths of probe requests. A path that ends with *
+	// matches every path that starts with the rest of it
+	Paths []string `json:"paths,omitempty"`
+	// UserAgents are prefixes of the User-Agent headers of probe
+	// requests
+	UserAgents []string `json:"userAgents,omitempty"`
+}
+
+// Matches returns true if a request for path, with the given
+// User-Agent header, is a probe. A nil ProbePolicy matches nothing
+func (p *ProbePolicy) Matches(path, userAgent string) bool {
+	if p == nil {
+		return false
+	}
+	for _, probePath := range p.Paths {
+		if prefix := strings.TrimSuffix(probePath, "*"); prefix != probePath {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		} else if path == probePath {
+			return true
+		}
+	}
+	if userAgent == "" {
+		return false
+	}
+	for _, prefix := range p.UserAgents {
+		if prefix != "" && strings.HasPrefix(userAgent, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// WaitingRoomPolicy is when browsers get a page that reloads itself,
+// instead of waiting for a Target that's starting, and how often the
+// page reloads
+type WaitingRoomPolicy struct {
+	// ThresholdMS is how long, in milliseconds, a request waits
+	// before it gets the page
+	ThresholdMS int `json:"thresholdMS"`
+	// RefreshSeconds is how long the page waits before it reloads
+	RefreshSeconds int `json:"refreshSeconds"`
+}
+
+// ConcurrencyPolicy limits the requests that an interceptor forwards
+// to a Target at once. Requests past the limit wait in a queue until
+// one in flight finishes
+type ConcurrencyPolicy struct {
+	// MaxInFlight is the maximum number of requests in flight
+	MaxInFlight int `json:"maxInFlight"`
+	// MaxQueued is the maximum number of requests that wait. nil means
+	// the interceptor's default applies
+	MaxQueued *int `json:"maxQueued,omitempty"`
+	// QueueTimeoutMS is how long, in milliseconds, requests wait
+	// before they're rejected. 0 means the interceptor's default
+	QueueTimeoutMS int `json:"queueTimeoutMS,omitempty"`
+}
+
+// the types of AuthPolicy
+const (
+	AuthTypeBearerToken = "bearerToken"
+	AuthTypeJWT         = "jwt"
+	AuthTypeForwardAuth = "forwardAuth"
+)
+
+// AuthPolicy is how the interceptor authenticates requests to a
+// Target. Type says which of its other fields apply
+type AuthPolicy struct {
+	Type string `json:"type"`
+	// SecretName is the name of the Secret, in the interceptor's
+	// namespace, with the bearer token under its token key
+	SecretName string `json:"secretName,omitempty"`
+	// JWKSURL is the URL of the keys that JWTs may be signed with
+	JWKSURL string `json:"jwksURL,omitempty"`
+	// Issuer and Audience are the iss and aud claims that JWTs must
+	// have. Empty means any
+	Issuer   string `json:"issuer,omitempty"`
+	Audience string `json:"audience,omitempty"`
+	// URL is the URL of the forward auth service
+	URL string `json:"url,omitempty"`
+	// ResponseHeaders are the headers of the forward auth service's
+	// response that are copied into the request
+	ResponseHeaders []string `json:"responseHeaders,omitempty"`
+}
+
+// FaultPolicy is the latency and the errors that the interceptor
+// injects into requests to a Target, to test how its clients cope
+// with cold starts and interceptor hiccups
+type FaultPolicy struct {
+	// DelayMS is how long, in milliseconds, to hold requests before
+	// forwarding them
+	DelayMS int64 `json:"delayMS,omitempty"`
+	// DelayPercent is the percentage of requests that are delayed
+	DelayPercent int `json:"delayPercent,omitempty"`
+	// AbortStatus is the status of the response that aborted requests
+	// get, instead of being forwarded
+	AbortStatus int `json:"abortStatus,omitempty"`
+	// AbortPercent is the percentage of requests that are aborted
+	AbortPercent int `json:"abortPercent,omitempty"`
+}
+
+// MirrorTarget is a service that gets copies of Percent percent of the
+// requests to a Target. Its responses are discarded
+type MirrorTarget struct {
+	Service string `json:"service"`
+	Port    int    `json:"port"`
+	Percent int    `json:"percent"`
+}
+
+// ServiceURL returns the URL of m's service
+func (m *MirrorTarget) ServiceURL() (*url.URL, error) {
+	return url.Parse(fmt.Sprintf("http://%s:%d", m.Service, m.Port))
+}
+
+// CanaryTarget is a workload that serves Weight percent of the requests
+// to a Target, while the Target's own workload serves the rest
+type CanaryTarget struct {
+	Service    string `json:"service"`
+	Port       int    `json:"port"`
+	Deployment string `json:"deployment"`
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind,omitempty"`
+	Weight     int    `json:"weight"`
+}
+
+// ForCanary returns a copy of t that routes to, and waits on, t's
+// canary workload instead of its own. Returns t if it has no canary
+func (t Target) ForCanary() Target {
+	if t.Canary == nil {
+		return t
+	}
+	canary := *t.Canary
+	t.Service = canary.Service
+	t.Port = canary.Port
+	t.Deployment = canary.Deployment
+	t.APIVersion = canary.APIVersion
+	t.Kind = canary.Kind
+	t.Canary = nil
+	// the canary's pods are behind a Service of their own, which
+	// the revision doesn't apply to
+	t.Revision = nil
+	return t
+}
+
+// CanaryQueueKey returns the key under which the pending requests to
+// host's canary are counted, so that the canary can be scaled apart
+// from the host's main workload. Hosts can't contain a '#', so the
+// key never collides with a real host
+func CanaryQueueKey(host string) string {
+	return host + "#canary"
+}
+
+// IsDeployment returns true if the workload that serves t is an
+// apps/v1 Deployment
+func (t *Target) IsDeployment() bool {
+	return (t.APIVersion == "" || t.APIVersion == "apps/v1") &&
+		(t.Kind == "" || t.Kind == "Deployment")
+}
+
+// HasWorkload returns true if t is served by a workload that the
+// interceptor should wait on. Targets that route to a URL or to an
+// ExternalName service have nothing to wait on or scale
+func (t *Target) HasWorkload() bool {
+	return t.Deployment != ""
+}
+
+// Warm returns true if t's workload always has replicas, so that
+// requests to it don't have to wait for a cold start. A workload
+// whose autoscaling is paused only has replicas if it's paused at
+// some
+func (t *Target) Warm() bool {
+	if t.PausedReplicas != nil {
+		return *t.PausedReplicas > 0
+	}
+	return t.MinReplicas > 0
+}
+
+// CountsMethod returns true if requests to t with the given HTTP
+// method count toward scaling
+func (t *Target) CountsMethod(method string) bool {
+	if len(t.CountedMethods) == 0 {
+		return true
+	}
+	for _, counted := range t.CountedMethods {
+		if strings.EqualFold(counted, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// RetryPolicy describes how the interceptor should retry idempotent
+// requests to a Target that fail before the backend sends a response
+type RetryPolicy struct {
+	// Attempts is the maximum number of retries for a single request
+	Attempts int `json:"attempts"`
+	// BackoffMS is the time, in milliseconds, to wait before the
+	// first retry. It doubles after every subsequent retry
+	BackoffMS int `json:"backoffMS"`
+	// BudgetPercent is the maximum percentage of in-flight requests
+	// to the Target that may be retries at any given time
+	BudgetPercent int `json:"budgetPercent"`
+}
+
+// ResponseCachePolicy describes how the interceptor caches responses
+// to GET and HEAD requests to a Target
+type ResponseCachePolicy struct {
+	// DefaultTTLSeconds is how long to cache responses that don't say
+	// how long they may be cached. 0 means they aren't cached
+	DefaultTTLSeconds int `json:"defaultTTLSeconds"`
+}
+
+// TransportPolicy tunes the pool of keep-alive connections that the
+// interceptor keeps open to a Target. 0 means the interceptor's default
+type TransportPolicy struct {
+	// MaxIdleConns is the maximum number of idle connections to keep
+	// open to the Target
+	MaxIdleConns int `json:"maxIdleConns,omitempty"`
+	// IdleConnTimeoutSeconds is the time after which an idle
+	// connection to the Target is closed
+	IdleConnTimeoutSeconds int `json:"idleConnTimeoutSeconds,omitempty"`
+	// DialTimeoutMS is the maximum time, in milliseconds, to establish
+	// a new connection to the Target, including retries
+	DialTimeoutMS int `json:"dialTimeoutMS,omitempty"`
 }
 
 // NewTarget creates a new Target from the given parameters.
@@ -33,8 +534,17 @@ func NewTarget(
 	}
 }
 
+// ServiceURL returns the URL that requests to t are forwarded to,
+// which is t.URL if it's set and the URL of t's service otherwise
 func (t *Target) ServiceURL() (*url.URL, error) {
-	urlStr := fmt.Sprintf("http://%s:%d", t.Service, t.Port)
+	if t.URL != "" {
+		return url.Parse(t.URL)
+	}
+	scheme := "http"
+	if t.UpstreamTLS != nil {
+		scheme = "https"
+	}
+	urlStr := fmt.Sprintf("%s://%s:%d", scheme, t.Service, t.Port)
 	u, err := url.Parse(urlStr)
 	if err != nil {
 		return nil, err
@@ -50,15 +560,39 @@ type Table struct {
 	fmt.Stringer
 	m map[string]Target
 	l *sync.RWMutex
+	// loaded is true once the table was replaced with one
+	// from its source, like the routing table ConfigMap
+	loaded bool
+	// namespace is the namespace that the table is scoped to, or
+	// empty if it holds targets from any namespace
+	namespace string
+	// version is the version of the table's contents (see Version)
+	version string
+	// updated is when version last changed
+	updated time.Time
 }
 
 func NewTable() *Table {
+	m := make(map[string]Target)
 	return &Table{
-		m: make(map[string]Target),
-		l: new(sync.RWMutex),
+		m:       m,
+		l:       new(sync.RWMutex),
+		version: tableVersion(m),
 	}
 }
 
+// NewNamespacedTable creates a new Table that only holds the targets in
+// namespace ns, keyed by plain host, like the interceptors in ns need.
+// When it's replaced with a table keyed by NamespacedHost, the targets
+// in other namespaces are left out and the namespace is stripped from
+// the keys. Plain host keys, from operators that predate namespaced
+// keys, are kept as they are
+func NewNamespacedTable(ns string) *Table {
+	ret := NewTable()
+	ret.namespace = ns
+	return ret
+}
+
 func (t *Table) String() string {
 	t.l.RLock()
 	defer t.l.RUnlock()
@@ -81,7 +615,11 @@ func (t *Table) UnmarshalJSON(data []byte) error {
 	defer t.l.Unlock()
 	t.m = map[string]Target{}
 	b := bytes.NewBuffer(data)
-	return json.NewDecoder(b).Decode(&t.m)
+	if err := json.NewDecoder(b).Decode(&t.m); err != nil {
+		return err
+	}
+	t.setVersion(tableVersion(t.m))
+	return nil
 }
 
 func (t *Table) Lookup(host string) (Target, error) {
@@ -94,6 +632,17 @@ func (t *Table) Lookup(host string) (Target, error) {
 	return ret, nil
 }
 
+// LookupInNamespace returns the target for host in namespace ns from t,
+// whose keys were created by NamespacedHost. If there's none, it falls
+// back to the target keyed by the plain host, which is how tables from
+// operators that predate namespaced keys hold them
+func LookupInNamespace(t TableReader, ns, host string) (Target, error) {
+	if ret, err := t.Lookup(NamespacedHost(ns, host)); err == nil {
+		return ret, nil
+	}
+	return t.Lookup(host)
+}
+
 // AddTarget registers target for host in the routing table t
 // if it didn't already exist.
 //
@@ -112,6 +661,7 @@ func (t *Table) AddTarget(
 		)
 	}
 	t.m[host] = target
+	t.setVersion(tableVersion(t.m))
 	return nil
 }
 
@@ -125,16 +675,180 @@ func (t *Table) RemoveTarget(host string) error {
 		return fmt.Errorf("host %s did not exist in the routing table", host)
 	}
 	delete(t.m, host)
+	t.setVersion(tableVersion(t.m))
 	return nil
 }
 
-// Replace replaces t's routing table with newTable's.
+// TableDiff is what changed in a Table when it was replaced
+type TableDiff struct {
+	// Added are the hosts that weren't in the table before, sorted
+	Added []string
+	// Removed are the hosts that aren't in the table anymore, sorted
+	Removed []string
+	// Changed are the hosts whose targets changed, sorted
+	Changed []string
+	// OldVersion and NewVersion are the versions of the
+	// table before and after it was replaced
+	OldVersion string
+	NewVersion string
+}
+
+// Empty returns true if no hosts were added, removed or changed
+func (d TableDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// Replace replaces t's routing table with newTable's, and returns what
+// changed. Lookups see either the whole old table or the whole new
+// one, never a mix of the two.
+//
+// t takes newTable's version, which is computed before t is scoped to
+// its namespace, so that every table loaded from the same source has
+// the same version.
 //
 // This function is concurrency safe for t, but not for newTable.
 // The caller must ensure that no other goroutine is writing to
 // newTable at the time at which they call this function.
-func (t *Table) Replace(newTable *Table) {
+func (t *Table) Replace(newTable *Table) TableDiff {
+	newM := newTable.m
+	if t.namespace != "" {
+		newM = newTable.scopedTo(t.namespace)
+	}
+	newVersion := tableVersion(newTable.m)
+
 	t.l.Lock()
-	defer t.l.Unlock()
-	t.m = newTable.m
+	oldM := t.m
+	diff := TableDiff{OldVersion: t.version, NewVersion: newVersion}
+	t.m = newM
+	t.setVersion(newVersion)
+	t.loaded = true
+	t.l.Unlock()
+
+	for host, target := range newM {
+		oldTarget, ok := oldM[host]
+		if !ok {
+			diff.Added = append(diff.Added, host)
+		} else if !reflect.DeepEqual(oldTarget, target) {
+			diff.Changed = append(diff.Changed, host)
+		}
+	}
+	for host := range oldM {
+		if _, ok := newM[host]; !ok {
+			diff.Removed = append(diff.Removed, host)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	return diff
+}
+
+// Version returns the version of t's contents, which is a hash of
+// them, and when it last changed. Tables with the same targets have
+// the same version, so operators can compare the versions of the
+// tables in the operator, scaler and interceptors to confirm that a
+// change to the routing table reached all of them
+func (t *Table) Version() (string, time.Time) {
+	t.l.RLock()
+	defer t.l.RUnlock()
+	return t.version, t.updated
+}
+
+// setVersion sets t's version. t.l must be held for writing
+func (t *Table) setVersion(version string) {
+	if version != t.version {
+		t.version = version
+		t.updated = time.Now()
+	}
+}
+
+// tableVersion returns the version of a table with the targets in m.
+// encoding/json sorts map keys, so the same targets always hash
+// the same
+func tableVersion(m map[string]Target) string {
+	b, err := json.Marshal(m)
+	if err != nil {
+		// Targets only hold types that encode
+		// without errors, so this can't happen
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8])
+}
+
+// scopedTo returns t's targets in namespace ns, keyed by plain host,
+// along with the targets that already had plain host keys
+func (t *Table) scopedTo(ns string) map[string]Target {
+	ret := make(map[string]Target, len(t.m))
+	for key, target := range t.m {
+		keyNS, host, ok := SplitNamespacedHost(key)
+		if ok && keyNS != ns {
+			continue
+		}
+		// a namespaced key wins over a plain
+		// one for the same host
+		if _, exists := ret[host]; exists && !ok {
+			continue
+		}
+		ret[host] = target
+	}
+	return ret
+}
+
+// NamespacedHost returns the key for host in a Table that holds hosts
+// from many namespaces, like the operator's. Stamping the namespace
+// into the key keeps the same host in two namespaces from colliding.
+// It's the same key that the host's counts are kept under in the
+// scaler
+func NamespacedHost(ns, host string) string {
+	return queue.NamespacedKey(ns, host)
+}
+
+// SplitNamespacedHost splits a key that NamespacedHost created into its
+// namespace and host. It returns false if key is a plain host
+func SplitNamespacedHost(key string) (string, string, bool) {
+	return queue.SplitNamespacedKey(key)
+}
+
+// ForNamespace returns a new Table with only the targets in t whose
+// keys were created by NamespacedHost with ns. The keys stay
+// namespaced, so the scaler can tell the same host in two namespaces
+// apart. Interceptors scope the table to their own namespace when they
+// load it (see NewNamespacedTable)
+func (t *Table) ForNamespace(ns string) *Table {
+	t.l.RLock()
+	defer t.l.RUnlock()
+	prefix := NamespacedHost(ns, "")
+	ret := NewTable()
+	for key, target := range t.m {
+		if strings.HasPrefix(key, prefix) {
+			ret.m[key] = target
+		}
+	}
+	ret.setVersion(tableVersion(ret.m))
+	return ret
+}
+
+// queueKeys returns the keys that requests to the targets in t are
+// counted under in a queue.Counter
+func (t *Table) queueKeys() map[string]struct{} {
+	t.l.RLock()
+	defer t.l.RUnlock()
+	ret := map[string]struct{}{}
+	for host, target := range t.m {
+		ret[host] = struct{}{}
+		if target.Canary != nil {
+			ret[CanaryQueueKey(host)] = struct{}{}
+		}
+	}
+	return ret
+}
+
+// HasSynced returns true if t was ever replaced with a whole new
+// table, which is how t is loaded from its source. Tables that were
+// only changed with AddTarget or RemoveTarget aren't synced
+func (t *Table) HasSynced() bool {
+	t.l.RLock()
+	defer t.l.RUnlock()
+	return t.loaded
 }
@@ -14,6 +14,17 @@ const (
 	routingFetchPath = "/routing_table"
 )
 
+// VersionHeader is the response header that holds the version of the
+// routing table (see Table.Version) in responses that return one
+const VersionHeader = "X-KEDA-HTTP-Routing-Table-Version"
+
+// SetVersionHeader sets the VersionHeader in w's headers to
+// table's version
+func SetVersionHeader(w http.ResponseWriter, table *Table) {
+	version, _ := table.Version()
+	w.Header().Set(VersionHeader, version)
+}
+
 // AddFetchRoute adds a route to mux that fetches the current state of table,
 // encodes it as JSON, and returns it to the HTTP client
 func AddFetchRoute(
@@ -55,6 +66,7 @@ func AddPingRoute(
 			))
 			return
 		}
+		SetVersionHeader(w, table)
 		w.WriteHeader(200)
 		if err := json.NewEncoder(w).Encode(table); err != nil {
 			w.WriteHeader(500)
@@ -70,6 +82,7 @@ func newTableHandler(
 ) http.Handler {
 	lggr = lggr.WithName("pkg.routing.TableHandler")
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetVersionHeader(w, table)
 		err := json.NewEncoder(w).Encode(table)
 		if err != nil {
 			w.WriteHeader(500)
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"testing"
 
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/queue"
 	"github.com/stretchr/testify/require"
 )
 
@@ -76,7 +78,162 @@ func TestTableReplace(t *testing.T) {
 
 	// replace the second table with the first and ensure that the tables
 	// are now equal
+	r.False(tbl2.HasSynced())
 	tbl2.Replace(tbl1)
 
-	r.Equal(tbl1, tbl2)
+	r.Equal(tbl1.m, tbl2.m)
+	// only the replaced table counts as loaded
+	r.True(tbl2.HasSynced())
+	r.False(tbl1.HasSynced())
+}
+
+func TestTableForNamespace(t *testing.T) {
+	r := require.New(t)
+	const host = "testhost"
+	tgt1 := Target{Service: "svc1", Port: 8080, Deployment: "depl1"}
+	tgt2 := Target{Service: "svc2", Port: 8080, Deployment: "depl2"}
+	tbl := NewTable()
+	// the same host in two namespaces doesn't collide
+	r.NoError(tbl.AddTarget(NamespacedHost("ns1", host), tgt1))
+	r.NoError(tbl.AddTarget(NamespacedHost("ns2", host), tgt2))
+	r.NoError(tbl.AddTarget(NamespacedHost("ns10", "otherhost"), tgt2))
+
+	ns1Tbl := tbl.ForNamespace("ns1")
+	ret, err := ns1Tbl.Lookup(NamespacedHost("ns1", host))
+	r.NoError(err)
+	r.Equal(tgt1, ret)
+	r.Equal(1, len(ns1Tbl.m))
+
+	ret, err = tbl.ForNamespace("ns2").Lookup(NamespacedHost("ns2", host))
+	r.NoError(err)
+	r.Equal(tgt2, ret)
+
+	r.Equal(0, len(tbl.ForNamespace("ns3").m))
+}
+
+func TestNamespacedTable(t *testing.T) {
+	r := require.New(t)
+	tgt1 := Target{Service: "svc1", Port: 8080, Deployment: "depl1"}
+	tgt2 := Target{Service: "svc2", Port: 8080, Deployment: "depl2"}
+	tgt3 := Target{Service: "svc3", Port: 8080, Deployment: "depl3"}
+	tbl := NewTable()
+	r.NoError(tbl.AddTarget(NamespacedHost("ns1", "internal.svc"), tgt1))
+	r.NoError(tbl.AddTarget(NamespacedHost("ns2", "internal.svc"), tgt2))
+	// a plain key, from an operator that predates namespaced keys
+	r.NoError(tbl.AddTarget("legacy.svc", tgt3))
+
+	ns1Tbl := NewNamespacedTable("ns1")
+	ns1Tbl.Replace(tbl)
+	ret, err := ns1Tbl.Lookup("internal.svc")
+	r.NoError(err)
+	r.Equal(tgt1, ret)
+	ret, err = ns1Tbl.Lookup("legacy.svc")
+	r.NoError(err)
+	r.Equal(tgt3, ret)
+	r.Equal(2, len(ns1Tbl.m))
+
+	ns2Tbl := NewNamespacedTable("ns2")
+	ns2Tbl.Replace(tbl)
+	ret, err = ns2Tbl.Lookup("internal.svc")
+	r.NoError(err)
+	r.Equal(tgt2, ret)
+
+	// the scaler looks hosts up in their namespace first,
+	// and falls back to plain keys
+	ret, err = LookupInNamespace(tbl, "ns2", "internal.svc")
+	r.NoError(err)
+	r.Equal(tgt2, ret)
+	ret, err = LookupInNamespace(tbl, "ns2", "legacy.svc")
+	r.NoError(err)
+	r.Equal(tgt3, ret)
+	_, err = LookupInNamespace(tbl, "ns3", "internal.svc")
+	r.Equal(ErrTargetNotFound, err)
+}
+
+func TestTableVersion(t *testing.T) {
+	r := require.New(t)
+	tgt1 := Target{Service: "svc1", Port: 8080, Deployment: "depl1"}
+	tgt2 := Target{Service: "svc2", Port: 8080, Deployment: "depl2"}
+	tbl := NewTable()
+	emptyVersion, _ := tbl.Version()
+	r.NotEmpty(emptyVersion)
+
+	// tables with the same targets have the same version,
+	// however they were built
+	src := NewTable()
+	r.NoError(src.AddTarget("host1", tgt1))
+	r.NoError(src.AddTarget("host2", tgt2))
+	other := NewTable()
+	r.NoError(other.AddTarget("host2", tgt2))
+	r.NoError(other.AddTarget("host1", tgt1))
+	srcVersion, _ := src.Version()
+	otherVersion, _ := other.Version()
+	r.Equal(srcVersion, otherVersion)
+	r.NotEqual(emptyVersion, srcVersion)
+
+	diff := tbl.Replace(src)
+	r.Equal(TableDiff{
+		Added:      []string{"host1", "host2"},
+		OldVersion: emptyVersion,
+		NewVersion: srcVersion,
+	}, diff)
+	version, updated := tbl.Version()
+	r.Equal(srcVersion, version)
+	r.False(updated.IsZero())
+
+	// replacing a table with the same one changes nothing
+	r.True(tbl.Replace(other).Empty())
+	_, sameUpdated := tbl.Version()
+	r.Equal(updated, sameUpdated)
+
+	next := NewTable()
+	r.NoError(next.AddTarget("host2", Target{Service: "svc2", Port: 9090, Deployment: "depl2"}))
+	r.NoError(next.AddTarget("host3", tgt1))
+	diff = tbl.Replace(next)
+	r.Equal([]string{"host3"}, diff.Added)
+	r.Equal([]string{"host1"}, diff.Removed)
+	r.Equal([]string{"host2"}, diff.Changed)
+	r.NotEqual(srcVersion, diff.NewVersion)
+
+	// namespaced tables take the version of the table they're loaded
+	// from, so they match the tables in the operator and the scaler
+	nsSrc := NewTable()
+	r.NoError(nsSrc.AddTarget(NamespacedHost("ns1", "host1"), tgt1))
+	r.NoError(nsSrc.AddTarget(NamespacedHost("ns2", "host1"), tgt2))
+	nsTbl := NewNamespacedTable("ns1")
+	nsTbl.Replace(nsSrc)
+	nsSrcVersion, _ := nsSrc.Version()
+	nsVersion, _ := nsTbl.Version()
+	r.Equal(nsSrcVersion, nsVersion)
+
+	// so do tables decoded from JSON
+	b, err := json.Marshal(nsSrc)
+	r.NoError(err)
+	decoded := NewTable()
+	r.NoError(json.Unmarshal(b, decoded))
+	decodedVersion, _ := decoded.Version()
+	r.Equal(nsSrcVersion, decodedVersion)
+}
+
+func TestUpdateQueueFromTable(t *testing.T) {
+	r := require.New(t)
+	tbl := NewTable()
+	r.NoError(tbl.AddTarget("host1", Target{Service: "svc1", Port: 8080}))
+	r.NoError(tbl.AddTarget("host2", Target{
+		Service: "svc2",
+		Port:    8080,
+		Canary:  &CanaryTarget{Service: "svc2-canary", Port: 8080, Weight: 10},
+	}))
+	q := queue.NewMemory()
+	r.NoError(q.Resize("oldhost", 1))
+	r.NoError(q.Resize(CanaryQueueKey("host1"), 1))
+
+	r.NoError(updateQueueFromTable(logr.Discard(), tbl, q))
+	cur, err := q.Current()
+	r.NoError(err)
+	r.Equal(map[string]int{
+		"host1":                 0,
+		"host2":                 0,
+		CanaryQueueKey("host2"): 0,
+	}, cur.Counts)
 }
@@ -2,6 +2,7 @@ package routing
 
 import (
 	"fmt"
+	"net"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -22,3 +23,94 @@ func TestTargetServiceURL(t *testing.T) {
 		svcURL.Host,
 	)
 }
+
+func TestTargetForCanary(t *testing.T) {
+	r := require.New(t)
+	target := Target{
+		Service:               "testsvc",
+		Port:                  8081,
+		Deployment:            "testdeploy",
+		TargetPendingRequests: 100,
+	}
+	r.Equal(target, target.ForCanary())
+
+	target.Canary = &CanaryTarget{
+		Service:    "canarysvc",
+		Port:       8082,
+		Deployment: "canaryrollout",
+		APIVersion: "argoproj.io/v1alpha1",
+		Kind:       "Rollout",
+		Weight:     10,
+	}
+	canary := target.ForCanary()
+	r.Equal("canarysvc", canary.Service)
+	r.Equal(8082, canary.Port)
+	r.Equal("canaryrollout", canary.Deployment)
+	r.False(canary.IsDeployment())
+	r.Nil(canary.Canary)
+	// everything else is shared with the main target
+	r.Equal(int32(100), canary.TargetPendingRequests)
+	// and the main target is left alone
+	r.Equal("testsvc", target.Service)
+}
+
+func TestTargetExternalURL(t *testing.T) {
+	r := require.New(t)
+
+	// ExternalName services have no workload
+	target := Target{
+		Service: "legacysvc",
+		Port:    8080,
+	}
+	r.False(target.HasWorkload())
+	svcURL, err := target.ServiceURL()
+	r.NoError(err)
+	r.Equal("http://legacysvc:8080", svcURL.String())
+
+	target.URL = "https://legacy.example.com/app"
+	svcURL, err = target.ServiceURL()
+	r.NoError(err)
+	r.Equal("https", svcURL.Scheme)
+	r.Equal("legacy.example.com", svcURL.Host)
+	r.Equal("/app", svcURL.Path)
+
+	target.Deployment = "testdeploy"
+	r.True(target.HasWorkload())
+}
+
+func TestIPFilterPolicyAllows(t *testing.T) {
+	r := require.New(t)
+	var nilPolicy *IPFilterPolicy
+	allowed, err := nilPolicy.Allows(net.ParseIP("192.168.1.1"))
+	r.NoError(err)
+	r.True(allowed)
+
+	policy := &IPFilterPolicy{
+		Allow: []string{"10.0.0.0/8", "fd00::/8", "192.168.1.1"},
+		Deny:  []string{"10.1.0.0/16"},
+	}
+	for ip, expected := range map[string]bool{
+		"10.0.0.1":    true,
+		"fd00::1":     true,
+		"192.168.1.1": true,
+		"192.168.1.2": false,
+		"10.1.2.3":    false,
+		"":            false,
+	} {
+		allowed, err := policy.Allows(net.ParseIP(ip))
+		r.NoError(err)
+		r.Equal(expected, allowed, "IP %q", ip)
+	}
+
+	// with only a deny list, everything else is allowed
+	policy = &IPFilterPolicy{Deny: []string{"10.1.0.0/16"}}
+	allowed, err = policy.Allows(net.ParseIP("192.168.1.1"))
+	r.NoError(err)
+	r.True(allowed)
+
+	// invalid entries reject every client
+	policy = &IPFilterPolicy{Allow: []string{"10.0.0.0/8", "not-an-ip"}}
+	allowed, err = policy.Allows(net.ParseIP("10.0.0.1"))
+	r.Error(err)
+	r.False(allowed)
+}
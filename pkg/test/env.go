@@ -0,0 +1,159 @@
+// Package test is a harness for end-to-end scaling scenarios that run
+// in Go tests, without a live cluster. It starts a Kubernetes API
+// server with envtest, fake interceptor admin servers that report the
+// counts that a test sets, and gRPC clients for a scaler that the test
+// runs in-process.
+package test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/kedacore/http-add-on/operator/api/v1alpha1"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+// defaultAssetsDir is where envtest looks for the API server and etcd
+// binaries if KUBEBUILDER_ASSETS isn't set
+const defaultAssetsDir = "/usr/local/kubebuilder/bin"
+
+// Env is a Kubernetes API server, backed by etcd, that runs on the
+// local machine for the length of a test. The add-on's CRDs are
+// installed in it
+type Env struct {
+	// Config connects to the API server
+	Config *rest.Config
+	// Client is a controller-runtime client that knows the
+	// add-on's types as well as the built-in ones
+	Client client.Client
+	// Kube is a clientset for the built-in types
+	Kube *kubernetes.Clientset
+}
+
+// StartEnv starts an Env and stops it when t is done. It skips t if
+// the envtest binaries aren't installed. Set KUBEBUILDER_ASSETS to the
+// directory they're in, if it's not /usr/local/kubebuilder/bin
+func StartEnv(t *testing.T) *Env {
+	t.Helper()
+	assets := os.Getenv("KUBEBUILDER_ASSETS")
+	if assets == "" {
+		assets = defaultAssetsDir
+	}
+	if _, err := os.Stat(filepath.Join(assets, "kube-apiserver")); err != nil {
+		t.Skipf("the envtest binaries aren't installed in %s, skipping", assets)
+	}
+
+	testEnv := &envtest.Environment{
+		CRDDirectoryPaths:     []string{crdDir()},
+		ErrorIfCRDPathMissing: true,
+		BinaryAssetsDirectory: assets,
+	}
+	cfg, err := testEnv.Start()
+	if err != nil {
+		t.Fatalf("starting envtest (%s)", err)
+	}
+	t.Cleanup(func() {
+		if err := testEnv.Stop(); err != nil {
+			t.Errorf("stopping envtest (%s)", err)
+		}
+	})
+
+	scheme := k8sruntime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatalf("adding the built-in types to the scheme (%s)", err)
+	}
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatalf("adding the add-on's types to the scheme (%s)", err)
+	}
+	cl, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		t.Fatalf("creating the client (%s)", err)
+	}
+	kube, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		t.Fatalf("creating the clientset (%s)", err)
+	}
+	return &Env{Config: cfg, Client: cl, Kube: kube}
+}
+
+// crdDir returns the directory with the add-on's CRDs, which is found
+// from this file's path so that tests in any package can use it
+func crdDir() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(
+		filepath.Dir(file),
+		"..",
+		"..",
+		"operator",
+		"config",
+		"crd",
+		"bases",
+	)
+}
+
+// CreateNamespace creates a namespace with a name that's unique to
+// this test and returns its name
+func (e *Env) CreateNamespace(ctx context.Context, t *testing.T) string {
+	t.Helper()
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: fmt.Sprintf("test-%d", time.Now().UnixNano()),
+		},
+	}
+	if err := e.Client.Create(ctx, ns); err != nil {
+		t.Fatalf("creating namespace %s (%s)", ns.Name, err)
+	}
+	return ns.Name
+}
+
+// SaveRoutingTable writes table to the routing table ConfigMap in ns,
+// like the operator does, creating the ConfigMap if it doesn't exist
+func (e *Env) SaveRoutingTable(
+	ctx context.Context,
+	t *testing.T,
+	ns string,
+	table *routing.Table,
+) {
+	t.Helper()
+	configMaps := e.Kube.CoreV1().ConfigMaps(ns)
+	cm, err := configMaps.Get(ctx, routing.ConfigMapRoutingTableName, metav1.GetOptions{})
+	create := k8serrors.IsNotFound(err)
+	if create {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      routing.ConfigMapRoutingTableName,
+				Namespace: ns,
+			},
+		}
+	} else if err != nil {
+		t.Fatalf("getting the routing table ConfigMap (%s)", err)
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	if err := routing.SaveTableToConfigMap(table, cm); err != nil {
+		t.Fatalf("saving the routing table (%s)", err)
+	}
+	if create {
+		_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+	} else {
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		t.Fatalf("writing the routing table ConfigMap (%s)", err)
+	}
+}
@@ -0,0 +1,120 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/k8s"
+	"github.com/kedacore/http-add-on/pkg/queue"
+	v1 "k8s.io/api/core/v1"
+)
+
+// FakeInterceptor is an interceptor's admin server that serves the
+// counts that a test sets, instead of counting requests. The scaler
+// scrapes it like it would a real interceptor
+type FakeInterceptor struct {
+	// URL is the admin server's URL
+	URL *url.URL
+	ns  string
+
+	mut        sync.Mutex
+	hosts      map[string]queue.HostCounts
+	epoch      int64
+	generation uint64
+}
+
+var _ queue.CountReader = &FakeInterceptor{}
+
+// StartFakeInterceptor starts a FakeInterceptor whose counts are
+// namespaced with ns, like the ones of an interceptor that watches ns,
+// and stops it when t is done. It starts without any hosts
+func StartFakeInterceptor(t *testing.T, ns string) *FakeInterceptor {
+	t.Helper()
+	ret := &FakeInterceptor{
+		ns:    ns,
+		hosts: map[string]queue.HostCounts{},
+		epoch: time.Now().UnixNano(),
+	}
+	mux := http.NewServeMux()
+	queue.AddCountsRoute(logr.Discard(), mux, ret, ns)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("parsing the fake interceptor's URL (%s)", err)
+	}
+	ret.URL = u
+	return ret
+}
+
+// Port returns the port of the admin server, which is the admin port
+// that the scaler must scrape
+func (f *FakeInterceptor) Port() string {
+	return f.URL.Port()
+}
+
+// Endpoints returns the Endpoints of a Service named svcName, in f's
+// namespace, whose only address is f's admin server. The API server
+// won't store them, since that address is a loopback one, so scalers
+// find f through GetEndpointsFunc instead
+func (f *FakeInterceptor) Endpoints(svcName string) *v1.Endpoints {
+	return k8s.FakeEndpointsForURL(f.URL, f.ns, svcName, 1)
+}
+
+// GetEndpointsFunc returns a k8s.GetEndpointsFunc that finds f behind
+// the Service named svcName in f's namespace, for scalers that run
+// without an API server
+func (f *FakeInterceptor) GetEndpointsFunc(svcName string) k8s.GetEndpointsFunc {
+	return func(_ context.Context, ns, name string) (*v1.Endpoints, error) {
+		if ns != f.ns || name != svcName {
+			return nil, fmt.Errorf("no fake interceptor behind %s/%s", ns, name)
+		}
+		return f.Endpoints(svcName), nil
+	}
+}
+
+// Set sets host's breakdown. host's count is its active and pending
+// requests
+func (f *FakeInterceptor) Set(host string, hc queue.HostCounts) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	f.hosts[host] = hc
+}
+
+// SetPending sets host's count to pending requests that are waiting
+// for its backend, like they do while it scales from zero
+func (f *FakeInterceptor) SetPending(host string, pending int) {
+	f.Set(host, queue.HostCounts{Pending: pending})
+}
+
+// Remove removes host, like an interceptor does when host is taken
+// out of the routing table
+func (f *FakeInterceptor) Remove(host string) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	delete(f.hosts, host)
+}
+
+// Current returns a snapshot of f's counts. It's the
+// queue.CountReader that f's admin server serves
+func (f *FakeInterceptor) Current() (*queue.Counts, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	f.generation++
+	ret := queue.NewCounts()
+	ret.Source = "fake-interceptor"
+	ret.Epoch = f.epoch
+	ret.Generation = f.generation
+	for host, hc := range f.hosts {
+		ret.Counts[host] = hc.Active + hc.Pending
+		ret.Hosts[host] = hc
+	}
+	return ret, nil
+}
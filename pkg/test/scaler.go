@@ -0,0 +1,110 @@
+package test
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/k8s"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	externalscaler "github.com/kedacore/http-add-on/proto"
+	"github.com/kedacore/http-add-on/scaler/server"
+	"google.golang.org/grpc"
+)
+
+// ScalerConfig returns the configuration of a scaler that scrapes f,
+// behind the admin Service named svcName, every 50ms. Its other
+// settings are the scaler's defaults, except that it scrapes over
+// HTTP/1, which is all that f serves
+func (f *FakeInterceptor) ScalerConfig(svcName string) *server.Config {
+	port, _ := strconv.Atoi(f.Port())
+	return &server.Config{
+		TargetNamespace:                  f.ns,
+		TargetService:                    svcName,
+		TargetPort:                       port,
+		TargetPendingRequests:            100,
+		TargetPendingRequestsInterceptor: 100,
+		QueueTickDuration:                50 * time.Millisecond,
+		ScrapeTimeout:                    time.Second,
+		FallbackPolicy:                   "none",
+		PartialResultsPolicy:             "hold",
+		FallbackTicks:                    10,
+		FallbackReplicas:                 1,
+	}
+}
+
+// StartScaler runs the external scaler that cfg describes in-process,
+// and returns the address of its gRPC server. The scaler finds the
+// interceptors with getEndpoints, like the one that
+// FakeInterceptor.GetEndpointsFunc returns, and gets the targets of
+// hosts from table. It's stopped when t is done
+func StartScaler(
+	ctx context.Context,
+	t *testing.T,
+	cfg *server.Config,
+	getEndpoints k8s.GetEndpointsFunc,
+	table routing.TableReader,
+) string {
+	t.Helper()
+	ctx, done := context.WithCancel(ctx)
+	t.Cleanup(done)
+	scaler, err := server.New(ctx, logr.Discard(), cfg, getEndpoints, table)
+	if err != nil {
+		t.Fatalf("creating the scaler (%s)", err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening for the scaler's gRPC server (%s)", err)
+	}
+	go scaler.Serve(ctx, lis, nil)
+	return lis.Addr().String()
+}
+
+// DialScaler connects to the external scaler's gRPC server at addr,
+// like KEDA does, and closes the connection when t is done
+func DialScaler(
+	ctx context.Context,
+	t *testing.T,
+	addr string,
+) externalscaler.ExternalScalerClient {
+	t.Helper()
+	ctx, done := context.WithTimeout(ctx, 5*time.Second)
+	defer done()
+	conn, err := grpc.DialContext(
+		ctx,
+		addr,
+		grpc.WithInsecure(),
+		grpc.WithBlock(),
+	)
+	if err != nil {
+		t.Fatalf("connecting to the scaler at %s (%s)", addr, err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	return externalscaler.NewExternalScalerClient(conn)
+}
+
+// Metric returns the value of the first metric that the scaler
+// reports for sor
+func Metric(
+	ctx context.Context,
+	t *testing.T,
+	cl externalscaler.ExternalScalerClient,
+	sor *externalscaler.ScaledObjectRef,
+) int64 {
+	t.Helper()
+	res, err := cl.GetMetrics(ctx, &externalscaler.GetMetricsRequest{
+		ScaledObjectRef: sor,
+	})
+	if err != nil {
+		t.Fatalf("getting the metrics of %s/%s (%s)", sor.Namespace, sor.Name, err)
+	}
+	if len(res.MetricValues) == 0 {
+		t.Fatalf("no metrics for %s/%s", sor.Namespace, sor.Name)
+	}
+	return res.MetricValues[0].MetricValue
+}
@@ -0,0 +1,220 @@
+package tls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CertReloader holds a certificate, its key, and a CA bundle that it
+// loads from files, like the ones in a mounted Secret. It checks the
+// files at most every checkEvery, and reloads them when they change,
+// so certificates can be rotated without restarting anything. The CA
+// bundle is optional for servers that don't verify clients.
+//
+// If a reload fails, for example because the certificate was replaced
+// but its key wasn't yet, the previously loaded files stay in use
+type CertReloader struct {
+	certFile   string
+	keyFile    string
+	caFile     string
+	checkEvery time.Duration
+
+	mut       *sync.RWMutex
+	cert      *tls.Certificate
+	caPool    *x509.CertPool
+	modTimes  [3]time.Time
+	lastCheck time.Time
+}
+
+// NewCertReloader creates a CertReloader for the given files and loads
+// them for the first time. Returns an error if they couldn't be loaded
+func NewCertReloader(
+	certFile,
+	keyFile,
+	caFile string,
+	checkEvery time.Duration,
+) (*CertReloader, error) {
+	ret := &CertReloader{
+		certFile:   certFile,
+		keyFile:    keyFile,
+		caFile:     caFile,
+		checkEvery: checkEvery,
+		mut:        new(sync.RWMutex),
+	}
+	modTimes, err := ret.statFiles()
+	if err != nil {
+		return nil, err
+	}
+	if err := ret.load(time.Now(), modTimes); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// statFiles returns the modification times of c's files
+func (c *CertReloader) statFiles() ([3]time.Time, error) {
+	ret := [3]time.Time{}
+	for i, file := range []string{c.certFile, c.keyFile, c.caFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return ret, errors.Wrap(err, "checking TLS file")
+		}
+		ret[i] = info.ModTime()
+	}
+	return ret, nil
+}
+
+// load reads all of c's files and replaces what c holds with them
+func (c *CertReloader) load(now time.Time, modTimes [3]time.Time) error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return errors.Wrap(err, "loading TLS certificate and key")
+	}
+	var caPool *x509.CertPool
+	if c.caFile != "" {
+		caBytes, err := ioutil.ReadFile(c.caFile)
+		if err != nil {
+			return errors.Wrap(err, "reading TLS CA bundle")
+		}
+		caPool = x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(caBytes) {
+			return fmt.Errorf("no certificates found in TLS CA bundle %s", c.caFile)
+		}
+	}
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.cert = &cert
+	c.caPool = caPool
+	c.modTimes = modTimes
+	c.lastCheck = now
+	return nil
+}
+
+// maybeReload reloads c's files if c didn't check them in the last
+// checkEvery, and any of them changed since they were last loaded
+func (c *CertReloader) maybeReload(now time.Time) error {
+	c.mut.Lock()
+	if now.Sub(c.lastCheck) < c.checkEvery {
+		c.mut.Unlock()
+		return nil
+	}
+	c.lastCheck = now
+	loaded := c.modTimes
+	c.mut.Unlock()
+
+	modTimes, err := c.statFiles()
+	if err != nil {
+		return err
+	}
+	if modTimes == loaded {
+		return nil
+	}
+	return c.load(now, modTimes)
+}
+
+// current returns the certificate and CA pool that c holds right now,
+// after reloading them if needed
+func (c *CertReloader) current() (*tls.Certificate, *x509.CertPool) {
+	// a failed reload keeps the previous files, which are
+	// still the best thing to use
+	_ = c.maybeReload(time.Now())
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	return c.cert, c.caPool
+}
+
+// ServerConfig returns a tls.Config for a server that presents c's
+// certificate, and verifies client certificates against c's CA bundle.
+// clientAuth decides whether clients must present a certificate.
+// With tls.VerifyClientCertIfGiven, use RequireClientCert to reject
+// requests without one, for example so that probes from the kubelet
+// still work.
+//
+// The VerifyConnection func of the returned tls.Config, if the caller
+// sets one, runs for every connection after the client certificate was
+// verified, like with VerifyClientSANs
+func (c *CertReloader) ServerConfig(clientAuth tls.ClientAuthType) *tls.Config {
+	ret := &tls.Config{MinVersion: tls.VersionTLS12}
+	ret.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cert, caPool := c.current()
+		return &tls.Config{
+			MinVersion:       tls.VersionTLS12,
+			Certificates:     []tls.Certificate{*cert},
+			ClientCAs:        caPool,
+			ClientAuth:       clientAuth,
+			VerifyConnection: ret.VerifyConnection,
+		}, nil
+	}
+	return ret
+}
+
+// VerifyClientSANs returns a func for tls.Config.VerifyConnection that
+// rejects clients unless their certificate has at least one of sans as
+// a DNS name, URI, or email address
+func VerifyClientSANs(sans []string) func(tls.ConnectionState) error {
+	allowed := make(map[string]struct{}, len(sans))
+	for _, san := range sans {
+		allowed[san] = struct{}{}
+	}
+	return func(state tls.ConnectionState) error {
+		if len(state.VerifiedChains) == 0 {
+			return fmt.Errorf("client presented no verified certificate")
+		}
+		leaf := state.VerifiedChains[0][0]
+		names := append([]string{}, leaf.DNSNames...)
+		names = append(names, leaf.EmailAddresses...)
+		for _, uri := range leaf.URIs {
+			names = append(names, uri.String())
+		}
+		for _, name := range names {
+			if _, ok := allowed[name]; ok {
+				return nil
+			}
+		}
+		return fmt.Errorf("client certificate SANs %v are not allowed", names)
+	}
+}
+
+// ClientConfig returns a tls.Config for a client that presents c's
+// certificate, and verifies that the server's certificate is signed
+// by c's CA bundle and valid for serverName.
+//
+// The server is verified by hand, rather than with RootCAs, so that
+// the CA bundle can be rotated too
+func (c *CertReloader) ClientConfig(serverName string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := c.current()
+			return cert, nil
+		},
+		// VerifyConnection does the verification instead
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return fmt.Errorf("server presented no certificate")
+			}
+			_, caPool := c.current()
+			intermediates := x509.NewCertPool()
+			for _, cert := range state.PeerCertificates[1:] {
+				intermediates.AddCert(cert)
+			}
+			_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+				DNSName:       serverName,
+				Roots:         caPool,
+				Intermediates: intermediates,
+			})
+			return err
+		},
+	}
+}
@@ -0,0 +1,246 @@
+package tls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testServerName = "testsvc.testns.svc"
+
+// testCA is a CA that signs certificates for tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	r := require.New(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	r.NoError(err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	r.NoError(err)
+	cert, err := x509.ParseCertificate(der)
+	r.NoError(err)
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// writeFiles signs a certificate for dnsName, then writes it, its
+// key and ca's certificate to dir. Returns the paths it wrote to
+func (ca *testCA) writeFiles(
+	t *testing.T,
+	dir,
+	dnsName string,
+) (string, string, string) {
+	r := require.New(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	r.NoError(err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: dnsName},
+		DNSNames:     []string{dnsName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth,
+		},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	r.NoError(err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	r.NoError(err)
+
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	caFile := filepath.Join(dir, "ca.crt")
+	r.NoError(ioutil.WriteFile(
+		certFile,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		0600,
+	))
+	r.NoError(ioutil.WriteFile(
+		keyFile,
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		0600,
+	))
+	r.NoError(ioutil.WriteFile(caFile, ca.pem, 0600))
+	// make sure the new files look changed, even on
+	// file systems with coarse modification times
+	future := time.Now().Add(time.Duration(len(dnsName)) * time.Minute)
+	for _, file := range []string{certFile, keyFile, caFile} {
+		r.NoError(os.Chtimes(file, future, future))
+	}
+	return certFile, keyFile, caFile
+}
+
+func newTestReloader(t *testing.T, ca *testCA, dnsName string) (*CertReloader, string) {
+	r := require.New(t)
+	dir := t.TempDir()
+	certFile, keyFile, caFile := ca.writeFiles(t, dir, dnsName)
+	reloader, err := NewCertReloader(certFile, keyFile, caFile, 0)
+	r.NoError(err)
+	return reloader, dir
+}
+
+func TestCertReloaderMutualTLS(t *testing.T) {
+	r := require.New(t)
+	ca := newTestCA(t, "testca")
+	serverCerts, _ := newTestReloader(t, ca, testServerName)
+	clientCerts, _ := newTestReloader(t, ca, "scaler")
+
+	srv := httptest.NewUnstartedServer(RequireClientCert(
+		[]string{"/healthz"},
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(200)
+		}),
+	))
+	srv.TLS = serverCerts.ServerConfig(tls.VerifyClientCertIfGiven)
+	srv.StartTLS()
+	defer srv.Close()
+
+	get := func(cl *http.Client, path string) (int, error) {
+		res, err := cl.Get(srv.URL + path)
+		if err != nil {
+			return 0, err
+		}
+		defer res.Body.Close()
+		return res.StatusCode, nil
+	}
+
+	// a client with a certificate from the CA can use every path
+	mtlsCl := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: clientCerts.ClientConfig(testServerName),
+	}}
+	code, err := get(mtlsCl, "/queue")
+	r.NoError(err)
+	r.Equal(200, code)
+
+	// a client without a certificate can only use the exempt paths
+	anonCl := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	code, err = get(anonCl, "/queue")
+	r.NoError(err)
+	r.Equal(403, code)
+	code, err = get(anonCl, "/healthz")
+	r.NoError(err)
+	r.Equal(200, code)
+
+	// the server's certificate isn't valid for other names
+	wrongNameCl := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: clientCerts.ClientConfig("othersvc.testns.svc"),
+	}}
+	_, err = get(wrongNameCl, "/queue")
+	r.Error(err)
+
+	// certificates from other CAs are rejected
+	otherCerts, _ := newTestReloader(t, newTestCA(t, "otherca"), "scaler")
+	otherCl := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: otherCerts.ClientConfig(testServerName),
+	}}
+	_, err = get(otherCl, "/queue")
+	r.Error(err)
+}
+
+func TestCertReloaderRotation(t *testing.T) {
+	r := require.New(t)
+	ca := newTestCA(t, "testca")
+	reloader, dir := newTestReloader(t, ca, "first.testing")
+	cert, _ := reloader.current()
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	r.NoError(err)
+	r.Equal("first.testing", leaf.Subject.CommonName)
+
+	// new files are picked up
+	ca.writeFiles(t, dir, "second.testing")
+	cert, _ = reloader.current()
+	leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	r.NoError(err)
+	r.Equal("second.testing", leaf.Subject.CommonName)
+
+	// broken files are ignored, and the last good ones kept
+	brokenFile := filepath.Join(dir, "tls.crt")
+	r.NoError(ioutil.WriteFile(brokenFile, []byte("garbage"), 0600))
+	future := time.Now().Add(24 * time.Hour)
+	r.NoError(os.Chtimes(brokenFile, future, future))
+	cert, _ = reloader.current()
+	leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	r.NoError(err)
+	r.Equal("second.testing", leaf.Subject.CommonName)
+}
+
+func TestNewCertReloaderErrors(t *testing.T) {
+	r := require.New(t)
+	dir := t.TempDir()
+	_, err := NewCertReloader(
+		filepath.Join(dir, "tls.crt"),
+		filepath.Join(dir, "tls.key"),
+		filepath.Join(dir, "ca.crt"),
+		time.Minute,
+	)
+	r.Error(err)
+
+	certFile, keyFile, caFile := newTestCA(t, "testca").writeFiles(t, dir, "test")
+	r.NoError(ioutil.WriteFile(caFile, []byte("garbage"), 0600))
+	_, err = NewCertReloader(certFile, keyFile, caFile, time.Minute)
+	r.Error(err)
+}
+
+func TestVerifyClientSANs(t *testing.T) {
+	r := require.New(t)
+	ca := newTestCA(t, "testca")
+	serverCerts, _ := newTestReloader(t, ca, testServerName)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(200)
+		},
+	))
+	srv.TLS = serverCerts.ServerConfig(tls.RequireAndVerifyClientCert)
+	srv.TLS.VerifyConnection = VerifyClientSANs([]string{"keda.testing"})
+	srv.StartTLS()
+	defer srv.Close()
+
+	get := func(clientName string) error {
+		clientCerts, _ := newTestReloader(t, ca, clientName)
+		cl := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: clientCerts.ClientConfig(testServerName),
+		}}
+		res, err := cl.Get(srv.URL)
+		if err != nil {
+			return err
+		}
+		return res.Body.Close()
+	}
+	r.NoError(get("keda.testing"))
+	r.Error(get("other.testing"))
+}
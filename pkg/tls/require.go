@@ -0,0 +1,28 @@
+package tls
+
+import (
+	"net/http"
+)
+
+// RequireClientCert returns an http.Handler that responds with 403 to
+// requests without a verified client certificate, and passes all others
+// to next. Requests for any path in exemptPaths, like health checks,
+// are always passed to next
+func RequireClientCert(exemptPaths []string, next http.Handler) http.Handler {
+	exempt := make(map[string]struct{}, len(exemptPaths))
+	for _, path := range exemptPaths {
+		exempt[path] = struct{}{}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := exempt[r.URL.Path]; ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("client certificate required"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
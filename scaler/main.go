@@ -6,23 +6,12 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
 	"log"
-	"net"
-	"net/http"
 	"os"
-	"time"
 
-	"github.com/go-logr/logr"
-	"github.com/kedacore/http-add-on/pkg/k8s"
+	pkgconfig "github.com/kedacore/http-add-on/pkg/config"
 	pkglog "github.com/kedacore/http-add-on/pkg/log"
-	"github.com/kedacore/http-add-on/pkg/queue"
-	"github.com/kedacore/http-add-on/pkg/routing"
-	externalscaler "github.com/kedacore/http-add-on/proto"
-	"golang.org/x/sync/errgroup"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/reflection"
+	"github.com/kedacore/http-add-on/scaler/server"
 )
 
 func main() {
@@ -30,165 +19,10 @@ func main() {
 	if err != nil {
 		log.Fatalf("error creating new logger (%v)", err)
 	}
-	ctx, done := context.WithCancel(
-		context.Background(),
-	)
-	defer done()
-	cfg := mustParseConfig()
-	grpcPort := cfg.GRPCPort
-	healthPort := cfg.HealthPort
-	namespace := cfg.TargetNamespace
-	svcName := cfg.TargetService
-	targetPortStr := fmt.Sprintf("%d", cfg.TargetPort)
-	targetPendingRequests := cfg.TargetPendingRequests
-	targetPendingRequestsInterceptor := cfg.TargetPendingRequestsInterceptor
-
-	k8sCl, _, err := k8s.NewClientset()
-	if err != nil {
-		lggr.Error(err, "getting a Kubernetes client")
+	cfg := new(server.Config)
+	pkgconfig.MustLoad("scaler", cfg)
+	if err := server.Run(context.Background(), lggr, cfg); err != nil {
+		lggr.Error(err, "one or more of the servers failed")
 		os.Exit(1)
 	}
-	pinger := newQueuePinger(
-		context.Background(),
-		lggr,
-		k8s.EndpointsFuncForK8sClientset(k8sCl),
-		namespace,
-		svcName,
-		targetPortStr,
-		time.NewTicker(500*time.Millisecond),
-	)
-
-	table := routing.NewTable()
-
-	grp, ctx := errgroup.WithContext(ctx)
-	grp.Go(func() error {
-		defer done()
-		return startGrpcServer(
-			ctx,
-			lggr,
-			grpcPort,
-			pinger,
-			table,
-			int64(targetPendingRequests),
-			int64(targetPendingRequestsInterceptor),
-		)
-	})
-
-	grp.Go(func() error {
-		defer done()
-		return routing.StartConfigMapRoutingTableUpdater(
-			ctx,
-			lggr,
-			cfg.UpdateRoutingTableDur,
-			k8sCl.CoreV1().ConfigMaps(cfg.TargetNamespace),
-			table,
-			// we don't care about the queue here.
-			// we just want to update the routing table
-			// so that the scaler can use it to determine
-			// the target metrics for given hosts.
-			queue.NewMemory(),
-		)
-	})
-	grp.Go(func() error {
-		defer done()
-		return startHealthcheckServer(
-			ctx,
-			lggr,
-			healthPort,
-			pinger,
-		)
-	})
-	lggr.Error(grp.Wait(), "one or more of the servers failed")
-}
-
-func startGrpcServer(
-	ctx context.Context,
-	lggr logr.Logger,
-	port int,
-	pinger *queuePinger,
-	routingTable *routing.Table,
-	targetPendingRequests int64,
-	targetPendingRequestsInterceptor int64,
-) error {
-
-	addr := fmt.Sprintf("0.0.0.0:%d", port)
-	lggr.Info("starting grpc server", "address", addr)
-	lis, err := net.Listen("tcp", addr)
-	if err != nil {
-		return err
-	}
-
-	grpcServer := grpc.NewServer()
-	externalscaler.RegisterExternalScalerServer(
-		grpcServer,
-		newImpl(
-			lggr,
-			pinger,
-			routingTable,
-			targetPendingRequests,
-			targetPendingRequestsInterceptor,
-		),
-	)
-	reflection.Register(grpcServer)
-	go func() {
-		<-ctx.Done()
-		lis.Close()
-	}()
-	return grpcServer.Serve(lis)
-
-}
-
-func startHealthcheckServer(
-	ctx context.Context,
-	lggr logr.Logger,
-	port int,
-	pinger *queuePinger,
-) error {
-	lggr = lggr.WithName("startHealthcheckServer")
-
-	mux := http.NewServeMux()
-	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(200)
-	})
-	mux.HandleFunc("/livez", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(200)
-	})
-	mux.HandleFunc("/queue", func(w http.ResponseWriter, r *http.Request) {
-		lggr = lggr.WithName("route.counts")
-		cts := pinger.counts()
-		lggr.Info("counts endpoint", "counts", cts)
-		if err := json.NewEncoder(w).Encode(&cts); err != nil {
-			lggr.Error(err, "writing counts information to client")
-			w.WriteHeader(500)
-		}
-	})
-	mux.HandleFunc("/queue_ping", func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
-		lggr := lggr.WithName("route.counts_ping")
-		if err := pinger.requestCounts(ctx); err != nil {
-			lggr.Error(err, "requesting counts failed")
-			w.WriteHeader(500)
-			w.Write([]byte("error requesting counts from interceptors"))
-			return
-		}
-		cts := pinger.counts()
-		lggr.Info("counts ping endpoint", "counts", cts)
-		if err := json.NewEncoder(w).Encode(&cts); err != nil {
-			lggr.Error(err, "writing counts data to caller")
-			w.WriteHeader(500)
-			w.Write([]byte("error writing counts data to caller"))
-		}
-	})
-
-	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
-		Handler: mux,
-	}
-	lggr.Info("starting health check server", "port", port)
-
-	go func() {
-		<-ctx.Done()
-		srv.Shutdown(ctx)
-	}()
-	return srv.ListenAndServe()
 }
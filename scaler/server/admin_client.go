@@ -0,0 +1,99 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	kedatls "github.com/kedacore/http-add-on/pkg/tls"
+	"golang.org/x/net/http2"
+)
+
+const (
+	// h2cDialTimeout is how long an h2cAdminClient waits to
+	// connect to an admin server
+	h2cDialTimeout = 5 * time.Second
+	// h2cReadIdleTimeout is how long an h2cAdminClient's connection
+	// may go without any frames before it's health checked with a
+	// ping, and h2cPingTimeout is how long it waits for the ping's
+	// response before it closes the connection. Interceptor pods come
+	// and go, so dead connections have to be noticed
+	h2cReadIdleTimeout = 10 * time.Second
+	h2cPingTimeout     = 5 * time.Second
+)
+
+// adminClient is how the queuePinger connects to the
+// interceptors' admin servers
+type adminClient struct {
+	httpCl *http.Client
+	// scheme is the URL scheme that the admin servers serve,
+	// either http or https
+	scheme string
+}
+
+// plainAdminClient returns an adminClient that connects to
+// admin servers over plain HTTP
+func plainAdminClient() adminClient {
+	return adminClient{httpCl: http.DefaultClient, scheme: "http"}
+}
+
+// h2cAdminClient returns an adminClient that connects to admin
+// servers over cleartext HTTP/2. It keeps a single connection to each
+// admin server, which all of its requests to that server share
+func h2cAdminClient() adminClient {
+	dialer := &net.Dialer{Timeout: h2cDialTimeout}
+	transport := &http2.Transport{
+		AllowHTTP: true,
+		// with AllowHTTP, the transport still calls DialTLS
+		// for http URLs, so it dials without TLS here
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dialer.Dial(network, addr)
+		},
+		ReadIdleTimeout: h2cReadIdleTimeout,
+		PingTimeout:     h2cPingTimeout,
+	}
+	return adminClient{
+		httpCl: &http.Client{Transport: transport},
+		scheme: "http",
+	}
+}
+
+// newAdminClient returns the adminClient that cfg describes for the
+// admin servers behind the Service svcName. If cfg has TLS files, the
+// client presents their certificate and verifies the admin servers
+// against their CA bundle, and negotiates HTTP/2 with them. Otherwise,
+// it connects over cleartext HTTP/2 if cfg.ScrapeHTTP2 is set. Returns
+// an error if the TLS files couldn't be loaded
+func newAdminClient(cfg *Config, svcName string) (adminClient, error) {
+	if !cfg.tlsEnabled() && cfg.ScrapeHTTP2 {
+		return h2cAdminClient(), nil
+	}
+	if !cfg.tlsEnabled() {
+		return plainAdminClient(), nil
+	}
+	certs, err := kedatls.NewCertReloader(
+		cfg.TLSCertFile,
+		cfg.TLSKeyFile,
+		cfg.TLSCAFile,
+		cfg.TLSReloadInterval,
+	)
+	if err != nil {
+		return adminClient{}, err
+	}
+	serverName := cfg.TLSServerName
+	if serverName == "" {
+		serverName = fmt.Sprintf(
+			"%s.%s.svc",
+			svcName,
+			cfg.TargetNamespace,
+		)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = certs.ClientConfig(serverName)
+	return adminClient{
+		httpCl: &http.Client{Transport: transport},
+		scheme: "https",
+	}, nil
+}
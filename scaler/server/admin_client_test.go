@@ -0,0 +1,35 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+)
+
+func TestNewAdminClient(t *testing.T) {
+	r := require.New(t)
+	cl, err := newAdminClient(&Config{}, "testsvc")
+	r.NoError(err)
+	r.Equal("http", cl.scheme)
+	r.Equal(http.DefaultClient, cl.httpCl)
+
+	// plain HTTP admin servers are scraped over
+	// cleartext HTTP/2 if it's enabled
+	cl, err = newAdminClient(&Config{ScrapeHTTP2: true}, "testsvc")
+	r.NoError(err)
+	r.Equal("http", cl.scheme)
+	transport, ok := cl.httpCl.Transport.(*http2.Transport)
+	r.True(ok)
+	r.True(transport.AllowHTTP)
+
+	// TLS files that don't exist are an error, rather than
+	// a silent fallback to plain HTTP
+	_, err = newAdminClient(&Config{
+		TLSCertFile: "/nonexistent/tls.crt",
+		TLSKeyFile:  "/nonexistent/tls.key",
+		TLSCAFile:   "/nonexistent/ca.crt",
+	}, "testsvc")
+	r.Error(err)
+}
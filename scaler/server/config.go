@@ -0,0 +1,197 @@
+package server
+
+import (
+	"time"
+)
+
+// Config is the scaler's configuration, which the scaler binary loads
+// from its environment
+type Config struct {
+	// GRPCPort is what port to serve the KEDA-compatible gRPC external scaler interface
+	// on
+	GRPCPort int `envconfig:"KEDA_HTTP_SCALER_PORT" default:"8080"`
+	// HealthPort is the port to serve the Kubernetes health check endpoints on
+	HealthPort int `envconfig:"KEDA_HTTP_HEALTH_PORT" default:"8090"`
+	// TargetNamespace is the namespace in which this scaler is running, and the namespace
+	// that the target interceptors are running in. This scaler and all the interceptors
+	// must be running in the same namespace
+	TargetNamespace string `envconfig:"KEDA_HTTP_SCALER_TARGET_ADMIN_NAMESPACE" required:"true"`
+	// TargetService is the name of the service to issue metrics RPC requests to interceptors.
+	// It's ignored if TargetServices is set
+	TargetService string `envconfig:"KEDA_HTTP_SCALER_TARGET_ADMIN_SERVICE" default:""`
+	// TargetServices is a comma-separated list of the admin services of
+	// several interceptor fleets, like one per zone, whose counts are
+	// merged. Each entry is <fleet name>=<service>, or just <service>
+	// for a fleet named after its service. All of them serve their
+	// admin servers on TargetPort
+	TargetServices []string `envconfig:"KEDA_HTTP_SCALER_TARGET_ADMIN_SERVICES" default:""`
+	// TargetPort is the port on TargetService to which to issue metrics RPC requests to
+	// interceptors
+	TargetPort int `envconfig:"KEDA_HTTP_SCALER_TARGET_ADMIN_PORT" required:"true"`
+	// EndpointsResyncDur is the duration between full resyncs of
+	// TargetService's EndpointSlices. Changes to them are picked up as
+	// soon as they happen, so this is only a fallback
+	EndpointsResyncDur time.Duration `envconfig:"KEDA_HTTP_SCALER_ENDPOINTS_RESYNC_DUR" default:"1m"`
+	// TargetPendingRequests is the default value for the
+	// pending requests value that the scaler will return to
+	// KEDA, if that value is not set on an incoming
+	// `HTTPScaledObject`
+	TargetPendingRequests int `envconfig:"KEDA_HTTP_SCALER_TARGET_PENDING_REQUESTS" default:"100"`
+	// RoutingTableResyncDur is the duration between full rebuilds of
+	// the routing table. The routing table is also updated as soon as
+	// its ConfigMap changes, so this is only a fallback
+	RoutingTableResyncDur time.Duration `envconfig:"KEDA_HTTP_SCALER_ROUTING_TABLE_RESYNC_DUR" default:"1m"`
+	// QueueTickDuration is the duration between queue count requests
+	// to the interceptors. Shorter durations let StreamIsActive notify
+	// KEDA sooner when a host becomes active
+	QueueTickDuration time.Duration `envconfig:"KEDA_HTTP_QUEUE_TICK_DURATION" default:"500ms"`
+	// MetricsMaxStaleness is how old the counts may get before a
+	// GetMetrics call pings the interceptors on demand, rather than
+	// report the counts from the last tick. Calls at the same time
+	// share a single ping. 0 means the counts are only updated on
+	// ticks
+	MetricsMaxStaleness time.Duration `envconfig:"KEDA_HTTP_SCALER_METRICS_MAX_STALENESS" default:"0"`
+	// ScrapeHTTP2 toggles whether the queue counts are scraped from
+	// interceptors that serve plain HTTP over cleartext HTTP/2, so
+	// that each interceptor's scrapes share a single connection.
+	// Interceptors that serve TLS negotiate HTTP/2 either way
+	ScrapeHTTP2 bool `envconfig:"KEDA_HTTP_SCALER_SCRAPE_HTTP2" default:"true"`
+	// ScrapeTimeout is how long each interceptor may take to respond
+	// with its queue counts, so that one slow interceptor doesn't hold
+	// up the tick. 0 means there's no limit
+	ScrapeTimeout time.Duration `envconfig:"KEDA_HTTP_SCALER_SCRAPE_TIMEOUT" default:"1s"`
+	// ScrapeJitter is the longest that each interceptor's scrape is
+	// delayed by, at random, so that the scrapes of a large fleet are
+	// spread out rather than all sent at once. 0 means they're not
+	// delayed
+	ScrapeJitter time.Duration `envconfig:"KEDA_HTTP_SCALER_SCRAPE_JITTER" default:"100ms"`
+	// This will be the 'Target Pending Requests' for the interceptor
+	TargetPendingRequestsInterceptor int `envconfig:"KEDA_HTTP_SCALER_TARGET_PENDING_REQUESTS_INTERCEPTOR" default:"100"`
+	// LeaderElection toggles whether this scaler should only serve
+	// the gRPC external scaler interface while it holds the leader
+	// election lease. Enable this when running more than one replica
+	LeaderElection bool `envconfig:"KEDA_HTTP_SCALER_LEADER_ELECTION" default:"false"`
+	// LeaderElectionLeaseName is the name of the Lease, in TargetNamespace,
+	// that scaler replicas compete for when LeaderElection is enabled
+	LeaderElectionLeaseName string `envconfig:"KEDA_HTTP_SCALER_LEADER_ELECTION_LEASE_NAME" default:"keda-http-add-on-external-scaler"`
+	// LeaderElectionLeaseDuration is how long non-leader replicas wait
+	// before trying to take over a lease that hasn't been renewed
+	LeaderElectionLeaseDuration time.Duration `envconfig:"KEDA_HTTP_SCALER_LEADER_ELECTION_LEASE_DURATION" default:"15s"`
+	// LeaderElectionRenewDeadline is how long the leader keeps trying
+	// to renew the lease before giving up leadership
+	LeaderElectionRenewDeadline time.Duration `envconfig:"KEDA_HTTP_SCALER_LEADER_ELECTION_RENEW_DEADLINE" default:"10s"`
+	// LeaderElectionRetryPeriod is how long replicas wait between
+	// attempts to acquire or renew the lease
+	LeaderElectionRetryPeriod time.Duration `envconfig:"KEDA_HTTP_SCALER_LEADER_ELECTION_RETRY_PERIOD" default:"2s"`
+	// PeerService is the name of a headless Service, in
+	// TargetNamespace, whose endpoints are the scaler's replicas. If
+	// it's set, each replica fetches the others' counts from their
+	// health check servers on HealthPort, and reports the highest
+	// count of each host, so that KEDA failing over from one replica
+	// to another doesn't reset the counts
+	PeerService string `envconfig:"KEDA_HTTP_SCALER_PEER_SERVICE" default:""`
+	// FallbackPolicy is what the scaler does when it can't reach any
+	// interceptor. "none" reports the counts it has, which drop to zero
+	// once they're stale. "hold" keeps reporting the last known counts
+	// for FallbackTicks ticks. "replicas" holds them too, then reports
+	// enough pending requests to scale every app to FallbackReplicas
+	FallbackPolicy string `envconfig:"KEDA_HTTP_SCALER_FALLBACK_POLICY" default:"none"`
+	// PartialResultsPolicy is what the scaler does with the counts of
+	// the interceptors that don't answer a tick, while others do.
+	// "drop" leaves them out right away. "hold" keeps their last counts
+	// until they're stale. "extrapolate" leaves them out, and scales up
+	// the counts of the others in their fleet to make up for them
+	PartialResultsPolicy string `envconfig:"KEDA_HTTP_SCALER_PARTIAL_RESULTS_POLICY" default:"hold"`
+	// FallbackTicks is the number of queue ticks without contact
+	// for which the last known counts are held
+	FallbackTicks int `envconfig:"KEDA_HTTP_SCALER_FALLBACK_TICKS" default:"10"`
+	// FallbackReplicas is the number of replicas to scale every app
+	// to when FallbackPolicy is "replicas" and the hold is over
+	FallbackReplicas int `envconfig:"KEDA_HTTP_SCALER_FALLBACK_REPLICAS" default:"1"`
+	// TLSCertFile and TLSKeyFile are the paths to the client certificate
+	// and key that the scaler presents to the interceptors' admin
+	// servers, and TLSCAFile is the path to the CA bundle that their
+	// certificates are verified against. If they're all set, the
+	// scaler requests queue counts over mutual TLS
+	TLSCertFile string `envconfig:"KEDA_HTTP_SCALER_TLS_CERT_FILE" default:""`
+	TLSKeyFile  string `envconfig:"KEDA_HTTP_SCALER_TLS_KEY_FILE" default:""`
+	TLSCAFile   string `envconfig:"KEDA_HTTP_SCALER_TLS_CA_FILE" default:""`
+	// TLSServerName is the name that the interceptors' certificates
+	// must be valid for. The scaler connects to interceptor pods by
+	// IP, so this can't be derived from the address. Defaults to
+	// <service>.<TargetNamespace>.svc for each fleet's service
+	TLSServerName string `envconfig:"KEDA_HTTP_SCALER_TLS_SERVER_NAME" default:""`
+	// TLSReloadInterval is how often all the TLS files, including the
+	// gRPC server's, are checked for changes, so that rotated
+	// certificates are picked up
+	TLSReloadInterval time.Duration `envconfig:"KEDA_HTTP_SCALER_TLS_RELOAD_INTERVAL" default:"1m"`
+	// GRPCTLSCertFile and GRPCTLSKeyFile are the paths to the certificate
+	// and key that the gRPC server presents to KEDA. If they're both set,
+	// the gRPC server serves TLS. Otherwise it serves plaintext
+	GRPCTLSCertFile string `envconfig:"KEDA_HTTP_SCALER_GRPC_TLS_CERT_FILE" default:""`
+	GRPCTLSKeyFile  string `envconfig:"KEDA_HTTP_SCALER_GRPC_TLS_KEY_FILE" default:""`
+	// GRPCTLSCAFile is the path to a CA bundle. If it's set along with
+	// the certificate and key, the gRPC server serves mutual TLS, and
+	// KEDA must present a client certificate signed by this CA
+	GRPCTLSCAFile string `envconfig:"KEDA_HTTP_SCALER_GRPC_TLS_CA_FILE" default:""`
+	// GRPCTLSAllowedSANs is a comma-separated list of DNS names, URIs
+	// and email addresses. If it's not empty, client certificates must
+	// have at least one of them as a SAN. Only used with mutual TLS
+	GRPCTLSAllowedSANs []string `envconfig:"KEDA_HTTP_SCALER_GRPC_TLS_ALLOWED_SANS" default:""`
+	// APIToken is the bearer token that clients must send to use the
+	// metrics API on the health check server. If it's empty, the
+	// metrics API is not served at all
+	APIToken string `envconfig:"KEDA_HTTP_SCALER_API_TOKEN" default:""`
+	// RegistrationToken is the bearer token that interceptors must
+	// send to register themselves with the scaler, like the
+	// interceptors that a namespace runs for itself. If it's empty,
+	// the registration endpoint is not served at all, and only the
+	// interceptors behind the admin services are scraped
+	RegistrationToken string `envconfig:"KEDA_HTTP_SCALER_REGISTRATION_TOKEN" default:""`
+	// PrometheusURL is the URL of a Prometheus server whose
+	// PrometheusQuery results augment the counts from the
+	// interceptors, for traffic that bypasses them. If it's empty,
+	// Prometheus isn't queried at all
+	PrometheusURL string `envconfig:"KEDA_HTTP_SCALER_PROMETHEUS_URL" default:""`
+	// PrometheusQuery is the PromQL query that returns each host's
+	// load, as a vector with a sample per host. Each host's count is
+	// the higher of its count from the interceptors and its sample
+	PrometheusQuery string `envconfig:"KEDA_HTTP_SCALER_PROMETHEUS_QUERY" default:""`
+	// PrometheusHostLabel is the label of PrometheusQuery's samples
+	// that has their host
+	PrometheusHostLabel string `envconfig:"KEDA_HTTP_SCALER_PROMETHEUS_HOST_LABEL" default:"host"`
+	// PrometheusNamespaceLabel is the label of PrometheusQuery's
+	// samples that has their namespace. If it's empty, every sample
+	// is in TargetNamespace
+	PrometheusNamespaceLabel string `envconfig:"KEDA_HTTP_SCALER_PROMETHEUS_NAMESPACE_LABEL" default:""`
+	// PrometheusInterval is how often PrometheusQuery is run. Its
+	// results are dropped once they're three intervals old
+	PrometheusInterval time.Duration `envconfig:"KEDA_HTTP_SCALER_PROMETHEUS_INTERVAL" default:"15s"`
+	// PrometheusTimeout is how long each query may take
+	PrometheusTimeout time.Duration `envconfig:"KEDA_HTTP_SCALER_PROMETHEUS_TIMEOUT" default:"5s"`
+	// WaitSLO is the longest that WaitSLOPercentile percent of the
+	// requests to a host should wait for its backend to be ready,
+	// according to the interceptors' wait histograms. Hosts whose
+	// waits are longer are logged, and their metrics are boosted. 0
+	// means waits aren't watched
+	WaitSLO time.Duration `envconfig:"KEDA_HTTP_SCALER_WAIT_SLO" default:"0"`
+	// WaitSLOPercentile is the percentile of each host's waits that
+	// is held to WaitSLO
+	WaitSLOPercentile int `envconfig:"KEDA_HTTP_SCALER_WAIT_SLO_PERCENTILE" default:"95"`
+	// WaitSLOMaxBoostPercent caps the boost of the metric of a host
+	// whose waits are over WaitSLO, as a percentage of its value. The
+	// metric is boosted by the ratio of the waits to WaitSLO, so the
+	// default of 200 at most doubles it. 100 only logs
+	WaitSLOMaxBoostPercent int `envconfig:"KEDA_HTTP_SCALER_WAIT_SLO_MAX_BOOST_PERCENT" default:"200"`
+}
+
+// tlsEnabled returns true if the scaler should use mutual TLS to
+// request queue counts from the interceptors
+func (c *Config) tlsEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != "" && c.TLSCAFile != ""
+}
+
+// grpcTLSEnabled returns true if the gRPC server should serve TLS
+func (c *Config) grpcTLSEnabled() bool {
+	return c.GRPCTLSCertFile != "" && c.GRPCTLSKeyFile != ""
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"time"
+)
+
+// endpointStatus describes how the requests for queue counts to a
+// single interceptor endpoint have gone
+type endpointStatus struct {
+	// Fleet is the name of the interceptor fleet that the
+	// endpoint belongs to
+	Fleet string `json:"fleet,omitempty"`
+	// FirstSeen is when the endpoint appeared in the endpoints list
+	FirstSeen time.Time `json:"firstSeen"`
+	// LastSuccess is the last time the endpoint returned its counts,
+	// or the zero time if it never has
+	LastSuccess time.Time `json:"lastSuccess,omitempty"`
+	// ConsecutiveFailures is the number of requests in a row that
+	// failed, since the last one that succeeded
+	ConsecutiveFailures int `json:"consecutiveFailures"`
+	// TotalSuccesses and TotalFailures are the number of requests
+	// that succeeded and failed since the endpoint appeared in the
+	// endpoints list
+	TotalSuccesses int `json:"totalSuccesses"`
+	TotalFailures  int `json:"totalFailures"`
+	// LastError is the error from the last request that failed, or
+	// empty if the last request succeeded
+	LastError string `json:"lastError,omitempty"`
+}
+
+// recordEndpoints updates the status of each of q's endpoints, and
+// q's scrapeStats, with results, which were fetched at time now.
+// Endpoints that aren't in liveAddrs anymore are forgotten
+func (q *queuePinger) recordEndpoints(
+	now time.Time,
+	liveAddrs map[string]struct{},
+	results []fetchResult,
+) {
+	lggr := q.lggr.WithName("queuePinger.recordEndpoints")
+	q.pingMut.Lock()
+	defer q.pingMut.Unlock()
+
+	for addr := range q.endpoints {
+		if _, live := liveAddrs[addr]; !live {
+			lggr.Info("interceptor endpoint removed", "interceptorAddress", addr)
+			delete(q.endpoints, addr)
+		}
+	}
+	for addr := range liveAddrs {
+		if _, ok := q.endpoints[addr]; !ok {
+			lggr.Info("interceptor endpoint added", "interceptorAddress", addr)
+			q.endpoints[addr] = &endpointStatus{FirstSeen: now}
+		}
+	}
+	q.lastScrape = scrapeStats{Time: now, Endpoints: len(liveAddrs)}
+	for _, res := range results {
+		if res.err != nil {
+			q.lastScrape.Failed++
+		} else {
+			q.lastScrape.Succeeded++
+		}
+		status, ok := q.endpoints[res.addr]
+		if !ok {
+			continue
+		}
+		status.Fleet = res.fleet
+		if res.err != nil {
+			status.ConsecutiveFailures++
+			status.TotalFailures++
+			status.LastError = res.err.Error()
+			continue
+		}
+		if status.ConsecutiveFailures > 0 {
+			lggr.Info(
+				"interceptor endpoint recovered",
+				"interceptorAddress",
+				res.addr,
+				"failures",
+				status.ConsecutiveFailures,
+			)
+		}
+		status.ConsecutiveFailures = 0
+		status.TotalSuccesses++
+		status.LastSuccess = now
+		status.LastError = ""
+	}
+}
+
+// endpointStatuses returns a copy of the status of each of q's
+// endpoints, keyed by address
+func (q *queuePinger) endpointStatuses() map[string]endpointStatus {
+	q.pingMut.RLock()
+	defer q.pingMut.RUnlock()
+	ret := make(map[string]endpointStatus, len(q.endpoints))
+	for addr, status := range q.endpoints {
+		ret[addr] = *status
+	}
+	return ret
+}
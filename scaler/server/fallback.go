@@ -0,0 +1,119 @@
+package server
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// fallbackNone keeps using the counts the queuePinger has when it
+	// can't reach any interceptor. They drop to zero once they're stale
+	fallbackNone = "none"
+	// fallbackHold keeps the last known counts for a number of ticks
+	// after the queuePinger lost contact with all the interceptors
+	fallbackHold = "hold"
+	// fallbackReplicas holds the last known counts like fallbackHold,
+	// then reports enough pending requests to scale every host to a
+	// fixed number of replicas until contact is back
+	fallbackReplicas = "replicas"
+)
+
+// fallbackPolicy is what the queuePinger does when it can't reach
+// any interceptor, so that KEDA doesn't scale apps to zero under load
+// just because their counts went stale
+type fallbackPolicy struct {
+	mode string
+	// ticks is the number of ticks without contact for which the
+	// last known counts are held
+	ticks int
+	// replicas is the number of replicas to scale every host to
+	// when mode is fallbackReplicas and the hold is over
+	replicas int
+}
+
+// newFallbackPolicy returns the fallbackPolicy that cfg describes.
+// Returns an error if cfg has an unknown fallback mode
+func newFallbackPolicy(cfg *Config) (fallbackPolicy, error) {
+	switch cfg.FallbackPolicy {
+	case fallbackNone, fallbackHold, fallbackReplicas:
+	default:
+		return fallbackPolicy{}, fmt.Errorf(
+			"unknown fallback policy %q",
+			cfg.FallbackPolicy,
+		)
+	}
+	return fallbackPolicy{
+		mode:     cfg.FallbackPolicy,
+		ticks:    cfg.FallbackTicks,
+		replicas: cfg.FallbackReplicas,
+	}, nil
+}
+
+// stalenessStats describes how long the queuePinger has been out of
+// contact with all the interceptors, and what fallback is in effect
+type stalenessStats struct {
+	LastContact      time.Time `json:"lastContact"`
+	StalenessSeconds float64   `json:"stalenessSeconds"`
+	FailedTicks      int       `json:"failedTicks"`
+	// Fallback is the fallback mode that's in effect right now, or
+	// empty if the queuePinger is reporting the counts it has
+	Fallback string `json:"fallback,omitempty"`
+}
+
+// recordContact records whether any interceptor responded to q at
+// time now. Returns true if q should hold on to the counts it has,
+// rather than reconciling them with the missing results.
+func (q *queuePinger) recordContact(now time.Time, contact bool) bool {
+	q.pingMut.Lock()
+	defer q.pingMut.Unlock()
+	if contact {
+		q.failedTicks = 0
+		q.lastContact = now
+		return false
+	}
+	q.failedTicks++
+	if q.fallback.mode == fallbackNone {
+		return false
+	}
+	// the counts won't be recomputed, but the fallback may have
+	// just kicked in, so let StreamIsActive check again
+	close(q.updatedCh)
+	q.updatedCh = make(chan struct{})
+	return q.failedTicks <= q.fallback.ticks
+}
+
+// fallbackReplicas returns the number of replicas to scale every host
+// to, and true, if q's fallback policy says so because q has been out
+// of contact with the interceptors for longer than it holds counts
+func (q *queuePinger) fallbackReplicas() (int, bool) {
+	q.pingMut.RLock()
+	defer q.pingMut.RUnlock()
+	if q.fallback.mode != fallbackReplicas ||
+		q.failedTicks <= q.fallback.ticks {
+		return 0, false
+	}
+	return q.fallback.replicas, true
+}
+
+// staleness returns q's stalenessStats at time now
+func (q *queuePinger) staleness(now time.Time) stalenessStats {
+	q.pingMut.RLock()
+	defer q.pingMut.RUnlock()
+	ret := stalenessStats{
+		LastContact:      q.lastContact,
+		StalenessSeconds: now.Sub(q.lastContact).Seconds(),
+		FailedTicks:      q.failedTicks,
+	}
+	if q.failedTicks > 0 && q.fallback.mode != fallbackNone {
+		ret.Fallback = fallbackHold
+		if q.failedTicks > q.fallback.ticks {
+			// after the hold, fallbackHold falls back
+			// to reporting the counts it has
+			ret.Fallback = ""
+			if q.fallback.mode == fallbackReplicas {
+				ret.Fallback = fallbackReplicas
+			}
+		}
+	}
+	return ret
+}
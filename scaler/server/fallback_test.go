@@ -0,0 +1,148 @@
+package server
+
+import (
+	context "context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/k8s"
+	"github.com/kedacore/http-add-on/pkg/queue"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	externalscaler "github.com/kedacore/http-add-on/proto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewFallbackPolicy(t *testing.T) {
+	r := require.New(t)
+	policy, err := newFallbackPolicy(&Config{
+		FallbackPolicy:   fallbackReplicas,
+		FallbackTicks:    3,
+		FallbackReplicas: 2,
+	})
+	r.NoError(err)
+	r.Equal(fallbackPolicy{mode: fallbackReplicas, ticks: 3, replicas: 2}, policy)
+
+	_, err = newFallbackPolicy(&Config{FallbackPolicy: "bogus"})
+	r.Error(err)
+}
+
+// newUnreachablePinger returns a queuePinger whose only interceptor
+// fails every request for counts, and which had counts of 10 for
+// host from that interceptor a minute ago
+func newUnreachablePinger(
+	t *testing.T,
+	host string,
+	fallback fallbackPolicy,
+) (*time.Ticker, *queuePinger) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	}))
+	t.Cleanup(srv.Close)
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	ticker, pinger := newFakeQueuePinger(
+		context.Background(),
+		logr.Discard(),
+		func(opts *fakeQueuePingerOpts) { opts.endpoints = k8s.FakeEndpointsForURL(u, "testns", "testsvc", 1) },
+		func(opts *fakeQueuePingerOpts) { opts.tickDur = 10000 * time.Hour },
+		func(opts *fakeQueuePingerOpts) { opts.port = u.Port() },
+		func(opts *fakeQueuePingerOpts) { opts.fallback = fallback },
+	)
+	counts := queue.NewCounts()
+	counts.Counts[host] = 10
+	pinger.reconcile(
+		time.Now().Add(-time.Minute),
+		map[string]struct{}{u.Host: {}},
+		[]fetchResult{{addr: u.Host, counts: counts}},
+	)
+	return ticker, pinger
+}
+
+func TestFallbackNone(t *testing.T) {
+	const host = "TestFallbackNone.testing"
+	r := require.New(t)
+	ticker, pinger := newUnreachablePinger(t, host, fallbackPolicy{mode: fallbackNone})
+	defer ticker.Stop()
+
+	// the counts are stale, so they're dropped right away
+	r.Error(pinger.requestCounts(context.Background()))
+	r.Equal(0, pinger.counts()[host])
+	_, ok := pinger.fallbackReplicas()
+	r.False(ok)
+	stats := pinger.staleness(time.Now())
+	r.Equal(1, stats.FailedTicks)
+	r.Empty(stats.Fallback)
+}
+
+func TestFallbackHold(t *testing.T) {
+	const host = "TestFallbackHold.testing"
+	r := require.New(t)
+	ticker, pinger := newUnreachablePinger(
+		t,
+		host,
+		fallbackPolicy{mode: fallbackHold, ticks: 2},
+	)
+	defer ticker.Stop()
+
+	// the last known counts are held for 2 ticks
+	for i := 1; i <= 2; i++ {
+		r.Error(pinger.requestCounts(context.Background()))
+		r.Equal(10, pinger.counts()[host])
+		stats := pinger.staleness(time.Now())
+		r.Equal(i, stats.FailedTicks)
+		r.Equal(fallbackHold, stats.Fallback)
+	}
+	// then dropped
+	r.Error(pinger.requestCounts(context.Background()))
+	r.Equal(0, pinger.counts()[host])
+	r.Empty(pinger.staleness(time.Now()).Fallback)
+	_, ok := pinger.fallbackReplicas()
+	r.False(ok)
+}
+
+func TestFallbackReplicas(t *testing.T) {
+	const host = "TestFallbackReplicas.testing"
+	r := require.New(t)
+	ctx := context.Background()
+	ticker, pinger := newUnreachablePinger(
+		t,
+		host,
+		fallbackPolicy{mode: fallbackReplicas, ticks: 1, replicas: 3},
+	)
+	defer ticker.Stop()
+	hdl := newImpl(logr.Discard(), pinger, routing.NewTable(), 123, 200)
+	sor := &externalscaler.ScaledObjectRef{
+		ScalerMetadata: map[string]string{
+			"host":                  host,
+			"targetPendingRequests": "50",
+		},
+	}
+
+	// the counts are held first
+	r.Error(pinger.requestCounts(ctx))
+	res, err := hdl.GetMetrics(ctx, &externalscaler.GetMetricsRequest{ScaledObjectRef: sor})
+	r.NoError(err)
+	r.Equal(int64(10), res.MetricValues[0].MetricValue)
+
+	// then the fallback reports enough to scale to 3 replicas
+	r.Error(pinger.requestCounts(ctx))
+	r.Equal(fallbackReplicas, pinger.staleness(time.Now()).Fallback)
+	res, err = hdl.GetMetrics(ctx, &externalscaler.GetMetricsRequest{ScaledObjectRef: sor})
+	r.NoError(err)
+	r.Equal(int64(150), res.MetricValues[0].MetricValue)
+	active, err := hdl.IsActive(ctx, sor)
+	r.NoError(err)
+	r.True(active.Result)
+
+	// once an interceptor responds again, the fallback is over
+	pinger.recordContact(time.Now(), true)
+	_, ok := pinger.fallbackReplicas()
+	r.False(ok)
+	stats := pinger.staleness(time.Now())
+	r.Equal(0, stats.FailedTicks)
+	r.Empty(stats.Fallback)
+}
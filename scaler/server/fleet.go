@@ -0,0 +1,85 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+)
+
+// interceptorFleet is a set of interceptors behind a single admin
+// Service, like the interceptors in one zone of a large cluster. The
+// queuePinger merges the counts of all of its fleets
+type interceptorFleet struct {
+	// name identifies the fleet in the counts that the
+	// scaler reports
+	name    string
+	svcName string
+	adminCl adminClient
+}
+
+// parseInterceptorFleets returns the interceptor fleets that cfg
+// describes, without their adminClients. Each entry of
+// KEDA_HTTP_SCALER_TARGET_ADMIN_SERVICES is either <name>=<service> or
+// just <service>, in which case the fleet is named after its Service.
+// If there are none, there's a single fleet behind
+// KEDA_HTTP_SCALER_TARGET_ADMIN_SERVICE. Returns an error if there's
+// no Service at all, unless interceptors can register themselves
+// instead, or if two fleets have the same name or Service
+func parseInterceptorFleets(cfg *Config) ([]interceptorFleet, error) {
+	entries := []string{}
+	for _, entry := range cfg.TargetServices {
+		if strings.TrimSpace(entry) != "" {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) == 0 && cfg.TargetService != "" {
+		entries = []string{cfg.TargetService}
+	}
+	if len(entries) == 0 && cfg.RegistrationToken != "" {
+		return []interceptorFleet{}, nil
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf(
+			"one of KEDA_HTTP_SCALER_TARGET_ADMIN_SERVICE or KEDA_HTTP_SCALER_TARGET_ADMIN_SERVICES must be set",
+		)
+	}
+	ret := make([]interceptorFleet, 0, len(entries))
+	names := map[string]struct{}{}
+	svcs := map[string]struct{}{}
+	for _, entry := range entries {
+		name, svc := entry, entry
+		if idx := strings.Index(entry, "="); idx >= 0 {
+			name, svc = entry[:idx], entry[idx+1:]
+		}
+		name, svc = strings.TrimSpace(name), strings.TrimSpace(svc)
+		if name == "" || svc == "" {
+			return nil, fmt.Errorf("invalid interceptor admin service %q", entry)
+		}
+		if _, ok := names[name]; ok {
+			return nil, fmt.Errorf("duplicate interceptor fleet name %q", name)
+		}
+		if _, ok := svcs[svc]; ok {
+			return nil, fmt.Errorf("duplicate interceptor admin service %q", svc)
+		}
+		names[name] = struct{}{}
+		svcs[svc] = struct{}{}
+		ret = append(ret, interceptorFleet{name: name, svcName: svc})
+	}
+	return ret, nil
+}
+
+// newInterceptorFleets returns the interceptor fleets that cfg
+// describes, each with an adminClient for its Service
+func newInterceptorFleets(cfg *Config) ([]interceptorFleet, error) {
+	ret, err := parseInterceptorFleets(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for idx := range ret {
+		adminCl, err := newAdminClient(cfg, ret[idx].svcName)
+		if err != nil {
+			return nil, err
+		}
+		ret[idx].adminCl = adminCl
+	}
+	return ret, nil
+}
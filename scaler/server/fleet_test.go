@@ -0,0 +1,45 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseInterceptorFleets(t *testing.T) {
+	r := require.New(t)
+
+	// without a list, there's a single fleet named after its service
+	fleets, err := parseInterceptorFleets(&Config{TargetService: "interceptor-admin"})
+	r.NoError(err)
+	r.Equal([]interceptorFleet{
+		{name: "interceptor-admin", svcName: "interceptor-admin"},
+	}, fleets)
+
+	// the list takes precedence over the single service
+	fleets, err = parseInterceptorFleets(&Config{
+		TargetService:  "interceptor-admin",
+		TargetServices: []string{"zone-a=admin-a", " admin-b ", ""},
+	})
+	r.NoError(err)
+	r.Equal([]interceptorFleet{
+		{name: "zone-a", svcName: "admin-a"},
+		{name: "admin-b", svcName: "admin-b"},
+	}, fleets)
+
+	for _, services := range [][]string{
+		{},
+		{"zone-a="},
+		{"=admin-a"},
+		{"zone-a=admin-a", "zone-a=admin-b"},
+		{"zone-a=admin-a", "zone-b=admin-a"},
+	} {
+		_, err := parseInterceptorFleets(&Config{TargetServices: services})
+		r.Error(err, "services %v", services)
+	}
+
+	// interceptors that register themselves don't need a service
+	fleets, err = parseInterceptorFleets(&Config{RegistrationToken: "secret"})
+	r.NoError(err)
+	r.Empty(fleets)
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"crypto/tls"
+
+	kedatls "github.com/kedacore/http-add-on/pkg/tls"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// grpcServerOptions returns the options for the gRPC server that
+// serve TLS or mutual TLS, as cfg describes, or none if cfg doesn't
+// enable TLS. Returns an error if the TLS files couldn't be loaded
+func grpcServerOptions(cfg *Config) ([]grpc.ServerOption, error) {
+	if !cfg.grpcTLSEnabled() {
+		return nil, nil
+	}
+	certs, err := kedatls.NewCertReloader(
+		cfg.GRPCTLSCertFile,
+		cfg.GRPCTLSKeyFile,
+		cfg.GRPCTLSCAFile,
+		cfg.TLSReloadInterval,
+	)
+	if err != nil {
+		return nil, err
+	}
+	clientAuth := tls.NoClientCert
+	if cfg.GRPCTLSCAFile != "" {
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+	tlsCfg := certs.ServerConfig(clientAuth)
+	if clientAuth != tls.NoClientCert && len(cfg.GRPCTLSAllowedSANs) > 0 {
+		tlsCfg.VerifyConnection = kedatls.VerifyClientSANs(cfg.GRPCTLSAllowedSANs)
+	}
+	return []grpc.ServerOption{
+		grpc.Creds(credentials.NewTLS(tlsCfg)),
+	}, nil
+}
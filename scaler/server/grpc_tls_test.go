@@ -0,0 +1,20 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGRPCServerOptions(t *testing.T) {
+	r := require.New(t)
+	opts, err := grpcServerOptions(&Config{})
+	r.NoError(err)
+	r.Empty(opts)
+
+	_, err = grpcServerOptions(&Config{
+		GRPCTLSCertFile: "/nonexistent/tls.crt",
+		GRPCTLSKeyFile:  "/nonexistent/tls.key",
+	})
+	r.Error(err)
+}
@@ -0,0 +1,628 @@
+// Handlers contains the gRPC implementation for an external scaler as defined
+// by the KEDA documentation at https://keda.sh/docs/2.0/concepts/external-scalers/#built-in-scalers-interface
+// This is the interface KEDA will poll in order to get the request queue size
+// and scale user apps properly
+package server
+
+import (
+	context "context"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	empty "github.com/golang/protobuf/ptypes/empty"
+	"github.com/kedacore/http-add-on/pkg/queue"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	externalscaler "github.com/kedacore/http-add-on/proto"
+)
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
+
+const (
+	// breakdownMetricsKey is the ScaledObject metadata key that, when
+	// set to true, makes the scaler report the active and pending
+	// parts of a host's count as metrics of their own, alongside the
+	// host's total count
+	breakdownMetricsKey = "breakdownMetrics"
+	// activeMetricSuffix and pendingMetricSuffix are appended to a
+	// host to get the names of its breakdown metrics
+	activeMetricSuffix  = "-active"
+	pendingMetricSuffix = "-pending"
+	// scalingMetricKey is the ScaledObject metadata key that selects
+	// what a host's metric counts. See the scalingMetric* constants
+	scalingMetricKey = "scalingMetric"
+	// scalingMetricRequests makes a host's metric count its pending
+	// and in-flight requests. It's the default
+	scalingMetricRequests = "requests"
+	// scalingMetricActiveConnections makes a host's metric count the
+	// client connections that are open to it
+	scalingMetricActiveConnections = "activeConnections"
+	// scalingMetricRequestsAwaitingResponse makes a host's metric
+	// count its requests that haven't got their response headers
+	// yet, leaving out the ones that are streaming their responses
+	scalingMetricRequestsAwaitingResponse = "requestsAwaitingResponse"
+	// scalingBehaviorKey is the ScaledObject metadata key with the
+	// metric type of the ScaledObject's trigger. KEDA reads the
+	// trigger's metricType itself, so the scaler only validates it
+	scalingBehaviorKey = "scalingBehavior"
+	// scalingBehaviorAverageValue targets a host's metric per replica.
+	// It's the default
+	scalingBehaviorAverageValue = "AverageValue"
+	// scalingBehaviorValue targets a host's metric across all replicas
+	scalingBehaviorValue = "Value"
+	// hostsKey is the ScaledObject metadata key with a comma-separated
+	// list of more hosts whose counts are added to the host's, for
+	// workloads that serve more than one host. The host's settings,
+	// like its target, apply to the total
+	hostsKey = "hosts"
+	// anyTrafficHost is the synthetic host whose counts are the total
+	// of every host in the ScaledObject's namespace, or of only the
+	// hosts in its hostsKey if it has any, so that a workload that
+	// serves many hosts is active as soon as any of them has traffic
+	anyTrafficHost = "__pending__"
+)
+
+type impl struct {
+	lggr                    logr.Logger
+	pinger                  *queuePinger
+	routingTable            routing.TableReader
+	smoother                *metricSmoother
+	waitSLO                 *waitSLO
+	targetMetric            int64
+	targetMetricInterceptor int64
+	externalscaler.UnimplementedExternalScalerServer
+}
+
+func newImpl(
+	lggr logr.Logger,
+	pinger *queuePinger,
+	routingTable routing.TableReader,
+	defaultTargetMetric int64,
+	defaultTargetMetricInterceptor int64,
+) *impl {
+	return &impl{
+		lggr:                    lggr,
+		pinger:                  pinger,
+		routingTable:            routingTable,
+		smoother:                newMetricSmoother(),
+		targetMetric:            defaultTargetMetric,
+		targetMetricInterceptor: defaultTargetMetricInterceptor,
+	}
+}
+
+func (e *impl) Ping(context.Context, *empty.Empty) (*empty.Empty, error) {
+	return &empty.Empty{}, nil
+}
+
+func (e *impl) IsActive(
+	ctx context.Context,
+	scaledObject *externalscaler.ScaledObjectRef,
+) (*externalscaler.IsActiveResponse, error) {
+	lggr := e.lggr.WithName("IsActive")
+	host, ok := scaledObject.ScalerMetadata["host"]
+	if !ok {
+		err := fmt.Errorf("no 'host' field found in ScaledObject metadata")
+		lggr.Error(err, "returning immediately from IsActive RPC call", "ScaledObject", scaledObject)
+		return nil, err
+	}
+	if host == "interceptor" {
+		return &externalscaler.IsActiveResponse{
+			Result: true,
+		}, nil
+	}
+	if replicas, ok := e.pausedReplicas(scaledObject.Namespace, host); ok {
+		return &externalscaler.IsActiveResponse{
+			Result: replicas > 0,
+		}, nil
+	}
+	// the fallback scales every host to some replicas,
+	// so none of them may be scaled to zero
+	if _, ok := e.pinger.fallbackReplicas(); ok {
+		return &externalscaler.IsActiveResponse{
+			Result: true,
+		}, nil
+	}
+	hostCount, hostBreakdown, ok := e.hostCounts(
+		scaledObject.Namespace,
+		host,
+		scaledObject.ScalerMetadata,
+	)
+	if !ok {
+		err := fmt.Errorf("host '%s' not found in counts", host)
+		lggr.Error(err, "Given host was not found in queue count map", "host", host, "allCounts", e.pinger.counts())
+		return nil, err
+	}
+	metric, err := scalingMetric(scaledObject.ScalerMetadata)
+	if err != nil {
+		lggr.Error(err, "invalid scaling metric", "host", host)
+		return nil, err
+	}
+	active := hostCount > 0
+	if metric == scalingMetricActiveConnections {
+		// a connection with no request in flight
+		// still needs the host's workload
+		active = active || hostBreakdown.Connections > 0
+	}
+	if !active && e.inScaledownPeriod(scaledObject.Namespace, host, hostBreakdown) {
+		active = true
+	}
+	return &externalscaler.IsActiveResponse{
+		Result: active,
+	}, nil
+}
+
+// StreamIsActive pushes the active state of scaledObject's host to
+// KEDA every time it changes. KEDA scales the host's app from zero as
+// soon as it receives a true value, rather than waiting for its next
+// poll of IsActive.
+//
+// The state is re-checked every time the queue pinger fetches new
+// counts from the interceptors.
+func (e *impl) StreamIsActive(
+	scaledObject *externalscaler.ScaledObjectRef,
+	server externalscaler.ExternalScaler_StreamIsActiveServer,
+) error {
+	lggr := e.lggr.WithName("StreamIsActive")
+	ctx := server.Context()
+	sentAny := false
+	lastActive := false
+	for {
+		// get the update channel before checking the active
+		// state, so that no update is missed in between
+		updated := e.pinger.updated()
+		active, err := e.IsActive(ctx, scaledObject)
+		if err != nil {
+			lggr.Error(
+				err,
+				"error getting active status in stream, continuing",
+			)
+		} else if !sentAny || active.Result != lastActive {
+			if err := server.Send(&externalscaler.IsActiveResponse{
+				Result: active.Result,
+			}); err != nil {
+				lggr.Error(err, "sending active status in stream")
+				return err
+			}
+			sentAny = true
+			lastActive = active.Result
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-updated:
+		}
+	}
+}
+
+func (e *impl) GetMetricSpec(
+	_ context.Context,
+	sor *externalscaler.ScaledObjectRef,
+) (*externalscaler.GetMetricSpecResponse, error) {
+	lggr := e.lggr.WithName("GetMetricSpec")
+	host, ok := sor.ScalerMetadata["host"]
+	if !ok {
+		err := fmt.Errorf("'host' not found in ScaledObject metadata")
+		lggr.Error(err, "no 'host' found in ScaledObject metadata")
+		return nil, err
+	}
+	var targetPendingRequests int64
+	if host == "interceptor" {
+		targetPendingRequests = e.targetMetricInterceptor
+	} else {
+		target, err := e.targetPendingRequests(sor.Namespace, host, sor.ScalerMetadata)
+		if err != nil {
+			lggr.Error(
+				err,
+				"error getting target for host",
+				"host",
+				host,
+			)
+			return nil, err
+		}
+		targetPendingRequests = target
+	}
+	name := metricName(sor.Namespace, host)
+	metricSpecs := []*externalscaler.MetricSpec{
+		{
+			MetricName: name,
+			TargetSize: targetPendingRequests,
+		},
+	}
+	if host != "interceptor" {
+		if _, err := scalingBehavior(sor.ScalerMetadata); err != nil {
+			lggr.Error(err, "error getting scaling behavior", "host", host)
+			return nil, err
+		}
+		breakdown, err := breakdownMetricsEnabled(sor.ScalerMetadata)
+		if err != nil {
+			lggr.Error(err, "error getting breakdown metrics setting", "host", host)
+			return nil, err
+		}
+		// the active and pending counts are each at most the
+		// total count, so the HPA, which scales on the largest
+		// of its metrics, scales the same as it would without them
+		if breakdown {
+			metricSpecs = append(
+				metricSpecs,
+				&externalscaler.MetricSpec{
+					MetricName: name + activeMetricSuffix,
+					TargetSize: targetPendingRequests,
+				},
+				&externalscaler.MetricSpec{
+					MetricName: name + pendingMetricSuffix,
+					TargetSize: targetPendingRequests,
+				},
+			)
+		}
+	}
+
+	return &externalscaler.GetMetricSpecResponse{
+		MetricSpecs: metricSpecs,
+	}, nil
+}
+
+// breakdownMetricsEnabled returns true if metadata asks for the active
+// and pending breakdown metrics
+func breakdownMetricsEnabled(metadata map[string]string) (bool, error) {
+	val, ok := metadata[breakdownMetricsKey]
+	if !ok || val == "" {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(val)
+	if err != nil {
+		return false, fmt.Errorf(
+			"invalid '%s' value %q in ScaledObject metadata (%w)",
+			breakdownMetricsKey,
+			val,
+			err,
+		)
+	}
+	return enabled, nil
+}
+
+// scalingMetric returns the scaling metric that metadata selects,
+// or scalingMetricRequests if it doesn't select one
+func scalingMetric(metadata map[string]string) (string, error) {
+	switch metric := metadata[scalingMetricKey]; metric {
+	case "", scalingMetricRequests:
+		return scalingMetricRequests, nil
+	case scalingMetricActiveConnections, scalingMetricRequestsAwaitingResponse:
+		return metric, nil
+	default:
+		return "", fmt.Errorf(
+			"invalid '%s' value %q in ScaledObject metadata",
+			scalingMetricKey,
+			metric,
+		)
+	}
+}
+
+// scalingBehavior returns the scaling behavior that metadata selects,
+// or scalingBehaviorAverageValue if it doesn't select one
+func scalingBehavior(metadata map[string]string) (string, error) {
+	switch behavior := metadata[scalingBehaviorKey]; behavior {
+	case "", scalingBehaviorAverageValue:
+		return scalingBehaviorAverageValue, nil
+	case scalingBehaviorValue:
+		return behavior, nil
+	default:
+		return "", fmt.Errorf(
+			"invalid '%s' value %q in ScaledObject metadata",
+			scalingBehaviorKey,
+			behavior,
+		)
+	}
+}
+
+// targetPendingRequests returns the target pending requests value for
+// host in namespace ns. It uses the value in the ScaledObject's metadata if there is one,
+// then the value in the routing table, and finally the default
+// e.targetMetric if neither is set
+func (e *impl) targetPendingRequests(
+	ns,
+	host string,
+	metadata map[string]string,
+) (int64, error) {
+	if targetStr, ok := metadata["targetPendingRequests"]; ok && targetStr != "" {
+		target, err := strconv.ParseInt(targetStr, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf(
+				"invalid 'targetPendingRequests' value %q in ScaledObject metadata (%w)",
+				targetStr,
+				err,
+			)
+		}
+		if target > 0 {
+			return target, nil
+		}
+	}
+	if target, err := routing.LookupInNamespace(e.routingTable, ns, host); err == nil && target.TargetPendingRequests > 0 {
+		return int64(target.TargetPendingRequests), nil
+	}
+	return e.targetMetric, nil
+}
+
+func (e *impl) GetMetrics(
+	ctx context.Context,
+	metricRequest *externalscaler.GetMetricsRequest,
+) (*externalscaler.GetMetricsResponse, error) {
+	lggr := e.lggr.WithName("GetMetrics")
+	host, ok := metricRequest.ScaledObjectRef.ScalerMetadata["host"]
+	if !ok {
+		err := fmt.Errorf("no 'host' field found in ScaledObject metadata")
+		lggr.Error(err, "ScaledObjectRef", metricRequest.ScaledObjectRef)
+		return nil, err
+	}
+	sor := metricRequest.ScaledObjectRef
+	suffix, name := requestedMetric(sor.Namespace, host, metricRequest.MetricName)
+	if replicas, ok := e.pausedReplicas(sor.Namespace, host); ok {
+		lggr.V(1).Info(
+			"autoscaling is paused, reporting static metric",
+			"host",
+			host,
+			"replicas",
+			replicas,
+		)
+		return e.replicasMetric(host, name, sor, int(replicas))
+	}
+	if replicas, ok := e.pinger.fallbackReplicas(); ok && host != "interceptor" {
+		lggr.V(1).Info(
+			"lost contact with the interceptors, reporting fallback metric",
+			"host",
+			host,
+			"replicas",
+			replicas,
+		)
+		return e.replicasMetric(host, name, sor, replicas)
+	}
+	// KEDA polls every ScaledObject, so the counts that are too
+	// old are refreshed once for all of the polls at the same time
+	e.pinger.refresh(ctx)
+	hostCount, hostBreakdown, ok := e.hostCounts(sor.Namespace, host, sor.ScalerMetadata)
+	if !ok {
+		if host == "interceptor" {
+			hostCount = e.pinger.aggregate()
+		} else {
+			err := fmt.Errorf("host '%s' not found in counts", host)
+			lggr.Error(err, "allCounts", e.pinger.counts())
+			return nil, err
+		}
+	}
+	metric, err := scalingMetric(sor.ScalerMetadata)
+	if err != nil {
+		lggr.Error(err, "invalid scaling metric", "host", host)
+		return nil, err
+	}
+	switch suffix {
+	case "":
+		switch metric {
+		case scalingMetricActiveConnections:
+			hostCount = hostBreakdown.Connections
+		case scalingMetricRequestsAwaitingResponse:
+			// streaming requests still keep the host
+			// active, but they don't add replicas
+			hostCount -= hostBreakdown.Streaming
+			if hostCount < 0 {
+				hostCount = 0
+			}
+		}
+		if host != "interceptor" {
+			boost := e.waitSLO.boost(
+				time.Now(),
+				queue.NamespacedKey(sor.Namespace, host),
+				hostBreakdown.Waits,
+			)
+			hostCount = int(math.Ceil(float64(hostCount) * boost))
+		}
+	case activeMetricSuffix:
+		hostCount = hostBreakdown.Active
+	case pendingMetricSuffix:
+		hostCount = hostBreakdown.Pending
+	}
+	metricValue := int64(hostCount)
+	if host != "interceptor" {
+		metricValue = e.smoother.smooth(
+			queue.NamespacedKey(sor.Namespace, host+suffix),
+			hostCount,
+			e.smoothingFactorPercent(sor.Namespace, host),
+		)
+	}
+	metricValues := []*externalscaler.MetricValue{
+		{
+			MetricName:  name,
+			MetricValue: metricValue,
+		},
+	}
+	return &externalscaler.GetMetricsResponse{
+		MetricValues: metricValues,
+	}, nil
+}
+
+// requestedMetric returns the breakdown suffix of the metric of host in
+// namespace ns that KEDA asked for with requested, which is empty for
+// the host's total, and the name to report the metric's value under.
+//
+// KEDA may prefix the metric name with the scaler's index, so only the
+// end of requested is matched. HPAs that were made before metric names
+// were sanitized ask for the host itself, plus the suffix, so those
+// names are matched too, and reported back as they were asked for
+func requestedMetric(ns, host, requested string) (string, string) {
+	name := metricName(ns, host)
+	if requested == "" || host == "interceptor" {
+		return "", name
+	}
+	for _, suffix := range []string{activeMetricSuffix, pendingMetricSuffix} {
+		if strings.HasSuffix(requested, name+suffix) {
+			return suffix, name + suffix
+		}
+		if strings.HasSuffix(requested, host+suffix) {
+			return suffix, host + suffix
+		}
+	}
+	if !strings.HasSuffix(requested, name) && strings.HasSuffix(requested, host) {
+		return "", host
+	}
+	return "", name
+}
+
+// hostCounts returns the count and breakdown of host in namespace ns,
+// plus those of the additional hosts in metadata's hostsKey, so that a
+// ScaledObject only sees the counts of its own hosts. Returns false if
+// host itself has no count. The additional hosts may not have any
+// requests yet, so they're only added if they have counts.
+//
+// For anyTrafficHost, it returns the total of the hosts in ns, which
+// is 0 rather than false if none of them has a count
+func (e *impl) hostCounts(
+	ns,
+	host string,
+	metadata map[string]string,
+) (int, queue.HostCounts, bool) {
+	allCounts, breakdown := e.pinger.countsAndBreakdown()
+	if host == anyTrafficHost {
+		count, hostBreakdown := anyTrafficCounts(allCounts, breakdown, ns, metadata)
+		return count, hostBreakdown, true
+	}
+	key := countKey(allCounts, ns, host)
+	count, ok := allCounts[key]
+	if !ok {
+		return 0, queue.HostCounts{}, false
+	}
+	hostBreakdown := breakdown[key]
+	for _, other := range additionalHosts(host, metadata) {
+		otherKey := countKey(allCounts, ns, other)
+		count += allCounts[otherKey]
+		hostBreakdown = hostBreakdown.Add(breakdown[otherKey])
+	}
+	return count, hostBreakdown, true
+}
+
+// anyTrafficCounts returns the total count and breakdown of the hosts
+// in metadata's hostsKey in namespace ns, or of every host in ns if
+// there are none. Counts from interceptors that predate namespaced
+// keys can't be told apart by namespace, so they're only included if
+// their host is listed
+func anyTrafficCounts(
+	allCounts map[string]int,
+	breakdown map[string]queue.HostCounts,
+	ns string,
+	metadata map[string]string,
+) (int, queue.HostCounts) {
+	count := 0
+	hostBreakdown := queue.HostCounts{}
+	if hosts := additionalHosts(anyTrafficHost, metadata); len(hosts) > 0 {
+		for _, host := range hosts {
+			key := countKey(allCounts, ns, host)
+			count += allCounts[key]
+			hostBreakdown = hostBreakdown.Add(breakdown[key])
+		}
+		return count, hostBreakdown
+	}
+	for key, hostCount := range allCounts {
+		if keyNS, _, ok := queue.SplitNamespacedKey(key); !ok || keyNS != ns {
+			continue
+		}
+		count += hostCount
+		hostBreakdown = hostBreakdown.Add(breakdown[key])
+	}
+	return count, hostBreakdown
+}
+
+// countKey returns the key that the counts of host in namespace ns are
+// under in counts. Interceptors namespace their keys, but ones that
+// predate namespaced keys send plain hosts, so those are the fallback
+func countKey(counts map[string]int, ns, host string) string {
+	key := queue.NamespacedKey(ns, host)
+	if _, ok := counts[key]; ok {
+		return key
+	}
+	return host
+}
+
+// additionalHosts returns the hosts in metadata's hostsKey, without
+// host itself and without duplicates
+func additionalHosts(host string, metadata map[string]string) []string {
+	seen := map[string]struct{}{host: {}}
+	ret := []string{}
+	for _, other := range strings.Split(metadata[hostsKey], ",") {
+		other = strings.TrimSpace(other)
+		if _, ok := seen[other]; ok || other == "" {
+			continue
+		}
+		seen[other] = struct{}{}
+		ret = append(ret, other)
+	}
+	return ret
+}
+
+// replicasMetric returns the value of host's metricName metric that
+// makes the HPA scale host's workload to replicas, whatever host's
+// pending requests are
+func (e *impl) replicasMetric(
+	host,
+	metricName string,
+	sor *externalscaler.ScaledObjectRef,
+	replicas int,
+) (*externalscaler.GetMetricsResponse, error) {
+	target, err := e.targetPendingRequests(sor.Namespace, host, sor.ScalerMetadata)
+	if err != nil {
+		e.lggr.Error(err, "error getting target for host", "host", host)
+		return nil, err
+	}
+	return &externalscaler.GetMetricsResponse{
+		MetricValues: []*externalscaler.MetricValue{
+			{
+				MetricName:  metricName,
+				MetricValue: int64(replicas) * target,
+			},
+		},
+	}, nil
+}
+
+// inScaledownPeriod returns true if the last request to host in
+// namespace ns, according to hc, was within the scaledown period of
+// host's target. The age of the last request is as of the last time
+// the interceptors were pinged, so the period may run over by up to a
+// ping interval
+func (e *impl) inScaledownPeriod(ns, host string, hc queue.HostCounts) bool {
+	if hc.LastRequestAgeMS == nil {
+		return false
+	}
+	host = strings.TrimSuffix(host, routing.CanaryQueueKey(""))
+	target, err := routing.LookupInNamespace(e.routingTable, ns, host)
+	if err != nil || target.ScaledownPeriodSeconds <= 0 {
+		return false
+	}
+	return *hc.LastRequestAgeMS < int64(target.ScaledownPeriodSeconds)*1000
+}
+
+// smoothingFactorPercent returns the weight, in percent, of the newest
+// count in the moving average that's reported for host in namespace
+// ns, or 0 if host's counts aren't smoothed. A canary is smoothed like
+// its host
+func (e *impl) smoothingFactorPercent(ns, host string) int32 {
+	host = strings.TrimSuffix(host, routing.CanaryQueueKey(""))
+	target, err := routing.LookupInNamespace(e.routingTable, ns, host)
+	if err != nil {
+		return 0
+	}
+	return target.SmoothingFactorPercent
+}
+
+// pausedReplicas returns the number of replicas that the workload of
+// host in namespace ns is pinned at, and true, if autoscaling is paused
+// for host. The canary of a paused host is paused too
+func (e *impl) pausedReplicas(ns, host string) (int32, bool) {
+	host = strings.TrimSuffix(host, routing.CanaryQueueKey(""))
+	target, err := routing.LookupInNamespace(e.routingTable, ns, host)
+	if err != nil || target.PausedReplicas == nil {
+		return 0, false
+	}
+	return *target.PausedReplicas, true
+}
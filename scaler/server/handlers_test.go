@@ -0,0 +1,939 @@
+package server
+
+import (
+	context "context"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/queue"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	externalscaler "github.com/kedacore/http-add-on/proto"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+func TestIsActive(t *testing.T) {
+	const host = "TestIsActive.testing.com"
+	r := require.New(t)
+	ctx := context.Background()
+	lggr := logr.Discard()
+	table := routing.NewTable()
+	ticker, pinger := newFakeQueuePinger(ctx, lggr)
+	defer ticker.Stop()
+	pinger.pingMut.Lock()
+	pinger.allCounts[host] = 0
+	pinger.pingMut.Unlock()
+
+	hdl := newImpl(
+		lggr,
+		pinger,
+		table,
+		123,
+		200,
+	)
+	res, err := hdl.IsActive(
+		ctx,
+		&externalscaler.ScaledObjectRef{
+			ScalerMetadata: map[string]string{
+				"host": host,
+			},
+		},
+	)
+	r.NoError(err)
+	r.NotNil(res)
+	// initially, IsActive should return false since the
+	// count for the host is 0
+	r.False(res.Result)
+
+	// incrment the count for the host and then expect
+	// active to be true
+	pinger.pingMut.Lock()
+	pinger.allCounts[host]++
+	pinger.pingMut.Unlock()
+	res, err = hdl.IsActive(
+		ctx,
+		&externalscaler.ScaledObjectRef{
+			ScalerMetadata: map[string]string{
+				"host": host,
+			},
+		},
+	)
+	r.NoError(err)
+	r.NotNil(res)
+	r.True(res.Result)
+}
+
+func TestGetMetricSpec(t *testing.T) {
+	const (
+		host   = "abcd"
+		target = int64(200)
+	)
+	r := require.New(t)
+	ctx := context.Background()
+	lggr := logr.Discard()
+	table := routing.NewTable()
+	table.AddTarget(host, routing.NewTarget(
+		"testsrv",
+		8080,
+		"testdepl",
+		int32(target),
+	))
+	ticker, pinger := newFakeQueuePinger(ctx, lggr)
+	defer ticker.Stop()
+	hdl := newImpl(lggr, pinger, table, 123, 200)
+	meta := map[string]string{
+		"host":                  host,
+		"targetPendingRequests": strconv.Itoa(int(target)),
+	}
+	ref := &externalscaler.ScaledObjectRef{
+		ScalerMetadata: meta,
+	}
+	ret, err := hdl.GetMetricSpec(ctx, ref)
+	r.NoError(err)
+	r.NotNil(ret)
+	r.Equal(1, len(ret.MetricSpecs))
+	spec := ret.MetricSpecs[0]
+	r.Equal(metricName("", host), spec.MetricName)
+	r.Equal(target, spec.TargetSize)
+}
+
+// GetMetricSpec should fall back to the routing table and then to
+// the default target when the ScaledObject metadata doesn't have
+// a targetPendingRequests value
+func TestGetMetricSpecTargetFallback(t *testing.T) {
+	const (
+		host          = "abcd"
+		routingTarget = int64(50)
+		defaultTarget = int64(123)
+	)
+	r := require.New(t)
+	ctx := context.Background()
+	lggr := logr.Discard()
+	table := routing.NewTable()
+	ticker, pinger := newFakeQueuePinger(ctx, lggr)
+	defer ticker.Stop()
+	hdl := newImpl(lggr, pinger, table, defaultTarget, 200)
+	ref := &externalscaler.ScaledObjectRef{
+		ScalerMetadata: map[string]string{"host": host},
+	}
+
+	// the host isn't in the routing table yet
+	ret, err := hdl.GetMetricSpec(ctx, ref)
+	r.NoError(err)
+	r.Equal(defaultTarget, ret.MetricSpecs[0].TargetSize)
+
+	table.AddTarget(host, routing.NewTarget(
+		"testsrv",
+		8080,
+		"testdepl",
+		int32(routingTarget),
+	))
+	ret, err = hdl.GetMetricSpec(ctx, ref)
+	r.NoError(err)
+	r.Equal(routingTarget, ret.MetricSpecs[0].TargetSize)
+
+	// an invalid value in the metadata is an error
+	ref.ScalerMetadata["targetPendingRequests"] = "notanumber"
+	_, err = hdl.GetMetricSpec(ctx, ref)
+	r.Error(err)
+}
+
+// GetMetricSpec should accept either scaling behavior, since the
+// target is the same for both, and reject anything else
+func TestGetMetricSpecScalingBehavior(t *testing.T) {
+	const host = "TestGetMetricSpecScalingBehavior.testing"
+	r := require.New(t)
+	ctx := context.Background()
+	lggr := logr.Discard()
+	ticker, pinger := newFakeQueuePinger(ctx, lggr)
+	defer ticker.Stop()
+	hdl := newImpl(lggr, pinger, routing.NewTable(), 123, 200)
+	ref := &externalscaler.ScaledObjectRef{
+		ScalerMetadata: map[string]string{"host": host},
+	}
+	for _, behavior := range []string{
+		"",
+		scalingBehaviorAverageValue,
+		scalingBehaviorValue,
+	} {
+		ref.ScalerMetadata[scalingBehaviorKey] = behavior
+		ret, err := hdl.GetMetricSpec(ctx, ref)
+		r.NoError(err, behavior)
+		r.Equal(int64(123), ret.MetricSpecs[0].TargetSize)
+	}
+
+	ref.ScalerMetadata[scalingBehaviorKey] = "Utilization"
+	_, err := hdl.GetMetricSpec(ctx, ref)
+	r.Error(err)
+}
+
+func TestPausedHost(t *testing.T) {
+	const host = "TestPausedHost.testing"
+	r := require.New(t)
+	ctx := context.Background()
+	lggr := logr.Discard()
+	table := routing.NewTable()
+	ticker, pinger := newFakeQueuePinger(ctx, lggr)
+	defer ticker.Stop()
+	pinger.pingMut.Lock()
+	pinger.allCounts[host] = 1000
+	pinger.allCounts[routing.CanaryQueueKey(host)] = 1000
+	pinger.pingMut.Unlock()
+	hdl := newImpl(lggr, pinger, table, 123, 200)
+
+	target := routing.NewTarget("testsvc", 8080, "testdepl", 50)
+	paused := int32(3)
+	target.PausedReplicas = &paused
+	r.NoError(table.AddTarget(host, target))
+
+	// the metric pins the host and its canary at the paused
+	// replicas, whatever their pending requests are
+	for _, key := range []string{host, routing.CanaryQueueKey(host)} {
+		sor := &externalscaler.ScaledObjectRef{
+			ScalerMetadata: map[string]string{
+				"host":                  key,
+				"targetPendingRequests": "50",
+			},
+		}
+		res, err := hdl.GetMetrics(ctx, &externalscaler.GetMetricsRequest{
+			ScaledObjectRef: sor,
+		})
+		r.NoError(err)
+		r.Equal(int64(150), res.MetricValues[0].MetricValue)
+		active, err := hdl.IsActive(ctx, sor)
+		r.NoError(err)
+		r.True(active.Result)
+	}
+
+	// paused at zero replicas, the host is inactive
+	paused = 0
+	active, err := hdl.IsActive(ctx, &externalscaler.ScaledObjectRef{
+		ScalerMetadata: map[string]string{"host": host},
+	})
+	r.NoError(err)
+	r.False(active.Result)
+}
+
+func TestBreakdownMetrics(t *testing.T) {
+	const host = "TestBreakdownMetrics.testing"
+	r := require.New(t)
+	ctx := context.Background()
+	lggr := logr.Discard()
+	ticker, pinger := newFakeQueuePinger(ctx, lggr)
+	defer ticker.Stop()
+	counts := queue.NewCounts()
+	counts.Counts[host] = 10
+	counts.Hosts[host] = queue.HostCounts{Active: 7, Pending: 3, OldestPendingAgeMS: 100}
+	pinger.reconcile(
+		time.Now(),
+		map[string]struct{}{"1.2.3.4:8080": {}},
+		[]fetchResult{{addr: "1.2.3.4:8080", counts: counts}},
+	)
+	hdl := newImpl(lggr, pinger, routing.NewTable(), 123, 200)
+
+	// the breakdown metrics are opt-in
+	sor := &externalscaler.ScaledObjectRef{
+		ScalerMetadata: map[string]string{"host": host},
+	}
+	spec, err := hdl.GetMetricSpec(ctx, sor)
+	r.NoError(err)
+	r.Equal(1, len(spec.MetricSpecs))
+
+	sor.ScalerMetadata[breakdownMetricsKey] = "notabool"
+	_, err = hdl.GetMetricSpec(ctx, sor)
+	r.Error(err)
+
+	sor.ScalerMetadata[breakdownMetricsKey] = "true"
+	spec, err = hdl.GetMetricSpec(ctx, sor)
+	r.NoError(err)
+	r.Equal(3, len(spec.MetricSpecs))
+	name := metricName("", host)
+	r.Equal(name, spec.MetricSpecs[0].MetricName)
+	r.Equal(name+activeMetricSuffix, spec.MetricSpecs[1].MetricName)
+	r.Equal(name+pendingMetricSuffix, spec.MetricSpecs[2].MetricName)
+
+	expected := map[string]int64{
+		name:                      10,
+		name + activeMetricSuffix: 7,
+		// KEDA may prefix metric names with the scaler's index
+		"s0-" + name + pendingMetricSuffix: 3,
+		// HPAs from before metric names were sanitized
+		// still get their values
+		host:                               10,
+		host + activeMetricSuffix:          7,
+		"s0-" + host + pendingMetricSuffix: 3,
+	}
+	for metricName, val := range expected {
+		res, err := hdl.GetMetrics(ctx, &externalscaler.GetMetricsRequest{
+			ScaledObjectRef: sor,
+			MetricName:      metricName,
+		})
+		r.NoError(err)
+		r.Equal(1, len(res.MetricValues))
+		r.Equal(val, res.MetricValues[0].MetricValue, metricName)
+		r.Equal(strings.TrimPrefix(metricName, "s0-"), res.MetricValues[0].MetricName)
+	}
+}
+
+func TestActiveConnectionsMetric(t *testing.T) {
+	const host = "TestActiveConnectionsMetric.testing"
+	r := require.New(t)
+	ctx := context.Background()
+	lggr := logr.Discard()
+	ticker, pinger := newFakeQueuePinger(ctx, lggr)
+	defer ticker.Stop()
+	// the host has long-lived connections open,
+	// but no requests in flight
+	counts := queue.NewCounts()
+	counts.Counts[host] = 0
+	counts.Hosts[host] = queue.HostCounts{Connections: 4}
+	pinger.reconcile(
+		time.Now(),
+		map[string]struct{}{"1.2.3.4:8080": {}},
+		[]fetchResult{{addr: "1.2.3.4:8080", counts: counts}},
+	)
+	hdl := newImpl(lggr, pinger, routing.NewTable(), 123, 200)
+
+	sor := &externalscaler.ScaledObjectRef{
+		ScalerMetadata: map[string]string{"host": host},
+	}
+	res, err := hdl.GetMetrics(ctx, &externalscaler.GetMetricsRequest{ScaledObjectRef: sor})
+	r.NoError(err)
+	r.Equal(int64(0), res.MetricValues[0].MetricValue)
+	active, err := hdl.IsActive(ctx, sor)
+	r.NoError(err)
+	r.False(active.Result)
+
+	sor.ScalerMetadata[scalingMetricKey] = scalingMetricActiveConnections
+	res, err = hdl.GetMetrics(ctx, &externalscaler.GetMetricsRequest{ScaledObjectRef: sor})
+	r.NoError(err)
+	r.Equal(int64(4), res.MetricValues[0].MetricValue)
+	active, err = hdl.IsActive(ctx, sor)
+	r.NoError(err)
+	r.True(active.Result)
+
+	sor.ScalerMetadata[scalingMetricKey] = "bogus"
+	_, err = hdl.GetMetrics(ctx, &externalscaler.GetMetricsRequest{ScaledObjectRef: sor})
+	r.Error(err)
+}
+
+func TestRequestsAwaitingResponseMetric(t *testing.T) {
+	const host = "TestRequestsAwaitingResponseMetric.testing"
+	r := require.New(t)
+	ctx := context.Background()
+	lggr := logr.Discard()
+	ticker, pinger := newFakeQueuePinger(ctx, lggr)
+	defer ticker.Stop()
+	// 3 of the host's 5 requests are streaming
+	// their responses
+	counts := queue.NewCounts()
+	counts.Counts[host] = 5
+	counts.Hosts[host] = queue.HostCounts{Active: 4, Pending: 1, Streaming: 3}
+	pinger.reconcile(
+		time.Now(),
+		map[string]struct{}{"1.2.3.4:8080": {}},
+		[]fetchResult{{addr: "1.2.3.4:8080", counts: counts}},
+	)
+	hdl := newImpl(lggr, pinger, routing.NewTable(), 123, 200)
+
+	sor := &externalscaler.ScaledObjectRef{
+		ScalerMetadata: map[string]string{"host": host},
+	}
+	res, err := hdl.GetMetrics(ctx, &externalscaler.GetMetricsRequest{ScaledObjectRef: sor})
+	r.NoError(err)
+	r.Equal(int64(5), res.MetricValues[0].MetricValue)
+
+	sor.ScalerMetadata[scalingMetricKey] = scalingMetricRequestsAwaitingResponse
+	res, err = hdl.GetMetrics(ctx, &externalscaler.GetMetricsRequest{ScaledObjectRef: sor})
+	r.NoError(err)
+	r.Equal(int64(2), res.MetricValues[0].MetricValue)
+
+	// streaming requests alone still keep the host active
+	counts.Counts[host] = 2
+	counts.Hosts[host] = queue.HostCounts{Active: 2, Streaming: 2}
+	pinger.reconcile(
+		time.Now(),
+		map[string]struct{}{"1.2.3.4:8080": {}},
+		[]fetchResult{{addr: "1.2.3.4:8080", counts: counts}},
+	)
+	res, err = hdl.GetMetrics(ctx, &externalscaler.GetMetricsRequest{ScaledObjectRef: sor})
+	r.NoError(err)
+	r.Equal(int64(0), res.MetricValues[0].MetricValue)
+	active, err := hdl.IsActive(ctx, sor)
+	r.NoError(err)
+	r.True(active.Result)
+}
+
+func TestAdditionalHosts(t *testing.T) {
+	const (
+		host      = "TestAdditionalHosts.testing"
+		otherHost = "www.TestAdditionalHosts.testing"
+		unrelated = "unrelated.TestAdditionalHosts.testing"
+	)
+	r := require.New(t)
+	ctx := context.Background()
+	lggr := logr.Discard()
+	ticker, pinger := newFakeQueuePinger(ctx, lggr)
+	defer ticker.Stop()
+	counts := queue.NewCounts()
+	counts.Counts[host] = 0
+	counts.Counts[otherHost] = 3
+	counts.Counts[unrelated] = 100
+	pinger.reconcile(
+		time.Now(),
+		map[string]struct{}{"1.2.3.4:8080": {}},
+		[]fetchResult{{addr: "1.2.3.4:8080", counts: counts}},
+	)
+	hdl := newImpl(lggr, pinger, routing.NewTable(), 123, 200)
+
+	// only the host's own count is used
+	sor := &externalscaler.ScaledObjectRef{
+		ScalerMetadata: map[string]string{"host": host},
+	}
+	res, err := hdl.GetMetrics(ctx, &externalscaler.GetMetricsRequest{ScaledObjectRef: sor})
+	r.NoError(err)
+	r.Equal(metricName("", host), res.MetricValues[0].MetricName)
+	r.Equal(int64(0), res.MetricValues[0].MetricValue)
+	active, err := hdl.IsActive(ctx, sor)
+	r.NoError(err)
+	r.False(active.Result)
+
+	// the additional hosts' counts are added to the host's, and
+	// hosts without counts and duplicates are ignored
+	sor.ScalerMetadata[hostsKey] = host + ", " + otherHost + ",nocounts.testing," + otherHost
+	res, err = hdl.GetMetrics(ctx, &externalscaler.GetMetricsRequest{ScaledObjectRef: sor})
+	r.NoError(err)
+	r.Equal(metricName("", host), res.MetricValues[0].MetricName)
+	r.Equal(int64(3), res.MetricValues[0].MetricValue)
+	active, err = hdl.IsActive(ctx, sor)
+	r.NoError(err)
+	r.True(active.Result)
+}
+
+func TestNamespacedHosts(t *testing.T) {
+	const (
+		host       = "internal.TestNamespacedHosts.testing"
+		legacyHost = "legacy.TestNamespacedHosts.testing"
+	)
+	r := require.New(t)
+	ctx := context.Background()
+	lggr := logr.Discard()
+	ticker, pinger := newFakeQueuePinger(ctx, lggr)
+	defer ticker.Stop()
+	// the same host in two namespaces, and a plain host from an
+	// interceptor that predates namespaced counts
+	counts := queue.NewCounts()
+	counts.Counts[queue.NamespacedKey("ns1", host)] = 2
+	counts.Counts[queue.NamespacedKey("ns2", host)] = 9
+	counts.Counts[legacyHost] = 4
+	pinger.reconcile(
+		time.Now(),
+		map[string]struct{}{"1.2.3.4:8080": {}},
+		[]fetchResult{{addr: "1.2.3.4:8080", counts: counts}},
+	)
+	table := routing.NewTable()
+	r.NoError(table.AddTarget(routing.NamespacedHost("ns1", host), routing.Target{
+		TargetPendingRequests: 10,
+	}))
+	r.NoError(table.AddTarget(routing.NamespacedHost("ns2", host), routing.Target{
+		TargetPendingRequests: 20,
+	}))
+	hdl := newImpl(lggr, pinger, table, 123, 200)
+
+	for ns, expected := range map[string]struct {
+		count  int64
+		target int64
+	}{
+		"ns1": {count: 2, target: 10},
+		"ns2": {count: 9, target: 20},
+	} {
+		sor := &externalscaler.ScaledObjectRef{
+			Namespace:      ns,
+			ScalerMetadata: map[string]string{"host": host},
+		}
+		res, err := hdl.GetMetrics(ctx, &externalscaler.GetMetricsRequest{ScaledObjectRef: sor})
+		r.NoError(err)
+		r.Equal(expected.count, res.MetricValues[0].MetricValue, ns)
+		spec, err := hdl.GetMetricSpec(ctx, sor)
+		r.NoError(err)
+		r.Equal(expected.target, spec.MetricSpecs[0].TargetSize, ns)
+	}
+
+	// a namespace without counts for the host doesn't
+	// see the other namespaces' counts
+	_, err := hdl.GetMetrics(ctx, &externalscaler.GetMetricsRequest{
+		ScaledObjectRef: &externalscaler.ScaledObjectRef{
+			Namespace:      "ns3",
+			ScalerMetadata: map[string]string{"host": host},
+		},
+	})
+	r.Error(err)
+
+	// plain hosts are found from any namespace
+	res, err := hdl.GetMetrics(ctx, &externalscaler.GetMetricsRequest{
+		ScaledObjectRef: &externalscaler.ScaledObjectRef{
+			Namespace:      "ns1",
+			ScalerMetadata: map[string]string{"host": legacyHost},
+		},
+	})
+	r.NoError(err)
+	r.Equal(int64(4), res.MetricValues[0].MetricValue)
+}
+
+func TestAnyTrafficHost(t *testing.T) {
+	const (
+		host       = "a.TestAnyTrafficHost.testing"
+		otherHost  = "b.TestAnyTrafficHost.testing"
+		legacyHost = "legacy.TestAnyTrafficHost.testing"
+	)
+	r := require.New(t)
+	ctx := context.Background()
+	lggr := logr.Discard()
+	ticker, pinger := newFakeQueuePinger(ctx, lggr)
+	defer ticker.Stop()
+	counts := queue.NewCounts()
+	counts.Counts[queue.NamespacedKey("ns1", host)] = 0
+	counts.Counts[queue.NamespacedKey("ns1", otherHost)] = 0
+	counts.Counts[queue.NamespacedKey("ns2", host)] = 9
+	counts.Counts[legacyHost] = 4
+	fetch := func() {
+		pinger.reconcile(
+			time.Now(),
+			map[string]struct{}{"1.2.3.4:8080": {}},
+			[]fetchResult{{addr: "1.2.3.4:8080", counts: counts}},
+		)
+	}
+	fetch()
+	hdl := newImpl(lggr, pinger, routing.NewTable(), 123, 200)
+
+	sor := &externalscaler.ScaledObjectRef{
+		Namespace:      "ns1",
+		ScalerMetadata: map[string]string{"host": anyTrafficHost},
+	}
+	spec, err := hdl.GetMetricSpec(ctx, sor)
+	r.NoError(err)
+	r.Equal(metricName("ns1", anyTrafficHost), spec.MetricSpecs[0].MetricName)
+	r.Equal(int64(123), spec.MetricSpecs[0].TargetSize)
+
+	// no host in the namespace has traffic, and the other
+	// namespaces' and plain hosts' counts aren't included
+	res, err := hdl.GetMetrics(ctx, &externalscaler.GetMetricsRequest{ScaledObjectRef: sor})
+	r.NoError(err)
+	r.Equal(metricName("ns1", anyTrafficHost), res.MetricValues[0].MetricName)
+	r.Equal(int64(0), res.MetricValues[0].MetricValue)
+	active, err := hdl.IsActive(ctx, sor)
+	r.NoError(err)
+	r.False(active.Result)
+
+	// traffic to any host in the namespace activates it
+	counts.Counts[queue.NamespacedKey("ns1", otherHost)] = 3
+	fetch()
+	res, err = hdl.GetMetrics(ctx, &externalscaler.GetMetricsRequest{ScaledObjectRef: sor})
+	r.NoError(err)
+	r.Equal(int64(3), res.MetricValues[0].MetricValue)
+	active, err = hdl.IsActive(ctx, sor)
+	r.NoError(err)
+	r.True(active.Result)
+
+	// with hosts listed, only they're included, plain hosts too
+	sor.ScalerMetadata[hostsKey] = host + "," + legacyHost
+	res, err = hdl.GetMetrics(ctx, &externalscaler.GetMetricsRequest{ScaledObjectRef: sor})
+	r.NoError(err)
+	r.Equal(int64(4), res.MetricValues[0].MetricValue)
+
+	// a namespace without any counts is inactive, not an error
+	active, err = hdl.IsActive(ctx, &externalscaler.ScaledObjectRef{
+		Namespace:      "ns3",
+		ScalerMetadata: map[string]string{"host": anyTrafficHost},
+	})
+	r.NoError(err)
+	r.False(active.Result)
+}
+
+func TestScaledownPeriod(t *testing.T) {
+	const host = "TestScaledownPeriod.testing"
+	r := require.New(t)
+	ctx := context.Background()
+	lggr := logr.Discard()
+	ticker, pinger := newFakeQueuePinger(ctx, lggr)
+	defer ticker.Stop()
+	generation := uint64(0)
+	setLastRequestAge := func(age *int64) {
+		generation++
+		counts := queue.NewCounts()
+		counts.Generation = generation
+		counts.Counts[host] = 0
+		counts.Hosts[host] = queue.HostCounts{LastRequestAgeMS: age}
+		pinger.reconcile(
+			time.Now(),
+			map[string]struct{}{"1.2.3.4:8080": {}},
+			[]fetchResult{{addr: "1.2.3.4:8080", counts: counts}},
+		)
+	}
+	table := routing.NewTable()
+	r.NoError(table.AddTarget(host, routing.Target{ScaledownPeriodSeconds: 60}))
+	hdl := newImpl(lggr, pinger, table, 123, 200)
+	sor := &externalscaler.ScaledObjectRef{
+		ScalerMetadata: map[string]string{"host": host},
+	}
+	isActive := func() bool {
+		res, err := hdl.IsActive(ctx, sor)
+		r.NoError(err)
+		return res.Result
+	}
+
+	// a host that had a request within the period stays active
+	recent := int64(30 * 1000)
+	setLastRequestAge(&recent)
+	r.True(isActive())
+
+	// and goes inactive once the period is over
+	old := int64(90 * 1000)
+	setLastRequestAge(&old)
+	r.False(isActive())
+
+	// a host that never had a request is inactive
+	setLastRequestAge(nil)
+	r.False(isActive())
+
+	// without a period, a host with no requests is inactive right away
+	r.NoError(table.RemoveTarget(host))
+	r.NoError(table.AddTarget(host, routing.Target{}))
+	setLastRequestAge(&recent)
+	r.False(isActive())
+}
+
+func TestSmoothedMetrics(t *testing.T) {
+	const host = "TestSmoothedMetrics.testing"
+	r := require.New(t)
+	ctx := context.Background()
+	lggr := logr.Discard()
+	table := routing.NewTable()
+	ticker, pinger := newFakeQueuePinger(ctx, lggr)
+	defer ticker.Stop()
+	setCount := func(count int) {
+		pinger.pingMut.Lock()
+		defer pinger.pingMut.Unlock()
+		pinger.allCounts[host] = count
+	}
+	target := routing.NewTarget("testsvc", 8080, "testdepl", 100)
+	target.SmoothingFactorPercent = 50
+	r.NoError(table.AddTarget(host, target))
+	hdl := newImpl(lggr, pinger, table, 123, 200)
+	sor := &externalscaler.ScaledObjectRef{
+		ScalerMetadata: map[string]string{"host": host},
+	}
+	getMetric := func() int64 {
+		res, err := hdl.GetMetrics(ctx, &externalscaler.GetMetricsRequest{
+			ScaledObjectRef: sor,
+		})
+		r.NoError(err)
+		return res.MetricValues[0].MetricValue
+	}
+
+	// the first count is reported as it is, and a spike
+	// only moves the average halfway
+	setCount(100)
+	r.Equal(int64(100), getMetric())
+	setCount(300)
+	r.Equal(int64(200), getMetric())
+	setCount(0)
+	r.Equal(int64(100), getMetric())
+	r.Equal(int64(50), getMetric())
+
+	// activity is still decided on the raw counts
+	active, err := hdl.IsActive(ctx, sor)
+	r.NoError(err)
+	r.False(active.Result)
+
+	// without smoothing, the raw counts are reported
+	r.NoError(table.RemoveTarget(host))
+	r.NoError(table.AddTarget(host, routing.NewTarget("testsvc", 8080, "testdepl", 100)))
+	setCount(300)
+	r.Equal(int64(300), getMetric())
+}
+
+// GetMetrics with a ScaledObjectRef in the RPC request that has
+// no 'host' field in the metadata field
+func TestGetMetricsMissingHostInMetadata(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	lggr := logr.Discard()
+	req := &externalscaler.GetMetricsRequest{
+		ScaledObjectRef: &externalscaler.ScaledObjectRef{},
+	}
+	table := routing.NewTable()
+	ticker, pinger := newFakeQueuePinger(ctx, lggr)
+	defer ticker.Stop()
+	hdl := newImpl(lggr, pinger, table, 123, 200)
+
+	// no 'host' in the ScalerObjectRef's metadata field
+	res, err := hdl.GetMetrics(ctx, req)
+	r.Error(err)
+	r.Nil(res)
+	r.Contains(
+		err.Error(),
+		"no 'host' field found in ScaledObject metadata",
+	)
+}
+
+// 'host' field found in ScalerObjectRef.ScalerMetadata, but
+// not found in the queuePinger
+func TestGetMetricsMissingHostInQueue(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	lggr := logr.Discard()
+
+	const host = "TestGetMetricsMissingHostInQueue.com"
+	meta := map[string]string{
+		"host": host,
+	}
+
+	table := routing.NewTable()
+	ticker, pinger := newFakeQueuePinger(ctx, lggr)
+	defer ticker.Stop()
+	hdl := newImpl(lggr, pinger, table, 123, 200)
+
+	req := &externalscaler.GetMetricsRequest{
+		ScaledObjectRef: &externalscaler.ScaledObjectRef{},
+	}
+	req.ScaledObjectRef.ScalerMetadata = meta
+	res, err := hdl.GetMetrics(ctx, req)
+	r.Error(err)
+	r.Contains(err.Error(), fmt.Sprintf(
+		"host '%s' not found in counts", host,
+	))
+	r.Nil(res)
+}
+
+// GetMetrics RPC call with host found in both the incoming
+// ScaledObject and in the queue counter
+func TestGetMetricsHostFoundInQueueCounts(t *testing.T) {
+	const (
+		ns          = "testns"
+		svcName     = "testsrv"
+		pendingQLen = 203
+	)
+
+	host := fmt.Sprintf("%s.scaler.testing.com", t.Name())
+
+	// create a request for the GetMetrics RPC call. it instructs
+	// GetMetrics to return the counts for one specific host.
+	// below, we do setup to ensure that we have a fake
+	// interceptor, and that interceptor knows about the given host
+	req := &externalscaler.GetMetricsRequest{
+		ScaledObjectRef: &externalscaler.ScaledObjectRef{
+			ScalerMetadata: map[string]string{
+				"host": host,
+			},
+		},
+	}
+
+	r := require.New(t)
+	ctx := context.Background()
+	lggr := logr.Discard()
+
+	// we need to create a new queuePinger with valid endpoints
+	// to query this time, so that when counts are requested by
+	// the internal queuePinger logic, there is a valid host from
+	// which to request those counts
+	q := queue.NewFakeCounter()
+	// NOTE: don't call .Resize here or you'll have to make sure
+	// to receive on q.ResizedCh
+	q.RetMap[host] = pendingQLen
+
+	// create a fake interceptor
+	fakeSrv, fakeSrvURL, endpoints, err := startFakeQueueEndpointServer(
+		ns,
+		svcName,
+		q,
+		1,
+	)
+	r.NoError(err)
+	defer fakeSrv.Close()
+
+	table := routing.NewTable()
+	// create a fake queue pinger. this is the simulated
+	// scaler that pings the above fake interceptor
+	ticker, pinger := newFakeQueuePinger(
+		ctx,
+		lggr,
+		func(opts *fakeQueuePingerOpts) { opts.endpoints = endpoints },
+		func(opts *fakeQueuePingerOpts) { opts.tickDur = 1 * time.Millisecond },
+		func(opts *fakeQueuePingerOpts) { opts.port = fakeSrvURL.Port() },
+	)
+	defer ticker.Stop()
+	// sleep for more than enough time for the pinger to do its
+	// first tick
+	time.Sleep(50 * time.Millisecond)
+
+	hdl := newImpl(lggr, pinger, table, 123, 200)
+	res, err := hdl.GetMetrics(ctx, req)
+	r.NoError(err)
+	r.NotNil(res)
+	r.Equal(1, len(res.MetricValues))
+	metricVal := res.MetricValues[0]
+	r.Equal(metricName("", host), metricVal.MetricName)
+	r.Equal(int64(pendingQLen), metricVal.MetricValue)
+}
+
+// Ensure that the queue pinger returns the aggregate request
+// count when the host is set to "interceptor"
+func TestGetMetricsInterceptorReturnsAggregate(t *testing.T) {
+	const (
+		ns          = "testns"
+		svcName     = "testsrv"
+		pendingQLen = 203
+	)
+
+	// create a request for the GetMetrics RPC call. it instructs
+	// GetMetrics to return the counts for one specific host.
+	// below, we do setup to ensure that we have a fake
+	// interceptor, and that interceptor knows about the given host
+	req := &externalscaler.GetMetricsRequest{
+		ScaledObjectRef: &externalscaler.ScaledObjectRef{
+			ScalerMetadata: map[string]string{
+				"host": "interceptor",
+			},
+		},
+	}
+
+	r := require.New(t)
+	ctx := context.Background()
+	lggr := logr.Discard()
+
+	// we need to create a new queuePinger with valid endpoints
+	// to query this time, so that when counts are requested by
+	// the internal queuePinger logic, there is a valid host from
+	// which to request those counts
+	q := queue.NewFakeCounter()
+	// NOTE: don't call .Resize here or you'll have to make sure
+	// to receive on q.ResizedCh
+	q.RetMap["host1"] = pendingQLen
+	q.RetMap["host2"] = pendingQLen
+
+	// create a fake interceptor
+	fakeSrv, fakeSrvURL, endpoints, err := startFakeQueueEndpointServer(
+		ns,
+		svcName,
+		q,
+		1,
+	)
+	r.NoError(err)
+	defer fakeSrv.Close()
+
+	table := routing.NewTable()
+	// create a fake queue pinger. this is the simulated
+	// scaler that pings the above fake interceptor
+	const tickDur = 5 * time.Millisecond
+	ticker, pinger := newFakeQueuePinger(
+		ctx,
+		lggr,
+		func(opts *fakeQueuePingerOpts) { opts.endpoints = endpoints },
+		func(opts *fakeQueuePingerOpts) { opts.tickDur = tickDur },
+		func(opts *fakeQueuePingerOpts) { opts.port = fakeSrvURL.Port() },
+	)
+	defer ticker.Stop()
+
+	// sleep for more than enough time for the pinger to do its
+	// first tick
+	time.Sleep(tickDur * 5)
+
+	hdl := newImpl(lggr, pinger, table, 123, 200)
+	res, err := hdl.GetMetrics(ctx, req)
+	r.NoError(err)
+	r.NotNil(res)
+	r.Equal(1, len(res.MetricValues))
+	metricVal := res.MetricValues[0]
+	r.Equal("interceptor", metricVal.MetricName)
+	aggregate := pinger.aggregate()
+	r.Equal(int64(aggregate), metricVal.MetricValue)
+}
+
+// fakeStreamIsActiveServer is an ExternalScaler_StreamIsActiveServer
+// that sends every IsActiveResponse on a channel
+type fakeStreamIsActiveServer struct {
+	grpc.ServerStream
+	ctx    context.Context
+	sentCh chan *externalscaler.IsActiveResponse
+}
+
+func (f *fakeStreamIsActiveServer) Context() context.Context {
+	return f.ctx
+}
+
+func (f *fakeStreamIsActiveServer) Send(res *externalscaler.IsActiveResponse) error {
+	f.sentCh <- res
+	return nil
+}
+
+func TestStreamIsActive(t *testing.T) {
+	const (
+		host = "TestStreamIsActive.testing.com"
+		addr = "1.2.3.4:8080"
+	)
+	r := require.New(t)
+	ctx, done := context.WithCancel(context.Background())
+	defer done()
+	lggr := logr.Discard()
+	ticker, pinger := newFakeQueuePinger(ctx, lggr)
+	defer ticker.Stop()
+	hdl := newImpl(lggr, pinger, routing.NewTable(), 123, 200)
+
+	var gen uint64
+	setCount := func(count int) {
+		gen++
+		counts := queue.NewCounts()
+		counts.Source = "interceptor1"
+		counts.Generation = gen
+		counts.Counts[host] = count
+		pinger.reconcile(
+			time.Now(),
+			map[string]struct{}{addr: {}},
+			[]fetchResult{{addr: addr, counts: counts}},
+		)
+	}
+	srv := &fakeStreamIsActiveServer{
+		ctx:    ctx,
+		sentCh: make(chan *externalscaler.IsActiveResponse),
+	}
+	expectSent := func(expected bool) {
+		t.Helper()
+		select {
+		case res := <-srv.sentCh:
+			r.Equal(expected, res.Result)
+		case <-time.After(time.Second):
+			r.FailNow("timed out waiting for an active status")
+		}
+	}
+	setCount(0)
+	errCh := make(chan error)
+	go func() {
+		errCh <- hdl.StreamIsActive(
+			&externalscaler.ScaledObjectRef{
+				ScalerMetadata: map[string]string{
+					"host": host,
+				},
+			},
+			srv,
+		)
+	}()
+
+	// the initial state is always sent
+	expectSent(false)
+
+	// an update with the same state shouldn't be sent,
+	// but a change in state should
+	setCount(0)
+	setCount(1)
+	expectSent(true)
+	setCount(0)
+	expectSent(false)
+
+	done()
+	r.NoError(<-errCh)
+}
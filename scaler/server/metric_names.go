@@ -0,0 +1,81 @@
+package server
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"github.com/kedacore/http-add-on/pkg/queue"
+)
+
+const (
+	// metricNamePrefix is the prefix of the names of every host's
+	// metrics, so that they're easy to tell apart from the metrics of
+	// other scalers in the HPA
+	metricNamePrefix = "http-"
+	// maxMetricNameLen is the longest that a metric name can be,
+	// including its breakdown suffix, so that it's a valid label
+	// value even after KEDA prefixes it with the scaler's index
+	maxMetricNameLen = 63
+)
+
+// metricName returns the name of the metric for host in namespace ns.
+// It's made of the namespace and host with everything but lowercase
+// letters, digits and dashes replaced by dashes, and a hash of the
+// original namespace and host, so that hosts that sanitize to the
+// same name, and the same host in different namespaces, still get
+// different names. It's shortened so that a breakdown suffix still
+// fits in maxMetricNameLen.
+//
+// The interceptor's own metric is always called "interceptor"
+func metricName(ns, host string) string {
+	if host == "interceptor" {
+		return host
+	}
+	h := fnv.New32a()
+	h.Write([]byte(queue.NamespacedKey(ns, host)))
+	hash := fmt.Sprintf("-%08x", h.Sum32())
+
+	name := sanitizeMetricName(host)
+	if ns != "" {
+		name = sanitizeMetricName(ns) + "-" + name
+	}
+	maxLen := maxMetricNameLen -
+		len(metricNamePrefix) -
+		len(pendingMetricSuffix) -
+		len(hash)
+	if len(name) > maxLen {
+		name = strings.TrimRight(name[:maxLen], "-")
+	}
+	return metricNamePrefix + name + hash
+}
+
+// sanitizeMetricName lowercases s and replaces every run of characters
+// that aren't letters or digits with a single dash, trimming dashes
+// from the ends
+func sanitizeMetricName(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, c := range strings.ToLower(s) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') {
+			b.WriteRune(c)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimRight(b.String(), "-")
+}
+
+// metricNames returns the metric name of each of the keys in counts,
+// mapped to the key, which is either <namespace>/<host> or, for
+// interceptors that predate namespaced counts, a plain host
+func metricNames(counts map[string]int) map[string]string {
+	ret := make(map[string]string, len(counts))
+	for key := range counts {
+		ns, host, _ := queue.SplitNamespacedKey(key)
+		ret[metricName(ns, host)] = key
+	}
+	return ret
+}
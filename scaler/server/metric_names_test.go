@@ -0,0 +1,48 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricName(t *testing.T) {
+	r := require.New(t)
+
+	name := metricName("my-ns", "API.Example.com:8080")
+	r.True(strings.HasPrefix(name, "http-my-ns-api-example-com-8080-"), name)
+	r.Equal(name, metricName("my-ns", "API.Example.com:8080"))
+
+	// the same host in another namespace, and hosts that
+	// sanitize to the same name, get different names
+	r.NotEqual(name, metricName("other-ns", "API.Example.com:8080"))
+	r.NotEqual(name, metricName("my-ns", "api-example-com-8080"))
+
+	long := metricName("ns", strings.Repeat("a", 200)+".testing")
+	r.LessOrEqual(len(long+pendingMetricSuffix), maxMetricNameLen)
+	for _, c := range long {
+		r.True((c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-', long)
+	}
+
+	r.Equal("interceptor", metricName("ns", "interceptor"))
+}
+
+func TestSanitizeMetricName(t *testing.T) {
+	r := require.New(t)
+	r.Equal("a-b-c", sanitizeMetricName("A..b__C"))
+	r.Equal("pending", sanitizeMetricName(anyTrafficHost))
+	r.Equal("", sanitizeMetricName("..."))
+}
+
+func TestMetricNames(t *testing.T) {
+	r := require.New(t)
+	names := metricNames(map[string]int{
+		"ns1/a.testing": 1,
+		"b.testing":     2,
+	})
+	r.Equal(map[string]string{
+		metricName("ns1", "a.testing"): "ns1/a.testing",
+		metricName("", "b.testing"):    "b.testing",
+	}, names)
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	kedahttp "github.com/kedacore/http-add-on/pkg/http"
+	"github.com/kedacore/http-add-on/pkg/queue"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	externalscaler "github.com/kedacore/http-add-on/proto"
+)
+
+// metricsAPIPath is the path of the metrics API. A host's metrics
+// are at metricsAPIPath/<namespace>/<host>, or metricsAPIPath/<host>
+// for hosts from interceptors that predate namespaced counts
+const metricsAPIPath = "/api/v1/metrics"
+
+// hostMetrics is what the metrics API reports for a host: its counts
+// from the interceptors, and the metric value, target and active
+// state that KEDA gets for it.
+//
+// The metric value, target and active state are the ones that a
+// ScaledObject with only the host in its metadata gets. ScaledObjects
+// that override the target, the scaling metric or the hosts in their
+// metadata get different values
+type hostMetrics struct {
+	// Namespace is the namespace of the host. It's empty for hosts
+	// from interceptors that predate namespaced counts
+	Namespace string `json:"namespace,omitempty"`
+	Host      string `json:"host"`
+	// MetricName is the name of the host's metric in the HPA
+	MetricName string           `json:"metricName"`
+	Count      int              `json:"count"`
+	Breakdown  queue.HostCounts `json:"breakdown"`
+	// Fleets is the count of the host in each interceptor fleet,
+	// keyed by fleet name
+	Fleets      map[string]int `json:"fleets,omitempty"`
+	MetricValue int64          `json:"metricValue"`
+	TargetValue int64          `json:"targetValue"`
+	Active      bool           `json:"active"`
+	Paused      bool           `json:"paused"`
+}
+
+// metricsAPIResponse is the response to a request for all the hosts'
+// metrics
+type metricsAPIResponse struct {
+	Hosts []hostMetrics `json:"hosts"`
+	// FallbackReplicas is the number of replicas that every host is
+	// scaled to because the scaler lost contact with the interceptors,
+	// or nil if it hasn't
+	FallbackReplicas *int `json:"fallbackReplicas,omitempty"`
+	// Scrapes describes how the scaler's last scrape of the
+	// interceptors went
+	Scrapes scrapeStats `json:"scrapes"`
+	// RoutingTableVersion is the version of the scaler's routing
+	// table, and RoutingTableUpdated is when it last changed
+	RoutingTableVersion string    `json:"routingTableVersion"`
+	RoutingTableUpdated time.Time `json:"routingTableUpdated"`
+}
+
+// hostMetrics returns the metrics of the host whose counts are under
+// key, and false if there are no such counts
+func (e *impl) hostMetrics(ctx context.Context, key string) (hostMetrics, bool, error) {
+	ns, host, _ := queue.SplitNamespacedKey(key)
+	count, breakdown, ok := e.hostCounts(ns, host, nil)
+	if !ok {
+		return hostMetrics{}, false, nil
+	}
+	sor := &externalscaler.ScaledObjectRef{
+		Namespace:      ns,
+		ScalerMetadata: map[string]string{"host": host},
+	}
+	metrics, err := e.GetMetrics(ctx, &externalscaler.GetMetricsRequest{
+		ScaledObjectRef: sor,
+		MetricName:      metricName(ns, host),
+	})
+	if err != nil {
+		return hostMetrics{}, false, err
+	}
+	active, err := e.IsActive(ctx, sor)
+	if err != nil {
+		return hostMetrics{}, false, err
+	}
+	target, err := e.targetPendingRequests(ns, host, nil)
+	if err != nil {
+		return hostMetrics{}, false, err
+	}
+	_, paused := e.pausedReplicas(ns, host)
+	var fleets map[string]int
+	for fleet, counts := range e.pinger.fleetBreakdown() {
+		if val, ok := counts[key]; ok {
+			if fleets == nil {
+				fleets = map[string]int{}
+			}
+			fleets[fleet] = val
+		}
+	}
+	return hostMetrics{
+		Namespace:   ns,
+		Host:        host,
+		MetricName:  metrics.MetricValues[0].MetricName,
+		Count:       count,
+		Breakdown:   breakdown,
+		Fleets:      fleets,
+		MetricValue: metrics.MetricValues[0].MetricValue,
+		TargetValue: target,
+		Active:      active.Result,
+		Paused:      paused,
+	}, true, nil
+}
+
+// newMetricsAPIHandler returns the handler for the metrics API, which
+// requires token as a bearer token. It serves the metrics of all hosts,
+// sorted by namespace and host, along with the version of table, at
+// metricsAPIPath, and the metrics of a single host at
+// metricsAPIPath/<namespace>/<host>
+func newMetricsAPIHandler(
+	lggr logr.Logger,
+	e *impl,
+	table *routing.Table,
+	token string,
+) http.Handler {
+	lggr = lggr.WithName("metricsAPI")
+	writeJSON := func(w http.ResponseWriter, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(v); err != nil {
+			lggr.Error(err, "writing metrics to client")
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(metricsAPIPath, func(w http.ResponseWriter, r *http.Request) {
+		ret := metricsAPIResponse{Hosts: []hostMetrics{}}
+		keys := []string{}
+		for key := range e.pinger.counts() {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			metrics, ok, err := e.hostMetrics(r.Context(), key)
+			if err != nil {
+				lggr.Error(err, "getting host metrics", "key", key)
+				continue
+			}
+			if ok {
+				ret.Hosts = append(ret.Hosts, metrics)
+			}
+		}
+		if replicas, ok := e.pinger.fallbackReplicas(); ok {
+			ret.FallbackReplicas = &replicas
+		}
+		ret.Scrapes = e.pinger.scrapes()
+		ret.RoutingTableVersion, ret.RoutingTableUpdated = table.Version()
+		routing.SetVersionHeader(w, table)
+		writeJSON(w, ret)
+	})
+	mux.HandleFunc(metricsAPIPath+"/", func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, metricsAPIPath+"/")
+		metrics, ok, err := e.hostMetrics(r.Context(), key)
+		if err != nil {
+			lggr.Error(err, "getting host metrics", "key", key)
+			w.WriteHeader(500)
+			w.Write([]byte("error getting host metrics"))
+			return
+		}
+		if !ok {
+			w.WriteHeader(404)
+			w.Write([]byte("host not found"))
+			return
+		}
+		writeJSON(w, metrics)
+	})
+	return kedahttp.BearerAuth(
+		token,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				w.Header().Set("Allow", http.MethodGet)
+				w.WriteHeader(405)
+				return
+			}
+			mux.ServeHTTP(w, r)
+		}),
+	)
+}
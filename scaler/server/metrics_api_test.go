@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/queue"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsAPI(t *testing.T) {
+	const (
+		token     = "abc123"
+		host      = "a.TestMetricsAPI.testing"
+		otherHost = "b.TestMetricsAPI.testing"
+	)
+	r := require.New(t)
+	ctx := context.Background()
+	lggr := logr.Discard()
+	ticker, pinger := newFakeQueuePinger(ctx, lggr)
+	defer ticker.Stop()
+	counts := queue.NewCounts()
+	counts.Counts[host] = 7
+	counts.Counts[otherHost] = 0
+	pinger.reconcile(
+		time.Now(),
+		map[string]struct{}{"1.2.3.4:8080": {}},
+		[]fetchResult{{addr: "1.2.3.4:8080", fleet: "zone-a", counts: counts}},
+	)
+	table := routing.NewTable()
+	table.AddTarget(host, routing.Target{
+		Service:               "svc",
+		Port:                  8080,
+		TargetPendingRequests: 5,
+	})
+	hdl := newMetricsAPIHandler(
+		lggr,
+		newImpl(lggr, pinger, table, 100, 200),
+		table,
+		token,
+	)
+
+	get := func(path, authz string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if authz != "" {
+			req.Header.Set("Authorization", authz)
+		}
+		rec := httptest.NewRecorder()
+		hdl.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// the API needs the token
+	r.Equal(401, get(metricsAPIPath, "").Code)
+	r.Equal(401, get(metricsAPIPath, "Bearer wrong").Code)
+
+	res := get(metricsAPIPath, "Bearer "+token)
+	r.Equal(200, res.Code)
+	var all metricsAPIResponse
+	r.NoError(json.NewDecoder(res.Body).Decode(&all))
+	r.Nil(all.FallbackReplicas)
+	version, _ := table.Version()
+	r.NotEmpty(version)
+	r.Equal(version, all.RoutingTableVersion)
+	r.Equal(version, res.Header().Get(routing.VersionHeader))
+	r.Len(all.Hosts, 2)
+	// hosts are sorted, and each gets its own target
+	r.Equal(hostMetrics{
+		Host:       host,
+		MetricName: metricName("", host),
+		Count:      7,
+		// counts without a breakdown are all active
+		Breakdown:   queue.HostCounts{Active: 7},
+		Fleets:      map[string]int{"zone-a": 7},
+		MetricValue: 7,
+		TargetValue: 5,
+		Active:      true,
+	}, all.Hosts[0])
+	r.Equal(otherHost, all.Hosts[1].Host)
+	r.False(all.Hosts[1].Active)
+	r.Equal(int64(100), all.Hosts[1].TargetValue)
+
+	res = get(metricsAPIPath+"/"+host, "Bearer "+token)
+	r.Equal(200, res.Code)
+	var single hostMetrics
+	r.NoError(json.NewDecoder(res.Body).Decode(&single))
+	r.Equal(all.Hosts[0], single)
+
+	r.Equal(404, get(metricsAPIPath+"/unknown.testing", "Bearer "+token).Code)
+
+	// the API is read-only
+	req := httptest.NewRequest("POST", metricsAPIPath, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	hdl.ServeHTTP(rec, req)
+	r.Equal(http.StatusMethodNotAllowed, rec.Code)
+}
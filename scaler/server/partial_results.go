@@ -0,0 +1,117 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/kedacore/http-add-on/pkg/queue"
+)
+
+const (
+	// partialDrop leaves the interceptors that didn't answer a tick out
+	// of the counts right away
+	partialDrop = "drop"
+	// partialHold keeps the last counts of the interceptors that didn't
+	// answer a tick, until they're stale
+	partialHold = "hold"
+	// partialExtrapolate leaves the interceptors that didn't answer a
+	// tick out of the counts, and scales up the counts of the others in
+	// their fleet to make up for them, assuming that traffic is spread
+	// evenly across each fleet
+	partialExtrapolate = "extrapolate"
+)
+
+// newPartialPolicy returns the partial results policy that cfg
+// describes. Returns an error if cfg has an unknown policy
+func newPartialPolicy(cfg *Config) (string, error) {
+	switch cfg.PartialResultsPolicy {
+	case partialDrop, partialHold, partialExtrapolate:
+		return cfg.PartialResultsPolicy, nil
+	default:
+		return "", fmt.Errorf(
+			"unknown partial results policy %q",
+			cfg.PartialResultsPolicy,
+		)
+	}
+}
+
+// scrapeStats describes how the queuePinger's last scrape of the
+// interceptors went
+type scrapeStats struct {
+	// Time is when the last scrape finished
+	Time time.Time `json:"time"`
+	// Endpoints is the number of interceptor endpoints that were
+	// scraped, and Succeeded and Failed are the number of them
+	// that did and didn't answer
+	Endpoints int `json:"endpoints"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	// Policy is how the counts of the endpoints that didn't
+	// answer are handled
+	Policy string `json:"policy"`
+}
+
+// scrapes returns q's scrapeStats for its last scrape
+func (q *queuePinger) scrapes() scrapeStats {
+	q.pingMut.RLock()
+	defer q.pingMut.RUnlock()
+	ret := q.lastScrape
+	ret.Policy = q.partialPolicy()
+	return ret
+}
+
+// partialPolicy returns q's partial results policy, which
+// is partialHold if q doesn't have one
+func (q *queuePinger) partialPolicy() string {
+	if q.partial == "" {
+		return partialHold
+	}
+	return q.partial
+}
+
+// extrapolationRatios returns the ratio of the live endpoints in each
+// fleet to the ones that answered at time now, for the fleets where
+// some, but not all, of the endpoints answered. Returns nil unless q's
+// policy is partialExtrapolate. Callers must hold q.pingMut
+func (q *queuePinger) extrapolationRatios(now time.Time) map[string]float64 {
+	if q.partialPolicy() != partialExtrapolate {
+		return nil
+	}
+	live := map[string]int{}
+	answered := map[string]int{}
+	for _, status := range q.endpoints {
+		live[status.Fleet]++
+		if status.LastSuccess.Equal(now) {
+			answered[status.Fleet]++
+		}
+	}
+	ret := map[string]float64{}
+	for fleet, n := range live {
+		// fleets where no endpoint answered have nothing to
+		// extrapolate from, so their last counts are held
+		if answered[fleet] > 0 {
+			ret[fleet] = float64(n) / float64(answered[fleet])
+		}
+	}
+	return ret
+}
+
+// extrapolate returns val scaled up by ratio, rounded up so that small
+// counts don't round down to nothing
+func extrapolate(val int, ratio float64) int {
+	if ratio == 1 {
+		return val
+	}
+	return int(math.Ceil(float64(val) * ratio))
+}
+
+// extrapolateHostCounts returns counts with its request and connection
+// counts scaled up by ratio. See extrapolate
+func extrapolateHostCounts(counts queue.HostCounts, ratio float64) queue.HostCounts {
+	counts.Active = extrapolate(counts.Active, ratio)
+	counts.Pending = extrapolate(counts.Pending, ratio)
+	counts.Connections = extrapolate(counts.Connections, ratio)
+	counts.Streaming = extrapolate(counts.Streaming, ratio)
+	return counts
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	context "context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/queue"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPartialPolicy(t *testing.T) {
+	r := require.New(t)
+	policy, err := newPartialPolicy(&Config{PartialResultsPolicy: partialExtrapolate})
+	r.NoError(err)
+	r.Equal(partialExtrapolate, policy)
+
+	_, err = newPartialPolicy(&Config{PartialResultsPolicy: "bogus"})
+	r.Error(err)
+}
+
+func TestReconcilePartialResults(t *testing.T) {
+	const fleet = "testfleet"
+	addrs := []string{"1.2.3.4:8080", "2.3.4.5:8080", "3.4.5.6:8080"}
+	liveAddrs := map[string]struct{}{}
+	for _, addr := range addrs {
+		liveAddrs[addr] = struct{}{}
+	}
+	// scrape has each interceptor but the ones in failed report
+	// gen as its generation, and its count of host1
+	scrape := func(
+		pinger *queuePinger,
+		now time.Time,
+		gen uint64,
+		counts []int,
+		failed map[string]bool,
+	) {
+		all := []fetchResult{}
+		results := []fetchResult{}
+		for idx, addr := range addrs {
+			if failed[addr] {
+				all = append(all, fetchResult{
+					addr:  addr,
+					fleet: fleet,
+					err:   errors.New("connection refused"),
+				})
+				continue
+			}
+			qCounts := queue.NewCounts()
+			qCounts.Source = addr
+			qCounts.Epoch = 1
+			qCounts.Generation = gen
+			qCounts.Counts["host1"] = counts[idx]
+			qCounts.Hosts["host1"] = queue.HostCounts{Active: counts[idx]}
+			res := fetchResult{addr: addr, fleet: fleet, counts: qCounts}
+			all = append(all, res)
+			results = append(results, res)
+		}
+		pinger.recordEndpoints(now, liveAddrs, all)
+		pinger.reconcile(now, liveAddrs, results)
+	}
+
+	for policy, expected := range map[string]int{
+		"":                 60,
+		partialHold:        60,
+		partialDrop:        30,
+		partialExtrapolate: 45,
+	} {
+		t.Run(policy, func(t *testing.T) {
+			r := require.New(t)
+			ticker, pinger := newFakeQueuePinger(context.Background(), logr.Discard())
+			defer ticker.Stop()
+			pinger.partial = policy
+
+			now := time.Now()
+			scrape(pinger, now, 1, []int{10, 20, 30}, nil)
+			r.Equal(60, pinger.counts()["host1"])
+
+			// the third interceptor doesn't answer the next tick
+			now = now.Add(time.Second)
+			scrape(pinger, now, 2, []int{10, 20, 30}, map[string]bool{
+				addrs[2]: true,
+			})
+			r.Equal(expected, pinger.counts()["host1"])
+			r.Equal(expected, pinger.breakdown()["host1"].Active)
+			r.Equal(expected, pinger.fleetBreakdown()[fleet]["host1"])
+
+			stats := pinger.scrapes()
+			r.Equal(now, stats.Time)
+			r.Equal(3, stats.Endpoints)
+			r.Equal(2, stats.Succeeded)
+			r.Equal(1, stats.Failed)
+			r.Equal(pinger.partialPolicy(), stats.Policy)
+			statuses := pinger.endpointStatuses()
+			r.Equal(2, statuses[addrs[0]].TotalSuccesses)
+			r.Equal(1, statuses[addrs[2]].TotalSuccesses)
+			r.Equal(1, statuses[addrs[2]].TotalFailures)
+
+			// with no interceptor in the fleet answering, there's
+			// nothing to extrapolate from, so hold is the most
+			// that any policy can do
+			now = now.Add(time.Second)
+			scrape(pinger, now, 3, nil, map[string]bool{
+				addrs[0]: true,
+				addrs[1]: true,
+				addrs[2]: true,
+			})
+			if policy == partialDrop {
+				r.Equal(0, pinger.counts()["host1"])
+			} else {
+				r.Equal(60, pinger.counts()["host1"])
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/k8s"
+	"github.com/kedacore/http-add-on/pkg/queue"
+	"golang.org/x/sync/errgroup"
+)
+
+// peerStatePath is the path on the health check server that serves
+// a scaler replica's peerState to the other replicas
+const peerStatePath = "/queue_peer"
+
+// peerState is what a scaler replica shares with the other replicas:
+// the counts it computed from the interceptors itself, before merging
+// in the other replicas' counts, and its moving averages
+type peerState struct {
+	Counts    map[string]int              `json:"counts"`
+	Breakdown map[string]queue.HostCounts `json:"breakdown"`
+	Averages  map[string]smoothedAverage  `json:"averages"`
+}
+
+// peerSnapshot is the most recent peerState that a peerSync received
+// from a single replica
+type peerSnapshot struct {
+	state    peerState
+	lastSeen time.Time
+}
+
+// peerSync shares the queuePinger's counts between scaler replicas.
+// Each replica pings the interceptors itself, and also fetches the
+// other replicas' peerStates, from the health check servers behind
+// svcName. A host's count is the highest that any replica has, so a
+// replica that just started, or that couldn't reach some
+// interceptors, or that doesn't have the registrations that went to
+// another replica, doesn't report fewer requests than the others. The
+// replica that KEDA fails over to carries on from the same counts and
+// moving averages as the one it was calling before
+type peerSync struct {
+	getEndpointsFn k8s.GetEndpointsFunc
+	ns             string
+	svcName        string
+	port           string
+	httpCl         *http.Client
+	staleAfter     time.Duration
+	// smoother adopts the other replicas' moving averages as
+	// they're fetched. nil means they're not adopted
+	smoother *metricSmoother
+	mut      *sync.Mutex
+	// snapshots is the last peerState from each replica,
+	// keyed by address
+	snapshots map[string]peerSnapshot
+}
+
+func newPeerSync(
+	getEndpointsFn k8s.GetEndpointsFunc,
+	ns,
+	svcName,
+	port string,
+) *peerSync {
+	return &peerSync{
+		getEndpointsFn: getEndpointsFn,
+		ns:             ns,
+		svcName:        svcName,
+		port:           port,
+		httpCl:         http.DefaultClient,
+		staleAfter:     defaultSnapshotStaleDur,
+		mut:            new(sync.Mutex),
+		snapshots:      map[string]peerSnapshot{},
+	}
+}
+
+// fetch requests the peerState of every replica behind p.svcName,
+// including this one, whose counts are the same as its own. Replicas
+// that don't respond keep their last peerState until p.staleAfter,
+// and replicas that leave the endpoints list are dropped. Does nothing
+// if p is nil.
+//
+// Returns a non-nil error if the endpoints or any replica couldn't be
+// reached
+func (p *peerSync) fetch(ctx context.Context, lggr logr.Logger) error {
+	if p == nil {
+		return nil
+	}
+	urls, err := k8s.EndpointsForService(ctx, p.ns, p.svcName, p.port, p.getEndpointsFn)
+	if err != nil {
+		return err
+	}
+	type result struct {
+		addr  string
+		state peerState
+	}
+	resultsCh := make(chan result, len(urls))
+	grp, _ := errgroup.WithContext(ctx)
+	for _, u := range urls {
+		u := *u
+		u.Path = peerStatePath
+		grp.Go(func() error {
+			state, err := p.get(ctx, u.String())
+			if err != nil {
+				lggr.Error(err, "getting the state of a scaler replica", "peerAddress", u.Host)
+				return err
+			}
+			resultsCh <- result{addr: u.Host, state: state}
+			return nil
+		})
+	}
+	fetchErr := grp.Wait()
+	close(resultsCh)
+
+	now := time.Now()
+	live := make(map[string]struct{}, len(urls))
+	for _, u := range urls {
+		live[u.Host] = struct{}{}
+	}
+	p.mut.Lock()
+	for res := range resultsCh {
+		p.snapshots[res.addr] = peerSnapshot{state: res.state, lastSeen: now}
+	}
+	for addr, snap := range p.snapshots {
+		if _, ok := live[addr]; !ok || now.Sub(snap.lastSeen) > p.staleAfter {
+			delete(p.snapshots, addr)
+		}
+	}
+	p.mut.Unlock()
+	if p.smoother != nil {
+		p.smoother.adopt(p.averages())
+	}
+	return fetchErr
+}
+
+func (p *peerSync) get(ctx context.Context, u string) (peerState, error) {
+	state := peerState{}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return state, err
+	}
+	res, err := p.httpCl.Do(req)
+	if err != nil {
+		return state, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return state, fmt.Errorf("%s returned status %d", u, res.StatusCode)
+	}
+	if err := json.NewDecoder(res.Body).Decode(&state); err != nil {
+		return state, fmt.Errorf("decoding the response from %s: %w", u, err)
+	}
+	return state, nil
+}
+
+// merge returns counts and breakdown, with each host's count raised
+// to the highest that any replica has, along with that replica's
+// breakdown of it. counts and breakdown aren't changed. Returns them
+// as they are if p is nil
+func (p *peerSync) merge(
+	counts map[string]int,
+	breakdown map[string]queue.HostCounts,
+) (map[string]int, map[string]queue.HostCounts) {
+	if p == nil {
+		return counts, breakdown
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	if len(p.snapshots) == 0 {
+		return counts, breakdown
+	}
+	mergedCounts := make(map[string]int, len(counts))
+	mergedBreakdown := make(map[string]queue.HostCounts, len(breakdown))
+	for host, val := range counts {
+		mergedCounts[host] = val
+	}
+	for host, hc := range breakdown {
+		mergedBreakdown[host] = hc
+	}
+	for _, snap := range p.snapshots {
+		for host, val := range snap.state.Counts {
+			if cur, ok := mergedCounts[host]; ok && cur >= val {
+				continue
+			}
+			mergedCounts[host] = val
+			mergedBreakdown[host] = snap.state.Breakdown[host]
+		}
+	}
+	return mergedCounts, mergedBreakdown
+}
+
+// averages returns the most recently updated moving average of each
+// metric, across every replica
+func (p *peerSync) averages() map[string]smoothedAverage {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	ret := map[string]smoothedAverage{}
+	for _, snap := range p.snapshots {
+		for key, avg := range snap.state.Averages {
+			if cur, ok := ret[key]; !ok || avg.Updated.After(cur.Updated) {
+				ret[key] = avg
+			}
+		}
+	}
+	return ret
+}
+
+// peerStateHandler serves this replica's peerState, from pinger and
+// smoother, to the other replicas
+func peerStateHandler(
+	lggr logr.Logger,
+	pinger *queuePinger,
+	smoother *metricSmoother,
+) http.Handler {
+	lggr = lggr.WithName("peerStateHandler")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		counts, breakdown := pinger.localCountsAndBreakdown()
+		state := peerState{
+			Counts:    counts,
+			Breakdown: breakdown,
+			Averages:  smoother.averages(),
+		}
+		if err := json.NewEncoder(w).Encode(state); err != nil {
+			lggr.Error(err, "writing peer state to client")
+		}
+	})
+}
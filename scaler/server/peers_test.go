@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/k8s"
+	kedanet "github.com/kedacore/http-add-on/pkg/net"
+	"github.com/kedacore/http-add-on/pkg/queue"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestPeerSync(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	const (
+		ns      = "testns"
+		svcName = "scaler-peers"
+	)
+
+	// the other replica has a higher count for host1,
+	// and a host that this replica doesn't know about
+	peerPinger := &queuePinger{
+		pingMut: new(sync.RWMutex),
+		localCounts: map[string]int{
+			"host1": 10,
+			"host3": 4,
+		},
+		localHostCounts: map[string]queue.HostCounts{
+			"host1": {Active: 7, Pending: 3},
+			"host3": {Active: 4},
+		},
+	}
+	peerSmoother := newMetricSmoother()
+	peerSmoother.smooth("host1", 10, 50)
+	hdl := http.NewServeMux()
+	hdl.Handle(peerStatePath, peerStateHandler(logr.Discard(), peerPinger, peerSmoother))
+	srv, url, err := kedanet.StartTestServer(hdl)
+	r.NoError(err)
+	defer srv.Close()
+
+	endpoints := k8s.FakeEndpointsForURL(url, ns, svcName, 1)
+	peers := newPeerSync(
+		func(context.Context, string, string) (*v1.Endpoints, error) {
+			return endpoints, nil
+		},
+		ns,
+		svcName,
+		url.Port(),
+	)
+	smoother := newMetricSmoother()
+	peers.smoother = smoother
+	r.NoError(peers.fetch(ctx, logr.Discard()))
+
+	counts, breakdown := peers.merge(
+		map[string]int{"host1": 2, "host2": 5},
+		map[string]queue.HostCounts{
+			"host1": {Active: 2},
+			"host2": {Pending: 5},
+		},
+	)
+	r.Equal(map[string]int{"host1": 10, "host2": 5, "host3": 4}, counts)
+	r.Equal(queue.HostCounts{Active: 7, Pending: 3}, breakdown["host1"])
+	r.Equal(queue.HostCounts{Pending: 5}, breakdown["host2"])
+
+	// the other replica's moving average carries on here
+	r.EqualValues(15, smoother.smooth("host1", 20, 50))
+
+	// replicas that leave the endpoints list are dropped
+	endpoints = &v1.Endpoints{}
+	r.NoError(peers.fetch(ctx, logr.Discard()))
+	counts, _ = peers.merge(map[string]int{"host1": 2}, nil)
+	r.Equal(map[string]int{"host1": 2}, counts)
+}
+
+func TestMetricSmootherAdopt(t *testing.T) {
+	r := require.New(t)
+	smoother := newMetricSmoother()
+	smoother.smooth("host1", 10, 50)
+	own := smoother.averages()["host1"]
+
+	// older averages don't replace newer ones
+	smoother.adopt(map[string]smoothedAverage{
+		"host1": {Value: 100, Updated: own.Updated.Add(-time.Minute)},
+		"host2": {Value: 8, Updated: own.Updated.Add(-time.Minute)},
+	})
+	avgs := smoother.averages()
+	r.Equal(10.0, avgs["host1"].Value)
+	r.Equal(8.0, avgs["host2"].Value)
+
+	smoother.adopt(map[string]smoothedAverage{
+		"host1": {Value: 30, Updated: own.Updated.Add(time.Minute)},
+	})
+	r.Equal(30.0, smoother.averages()["host1"].Value)
+}
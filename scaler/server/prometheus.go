@@ -0,0 +1,211 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/queue"
+)
+
+// prometheusStaleTicks is the number of query intervals after which
+// the last results of a promSource are no longer merged, so that a
+// Prometheus server that went away doesn't hold hosts up forever
+const prometheusStaleTicks = 3
+
+// promQueryResponse is the part of a Prometheus instant query
+// response that a promSource reads
+type promQueryResponse struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+	Data      struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Metric map[string]string `json:"metric"`
+			// Value is the sample's timestamp and its
+			// value, as a string
+			Value [2]interface{} `json:"value"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// promSource augments the counts from the interceptors with the result
+// of a PromQL query, for clusters where some of the traffic to a host
+// bypasses the interceptors, like traffic within a service mesh. The
+// query must return a vector with a sample per host, like
+//
+//	sum by (destination_service_name) (rate(istio_requests_total[1m]))
+//
+// whose hostLabel label has the host and whose value is the host's
+// load, in the same unit as its pending requests. If nsLabel is set,
+// each sample's namespace is in that label, and otherwise all the
+// samples are in the scaler's namespace.
+//
+// A host's count is the higher of its count from the interceptors and
+// its value from Prometheus, rounded up. See merge
+type promSource struct {
+	httpCl    *http.Client
+	queryURL  string
+	query     string
+	hostLabel string
+	nsLabel   string
+	ns        string
+	interval  time.Duration
+	mut       *sync.Mutex
+	// counts are the values from the last query that succeeded, by
+	// namespaced host, and updated is when it succeeded
+	counts  map[string]int
+	updated time.Time
+}
+
+// newPromSource creates a new promSource for the Prometheus server at
+// serverURL, whose query results are namespaced with ns unless nsLabel
+// is set. Returns an error if serverURL isn't a valid URL
+func newPromSource(
+	serverURL,
+	query,
+	hostLabel,
+	nsLabel,
+	ns string,
+	interval,
+	timeout time.Duration,
+) (*promSource, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing the Prometheus URL: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("the Prometheus URL %q has no scheme or host", serverURL)
+	}
+	u.Path = u.Path + "/api/v1/query"
+	return &promSource{
+		httpCl:    &http.Client{Timeout: timeout},
+		queryURL:  u.String(),
+		query:     query,
+		hostLabel: hostLabel,
+		nsLabel:   nsLabel,
+		ns:        ns,
+		interval:  interval,
+		mut:       new(sync.Mutex),
+		counts:    map[string]int{},
+	}, nil
+}
+
+// run queries Prometheus every p.interval until ctx is done. Failed
+// queries are logged, and the last results are kept until they're
+// stale
+func (p *promSource) run(ctx context.Context, lggr logr.Logger) error {
+	lggr = lggr.WithName("promSource.run")
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		if err := p.fetch(ctx); err != nil {
+			lggr.Error(err, "querying Prometheus", "query", p.query)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// fetch runs p's query and replaces p's counts with its result
+func (p *promSource) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.queryURL, nil)
+	if err != nil {
+		return err
+	}
+	req.URL.RawQuery = url.Values{"query": {p.query}}.Encode()
+	res, err := p.httpCl.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body := promQueryResponse{}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return fmt.Errorf("decoding the response from Prometheus (status %d): %w", res.StatusCode, err)
+	}
+	if body.Status != "success" {
+		return fmt.Errorf("Prometheus returned %s: %s", body.ErrorType, body.Error)
+	}
+	if body.Data.ResultType != "vector" {
+		return fmt.Errorf("the query returned a %s, not a vector", body.Data.ResultType)
+	}
+	counts := make(map[string]int, len(body.Data.Result))
+	for _, sample := range body.Data.Result {
+		host := sample.Metric[p.hostLabel]
+		if host == "" {
+			continue
+		}
+		ns := p.ns
+		if p.nsLabel != "" {
+			ns = sample.Metric[p.nsLabel]
+		}
+		valStr, ok := sample.Value[1].(string)
+		if !ok {
+			return fmt.Errorf("the sample for %s has no value", host)
+		}
+		val, err := strconv.ParseFloat(valStr, 64)
+		if err != nil {
+			return fmt.Errorf("parsing the value of the sample for %s: %w", host, err)
+		}
+		if math.IsNaN(val) || val <= 0 {
+			continue
+		}
+		key := queue.NamespacedKey(ns, host)
+		counts[key] += int(math.Ceil(val))
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	p.counts = counts
+	p.updated = time.Now()
+	return nil
+}
+
+// merge returns counts and breakdown, with each host's count raised to
+// its value from Prometheus if that's higher. The requests that
+// Prometheus adds are active, since they didn't go through an
+// interceptor. counts and breakdown aren't changed. Returns them as
+// they are if p is nil, or if its last results are stale
+func (p *promSource) merge(
+	now time.Time,
+	counts map[string]int,
+	breakdown map[string]queue.HostCounts,
+) (map[string]int, map[string]queue.HostCounts) {
+	if p == nil {
+		return counts, breakdown
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	if len(p.counts) == 0 || now.Sub(p.updated) > prometheusStaleTicks*p.interval {
+		return counts, breakdown
+	}
+	mergedCounts := make(map[string]int, len(counts))
+	mergedBreakdown := make(map[string]queue.HostCounts, len(breakdown))
+	for host, val := range counts {
+		mergedCounts[host] = val
+	}
+	for host, hc := range breakdown {
+		mergedBreakdown[host] = hc
+	}
+	for host, val := range p.counts {
+		cur, ok := mergedCounts[host]
+		if ok && cur >= val {
+			continue
+		}
+		mergedCounts[host] = val
+		hc := mergedBreakdown[host]
+		hc.Active += val - cur
+		mergedBreakdown[host] = hc
+	}
+	return mergedCounts, mergedBreakdown
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	kedanet "github.com/kedacore/http-add-on/pkg/net"
+	"github.com/kedacore/http-add-on/pkg/queue"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPromSource(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	const ns = "testns"
+
+	queries := make(chan string, 1)
+	hdl := http.NewServeMux()
+	hdl.HandleFunc("/api/v1/query", func(w http.ResponseWriter, req *http.Request) {
+		queries <- req.URL.Query().Get("query")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "success",
+			"data": map[string]interface{}{
+				"resultType": "vector",
+				"result": []interface{}{
+					map[string]interface{}{
+						"metric": map[string]string{"host": "host1"},
+						"value":  []interface{}{1700000000.0, "9.2"},
+					},
+					map[string]interface{}{
+						"metric": map[string]string{"host": "host3"},
+						"value":  []interface{}{1700000000.0, "4"},
+					},
+					map[string]interface{}{
+						"metric": map[string]string{"host": "host4"},
+						"value":  []interface{}{1700000000.0, "0"},
+					},
+				},
+			},
+		})
+	})
+	srv, url, err := kedanet.StartTestServer(hdl)
+	r.NoError(err)
+	defer srv.Close()
+
+	const query = `sum by (host) (rate(istio_requests_total[1m]))`
+	prom, err := newPromSource(url.String(), query, "host", "", ns, time.Minute, time.Second)
+	r.NoError(err)
+	r.NoError(prom.fetch(ctx))
+	r.Equal(query, <-queries)
+
+	host1 := queue.NamespacedKey(ns, "host1")
+	host2 := queue.NamespacedKey(ns, "host2")
+	host3 := queue.NamespacedKey(ns, "host3")
+	counts := map[string]int{host1: 2, host2: 5}
+	breakdown := map[string]queue.HostCounts{
+		host1: {Active: 1, Pending: 1},
+		host2: {Pending: 5},
+	}
+	merged, mergedBreakdown := prom.merge(time.Now(), counts, breakdown)
+	// values are rounded up, and hosts with no load
+	// in Prometheus aren't added
+	r.Equal(map[string]int{host1: 10, host2: 5, host3: 4}, merged)
+	r.Equal(queue.HostCounts{Active: 9, Pending: 1}, mergedBreakdown[host1])
+	r.Equal(queue.HostCounts{Pending: 5}, mergedBreakdown[host2])
+	r.Equal(queue.HostCounts{Active: 4}, mergedBreakdown[host3])
+	// the counts that were passed in aren't changed
+	r.Equal(2, counts[host1])
+	r.Equal(queue.HostCounts{Active: 1, Pending: 1}, breakdown[host1])
+
+	// stale results aren't merged
+	merged, _ = prom.merge(time.Now().Add(4*time.Minute), counts, breakdown)
+	r.Equal(counts, merged)
+
+	// a nil promSource merges nothing
+	var nilProm *promSource
+	merged, _ = nilProm.merge(time.Now(), counts, breakdown)
+	r.Equal(counts, merged)
+}
+
+func TestPromSourceErrors(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	_, err := newPromSource("prometheus:9090", "up", "host", "", "testns", time.Minute, time.Second)
+	r.Error(err)
+
+	hdl := http.NewServeMux()
+	hdl.HandleFunc("/api/v1/query", func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":    "error",
+			"errorType": "bad_data",
+			"error":     "parse error",
+		})
+	})
+	srv, url, err := kedanet.StartTestServer(hdl)
+	r.NoError(err)
+	defer srv.Close()
+
+	prom, err := newPromSource(url.String(), "up{", "host", "", "testns", time.Minute, time.Second)
+	r.NoError(err)
+	err = prom.fetch(ctx)
+	r.Error(err)
+	r.Contains(err.Error(), "bad_data")
+	merged, _ := prom.merge(time.Now(), map[string]int{"testns/host1": 1}, nil)
+	r.Equal(map[string]int{"testns/host1": 1}, merged)
+}
@@ -0,0 +1,633 @@
+// This file contains the implementation for the HTTP request queue used by the
+// KEDA external scaler implementation
+package server
+
+import (
+	"context"
+	"math/rand"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/k8s"
+	"github.com/kedacore/http-add-on/pkg/queue"
+	"golang.org/x/sync/errgroup"
+)
+
+// defaultSnapshotStaleDur is how long the queuePinger keeps using the
+// last counts it received from an interceptor that stopped responding,
+// as long as that interceptor is still in the endpoints list.
+const defaultSnapshotStaleDur = 5 * time.Second
+
+// defaultRestartResyncDur is how long the queuePinger keeps using the
+// counts that an interceptor had before it restarted, for the hosts
+// that haven't had a request since the restart
+const defaultRestartResyncDur = 10 * time.Second
+
+// interceptorSnapshot is the most recent set of counts that the
+// queuePinger received from a single interceptor
+type interceptorSnapshot struct {
+	counts   *queue.Counts
+	addr     string
+	fleet    string
+	lastSeen time.Time
+	// carried is the last snapshot from before the interceptor
+	// restarted, which is used until resyncUntil. It's nil if the
+	// interceptor didn't restart recently
+	carried     *queue.Counts
+	resyncUntil time.Time
+}
+
+// interceptorTombstone is the last snapshot of an interceptor that
+// the queuePinger stopped counting, because it left the endpoints list
+// or stopped responding. It's kept until expires, so that the
+// interceptor's counts can still be held if it comes back after a
+// restart
+type interceptorTombstone struct {
+	snap    interceptorSnapshot
+	expires time.Time
+}
+
+// hostCounts returns the count and the breakdown of each host in snap.
+//
+// An interceptor that just restarted reports zero for every host,
+// whether or not its clients are still sending requests. Until it
+// resyncs, hosts that it hasn't started a request for since the
+// restart are reported as they were before it, so that the restart
+// doesn't look like the host's traffic stopped
+func (snap interceptorSnapshot) hostCounts(
+	now time.Time,
+) (map[string]int, map[string]queue.HostCounts) {
+	counts := make(map[string]int, len(snap.counts.Counts))
+	breakdown := make(map[string]queue.HostCounts, len(snap.counts.Counts))
+	for host, val := range snap.counts.Counts {
+		counts[host] = val
+		breakdown[host] = snap.counts.Host(host)
+	}
+	if snap.carried == nil || !now.Before(snap.resyncUntil) {
+		return counts, breakdown
+	}
+	for host, val := range snap.carried.Counts {
+		if breakdown[host].Started > 0 || counts[host] >= val {
+			continue
+		}
+		counts[host] = val
+		breakdown[host] = snap.carried.Host(host)
+	}
+	return counts, breakdown
+}
+
+type queuePinger struct {
+	getEndpointsFn k8s.GetEndpointsFunc
+	ns             string
+	fleets         []interceptorFleet
+	adminPort      string
+	pingMut        *sync.RWMutex
+	lastPingTime   time.Time
+	allCounts      map[string]int
+	hostCounts     map[string]queue.HostCounts
+	// fleetCounts is the count of each host in each fleet, keyed by
+	// fleet name, then by host
+	fleetCounts    map[string]map[string]int
+	aggregateCount int
+	snapshots      map[string]interceptorSnapshot
+	tombstones     map[string]interceptorTombstone
+	staleAfter     time.Duration
+	restartResync  time.Duration
+	fallback       fallbackPolicy
+	// failedTicks is the number of ticks in a row in which no
+	// interceptor could be reached, and lastContact is the last
+	// time one could
+	failedTicks int
+	lastContact time.Time
+	// endpoints is the status of each interceptor endpoint that's
+	// currently in the endpoints list, keyed by address
+	endpoints map[string]*endpointStatus
+	// lastScrape describes how the last scrape went
+	lastScrape scrapeStats
+	// partial is what happens to the counts of interceptors that
+	// don't answer a tick. See partialPolicy
+	partial string
+	// updatedCh is closed and replaced every time the counts are
+	// recomputed. see updated()
+	updatedCh chan struct{}
+	// maxStaleness is how old the counts may get before refresh pings
+	// the interceptors on demand, instead of waiting for the next
+	// tick. 0 means they're never refreshed on demand
+	maxStaleness time.Duration
+	// scrapeTimeout is how long each interceptor may take to
+	// respond. 0 means there's no limit
+	scrapeTimeout time.Duration
+	// scrapeJitter is the longest that each interceptor's scrape is
+	// delayed by, at random, so that a large fleet isn't scraped all
+	// at once. 0 means they're not delayed
+	scrapeJitter time.Duration
+	// registry holds the interceptors that registered themselves,
+	// which are pinged along with the fleets. nil means there are
+	// none
+	registry *interceptorRegistry
+	// peers shares the counts with the other scaler replicas,
+	// whose higher counts are merged into this one's. nil means
+	// there are no other replicas
+	peers *peerSync
+	// prom augments the counts with the result of a PromQL query,
+	// for traffic that bypasses the interceptors. nil means they
+	// aren't augmented
+	prom *promSource
+	// localCounts and localHostCounts are allCounts and hostCounts
+	// before the other replicas' counts were merged into them
+	localCounts     map[string]int
+	localHostCounts map[string]queue.HostCounts
+	// refreshMut guards refreshCh and lastRefresh. refreshCh is
+	// closed when the on-demand ping in flight finishes, and is nil
+	// if there's none. lastRefresh is the time the last one started
+	refreshMut  *sync.Mutex
+	refreshCh   chan struct{}
+	lastRefresh time.Time
+	lggr        logr.Logger
+}
+
+func newQueuePinger(
+	ctx context.Context,
+	lggr logr.Logger,
+	getEndpointsFn k8s.GetEndpointsFunc,
+	ns string,
+	fleets []interceptorFleet,
+	adminPort string,
+	fallback fallbackPolicy,
+	pingTicker *time.Ticker,
+) *queuePinger {
+	pingMut := new(sync.RWMutex)
+	pinger := &queuePinger{
+		getEndpointsFn:  getEndpointsFn,
+		ns:              ns,
+		fleets:          fleets,
+		adminPort:       adminPort,
+		pingMut:         pingMut,
+		lggr:            lggr,
+		allCounts:       map[string]int{},
+		hostCounts:      map[string]queue.HostCounts{},
+		localCounts:     map[string]int{},
+		localHostCounts: map[string]queue.HostCounts{},
+		fleetCounts:     map[string]map[string]int{},
+		snapshots:       map[string]interceptorSnapshot{},
+		tombstones:      map[string]interceptorTombstone{},
+		endpoints:       map[string]*endpointStatus{},
+		staleAfter:      defaultSnapshotStaleDur,
+		restartResync:   defaultRestartResyncDur,
+		fallback:        fallback,
+		lastContact:     time.Now(),
+		updatedCh:       make(chan struct{}),
+		refreshMut:      new(sync.Mutex),
+	}
+
+	go func() {
+		defer pingTicker.Stop()
+		for range pingTicker.C {
+			if err := pinger.requestCounts(ctx); err != nil {
+				lggr.Error(err, "getting request counts")
+			}
+		}
+	}()
+
+	return pinger
+}
+
+// pingOnUpdate requests the counts every time the channel that
+// updated returns is closed, until ctx is done. Use it to ping new
+// interceptors, and stop pinging removed ones, as soon as the
+// endpoints list changes rather than on the next tick
+func (q *queuePinger) pingOnUpdate(
+	ctx context.Context,
+	updated func() <-chan struct{},
+) {
+	lggr := q.lggr.WithName("queuePinger.pingOnUpdate")
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-updated():
+			if err := q.requestCounts(ctx); err != nil {
+				lggr.Error(err, "getting request counts after the interceptor endpoints changed")
+			}
+		}
+	}
+}
+
+// refresh pings the interceptors if the counts are older than
+// q.maxStaleness, and waits until the ping finishes or ctx is done.
+// Concurrent callers share a single ping, and a ping that failed isn't
+// retried until q.maxStaleness after it started, so that a burst of
+// callers, or interceptors that can't be reached, don't multiply the
+// pings. Callers should use the counts they have whether or not the
+// refresh worked
+func (q *queuePinger) refresh(ctx context.Context) {
+	if q.maxStaleness <= 0 {
+		return
+	}
+	now := time.Now()
+	q.pingMut.RLock()
+	age := now.Sub(q.lastPingTime)
+	q.pingMut.RUnlock()
+	if age <= q.maxStaleness {
+		return
+	}
+
+	q.refreshMut.Lock()
+	done := q.refreshCh
+	if done == nil {
+		if now.Sub(q.lastRefresh) <= q.maxStaleness {
+			q.refreshMut.Unlock()
+			return
+		}
+		done = make(chan struct{})
+		q.refreshCh = done
+		q.lastRefresh = now
+		q.refreshMut.Unlock()
+		// the ping outlives the caller that started it, since
+		// other callers may be waiting for it too
+		go func() {
+			if err := q.requestCounts(context.Background()); err != nil {
+				q.lggr.Error(err, "getting request counts on demand")
+			}
+			q.refreshMut.Lock()
+			q.refreshCh = nil
+			q.refreshMut.Unlock()
+			close(done)
+		}()
+	} else {
+		q.refreshMut.Unlock()
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// countsAndBreakdown returns the results of counts and breakdown
+// from the same recomputation of the counts
+func (q *queuePinger) countsAndBreakdown() (map[string]int, map[string]queue.HostCounts) {
+	q.pingMut.RLock()
+	defer q.pingMut.RUnlock()
+	return q.allCounts, q.hostCounts
+}
+
+// localCountsAndBreakdown is countsAndBreakdown, without the counts
+// of the other scaler replicas merged in
+func (q *queuePinger) localCountsAndBreakdown() (map[string]int, map[string]queue.HostCounts) {
+	q.pingMut.RLock()
+	defer q.pingMut.RUnlock()
+	return q.localCounts, q.localHostCounts
+}
+
+func (q *queuePinger) counts() map[string]int {
+	q.pingMut.RLock()
+	defer q.pingMut.RUnlock()
+	return q.allCounts
+}
+
+// breakdown returns the breakdown of each host's count into active
+// and pending requests, summed across all interceptors
+func (q *queuePinger) breakdown() map[string]queue.HostCounts {
+	q.pingMut.RLock()
+	defer q.pingMut.RUnlock()
+	return q.hostCounts
+}
+
+// fleetBreakdown returns the count of each host in each interceptor
+// fleet, keyed by fleet name, then by host
+func (q *queuePinger) fleetBreakdown() map[string]map[string]int {
+	q.pingMut.RLock()
+	defer q.pingMut.RUnlock()
+	return q.fleetCounts
+}
+
+func (q *queuePinger) aggregate() int {
+	q.pingMut.RLock()
+	defer q.pingMut.RUnlock()
+	return q.aggregateCount
+}
+
+// updated returns a channel that is closed the next time the
+// counts are recomputed. Callers that want to be notified of
+// every update should call this again after each notification,
+// and before reading the counts, so they don't miss any updates
+func (q *queuePinger) updated() <-chan struct{} {
+	q.pingMut.RLock()
+	defer q.pingMut.RUnlock()
+	return q.updatedCh
+}
+
+// fetchResult is the result of fetching counts from
+// the interceptor at addr, in fleet
+type fetchResult struct {
+	addr   string
+	fleet  string
+	counts *queue.Counts
+	err    error
+}
+
+// requestCounts fetches counts from every interceptor endpoint, in
+// every fleet, and from every registered interceptor, along with the
+// other scaler replicas' counts if there are peers, then reconciles them with the counts it already has before recomputing
+// the totals. See reconcile for details.
+//
+// Returns a non-nil error if any interceptor, or any fleet's
+// endpoints, couldn't be reached. Counts from the interceptors that
+// could be reached are still used in that case. If none could be reached, q's fallback policy
+// decides whether the counts it already has are kept as they are.
+func (q *queuePinger) requestCounts(ctx context.Context) error {
+	lggr := q.lggr.WithName("queuePinger.requestCounts")
+
+	type fleetEndpoint struct {
+		fleet interceptorFleet
+		u     *url.URL
+	}
+	endpointURLs := []fleetEndpoint{}
+	var endpointsErr error
+	for _, fleet := range q.fleets {
+		urls, err := k8s.EndpointsForService(
+			ctx,
+			q.ns,
+			fleet.svcName,
+			q.adminPort,
+			q.getEndpointsFn,
+		)
+		if err != nil {
+			// the other fleets' counts are still good
+			lggr.Error(err, "getting interceptor endpoints", "fleet", fleet.name)
+			endpointsErr = err
+			continue
+		}
+		for _, u := range urls {
+			endpointURLs = append(endpointURLs, fleetEndpoint{fleet: fleet, u: u})
+		}
+	}
+	for _, entry := range q.registry.active(time.Now()) {
+		endpointURLs = append(endpointURLs, fleetEndpoint{
+			fleet: interceptorFleet{
+				name:    registeredFleetName(entry.Namespace),
+				adminCl: q.registry.adminCl,
+			},
+			u: &url.URL{Host: entry.Address},
+		})
+	}
+	if endpointsErr != nil && len(endpointURLs) == 0 {
+		q.recordContact(time.Now(), false)
+		return endpointsErr
+	}
+
+	// the other replicas are fetched alongside the interceptors,
+	// and their errors are only logged, since this replica's own
+	// counts are still good without them
+	peersDone := make(chan struct{})
+	go func() {
+		defer close(peersDone)
+		if err := q.peers.fetch(ctx, lggr); err != nil {
+			lggr.Error(err, "getting the other scaler replicas' counts")
+		}
+	}()
+
+	resultsCh := make(chan fetchResult, len(endpointURLs))
+	fetchGrp, _ := errgroup.WithContext(ctx)
+	for _, endpoint := range endpointURLs {
+		u := *endpoint.u
+		fleet := endpoint.fleet
+		u.Scheme = fleet.adminCl.scheme
+		fetchGrp.Go(func() error {
+			scrapeCtx, done := q.scrapeContext(ctx)
+			defer done()
+			counts, err := queue.GetCounts(
+				scrapeCtx,
+				lggr,
+				fleet.adminCl.httpCl,
+				u,
+			)
+			if err != nil {
+				lggr.Error(
+					err,
+					"getting queue counts from interceptor",
+					"interceptorAddress",
+					u.String(),
+					"fleet",
+					fleet.name,
+				)
+				resultsCh <- fetchResult{addr: u.Host, fleet: fleet.name, err: err}
+				return err
+			}
+			resultsCh <- fetchResult{addr: u.Host, fleet: fleet.name, counts: counts}
+			return nil
+		})
+	}
+	// resultsCh is buffered to hold a result from every endpoint,
+	// so the fetch goroutines never block on it
+	fetchErr := fetchGrp.Wait()
+	close(resultsCh)
+	<-peersDone
+
+	now := time.Now()
+	results := make([]fetchResult, 0, len(endpointURLs))
+	allResults := make([]fetchResult, 0, len(endpointURLs))
+	for res := range resultsCh {
+		allResults = append(allResults, res)
+		if res.err == nil {
+			results = append(results, res)
+		}
+	}
+	liveAddrs := make(map[string]struct{}, len(endpointURLs))
+	for _, endpoint := range endpointURLs {
+		liveAddrs[endpoint.u.Host] = struct{}{}
+	}
+	q.recordEndpoints(now, liveAddrs, allResults)
+	if hold := q.recordContact(now, len(results) > 0); hold {
+		lggr.Info(
+			"no interceptor could be reached, holding the last known counts",
+			"lastContact",
+			q.staleness(now).LastContact,
+		)
+	} else {
+		q.reconcile(now, liveAddrs, results)
+	}
+
+	if fetchErr != nil {
+		lggr.Error(fetchErr, "fetching all counts failed")
+		return fetchErr
+	}
+	return endpointsErr
+}
+
+// removeSnapshot stops counting snap, which is q's snapshot under key,
+// and keeps it as a tombstone until q.restartResync after now. q.pingMut
+// must be held for writing
+func (q *queuePinger) removeSnapshot(
+	now time.Time,
+	key string,
+	snap interceptorSnapshot,
+) {
+	delete(q.snapshots, key)
+	q.tombstones[key] = interceptorTombstone{
+		snap:    snap,
+		expires: now.Add(q.restartResync),
+	}
+}
+
+// scrapeContext waits for a random fraction of q.scrapeJitter, then
+// returns the context that a single interceptor's scrape uses, which is
+// done after q.scrapeTimeout. The scrape fails right away if ctx is
+// done before the wait is over. Callers must call the returned
+// function once the scrape is over
+func (q *queuePinger) scrapeContext(
+	ctx context.Context,
+) (context.Context, context.CancelFunc) {
+	if q.scrapeJitter > 0 {
+		select {
+		case <-time.After(time.Duration(rand.Int63n(int64(q.scrapeJitter)))):
+		case <-ctx.Done():
+		}
+	}
+	if q.scrapeTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, q.scrapeTimeout)
+}
+
+// reconcile merges results into the snapshots q already has, then
+// recomputes q's total and aggregate counts from those snapshots.
+//
+// Snapshots are keyed by the interceptor that produced them, so an
+// interceptor that is reachable at more than one address is only
+// counted once, and a snapshot that is older than one q already has
+// is discarded. Snapshots for interceptors that didn't respond are
+// kept, so their pending requests aren't missed while they're
+// temporarily unreachable, but they're dropped when the interceptor
+// leaves liveAddrs or hasn't responded for longer than q.staleAfter.
+// Dropped snapshots are kept as tombstones for q.restartResync, so
+// that an interceptor which restarted while it was out of the
+// endpoints list still has its previous counts held once it's back.
+// q's partial results policy can drop them right away instead, or
+// make up for them with the counts of the others in their fleet. See
+// partialPolicy.
+//
+// The totals are then merged with the other scaler replicas' counts,
+// if q has peers, and with the results of q's Prometheus query, if it
+// has one. See peerSync.merge and promSource.merge
+func (q *queuePinger) reconcile(
+	now time.Time,
+	liveAddrs map[string]struct{},
+	results []fetchResult,
+) {
+	q.pingMut.Lock()
+	defer q.pingMut.Unlock()
+
+	for _, res := range results {
+		key := res.counts.Source
+		if key == "" {
+			// untagged counts can't be de-duplicated, so
+			// fall back to identifying them by address
+			key = res.addr
+		}
+		// a new interceptor at an address means the
+		// previous one at that address is gone
+		for otherKey, snap := range q.snapshots {
+			if otherKey != key && snap.addr == res.addr {
+				q.removeSnapshot(now, otherKey, snap)
+			}
+		}
+		prev, ok := q.snapshots[key]
+		if tomb, found := q.tombstones[key]; found {
+			if !ok && now.Before(tomb.expires) {
+				prev, ok = tomb.snap, true
+			}
+			delete(q.tombstones, key)
+		}
+		restarted := ok && res.counts.RestartedSince(prev.counts)
+		if ok && !restarted && !res.counts.NewerThan(prev.counts) {
+			prev.lastSeen = now
+			q.snapshots[key] = prev
+			continue
+		}
+		snap := interceptorSnapshot{
+			counts:   res.counts,
+			addr:     res.addr,
+			fleet:    res.fleet,
+			lastSeen: now,
+		}
+		if restarted {
+			q.lggr.Info(
+				"interceptor restarted, holding its previous counts until it resyncs",
+				"interceptorAddress",
+				res.addr,
+			)
+			snap.carried = prev.counts
+			snap.resyncUntil = now.Add(q.restartResync)
+		} else if ok {
+			snap.carried = prev.carried
+			snap.resyncUntil = prev.resyncUntil
+		}
+		q.snapshots[key] = snap
+	}
+
+	staleAfter := q.staleAfter
+	if q.partialPolicy() == partialDrop {
+		staleAfter = 0
+	}
+	for key, snap := range q.snapshots {
+		_, live := liveAddrs[snap.addr]
+		if !live || now.Sub(snap.lastSeen) > staleAfter {
+			q.removeSnapshot(now, key, snap)
+		}
+	}
+	for key, tomb := range q.tombstones {
+		if !now.Before(tomb.expires) {
+			delete(q.tombstones, key)
+		}
+	}
+
+	totalCounts := make(map[string]int)
+	hostCounts := make(map[string]queue.HostCounts)
+	fleetCounts := make(map[string]map[string]int)
+	ratios := q.extrapolationRatios(now)
+	for _, snap := range q.snapshots {
+		ratio := 1.0
+		if fleetRatio, ok := ratios[snap.fleet]; ok {
+			// the others in the fleet make up for
+			// interceptors that didn't answer
+			if snap.lastSeen.Before(now) {
+				continue
+			}
+			ratio = fleetRatio
+		}
+		// each interceptor has a map of counts, one count
+		// per host. add up the counts for each host
+		counts, breakdown := snap.hostCounts(now)
+		if snap.fleet != "" && fleetCounts[snap.fleet] == nil {
+			fleetCounts[snap.fleet] = make(map[string]int, len(counts))
+		}
+		for host, val := range counts {
+			val = extrapolate(val, ratio)
+			totalCounts[host] += val
+			hostCounts[host] = hostCounts[host].Add(
+				extrapolateHostCounts(breakdown[host], ratio),
+			)
+			if snap.fleet != "" {
+				fleetCounts[snap.fleet][host] += val
+			}
+		}
+	}
+	q.localCounts = totalCounts
+	q.localHostCounts = hostCounts
+	totalCounts, hostCounts = q.peers.merge(totalCounts, hostCounts)
+	totalCounts, hostCounts = q.prom.merge(now, totalCounts, hostCounts)
+	agg := 0
+	for _, val := range totalCounts {
+		agg += val
+	}
+	q.allCounts = totalCounts
+	q.hostCounts = hostCounts
+	q.fleetCounts = fleetCounts
+	q.aggregateCount = agg
+	q.lastPingTime = now
+	close(q.updatedCh)
+	q.updatedCh = make(chan struct{})
+}
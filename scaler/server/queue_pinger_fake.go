@@ -1,4 +1,4 @@
-package main
+package server
 
 import (
 	context "context"
@@ -30,7 +30,7 @@ func startFakeQueueEndpointServer(
 	numEndpoints int,
 ) (*httptest.Server, *url.URL, *v1.Endpoints, error) {
 	hdl := http.NewServeMux()
-	queue.AddCountsRoute(logr.Discard(), hdl, q)
+	queue.AddCountsRoute(logr.Discard(), hdl, q, "")
 	srv, url, err := kedanet.StartTestServer(hdl)
 	if err != nil {
 		return nil, nil, nil, err
@@ -44,6 +44,7 @@ type fakeQueuePingerOpts struct {
 	endpoints *v1.Endpoints
 	tickDur   time.Duration
 	port      string
+	fallback  fallbackPolicy
 }
 
 type optsFunc func(*fakeQueuePingerOpts)
@@ -61,6 +62,7 @@ func newFakeQueuePinger(
 		endpoints: &v1.Endpoints{},
 		tickDur:   time.Second,
 		port:      "8080",
+		fallback:  fallbackPolicy{mode: fallbackNone},
 	}
 	for _, optsFunc := range optsFuncs {
 		optsFunc(opts)
@@ -78,8 +80,13 @@ func newFakeQueuePinger(
 
 		},
 		"testns",
-		"testsvc",
+		[]interceptorFleet{{
+			name:    "testsvc",
+			svcName: "testsvc",
+			adminCl: plainAdminClient(),
+		}},
 		opts.port,
+		opts.fallback,
 		ticker,
 	)
 	return ticker, pinger
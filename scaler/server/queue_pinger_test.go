@@ -0,0 +1,597 @@
+package server
+
+import (
+	context "context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/k8s"
+	kedanet "github.com/kedacore/http-add-on/pkg/net"
+	"github.com/kedacore/http-add-on/pkg/queue"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestRequestCounts(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	const (
+		ns      = "testns"
+		svcName = "testsvc"
+	)
+
+	// assemble an in-memory queue and start up a fake server that serves it.
+	// we'll configure the queue pinger to use that server below
+	counts := map[string]int{
+		"host1": 123,
+		"host2": 234,
+		"host3": 456,
+		"host4": 809,
+	}
+
+	q := queue.NewMemory()
+	for host, count := range counts {
+		q.Resize(host, count)
+	}
+
+	hdl := http.NewServeMux()
+	queue.AddCountsRoute(logr.Discard(), hdl, q, "")
+	srv, url, err := kedanet.StartTestServer(hdl)
+	r.NoError(err)
+	defer srv.Close()
+
+	endpoints := k8s.FakeEndpointsForURL(url, ns, svcName, 3)
+	// set the initial ticker to effectively never tick so that we
+	// can check the behavior of the pinger before the first
+	// tick
+	ticker := time.NewTicker(10000 * time.Hour)
+	pinger := newQueuePinger(
+		ctx,
+		logr.Discard(),
+		func(context.Context, string, string) (*v1.Endpoints, error) {
+			return endpoints, nil
+		},
+		ns,
+		[]interceptorFleet{{
+			name:    svcName,
+			svcName: svcName,
+			adminCl: plainAdminClient(),
+		}},
+		url.Port(),
+		fallbackPolicy{mode: fallbackNone},
+		ticker,
+	)
+	// the pinger starts a background watch loop but won't request the counts
+	// before the first tick. since the first tick effectively won't
+	// happen (it was set to a very long duration above), there should be
+	// no counts right now
+	retCounts := pinger.counts()
+	r.Equal(0, len(retCounts))
+
+	// reset the ticker to tick practically immediately. sleep for a little
+	// bit to ensure that the tick occurred and the counts were successfully
+	// computed, then check them.
+	ticker.Reset(1 * time.Nanosecond)
+	time.Sleep(50 * time.Millisecond)
+
+	// now that the tick has happened, there should be as many
+	// key/value pairs in the returned counts map as addresses
+	retCounts = pinger.counts()
+	r.Equal(len(counts), len(retCounts))
+
+	// every address is served by the same interceptor, so its
+	// counts should only be included once
+	for retHost, retCount := range retCounts {
+		expectedCount, ok := counts[retHost]
+		r.True(ok, "unexpected host %s returned", retHost)
+		r.Equal(
+			expectedCount,
+			retCount,
+			"count for host %s was not the expected %d",
+			retCount,
+			expectedCount,
+		)
+	}
+
+}
+
+func TestRefreshCoalesces(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	const (
+		ns      = "testns"
+		svcName = "testsvc"
+	)
+	q := queue.NewMemory()
+	r.NoError(q.Resize("host1", 3))
+	countsHdl := http.NewServeMux()
+	queue.AddCountsRoute(logr.Discard(), countsHdl, q, "")
+	// every ping waits until unblock is closed, so that all the
+	// refreshes below overlap
+	pings := int32(0)
+	unblock := make(chan struct{})
+	srv, url, err := kedanet.StartTestServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			atomic.AddInt32(&pings, 1)
+			<-unblock
+			countsHdl.ServeHTTP(w, req)
+		},
+	))
+	r.NoError(err)
+	defer srv.Close()
+	endpoints := k8s.FakeEndpointsForURL(url, ns, svcName, 1)
+	ticker := time.NewTicker(10000 * time.Hour)
+	defer ticker.Stop()
+	pinger := newQueuePinger(
+		ctx,
+		logr.Discard(),
+		func(context.Context, string, string) (*v1.Endpoints, error) {
+			return endpoints, nil
+		},
+		ns,
+		[]interceptorFleet{{
+			name:    svcName,
+			svcName: svcName,
+			adminCl: plainAdminClient(),
+		}},
+		url.Port(),
+		fallbackPolicy{mode: fallbackNone},
+		ticker,
+	)
+
+	// without a maximum staleness, nothing is refreshed
+	pinger.refresh(ctx)
+	r.Equal(int32(0), atomic.LoadInt32(&pings))
+
+	pinger.maxStaleness = time.Minute
+	grp := new(sync.WaitGroup)
+	for i := 0; i < 10; i++ {
+		grp.Add(1)
+		go func() {
+			defer grp.Done()
+			pinger.refresh(ctx)
+		}()
+	}
+	r.Eventually(func() bool {
+		return atomic.LoadInt32(&pings) == 1
+	}, time.Second, time.Millisecond)
+	close(unblock)
+	grp.Wait()
+	r.Equal(int32(1), atomic.LoadInt32(&pings))
+	r.Equal(3, pinger.counts()["host1"])
+
+	// the counts are fresh now, so they aren't refreshed again
+	pinger.refresh(ctx)
+	r.Equal(int32(1), atomic.LoadInt32(&pings))
+}
+
+func TestReconcileCounts(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	ticker, pinger := newFakeQueuePinger(ctx, logr.Discard())
+	defer ticker.Stop()
+
+	newCounts := func(source string, epoch int64, gen uint64, count int) *queue.Counts {
+		ret := queue.NewCounts()
+		ret.Source = source
+		ret.Epoch = epoch
+		ret.Generation = gen
+		ret.Counts["host1"] = count
+		return ret
+	}
+	liveAddrs := map[string]struct{}{
+		"1.2.3.4:8080": {},
+		"2.3.4.5:8080": {},
+	}
+	now := time.Now()
+
+	// two interceptors report counts, so they should be added up
+	pinger.reconcile(now, liveAddrs, []fetchResult{
+		{addr: "1.2.3.4:8080", counts: newCounts("interceptor1", 1, 1, 10)},
+		{addr: "2.3.4.5:8080", counts: newCounts("interceptor2", 1, 1, 20)},
+	})
+	r.Equal(30, pinger.counts()["host1"])
+	r.Equal(30, pinger.aggregate())
+
+	// interceptor2 didn't respond, so its previous counts should
+	// still be used. interceptor1 sent an older snapshot, which
+	// should be ignored
+	pinger.reconcile(now, liveAddrs, []fetchResult{
+		{addr: "1.2.3.4:8080", counts: newCounts("interceptor1", 1, 0, 100)},
+	})
+	r.Equal(30, pinger.counts()["host1"])
+
+	// interceptor1 restarted, so its new epoch should replace the
+	// old one even though the generation is lower. until it resyncs,
+	// its lower count is taken as an artifact of the restart
+	pinger.reconcile(now, liveAddrs, []fetchResult{
+		{addr: "1.2.3.4:8080", counts: newCounts("interceptor1", 2, 1, 5)},
+		{addr: "2.3.4.5:8080", counts: newCounts("interceptor2", 1, 2, 20)},
+	})
+	r.Equal(30, pinger.counts()["host1"])
+	// until it starts a request for the host
+	restarted := newCounts("interceptor1", 2, 2, 5)
+	restarted.Hosts["host1"] = queue.HostCounts{Active: 5, Started: 5}
+	pinger.reconcile(now, liveAddrs, []fetchResult{
+		{addr: "1.2.3.4:8080", counts: restarted},
+		{addr: "2.3.4.5:8080", counts: newCounts("interceptor2", 1, 3, 20)},
+	})
+	r.Equal(25, pinger.counts()["host1"])
+	// or the resync period is over
+	pinger.reconcile(now, liveAddrs, []fetchResult{
+		{addr: "1.2.3.4:8080", counts: newCounts("interceptor1", 3, 1, 0)},
+	})
+	r.Equal(25, pinger.counts()["host1"])
+	pinger.reconcile(now.Add(pinger.restartResync), liveAddrs, []fetchResult{
+		{addr: "1.2.3.4:8080", counts: newCounts("interceptor1", 3, 2, 0)},
+		{addr: "2.3.4.5:8080", counts: newCounts("interceptor2", 1, 4, 20)},
+	})
+	r.Equal(20, pinger.counts()["host1"])
+
+	// interceptor2 hasn't responded for longer than the stale
+	// duration, so its counts should be dropped
+	now = now.Add(pinger.restartResync)
+	pinger.reconcile(now.Add(pinger.staleAfter*2), liveAddrs, []fetchResult{
+		{addr: "1.2.3.4:8080", counts: newCounts("interceptor1", 3, 3, 5)},
+	})
+	r.Equal(5, pinger.counts()["host1"])
+
+	// interceptor1 left the endpoints list, so its counts should
+	// be dropped right away
+	pinger.reconcile(now.Add(pinger.staleAfter*2), map[string]struct{}{}, nil)
+	r.Equal(0, len(pinger.counts()))
+	r.Equal(0, pinger.aggregate())
+}
+
+func TestReconcileRestartOutOfEndpoints(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	ticker, pinger := newFakeQueuePinger(ctx, logr.Discard())
+	defer ticker.Stop()
+
+	newCounts := func(epoch int64, gen uint64, count int) *queue.Counts {
+		ret := queue.NewCounts()
+		ret.Source = "interceptor1"
+		ret.Epoch = epoch
+		ret.Generation = gen
+		ret.Counts["host1"] = count
+		return ret
+	}
+	liveAddrs := map[string]struct{}{"1.2.3.4:8080": {}}
+	now := time.Now()
+	pinger.reconcile(now, liveAddrs, []fetchResult{
+		{addr: "1.2.3.4:8080", counts: newCounts(1, 1, 10)},
+	})
+	r.Equal(10, pinger.counts()["host1"])
+
+	// the interceptor restarts, and is out of the endpoints
+	// list for longer than the stale duration, so its counts
+	// are dropped
+	now = now.Add(pinger.staleAfter * 2)
+	pinger.reconcile(now, map[string]struct{}{}, nil)
+	r.Equal(0, len(pinger.counts()))
+
+	// once it's back, its previous counts are held
+	// until it resyncs
+	now = now.Add(pinger.staleAfter)
+	pinger.reconcile(now, liveAddrs, []fetchResult{
+		{addr: "1.2.3.4:8080", counts: newCounts(2, 1, 0)},
+	})
+	r.Equal(10, pinger.counts()["host1"])
+	pinger.reconcile(now.Add(pinger.restartResync), liveAddrs, []fetchResult{
+		{addr: "1.2.3.4:8080", counts: newCounts(2, 2, 7)},
+	})
+	r.Equal(7, pinger.counts()["host1"])
+
+	// the previous counts aren't held once the
+	// tombstone expires
+	now = now.Add(pinger.restartResync)
+	pinger.reconcile(now, map[string]struct{}{}, nil)
+	now = now.Add(pinger.restartResync)
+	pinger.reconcile(now, map[string]struct{}{}, nil)
+	pinger.reconcile(now, liveAddrs, []fetchResult{
+		{addr: "1.2.3.4:8080", counts: newCounts(3, 1, 0)},
+	})
+	r.Equal(0, pinger.counts()["host1"])
+}
+
+func TestReconcileBreakdown(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	ticker, pinger := newFakeQueuePinger(ctx, logr.Discard())
+	defer ticker.Stop()
+	liveAddrs := map[string]struct{}{
+		"1.2.3.4:8080": {},
+		"2.3.4.5:8080": {},
+	}
+
+	v2Counts := queue.NewCounts()
+	v2Counts.Source = "interceptor1"
+	v2Counts.Counts["host1"] = 10
+	v2Counts.Hosts["host1"] = queue.HostCounts{
+		Active:             6,
+		Pending:            4,
+		OldestPendingAgeMS: 2000,
+	}
+	// older interceptors don't send a breakdown, so
+	// all their requests are considered active
+	v1Counts := &queue.Counts{
+		Counts:  map[string]int{"host1": 5},
+		Source:  "interceptor2",
+		Version: 1,
+	}
+	pinger.reconcile(time.Now(), liveAddrs, []fetchResult{
+		{addr: "1.2.3.4:8080", counts: v2Counts},
+		{addr: "2.3.4.5:8080", counts: v1Counts},
+	})
+	r.Equal(15, pinger.counts()["host1"])
+	r.Equal(queue.HostCounts{
+		Active:             11,
+		Pending:            4,
+		OldestPendingAgeMS: 2000,
+	}, pinger.breakdown()["host1"])
+}
+
+func TestReconcileFleets(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	ticker, pinger := newFakeQueuePinger(ctx, logr.Discard())
+	defer ticker.Stop()
+	liveAddrs := map[string]struct{}{
+		"1.2.3.4:8080": {},
+		"2.3.4.5:8080": {},
+		"3.4.5.6:8080": {},
+	}
+	newCounts := func(source string, count int) *queue.Counts {
+		ret := queue.NewCounts()
+		ret.Source = source
+		ret.Counts["host1"] = count
+		return ret
+	}
+
+	// the counts of every fleet are merged, and also
+	// reported for each fleet apart
+	pinger.reconcile(time.Now(), liveAddrs, []fetchResult{
+		{addr: "1.2.3.4:8080", fleet: "zone-a", counts: newCounts("interceptor1", 10)},
+		{addr: "2.3.4.5:8080", fleet: "zone-a", counts: newCounts("interceptor2", 20)},
+		{addr: "3.4.5.6:8080", fleet: "zone-b", counts: newCounts("interceptor3", 5)},
+	})
+	r.Equal(35, pinger.counts()["host1"])
+	r.Equal(map[string]map[string]int{
+		"zone-a": {"host1": 30},
+		"zone-b": {"host1": 5},
+	}, pinger.fleetBreakdown())
+}
+
+func TestRequestCountsFleets(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	const ns = "testns"
+
+	q := queue.NewMemory()
+	r.NoError(q.Resize("host1", 3))
+	hdl := http.NewServeMux()
+	queue.AddCountsRoute(logr.Discard(), hdl, q, "")
+	srv, url, err := kedanet.StartTestServer(hdl)
+	r.NoError(err)
+	defer srv.Close()
+
+	endpoints := k8s.FakeEndpointsForURL(url, ns, "zone-a-svc", 1)
+	ticker := time.NewTicker(10000 * time.Hour)
+	defer ticker.Stop()
+	pinger := newQueuePinger(
+		ctx,
+		logr.Discard(),
+		func(_ context.Context, _, svcName string) (*v1.Endpoints, error) {
+			if svcName != "zone-a-svc" {
+				return nil, errors.New("no such service")
+			}
+			return endpoints, nil
+		},
+		ns,
+		[]interceptorFleet{
+			{name: "zone-a", svcName: "zone-a-svc", adminCl: plainAdminClient()},
+			{name: "zone-b", svcName: "zone-b-svc", adminCl: plainAdminClient()},
+		},
+		url.Port(),
+		fallbackPolicy{mode: fallbackNone},
+		ticker,
+	)
+
+	// a fleet whose endpoints can't be listed is an error, but
+	// the other fleets' counts are still used
+	r.Error(pinger.requestCounts(ctx))
+	r.Equal(3, pinger.counts()["host1"])
+	r.Equal(map[string]map[string]int{
+		"zone-a": {"host1": 3},
+	}, pinger.fleetBreakdown())
+	for _, status := range pinger.endpointStatuses() {
+		r.Equal("zone-a", status.Fleet)
+	}
+}
+
+func TestRecordEndpoints(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	ticker, pinger := newFakeQueuePinger(ctx, logr.Discard())
+	defer ticker.Stop()
+	liveAddrs := map[string]struct{}{
+		"1.2.3.4:8080": {},
+		"2.3.4.5:8080": {},
+	}
+	now := time.Now()
+
+	pinger.recordEndpoints(now, liveAddrs, []fetchResult{
+		{addr: "1.2.3.4:8080", counts: queue.NewCounts()},
+		{addr: "2.3.4.5:8080", err: errors.New("connection refused")},
+	})
+	pinger.recordEndpoints(now, liveAddrs, []fetchResult{
+		{addr: "1.2.3.4:8080", err: errors.New("timeout")},
+		{addr: "2.3.4.5:8080", err: errors.New("connection refused")},
+	})
+	statuses := pinger.endpointStatuses()
+	r.Len(statuses, 2)
+	r.Equal(1, statuses["1.2.3.4:8080"].ConsecutiveFailures)
+	r.Equal(now, statuses["1.2.3.4:8080"].LastSuccess)
+	r.Equal("timeout", statuses["1.2.3.4:8080"].LastError)
+	r.Equal(2, statuses["2.3.4.5:8080"].ConsecutiveFailures)
+	r.True(statuses["2.3.4.5:8080"].LastSuccess.IsZero())
+
+	// a success resets the consecutive failures, but not the total,
+	// and removed endpoints are forgotten
+	pinger.recordEndpoints(now, map[string]struct{}{"2.3.4.5:8080": {}}, []fetchResult{
+		{addr: "2.3.4.5:8080", counts: queue.NewCounts()},
+	})
+	statuses = pinger.endpointStatuses()
+	r.Len(statuses, 1)
+	r.Equal(0, statuses["2.3.4.5:8080"].ConsecutiveFailures)
+	r.Equal(2, statuses["2.3.4.5:8080"].TotalFailures)
+	r.Empty(statuses["2.3.4.5:8080"].LastError)
+}
+
+func TestPingOnUpdate(t *testing.T) {
+	r := require.New(t)
+	ctx, done := context.WithCancel(context.Background())
+	defer done()
+	const host = "TestPingOnUpdate.testing"
+
+	q := queue.NewMemory()
+	q.Resize(host, 3)
+	hdl := http.NewServeMux()
+	queue.AddCountsRoute(logr.Discard(), hdl, q, "")
+	srv, url, err := kedanet.StartTestServer(hdl)
+	r.NoError(err)
+	defer srv.Close()
+
+	// the ticker never ticks, so the counts are
+	// only requested when the endpoints change
+	ticker, pinger := newFakeQueuePinger(
+		ctx,
+		logr.Discard(),
+		func(opts *fakeQueuePingerOpts) { opts.endpoints = k8s.FakeEndpointsForURL(url, "testns", "testsvc", 1) },
+		func(opts *fakeQueuePingerOpts) { opts.tickDur = 10000 * time.Hour },
+		func(opts *fakeQueuePingerOpts) { opts.port = url.Port() },
+	)
+	defer ticker.Stop()
+	updatedCh := make(chan struct{})
+	go pinger.pingOnUpdate(ctx, func() <-chan struct{} { return updatedCh })
+	r.Equal(0, pinger.counts()[host])
+
+	close(updatedCh)
+	r.Eventually(func() bool {
+		return pinger.counts()[host] == 3
+	}, time.Second, 10*time.Millisecond)
+	r.Len(pinger.endpointStatuses(), 1)
+}
+
+func TestRequestCountsOverH2C(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	const (
+		ns      = "testns"
+		svcName = "testsvc"
+	)
+	q := queue.NewMemory()
+	r.NoError(q.Resize("host1", 3))
+	countsHdl := http.NewServeMux()
+	queue.AddCountsRoute(logr.Discard(), countsHdl, q, "")
+	protos := make(chan int, 10)
+	srv := httptest.NewUnstartedServer(h2c.NewHandler(
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			protos <- req.ProtoMajor
+			countsHdl.ServeHTTP(w, req)
+		}),
+		&http2.Server{},
+	))
+	conns := int32(0)
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+	srvURL, err := url.Parse(srv.URL)
+	r.NoError(err)
+
+	endpoints := k8s.FakeEndpointsForURL(srvURL, ns, svcName, 1)
+	ticker := time.NewTicker(10000 * time.Hour)
+	defer ticker.Stop()
+	pinger := newQueuePinger(
+		ctx,
+		logr.Discard(),
+		func(context.Context, string, string) (*v1.Endpoints, error) {
+			return endpoints, nil
+		},
+		ns,
+		[]interceptorFleet{{
+			name:    svcName,
+			svcName: svcName,
+			adminCl: h2cAdminClient(),
+		}},
+		srvURL.Port(),
+		fallbackPolicy{mode: fallbackNone},
+		ticker,
+	)
+	pinger.scrapeJitter = 10 * time.Millisecond
+	for i := 0; i < 3; i++ {
+		r.NoError(pinger.requestCounts(ctx))
+		r.Equal(2, <-protos)
+	}
+	r.Equal(map[string]int{"host1": 3}, pinger.counts())
+	// every scrape shared the same connection
+	r.EqualValues(1, atomic.LoadInt32(&conns))
+}
+
+func TestRequestCountsScrapeTimeout(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	const (
+		ns      = "testns"
+		svcName = "testsvc"
+	)
+	// the interceptor never responds, until the test is over
+	unblock := make(chan struct{})
+	srv, url, err := kedanet.StartTestServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			<-unblock
+		},
+	))
+	r.NoError(err)
+	defer srv.Close()
+	// the server waits for its handlers before it closes
+	defer close(unblock)
+	endpoints := k8s.FakeEndpointsForURL(url, ns, svcName, 1)
+	ticker := time.NewTicker(10000 * time.Hour)
+	defer ticker.Stop()
+	pinger := newQueuePinger(
+		ctx,
+		logr.Discard(),
+		func(context.Context, string, string) (*v1.Endpoints, error) {
+			return endpoints, nil
+		},
+		ns,
+		[]interceptorFleet{{
+			name:    svcName,
+			svcName: svcName,
+			adminCl: plainAdminClient(),
+		}},
+		url.Port(),
+		fallbackPolicy{mode: fallbackNone},
+		ticker,
+	)
+	pinger.scrapeTimeout = 50 * time.Millisecond
+	start := time.Now()
+	r.Error(pinger.requestCounts(ctx))
+	r.Less(time.Since(start), time.Second)
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	kedahttp "github.com/kedacore/http-add-on/pkg/http"
+	"github.com/kedacore/http-add-on/pkg/queue"
+)
+
+// maxRegistrationTTL is the longest that the registry keeps a
+// registration, whatever TTL it asks for
+const maxRegistrationTTL = 5 * time.Minute
+
+// registeredFleetName returns the name of the fleet that the
+// interceptors that registered themselves in ns are counted under
+func registeredFleetName(ns string) string {
+	return "namespace/" + ns
+}
+
+// registeredInterceptor is a registration, and the time it runs out
+type registeredInterceptor struct {
+	queue.Registration
+	Expires time.Time `json:"expires"`
+}
+
+// interceptorRegistry holds the interceptors that registered
+// themselves with the scaler, rather than being found through the
+// EndpointSlices of one of its fleets' Services. The queuePinger
+// scrapes them along with the fleets, until their registrations run
+// out or they deregister
+type interceptorRegistry struct {
+	mut     *sync.Mutex
+	entries map[string]registeredInterceptor
+	// adminCl is how the registered
+	// interceptors are scraped
+	adminCl adminClient
+}
+
+func newInterceptorRegistry(adminCl adminClient) *interceptorRegistry {
+	return &interceptorRegistry{
+		mut:     new(sync.Mutex),
+		entries: map[string]registeredInterceptor{},
+		adminCl: adminCl,
+	}
+}
+
+// register adds reg to r, or renews it, as of now. Returns an error if
+// reg doesn't name a namespace and pod, or has an invalid address
+func (r *interceptorRegistry) register(reg queue.Registration, now time.Time) error {
+	if reg.Namespace == "" || reg.Pod == "" {
+		return fmt.Errorf("registrations need a namespace and a pod")
+	}
+	if _, _, err := net.SplitHostPort(reg.Address); err != nil {
+		return fmt.Errorf("invalid address %q (%w)", reg.Address, err)
+	}
+	ttl := time.Duration(reg.TTLSeconds) * time.Second
+	if ttl <= 0 || ttl > maxRegistrationTTL {
+		ttl = maxRegistrationTTL
+	}
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.entries[reg.Key()] = registeredInterceptor{
+		Registration: reg,
+		Expires:      now.Add(ttl),
+	}
+	return nil
+}
+
+// deregister removes reg from r
+func (r *interceptorRegistry) deregister(reg queue.Registration) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	delete(r.entries, reg.Key())
+}
+
+// active returns the registrations in r that haven't run out as of
+// now, sorted by key, and forgets the ones that have. A nil
+// interceptorRegistry has none
+func (r *interceptorRegistry) active(now time.Time) []registeredInterceptor {
+	if r == nil {
+		return nil
+	}
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	ret := make([]registeredInterceptor, 0, len(r.entries))
+	for key, entry := range r.entries {
+		if !now.Before(entry.Expires) {
+			delete(r.entries, key)
+			continue
+		}
+		ret = append(ret, entry)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Key() < ret[j].Key()
+	})
+	return ret
+}
+
+// MarshalJSON implements json.Marshaler. It returns the
+// registrations that haven't run out
+func (r *interceptorRegistry) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.active(time.Now()))
+}
+
+// registrationHandler serves the registration endpoint. POST registers
+// the interceptor in the body, DELETE deregisters it, and GET returns
+// the registrations. All of them require token as a bearer token
+func registrationHandler(
+	lggr logr.Logger,
+	r *interceptorRegistry,
+	token string,
+) http.Handler {
+	lggr = lggr.WithName("registrationHandler")
+	return kedahttp.BearerAuth(
+		token,
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Method == http.MethodGet {
+				if err := json.NewEncoder(w).Encode(r); err != nil {
+					lggr.Error(err, "writing interceptor registrations to client")
+				}
+				return
+			}
+			if req.Method != http.MethodPost && req.Method != http.MethodDelete {
+				w.Header().Set("Allow", "GET, POST, DELETE")
+				w.WriteHeader(405)
+				return
+			}
+			reg := queue.Registration{}
+			if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, 4096)).Decode(&reg); err != nil {
+				w.WriteHeader(400)
+				w.Write([]byte("invalid registration"))
+				return
+			}
+			if req.Method == http.MethodDelete {
+				r.deregister(reg)
+				lggr.Info("interceptor deregistered", "key", reg.Key())
+				w.WriteHeader(204)
+				return
+			}
+			if err := r.register(reg, time.Now()); err != nil {
+				w.WriteHeader(400)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			lggr.V(1).Info("interceptor registered", "key", reg.Key(), "address", reg.Address)
+			w.WriteHeader(204)
+		}),
+	)
+}
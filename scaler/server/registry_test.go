@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	kedanet "github.com/kedacore/http-add-on/pkg/net"
+	"github.com/kedacore/http-add-on/pkg/queue"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestInterceptorRegistry(t *testing.T) {
+	r := require.New(t)
+	now := time.Now()
+	registry := newInterceptorRegistry(plainAdminClient())
+	reg := queue.Registration{
+		Namespace:  "team-a",
+		Pod:        "interceptor-1",
+		Address:    "10.0.0.1:9090",
+		TTLSeconds: 30,
+	}
+	r.NoError(registry.register(reg, now))
+	r.Len(registry.active(now), 1)
+	// registrations run out unless they're renewed
+	r.Len(registry.active(now.Add(31*time.Second)), 0)
+
+	r.NoError(registry.register(reg, now))
+	registry.deregister(reg)
+	r.Len(registry.active(now), 0)
+
+	// registrations without a namespace, pod or valid address
+	// are rejected
+	r.Error(registry.register(queue.Registration{Pod: "p", Address: "10.0.0.1:9090"}, now))
+	r.Error(registry.register(queue.Registration{Namespace: "ns", Address: "10.0.0.1:9090"}, now))
+	r.Error(registry.register(queue.Registration{Namespace: "ns", Pod: "p", Address: "10.0.0.1"}, now))
+
+	var nilRegistry *interceptorRegistry
+	r.Empty(nilRegistry.active(now))
+}
+
+func TestRegistrationHandler(t *testing.T) {
+	r := require.New(t)
+	registry := newInterceptorRegistry(plainAdminClient())
+	hdl := registrationHandler(logr.Discard(), registry, "secret")
+	reg := queue.Registration{
+		Namespace:  "team-a",
+		Pod:        "interceptor-1",
+		Address:    "10.0.0.1:9090",
+		TTLSeconds: 30,
+	}
+	body, err := json.Marshal(reg)
+	r.NoError(err)
+
+	// registrations need the token
+	req := httptest.NewRequest("POST", queue.RegistrationPath, bytes.NewReader(body))
+	res := httptest.NewRecorder()
+	hdl.ServeHTTP(res, req)
+	r.Equal(401, res.Code)
+	r.Len(registry.active(time.Now()), 0)
+
+	req = httptest.NewRequest("POST", queue.RegistrationPath, bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	res = httptest.NewRecorder()
+	hdl.ServeHTTP(res, req)
+	r.Equal(204, res.Code)
+	r.Len(registry.active(time.Now()), 1)
+
+	req = httptest.NewRequest("DELETE", queue.RegistrationPath, bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	res = httptest.NewRecorder()
+	hdl.ServeHTTP(res, req)
+	r.Equal(204, res.Code)
+	r.Len(registry.active(time.Now()), 0)
+}
+
+// the pinger should scrape registered interceptors along with its
+// fleets, and count them under their namespace's fleet
+func TestRequestCountsRegistered(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	q := queue.NewMemory()
+	r.NoError(q.Resize("host1", 2))
+	mux := http.NewServeMux()
+	queue.AddCountsRoute(logr.Discard(), mux, q, "team-a")
+	srv, srvURL, err := kedanet.StartTestServer(mux)
+	r.NoError(err)
+	defer srv.Close()
+
+	ticker := time.NewTicker(10000 * time.Hour)
+	defer ticker.Stop()
+	pinger := newQueuePinger(
+		ctx,
+		logr.Discard(),
+		func(context.Context, string, string) (*v1.Endpoints, error) {
+			return &v1.Endpoints{}, nil
+		},
+		"testns",
+		[]interceptorFleet{},
+		"8080",
+		fallbackPolicy{mode: fallbackNone},
+		ticker,
+	)
+	pinger.registry = newInterceptorRegistry(plainAdminClient())
+	r.NoError(pinger.registry.register(queue.Registration{
+		Namespace:  "team-a",
+		Pod:        "interceptor-1",
+		Address:    srvURL.Host,
+		TTLSeconds: 30,
+	}, time.Now()))
+
+	r.NoError(pinger.requestCounts(ctx))
+	key := queue.NamespacedKey("team-a", "host1")
+	r.Equal(2, pinger.counts()[key])
+	r.Equal(map[string]map[string]int{
+		registeredFleetName("team-a"): {key: 2},
+	}, pinger.fleetBreakdown())
+}
@@ -0,0 +1,300 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/health"
+	"github.com/kedacore/http-add-on/pkg/k8s"
+	"github.com/kedacore/http-add-on/pkg/queue"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"golang.org/x/sync/errgroup"
+	"k8s.io/client-go/rest"
+)
+
+// Run runs the scaler that cfg describes in the cluster it's in, along
+// with its health check server, until ctx is done or one of its
+// servers fails
+func Run(ctx context.Context, lggr logr.Logger, cfg *Config) error {
+	ctx, done := context.WithCancel(ctx)
+	defer done()
+	namespace := cfg.TargetNamespace
+
+	k8sCl, _, err := k8s.NewClientset()
+	if err != nil {
+		return fmt.Errorf("getting a Kubernetes client (%w)", err)
+	}
+	fleets, err := parseInterceptorFleets(cfg)
+	if err != nil {
+		return fmt.Errorf("configuring the interceptor fleets (%w)", err)
+	}
+	fleetSvcs := make([]string, len(fleets))
+	for idx, fleet := range fleets {
+		fleetSvcs[idx] = fleet.svcName
+	}
+	// the other scaler replicas' EndpointSlices
+	// are watched along with the fleets'
+	watchedSvcs := fleetSvcs
+	if cfg.PeerService != "" {
+		watchedSvcs = append(watchedSvcs, cfg.PeerService)
+	}
+	// the EndpointSlices of every fleet's interceptors are watched,
+	// so new interceptors are pinged as soon as they're ready
+	restCfg, err := rest.InClusterConfig()
+	if err != nil {
+		return fmt.Errorf("getting the Kubernetes client config (%w)", err)
+	}
+	k8sCache, err := k8s.NewCache(
+		restCfg,
+		namespace,
+		cfg.EndpointsResyncDur,
+		k8s.WithSelectors(k8s.EndpointSliceSelector(watchedSvcs...)),
+	)
+	if err != nil {
+		return fmt.Errorf("creating the Kubernetes cache (%w)", err)
+	}
+	endpointSlices, err := k8s.NewInformerEndpointSliceCache(
+		ctx,
+		k8sCache,
+		namespace,
+		watchedSvcs...,
+	)
+	if err != nil {
+		return fmt.Errorf("creating the endpoint slices cache (%w)", err)
+	}
+
+	table := routing.NewTable()
+	scaler, err := New(ctx, lggr, cfg, endpointSlices.GetEndpoints, table)
+	if err != nil {
+		return err
+	}
+	pinger := scaler.pinger
+	var registrations http.Handler
+	if pinger.registry != nil {
+		registrations = registrationHandler(lggr, pinger.registry, cfg.RegistrationToken)
+	}
+	var peerState http.Handler
+	if pinger.peers != nil {
+		peerState = peerStateHandler(lggr, pinger, scaler.impl.smoother)
+	}
+	var metricsAPI http.Handler
+	if cfg.APIToken != "" {
+		metricsAPI = newMetricsAPIHandler(lggr, scaler.impl, table, cfg.APIToken)
+	}
+
+	// with leader election, only the leader serves gRPC, so
+	// only the leader is ready for KEDA to connect to
+	grpcServing := &health.Flag{Reason: "the gRPC server is not serving"}
+	readyChecks := map[string]health.Check{
+		"grpcServer":   grpcServing.Check,
+		"routingTable": health.SyncedCheck("the routing table", table.HasSynced),
+		"interceptorEndpoints": health.SyncedCheck(
+			"the interceptor endpoints",
+			endpointSlices.HasSynced,
+		),
+	}
+
+	grp, ctx := errgroup.WithContext(ctx)
+	grp.Go(func() error {
+		defer done()
+		runGrpcServer := func(ctx context.Context) error {
+			return startGrpcServer(
+				ctx,
+				lggr,
+				cfg.GRPCPort,
+				scaler,
+				grpcServing,
+				readyChecks,
+			)
+		}
+		if !cfg.LeaderElection {
+			return runGrpcServer(ctx)
+		}
+		// only the leader serves metrics to KEDA, so that
+		// multiple scaler replicas don't report the same
+		// queue counts more than once
+		identity, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		return k8s.RunWithLeaderElection(
+			ctx,
+			lggr,
+			k8sCl,
+			k8s.LeaderElectionConfig{
+				Namespace:     cfg.TargetNamespace,
+				LeaseName:     cfg.LeaderElectionLeaseName,
+				Identity:      identity,
+				LeaseDuration: cfg.LeaderElectionLeaseDuration,
+				RenewDeadline: cfg.LeaderElectionRenewDeadline,
+				RetryPeriod:   cfg.LeaderElectionRetryPeriod,
+			},
+			runGrpcServer,
+		)
+	})
+
+	grp.Go(func() error {
+		defer done()
+		return routing.StartConfigMapRoutingTableInformer(
+			ctx,
+			lggr,
+			k8sCl,
+			cfg.TargetNamespace,
+			cfg.RoutingTableResyncDur,
+			table,
+			// we don't care about the queue here.
+			// we just want to update the routing table
+			// so that the scaler can use it to determine
+			// the target metrics for given hosts.
+			queue.NewMemory(),
+		)
+	})
+	if pinger.prom != nil {
+		grp.Go(func() error {
+			defer done()
+			return pinger.prom.run(ctx, lggr)
+		})
+	}
+	grp.Go(func() error {
+		defer done()
+		go pinger.pingOnUpdate(ctx, endpointSlices.Updated)
+		return k8s.StartCache(ctx, lggr, k8sCache)
+	})
+	grp.Go(func() error {
+		defer done()
+		return startHealthcheckServer(
+			ctx,
+			lggr,
+			cfg.HealthPort,
+			pinger,
+			metricsAPI,
+			registrations,
+			peerState,
+			readyChecks,
+		)
+	})
+	return grp.Wait()
+}
+
+func startGrpcServer(
+	ctx context.Context,
+	lggr logr.Logger,
+	port int,
+	scaler *Scaler,
+	serving *health.Flag,
+	readyChecks map[string]health.Check,
+) error {
+
+	addr := fmt.Sprintf("0.0.0.0:%d", port)
+	lggr.Info("starting grpc server", "address", addr)
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	// the listener is open, so connections are accepted
+	// from here on, even before Serve starts
+	serving.Set()
+	defer serving.Unset()
+	return scaler.Serve(ctx, lis, readyChecks)
+
+}
+
+func startHealthcheckServer(
+	ctx context.Context,
+	lggr logr.Logger,
+	port int,
+	pinger *queuePinger,
+	metricsAPI http.Handler,
+	registrations http.Handler,
+	peerState http.Handler,
+	readyChecks map[string]health.Check,
+) error {
+	lggr = lggr.WithName("startHealthcheckServer")
+
+	mux := http.NewServeMux()
+	health.AddRoutes(lggr, mux, readyChecks)
+	if metricsAPI != nil {
+		mux.Handle(metricsAPIPath, metricsAPI)
+		mux.Handle(metricsAPIPath+"/", metricsAPI)
+	}
+	if registrations != nil {
+		mux.Handle(queue.RegistrationPath, registrations)
+	}
+	if peerState != nil {
+		mux.Handle(peerStatePath, peerState)
+	}
+	mux.HandleFunc("/queue", func(w http.ResponseWriter, r *http.Request) {
+		lggr = lggr.WithName("route.counts")
+		cts := pinger.counts()
+		lggr.Info("counts endpoint", "counts", cts)
+		if err := json.NewEncoder(w).Encode(&cts); err != nil {
+			lggr.Error(err, "writing counts information to client")
+			w.WriteHeader(500)
+		}
+	})
+	mux.HandleFunc("/queue_breakdown", func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewEncoder(w).Encode(pinger.breakdown()); err != nil {
+			lggr.Error(err, "writing counts breakdown to client")
+		}
+	})
+	mux.HandleFunc("/queue_staleness", func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewEncoder(w).Encode(pinger.staleness(time.Now())); err != nil {
+			lggr.Error(err, "writing staleness information to client")
+		}
+	})
+	mux.HandleFunc("/queue_fleets", func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewEncoder(w).Encode(pinger.fleetBreakdown()); err != nil {
+			lggr.Error(err, "writing interceptor fleet counts to client")
+		}
+	})
+	mux.HandleFunc("/queue_endpoints", func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewEncoder(w).Encode(pinger.endpointStatuses()); err != nil {
+			lggr.Error(err, "writing interceptor endpoint statuses to client")
+		}
+	})
+	mux.HandleFunc("/queue_scrapes", func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewEncoder(w).Encode(pinger.scrapes()); err != nil {
+			lggr.Error(err, "writing interceptor scrape stats to client")
+		}
+	})
+	mux.HandleFunc("/metric_names", func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewEncoder(w).Encode(metricNames(pinger.counts())); err != nil {
+			lggr.Error(err, "writing metric names to client")
+		}
+	})
+	mux.HandleFunc("/queue_ping", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		lggr := lggr.WithName("route.counts_ping")
+		if err := pinger.requestCounts(ctx); err != nil {
+			lggr.Error(err, "requesting counts failed")
+			w.WriteHeader(500)
+			w.Write([]byte("error requesting counts from interceptors"))
+			return
+		}
+		cts := pinger.counts()
+		lggr.Info("counts ping endpoint", "counts", cts)
+		if err := json.NewEncoder(w).Encode(&cts); err != nil {
+			lggr.Error(err, "writing counts data to caller")
+			w.WriteHeader(500)
+			w.Write([]byte("error writing counts data to caller"))
+		}
+	})
+
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: mux,
+	}
+	lggr.Info("starting health check server", "port", port)
+
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(ctx)
+	}()
+	return srv.ListenAndServe()
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/health"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestHealthChecks(t *testing.T) {
+	ctx := context.Background()
+	ctx, done := context.WithCancel(ctx)
+	defer done()
+	lggr := logr.Discard()
+	r := require.New(t)
+	const port = 8080
+
+	errgrp, ctx := errgroup.WithContext(ctx)
+
+	ticker, pinger := newFakeQueuePinger(ctx, lggr)
+	defer ticker.Stop()
+	grpcServing := &health.Flag{}
+	srvFunc := func() error {
+		return startHealthcheckServer(
+			ctx,
+			lggr,
+			port,
+			pinger,
+			nil,
+			nil,
+			nil,
+			map[string]health.Check{"grpcServer": grpcServing.Check},
+		)
+	}
+	errgrp.Go(srvFunc)
+	time.Sleep(500 * time.Millisecond)
+
+	res, err := http.Get(fmt.Sprintf("http://0.0.0.0:%d/healthz", port))
+	r.NoError(err)
+	r.Equal(200, res.StatusCode)
+
+	res, err = http.Get(fmt.Sprintf("http://0.0.0.0:%d/livez", port))
+	r.NoError(err)
+	r.Equal(200, res.StatusCode)
+
+	// the scaler isn't ready until the gRPC server is serving
+	res, err = http.Get(fmt.Sprintf("http://0.0.0.0:%d/readyz", port))
+	r.NoError(err)
+	r.Equal(503, res.StatusCode)
+	grpcServing.Set()
+	res, err = http.Get(fmt.Sprintf("http://0.0.0.0:%d/readyz", port))
+	r.NoError(err)
+	r.Equal(200, res.StatusCode)
+}
+
+func TestGRPCHealth(t *testing.T) {
+	ctx, done := context.WithCancel(context.Background())
+	defer done()
+	lggr := logr.Discard()
+	r := require.New(t)
+	const port = 8089
+
+	ticker, pinger := newFakeQueuePinger(ctx, lggr)
+	defer ticker.Stop()
+	table := routing.NewTable()
+	grpcServing := &health.Flag{}
+	readyChecks := map[string]health.Check{
+		"grpcServer":   grpcServing.Check,
+		"routingTable": health.SyncedCheck("the routing table", table.HasSynced),
+	}
+	go startGrpcServer(
+		ctx,
+		lggr,
+		port,
+		&Scaler{pinger: pinger, impl: newImpl(lggr, pinger, table, 123, 200)},
+		grpcServing,
+		readyChecks,
+	)
+
+	conn, err := grpc.DialContext(
+		ctx,
+		fmt.Sprintf("127.0.0.1:%d", port),
+		grpc.WithInsecure(),
+	)
+	r.NoError(err)
+	defer conn.Close()
+	healthCl := healthpb.NewHealthClient(conn)
+	status := func(svc string) healthpb.HealthCheckResponse_ServingStatus {
+		res, err := healthCl.Check(ctx, &healthpb.HealthCheckRequest{Service: svc})
+		if err != nil {
+			return healthpb.HealthCheckResponse_UNKNOWN
+		}
+		return res.Status
+	}
+
+	// the server is alive as soon as it serves, but the external
+	// scaler isn't until the routing table has synced
+	r.Eventually(func() bool {
+		return status("") == healthpb.HealthCheckResponse_SERVING
+	}, 2*time.Second, 50*time.Millisecond)
+	r.Equal(healthpb.HealthCheckResponse_NOT_SERVING, status(externalScalerServiceName))
+
+	table.Replace(routing.NewTable())
+	r.Eventually(func() bool {
+		return status(externalScalerServiceName) == healthpb.HealthCheckResponse_SERVING
+	}, 2*grpcHealthUpdateInterval, 50*time.Millisecond)
+}
@@ -0,0 +1,177 @@
+// Package server is the HTTP Add-on's KEDA external scaler. The scaler
+// binary runs it with Run, and tests can run it in-process with New
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/health"
+	"github.com/kedacore/http-add-on/pkg/k8s"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	externalscaler "github.com/kedacore/http-add-on/proto"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+)
+
+const (
+	// externalScalerServiceName is the name of the gRPC service
+	// that KEDA calls
+	externalScalerServiceName = "externalscaler.ExternalScaler"
+	// grpcHealthUpdateInterval is how often the status that the gRPC
+	// health service reports for the external scaler is updated
+	grpcHealthUpdateInterval = time.Second
+)
+
+// Scaler is the external scaler that KEDA calls. It's the queuePinger
+// that gets the counts from the interceptors, and the gRPC handlers
+// that report them
+type Scaler struct {
+	pinger   *queuePinger
+	impl     *impl
+	grpcOpts []grpc.ServerOption
+}
+
+// New creates the Scaler that cfg describes. It finds the interceptors
+// behind cfg's admin services, and the other scaler replicas behind
+// cfg.PeerService, with getEndpoints, and gets the targets of hosts
+// from table. It pings the interceptors every cfg.QueueTickDuration
+// from then on. Returns an error if cfg is invalid
+func New(
+	ctx context.Context,
+	lggr logr.Logger,
+	cfg *Config,
+	getEndpoints k8s.GetEndpointsFunc,
+	table routing.TableReader,
+) (*Scaler, error) {
+	fallback, err := newFallbackPolicy(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid KEDA_HTTP_SCALER_FALLBACK_POLICY (%w)", err)
+	}
+	partial, err := newPartialPolicy(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid KEDA_HTTP_SCALER_PARTIAL_RESULTS_POLICY (%w)", err)
+	}
+	fleets, err := newInterceptorFleets(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("configuring the interceptor fleets (%w)", err)
+	}
+	grpcOpts, err := grpcServerOptions(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading the TLS files for the gRPC server (%w)", err)
+	}
+	pinger := newQueuePinger(
+		ctx,
+		lggr,
+		getEndpoints,
+		cfg.TargetNamespace,
+		fleets,
+		strconv.Itoa(cfg.TargetPort),
+		fallback,
+		time.NewTicker(cfg.QueueTickDuration),
+	)
+	pinger.maxStaleness = cfg.MetricsMaxStaleness
+	pinger.partial = partial
+	pinger.scrapeTimeout = cfg.ScrapeTimeout
+	pinger.scrapeJitter = cfg.ScrapeJitter
+	if cfg.PrometheusURL != "" {
+		if cfg.PrometheusQuery == "" {
+			return nil, errors.New(
+				"KEDA_HTTP_SCALER_PROMETHEUS_QUERY is not set, so Prometheus can't be queried",
+			)
+		}
+		prom, err := newPromSource(
+			cfg.PrometheusURL,
+			cfg.PrometheusQuery,
+			cfg.PrometheusHostLabel,
+			cfg.PrometheusNamespaceLabel,
+			cfg.TargetNamespace,
+			cfg.PrometheusInterval,
+			cfg.PrometheusTimeout,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("invalid KEDA_HTTP_SCALER_PROMETHEUS_URL (%w)", err)
+		}
+		pinger.prom = prom
+	}
+	if cfg.RegistrationToken != "" {
+		// registered interceptors are scraped by pod IP, so
+		// there's no Service name to verify them against
+		if cfg.tlsEnabled() && cfg.TLSServerName == "" {
+			return nil, errors.New(
+				"KEDA_HTTP_SCALER_TLS_SERVER_NAME is not set, so registered interceptors can't be scraped over TLS",
+			)
+		}
+		adminCl, err := newAdminClient(cfg, "")
+		if err != nil {
+			return nil, fmt.Errorf("loading the TLS files for registered interceptors (%w)", err)
+		}
+		pinger.registry = newInterceptorRegistry(adminCl)
+	}
+
+	scalerImpl := newImpl(
+		lggr,
+		pinger,
+		table,
+		int64(cfg.TargetPendingRequests),
+		int64(cfg.TargetPendingRequestsInterceptor),
+	)
+	if cfg.WaitSLO > 0 {
+		scalerImpl.waitSLO = newWaitSLO(
+			lggr,
+			cfg.WaitSLO,
+			cfg.WaitSLOPercentile,
+			cfg.WaitSLOMaxBoostPercent,
+		)
+	}
+	if cfg.PeerService != "" {
+		peers := newPeerSync(
+			getEndpoints,
+			cfg.TargetNamespace,
+			cfg.PeerService,
+			strconv.Itoa(cfg.HealthPort),
+		)
+		peers.smoother = scalerImpl.smoother
+		pinger.peers = peers
+	}
+	return &Scaler{
+		pinger:   pinger,
+		impl:     scalerImpl,
+		grpcOpts: grpcOpts,
+	}, nil
+}
+
+// Serve serves the gRPC external scaler interface, and the gRPC health
+// service, on lis until ctx is done. The health service reports the
+// external scaler as serving while all of readyChecks pass
+func (s *Scaler) Serve(
+	ctx context.Context,
+	lis net.Listener,
+	readyChecks map[string]health.Check,
+) error {
+	grpcServer := grpc.NewServer(s.grpcOpts...)
+	externalscaler.RegisterExternalScalerServer(grpcServer, s.impl)
+	// the health service lets KEDA and gRPC probes check the
+	// external scaler itself, rather than only its TCP port
+	healthSrv := health.NewGRPCServer(externalScalerServiceName)
+	healthpb.RegisterHealthServer(grpcServer, healthSrv)
+	reflection.Register(grpcServer)
+	go health.UpdateGRPC(
+		ctx,
+		healthSrv,
+		grpcHealthUpdateInterval,
+		readyChecks,
+		externalScalerServiceName,
+	)
+	go func() {
+		<-ctx.Done()
+		lis.Close()
+	}()
+	return grpcServer.Serve(lis)
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/k8s"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	r := require.New(t)
+	ctx, done := context.WithCancel(context.Background())
+	defer done()
+	cfg := &Config{
+		TargetNamespace:       "testns",
+		TargetService:         "interceptor-admin",
+		TargetPort:            9090,
+		TargetPendingRequests: 123,
+		QueueTickDuration:     time.Hour,
+		MetricsMaxStaleness:   time.Second,
+		FallbackPolicy:        fallbackHold,
+		PartialResultsPolicy:  partialDrop,
+		PeerService:           "scaler-peers",
+	}
+	getEndpoints := k8s.GetEndpointsFunc(nil)
+	scaler, err := New(ctx, logr.Discard(), cfg, getEndpoints, routing.NewTable())
+	r.NoError(err)
+	r.Equal("9090", scaler.pinger.adminPort)
+	r.Equal(time.Second, scaler.pinger.maxStaleness)
+	r.Equal(partialDrop, scaler.pinger.partial)
+	r.Equal(fallbackHold, scaler.pinger.fallback.mode)
+	r.Len(scaler.pinger.fleets, 1)
+	r.Equal("interceptor-admin", scaler.pinger.fleets[0].svcName)
+	r.NotNil(scaler.pinger.peers)
+	r.Nil(scaler.pinger.registry)
+	r.Equal(int64(123), scaler.impl.targetMetric)
+
+	// an invalid config is an error, rather than a scaler
+	// that can't work
+	cfg.FallbackPolicy = "bogus"
+	_, err = New(ctx, logr.Discard(), cfg, getEndpoints, routing.NewTable())
+	r.Error(err)
+	cfg.FallbackPolicy = fallbackHold
+	cfg.PrometheusURL = "http://prometheus:9090"
+	_, err = New(ctx, logr.Discard(), cfg, getEndpoints, routing.NewTable())
+	r.Error(err)
+}
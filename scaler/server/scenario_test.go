@@ -0,0 +1,122 @@
+package server_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/queue"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	"github.com/kedacore/http-add-on/pkg/test"
+	externalscaler "github.com/kedacore/http-add-on/proto"
+	"github.com/stretchr/testify/require"
+)
+
+const scenarioAdminSvc = "keda-add-ons-http-interceptor-admin"
+
+// a cold host's pending requests reach KEDA through the scaler's gRPC
+// server, and its workload is only active while it has some
+func TestScenarioScaleFromZero(t *testing.T) {
+	const (
+		ns   = "testns"
+		host = "myapp.TestScenarioScaleFromZero.testing"
+	)
+	r := require.New(t)
+	ctx := context.Background()
+	icpt := test.StartFakeInterceptor(t, ns)
+	icpt.SetPending(host, 0)
+	table := routing.NewTable()
+	r.NoError(table.AddTarget(routing.NamespacedHost(ns, host), routing.Target{
+		TargetPendingRequests: 5,
+	}))
+	addr := test.StartScaler(
+		ctx,
+		t,
+		icpt.ScalerConfig(scenarioAdminSvc),
+		icpt.GetEndpointsFunc(scenarioAdminSvc),
+		table,
+	)
+	cl := test.DialScaler(ctx, t, addr)
+	sor := &externalscaler.ScaledObjectRef{
+		Name:           "myapp",
+		Namespace:      ns,
+		ScalerMetadata: map[string]string{"host": host},
+	}
+
+	spec, err := cl.GetMetricSpec(ctx, sor)
+	r.NoError(err)
+	r.Equal(int64(5), spec.MetricSpecs[0].TargetSize)
+	r.Eventually(func() bool {
+		active, err := cl.IsActive(ctx, sor)
+		return err == nil && !active.Result
+	}, 2*time.Second, 20*time.Millisecond)
+
+	icpt.SetPending(host, 12)
+	r.Eventually(func() bool {
+		active, err := cl.IsActive(ctx, sor)
+		return err == nil && active.Result
+	}, 2*time.Second, 20*time.Millisecond)
+	r.Equal(int64(12), test.Metric(ctx, t, cl, sor))
+
+	icpt.Set(host, queue.HostCounts{Active: 3})
+	r.Eventually(func() bool {
+		res, err := cl.GetMetrics(ctx, &externalscaler.GetMetricsRequest{
+			ScaledObjectRef: sor,
+		})
+		return err == nil && res.MetricValues[0].MetricValue == 3
+	}, 2*time.Second, 20*time.Millisecond)
+}
+
+// the same scenario against an API server, where the scaler finds the
+// targets through the routing table ConfigMap that the operator writes
+func TestScenarioEnvtest(t *testing.T) {
+	const host = "myapp.TestScenarioEnvtest.testing"
+	env := test.StartEnv(t)
+	r := require.New(t)
+	ctx, done := context.WithCancel(context.Background())
+	defer done()
+	ns := env.CreateNamespace(ctx, t)
+
+	icpt := test.StartFakeInterceptor(t, ns)
+	saved := routing.NewTable()
+	r.NoError(saved.AddTarget(routing.NamespacedHost(ns, host), routing.Target{
+		TargetPendingRequests: 7,
+	}))
+	env.SaveRoutingTable(ctx, t, ns, saved)
+
+	table := routing.NewTable()
+	go routing.StartConfigMapRoutingTableInformer(
+		ctx,
+		logr.Discard(),
+		env.Kube,
+		ns,
+		time.Minute,
+		table,
+		queue.NewMemory(),
+	)
+	addr := test.StartScaler(
+		ctx,
+		t,
+		icpt.ScalerConfig(scenarioAdminSvc),
+		icpt.GetEndpointsFunc(scenarioAdminSvc),
+		table,
+	)
+	cl := test.DialScaler(ctx, t, addr)
+	sor := &externalscaler.ScaledObjectRef{
+		Name:           "myapp",
+		Namespace:      ns,
+		ScalerMetadata: map[string]string{"host": host},
+	}
+
+	r.Eventually(func() bool {
+		spec, err := cl.GetMetricSpec(ctx, sor)
+		return err == nil && spec.MetricSpecs[0].TargetSize == 7
+	}, 10*time.Second, 50*time.Millisecond)
+	icpt.SetPending(host, 4)
+	r.Eventually(func() bool {
+		active, err := cl.IsActive(ctx, sor)
+		return err == nil && active.Result
+	}, 10*time.Second, 50*time.Millisecond)
+	r.Equal(int64(4), test.Metric(ctx, t, cl, sor))
+}
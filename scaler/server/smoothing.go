@@ -0,0 +1,80 @@
+package server
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// smoothedAverage is the moving average of a metric, and the time it
+// was last updated
+type smoothedAverage struct {
+	Value   float64   `json:"value"`
+	Updated time.Time `json:"updated"`
+}
+
+// metricSmoother keeps an exponentially weighted moving average of each
+// metric that the scaler reports, so that spiky counts don't make
+// replicas oscillate
+type metricSmoother struct {
+	mut  *sync.Mutex
+	avgs map[string]smoothedAverage
+}
+
+func newMetricSmoother() *metricSmoother {
+	return &metricSmoother{
+		mut:  new(sync.Mutex),
+		avgs: map[string]smoothedAverage{},
+	}
+}
+
+// smooth adds value to the moving average of the metric under key,
+// with factorPercent percent of the weight, and returns the new
+// average, rounded. The first value of a metric is its average. A
+// factorPercent that's not between 1 and 99 turns smoothing off, and
+// returns value as it is
+func (s *metricSmoother) smooth(key string, value int, factorPercent int32) int64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if factorPercent <= 0 || factorPercent >= 100 {
+		// the average would be stale if smoothing
+		// were turned back on later
+		delete(s.avgs, key)
+		return int64(value)
+	}
+	avg, ok := s.avgs[key]
+	if !ok {
+		avg.Value = float64(value)
+	} else {
+		factor := float64(factorPercent) / 100
+		avg.Value = factor*float64(value) + (1-factor)*avg.Value
+	}
+	avg.Updated = time.Now()
+	s.avgs[key] = avg
+	return int64(math.Round(avg.Value))
+}
+
+// averages returns a copy of every moving average in s, keyed by
+// metric
+func (s *metricSmoother) averages() map[string]smoothedAverage {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	ret := make(map[string]smoothedAverage, len(s.avgs))
+	for key, avg := range s.avgs {
+		ret[key] = avg
+	}
+	return ret
+}
+
+// adopt replaces the moving averages in s with the ones in avgs that
+// were updated more recently, like those of another scaler replica
+// that KEDA was calling until it failed over to this one
+func (s *metricSmoother) adopt(avgs map[string]smoothedAverage) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	for key, avg := range avgs {
+		if own, ok := s.avgs[key]; !ok || avg.Updated.After(own.Updated) {
+			s.avgs[key] = avg
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/queue"
+)
+
+// waitSLOAlertInterval is the shortest time between
+// two alerts about the same host's waits
+const waitSLOAlertInterval = time.Minute
+
+// waitSLO watches the time that the requests to each host spend
+// pending, from the wait histograms in the interceptors' counts. When
+// a host's waits are over the SLO, it alerts with a log line, and
+// boosts the host's metric so that its workload scales up sooner
+type waitSLO struct {
+	lggr logr.Logger
+	slo  time.Duration
+	// quantile is the quantile of the
+	// waits that's held to slo
+	quantile float64
+	// maxBoost is the most that a host's
+	// metric is multiplied by
+	maxBoost  float64
+	mut       *sync.Mutex
+	lastAlert map[string]time.Time
+}
+
+// newWaitSLO creates a waitSLO that holds the percentile percentile of
+// each host's waits to slo, and boosts a host's metric by at most
+// maxBoostPercent percent of its value. A maxBoostPercent of 100 or
+// less only alerts
+func newWaitSLO(
+	lggr logr.Logger,
+	slo time.Duration,
+	percentile,
+	maxBoostPercent int,
+) *waitSLO {
+	maxBoost := float64(maxBoostPercent) / 100
+	if maxBoost < 1 {
+		maxBoost = 1
+	}
+	return &waitSLO{
+		lggr:      lggr.WithName("waitSLO"),
+		slo:       slo,
+		quantile:  float64(percentile) / 100,
+		maxBoost:  maxBoost,
+		mut:       new(sync.Mutex),
+		lastAlert: map[string]time.Time{},
+	}
+}
+
+// boost returns the factor that the metric of host, whose recent
+// waits are in waits, is multiplied by. It's the ratio of the waits'
+// quantile to the SLO, up to s.maxBoost, if the quantile is over the
+// SLO, and 1 otherwise. Breaches are logged at most once every
+// waitSLOAlertInterval per host. Returns 1 if s is nil
+func (s *waitSLO) boost(now time.Time, host string, waits *queue.WaitHistogram) float64 {
+	if s == nil {
+		return 1
+	}
+	wait := waits.Quantile(s.quantile)
+	if wait <= s.slo {
+		return 1
+	}
+	ret := math.Min(float64(wait)/float64(s.slo), s.maxBoost)
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if last, ok := s.lastAlert[host]; !ok || now.Sub(last) >= waitSLOAlertInterval {
+		s.lastAlert[host] = now
+		s.lggr.Info(
+			"pending requests are waiting longer than the SLO",
+			"host",
+			host,
+			"percentile",
+			int(s.quantile*100),
+			"wait",
+			wait,
+			"slo",
+			s.slo,
+			"waits",
+			waits.Count,
+			"boost",
+			ret,
+		)
+	}
+	return ret
+}
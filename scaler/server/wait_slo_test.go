@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kedacore/http-add-on/pkg/queue"
+	"github.com/kedacore/http-add-on/pkg/routing"
+	externalscaler "github.com/kedacore/http-add-on/proto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitSLOBoost(t *testing.T) {
+	r := require.New(t)
+	now := time.Now()
+	slo := newWaitSLO(logr.Discard(), time.Second, 95, 300)
+
+	var nilSLO *waitSLO
+	r.Equal(1.0, nilSLO.boost(now, "host", nil))
+
+	// no waits, or waits under the SLO, aren't boosted
+	r.Equal(1.0, slo.boost(now, "host", nil))
+	waits := queue.NewWaitHistogram()
+	waits.Observe(200 * time.Millisecond)
+	r.Equal(1.0, slo.boost(now, "host", waits))
+
+	// waits over the SLO are boosted by their ratio to it
+	waits.Observe(2 * time.Second)
+	r.Equal(2.5, slo.boost(now, "host", waits))
+	r.Equal(now, slo.lastAlert["host"])
+	// up to the max boost
+	waits.Observe(30 * time.Second)
+	waits.Observe(30 * time.Second)
+	r.Equal(3.0, slo.boost(now.Add(time.Second), "host", waits))
+	// and alerts are rate limited
+	r.Equal(now, slo.lastAlert["host"])
+
+	// a max boost of 100% or less only alerts
+	alertOnly := newWaitSLO(logr.Discard(), time.Second, 95, 50)
+	r.Equal(1.0, alertOnly.boost(now, "host", waits))
+	r.Equal(now, alertOnly.lastAlert["host"])
+}
+
+func TestWaitSLOMetric(t *testing.T) {
+	const host = "TestWaitSLOMetric.testing"
+	r := require.New(t)
+	ctx := context.Background()
+	lggr := logr.Discard()
+	ticker, pinger := newFakeQueuePinger(ctx, lggr)
+	defer ticker.Stop()
+	waits := queue.NewWaitHistogram()
+	waits.Observe(4 * time.Second)
+	counts := queue.NewCounts()
+	counts.Counts[host] = 5
+	counts.Hosts[host] = queue.HostCounts{Active: 3, Pending: 2, Waits: waits}
+	pinger.reconcile(
+		time.Now(),
+		map[string]struct{}{"1.2.3.4:8080": {}},
+		[]fetchResult{{addr: "1.2.3.4:8080", counts: counts}},
+	)
+	hdl := newImpl(lggr, pinger, routing.NewTable(), 123, 200)
+	sor := &externalscaler.ScaledObjectRef{
+		ScalerMetadata: map[string]string{"host": host},
+	}
+	res, err := hdl.GetMetrics(ctx, &externalscaler.GetMetricsRequest{ScaledObjectRef: sor})
+	r.NoError(err)
+	r.Equal(int64(5), res.MetricValues[0].MetricValue)
+
+	// the p95 wait is twice the SLO, so the metric is doubled
+	hdl.waitSLO = newWaitSLO(lggr, 2500*time.Millisecond, 95, 200)
+	res, err = hdl.GetMetrics(ctx, &externalscaler.GetMetricsRequest{ScaledObjectRef: sor})
+	r.NoError(err)
+	r.Equal(int64(10), res.MetricValues[0].MetricValue)
+}